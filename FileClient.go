@@ -6,13 +6,21 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tizianocitro/m2cs/internal/caching"
+	"github.com/tizianocitro/m2cs/internal/clock"
 	"github.com/tizianocitro/m2cs/internal/loadbalancing"
+	"github.com/tizianocitro/m2cs/internal/progress"
+	"github.com/tizianocitro/m2cs/internal/replication"
 	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/cache"
 	"github.com/tizianocitro/m2cs/pkg/filestorage"
 )
 
@@ -22,6 +30,62 @@ type FileClient struct {
 	lbStrategy      LoadBalancingStrategy
 	lb              loadbalancing.LoadBalancer
 	cache           *caching.FileCache
+	backend         cache.Backend // Shared cache used in place of the in-memory one, see CacheOptions.Backend
+	cacheRules      []CacheRule   // Per-box overrides of the cache options, see CacheOptions.Rules
+	snapshotPath    string        // Cache snapshot restored by ConfigureCache, see CacheOptions
+	snapshotOnClose bool
+	revalidating    sync.Map // Cache keys being revalidated in background, see CacheOptions.StaleWhileRevalidate
+	softDelete      SoftDeleteOptions
+
+	writeSlots     chan struct{} // Nil when the concurrent writes are not capped
+	inFlightWrites atomic.Int64
+	maxObjectSize  int64 // Maximum size of the written objects, unlimited when 0, see WithMaxObjectSize
+
+	spoolThreshold int64  // Size beyond which the payloads are spooled to temporary files, never when 0, see WithSpoolThreshold
+	copyBufferSize int    // Buffer of the internal copies, the one of io.Copy when 0, see WithCopyBufferSize
+	tempDir        string // Directory of the spooled payloads, os.TempDir() when empty, see WithTempDir
+
+	shadow *shadowReader // Nil when shadow reads are disabled
+
+	keyPrefix      string // Prepended to the keys, see WithKeyPrefix
+	boxPrefix      string // Prepended to the store boxes, see WithBoxPrefix
+	nameValidation NameValidation
+	keyEncoding    KeyEncoding
+
+	storageConcurrency int            // Calls to the storages in flight per operation, all of them when 0
+	throttles          writeThrottles // Writes to the throttled storages, see WriteConcurrencyLimits
+
+	retryPolicies map[OperationType]RetryPolicy // Nil when no operation is retried, see WithRetryPolicy
+	clock         clock.Clock                   // Nil for the system clock, see WithClock
+
+	rolesMu               sync.RWMutex
+	primary               int  // Index of the PRIMARY storage, -1 when there is none, see PromoteToPrimary
+	bestEffortSecondaries bool // Failures of the SECONDARY_MAIN storages are logged, see WithBestEffortSecondaries
+
+	allowDuplicates        bool // Storages given twice are accepted, see WithAllowDuplicates
+	noPassthrough          bool // The operations of single-storage clients take the full path, see WithDirectPassthrough
+	enforceReplicaReadOnly bool // The replicas are set read-only, see WithEnforceReplicaReadOnly
+
+	interceptors    []Interceptor                    // Wrap the operations in registration order, see WithInterceptors
+	requestIDHeader string                           // Header of the request ID, see WithRequestIDHeader
+	requestID       func(ctx context.Context) string // Nil when no request ID is attached
+	audit           *auditLog                        // Nil when the writes and removals are not audited, see WithAudit
+
+	backlog      atomic.Int64           // Background replications in flight, see ReplicationStatus
+	backpressure *backpressure          // Nil when the background replications are not bounded, see WithBackpressure
+	journal      *replication.DiskQueue // Nil when the background replications are not journaled, see WithReplicationJournal
+	lag          replicationLag         // Writes not confirmed yet on each storage, see ReplicationLag
+
+	replicatorOnce     sync.Once
+	engine             *replication.Engine // Runs the background replications, see replicator
+	replicationWorkers int                 // Background replications running at the same time, unbounded when 0, see WithReplicationWorkers
+
+	diagnosticsBox string // Store box of SelfTest, DefaultDiagnosticsBox when empty, see WithDiagnosticsBox
+
+	replicationFilter ReplicationFilter // Nil when every write is replicated, see WithReplicationFilter
+
+	quotaMu sync.RWMutex
+	quotas  map[string]*boxQuota // Budgets of the store boxes, by stored name, see ConfigureQuota
 }
 
 func NewFileClient(replicationMode ReplicationMode, loadBalacingStrategy LoadBalancingStrategy, storages ...filestorage.FileStorage) *FileClient {
@@ -30,92 +94,389 @@ func NewFileClient(replicationMode ReplicationMode, loadBalacingStrategy LoadBal
 		replicationMode: replicationMode,
 		lbStrategy:      loadBalacingStrategy,
 		cache:           nil,
+		primary:         primaryIndex(storages),
+	}
+}
+
+// NewFileClientWithOptions creates a FileClient like NewFileClient, then applies the given options.
+// The storages are validated, failing with ErrInvalidConfiguration on nil storages, on storages
+// given twice, as the same instance or with the same label, unless WithAllowDuplicates is set,
+// and on roles not agreeing with IsMainInstance.
+func NewFileClientWithOptions(replicationMode ReplicationMode, loadBalacingStrategy LoadBalancingStrategy, storages []filestorage.FileStorage, opts ...FileClientOption) (*FileClient, error) {
+	if err := validateNotNil(storages); err != nil {
+		return nil, err
+	}
+	if err := validateRoles(storages); err != nil {
+		return nil, err
+	}
+
+	f := NewFileClient(replicationMode, loadBalacingStrategy, storages...)
+	for _, opt := range opts {
+		if err := opt(f); err != nil {
+			return nil, err
+		}
+	}
+
+	if !f.allowDuplicates {
+		if err := validateUnique(storages); err != nil {
+			return nil, err
+		}
 	}
+	if f.enforceReplicaReadOnly {
+		if err := enforceReadOnly(storages); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
 }
 
 // PutObject uploads an object to all main storages based on the replication mode.
 // In ASYNC_REPLICATION mode, it attempts to write to one main storage and then fans out
 // the write to other main storages in the background.
 // In SYNC_REPLICATION mode, it writes to all main storages and collects errors.
+// With a single storage, the payload may be passed to it as is, see WithDirectPassthrough.
 func (f *FileClient) PutObject(ctx context.Context, storeBox, fileName string, reader io.Reader) error {
+	return f.intercept(ctx, OpInfo{Name: "PutObject", Type: WRITE_OPERATION, StoreBox: storeBox, Key: fileName, Size: writeSize(reader, PutOptions{})}, func(ctx context.Context) error {
+		return f.putPassthrough(ctx, storeBox, fileName, reader)
+	})
+}
+
+// PutObjectWithOptions behaves like PutObject, applying the given options.
+// With an IdempotencyKey, the key is stored with the object and each storage skips the write when
+// it already holds the object written with the same key, so that retrying a write, e.g. after a
+// timeout or a restart while ASYNC_REPLICATION was fanning it out, applies it at most once per
+// storage. Storages skipping the write do not read the payload, so their progress is not reported.
+// The check is not atomic: concurrent writes with the same key may all be applied.
+// With a Retention, the object is locked on every main storage until the retention expires, see
+// SetObjectLegalHold; storages without object lock fail with filestorage.ErrObjectLockUnsupported.
+// With a ChecksumAlgorithm, each storage sends the checksum of the stored bytes, compressed and
+// encrypted ones included, for the provider to verify; storages whose provider rejects the upload
+// or reports a different checksum fail with ErrChecksumMismatch.
+// With a Size, the payload is read into a buffer allocated upfront and the storages saving objects
+// without transforms pass it to their SDK as is; a payload of a different size fails with
+// ErrUnexpectedPayload before any storage is written, as does an empty one with ExpectNonEmpty.
+// The payload is read once, before any storage is written, and every storage is written from the
+// same bytes: a reader already consumed in part by the caller yields the same truncated object on
+// every storage, in both replication modes, never diverging replicas. A regular *os.File, or an
+// io.ReaderAt and io.Seeker reporting its Size, is instead read in place by every storage from
+// its current position, and left at its end, see viewPayload; the file must not be modified
+// until the write completes.
+func (f *FileClient) PutObjectWithOptions(ctx context.Context, storeBox, fileName string, reader io.Reader, opts PutOptions) error {
+	return f.intercept(ctx, OpInfo{Name: "PutObjectWithOptions", Type: WRITE_OPERATION, StoreBox: storeBox, Key: fileName, Size: writeSize(reader, opts)}, func(ctx context.Context) error {
+		return f.putObject(ctx, storeBox, fileName, reader, opts)
+	})
+}
+
+// putObject implements PutObjectWithOptions.
+func (f *FileClient) putObject(ctx context.Context, storeBox, fileName string, reader io.Reader, opts PutOptions) error {
+	return f.writeObject(ctx, storeBox, fileName, reader, opts, nil)
+}
+
+// writeObject implements PutObjectWithOptions, filling report when it is not nil.
+func (f *FileClient) writeObject(ctx context.Context, storeBox, fileName string, reader io.Reader, opts PutOptions, report *PutReport) error {
 	if reader == nil {
 		return fmt.Errorf("reader is nil")
 	}
 
-	buf, err := io.ReadAll(reader)
+	storeBox, fileName, err := f.scope(storeBox, fileName)
 	if err != nil {
-		return fmt.Errorf("failed to read input stream: %w", err)
+		return err
 	}
 
-	var mains []filestorage.FileStorage
-	for _, s := range f.storages {
-		if s.GetConnectionProperties().IsMainInstance {
-			mains = append(mains, s)
+	release, err := f.acquireWrite(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := f.readPayload(reader, opts)
+	if err != nil {
+		release()
+		return err
+	}
+
+	// Every storage reads the same pooled bytes, or spooled file, through its own reader; the
+	// payload is released once all the writes, background ones included, have completed.
+	return f.replicate(ctx, &putRequest{
+		storeBox:  storeBox,
+		fileName:  fileName,
+		newReader: payload.newReader,
+		size:      payload.size,
+		done: func() {
+			payload.release()
+			release()
+		},
+		opts:     opts,
+		slotHeld: true,
+		report:   report,
+	})
+}
+
+// putRequest describes a write to be replicated on the main storages.
+// newReader must return an independent reader positioned at the start of the payload
+// on every call. If done is not nil, it is invoked exactly once when every write,
+// background ones included, has completed.
+type putRequest struct {
+	storeBox  string
+	fileName  string
+	newReader func() io.Reader
+	size      int64
+	done      func()
+	opts      PutOptions
+	slotHeld  bool // The write slot was acquired by the caller and is released by done
+
+	// When parallel is set, storages implementing filestorage.ParallelUploader read the
+	// payload from readerAt in concurrent parts instead of from newReader.
+	readerAt io.ReaderAt
+	parallel *ParallelOptions
+
+	aggregate *progress.Aggregator
+
+	// When report is set, it is filled with the results of the main storages written
+	// before replicate returns.
+	report     *PutReport
+	results    *putResults
+	conditions map[string]string // ETags the storages are written over, by label, see PutOptions.IfMatch
+}
+
+// readerFor returns a fresh reader of the payload for the i-th target storage,
+// wrapped to report progress when requested by the put options.
+func (req *putRequest) readerFor(i int, s filestorage.FileStorage) io.Reader {
+	r := req.newReader()
+	if req.opts.Progress == nil && req.opts.StorageProgress == nil {
+		return r
+	}
+
+	label := storageLabel(s)
+	id := strconv.Itoa(i)
+	return progress.NewReader(r, req.size, func(transferred, total int64) {
+		if req.opts.StorageProgress != nil {
+			req.opts.StorageProgress(label, transferred, total)
+		}
+		if req.aggregate != nil {
+			req.aggregate.Update(id, transferred)
+		}
+	}, progress.Options{Interval: req.opts.ProgressInterval, Bytes: req.opts.ProgressBytes})
+}
+
+// put writes the payload of the request on the i-th target storage.
+func (req *putRequest) put(ctx context.Context, i int, s filestorage.FileStorage) error {
+	opts := filestorage.PutOptions{
+		IdempotencyKey:    req.opts.IdempotencyKey,
+		Retention:         req.opts.Retention,
+		Size:              req.opts.Size,
+		ChecksumAlgorithm: req.opts.ChecksumAlgorithm,
+		ContentType:       req.opts.ContentType,
+	}
+	if req.conditions[storageLabel(s)] != "" {
+		putter, ok := s.(filestorage.ResultPutter)
+		if !ok {
+			return fmt.Errorf("conditional writes are not supported by %s", storageLabel(s))
+		}
+		return req.putWithResult(ctx, i, s, putter, opts)
+	}
+	if req.parallel != nil {
+		if uploader, ok := s.(filestorage.ParallelUploader); ok {
+			return uploader.UploadParallel(ctx, req.storeBox, req.fileName, req.readerAt, req.size, *req.parallel)
+		}
+	}
+	if putter, ok := s.(filestorage.ResultPutter); ok && req.results != nil {
+		return req.putWithResult(ctx, i, s, putter, opts)
+	}
+	if req.opts.IdempotencyKey != "" || req.opts.Retention.Mode != NO_RETENTION || req.opts.Size > 0 || req.opts.ChecksumAlgorithm != NO_CHECKSUM || req.opts.ContentType != "" {
+		putter, ok := s.(filestorage.OptionsPutter)
+		switch {
+		case !ok && req.opts.Retention.Mode != NO_RETENTION:
+			return filestorage.ErrObjectLockUnsupported
+		case !ok && req.opts.IdempotencyKey != "":
+			return fmt.Errorf("idempotency keys are not supported by %s", storageLabel(s))
+		case !ok && req.opts.ChecksumAlgorithm != NO_CHECKSUM:
+			return fmt.Errorf("checksums are not supported by %s", storageLabel(s))
+		case ok:
+			return putter.PutObjectWithOptions(ctx, req.storeBox, req.fileName, req.readerFor(i, s), opts)
+		}
+	}
+	return s.PutObject(ctx, req.storeBox, req.fileName, req.readerFor(i, s))
+}
+
+// finish invokes the done callback of the request, if any.
+func (req *putRequest) finish() {
+	if req.done != nil {
+		req.done()
+	}
+}
+
+// replicate writes the payload of the request to the main storages according to
+// the replication mode.
+func (f *FileClient) replicate(ctx context.Context, req *putRequest) (err error) {
+	storeBox, fileName := req.storeBox, req.fileName
+
+	if err := checkPayload(req.size, req.opts); err != nil {
+		req.finish()
+		return err
+	}
+	if req.conditions, err = f.conditions(req.opts.IfMatch); err != nil {
+		req.finish()
+		return err
+	}
+	if req.report != nil {
+		req.results = &putResults{report: req.report}
+	}
+
+	commitQuota, err := f.reserveQuota(storeBox, fileName, req.size)
+	if err != nil {
+		req.finish()
+		return err
+	}
+	defer func() { commitQuota(err == nil) }()
+
+	var checksum string
+	if f.audit != nil {
+		if err := f.audit.check(); err != nil {
+			req.finish()
+			return err
+		}
+		checksum = payloadChecksum(req)
+	}
+
+	if !req.slotHeld {
+		release, err := f.acquireWrite(ctx)
+		if err != nil {
+			req.finish()
+			return err
 		}
+		done := req.done
+		req.done = func() {
+			if done != nil {
+				done()
+			}
+			release()
+		}
+	}
+
+	mode, err := f.writeMode(ctx)
+	if err != nil {
+		req.finish()
+		return err
 	}
+
+	mains := f.mainStorages()
 	if len(mains) == 0 {
+		req.finish()
 		return errors.New("no main instance found for PutObject operation")
 	}
 
-	switch f.replicationMode {
-	case ASYNC_REPLICATION:
-		var oneSuccess = false
+	// the writes rejected by the replication filter take the first write of ASYNC_REPLICATION,
+	// without the fan-out
+	local := !f.replicates(req)
+	copies := len(mains)
+	if local {
+		mode, copies = ASYNC_REPLICATION, 1
+		if req.report != nil {
+			req.report.Local = true
+		}
+	}
+
+	if req.opts.Progress != nil {
+		req.aggregate = progress.NewAggregator(req.size*int64(copies), req.opts.Progress)
+	}
+
+	// the failures of a storage are retried before it is declared failed
+	put := func(ctx context.Context, i int, s filestorage.FileStorage) error {
+		return f.retry(ctx, WRITE_OPERATION, func() error {
+			return f.throttles.do(ctx, s, func() error {
+				return req.put(ctx, i, s)
+			})
+		})
+	}
+
+	// with a primary, the write is replicated to the other main storages only once the primary has
+	// accepted it, so that they never hold a version the primary does not
+	primary := f.primaryMain()
+	if primary >= 0 {
+		if err := put(ctx, primary, mains[primary]); err != nil {
+			req.finish()
+			if errors.Is(err, ErrPreconditionFailed) {
+				return fmt.Errorf("PutObject failed on %s: %w", storageLabel(mains[primary]), err)
+			}
+			return fmt.Errorf("%w: PutObject failed on %s: %w", ErrPrimaryUnavailable, storageLabel(mains[primary]), err)
+		}
+		f.lag.record(mains[primary], storeBox, fileName, nil)
+	}
 
-		for i, storage := range mains {
-			err := storage.PutObject(ctx, storeBox, fileName, bytes.NewReader(buf))
+	switch mode {
+	case ASYNC_REPLICATION:
+		first := primary
+		for i := 0; first < 0 && i < len(mains); i++ {
+			err := put(ctx, i, mains[i])
 			if err == nil {
-				oneSuccess = true
-				mains = append(mains[:i], mains[i+1:]...)
-				break
+				first = i
+				f.lag.record(mains[i], storeBox, fileName, nil)
+			} else if errors.Is(err, ErrPreconditionFailed) {
+				req.finish()
+				return fmt.Errorf("[async] PutObject failed on %s: %w", storageLabel(mains[i]), err)
 			}
 		}
-		if !oneSuccess {
+		if first < 0 {
+			req.finish()
 			return fmt.Errorf("[async] PutObject failed on all main storages")
 		}
 
-		for _, storage := range mains {
-			s := storage
-			go func() {
-				localCtx := context.Background()
-				if err := s.PutObject(localCtx, storeBox, fileName, bytes.NewReader(buf)); err != nil {
-					log.Printf("[async] PutObject failed on %T: %v", s, err)
-				}
-			}()
+		// fan out to every main storage except the one already written,
+		// keeping the original indexes for progress reporting
+		targets, indexes := followers(mains, first)
+		if local {
+			targets, indexes = nil, nil
 		}
+		f.fanOut(ctx, req, mains[first], targets, indexes)
 
-		if f.cache != nil && f.cache.Enabled() {
-			f.cache.Invalidate(storeBox + "/" + fileName)
-		}
+		f.cacheInvalidate(storeBox, fileName)
+		f.cacheMarkExists(storeBox, fileName, true)
 
+		written := []filestorage.FileStorage{mains[first]}
+		if primary >= 0 && primary != first {
+			written = append(written, mains[primary])
+		}
+		f.auditPut(ctx, req, checksum, written)
+		req.fillReport(written)
 		return nil
 
 	case SYNC_REPLICATION:
-		var wg sync.WaitGroup
-		errCh := make(chan error, len(mains))
-
-		wg.Add(len(mains))
-		for _, storage := range mains {
-			s := storage
-			go func() {
-				defer wg.Done()
-				if err := s.PutObject(ctx, storeBox, fileName, bytes.NewReader(buf)); err != nil {
-					errCh <- fmt.Errorf("[sync] PutObject failed on %T: %w", s, err)
-				}
-			}()
-		}
+		defer req.finish()
 
-		wg.Wait()
-		close(errCh)
+		targets, indexes := followers(mains, primary)
+		results := f.forEachStorage(ctx, targets, func(j int, s filestorage.FileStorage) error {
+			return put(ctx, indexes[j], s)
+		})
 
-		var errs []error
-		for e := range errCh {
-			errs = append(errs, e)
+		var (
+			errs    []error
+			written []filestorage.FileStorage
+		)
+		if primary >= 0 {
+			written = append(written, mains[primary])
+		}
+		for j, err := range results {
+			switch {
+			case err == nil:
+				written = append(written, targets[j])
+			case primary >= 0 && f.bestEffortSecondaries:
+				log.Printf("[sync] PutObject failed on secondary %s: %v", storageLabel(targets[j]), err)
+			default:
+				errs = append(errs, fmt.Errorf("[sync] PutObject failed on %T: %w", targets[j], err))
+			}
+		}
+		// the storages a write failed on lag behind the ones it was written to
+		if len(written) > 0 {
+			for j, err := range results {
+				f.lag.record(targets[j], storeBox, fileName, err)
+			}
 		}
 
 		if len(errs) == 0 {
-			if f.cache != nil && f.cache.Enabled() {
-				f.cache.Invalidate(storeBox + "/" + fileName)
-			}
+			f.cacheInvalidate(storeBox, fileName)
+			f.cacheMarkExists(storeBox, fileName, true)
+			f.auditPut(ctx, req, checksum, written)
+			req.fillReport(written)
 			return nil
 		}
 		if len(errs) == len(mains) {
@@ -124,144 +485,334 @@ func (f *FileClient) PutObject(ctx context.Context, storeBox, fileName string, r
 		return fmt.Errorf("[sync] PutObject partially failed on %d/%d storages: %w", len(errs), len(mains), errors.Join(errs...))
 
 	default:
-		return fmt.Errorf("unsupported replication mode: %v", f.replicationMode)
+		req.finish()
+		return fmt.Errorf("unsupported replication mode: %v", mode)
 	}
 }
 
 // GetObject retrieves an object using the configured load balancing strategy.
+// The object is read in memory: the returned reader also implements io.WriterTo,
+// io.Seeker and io.ReaderAt, so it can be copied efficiently or served with http.ServeContent,
+// unless it is read through the passthrough of a single storage, see WithDirectPassthrough.
 func (f *FileClient) GetObject(ctx context.Context, storeBox, fileName string) (io.ReadCloser, error) {
-	if f.cache != nil && f.cache.Enabled() {
-		data := f.cache.GetFile(storeBox + "/" + fileName)
-		if data != nil {
-			return data, nil
-		}
-	}
+	var obj io.ReadCloser
+	err := f.intercept(ctx, OpInfo{Name: "GetObject", Type: READ_OPERATION, StoreBox: storeBox, Key: fileName, Size: -1}, func(ctx context.Context) (err error) {
+		obj, err = f.getObject(ctx, storeBox, fileName, GetOptions{})
+		return err
+	})
+	return obj, err
+}
 
+// GetObjectWithOptions behaves like GetObject, applying the given options.
+// With a ReadConsistency other than EVENTUAL_CONSISTENCY, the cache is not read, as its copy
+// may be older than the one of the main storages.
+func (f *FileClient) GetObjectWithOptions(ctx context.Context, storeBox, fileName string, opts GetOptions) (io.ReadCloser, error) {
 	var obj io.ReadCloser
-	var mainStorages []filestorage.FileStorage
-	var nonMainStorages []filestorage.FileStorage
+	err := f.intercept(ctx, OpInfo{Name: "GetObjectWithOptions", Type: READ_OPERATION, StoreBox: storeBox, Key: fileName, Size: -1}, func(ctx context.Context) (err error) {
+		obj, err = f.getObject(ctx, storeBox, fileName, opts)
+		return err
+	})
+	return obj, err
+}
 
-	for _, storage := range f.storages {
-		if storage.GetConnectionProperties().IsMainInstance {
-			mainStorages = append(mainStorages, storage)
-		} else {
-			nonMainStorages = append(nonMainStorages, storage)
+// getObject implements GetObjectWithOptions.
+func (f *FileClient) getObject(ctx context.Context, storeBox, fileName string, opts GetOptions) (io.ReadCloser, error) {
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Progress == nil && opts.ReadConsistency == EVENTUAL_CONSISTENCY {
+		if s := f.passthrough(storeBox); s != nil {
+			return f.getDirect(ctx, s, storeBox, fileName)
 		}
 	}
 
-	var groups []loadbalancing.ClientGroup
+	// the cached copy may be older than the one of the main storages
+	var data io.ReadCloser
+	if opts.ReadConsistency == EVENTUAL_CONSISTENCY {
+		data = f.cachedObject(storeBox, fileName)
+	}
+	if data != nil {
+		if opts.Progress != nil {
+			return struct {
+				io.Reader
+				io.Closer
+			}{opts.progressReader(data), data}, nil
+		}
+		return data, nil
+	}
 
-	if len(nonMainStorages) > 0 {
-		groups = append(groups, loadbalancing.ClientGroup{
-			Clients: toLB(nonMainStorages),
-		})
+	lb, err := f.loadBalancer()
+	if err != nil {
+		return nil, err
 	}
-	if len(mainStorages) > 0 {
-		groups = append(groups, loadbalancing.ClientGroup{
-			Clients: toLB(mainStorages),
-		})
+
+	obj, err := f.readObject(ctx, lb, storeBox, fileName, opts.ReadConsistency)
+	if err != nil {
+		return nil, fmt.Errorf("FileClient GetObject error: %w", err)
 	}
 
-	var err error
+	var src io.Reader = obj
+	if opts.Progress != nil {
+		src = opts.progressReader(obj)
+	}
 
-	if f.lb == nil {
-		var strategy loadbalancing.Strategy
-		switch f.lbStrategy {
-		case READ_REPLICA_FIRST:
-			strategy = loadbalancing.CLASSIC
-		case ROUND_ROBIN:
-			strategy = loadbalancing.ROUND_ROBIN
-		default:
-			return nil, fmt.Errorf("unsupported load balancing strategy: %v", f.lbStrategy)
-		}
+	// The object is read into a pooled buffer, grown as needed, and copied once into an
+	// exactly sized slice which is handed to the caller and to the cache; objects beyond the
+	// spool threshold are read into a temporary file instead, and not cached.
+	pooled, err := f.spool(src, -1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object data: %w", err)
+	}
+	if pooled.file != nil {
+		return newSpooledObject(f, pooled), nil
+	}
+	buf := bytes.Clone(pooled.buf.Bytes())
+	pooled.release()
 
-		f.lb, err = loadbalancing.Factory{}.NewLoadBalancer(strategy, groups)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create load balancer: %w", err)
+	if f.shadow != nil {
+		f.shadow.maybeCompare(storeBox, fileName, buf)
+	}
+
+	f.cacheStore(storeBox, fileName, buf)
+
+	return caching.NewReadCloser(buf), nil
+
+}
+
+// GetObjectWithInfo retrieves an object along with its size and attributes, taken from the
+// response of the storage serving the read; see filestorage.InfoGetter for the meaning of the
+// size when the storage compresses or encrypts the objects. Like GetObject, the content is read
+// into a BytesReadCloser or, beyond the spool threshold, into a temporary file, and an unknown
+// ObjectStat.Size is set to the bytes read. The cache is neither read nor filled, so that the
+// attributes are always current.
+// Storages without support are skipped by the load balancer as if they failed.
+func (f *FileClient) GetObjectWithInfo(ctx context.Context, storeBox, fileName string) (io.ReadCloser, ObjectStat, error) {
+	var (
+		obj  io.ReadCloser
+		stat ObjectStat
+	)
+	err := f.intercept(ctx, OpInfo{Name: "GetObjectWithInfo", Type: READ_OPERATION, StoreBox: storeBox, Key: fileName, Size: -1}, func(ctx context.Context) (err error) {
+		obj, stat, err = f.getObjectWithInfo(ctx, storeBox, fileName)
+		return err
+	})
+	return obj, stat, err
+}
+
+// getObjectWithInfo implements GetObjectWithInfo.
+func (f *FileClient) getObjectWithInfo(ctx context.Context, storeBox, fileName string) (io.ReadCloser, ObjectStat, error) {
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return nil, ObjectStat{}, err
+	}
+
+	lb, err := f.loadBalancer()
+	if err != nil {
+		return nil, ObjectStat{}, err
+	}
+
+	type objectWithInfo struct {
+		obj  io.ReadCloser
+		stat ObjectStat
+	}
+
+	res, err := loadbalancing.Execute(ctx, lb, func(client loadbalancing.Client) (objectWithInfo, error) {
+		ig, ok := client.(filestorage.InfoGetter)
+		if !ok {
+			return objectWithInfo{}, filestorage.ErrInfoNotSupported
 		}
+		obj, stat, err := ig.GetObjectWithInfo(ctx, storeBox, fileName)
+		return objectWithInfo{obj: obj, stat: stat}, err
+	})
+	if err != nil {
+		return nil, ObjectStat{}, fmt.Errorf("FileClient GetObjectWithInfo error: %w", err)
+	}
 
+	// read into memory like GetObject, or into a temporary file beyond the spool threshold
+	pooled, err := f.spool(res.obj, res.stat.Size)
+	_ = res.obj.Close()
+	if err != nil {
+		return nil, ObjectStat{}, fmt.Errorf("failed to read object data: %w", err)
+	}
+	if res.stat.Size < 0 {
+		res.stat.Size = pooled.size
 	}
+	if pooled.file != nil {
+		return newSpooledObject(f, pooled), res.stat, nil
+	}
+	buf := bytes.Clone(pooled.buf.Bytes())
+	pooled.release()
+
+	return caching.NewReadCloser(buf), res.stat, nil
+}
+
+// ExistObject reports whether an object exists, asking the storages in the order of the
+// configured load balancing strategy. The answer of the first storage that responds
+// without error is returned; use ExistsObject to look for the object on every storage.
+func (f *FileClient) ExistObject(ctx context.Context, storeBox, fileName string) (bool, error) {
+	var exists bool
+	err := f.intercept(ctx, OpInfo{Name: "ExistObject", Type: EXIST_OPERATION, StoreBox: storeBox, Key: fileName, Size: -1}, func(ctx context.Context) (err error) {
+		exists, err = f.existObject(ctx, storeBox, fileName)
+		return err
+	})
+	return exists, err
+}
 
-	obj, err = f.lb.Apply(ctx, storeBox, fileName)
+// existObject implements ExistObject.
+func (f *FileClient) existObject(ctx context.Context, storeBox, fileName string) (bool, error) {
+	storeBox, fileName, err := f.scope(storeBox, fileName)
 	if err != nil {
-		return nil, fmt.Errorf("FileClient GetObject error: %w", err)
+		return false, err
 	}
 
-	var buf []byte
-	buf, err = io.ReadAll(obj)
+	lb, err := f.balancer(EXIST_OPERATION)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read object data: %w", err)
+		return false, err
+	}
+
+	exists, err := loadbalancing.Execute(ctx, lb, func(client loadbalancing.Client) (bool, error) {
+		return client.ExistObject(ctx, storeBox, fileName)
+	})
+	if err != nil {
+		return false, fmt.Errorf("FileClient ExistObject error: %w", err)
+	}
+
+	return exists, nil
+}
+
+// readGroups splits the storages into load balancing groups: non-main storages
+// first, then main storages, then the storages unable to decrypt the objects they store, e.g.
+// with ConnectionOptions.EncryptOnly, which are read only when all the others fail, see
+// ConnectionProperties.CanDecrypt. Within a group the storages are sorted by label, so that
+// the order, and the rotation of ROUND_ROBIN, do not depend on the order they were given in;
+// storages with the same label keep their relative order. Empty groups are left out: without
+// replicas, the main storages form the first group, which ROUND_ROBIN rotates.
+func readGroups(storages []filestorage.FileStorage) []loadbalancing.ClientGroup {
+	var mainStorages []filestorage.FileStorage
+	var nonMainStorages []filestorage.FileStorage
+	var undecryptable []filestorage.FileStorage
+
+	for _, storage := range storages {
+		properties := storage.GetConnectionProperties()
+		switch {
+		case !properties.CanDecrypt():
+			undecryptable = append(undecryptable, storage)
+		case properties.IsMainInstance:
+			mainStorages = append(mainStorages, storage)
+		default:
+			nonMainStorages = append(nonMainStorages, storage)
+		}
 	}
 
-	if f.cache != nil && f.cache.Enabled() {
-		f.cache.Store(storeBox+"/"+fileName, buf)
+	byLabel := func(storages []filestorage.FileStorage) {
+		sort.SliceStable(storages, func(i, j int) bool {
+			return storageLabel(storages[i]) < storageLabel(storages[j])
+		})
 	}
+	byLabel(nonMainStorages)
+	byLabel(mainStorages)
+	byLabel(undecryptable)
 
-	return io.NopCloser(bytes.NewReader(buf)), nil
+	var groups []loadbalancing.ClientGroup
+	for _, group := range [][]filestorage.FileStorage{nonMainStorages, mainStorages, undecryptable} {
+		if len(group) > 0 {
+			groups = append(groups, loadbalancing.ClientGroup{
+				Clients: toLB(group),
+			})
+		}
+	}
 
+	return groups
+}
+
+// loadBalancer returns the load balancer of the client, building it on first use.
+func (f *FileClient) loadBalancer() (loadbalancing.LoadBalancer, error) {
+	return f.balancer(READ_OPERATION)
 }
 
 // RemoveObject deletes an object from all main storages in parallel.
+// When soft-delete is enabled, each storage first copies the object into its trash box.
 // Errors are collected across storages and aggregated:
 //   - If all storages fail, the function returns a consolidated error.
-//   - If some storages fail, a partial error is returned with details.
+//   - If some storages fail, a *PartialFailureError is returned with the failure of each storage.
 //   - If no errors occur, the function returns nil.
 func (f *FileClient) RemoveObject(ctx context.Context, storeBox string, fileName string) error {
-	var errs []error
-
-	var mainStorages []filestorage.FileStorage
+	return f.intercept(ctx, OpInfo{Name: "RemoveObject", Type: REMOVE_OPERATION, StoreBox: storeBox, Key: fileName, Size: -1}, func(ctx context.Context) error {
+		return f.removeObject(ctx, storeBox, fileName)
+	})
+}
 
-	for _, storage := range f.storages {
-		if storage.GetConnectionProperties().IsMainInstance {
-			mainStorages = append(mainStorages, storage)
+// removeObject implements RemoveObject.
+func (f *FileClient) removeObject(ctx context.Context, storeBox string, fileName string) error {
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return err
+	}
+	if s := f.passthrough(storeBox); s != nil {
+		return f.removeDirect(ctx, s, storeBox, fileName)
+	}
+	if f.audit != nil {
+		if err := f.audit.check(); err != nil {
+			return err
 		}
 	}
 
-	if len(mainStorages) == 0 {
-		return errors.New("no main instance found for RemoveObject operation")
+	err = f.onMainStorages(ctx, "RemoveObject", func(s filestorage.FileStorage) error {
+		return f.retry(ctx, REMOVE_OPERATION, func() error {
+			return f.removeFrom(ctx, s, storeBox, fileName)
+		})
+	})
+	if err != nil {
+		return err
 	}
 
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-
-	for _, storage := range mainStorages {
-		wg.Add(1)
-		go func(s filestorage.FileStorage) {
-			defer wg.Done()
-			if err := s.RemoveObject(ctx, storeBox, fileName); err != nil {
-				mu.Lock()
-				errs = append(errs, fmt.Errorf("RemoveObject failed on storage %T: %w", s, err))
-				mu.Unlock()
-			}
-		}(storage)
+	f.cacheInvalidate(storeBox, fileName)
+	f.cacheMarkExists(storeBox, fileName, false)
+	f.removeQuota(storeBox, fileName)
+	if f.audit != nil {
+		f.audit.record(ctx, AuditRecord{Op: "RemoveObject", StoreBox: storeBox, Key: fileName, Storages: storageLabels(f.mainStorages())})
 	}
+	return nil
+}
 
-	wg.Wait()
+// ExistsObject reports whether an object exists on any storage. With the cache enabled, it answers
+// without calling the storages when the object is cached, or within ExistenceTTL of the last check,
+// put or removal of the object through the client; a check answered by every storage is recorded.
+func (f *FileClient) ExistsObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
+	var exists bool
+	err := f.intercept(ctx, OpInfo{Name: "ExistsObject", Type: EXIST_OPERATION, StoreBox: storeBox, Key: fileName, Size: -1}, func(ctx context.Context) (err error) {
+		exists, err = f.existsObject(ctx, storeBox, fileName)
+		return err
+	})
+	return exists, err
+}
 
-	if len(errs) == 0 {
-		if f.cache != nil && f.cache.Enabled() {
-			f.cache.Invalidate(storeBox + "/" + fileName)
-		}
-		return nil
+// existsObject implements ExistsObject.
+func (f *FileClient) existsObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return false, err
 	}
 
-	if len(errs) == len(mainStorages) {
-		return fmt.Errorf("RemoveObject failed on all main storages: %w", errors.Join(errs...))
+	if exists, known := f.cachedExistence(storeBox, fileName); known {
+		return exists, nil
 	}
 
-	return fmt.Errorf("RemoveObject partially failed on %d/%d storages: %w", len(errs), len(f.storages), errors.Join(errs...))
-}
-
-func (f FileClient) ExistsObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
 	var errs []error
 
 	for _, storage := range f.storages {
-		exists, err := storage.ExistObject(ctx, storeBox, fileName)
+		var exists bool
+		err := f.retry(ctx, EXIST_OPERATION, func() error {
+			var err error
+			exists, err = storage.ExistObject(ctx, storeBox, fileName)
+			return err
+		})
 		if err != nil {
 			errs = append(errs, fmt.Errorf("ExistsObject failed on storage %T: %w", storage, err))
 			continue
 		}
 		if exists {
+			f.cacheMarkExists(storeBox, fileName, true)
 			return true, nil
 		}
 	}
@@ -269,6 +820,9 @@ func (f FileClient) ExistsObject(ctx context.Context, storeBox string, fileName
 	if len(errs) == len(f.storages) {
 		return false, fmt.Errorf("ExistsObject failed on all storages: %w", errors.Join(errs...))
 	}
+	if len(errs) == 0 {
+		f.cacheMarkExists(storeBox, fileName, false)
+	}
 
 	return false, nil
 }
@@ -288,6 +842,27 @@ func (f *FileClient) ConfigureCache(options CacheOptions) error {
 	if options.MaxItems <= 0 {
 		options.MaxItems = 5
 	}
+	if options.MaxItemSizeMB < 0 || options.AdmissionMinHits < 0 || options.AdmissionWindow < 0 {
+		return fmt.Errorf("cache admission options must not be negative")
+	}
+	if options.CompressMinBytes < 0 {
+		return fmt.Errorf("cache CompressMinBytes must not be negative")
+	}
+	if options.StaleWhileRevalidate < 0 || options.MaxStale < 0 {
+		return fmt.Errorf("cache stale windows must not be negative")
+	}
+	if options.MaxStale > 0 && options.MaxStale < options.StaleWhileRevalidate {
+		return fmt.Errorf("MaxStale must not be shorter than StaleWhileRevalidate")
+	}
+	if options.ExistenceTTL < 0 {
+		return fmt.Errorf("cache ExistenceTTL must not be negative")
+	}
+	if options.SnapshotOnClose && options.SnapshotPath == "" {
+		return fmt.Errorf("SnapshotOnClose requires a SnapshotPath")
+	}
+	if err := validateCacheRules(options.Rules); err != nil {
+		return err
+	}
 
 	if f.cache != nil {
 		f.cache.StopValidationRoutine()
@@ -301,8 +876,38 @@ func (f *FileClient) ConfigureCache(options CacheOptions) error {
 			TTL:               options.TTL,
 			MaxItems:          options.MaxItems,
 			ValidationOptions: options.ValidationStrategy,
+
+			MaxItemSizeMB:    options.MaxItemSizeMB,
+			AdmissionMinHits: options.AdmissionMinHits,
+			AdmissionWindow:  options.AdmissionWindow,
+
+			CompressEntries:  options.CompressEntries,
+			CompressMinBytes: options.CompressMinBytes,
+			CopyOnRead:       options.CopyOnRead,
+
+			StaleWhileRevalidate: options.StaleWhileRevalidate,
+			MaxStale:             options.MaxStale,
+
+			SnapshotMaxItemBytes: options.SnapshotMaxItemBytes,
+			SnapshotMaxAge:       options.SnapshotMaxAge,
+
+			ExistenceTTL: options.ExistenceTTL,
+
+			OnValidation: options.OnValidation,
+			Clock:        f.clock,
 		},
 	}
+	f.backend = options.Backend
+	f.cacheRules = append([]CacheRule(nil), options.Rules...)
+	f.snapshotPath = options.SnapshotPath
+	f.snapshotOnClose = options.SnapshotOnClose
+
+	// a missing snapshot is a cold start; a corrupt one is ignored, so that it cannot prevent a restart
+	if f.snapshotPath != "" {
+		if err := f.cache.LoadSnapshot(f.snapshotPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("[cache] ignoring snapshot %s: %v", f.snapshotPath, err)
+		}
+	}
 	if f.cache.Options.Enabled {
 		f.cache.StartValidationRoutine()
 	}
@@ -311,39 +916,152 @@ func (f *FileClient) ConfigureCache(options CacheOptions) error {
 }
 
 // EnableCache marks the cache as enabled and starts the validation routine
-// if a validation strategy is configured.
+// if a validation strategy is configured. Enabling an enabled cache does nothing;
+// an unconfigured cache fails with a CacheStateError matching ErrCacheNotConfigured.
 func (f *FileClient) EnableCache() error {
-	if f.cache == nil {
-		return fmt.Errorf("cache is not configured; configure it before enabling")
-	}
-	if f.cache.Options.Enabled {
+	switch f.CacheState() {
+	case CACHE_UNCONFIGURED:
+		return &CacheStateError{Op: "EnableCache", State: CACHE_UNCONFIGURED}
+	case CACHE_ENABLED:
 		return nil
 	}
 
 	f.cache.Options.Enabled = true
 
 	// Start validation routine if a strategy is set
-	if v := f.cache.Options.ValidationOptions; v != nil && v.Strategy != caching.NO_VALIDATION {
-		_ = f.cache.StartValidationRoutine()
+	_ = f.cache.StartValidationRoutine()
+	return nil
+}
+
+// DisableCache marks the cache as disabled and stops the validation routine, keeping
+// the configuration so that EnableCache can turn it back on. Disabling a disabled cache
+// does nothing; an unconfigured cache fails with a CacheStateError matching ErrCacheNotConfigured.
+func (f *FileClient) DisableCache() error {
+	switch f.CacheState() {
+	case CACHE_UNCONFIGURED:
+		return &CacheStateError{Op: "DisableCache", State: CACHE_UNCONFIGURED}
+	case CACHE_DISABLED:
+		return nil
 	}
+
+	f.cache.StopValidationRoutine()
+	f.cache.Options.Enabled = false
 	return nil
 }
 
-// DisableCache marks the cache as disabled and stops the validation routine.
-// Safe to call multiple times.
-func (f *FileClient) DisableCache() {
+// Close writes the pending audit records, see WithAudit, stops the validation routine of the cache
+// and, with SnapshotOnClose, saves the cache to SnapshotPath, so that a FileClient configured with
+// the same path restores it at startup. The FileClient can still be used after Close.
+func (f *FileClient) Close() error {
+	if f.audit != nil {
+		f.audit.close()
+	}
 	if f.cache == nil {
-		return
+		return nil
 	}
 
 	f.cache.StopValidationRoutine()
-	f.cache.Options.Enabled = false
+	if f.snapshotOnClose {
+		if err := f.cache.SaveSnapshot(f.snapshotPath); err != nil {
+			return fmt.Errorf("FileClient Close error: %w", err)
+		}
+	}
+	return nil
+}
+
+// CacheEntries describes the objects held by the cache and not expired, sorted by key, e.g. to
+// debug the cache in production. The keys are the store box and the key of the objects as
+// stored, i.e. with the prefixes and the encoding of the client, joined by "/".
+// The content of the objects is not reported. It returns nil when the cache is not configured.
+func (f *FileClient) CacheEntries() []CacheEntryInfo {
+	if f.cache == nil {
+		return nil
+	}
+	return f.cache.Entries()
+}
+
+// CacheStats returns the counters of the cache, cumulated since it was configured: the runs of the
+// validation routine and the entries they scanned and expired, and the stats of the last run, see
+// CacheOptions.OnValidation. It returns zero counters when the cache is not configured.
+func (f *FileClient) CacheStats() CacheStats {
+	return f.cache.Stats()
 }
 
+// CacheContains describes the cached copy of an object, reporting false when the object is
+// not cached, its copy has expired or the cache is not configured. The hits are not counted.
+func (f *FileClient) CacheContains(storeBox, fileName string) (CacheEntryInfo, bool) {
+	if f.cache == nil {
+		return CacheEntryInfo{}, false
+	}
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return CacheEntryInfo{}, false
+	}
+	return f.cache.Entry(storeBox + "/" + fileName)
+}
+
+// ClearCache removes every object from the cache, shared cache backend included.
 func (f *FileClient) ClearCache() {
 	if f.cache != nil {
-		f.cache.Clear()
+		_ = f.cache.Clear(context.Background())
+	}
+	if f.backend != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+		defer cancel()
+		if err := f.backend.Clear(ctx); err != nil {
+			log.Printf("[cache] failed to clear the cache backend: %v", err)
+		}
+	}
+}
+
+// onMainStorages runs op concurrently on every main storage, see forEachStorage, and aggregates
+// the failures like RemoveObject: a consolidated error when all storages fail, a *PartialFailureError
+// when only some of them do.
+func (f *FileClient) onMainStorages(ctx context.Context, op string, fn func(filestorage.FileStorage) error) error {
+	mainStorages := f.mainStorages()
+	if len(mainStorages) == 0 {
+		return fmt.Errorf("no main instance found for %s operation", op)
+	}
+
+	errs := f.forEachStorage(ctx, mainStorages, func(_ int, s filestorage.FileStorage) error {
+		return fn(s)
+	})
+
+	var failures []*StorageError
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, &StorageError{Op: op, Label: storageLabel(mainStorages[i]), Err: err})
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	if len(failures) == len(mainStorages) {
+		return fmt.Errorf("%s failed on all main storages: %w", op, joinStorageErrors(failures))
 	}
+
+	return &PartialFailureError{Op: op, Total: len(mainStorages), Failures: failures}
+}
+
+// mainStorages returns the storages configured as main instances.
+func (f *FileClient) mainStorages() []filestorage.FileStorage {
+	var mains []filestorage.FileStorage
+	for _, s := range f.storages {
+		if s.GetConnectionProperties().IsMainInstance {
+			mains = append(mains, s)
+		}
+	}
+	return mains
+}
+
+// storageLabel returns the label of the storage, falling back to its type name.
+func storageLabel(s filestorage.FileStorage) string {
+	if label := s.GetConnectionProperties().Label; label != "" {
+		return label
+	}
+	return fmt.Sprintf("%T", s)
 }
 
 func toLB(storages []filestorage.FileStorage) []loadbalancing.Client {
@@ -364,17 +1082,78 @@ const (
 	ASYNC_REPLICATION
 )
 
+// String returns the name of the replication mode.
+func (m ReplicationMode) String() string {
+	switch m {
+	case SYNC_REPLICATION:
+		return "SYNC_REPLICATION"
+	case ASYNC_REPLICATION:
+		return "ASYNC_REPLICATION"
+	default:
+		return fmt.Sprintf("ReplicationMode(%d)", int(m))
+	}
+}
+
+// ParseReplicationMode returns the replication mode named s, case-insensitively: the name of its
+// constant, as returned by String, or "sync" and "async", e.g. to read it from a configuration.
+func ParseReplicationMode(s string) (ReplicationMode, error) {
+	return common.ParseName(s, "replication mode",
+		[]ReplicationMode{SYNC_REPLICATION, ASYNC_REPLICATION},
+		"sync", "async")
+}
+
+// ParseCompressionAlgorithm returns the compression algorithm named s, case-insensitively: the
+// name of its constant, as returned by String, or "none", "gzip" and "gzip-content-encoding".
+func ParseCompressionAlgorithm(s string) (CompressionAlgorithm, error) {
+	return common.ParseCompressionAlgorithm(s)
+}
+
+// ParseEncryptionAlgorithm returns the encryption algorithm named s, case-insensitively: the
+// name of its constant, as returned by String, or "none" and "aes256".
+func ParseEncryptionAlgorithm(s string) (EncryptionAlgorithm, error) {
+	return common.ParseEncryptionAlgorithm(s)
+}
+
 // Re-export types (type alias)
 type CompressionAlgorithm = common.CompressionAlgorithm
 type EncryptionAlgorithm = common.EncryptionAlgorithm
+type StorageTier = common.StorageTier
+type RetentionMode = common.RetentionMode
+type ChecksumAlgorithm = common.ChecksumAlgorithm
+type StorageRole = common.StorageRole
+type EventType = common.EventType
+type TransformRule = common.TransformRule
 
 // Re-export constants
 const (
-	NO_COMPRESSION   = common.NO_COMPRESSION
-	GZIP_COMPRESSION = common.GZIP_COMPRESSION
+	NO_COMPRESSION        = common.NO_COMPRESSION
+	GZIP_COMPRESSION      = common.GZIP_COMPRESSION
+	GZIP_CONTENT_ENCODING = common.GZIP_CONTENT_ENCODING
 
 	NO_ENCRYPTION     = common.NO_ENCRYPTION
 	AES256_ENCRYPTION = common.AES256_ENCRYPTION
+
+	HOT_TIER     = common.HOT_TIER
+	COOL_TIER    = common.COOL_TIER
+	COLD_TIER    = common.COLD_TIER
+	ARCHIVE_TIER = common.ARCHIVE_TIER
+
+	NO_ROLE        = common.NO_ROLE
+	PRIMARY        = common.PRIMARY
+	SECONDARY_MAIN = common.SECONDARY_MAIN
+	REPLICA        = common.REPLICA
+
+	NO_RETENTION         = common.NO_RETENTION
+	GOVERNANCE_RETENTION = common.GOVERNANCE_RETENTION
+	COMPLIANCE_RETENTION = common.COMPLIANCE_RETENTION
+
+	NO_CHECKSUM     = common.NO_CHECKSUM
+	CRC32C_CHECKSUM = common.CRC32C_CHECKSUM
+	SHA1_CHECKSUM   = common.SHA1_CHECKSUM
+	SHA256_CHECKSUM = common.SHA256_CHECKSUM
+
+	OBJECT_CREATED = common.OBJECT_CREATED
+	OBJECT_REMOVED = common.OBJECT_REMOVED
 )
 
 type LoadBalancingStrategy int
@@ -383,3 +1162,23 @@ const (
 	READ_REPLICA_FIRST LoadBalancingStrategy = iota
 	ROUND_ROBIN
 )
+
+// String returns the name of the load balancing strategy.
+func (l LoadBalancingStrategy) String() string {
+	switch l {
+	case READ_REPLICA_FIRST:
+		return "READ_REPLICA_FIRST"
+	case ROUND_ROBIN:
+		return "ROUND_ROBIN"
+	default:
+		return fmt.Sprintf("LoadBalancingStrategy(%d)", int(l))
+	}
+}
+
+// ParseLoadBalancingStrategy returns the load balancing strategy named s, case-insensitively: the
+// name of its constant, as returned by String, or "read-replica-first" and "round-robin".
+func ParseLoadBalancingStrategy(s string) (LoadBalancingStrategy, error) {
+	return common.ParseName(s, "load balancing strategy",
+		[]LoadBalancingStrategy{READ_REPLICA_FIRST, ROUND_ROBIN},
+		"read-replica-first", "round-robin")
+}
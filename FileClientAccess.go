@@ -0,0 +1,82 @@
+package m2cs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// SetBoxPublicRead grants or revokes anonymous read access to a store box on every main
+// storage, e.g. for static assets. Storages without access management fail with
+// filestorage.ErrAccessNotSupported, so that the returned *PartialFailureError reports
+// which storages applied the change. The access is granted on the whole store box, even
+// when the client is scoped with WithKeyPrefix.
+func (f *FileClient) SetBoxPublicRead(ctx context.Context, storeBox string, public bool) error {
+	storeBox, err := f.scopeBox(storeBox)
+	if err != nil {
+		return err
+	}
+
+	return f.onMainStorages(ctx, "SetBoxPublicRead", func(s filestorage.FileStorage) error {
+		am, ok := s.(filestorage.AccessManager)
+		if !ok {
+			return filestorage.ErrAccessNotSupported
+		}
+		return am.SetBoxPublicRead(ctx, storeBox, public)
+	})
+}
+
+// GetBoxAccess returns the anonymous access granted on a store box by each main storage,
+// keyed by storage label. Failures are reported as in SetBoxPublicRead; when the storages
+// reporting their access disagree, the error wraps ErrBoxAccessDiverged.
+func (f *FileClient) GetBoxAccess(ctx context.Context, storeBox string) (map[string]BoxAccess, error) {
+	storeBox, err := f.scopeBox(storeBox)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	accesses := make(map[string]BoxAccess)
+
+	err = f.onMainStorages(ctx, "GetBoxAccess", func(s filestorage.FileStorage) error {
+		am, ok := s.(filestorage.AccessManager)
+		if !ok {
+			return filestorage.ErrAccessNotSupported
+		}
+
+		access, err := am.GetBoxAccess(ctx, storeBox)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		accesses[storageLabel(s)] = access
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return accesses, err
+	}
+
+	labels := make([]string, 0, len(accesses))
+	for label := range accesses {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels[1:] {
+		if accesses[label] != accesses[labels[0]] {
+			details := make([]string, 0, len(labels))
+			for _, l := range labels {
+				details = append(details, fmt.Sprintf("%s: %+v", l, accesses[l]))
+			}
+			return accesses, fmt.Errorf("%w: %s", ErrBoxAccessDiverged, strings.Join(details, ", "))
+		}
+	}
+
+	return accesses, nil
+}
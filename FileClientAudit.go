@@ -0,0 +1,287 @@
+package m2cs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+const (
+	defaultAuditFlushInterval = 5 * time.Second
+	defaultAuditMaxBatch      = 100
+	defaultAuditMaxPending    = 10000
+	defaultAuditTimeout       = 30 * time.Second
+)
+
+// WithAudit makes the FileClient keep an append-only audit log of its successful writes and
+// removals, e.g. for compliance. After each PutObject, FPutObject, UploadParallel,
+// PutObjectFromURL and RemoveObject that succeeds, an AuditRecord is queued; the queued records
+// are written in the background, every FlushInterval or once MaxBatch of them are pending, as a
+// new JSON Lines object of opts.StoreBox on opts.Storage, so that no record is ever overwritten.
+// The payload of the writes is read once more to compute its checksum.
+// A failure to write a batch is reported to OnError, or logged, and the batch is retried with the
+// next one; it does not fail the operations, unless Strict is set. Close writes the pending records.
+func WithAudit(opts AuditOptions) FileClientOption {
+	return func(f *FileClient) error {
+		if opts.Storage == nil {
+			return fmt.Errorf("audit storage is nil")
+		}
+		if err := ValidateBoxName(opts.StoreBox, f.nameValidation); err != nil {
+			return fmt.Errorf("invalid audit box: %w", err)
+		}
+		if opts.FlushInterval <= 0 {
+			opts.FlushInterval = defaultAuditFlushInterval
+		}
+		if opts.MaxBatch <= 0 {
+			opts.MaxBatch = defaultAuditMaxBatch
+		}
+		if opts.MaxPending <= 0 {
+			opts.MaxPending = defaultAuditMaxPending
+		}
+		if opts.MaxPending < opts.MaxBatch {
+			return fmt.Errorf("audit MaxPending %d is lower than MaxBatch %d", opts.MaxPending, opts.MaxBatch)
+		}
+		if opts.Timeout <= 0 {
+			opts.Timeout = defaultAuditTimeout
+		}
+
+		f.audit = &auditLog{opts: opts, kick: make(chan struct{}, 1)}
+		return nil
+	}
+}
+
+// principalKey is the context key of the principal of an operation.
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx carrying principal, the user or service on whose
+// behalf the operations are made, which is recorded in the audit log, see WithAudit.
+func ContextWithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal set on ctx by ContextWithPrincipal, if any.
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(principalKey{}).(string)
+	return principal, ok
+}
+
+// FlushAudit writes the pending audit records, failing with ErrAuditFailed when they cannot be
+// written; they are then kept for the next attempt. It does nothing without an audit log.
+func (f *FileClient) FlushAudit(ctx context.Context) error {
+	if f.audit == nil {
+		return nil
+	}
+	return f.audit.flush(ctx)
+}
+
+// auditLog queues the records of the audit log and writes them in batches.
+type auditLog struct {
+	opts AuditOptions
+	kick chan struct{} // Wakes the flush routine up when MaxBatch records are pending
+
+	mu      sync.Mutex
+	pending []AuditRecord
+	failing bool          // The last batch could not be written
+	stop    chan struct{} // Nil when the flush routine is not running
+	done    chan struct{}
+
+	flushMu sync.Mutex // Serializes the writes of the batches
+}
+
+// check fails with ErrAuditUnavailable when the audit log is strict and the last batch
+// could not be written.
+func (a *auditLog) check() error {
+	if !a.opts.Strict {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.failing {
+		return fmt.Errorf("%w: the last batch of records could not be written", ErrAuditUnavailable)
+	}
+	return nil
+}
+
+// record queues a record, starting the flush routine if needed. It never blocks on the
+// audit storage.
+func (a *auditLog) record(ctx context.Context, record AuditRecord) {
+	record.Time = time.Now().UTC()
+	record.Principal, _ = PrincipalFromContext(ctx)
+
+	a.mu.Lock()
+	a.pending = append(a.pending, record)
+	dropped := len(a.pending) - a.opts.MaxPending
+	if dropped > 0 {
+		a.pending = append(a.pending[:0], a.pending[dropped:]...)
+	}
+	full := len(a.pending) >= a.opts.MaxBatch
+	if a.stop == nil {
+		a.stop, a.done = make(chan struct{}), make(chan struct{})
+		go a.run(a.stop, a.done)
+	}
+	a.mu.Unlock()
+
+	if dropped > 0 {
+		a.report(fmt.Errorf("%w: %d records dropped, more than %d are pending", ErrAuditFailed, dropped, a.opts.MaxPending))
+	}
+	if full {
+		select {
+		case a.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run writes the pending records every FlushInterval, or when kicked, until stop is closed;
+// then it writes the pending records one last time and closes done.
+func (a *auditLog) run(stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(a.opts.FlushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), a.opts.Timeout)
+		defer cancel()
+		if err := a.flush(ctx); err != nil {
+			a.report(err)
+		}
+	}
+	for {
+		select {
+		case <-ticker.C:
+			flush()
+		case <-a.kick:
+			flush()
+		case <-stop:
+			flush()
+			return
+		}
+	}
+}
+
+// close stops the flush routine, if running, once it has written the pending records.
+func (a *auditLog) close() {
+	a.mu.Lock()
+	stop, done := a.stop, a.done
+	a.stop, a.done = nil, nil
+	a.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// flush writes the pending records as a new object of the audit box, putting them back in
+// front of the queue when the write fails.
+func (a *auditLog) flush(ctx context.Context) error {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+
+	a.mu.Lock()
+	batch := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, record := range batch {
+		if err := enc.Encode(record); err != nil {
+			a.requeue(batch)
+			return fmt.Errorf("%w: %w", ErrAuditFailed, err)
+		}
+	}
+
+	key := auditKey(batch[0].Time)
+	if err := a.opts.Storage.PutObject(ctx, a.opts.StoreBox, key, &buf); err != nil {
+		a.requeue(batch)
+		return fmt.Errorf("%w: %d records to %s/%s on %s: %w", ErrAuditFailed, len(batch), a.opts.StoreBox, key, storageLabel(a.opts.Storage), err)
+	}
+
+	a.mu.Lock()
+	a.failing = false
+	a.mu.Unlock()
+	return nil
+}
+
+// requeue puts a batch that could not be written back in front of the queue, dropping the
+// oldest records beyond MaxPending.
+func (a *auditLog) requeue(batch []AuditRecord) {
+	a.mu.Lock()
+	a.failing = true
+	a.pending = append(batch, a.pending...)
+	dropped := len(a.pending) - a.opts.MaxPending
+	if dropped > 0 {
+		a.pending = a.pending[dropped:]
+	}
+	a.mu.Unlock()
+
+	if dropped > 0 {
+		a.report(fmt.Errorf("%w: %d records dropped, more than %d are pending", ErrAuditFailed, dropped, a.opts.MaxPending))
+	}
+}
+
+// report delivers a failure to the OnError hook or, without a hook, logs it.
+func (a *auditLog) report(err error) {
+	if a.opts.OnError != nil {
+		a.opts.OnError(err)
+		return
+	}
+	log.Printf("[audit] %v", err)
+}
+
+// auditKey returns a new key for a batch whose first record was made at t. The keys are sorted
+// by time and, thanks to their random suffix, unique across the clients sharing the audit box.
+func auditKey(t time.Time) string {
+	var suffix [8]byte
+	_, _ = rand.Read(suffix[:])
+	return t.Format("2006/01/02/20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix[:]) + ".jsonl"
+}
+
+// payloadChecksum returns the hex SHA-256 digest of the payload of a write, or an empty string
+// when it cannot be read.
+func payloadChecksum(req *putRequest) string {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, req.newReader()); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// auditPut records a successful write of the request on the given storages, if audited.
+func (f *FileClient) auditPut(ctx context.Context, req *putRequest, checksum string, written []filestorage.FileStorage) {
+	if f.audit == nil {
+		return
+	}
+	f.audit.record(ctx, AuditRecord{
+		Op:       "PutObject",
+		StoreBox: req.storeBox,
+		Key:      req.fileName,
+		Size:     req.size,
+		Checksum: checksum,
+		Storages: storageLabels(written),
+	})
+}
+
+// storageLabels returns the labels of the given storages.
+func storageLabels(storages []filestorage.FileStorage) []string {
+	labels := make([]string, 0, len(storages))
+	for _, s := range storages {
+		labels = append(labels, storageLabel(s))
+	}
+	return labels
+}
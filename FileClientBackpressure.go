@@ -0,0 +1,134 @@
+package m2cs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicationStatus describes the replication of the writes of a FileClient, see ReplicationStatus.
+type ReplicationStatus struct {
+	Mode      ReplicationMode // Mode of the next writes, SYNC_REPLICATION while ASYNC_REPLICATION is throttled
+	Backlog   int64           // Background replications in flight
+	Queued    int64           // Background replications waiting for a worker, see WithReplicationWorkers, or for an earlier write of their key
+	OldestAge time.Duration   // Age of the oldest background replication in flight, 0 without any
+}
+
+// WithBackpressure bounds the background replications of an ASYNC_REPLICATION client, which
+// could otherwise grow without limits under a sustained load on a slow storage. Once HighWater
+// replications are in flight, the writes are replicated synchronously, like with SYNC_REPLICATION,
+// or wait for the backlog to drain with Block, until at most LowWater are in flight; the writes
+// are then replicated in background again. Without the option, or with SYNC_REPLICATION, the
+// writes are never throttled.
+func WithBackpressure(opts BackpressureOptions) FileClientOption {
+	return func(f *FileClient) error {
+		if opts.HighWater <= 0 {
+			return fmt.Errorf("backpressure HighWater must be positive, got %d", opts.HighWater)
+		}
+		if opts.LowWater == 0 {
+			opts.LowWater = opts.HighWater / 2
+		}
+		if opts.LowWater < 0 || opts.LowWater >= opts.HighWater {
+			return fmt.Errorf("backpressure LowWater must be in [0, %d), got %d", opts.HighWater, opts.LowWater)
+		}
+		f.backpressure = &backpressure{opts: opts}
+		return nil
+	}
+}
+
+// ReplicationStatus returns the current replication mode of the writes and the depth and age of
+// the queue of the background replications, e.g. to be exported as metrics. The replays of
+// RecoverPendingReplications count in Queued and OldestAge, not in Backlog.
+func (f *FileClient) ReplicationStatus() ReplicationStatus {
+	stats := f.replicator().Stats()
+	status := ReplicationStatus{
+		Mode:      f.replicationMode,
+		Backlog:   f.backlog.Load(),
+		Queued:    int64(stats.Pending - stats.Running),
+		OldestAge: stats.OldestAge,
+	}
+	if f.replicationMode == ASYNC_REPLICATION && f.backpressure != nil && f.backpressure.throttled.Load() {
+		status.Mode = SYNC_REPLICATION
+	}
+	return status
+}
+
+// writeMode returns the mode of a write, waiting for the backlog to drain when the writes
+// are throttled with Block.
+func (f *FileClient) writeMode(ctx context.Context) (ReplicationMode, error) {
+	if f.replicationMode != ASYNC_REPLICATION || f.backpressure == nil {
+		return f.replicationMode, nil
+	}
+	return f.backpressure.mode(ctx)
+}
+
+// startBackground accounts for a background replication, returning the function to call once it
+// has completed.
+func (f *FileClient) startBackground() func() {
+	n := f.backlog.Add(1)
+	if f.backpressure != nil {
+		f.backpressure.update(n)
+	}
+	return func() {
+		n := f.backlog.Add(-1)
+		if f.backpressure != nil {
+			f.backpressure.update(n)
+		}
+	}
+}
+
+// backpressure throttles the writes of an ASYNC_REPLICATION client, with hysteresis, from the
+// number of background replications in flight.
+type backpressure struct {
+	opts BackpressureOptions
+
+	mu        sync.Mutex
+	throttled atomic.Bool   // Written under mu
+	drained   chan struct{} // Closed when the throttling stops
+}
+
+// update switches the throttling on or off from n, the background replications in flight.
+func (b *backpressure) update(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch throttled := b.throttled.Load(); {
+	case !throttled && n >= int64(b.opts.HighWater):
+		b.throttled.Store(true)
+		b.drained = make(chan struct{})
+		b.notify(SYNC_REPLICATION)
+	case throttled && n <= int64(b.opts.LowWater):
+		b.throttled.Store(false)
+		close(b.drained)
+		b.notify(ASYNC_REPLICATION)
+	}
+}
+
+// notify reports a change of mode to the OnModeChange hook, if any.
+func (b *backpressure) notify(mode ReplicationMode) {
+	if b.opts.OnModeChange != nil {
+		b.opts.OnModeChange(mode)
+	}
+}
+
+// mode returns the mode of a write, waiting for the throttling to stop with Block.
+func (b *backpressure) mode(ctx context.Context) (ReplicationMode, error) {
+	b.mu.Lock()
+	throttled, drained := b.throttled.Load(), b.drained
+	b.mu.Unlock()
+
+	switch {
+	case !throttled:
+		return ASYNC_REPLICATION, nil
+	case !b.opts.Block:
+		return SYNC_REPLICATION, nil
+	}
+	select {
+	case <-drained:
+		return ASYNC_REPLICATION, nil
+	case <-ctx.Done():
+		return ASYNC_REPLICATION, fmt.Errorf("waiting for the replication backlog to drain: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,258 @@
+package m2cs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/internal/bufpool"
+)
+
+// spoolPattern is the name pattern of the temporary files of the spooled payloads.
+const spoolPattern = "m2cs-spool-*"
+
+// WithSpoolThreshold spills the payloads larger than bytes to temporary files in the directory
+// set by WithTempDir, instead of holding them in memory, e.g. on devices with little memory.
+// PutObject holds at most bytes of the payload in memory before spilling it to a file, from
+// which every storage is written, and removes the file once every write, background ones
+// included, has completed or failed. GetObject downloads the larger objects to a file, removed
+// when the returned reader is closed; they are neither cached nor compared by the shadow reads.
+// By default the payloads are held in memory whatever their size.
+func WithSpoolThreshold(bytes int64) FileClientOption {
+	return func(f *FileClient) error {
+		if bytes <= 0 {
+			return fmt.Errorf("spool threshold must be positive, got %d", bytes)
+		}
+		f.spoolThreshold = bytes
+		return nil
+	}
+}
+
+// WithCopyBufferSize sets the size of the buffer of the internal copies: the spilling of the
+// payloads to temporary files, the downloads of FGetObject and DownloadParallel and the reads of
+// the spooled objects. By default the copies use the 32 KB buffer of io.Copy, or none when the
+// source or the destination can copy by themselves.
+func WithCopyBufferSize(bytes int) FileClientOption {
+	return func(f *FileClient) error {
+		if bytes <= 0 {
+			return fmt.Errorf("copy buffer size must be positive, got %d", bytes)
+		}
+		f.copyBufferSize = bytes
+		return nil
+	}
+}
+
+// WithTempDir sets the directory of the temporary files of the spooled payloads, see
+// WithSpoolThreshold. The directory must exist. By default it is os.TempDir().
+func WithTempDir(path string) FileClientOption {
+	return func(f *FileClient) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("invalid temp dir: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("invalid temp dir: %s is not a directory", path)
+		}
+		f.tempDir = path
+		return nil
+	}
+}
+
+// payload is the content of a write or of a read, held in a pooled buffer, spooled to a
+// temporary file or, for a write, read in place from the reader of the caller.
+type payload struct {
+	buf  *bytes.Buffer // Nil when spooled or viewed
+	file *os.File      // Nil when held in memory or viewed
+	size int64
+
+	// When at is set, the payload is the section of at starting at offset, see viewPayload.
+	at     io.ReaderAt
+	offset int64
+	closer io.Closer // Closed on release, when not nil
+}
+
+// newReader returns an independent reader positioned at the start of the payload.
+func (p *payload) newReader() io.Reader {
+	switch {
+	case p.at != nil:
+		return io.NewSectionReader(p.at, p.offset, p.size)
+	case p.file != nil:
+		return io.NewSectionReader(p.file, 0, p.size)
+	}
+	return bytes.NewReader(p.buf.Bytes())
+}
+
+// release returns the buffer to the pool, or removes the file, once nothing reads the payload anymore.
+func (p *payload) release() {
+	switch {
+	case p.at != nil:
+		if p.closer != nil {
+			_ = p.closer.Close()
+		}
+	case p.file != nil:
+		removeSpool(p.file)
+	default:
+		bufpool.Default.Put(p.buf)
+	}
+}
+
+// sizedReaderAt is a reader whose content can be read in place, such as *bytes.Reader,
+// *strings.Reader and *io.SectionReader.
+type sizedReaderAt interface {
+	io.ReaderAt
+	io.Seeker
+	Size() int64
+}
+
+// viewPayload returns the payload of a write from a regular *os.File, or from a sizedReaderAt,
+// as the section between its current position and its end, which every storage reads through
+// its own io.SectionReader instead of from a copy. It fails like readPayload, before reading
+// anything, when the section is larger than limit or of a size other than opts.Size. Otherwise
+// the position of reader is moved to its end, as if it had been read; the reads of the sections
+// do not depend on it, so concurrent ones share the file descriptor. It returns nil when reader
+// must be buffered.
+// The writes of ASYNC_REPLICATION outlive the call, so the file is reopened for them, and the
+// other readers, which the caller may reuse, are buffered.
+func (f *FileClient) viewPayload(reader io.Reader, limit int64, opts PutOptions) (*payload, error) {
+	var (
+		at     io.ReaderAt
+		end    int64
+		closer io.Closer
+	)
+	switch r := reader.(type) {
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return nil, nil
+		}
+		at, end = r, info.Size()
+		if f.replicationMode == ASYNC_REPLICATION {
+			file, ok := reopen(r, info)
+			if !ok {
+				return nil, nil
+			}
+			at, closer = file, file
+		}
+	case sizedReaderAt:
+		if f.replicationMode == ASYNC_REPLICATION {
+			return nil, nil
+		}
+		at, end = r, r.Size()
+	default:
+		return nil, nil
+	}
+
+	p := &payload{at: at, closer: closer}
+	seeker := reader.(io.Seeker)
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		p.release()
+		return nil, fmt.Errorf("failed to read input stream: %w", err)
+	}
+	p.offset, p.size = offset, max(end-offset, 0)
+
+	if err := checkSize(p.size, limit); err != nil {
+		p.release()
+		return nil, err
+	}
+	if opts.Size > 0 && p.size != opts.Size {
+		p.release()
+		return nil, fmt.Errorf("failed to read input stream: %w: read %d bytes, PutOptions.Size is %d", ErrUnexpectedPayload, p.size, opts.Size)
+	}
+	if _, err := seeker.Seek(end, io.SeekStart); err != nil {
+		p.release()
+		return nil, fmt.Errorf("failed to read input stream: %w", err)
+	}
+	return p, nil
+}
+
+// reopen opens file again, e.g. for the reads to outlive the descriptor of the caller. It fails
+// when the path of file no longer leads to it.
+func reopen(file *os.File, info os.FileInfo) (*os.File, bool) {
+	reopened, err := os.Open(file.Name())
+	if err != nil {
+		return nil, false
+	}
+	if same, err := reopened.Stat(); err != nil || !os.SameFile(info, same) {
+		_ = reopened.Close()
+		return nil, false
+	}
+	return reopened, true
+}
+
+// spool reads r until EOF into a pooled buffer or, beyond the spool threshold, into a temporary
+// file. size, when > 0, is the expected size of the content: the buffer is grown upfront to it,
+// and content larger than the threshold is written to the file without being buffered first.
+func (f *FileClient) spool(r io.Reader, size int64) (*payload, error) {
+	if f.spoolThreshold <= 0 {
+		buf, err := bufpool.Default.ReadAllSize(r, size)
+		if err != nil {
+			return nil, err
+		}
+		return &payload{buf: buf, size: int64(buf.Len())}, nil
+	}
+
+	if size <= f.spoolThreshold {
+		buf, err := bufpool.Default.ReadAllSize(io.LimitReader(r, f.spoolThreshold+1), size)
+		if err != nil {
+			return nil, err
+		}
+		if int64(buf.Len()) <= f.spoolThreshold {
+			return &payload{buf: buf, size: int64(buf.Len())}, nil
+		}
+		defer bufpool.Default.Put(buf)
+		r = io.MultiReader(bytes.NewReader(buf.Bytes()), r)
+	}
+
+	file, err := os.CreateTemp(f.tempDir, spoolPattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	n, err := f.copy(file, r)
+	if err != nil {
+		removeSpool(file)
+		return nil, err
+	}
+	return &payload{file: file, size: n}, nil
+}
+
+// removeSpool closes and removes a temporary file of a spooled payload.
+func removeSpool(file *os.File) {
+	_ = file.Close()
+	_ = os.Remove(file.Name())
+}
+
+// copy copies src to dst like io.Copy, through a buffer of the size set by WithCopyBufferSize.
+func (f *FileClient) copy(dst io.Writer, src io.Reader) (int64, error) {
+	if f.copyBufferSize <= 0 {
+		return io.Copy(dst, src)
+	}
+	// io.WriterTo and io.ReaderFrom are hidden, since they would bypass the buffer
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, f.copyBufferSize))
+}
+
+// spooledObject is an object read by GetObject spooled to a temporary file, removed on Close.
+// Like the objects read in memory, it implements io.WriterTo, io.Seeker and io.ReaderAt.
+type spooledObject struct {
+	*io.SectionReader
+	client *FileClient
+	file   *os.File
+	once   sync.Once
+}
+
+func newSpooledObject(f *FileClient, p *payload) *spooledObject {
+	return &spooledObject{SectionReader: io.NewSectionReader(p.file, 0, p.size), client: f, file: p.file}
+}
+
+// WriteTo writes the rest of the object to w.
+func (o *spooledObject) WriteTo(w io.Writer) (int64, error) {
+	return o.client.copy(w, o.SectionReader)
+}
+
+// Close removes the temporary file.
+func (o *spooledObject) Close() error {
+	o.once.Do(func() { removeSpool(o.file) })
+	return nil
+}
@@ -0,0 +1,95 @@
+package m2cs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"github.com/tizianocitro/m2cs/internal/caching"
+	"github.com/tizianocitro/m2cs/pkg/cache"
+)
+
+// CacheBackend is a cache shared by several FileClient instances, e.g. the Redis adapter of
+// pkg/cache/redis, see CacheOptions.Backend.
+type CacheBackend = cache.Backend
+
+// backendTimeout bounds the calls to a cache backend, so that a slow backend cannot stall the
+// operations it caches for.
+const backendTimeout = 5 * time.Second
+
+// cacheStore caches the content of an object, when the cache is enabled for its store box, with the
+// TTL and the size limit of the box. With a backend, a failure is logged: the object is just not cached.
+func (f *FileClient) cacheStore(storeBox, fileName string, data []byte) {
+	policy, enabled := f.cachePolicy(storeBox)
+	if !enabled || int64(len(data)) > policy.maxItemBytes {
+		return
+	}
+	if f.backend == nil {
+		// with CopyOnRead the cache holds its own copy, so that the caller may modify data
+		if f.cache.Options.CopyOnRead {
+			data = bytes.Clone(data)
+		}
+		f.cache.StoreWithTTL(storeBox+"/"+fileName, data, policy.ttl)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	if err := f.backend.Set(ctx, storeBox+"/"+fileName, data, policy.ttl); err != nil {
+		log.Printf("[cache] failed to store %s/%s in the cache backend: %v", storeBox, fileName, err)
+	}
+}
+
+// cacheInvalidate removes an object, and its existence marker, from the cache, when the cache is enabled
+// for its store box, so that the other clients sharing the backend read it from the storages as well.
+// With a backend, a failure is logged: the other clients may read the stale copy until it expires.
+func (f *FileClient) cacheInvalidate(storeBox, fileName string) {
+	if _, enabled := f.cachePolicy(storeBox); !enabled {
+		return
+	}
+	f.cache.Invalidate(storeBox + "/" + fileName)
+	if f.backend == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	if err := f.backend.Delete(ctx, storeBox+"/"+fileName); err != nil {
+		log.Printf("[cache] failed to invalidate %s/%s in the cache backend: %v", storeBox, fileName, err)
+	}
+}
+
+// cacheMarkExists records whether an object exists, when the cache is enabled for its store box,
+// so that ExistsObject answers without calling the storages for ExistenceTTL.
+func (f *FileClient) cacheMarkExists(storeBox, fileName string, exists bool) {
+	if _, enabled := f.cachePolicy(storeBox); enabled {
+		f.cache.MarkExists(storeBox+"/"+fileName, exists)
+	}
+}
+
+// cachedExistence reports whether an object exists, as known to the cache, see FileCache.Exists.
+func (f *FileClient) cachedExistence(storeBox, fileName string) (exists bool, known bool) {
+	if _, enabled := f.cachePolicy(storeBox); !enabled {
+		return false, false
+	}
+	return f.cache.Exists(storeBox + "/" + fileName)
+}
+
+// backendObject returns the copy of an object held by the cache backend, or nil when it is
+// missing or the backend fails, in which case the object is read from the storages.
+func (f *FileClient) backendObject(storeBox, fileName string) io.ReadCloser {
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+
+	data, found, err := f.backend.Get(ctx, storeBox+"/"+fileName)
+	if err != nil {
+		log.Printf("[cache] failed to read %s/%s from the cache backend: %v", storeBox, fileName, err)
+		return nil
+	}
+	if !found {
+		return nil
+	}
+	return caching.NewReadCloser(data)
+}
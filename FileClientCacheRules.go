@@ -0,0 +1,61 @@
+package m2cs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// matchAllBoxes is the pattern of a CacheRule matching every store box.
+const matchAllBoxes = "*"
+
+// cachePolicy is the cache configuration applied to the objects of a store box.
+type cachePolicy struct {
+	ttl          time.Duration
+	maxItemBytes int64
+}
+
+// cachePolicy returns the cache configuration of a store box, as stored, reporting false when the
+// cache is not enabled or a CacheRule disables it for the box.
+func (f *FileClient) cachePolicy(storeBox string) (cachePolicy, bool) {
+	if !f.cache.Enabled() {
+		return cachePolicy{}, false
+	}
+
+	policy := cachePolicy{ttl: f.cache.Options.TTL, maxItemBytes: f.cache.MaxItemBytes()}
+	box := strings.TrimPrefix(storeBox, f.boxPrefix)
+	for _, rule := range f.cacheRules {
+		if matched, _ := path.Match(rule.BoxPattern, box); !matched {
+			continue
+		}
+		if !rule.Enabled {
+			return cachePolicy{}, false
+		}
+		if rule.TTL > 0 {
+			policy.ttl = rule.TTL
+		}
+		if rule.MaxItemSizeMB > 0 {
+			policy.maxItemBytes = min(policy.maxItemBytes, rule.MaxItemSizeMB*1024*1024)
+		}
+		break
+	}
+	return policy, true
+}
+
+// validateCacheRules checks the patterns and the limits of the rules, and that no rule follows
+// a rule matching every store box, as it would never apply.
+func validateCacheRules(rules []CacheRule) error {
+	for i, rule := range rules {
+		if _, err := path.Match(rule.BoxPattern, ""); err != nil || rule.BoxPattern == "" {
+			return fmt.Errorf("cache rule %d: invalid box pattern %q", i, rule.BoxPattern)
+		}
+		if rule.TTL < 0 || rule.MaxItemSizeMB < 0 {
+			return fmt.Errorf("cache rule %d: TTL and MaxItemSizeMB must not be negative", i)
+		}
+		if rule.BoxPattern == matchAllBoxes && i < len(rules)-1 {
+			return fmt.Errorf("cache rule %d matches every box: the %d rules after it would never apply", i, len(rules)-1-i)
+		}
+	}
+	return nil
+}
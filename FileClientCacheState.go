@@ -0,0 +1,76 @@
+package m2cs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CacheState is the state of the cache of a FileClient. ConfigureCache moves an unconfigured cache
+// to CACHE_ENABLED or CACHE_DISABLED, based on CacheOptions.Enabled, and EnableCache and DisableCache
+// move a configured cache between the two. A disabled cache keeps its configuration and is neither
+// read nor filled.
+type CacheState int
+
+const (
+	CACHE_UNCONFIGURED CacheState = iota
+	CACHE_DISABLED
+	CACHE_ENABLED
+)
+
+// String returns the name of the cache state.
+func (s CacheState) String() string {
+	switch s {
+	case CACHE_UNCONFIGURED:
+		return "CACHE_UNCONFIGURED"
+	case CACHE_DISABLED:
+		return "CACHE_DISABLED"
+	case CACHE_ENABLED:
+		return "CACHE_ENABLED"
+	default:
+		return fmt.Sprintf("CacheState(%d)", int(s))
+	}
+}
+
+// ErrCacheNotConfigured matches the CacheStateError of the operations requiring ConfigureCache to be called first.
+var ErrCacheNotConfigured = errors.New("cache is not configured")
+
+// ErrCacheNotEnabled matches the CacheStateError of the operations requiring the cache to be enabled.
+var ErrCacheNotEnabled = errors.New("cache is not enabled")
+
+// CacheStateError is returned by the cache operations which are invalid in the current state of the cache.
+type CacheStateError struct {
+	Op    string
+	State CacheState
+}
+
+func (e *CacheStateError) Error() string {
+	if e.State == CACHE_UNCONFIGURED {
+		return fmt.Sprintf("%s: cache is not configured; configure it first", e.Op)
+	}
+	return fmt.Sprintf("%s: invalid in state %v", e.Op, e.State)
+}
+
+// Is reports whether target is ErrCacheNotConfigured for an unconfigured cache, or
+// ErrCacheNotEnabled for a cache which is not enabled.
+func (e *CacheStateError) Is(target error) bool {
+	switch target {
+	case ErrCacheNotConfigured:
+		return e.State == CACHE_UNCONFIGURED
+	case ErrCacheNotEnabled:
+		return e.State != CACHE_ENABLED
+	default:
+		return false
+	}
+}
+
+// CacheState returns the state of the cache.
+func (f *FileClient) CacheState() CacheState {
+	switch {
+	case f.cache == nil:
+		return CACHE_UNCONFIGURED
+	case f.cache.Enabled():
+		return CACHE_ENABLED
+	default:
+		return CACHE_DISABLED
+	}
+}
@@ -0,0 +1,62 @@
+package m2cs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// CleanupIncompleteUploads aborts the multipart uploads of a store box initiated more than
+// olderThan ago on every main storage supporting them, MinIO and AWS S3, and returns the number
+// of uploads aborted on each of them, keyed by storage label. The other storages, such as Azure
+// where uncommitted blocks expire on their own, are skipped and missing from the result.
+// With WithKeyPrefix, only the uploads within the namespace of the client are aborted, on the
+// storages implementing filestorage.PrefixUploadCleaner; the other ones are skipped.
+// Storages failing to abort some uploads report the uploads they aborted, and their errors are
+// returned as a *PartialFailureError, or as a single error when every storage failed.
+func (f *FileClient) CleanupIncompleteUploads(ctx context.Context, storeBox string, olderThan time.Duration) (map[string]int, error) {
+	storeBox, err := f.scopeBox(storeBox)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	counts := make(map[string]int)
+
+	err = f.onMainStorages(ctx, "CleanupIncompleteUploads", func(s filestorage.FileStorage) error {
+		abort := f.uploadAborter(s)
+		if abort == nil {
+			return nil
+		}
+
+		aborted, err := abort(ctx, storeBox, olderThan)
+		if aborted > 0 || err == nil {
+			mu.Lock()
+			counts[storageLabel(s)] = aborted
+			mu.Unlock()
+		}
+		return err
+	})
+
+	return counts, err
+}
+
+// uploadAborter returns the function aborting the incomplete uploads of s within the namespace of
+// the client, or nil when s cannot restrict the aborts to it.
+func (f *FileClient) uploadAborter(s filestorage.FileStorage) func(ctx context.Context, storeBox string, olderThan time.Duration) (int, error) {
+	if f.keyPrefix == "" {
+		if cleaner, ok := s.(filestorage.UploadCleaner); ok {
+			return cleaner.AbortIncompleteUploads
+		}
+		return nil
+	}
+
+	if cleaner, ok := s.(filestorage.PrefixUploadCleaner); ok {
+		return func(ctx context.Context, storeBox string, olderThan time.Duration) (int, error) {
+			return cleaner.AbortIncompleteUploadsWithPrefix(ctx, storeBox, f.keyPrefix, olderThan)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package m2cs
+
+import (
+	"fmt"
+
+	"github.com/tizianocitro/m2cs/internal/clock"
+)
+
+// Clock tells the time and waits for it to pass, see WithClock. The tests drive the time of a
+// client with storagetest.FakeClock, which implements it.
+type Clock = clock.Clock
+
+// ClockTicker delivers the ticks of a Clock, like time.Ticker.
+type ClockTicker = clock.Ticker
+
+// WithClock makes the client tell and wait for the time with c instead of the system clock: the
+// delays between the attempts of WithRetryPolicy, the creation times of the entries of
+// WithReplicationJournal and, for the caches configured by ConfigureCache, the age of the items
+// and the ticks of the validation routine follow c.
+func WithClock(c Clock) FileClientOption {
+	return func(f *FileClient) error {
+		if c == nil {
+			return fmt.Errorf("clock is nil")
+		}
+		f.clock = c
+		return nil
+	}
+}
@@ -0,0 +1,94 @@
+package m2cs
+
+import (
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Codec serializes the values written by PutEncoded and read by GetEncoded.
+type Codec interface {
+	// ContentType is the content type stored with the encoded objects.
+	ContentType() string
+	// Encode writes the encoding of v to w.
+	Encode(w io.Writer, v any) error
+	// Decode reads an encoded value from r into out, which must be a pointer.
+	Decode(r io.Reader, out any) error
+}
+
+var (
+	// JSONCodec encodes values as JSON documents, with encoding/json.
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec encodes values as gob streams, with encoding/gob.
+	GobCodec Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Encode(w io.Writer, v any) error { return json.NewEncoder(w).Encode(v) }
+
+func (jsonCodec) Decode(r io.Reader, out any) error { return json.NewDecoder(r).Decode(out) }
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string { return "application/x-gob" }
+
+func (gobCodec) Encode(w io.Writer, v any) error { return gob.NewEncoder(w).Encode(v) }
+
+func (gobCodec) Decode(r io.Reader, out any) error { return gob.NewDecoder(r).Decode(out) }
+
+// PutJSON writes v as a JSON document, see PutEncoded.
+func (f *FileClient) PutJSON(ctx context.Context, storeBox, fileName string, v any) error {
+	return f.PutEncoded(ctx, storeBox, fileName, v, JSONCodec)
+}
+
+// GetJSON reads a JSON document into out, see GetEncoded.
+func (f *FileClient) GetJSON(ctx context.Context, storeBox, fileName string, out any) error {
+	return f.GetEncoded(ctx, storeBox, fileName, out, JSONCodec)
+}
+
+// PutGob writes v as a gob stream, see PutEncoded.
+func (f *FileClient) PutGob(ctx context.Context, storeBox, fileName string, v any) error {
+	return f.PutEncoded(ctx, storeBox, fileName, v, GobCodec)
+}
+
+// GetGob reads a gob stream into out, see GetEncoded.
+func (f *FileClient) GetGob(ctx context.Context, storeBox, fileName string, out any) error {
+	return f.GetEncoded(ctx, storeBox, fileName, out, GobCodec)
+}
+
+// PutEncoded writes v encoded with codec, storing the content type of the codec, like
+// PutObjectWithOptions: the value is encoded as it is read by the client, without marshaling it
+// into a separate buffer first, and the object goes through the transforms and the replication
+// of any other write. A value the codec fails to encode is not written.
+func (f *FileClient) PutEncoded(ctx context.Context, storeBox, fileName string, v any, codec Codec) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(codec.Encode(pw, v))
+	}()
+	defer pr.Close()
+
+	if err := f.PutObjectWithOptions(ctx, storeBox, fileName, pr, PutOptions{ContentType: codec.ContentType()}); err != nil {
+		return fmt.Errorf("failed to put the encoded object: %w", err)
+	}
+	return nil
+}
+
+// GetEncoded reads an object written with codec into out like GetObject, decoding it as it is
+// read. The cache holds the encoded bytes, so that every call decodes a fresh value.
+func (f *FileClient) GetEncoded(ctx context.Context, storeBox, fileName string, out any, codec Codec) error {
+	obj, err := f.GetObject(ctx, storeBox, fileName)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	if err := codec.Decode(obj, out); err != nil {
+		return fmt.Errorf("failed to decode %s/%s: %w", storeBox, fileName, err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package m2cs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// WithMaxConcurrentWrites caps the number of writes the FileClient replicates at the same time.
+// A write holds its slot from the start of the replication until every storage has been written,
+// background writes of ASYNC_REPLICATION included; PutObject also holds it while reading the payload,
+// so that the cap bounds the memory used by the payloads as well.
+// Callers waiting for a slot give up when their context is done.
+func WithMaxConcurrentWrites(n int) FileClientOption {
+	return func(f *FileClient) error {
+		if n <= 0 {
+			return fmt.Errorf("max concurrent writes must be positive, got %d", n)
+		}
+		f.writeSlots = make(chan struct{}, n)
+		return nil
+	}
+}
+
+// WithMaxStorageConcurrency caps the number of storages an operation calls at the same time, e.g.
+// the writes of a SYNC_REPLICATION put or the deletions of RemoveObject, so that an operation on many
+// storages runs a bounded number of goroutines. Once the context of the operation is done, the storages
+// not called yet are skipped and fail with the context error, so that the operation returns as soon as
+// the calls in flight do. By default every storage is called at once.
+func WithMaxStorageConcurrency(n int) FileClientOption {
+	return func(f *FileClient) error {
+		if n <= 0 {
+			return fmt.Errorf("max storage concurrency must be positive, got %d", n)
+		}
+		f.storageConcurrency = n
+		return nil
+	}
+}
+
+// InFlightWrites returns the number of writes being replicated, background writes included.
+func (f *FileClient) InFlightWrites() int64 {
+	return f.inFlightWrites.Load()
+}
+
+// acquireWrite waits for a write slot, when the writes are capped, and returns the function
+// releasing it, which must be called exactly once.
+func (f *FileClient) acquireWrite(ctx context.Context) (func(), error) {
+	if f.writeSlots != nil {
+		select {
+		case f.writeSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to acquire a write slot: %w", ctx.Err())
+		}
+	}
+
+	f.inFlightWrites.Add(1)
+	return func() {
+		f.inFlightWrites.Add(-1)
+		if f.writeSlots != nil {
+			<-f.writeSlots
+		}
+	}, nil
+}
+
+// forEachStorage calls fn concurrently on every storage, with at most WithMaxStorageConcurrency calls
+// in flight, and returns the error of each call, indexed as storages. Once ctx is done, the storages
+// not called yet are skipped and fail with the context error. It returns when every call has returned.
+func (f *FileClient) forEachStorage(ctx context.Context, storages []filestorage.FileStorage, fn func(i int, s filestorage.FileStorage) error) []error {
+	errs := make([]error, len(storages))
+
+	limit := f.storageConcurrency
+	if limit <= 0 || limit > len(storages) {
+		limit = len(storages)
+	}
+	sem := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	for i, storage := range storages {
+		err := ctx.Err()
+		if err == nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		if err != nil {
+			for j := i; j < len(storages); j++ {
+				errs[j] = fmt.Errorf("not attempted: %w", err)
+			}
+			break
+		}
+
+		wg.Add(1)
+		i, s := i, storage
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = fn(i, s)
+		}()
+	}
+
+	wg.Wait()
+	return errs
+}
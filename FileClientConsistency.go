@@ -0,0 +1,135 @@
+package m2cs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tizianocitro/m2cs/internal/loadbalancing"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// ReadConsistency defines the freshness required by a read, see GetOptions.ReadConsistency.
+// EVENTUAL_CONSISTENCY accepts the copy of any storage, which may be older than the one of the
+// main storages with ASYNC_REPLICATION. STRONG_CONSISTENCY only reads from the main storages.
+// VERIFIED_CONSISTENCY asks a main storage for the ETag of the object first, then accepts the copy
+// of any storage with the same ETag, falling back to the main storages.
+type ReadConsistency int
+
+const (
+	EVENTUAL_CONSISTENCY ReadConsistency = iota
+	STRONG_CONSISTENCY
+	VERIFIED_CONSISTENCY
+)
+
+// String returns the name of the read consistency.
+func (c ReadConsistency) String() string {
+	switch c {
+	case EVENTUAL_CONSISTENCY:
+		return "EVENTUAL_CONSISTENCY"
+	case STRONG_CONSISTENCY:
+		return "STRONG_CONSISTENCY"
+	case VERIFIED_CONSISTENCY:
+		return "VERIFIED_CONSISTENCY"
+	default:
+		return fmt.Sprintf("ReadConsistency(%d)", int(c))
+	}
+}
+
+var (
+	// errNotMain is reported for the replicas skipped by the reads with STRONG_CONSISTENCY.
+	errNotMain = errors.New("replica skipped by STRONG_CONSISTENCY read")
+	// errStaleCopy is reported for the copies rejected by the reads with VERIFIED_CONSISTENCY.
+	errStaleCopy = errors.New("copy differs from the main storages")
+)
+
+// readObject reads an object through the load balancer with the given consistency.
+func (f *FileClient) readObject(ctx context.Context, lb loadbalancing.LoadBalancer, storeBox, fileName string, consistency ReadConsistency) (io.ReadCloser, error) {
+	switch consistency {
+	case EVENTUAL_CONSISTENCY:
+		return lb.Apply(ctx, storeBox, fileName)
+	case STRONG_CONSISTENCY:
+		return f.readFromMains(ctx, lb, storeBox, fileName)
+	case VERIFIED_CONSISTENCY:
+		return f.readVerified(ctx, lb, storeBox, fileName)
+	default:
+		return nil, fmt.Errorf("unknown read consistency %v", consistency)
+	}
+}
+
+// readFromMains reads an object from the first main storage, in load balancing order, serving it.
+func (f *FileClient) readFromMains(ctx context.Context, lb loadbalancing.LoadBalancer, storeBox, fileName string) (io.ReadCloser, error) {
+	return loadbalancing.Execute(ctx, lb, func(client loadbalancing.Client) (io.ReadCloser, error) {
+		s := client.(filestorage.FileStorage)
+		if !s.GetConnectionProperties().IsMainInstance {
+			return nil, errNotMain
+		}
+		return s.GetObject(ctx, storeBox, fileName)
+	})
+}
+
+// readVerified reads an object from the first storage, in load balancing order, holding the copy
+// with the ETag reported by a main storage. The copies of the replicas are compared by ETag, so
+// they only match the main storages of the same provider saving objects with the same transforms;
+// the main storages are trusted as is. Without a main storage able to report the ETag, the object
+// is read from the main storages.
+func (f *FileClient) readVerified(ctx context.Context, lb loadbalancing.LoadBalancer, storeBox, fileName string) (io.ReadCloser, error) {
+	etag, err := f.mainETag(ctx, storeBox, fileName)
+	if errors.Is(err, filestorage.ErrObjectNotFound) {
+		return nil, err
+	}
+	if err != nil || etag == "" {
+		return f.readFromMains(ctx, lb, storeBox, fileName)
+	}
+
+	return loadbalancing.Execute(ctx, lb, func(client loadbalancing.Client) (io.ReadCloser, error) {
+		s := client.(filestorage.FileStorage)
+		if s.GetConnectionProperties().IsMainInstance {
+			return s.GetObject(ctx, storeBox, fileName)
+		}
+		ig, ok := s.(filestorage.InfoGetter)
+		if !ok {
+			return nil, filestorage.ErrInfoNotSupported
+		}
+		obj, stat, err := ig.GetObjectWithInfo(ctx, storeBox, fileName)
+		if err != nil {
+			return nil, err
+		}
+		if stat.ETag != etag {
+			_ = obj.Close()
+			return nil, fmt.Errorf("%w: ETag %q, expected %q", errStaleCopy, stat.ETag, etag)
+		}
+		return obj, nil
+	})
+}
+
+// mainETag returns the ETag of an object reported by the PRIMARY storage or, without a primary,
+// by the first main storage able to report it.
+func (f *FileClient) mainETag(ctx context.Context, storeBox, fileName string) (string, error) {
+	mains := f.mainStorages()
+	if primary := f.primaryMain(); primary >= 0 {
+		mains = append([]filestorage.FileStorage{mains[primary]}, mains...)
+	}
+
+	var errs []error
+	for _, s := range mains {
+		stater, ok := s.(filestorage.ObjectStater)
+		if !ok {
+			continue
+		}
+		var stat ObjectStat
+		err := f.retry(ctx, READ_OPERATION, func() (err error) {
+			stat, err = stater.StatObject(ctx, storeBox, fileName)
+			return err
+		})
+		if err == nil {
+			return stat.ETag, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", storageLabel(s), err))
+	}
+	if len(errs) == 0 {
+		return "", nil
+	}
+	return "", errors.Join(errs...)
+}
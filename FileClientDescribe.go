@@ -0,0 +1,151 @@
+package m2cs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tizianocitro/m2cs/internal/caching"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// ClientDescription is a read-only snapshot of the configuration of a FileClient.
+// It never contains credentials or encryption keys.
+type ClientDescription struct {
+	ReplicationMode ReplicationMode
+	LoadBalancing   LoadBalancingStrategy
+	Cache           CacheDescription
+	Storages        []StorageDescription
+	ReadOrder       []string // Labels of the storages in the order reads try them, before any ROUND_ROBIN rotation
+}
+
+// CacheDescription describes the cache configuration of a FileClient.
+// Configured is false when ConfigureCache was never called.
+type CacheDescription struct {
+	Configured         bool
+	Enabled            bool
+	MaxSizeMB          int64
+	TTL                time.Duration
+	MaxItems           int
+	MaxItemSizeMB      int64 // Largest item admitted, MaxSizeMB when not set
+	AdmissionMinHits   int   // Reads within the window before an item is cached, 1 when not set
+	CompressEntries    bool
+	CurrentSizeBytes   int64 // Memory held by the cached items, compressed or not
+	LogicalSizeBytes   int64 // Size of the cached items once decompressed
+	ValidationStrategy string
+}
+
+// StorageDescription describes a storage of a FileClient.
+type StorageDescription struct {
+	Label       string
+	Type        string
+	IsMain      bool
+	Role        StorageRole // Current role, changed by PromoteToPrimary
+	Compression CompressionAlgorithm
+	Encryption  EncryptionAlgorithm
+}
+
+// Describe returns the configuration of the client, for display purposes.
+func (f *FileClient) Describe() ClientDescription {
+	return ClientDescription{
+		ReplicationMode: f.replicationMode,
+		LoadBalancing:   f.lbStrategy,
+		Cache:           describeCache(f.cache),
+		Storages:        f.GetStorages(),
+		ReadOrder:       readOrder(f.storages),
+	}
+}
+
+// readOrder returns the labels of the storages in the order of their load balancing groups.
+func readOrder(storages []filestorage.FileStorage) []string {
+	var labels []string
+	for _, group := range readGroups(storages) {
+		for _, client := range group.Clients {
+			labels = append(labels, storageLabel(client.(filestorage.FileStorage)))
+		}
+	}
+	return labels
+}
+
+// GetStorages returns the description of the storages of the client, in configuration order.
+func (f *FileClient) GetStorages() []StorageDescription {
+	storages := make([]StorageDescription, 0, len(f.storages))
+	for i, s := range f.storages {
+		description := describeStorage(s)
+		description.Role = f.role(i)
+		storages = append(storages, description)
+	}
+	return storages
+}
+
+// String returns a human readable summary of the description.
+func (d ClientDescription) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "replication=%s load_balancing=%s cache=%s read_order=[%s]",
+		d.ReplicationMode, d.LoadBalancing, d.Cache, strings.Join(d.ReadOrder, " "))
+	for _, s := range d.Storages {
+		fmt.Fprintf(&sb, "\n  %s", s)
+	}
+	return sb.String()
+}
+
+// String returns a human readable summary of the cache description.
+func (c CacheDescription) String() string {
+	if !c.Configured {
+		return "not configured"
+	}
+	return fmt.Sprintf("{enabled=%t max_size_mb=%d ttl=%s max_items=%d max_item_size_mb=%d admission_min_hits=%d validation=%s}",
+		c.Enabled, c.MaxSizeMB, c.TTL, c.MaxItems, c.MaxItemSizeMB, c.AdmissionMinHits, c.ValidationStrategy)
+}
+
+// String returns a human readable summary of the storage description.
+func (s StorageDescription) String() string {
+	return fmt.Sprintf("%s (%s) main=%t role=%s compression=%s encryption=%s",
+		s.Label, s.Type, s.IsMain, s.Role, s.Compression, s.Encryption)
+}
+
+// describeStorage copies the non-secret properties of a storage.
+func describeStorage(s filestorage.FileStorage) StorageDescription {
+	props := s.GetConnectionProperties()
+	return StorageDescription{
+		Label:       storageLabel(s),
+		Type:        fmt.Sprintf("%T", s),
+		IsMain:      props.IsMainInstance,
+		Compression: props.SaveCompress,
+		Encryption:  props.SaveEncrypt,
+	}
+}
+
+// describeCache copies the options of a cache.
+func describeCache(cache *caching.FileCache) CacheDescription {
+	if cache == nil {
+		return CacheDescription{}
+	}
+
+	validation := "NO_VALIDATION"
+	if v := cache.Options.ValidationOptions; v != nil {
+		switch v.Strategy {
+		case caching.NO_VALIDATION:
+		case caching.SAMPLING_VALIDATION:
+			validation = fmt.Sprintf("SAMPLING_VALIDATION(%d%%, every %s)", v.SamplingPercent, v.ValidationInterval)
+		case caching.CUSTOM_VALIDATION:
+			validation = fmt.Sprintf("CUSTOM_VALIDATION(every %s)", v.ValidationInterval)
+		default:
+			validation = fmt.Sprintf("Strategy(%d)(every %s)", int(v.Strategy), v.ValidationInterval)
+		}
+	}
+
+	return CacheDescription{
+		Configured:         true,
+		Enabled:            cache.Enabled(),
+		MaxSizeMB:          cache.Options.MaxSizeMB,
+		TTL:                cache.Options.TTL,
+		MaxItems:           cache.Options.MaxItems,
+		MaxItemSizeMB:      cache.MaxItemBytes() >> 20,
+		AdmissionMinHits:   max(cache.Options.AdmissionMinHits, 1),
+		CompressEntries:    cache.Options.CompressEntries,
+		CurrentSizeBytes:   cache.CurrentSizeBytes(),
+		LogicalSizeBytes:   cache.LogicalSizeBytes(),
+		ValidationStrategy: validation,
+	}
+}
@@ -0,0 +1,83 @@
+package m2cs
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Interceptor wraps the operations of a FileClient, e.g. to log, meter or authorize them.
+// It is called with the context and the description of the operation, and runs the operation,
+// along with the interceptors registered after it, by calling next, possibly with a derived
+// context. An interceptor short-circuits the operation by returning an error without calling
+// next; the error is returned by the operation as is.
+type Interceptor func(ctx context.Context, op OpInfo, next func(ctx context.Context) error) error
+
+// OpInfo describes an operation passed to the interceptors.
+type OpInfo struct {
+	Name     string        // Name of the FileClient method, e.g. "PutObject"
+	Type     OperationType // READ_OPERATION, WRITE_OPERATION, REMOVE_OPERATION or EXIST_OPERATION
+	StoreBox string        // Store box as given by the caller, before WithBoxPrefix is applied
+	Key      string        // Key as given by the caller, before WithKeyPrefix and the key encoding are applied
+	Size     int64         // Size of the written object, -1 when unknown and for the other operations
+}
+
+// WithInterceptors wraps the reads, writes, deletions and existence checks of the FileClient with
+// the given interceptors: GetObject, GetObjectWithOptions, GetObjectWithInfo, GetObjectToWriter,
+// FGetObject and DownloadParallel; PutObject, PutObjectWithOptions, PutObjectWithReport, FPutObject,
+// UploadParallel and PutObjectFromURL; RemoveObject; ExistObject and ExistsObject. The
+// interceptors run in registration order, the first one being the outermost, before the names
+// are validated.
+// The calls made by the client itself, such as the background fan-out of ASYNC_REPLICATION
+// and the cache revalidations, are not intercepted.
+func WithInterceptors(interceptors ...Interceptor) FileClientOption {
+	return func(f *FileClient) error {
+		for i, interceptor := range interceptors {
+			if interceptor == nil {
+				return fmt.Errorf("interceptor at index %d is nil", i)
+			}
+		}
+		f.interceptors = append(f.interceptors, interceptors...)
+		return nil
+	}
+}
+
+// intercept runs call through the interceptors of the client. An interceptor returning nil
+// without calling next makes the operation fail, as it has no result to return.
+func (f *FileClient) intercept(ctx context.Context, op OpInfo, call func(ctx context.Context) error) error {
+	if len(f.interceptors) == 0 {
+		return call(f.withRequestID(ctx))
+	}
+
+	// the request ID is taken from the context passed by the innermost interceptor
+	called := false
+	next := func(ctx context.Context) error {
+		called = true
+		return call(f.withRequestID(ctx))
+	}
+	for i := len(f.interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := f.interceptors[i], next
+		next = func(ctx context.Context) error {
+			return interceptor(ctx, op, inner)
+		}
+	}
+
+	if err := next(ctx); err != nil {
+		return err
+	}
+	if !called {
+		return fmt.Errorf("%s of %s/%s was skipped by an interceptor", op.Name, op.StoreBox, op.Key)
+	}
+	return nil
+}
+
+// writeSize returns the size of the object written by a put, when known, or -1.
+func writeSize(reader io.Reader, opts PutOptions) int64 {
+	if opts.Size > 0 {
+		return opts.Size
+	}
+	if l, ok := reader.(interface{ Len() int }); ok {
+		return int64(l.Len())
+	}
+	return -1
+}
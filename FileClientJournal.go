@@ -0,0 +1,111 @@
+package m2cs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/internal/replication"
+)
+
+// RecoveryReport summarizes the outcome of a RecoverPendingReplications call.
+type RecoveryReport struct {
+	Entries    int // Pending replications found in the journal
+	Replicated int // Pending replications completed on all their targets
+	Failed     int // Pending replications left in the journal, as some targets failed again
+	Corrupt    int // Journal entries that could not be decoded, renamed with the ".corrupt" suffix
+}
+
+// WithReplicationJournal makes the ASYNC_REPLICATION writes survive a crash of the process:
+// before PutObject returns, the replication left to the background, i.e. the store box, the key,
+// the storage written and the labels of the other main storages, is recorded as a file of dir,
+// which is removed once every target is written. After a crash, RecoverPendingReplications
+// completes the replications recorded by the previous process. The directory is created if needed
+// and must not be shared by processes running at the same time. The storages are identified by
+// their label, which must be set and stay the same across restarts.
+func WithReplicationJournal(dir string) FileClientOption {
+	return func(f *FileClient) error {
+		journal, err := replication.NewDiskQueue(dir)
+		if err != nil {
+			return err
+		}
+		f.journal = journal
+		return nil
+	}
+}
+
+// RecoverPendingReplications completes the replications recorded in the journal, see
+// WithReplicationJournal, by copying each object from the storage written first to the targets
+// of its replication. It should be called at startup, before the client is used. Copies are
+// idempotent: a target already holding the object is written again with the same content, so
+// the journal can be replayed any number of times. An entry is removed once all its targets are
+// written, and kept, with the targets left, otherwise. Entries that cannot be decoded are renamed
+// with the ".corrupt" suffix and counted, and the leftovers of entries being written during the
+// crash are removed, as their PutObject had not returned. The copies run on the workers of the
+// background replications, see WithReplicationWorkers.
+func (f *FileClient) RecoverPendingReplications(ctx context.Context) (RecoveryReport, error) {
+	var report RecoveryReport
+	if f.journal == nil {
+		return report, errors.New("no replication journal configured")
+	}
+
+	tasks, corrupt, err := f.journal.Pending()
+	if err != nil {
+		return report, err
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	for _, err := range corrupt {
+		report.Corrupt++
+		log.Printf("[journal] %v", err)
+		if errors.Is(err, replication.ErrSetAside) {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, t := range tasks {
+		report.Entries++
+		wg.Add(1)
+		t.Done = func(results []error) {
+			defer wg.Done()
+			err := f.recordReplay(t, results)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				report.Replicated++
+			} else {
+				report.Failed++
+				errs = append(errs, fmt.Errorf("%s/%s: %w", t.StoreBox, t.Key, err))
+			}
+		}
+		_ = f.replicator().Enqueue(ctx, t)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return report, fmt.Errorf("RecoverPendingReplications failed on %d/%d entries: %w", report.Failed, report.Entries, errors.Join(errs...))
+	}
+	return report, nil
+}
+
+// recordReplay records the results of the replay of a journaled replication in the replication
+// lag, returning the failures of its targets.
+func (f *FileClient) recordReplay(t *replication.Task, results []error) error {
+	var errs []error
+	for i, err := range results {
+		if dst := f.storageByLabel(t.Targets[i]); dst != nil {
+			f.lag.record(dst, t.StoreBox, t.Key, err)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.Targets[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
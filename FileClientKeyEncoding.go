@@ -0,0 +1,117 @@
+package m2cs
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// KeyEncoding defines how the FileClient encodes the keys given by the caller into the keys
+// stored on the storages, so that names holding characters handled differently by the providers
+// round-trip on all of them.
+// URL_KEY_ENCODING percent-encodes every byte other than letters, digits, "-", ".", "_", "~" and "/",
+// e.g. "my file\1%.txt" is stored as "my%20file%5C1%25.txt".
+// BASE64_KEY_ENCODING encodes every "/"-separated segment in unpadded URL-safe base64, so that the
+// stored segments only hold letters, digits, "-" and "_", e.g. "docs/a b." is stored as "ZG9jcw/YSBiLg".
+// Encoded keys are longer: they must still fit the limits checked by the name validation.
+type KeyEncoding int
+
+const (
+	NO_KEY_ENCODING KeyEncoding = iota
+	URL_KEY_ENCODING
+	BASE64_KEY_ENCODING
+)
+
+// String returns the name of the key encoding.
+func (e KeyEncoding) String() string {
+	switch e {
+	case NO_KEY_ENCODING:
+		return "NO_KEY_ENCODING"
+	case URL_KEY_ENCODING:
+		return "URL_KEY_ENCODING"
+	case BASE64_KEY_ENCODING:
+		return "BASE64_KEY_ENCODING"
+	default:
+		return fmt.Sprintf("KeyEncoding(%d)", int(e))
+	}
+}
+
+// WithKeyEncoding encodes the keys on every operation and decodes the keys reported by SyncBox and
+// Watch. A stored key which is not the encoding of any name, e.g. "a%41" or "a b" with URL_KEY_ENCODING,
+// has been written without the encoding: it is ignored by SyncBox and Watch, and cannot be addressed
+// through the client. A stored key which is the encoding of a name is that name, whoever wrote it:
+// the literal key "a%20b" and the name "a b" are the same object for the client, and "a%20b" is
+// stored as "a%2520b". The key prefix of WithKeyPrefix is not encoded; the prefixes of the lifecycle
+// rules are, so that with BASE64_KEY_ENCODING they only match whole segments.
+func WithKeyEncoding(encoding KeyEncoding) FileClientOption {
+	return func(f *FileClient) error {
+		if encoding < NO_KEY_ENCODING || encoding > BASE64_KEY_ENCODING {
+			return fmt.Errorf("unknown key encoding %v", encoding)
+		}
+		f.keyEncoding = encoding
+		return nil
+	}
+}
+
+// encode returns the stored form of a key.
+func (e KeyEncoding) encode(key string) string {
+	switch e {
+	case URL_KEY_ENCODING:
+		var sb strings.Builder
+		for i := 0; i < len(key); i++ {
+			if c := key[i]; isUnreservedKeyByte(c) || c == '/' {
+				sb.WriteByte(c)
+			} else {
+				fmt.Fprintf(&sb, "%%%02X", c)
+			}
+		}
+		return sb.String()
+	case BASE64_KEY_ENCODING:
+		segments := strings.Split(key, "/")
+		for i, segment := range segments {
+			segments[i] = base64.RawURLEncoding.EncodeToString([]byte(segment))
+		}
+		return strings.Join(segments, "/")
+	default:
+		return key
+	}
+}
+
+// decode returns the key whose stored form is key, reporting false when key is not
+// the encoding of any key.
+func (e KeyEncoding) decode(key string) (string, bool) {
+	var decoded string
+	switch e {
+	case URL_KEY_ENCODING:
+		unescaped, err := url.PathUnescape(key)
+		if err != nil {
+			return "", false
+		}
+		decoded = unescaped
+	case BASE64_KEY_ENCODING:
+		segments := strings.Split(key, "/")
+		for i, segment := range segments {
+			raw, err := base64.RawURLEncoding.DecodeString(segment)
+			if err != nil {
+				return "", false
+			}
+			segments[i] = string(raw)
+		}
+		decoded = strings.Join(segments, "/")
+	default:
+		return key, true
+	}
+
+	// a key decoding to a name encoded differently was not written with the encoding
+	if e.encode(decoded) != key {
+		return "", false
+	}
+	return decoded, true
+}
+
+// isUnreservedKeyByte reports whether c is left as is by URL_KEY_ENCODING.
+func isUnreservedKeyByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,161 @@
+package m2cs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// LagInfo is the replication lag of a main storage, see ReplicationLag.
+type LagInfo struct {
+	PendingObjects   int           // Objects written to a main storage, not yet confirmed on this one
+	OldestPendingAge time.Duration // Age of the oldest pending object, 0 without pending objects
+	LastReplicated   time.Time     // Time of the last write confirmed on this storage, zero before the first one
+}
+
+// WithReplicationLagHook calls hook with the replication lag of a main storage, by label, every
+// time it changes, e.g. to export it as metrics; see ReplicationLag. The hook is called by the
+// writes and by the background replications, possibly concurrently, and must not block.
+func WithReplicationLagHook(hook func(storage string, lag LagInfo)) FileClientOption {
+	return func(f *FileClient) error {
+		if hook == nil {
+			return fmt.Errorf("replication lag hook is nil")
+		}
+		f.lag.hook = hook
+		return nil
+	}
+}
+
+// ReplicationLag returns the replication lag of each main storage, by label: the objects written
+// to a main storage whose write is not confirmed yet on this one, in flight in the background with
+// ASYNC_REPLICATION or failed, and when the last write was confirmed. A pending object is
+// confirmed by the next successful write of its key on the storage, including the ones of
+// RecoverPendingReplications. Lagging storages are usually the slow or failing ones of
+// ASYNC_REPLICATION clients; with SYNC_REPLICATION, only the storages a write partially failed on
+// lag.
+func (f *FileClient) ReplicationLag() map[string]LagInfo {
+	now := time.Now()
+	lags := make(map[string]LagInfo)
+	for _, s := range f.mainStorages() {
+		label := storageLabel(s)
+		lags[label] = f.lag.info(label, now)
+	}
+	return lags
+}
+
+// replicationLag tracks the writes not confirmed yet on each storage, see ReplicationLag. Its
+// zero value tracks nothing yet.
+type replicationLag struct {
+	mu       sync.Mutex
+	storages map[string]*storageLag // By label
+	hook     func(storage string, lag LagInfo)
+}
+
+// storageLag is the replication lag of a storage.
+type storageLag struct {
+	pending map[string]*pendingWrite // By store box and key
+	last    time.Time
+}
+
+// pendingWrite is an object not confirmed yet on a storage.
+type pendingWrite struct {
+	since    time.Time // When the oldest unconfirmed write started
+	inFlight int       // Writes of the object in flight on the storage
+}
+
+// lagKey identifies an object in the pending writes of a storage.
+func lagKey(storeBox, fileName string) string {
+	return storeBox + "/" + fileName
+}
+
+// start records writes of storeBox/fileName started on targets.
+func (l *replicationLag) start(targets []filestorage.FileStorage, storeBox, fileName string) {
+	now := time.Now()
+	key := lagKey(storeBox, fileName)
+	for _, s := range targets {
+		label := storageLabel(s)
+		l.update(label, now, func(lag *storageLag) {
+			p := lag.pending[key]
+			if p == nil {
+				p = &pendingWrite{since: now}
+				lag.pending[key] = p
+			}
+			p.inFlight++
+		})
+	}
+}
+
+// finish records the outcome of a write of storeBox/fileName on s, started with start. A failed
+// write leaves the object pending, until confirmed by a later write.
+func (l *replicationLag) finish(s filestorage.FileStorage, storeBox, fileName string, err error) {
+	now := time.Now()
+	key := lagKey(storeBox, fileName)
+	l.update(storageLabel(s), now, func(lag *storageLag) {
+		p := lag.pending[key]
+		if p != nil && p.inFlight > 0 {
+			p.inFlight--
+		}
+		if err != nil {
+			return
+		}
+		lag.last = now
+		switch {
+		case p == nil:
+		case p.inFlight == 0:
+			delete(lag.pending, key)
+		default:
+			// the writes still in flight started after the oldest one, which is now confirmed
+			p.since = now
+		}
+	})
+}
+
+// record records the outcome of a write of storeBox/fileName on s, not recorded by start: a
+// failed write leaves the object pending.
+func (l *replicationLag) record(s filestorage.FileStorage, storeBox, fileName string, err error) {
+	if err != nil {
+		l.start([]filestorage.FileStorage{s}, storeBox, fileName)
+	}
+	l.finish(s, storeBox, fileName, err)
+}
+
+// update applies fn to the lag of label, then reports it to the hook.
+func (l *replicationLag) update(label string, now time.Time, fn func(lag *storageLag)) {
+	l.mu.Lock()
+	if l.storages == nil {
+		l.storages = make(map[string]*storageLag)
+	}
+	lag := l.storages[label]
+	if lag == nil {
+		lag = &storageLag{pending: make(map[string]*pendingWrite)}
+		l.storages[label] = lag
+	}
+	fn(lag)
+	info := lag.info(now)
+	l.mu.Unlock()
+
+	if l.hook != nil {
+		l.hook(label, info)
+	}
+}
+
+// info returns the lag of label at now.
+func (l *replicationLag) info(label string, now time.Time) LagInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lag := l.storages[label]; lag != nil {
+		return lag.info(now)
+	}
+	return LagInfo{}
+}
+
+// info returns the lag at now.
+func (lag *storageLag) info(now time.Time) LagInfo {
+	info := LagInfo{PendingObjects: len(lag.pending), LastReplicated: lag.last}
+	for _, p := range lag.pending {
+		info.OldestPendingAge = max(info.OldestPendingAge, now.Sub(p.since))
+	}
+	return info
+}
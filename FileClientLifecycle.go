@@ -0,0 +1,116 @@
+package m2cs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// SetBoxLifecycle replaces the lifecycle rules of a store box on every main storage; an
+// empty slice removes them. Storages without lifecycle support, such as Azure where the
+// policies are account-scoped, fail with filestorage.ErrLifecycleNotSupported, so that the
+// returned *PartialFailureError reports which storages accepted the rules.
+// A client scoped with WithKeyPrefix replaces only the rules of its namespace: the prefix is
+// prepended to the Prefix and to the ID of its rules, and the rules of the other namespaces
+// of the box are kept.
+func (f *FileClient) SetBoxLifecycle(ctx context.Context, storeBox string, rules []LifecycleRule) error {
+	storeBox, err := f.scopeBox(storeBox)
+	if err != nil {
+		return err
+	}
+
+	return f.onMainStorages(ctx, "SetBoxLifecycle", func(s filestorage.FileStorage) error {
+		lm, ok := s.(filestorage.LifecycleManager)
+		if !ok {
+			return filestorage.ErrLifecycleNotSupported
+		}
+		if f.keyPrefix == "" {
+			return lm.SetBoxLifecycle(ctx, storeBox, rules)
+		}
+
+		existing, err := lm.GetBoxLifecycle(ctx, storeBox)
+		if err != nil {
+			return err
+		}
+
+		var merged []LifecycleRule
+		for _, rule := range existing {
+			if _, ok := f.unscopeRule(rule); !ok {
+				merged = append(merged, rule)
+			}
+		}
+		for i, rule := range rules {
+			merged = append(merged, f.scopeRule(rule, i))
+		}
+		return lm.SetBoxLifecycle(ctx, storeBox, merged)
+	})
+}
+
+// GetBoxLifecycle returns the lifecycle rules of a store box on each main storage, keyed by
+// storage label. Storages failing to report their rules are missing from the result and
+// their errors are reported as in SetBoxLifecycle. A client scoped with WithKeyPrefix only
+// returns the rules of its namespace, as they were set.
+func (f *FileClient) GetBoxLifecycle(ctx context.Context, storeBox string) (map[string][]LifecycleRule, error) {
+	storeBox, err := f.scopeBox(storeBox)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	lifecycles := make(map[string][]LifecycleRule)
+
+	err = f.onMainStorages(ctx, "GetBoxLifecycle", func(s filestorage.FileStorage) error {
+		lm, ok := s.(filestorage.LifecycleManager)
+		if !ok {
+			return filestorage.ErrLifecycleNotSupported
+		}
+
+		rules, err := lm.GetBoxLifecycle(ctx, storeBox)
+		if err != nil {
+			return err
+		}
+
+		if f.keyPrefix != "" {
+			var scoped []LifecycleRule
+			for _, rule := range rules {
+				if rule, ok := f.unscopeRule(rule); ok {
+					scoped = append(scoped, rule)
+				}
+			}
+			rules = scoped
+		}
+
+		mu.Lock()
+		lifecycles[storageLabel(s)] = rules
+		mu.Unlock()
+		return nil
+	})
+
+	return lifecycles, err
+}
+
+// scopeRule moves the rule at index i into the namespace of the client, naming it after
+// its position when it has no ID.
+func (f *FileClient) scopeRule(rule LifecycleRule, i int) LifecycleRule {
+	if rule.ID == "" {
+		rule.ID = fmt.Sprintf("m2cs-rule-%d", i)
+	}
+	rule.ID = f.keyPrefix + rule.ID
+	rule.Prefix = f.storedKey(rule.Prefix)
+	return rule
+}
+
+// unscopeRule strips the namespace of the client from a rule, reporting false when the
+// rule belongs to another namespace.
+func (f *FileClient) unscopeRule(rule LifecycleRule) (LifecycleRule, bool) {
+	prefix, ok := f.unscopeKey(rule.Prefix)
+	if !strings.HasPrefix(rule.ID, f.keyPrefix) || !ok {
+		return rule, false
+	}
+	rule.ID = rule.ID[len(f.keyPrefix):]
+	rule.Prefix = prefix
+	return rule, true
+}
@@ -0,0 +1,90 @@
+package m2cs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnexpectedPayload is returned by the writes whose payload does not match PutOptions.Size, or
+// is empty with PutOptions.ExpectNonEmpty, e.g. because the reader was already consumed in part
+// by the caller. No storage is written.
+var ErrUnexpectedPayload = errors.New("unexpected payload")
+
+// WithMaxObjectSize caps the size of the objects written by the FileClient, e.g. to protect the
+// storages from a producer streaming an unbounded reader. PutObject counts the bytes as they are
+// read and fails with ErrObjectTooLarge as soon as the limit is exceeded: as the payload is read
+// before any storage is written, no storage holds a partial object. Writes whose size is known
+// upfront, such as FPutObject and UploadParallel, fail before reading anything.
+// PutOptions.MaxSize overrides the limit per call.
+func WithMaxObjectSize(bytes int64) FileClientOption {
+	return func(f *FileClient) error {
+		if bytes <= 0 {
+			return fmt.Errorf("max object size must be positive, got %d", bytes)
+		}
+		f.maxObjectSize = bytes
+		return nil
+	}
+}
+
+// maxSize returns the maximum size of the payload of a write with the given options, 0 when unlimited.
+func (f *FileClient) maxSize(opts PutOptions) int64 {
+	if opts.MaxSize > 0 {
+		return opts.MaxSize
+	}
+	return f.maxObjectSize
+}
+
+// checkSize fails with ErrObjectTooLarge when size exceeds limit, unless limit is 0.
+func checkSize(size, limit int64) error {
+	if limit > 0 && size > limit {
+		return fmt.Errorf("%w (%d > %d bytes), no storage was written", ErrObjectTooLarge, size, limit)
+	}
+	return nil
+}
+
+// readPayload reads the payload of a PutObject into a pooled buffer or a spooled file, or views
+// it in place, see viewPayload, which the caller must release. At most one byte beyond the size
+// limit is read from reader.
+func (f *FileClient) readPayload(reader io.Reader, opts PutOptions) (*payload, error) {
+	limit := f.maxSize(opts)
+	if view, err := f.viewPayload(reader, limit, opts); view != nil || err != nil {
+		return view, err
+	}
+
+	size := opts.Size
+	if l, ok := reader.(interface{ Len() int }); ok && size <= 0 {
+		size = int64(l.Len())
+	}
+	if err := checkSize(size, limit); err != nil {
+		return nil, err
+	}
+
+	if limit > 0 {
+		reader = io.LimitReader(reader, limit+1)
+	}
+	p, err := f.spool(reader, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input stream: %w", err)
+	}
+
+	switch {
+	case limit > 0 && p.size > limit:
+		p.release()
+		return nil, fmt.Errorf("%w (more than %d bytes), no storage was written", ErrObjectTooLarge, limit)
+	case opts.Size > 0 && p.size != opts.Size:
+		read := p.size
+		p.release()
+		return nil, fmt.Errorf("failed to read input stream: %w: read %d bytes, PutOptions.Size is %d", ErrUnexpectedPayload, read, opts.Size)
+	}
+	return p, nil
+}
+
+// checkPayload fails with ErrUnexpectedPayload when the payload of a write with
+// PutOptions.ExpectNonEmpty is empty.
+func checkPayload(size int64, opts PutOptions) error {
+	if opts.ExpectNonEmpty && size == 0 {
+		return fmt.Errorf("%w: read 0 bytes, PutOptions.ExpectNonEmpty is set, no storage was written", ErrUnexpectedPayload)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package m2cs
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"strings"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// ListOptions selects the objects listed by Objects and ListObjects.
+type ListOptions = filestorage.ListOptions
+
+// Objects iterates the objects of a store box, one page of the provider at a time:
+//
+//	for info, err := range client.Objects(ctx, "box", m2cs.ListOptions{Prefix: "photos/"}) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The objects are listed from the PRIMARY storage, if any, else from the first main storage able
+// to list, with their keys as given by the caller: the keys outside the namespace of WithKeyPrefix,
+// or not written with the key encoding of the client, are skipped. Breaking out of the loop stops
+// the paging of the provider. A failure, e.g. of the storage or of the validation of the store box
+// name, is yielded once, with a zero ObjectInfo, and ends the iteration.
+func (f *FileClient) Objects(ctx context.Context, storeBox string, opts ListOptions) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		storeBox, err := f.scopeBox(storeBox)
+		if err != nil {
+			yield(ObjectInfo{}, err)
+			return
+		}
+		s, err := f.objectsSource()
+		if err != nil {
+			yield(ObjectInfo{}, err)
+			return
+		}
+
+		prefix := opts.Prefix
+		stored := opts
+		stored.Prefix = f.storedPrefix(prefix)
+		for info, err := range filestorage.IterateObjects(ctx, s, storeBox, stored) {
+			if err != nil {
+				yield(ObjectInfo{}, err)
+				return
+			}
+			key, ok := f.unscopeKey(info.Key)
+			if !ok || !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			info.Key = key
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
+
+// ListObjects returns the objects of a store box, see Objects.
+func (f *FileClient) ListObjects(ctx context.Context, storeBox string, opts ListOptions) ([]ObjectInfo, error) {
+	var infos []ObjectInfo
+	for info, err := range f.Objects(ctx, storeBox, opts) {
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// objectsSource returns the storage listed by Objects: the PRIMARY storage, if any, else the first
+// main storage able to list.
+func (f *FileClient) objectsSource() (filestorage.FileStorage, error) {
+	mains := f.mainStorages()
+	if primary := f.primaryMain(); primary >= 0 {
+		mains = append([]filestorage.FileStorage{mains[primary]}, mains...)
+	}
+	for _, s := range mains {
+		switch s.(type) {
+		case filestorage.ObjectIterator, filestorage.ObjectLister:
+			return s, nil
+		}
+	}
+	return nil, errors.New("no main storage supports listing")
+}
+
+// storedPrefix returns the longest stored prefix shared by the keys starting with prefix, as given
+// by the caller. The base64 encoding of the keys preserves the prefixes of whole segments only.
+func (f *FileClient) storedPrefix(prefix string) string {
+	if f.keyEncoding == BASE64_KEY_ENCODING {
+		prefix = prefix[:strings.LastIndex(prefix, "/")+1]
+	}
+	if prefix == "" {
+		return f.keyPrefix
+	}
+	return f.keyPrefix + f.keyEncoding.encode(prefix)
+}
@@ -0,0 +1,137 @@
+package m2cs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidBoxName is returned when a store box name is rejected before any request is sent.
+var ErrInvalidBoxName = errors.New("invalid store box name")
+
+// ErrInvalidKey is returned when an object key is rejected before any request is sent, or
+// could escape the namespace of a FileClient scoped with WithKeyPrefix.
+var ErrInvalidKey = errors.New("invalid object key")
+
+// Limits shared by MinIO, AWS S3 and Azure Blob.
+const (
+	minBoxNameLength = 3
+	maxBoxNameLength = 63
+	maxKeyLength     = 1024 // In bytes, as AWS S3 counts them
+	maxKeySegments   = 254  // Azure Blob limit on the "/"-separated segments of a key
+)
+
+// NameValidation selects the rules the store box names and the keys are checked against by the
+// FileClient, before any request is sent, so that a name accepted by some storages and rejected by
+// others does not cause a partial failure.
+// STRICT_NAME_VALIDATION, the default, enforces the rules common to MinIO, AWS S3 and Azure Blob:
+// store box names of 3 to 63 lowercase letters, digits and hyphens, starting and ending with a letter
+// or a digit, without consecutive hyphens; keys without control characters, not ending with "." or
+// "/", of at most 254 segments. LENIENT_NAME_VALIDATION, for clients targeting a single provider, only
+// rejects the names no provider accepts: empty names, store box names holding "/", and keys that are
+// not valid UTF-8. Keys are limited to 1024 bytes in both modes.
+type NameValidation int
+
+const (
+	STRICT_NAME_VALIDATION NameValidation = iota
+	LENIENT_NAME_VALIDATION
+)
+
+// String returns the name of the name validation.
+func (v NameValidation) String() string {
+	switch v {
+	case STRICT_NAME_VALIDATION:
+		return "STRICT_NAME_VALIDATION"
+	case LENIENT_NAME_VALIDATION:
+		return "LENIENT_NAME_VALIDATION"
+	default:
+		return fmt.Sprintf("NameValidation(%d)", int(v))
+	}
+}
+
+// WithNameValidation selects the rules the store box names and the keys are checked against.
+func WithNameValidation(validation NameValidation) FileClientOption {
+	return func(f *FileClient) error {
+		if validation != STRICT_NAME_VALIDATION && validation != LENIENT_NAME_VALIDATION {
+			return fmt.Errorf("unknown name validation %v", validation)
+		}
+		f.nameValidation = validation
+		return nil
+	}
+}
+
+// ValidateBoxName checks a store box name against the given rules, e.g. before creating the
+// store box on the storages. The error wraps ErrInvalidBoxName and describes the violation.
+func ValidateBoxName(name string, validation NameValidation) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %q %s", ErrInvalidBoxName, name, fmt.Sprintf(format, args...))
+	}
+
+	if name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidBoxName)
+	}
+	if strings.Contains(name, "/") {
+		return invalid("holds a \"/\"")
+	}
+	if validation == LENIENT_NAME_VALIDATION {
+		return nil
+	}
+
+	if n := len(name); n < minBoxNameLength || n > maxBoxNameLength {
+		return invalid("is %d bytes long, not between %d and %d", n, minBoxNameLength, maxBoxNameLength)
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return invalid("holds the uppercase letter %q", r)
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+		default:
+			return invalid("holds %q; only lowercase letters, digits and hyphens are allowed", r)
+		}
+	}
+	if name[0] == '-' || name[len(name)-1] == '-' {
+		return invalid("must start and end with a letter or a digit")
+	}
+	if strings.Contains(name, "--") {
+		return invalid("holds consecutive hyphens")
+	}
+	if strings.HasPrefix(name, "sthree-") || strings.HasPrefix(name, "amzn-s3-demo-") || strings.HasSuffix(name, "-s3alias") {
+		return invalid("uses a prefix or suffix reserved by AWS S3")
+	}
+	return nil
+}
+
+// ValidateKey checks an object key against the given rules. The error wraps ErrInvalidKey
+// and describes the violation.
+func ValidateKey(key string, validation NameValidation) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %q %s", ErrInvalidKey, key, fmt.Sprintf(format, args...))
+	}
+
+	if key == "" {
+		return fmt.Errorf("%w: key is empty", ErrInvalidKey)
+	}
+	if len(key) > maxKeyLength {
+		return fmt.Errorf("%w: key is %d bytes long, the limit is %d", ErrInvalidKey, len(key), maxKeyLength)
+	}
+	if !utf8.ValidString(key) {
+		return invalid("is not valid UTF-8")
+	}
+	if validation == LENIENT_NAME_VALIDATION {
+		return nil
+	}
+
+	for _, r := range key {
+		if r < 0x20 || r == 0x7f {
+			return invalid("holds the control character %U", r)
+		}
+	}
+	if strings.HasSuffix(key, ".") || strings.HasSuffix(key, "/") {
+		return invalid("ends with %q, which Azure Blob does not preserve", key[len(key)-1:])
+	}
+	if n := strings.Count(key, "/") + 1; n > maxKeySegments {
+		return invalid("has %d segments, the limit is %d", n, maxKeySegments)
+	}
+	return nil
+}
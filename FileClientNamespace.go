@@ -0,0 +1,100 @@
+package m2cs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WithKeyPrefix scopes the FileClient to the keys starting with prefix, e.g. to share the store boxes
+// among tenants. The prefix is prepended to the keys on writes, reads, deletions and existence checks,
+// cache keys included, and stripped from the keys reported by SyncBox and Watch, which ignore the keys
+// outside the namespace. A "/" is appended to the prefix when missing, so that the namespace "tenant-a"
+// does not include the keys of "tenant-ab". Keys are validated before being prefixed: empty keys, keys
+// starting with "/" and keys holding "." or ".." segments fail with ErrInvalidKey.
+func WithKeyPrefix(prefix string) FileClientOption {
+	return func(f *FileClient) error {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if err := validateScopedKey(prefix); err != nil {
+			return fmt.Errorf("invalid key prefix: %w", err)
+		}
+		f.keyPrefix = prefix + "/"
+		return nil
+	}
+}
+
+// WithBoxPrefix scopes the FileClient to the store boxes whose name starts with prefix: the prefix is
+// prepended to the store box of every operation, so that "photos" is stored in prefix + "photos".
+// The trash box of soft-delete is used as configured.
+func WithBoxPrefix(prefix string) FileClientOption {
+	return func(f *FileClient) error {
+		if prefix == "" || strings.ContainsAny(prefix, `/\`) {
+			return fmt.Errorf("invalid box prefix %q", prefix)
+		}
+		f.boxPrefix = prefix
+		return nil
+	}
+}
+
+// scope validates a store box and a key given by the caller and returns them as stored,
+// with the key encoded and the prefixes applied. The stored names are checked against
+// the name validation rules.
+func (f *FileClient) scope(storeBox, fileName string) (string, string, error) {
+	storeBox, err := f.scopeBox(storeBox)
+	if err != nil {
+		return "", "", err
+	}
+	if f.keyPrefix != "" {
+		if err := validateScopedKey(fileName); err != nil {
+			return "", "", err
+		}
+	}
+	fileName = f.storedKey(fileName)
+	if err := ValidateKey(fileName, f.nameValidation); err != nil {
+		return "", "", err
+	}
+	return storeBox, fileName, nil
+}
+
+// storedKey returns the key stored for a key given by the caller, without validating it.
+func (f *FileClient) storedKey(fileName string) string {
+	return f.keyPrefix + f.keyEncoding.encode(fileName)
+}
+
+// scopeBox validates a store box given by the caller and returns it with the prefix applied.
+func (f *FileClient) scopeBox(storeBox string) (string, error) {
+	if f.boxPrefix != "" && storeBox == "" {
+		return "", fmt.Errorf("%w: name is empty", ErrInvalidBoxName)
+	}
+	storeBox = f.boxPrefix + storeBox
+	if err := ValidateBoxName(storeBox, f.nameValidation); err != nil {
+		return "", err
+	}
+	return storeBox, nil
+}
+
+// unscopeKey returns the key given by the caller for a stored key, stripped of the key
+// prefix and decoded, reporting false when the key is outside the namespace of the client
+// or was not written with its key encoding.
+func (f *FileClient) unscopeKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, f.keyPrefix) {
+		return "", false
+	}
+	return f.keyEncoding.decode(key[len(f.keyPrefix):])
+}
+
+// validateScopedKey rejects the keys that could be resolved outside of a prefix, by the providers
+// or by tools mapping keys to paths. Backslashes are treated as separators as well.
+func validateScopedKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: key is empty", ErrInvalidKey)
+	}
+	if strings.HasPrefix(key, "/") || strings.HasPrefix(key, `\`) {
+		return fmt.Errorf("%w: %q starts with a separator", ErrInvalidKey, key)
+	}
+	for _, segment := range strings.FieldsFunc(key, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("%w: %q holds a %q segment", ErrInvalidKey, key, segment)
+		}
+	}
+	return nil
+}
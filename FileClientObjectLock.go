@@ -0,0 +1,27 @@
+package m2cs
+
+import (
+	"context"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// SetObjectLegalHold places or removes the legal hold of an object on every main storage.
+// A legal hold prevents the object from being overwritten or deleted until it is removed,
+// independently of its retention. Storages without object lock, or whose store box does not
+// have it enabled, fail with filestorage.ErrObjectLockUnsupported, so that the returned
+// *PartialFailureError reports which storages hold the object.
+func (f *FileClient) SetObjectLegalHold(ctx context.Context, storeBox, fileName string, on bool) error {
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return err
+	}
+
+	return f.onMainStorages(ctx, "SetObjectLegalHold", func(s filestorage.FileStorage) error {
+		locker, ok := s.(filestorage.ObjectLocker)
+		if !ok {
+			return filestorage.ErrObjectLockUnsupported
+		}
+		return locker.SetObjectLegalHold(ctx, storeBox, fileName, on)
+	})
+}
@@ -0,0 +1,290 @@
+package m2cs
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/internal/bufpool"
+	"github.com/tizianocitro/m2cs/internal/loadbalancing"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// Defaults of DownloadParallel; uploads use the defaults of the storages.
+const (
+	defaultParallelPartSize    = 8 << 20
+	defaultParallelConcurrency = 4
+)
+
+// DownloadParallel downloads an object into w, fetching ranges of opts.PartSize bytes with
+// opts.Concurrency workers, and returns the number of bytes written.
+// Every range goes through the load balancer, so the ranges of an object may be served by
+// different replicas. Ranges can only be served by storages saving objects without compression
+// and encryption; when no such storage holds the object, it is streamed sequentially into w.
+// When the ETag of the object is a plain MD5 digest, the ranged download is verified against it
+// and ErrChecksumMismatch is returned on mismatch, e.g. when the replicas hold different versions.
+// On error, w may hold part of the object.
+func (f *FileClient) DownloadParallel(ctx context.Context, storeBox, fileName string, w io.WriterAt, opts ParallelOptions) (int64, error) {
+	var n int64
+	err := f.intercept(ctx, OpInfo{Name: "DownloadParallel", Type: READ_OPERATION, StoreBox: storeBox, Key: fileName, Size: -1}, func(ctx context.Context) (err error) {
+		n, err = f.downloadParallel(ctx, storeBox, fileName, w, opts)
+		return err
+	})
+	return n, err
+}
+
+// downloadParallel implements DownloadParallel.
+func (f *FileClient) downloadParallel(ctx context.Context, storeBox, fileName string, w io.WriterAt, opts ParallelOptions) (int64, error) {
+	if opts.PartSize <= 0 {
+		opts.PartSize = defaultParallelPartSize
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultParallelConcurrency
+	}
+
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	lb, err := f.loadBalancer()
+	if err != nil {
+		return 0, err
+	}
+
+	stat, err := loadbalancing.Execute(ctx, lb, func(client loadbalancing.Client) (filestorage.ObjectStat, error) {
+		if !servesRanges(client) {
+			return filestorage.ObjectStat{}, filestorage.ErrRangeNotSupported
+		}
+		return client.(filestorage.ObjectStater).StatObject(ctx, storeBox, fileName)
+	})
+	if errors.Is(err, filestorage.ErrRangeNotSupported) {
+		return f.downloadSequential(ctx, lb, storeBox, fileName, w)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("FileClient DownloadParallel error: %w", err)
+	}
+
+	if err := f.downloadRanges(ctx, lb, storeBox, fileName, w, stat, opts); err != nil {
+		return 0, err
+	}
+
+	return stat.Size, nil
+}
+
+// UploadParallel uploads size bytes read from r to all main storages based on the replication
+// mode, like FPutObject. Storages supporting it upload the object in concurrent parts, with a
+// multipart upload on MinIO and AWS S3 and staged blocks on Azure Blob; the part size is chosen
+// from the object size within the provider limits, starting from opts.PartSize. Storages saving
+// objects compressed or encrypted, and the other storages, read the object sequentially.
+// With SYNC_REPLICATION the call returns when every main storage is written, with
+// ASYNC_REPLICATION once the first one is. Failed multipart uploads are aborted, so that the
+// providers do not retain, and bill, their parts.
+func (f *FileClient) UploadParallel(ctx context.Context, storeBox, fileName string, r io.ReaderAt, size int64, opts ParallelOptions) error {
+	return f.intercept(ctx, OpInfo{Name: "UploadParallel", Type: WRITE_OPERATION, StoreBox: storeBox, Key: fileName, Size: size}, func(ctx context.Context) error {
+		return f.uploadParallel(ctx, storeBox, fileName, r, size, opts)
+	})
+}
+
+// uploadParallel implements UploadParallel.
+func (f *FileClient) uploadParallel(ctx context.Context, storeBox, fileName string, r io.ReaderAt, size int64, opts ParallelOptions) error {
+	if r == nil {
+		return fmt.Errorf("reader is nil")
+	}
+	if size < 0 {
+		return fmt.Errorf("invalid size %d", size)
+	}
+	if err := checkSize(size, f.maxObjectSize); err != nil {
+		return err
+	}
+
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return err
+	}
+
+	return f.replicate(ctx, &putRequest{
+		storeBox:  storeBox,
+		fileName:  fileName,
+		newReader: func() io.Reader { return io.NewSectionReader(r, 0, size) },
+		size:      size,
+		readerAt:  r,
+		parallel:  &opts,
+	})
+}
+
+// servesRanges reports whether client can stat an object and serve its ranges by
+// logical offset, which holds only when it saves objects without transforms.
+func servesRanges(client loadbalancing.Client) bool {
+	storage, ok := client.(filestorage.FileStorage)
+	if !ok {
+		return false
+	}
+	if _, ok := client.(filestorage.RangeReader); !ok {
+		return false
+	}
+	if _, ok := client.(filestorage.ObjectStater); !ok {
+		return false
+	}
+
+	return !storage.GetConnectionProperties().Transformed()
+}
+
+// downloadRanges fetches the object described by stat into w, range by range.
+// The ranges are hashed in order by the calling goroutine; a window bounds the ranges
+// fetched ahead of the hashing, so that at most 2*Concurrency of them are held in memory.
+func (f *FileClient) downloadRanges(ctx context.Context, lb loadbalancing.LoadBalancer, storeBox, fileName string, w io.WriterAt, stat filestorage.ObjectStat, opts ParallelOptions) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	parts := int((stat.Size + opts.PartSize - 1) / opts.PartSize)
+	fetched := make([]chan *bytes.Buffer, parts)
+	for i := range fetched {
+		fetched[i] = make(chan *bytes.Buffer, 1)
+	}
+	window := make(chan struct{}, 2*opts.Concurrency)
+	jobs := make(chan int)
+
+	go func() {
+		defer close(jobs)
+		for i := 0; i < parts; i++ {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for n := 0; n < opts.Concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				offset := int64(i) * opts.PartSize
+				buf, err := f.fetchRange(ctx, lb, storeBox, fileName, w, offset, min(opts.PartSize, stat.Size-offset))
+				if err != nil {
+					fail(err)
+				}
+				fetched[i] <- buf
+			}
+		}()
+	}
+
+	hash := md5.New()
+	for i := 0; i < parts; i++ {
+		var buf *bytes.Buffer
+		select {
+		case buf = <-fetched[i]:
+		case <-ctx.Done():
+		}
+		if buf == nil {
+			break
+		}
+		hash.Write(buf.Bytes())
+		bufpool.Default.Put(buf)
+		<-window
+	}
+
+	cancel()
+	wg.Wait()
+	for _, ch := range fetched {
+		select {
+		case buf := <-ch:
+			bufpool.Default.Put(buf)
+		default:
+		}
+	}
+
+	if firstErr != nil {
+		return fmt.Errorf("FileClient DownloadParallel error: %w", firstErr)
+	}
+	if err := parent.Err(); err != nil {
+		return err
+	}
+
+	if digest := md5ETag(stat.ETag); digest != "" && digest != hex.EncodeToString(hash.Sum(nil)) {
+		return fmt.Errorf("%w: ETag is %s", ErrChecksumMismatch, digest)
+	}
+
+	return nil
+}
+
+// fetchRange reads length bytes of the object starting at offset from the load-balanced
+// storages and writes them at offset in w. The bytes are returned in a pooled buffer,
+// which the caller must return to the pool.
+func (f *FileClient) fetchRange(ctx context.Context, lb loadbalancing.LoadBalancer, storeBox, fileName string, w io.WriterAt, offset, length int64) (*bytes.Buffer, error) {
+	buf, err := loadbalancing.Execute(ctx, lb, func(client loadbalancing.Client) (*bytes.Buffer, error) {
+		rr, ok := client.(filestorage.RangeReader)
+		if !ok {
+			return nil, filestorage.ErrRangeNotSupported
+		}
+		obj, err := rr.GetObjectRange(ctx, storeBox, fileName, offset, length)
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Close()
+
+		buf := bufpool.Default.Get()
+		buf.Grow(int(length))
+		if _, err := f.copy(buf, io.LimitReader(obj, length+1)); err != nil {
+			bufpool.Default.Put(buf)
+			return nil, err
+		}
+		if int64(buf.Len()) != length {
+			read := buf.Len()
+			bufpool.Default.Put(buf)
+			return nil, fmt.Errorf("read %d bytes, expected %d", read, length)
+		}
+		return buf, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download the range at offset %d: %w", offset, err)
+	}
+
+	if _, err := w.WriteAt(buf.Bytes(), offset); err != nil {
+		bufpool.Default.Put(buf)
+		return nil, fmt.Errorf("failed to write the range at offset %d: %w", offset, err)
+	}
+
+	return buf, nil
+}
+
+// downloadSequential streams the whole object into w, starting at offset 0.
+func (f *FileClient) downloadSequential(ctx context.Context, lb loadbalancing.LoadBalancer, storeBox, fileName string, w io.WriterAt) (int64, error) {
+	obj, err := lb.Apply(ctx, storeBox, fileName)
+	if err != nil {
+		return 0, fmt.Errorf("FileClient DownloadParallel error: %w", err)
+	}
+	defer obj.Close()
+
+	n, err := f.copy(io.NewOffsetWriter(w, 0), obj)
+	if err != nil {
+		return n, fmt.Errorf("failed to write object data: %w", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,120 @@
+package m2cs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// WithDirectPassthrough enables or disables the passthrough of the clients wrapping a single
+// storage, e.g. in development. It is enabled by default: when the only storage is a main one,
+// not a PRIMARY, and neither the cache and the quota of the store box, the audit, the retries,
+// the maximum object size, the shadow reads nor soft-delete are configured, PutObject, GetObject
+// and RemoveObject call the storage directly. The payload of PutObject is then passed to the
+// storage as is, without being buffered, and GetObject returns the reader of the storage, which
+// does not hold the object in memory. The errors are wrapped as on the full path.
+// WithDirectPassthrough(false) forces the full path, e.g. for the readers of GetObject to
+// implement io.Seeker and io.ReaderAt.
+func WithDirectPassthrough(enabled bool) FileClientOption {
+	return func(f *FileClient) error {
+		f.noPassthrough = !enabled
+		return nil
+	}
+}
+
+// passthrough returns the storage the operations on storeBox, as stored, are delegated to, see
+// WithDirectPassthrough, or nil when they take the full path.
+func (f *FileClient) passthrough(storeBox string) filestorage.FileStorage {
+	if f.noPassthrough || len(f.storages) != 1 {
+		return nil
+	}
+	if f.audit != nil || f.retryPolicies != nil || f.maxObjectSize > 0 || f.shadow != nil || f.softDelete.Enabled {
+		return nil
+	}
+	if _, cached := f.cachePolicy(storeBox); cached {
+		return nil
+	}
+	if f.quota(storeBox) != nil {
+		return nil
+	}
+
+	// a primary fails the writes with ErrPrimaryUnavailable
+	s := f.storages[0]
+	if !s.GetConnectionProperties().IsMainInstance || f.primaryMain() >= 0 {
+		return nil
+	}
+	return s
+}
+
+// putDirect writes an object on the storage of the passthrough, failing like replicate.
+func (f *FileClient) putDirect(ctx context.Context, s filestorage.FileStorage, storeBox, fileName string, reader io.Reader) error {
+	release, err := f.acquireWrite(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if f.replicationMode == ASYNC_REPLICATION {
+		if err := s.PutObject(ctx, storeBox, fileName, reader); err != nil {
+			return fmt.Errorf("[async] PutObject failed on all main storages")
+		}
+		f.lag.record(s, storeBox, fileName, nil)
+		return nil
+	}
+
+	// like forEachStorage, the storage is not called once ctx is done
+	if err = ctx.Err(); err != nil {
+		err = fmt.Errorf("not attempted: %w", err)
+	} else {
+		err = s.PutObject(ctx, storeBox, fileName, reader)
+	}
+	if err == nil {
+		f.lag.record(s, storeBox, fileName, nil)
+		return nil
+	}
+	return fmt.Errorf("[sync] PutObject failed on all 1 storages: %w", errors.Join(fmt.Errorf("[sync] PutObject failed on %T: %w", s, err)))
+}
+
+// getDirect reads an object from the storage of the passthrough, failing like the load balancers.
+func (f *FileClient) getDirect(ctx context.Context, s filestorage.FileStorage, storeBox, fileName string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("FileClient GetObject error: all clients failed to get the object: %w", err)
+	}
+	obj, err := s.GetObject(ctx, storeBox, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("FileClient GetObject error: all clients failed to get the object: %w", errors.Join(fmt.Errorf("client#0: %w", err)))
+	}
+	return obj, nil
+}
+
+// removeDirect removes an object from the storage of the passthrough, failing like onMainStorages.
+func (f *FileClient) removeDirect(ctx context.Context, s filestorage.FileStorage, storeBox, fileName string) error {
+	err := ctx.Err()
+	if err != nil {
+		err = fmt.Errorf("not attempted: %w", err)
+	} else {
+		err = s.RemoveObject(ctx, storeBox, fileName)
+	}
+	if err != nil {
+		failure := &StorageError{Op: "RemoveObject", Label: storageLabel(s), Err: err}
+		return fmt.Errorf("RemoveObject failed on all main storages: %w", joinStorageErrors([]*StorageError{failure}))
+	}
+	return nil
+}
+
+// putPassthrough implements PutObject, writing through the passthrough when it applies.
+func (f *FileClient) putPassthrough(ctx context.Context, storeBox, fileName string, reader io.Reader) error {
+	if reader != nil {
+		box, key, err := f.scope(storeBox, fileName)
+		if err != nil {
+			return err
+		}
+		if s := f.passthrough(box); s != nil {
+			return f.putDirect(ctx, s, box, key, reader)
+		}
+	}
+	return f.putObject(ctx, storeBox, fileName, reader, PutOptions{})
+}
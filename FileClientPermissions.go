@@ -0,0 +1,189 @@
+package m2cs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// Operations checked by CheckPermissions, in the order they are run.
+const (
+	PermissionPut    = "put"
+	PermissionGet    = "get"
+	PermissionStat   = "stat"
+	PermissionList   = "list"
+	PermissionDelete = "delete"
+)
+
+// Outcomes of the operations checked by CheckPermissions, see PermissionCheck.
+const (
+	PermissionGranted     = "granted"
+	PermissionDenied      = "denied"
+	PermissionUnsupported = "unsupported"
+	PermissionFailed      = "failed"
+)
+
+// permissionSentinel is the content of the temporary object written by CheckPermissions.
+var permissionSentinel = []byte("m2cs permission check")
+
+// PermissionCheck is the outcome of an operation checked by CheckPermissions on a storage.
+type PermissionCheck struct {
+	Operation string // "put", "get", "stat", "list" or "delete"
+	Outcome   string // "granted", "denied", "unsupported" or "failed"
+	Err       error  // Failure of the operation, when denied or failed
+}
+
+// StoragePermissions is the outcome of CheckPermissions on a storage.
+type StoragePermissions struct {
+	Label    string
+	Checks   []PermissionCheck // One per operation, in the order they are run
+	Leftover bool              // The temporary object was written, but could not be removed
+}
+
+// Granted reports whether the storage granted the operation op, e.g. PermissionPut.
+func (p StoragePermissions) Granted(op string) bool {
+	for _, c := range p.Checks {
+		if c.Operation == op {
+			return c.Outcome == PermissionGranted
+		}
+	}
+	return false
+}
+
+// PermissionReport summarizes the outcome of a CheckPermissions call.
+type PermissionReport struct {
+	StoreBox string               // Store box checked, as stored
+	Key      string               // Key of the temporary object, as stored
+	Storages []StoragePermissions // Outcome on every storage, in configuration order
+}
+
+// Granted reports whether every storage granted every operation it supports.
+func (r PermissionReport) Granted() bool {
+	for _, s := range r.Storages {
+		if s.Leftover {
+			return false
+		}
+		for _, c := range s.Checks {
+			if c.Outcome != PermissionGranted && c.Outcome != PermissionUnsupported {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// CheckPermissions checks which operations the credentials of every storage, main storages and
+// replicas alike, are allowed to run on storeBox, e.g. in a startup probe, so that a
+// misconfigured policy is reported upfront instead of as runtime failures. A temporary object
+// with a random key is written, read, stated, listed and removed on every storage, directly,
+// without going through the cache, the interceptors and the retries. An operation refused by the
+// provider with an access denied error, or by a read-only storage (see filestorage.IsAccessDenied),
+// is reported as denied, one failing otherwise as failed, and the stat and the list are reported
+// as unsupported on the storages without them; the failures are reported, they do not fail the
+// check. When the object cannot be written, the read and the stat are granted if they report it
+// missing. The removal is granted only once the object is gone, as some providers accept it
+// without removing the object; an object left behind is reported with StoragePermissions.Leftover.
+// The storages are checked concurrently, see WithMaxStorageConcurrency. An error is returned only
+// when storeBox is invalid or ctx is done, along with the report of the operations run so far.
+func (f *FileClient) CheckPermissions(ctx context.Context, storeBox string) (PermissionReport, error) {
+	var suffix [8]byte
+	_, _ = rand.Read(suffix[:])
+	storeBox, key, err := f.scope(storeBox, "m2cs-permission-check-"+hex.EncodeToString(suffix[:]))
+	if err != nil {
+		return PermissionReport{}, err
+	}
+
+	report := PermissionReport{StoreBox: storeBox, Key: key, Storages: make([]StoragePermissions, len(f.storages))}
+	for i, s := range f.storages {
+		report.Storages[i].Label = storageLabel(s)
+	}
+	f.forEachStorage(ctx, f.storages, func(i int, s filestorage.FileStorage) error {
+		report.Storages[i] = checkPermissions(ctx, s, storeBox, key)
+		return nil
+	})
+
+	return report, ctx.Err()
+}
+
+// checkPermissions runs the operations checked by CheckPermissions on s, stopping once ctx is done.
+func checkPermissions(ctx context.Context, s filestorage.FileStorage, storeBox, key string) StoragePermissions {
+	permissions := StoragePermissions{Label: storageLabel(s)}
+	check := func(op string, run func() error) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		c := PermissionCheck{Operation: op, Outcome: PermissionGranted}
+		switch err := run(); {
+		case errors.Is(err, errPermissionUnsupported):
+			c.Outcome = PermissionUnsupported
+		case filestorage.IsAccessDenied(err):
+			c.Outcome, c.Err = PermissionDenied, err
+		case err != nil:
+			c.Outcome, c.Err = PermissionFailed, err
+		}
+		permissions.Checks = append(permissions.Checks, c)
+		return c.Outcome == PermissionGranted
+	}
+
+	written := check(PermissionPut, func() error {
+		return s.PutObject(ctx, storeBox, key, bytes.NewReader(permissionSentinel))
+	})
+
+	// without the object, the reads are granted when they report it missing
+	missing := func(err error) error {
+		if !written && errors.Is(err, filestorage.ErrObjectNotFound) {
+			return nil
+		}
+		return err
+	}
+	check(PermissionGet, func() error {
+		obj, err := s.GetObject(ctx, storeBox, key)
+		if err != nil {
+			return missing(err)
+		}
+		defer obj.Close()
+		_, err = io.Copy(io.Discard, obj)
+		return err
+	})
+	check(PermissionStat, func() error {
+		stater, ok := s.(filestorage.ObjectStater)
+		if !ok {
+			return errPermissionUnsupported
+		}
+		_, err := stater.StatObject(ctx, storeBox, key)
+		return missing(err)
+	})
+	check(PermissionList, func() error {
+		lister, ok := s.(filestorage.ObjectLister)
+		if !ok {
+			return errPermissionUnsupported
+		}
+		_, err := lister.ListObjectsInfo(ctx, storeBox, key)
+		return err
+	})
+	removed := check(PermissionDelete, func() error {
+		if err := s.RemoveObject(ctx, storeBox, key); err != nil && !errors.Is(err, filestorage.ErrObjectNotFound) {
+			return err
+		}
+		if !written {
+			return nil
+		}
+		if exists, err := s.ExistObject(ctx, storeBox, key); err == nil && exists {
+			return fmt.Errorf("%w: object still present once removed", filestorage.ErrAccessDenied)
+		}
+		return nil
+	})
+	permissions.Leftover = written && !removed
+
+	return permissions
+}
+
+// errPermissionUnsupported is reported by the operations checked by CheckPermissions that the
+// storage does not implement.
+var errPermissionUnsupported = errors.New("operation not supported by the storage")
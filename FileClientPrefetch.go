@@ -0,0 +1,247 @@
+package m2cs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/internal/loadbalancing"
+)
+
+// Default concurrency of Prefetch.
+const defaultPrefetchConcurrency = 4
+
+// Outcomes of the keys of a Prefetch call, see PrefetchResult.
+const (
+	PrefetchCached       = "cached"
+	PrefetchTooLarge     = "too-large"
+	PrefetchCacheFull    = "cache-full"
+	PrefetchFailed       = "failed"
+	PrefetchNotAttempted = "not-attempted"
+	PrefetchBypassed     = "bypassed"
+)
+
+// PrefetchResult is the outcome of a key of a Prefetch call.
+type PrefetchResult struct {
+	Key     string // Key of the object, as given by the caller
+	Outcome string // "cached", "too-large", "cache-full", "failed", "not-attempted" or "bypassed"
+	Size    int64  // Bytes stored in the cache, when cached
+	Err     error  // Why the object could not be fetched, when failed or not attempted
+}
+
+// PrefetchReport summarizes the outcome of a Prefetch call.
+type PrefetchReport struct {
+	Results []PrefetchResult // Outcome of every key, in the order of the keys
+	Cached  int              // Objects stored in the cache
+	Bytes   int64            // Bytes stored in the cache
+	Skipped int              // Objects too large for the cache, left out once it was full, or bypassing it
+	Failed  int              // Objects that could not be fetched, or were not attempted
+}
+
+// Prefetch warms up the cache with the objects of storeBox named by keys, e.g. before an expected
+// spike of reads, fetching them through the load balancer with opts.Concurrency workers. Objects
+// are fetched even when cached, which renews their TTL, and bypass the admission filter of the
+// cache. Objects larger than the MaxItemSizeMB of the cache are skipped; the prefetched objects
+// fill at most MaxSizeMB bytes and MaxItems items, so that they do not evict each other, and the
+// keys left once the cache is full are skipped. Once ctx is done, the keys not fetched yet are not
+// attempted and the context error is returned along with the report. Fetch failures do not stop
+// the prefetch, and are returned together. When a CacheRule disables the cache for storeBox, no
+// object is fetched and every key is reported as bypassed; the TTL and the MaxItemSizeMB of the
+// matching rule apply otherwise.
+func (f *FileClient) Prefetch(ctx context.Context, storeBox string, keys []string, opts PrefetchOptions) (PrefetchReport, error) {
+	report := PrefetchReport{Results: make([]PrefetchResult, len(keys))}
+	for i, key := range keys {
+		report.Results[i].Key = key
+	}
+
+	if state := f.CacheState(); state != CACHE_ENABLED {
+		return report, &CacheStateError{Op: "Prefetch", State: state}
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultPrefetchConcurrency
+	}
+
+	lb, err := f.loadBalancer()
+	if err != nil {
+		return report, err
+	}
+
+	// the objects of a box for which the rules disable the cache are not fetched
+	if scoped, err := f.scopeBox(storeBox); err == nil {
+		if _, enabled := f.cachePolicy(scoped); !enabled {
+			for i := range report.Results {
+				report.Results[i].Outcome = PrefetchBypassed
+			}
+			report.Skipped = len(keys)
+			return report, nil
+		}
+	}
+
+	budget := &prefetchBudget{
+		bytes: f.cache.Options.MaxSizeMB << 20,
+		items: f.cache.Options.MaxItems,
+	}
+	sem := make(chan struct{}, opts.Concurrency)
+
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		err := ctx.Err()
+		if err == nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		if err != nil {
+			for j := i; j < len(keys); j++ {
+				report.Results[j].Outcome = PrefetchNotAttempted
+				report.Results[j].Err = err
+			}
+			break
+		}
+
+		if !budget.reserve() {
+			// the objects in flight may not be stored, freeing their slots
+			wg.Wait()
+			if !budget.reserve() {
+				<-sem
+				for j := i; j < len(keys); j++ {
+					report.Results[j].Outcome = PrefetchCacheFull
+				}
+				break
+			}
+		}
+
+		wg.Add(1)
+		i, key := i, key
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			report.Results[i] = f.prefetchObject(ctx, lb, storeBox, key, budget)
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, result := range report.Results {
+		switch result.Outcome {
+		case PrefetchCached:
+			report.Cached++
+			report.Bytes += result.Size
+		case PrefetchTooLarge, PrefetchCacheFull:
+			report.Skipped++
+		case PrefetchFailed:
+			report.Failed++
+			errs = append(errs, fmt.Errorf("%s: %w", result.Key, result.Err))
+		case PrefetchNotAttempted:
+			report.Failed++
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	if len(errs) > 0 {
+		return report, fmt.Errorf("FileClient Prefetch failed for %d/%d objects: %w", len(errs), len(keys), errors.Join(errs...))
+	}
+	return report, nil
+}
+
+// prefetchObject fetches an object through lb and stores it in the cache, within the budget.
+// The caller must have reserved an item of the budget, which is released unless the object is stored.
+func (f *FileClient) prefetchObject(ctx context.Context, lb loadbalancing.LoadBalancer, storeBox, fileName string, budget *prefetchBudget) PrefetchResult {
+	result := PrefetchResult{Key: fileName, Outcome: PrefetchFailed}
+	stored := false
+	defer func() {
+		if !stored {
+			budget.release()
+		}
+	}()
+
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	obj, err := lb.Apply(ctx, storeBox, fileName)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer obj.Close()
+
+	policy, _ := f.cachePolicy(storeBox)
+
+	// one byte past the limit tells an object of exactly the maximum size from a larger one
+	maxSize := policy.maxItemBytes
+	data, err := io.ReadAll(io.LimitReader(obj, maxSize+1))
+	if err != nil {
+		result.Err = fmt.Errorf("failed to read object data: %w", err)
+		return result
+	}
+	if int64(len(data)) > maxSize {
+		result.Outcome = PrefetchTooLarge
+		return result
+	}
+	if !budget.spend(int64(len(data))) {
+		result.Outcome = PrefetchCacheFull
+		return result
+	}
+
+	if f.backend != nil {
+		if err := f.backend.Set(ctx, storeBox+"/"+fileName, data, policy.ttl); err != nil {
+			result.Err = fmt.Errorf("failed to store the object in the cache backend: %w", err)
+			return result
+		}
+	} else {
+		f.cache.PreloadWithTTL(storeBox+"/"+fileName, data, policy.ttl)
+	}
+	stored = true
+	result.Outcome = PrefetchCached
+	result.Size = int64(len(data))
+	return result
+}
+
+// prefetchBudget tracks the bytes and items left to a Prefetch call. Items are reserved before
+// fetching, so that no more objects than the cache holds are fetched, and bytes once read.
+// Once an object does not fit in the bytes left, the budget is full and no item can be reserved.
+type prefetchBudget struct {
+	mu    sync.Mutex
+	bytes int64
+	items int
+	full  bool
+}
+
+// reserve takes an item, reporting false when none is left.
+func (b *prefetchBudget) reserve() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.full || b.items <= 0 {
+		return false
+	}
+	b.items--
+	return true
+}
+
+// release gives back an item taken by reserve.
+func (b *prefetchBudget) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.items++
+}
+
+// spend takes n bytes, reporting false when fewer are left.
+func (b *prefetchBudget) spend(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > b.bytes {
+		b.full = true
+		return false
+	}
+	b.bytes -= n
+	return true
+}
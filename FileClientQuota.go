@@ -0,0 +1,210 @@
+package m2cs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// BoxUsage is the usage of a store box with a quota, see ConfigureQuota.
+type BoxUsage struct {
+	Bytes      int64 // Bytes of the objects of the box
+	Objects    int64 // Number of objects of the box
+	MaxBytes   int64 // Maximum number of bytes, unlimited when 0
+	MaxObjects int64 // Maximum number of objects, unlimited when 0
+}
+
+// ConfigureQuota sets a budget on storeBox, e.g. to enforce the storage budget of a tenant:
+// the writes that would take the box beyond maxBytes bytes or maxObjects objects fail with
+// ErrQuotaExceeded before any storage is written. A limit of 0 leaves that dimension unlimited.
+// The usage is tracked from the successful writes and removals made through the client, counting
+// the size of the payloads before compression and encryption; call InitializeUsageFromListing to
+// seed it with the objects already stored. Calling ConfigureQuota again changes the limits and
+// keeps the usage. The quota is advisory: the writes and removals made by other processes, or
+// by other clients, are not seen until the next InitializeUsageFromListing.
+func (f *FileClient) ConfigureQuota(storeBox string, maxBytes, maxObjects int64) error {
+	if maxBytes < 0 || maxObjects < 0 {
+		return fmt.Errorf("quota limits must not be negative, got %d bytes and %d objects", maxBytes, maxObjects)
+	}
+	storeBox, err := f.scopeBox(storeBox)
+	if err != nil {
+		return err
+	}
+
+	f.quotaMu.Lock()
+	defer f.quotaMu.Unlock()
+	if f.quotas == nil {
+		f.quotas = make(map[string]*boxQuota)
+	}
+	q, ok := f.quotas[storeBox]
+	if !ok {
+		q = &boxQuota{sizes: make(map[string]int64)}
+		f.quotas[storeBox] = q
+	}
+	q.mu.Lock()
+	q.maxBytes, q.maxObjects = maxBytes, maxObjects
+	q.mu.Unlock()
+	return nil
+}
+
+// Usage returns the usage of storeBox, reporting false when the box has no quota.
+func (f *FileClient) Usage(storeBox string) (BoxUsage, bool) {
+	storeBox, err := f.scopeBox(storeBox)
+	if err != nil {
+		return BoxUsage{}, false
+	}
+	q := f.quota(storeBox)
+	if q == nil {
+		return BoxUsage{}, false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return BoxUsage{Bytes: q.bytes, Objects: q.objects, MaxBytes: q.maxBytes, MaxObjects: q.maxObjects}, true
+}
+
+// InitializeUsageFromListing replaces the usage of storeBox, which must have a quota, with the
+// objects listed on the PRIMARY storage, or on the first main storage able to list. A client
+// scoped with WithKeyPrefix only counts the objects of its namespace. The listed sizes are the
+// stored ones, which differ from the sizes of the payloads on storages saving objects compressed
+// or encrypted. It can be called at any time, e.g. periodically, to recalculate the usage.
+func (f *FileClient) InitializeUsageFromListing(ctx context.Context, storeBox string) error {
+	storeBox, err := f.scopeBox(storeBox)
+	if err != nil {
+		return err
+	}
+	q := f.quota(storeBox)
+	if q == nil {
+		return fmt.Errorf("store box %s has no quota", storeBox)
+	}
+
+	lister, label, err := f.usageLister()
+	if err != nil {
+		return err
+	}
+	objects, err := lister.ListObjectsInfo(ctx, storeBox, f.keyPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list storage %s: %w", label, err)
+	}
+
+	sizes := make(map[string]int64, len(objects))
+	var bytes int64
+	for _, obj := range objects {
+		if _, ok := f.unscopeKey(obj.Key); ok {
+			sizes[obj.Key] = obj.Size
+			bytes += obj.Size
+		}
+	}
+
+	q.mu.Lock()
+	q.sizes, q.bytes, q.objects = sizes, bytes, int64(len(sizes))
+	q.mu.Unlock()
+	return nil
+}
+
+// usageLister returns the storage whose listing seeds the usage of the boxes.
+func (f *FileClient) usageLister() (filestorage.ObjectLister, string, error) {
+	mains := f.mainStorages()
+	if primary := f.primaryMain(); primary >= 0 {
+		mains = append([]filestorage.FileStorage{mains[primary]}, mains...)
+	}
+	for _, s := range mains {
+		if lister, ok := s.(filestorage.ObjectLister); ok {
+			return lister, storageLabel(s), nil
+		}
+	}
+	return nil, "", errors.New("no main storage supports listing")
+}
+
+// quota returns the quota of a store box, as stored, or nil.
+func (f *FileClient) quota(storeBox string) *boxQuota {
+	f.quotaMu.RLock()
+	defer f.quotaMu.RUnlock()
+	return f.quotas[storeBox]
+}
+
+// reserveQuota reserves the budget of a write of size bytes, failing with ErrQuotaExceeded when
+// the box has not enough budget left. The returned function must be called once the write
+// completes, reporting whether it succeeded, to release the reservation and update the usage.
+func (f *FileClient) reserveQuota(storeBox, fileName string, size int64) (func(ok bool), error) {
+	q := f.quota(storeBox)
+	if q == nil {
+		return func(bool) {}, nil
+	}
+	return q.reserve(storeBox, fileName, size)
+}
+
+// removeQuota updates the usage of a store box after the removal of an object.
+func (f *FileClient) removeQuota(storeBox, fileName string) {
+	if q := f.quota(storeBox); q != nil {
+		q.remove(fileName)
+	}
+}
+
+// boxQuota tracks the usage of a store box against its limits. Writes in flight hold a
+// reservation, so that concurrent writes cannot exceed the limits together.
+type boxQuota struct {
+	mu              sync.Mutex
+	maxBytes        int64
+	maxObjects      int64
+	bytes           int64
+	objects         int64
+	reservedBytes   int64
+	reservedObjects int64
+	sizes           map[string]int64 // Size of each object, by stored key
+}
+
+// reserve reserves the budget of a write of size bytes on fileName, taking into account the
+// object it replaces, if any.
+func (q *boxQuota) reserve(storeBox, fileName string, size int64) (func(ok bool), error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	old, exists := q.sizes[fileName]
+	bytes := max(size-old, 0)
+	var objects int64
+	if !exists {
+		objects = 1
+	}
+
+	if q.maxBytes > 0 && q.bytes+q.reservedBytes+bytes > q.maxBytes {
+		return nil, fmt.Errorf("%w: writing %d bytes to %s would use %d bytes, the limit is %d",
+			ErrQuotaExceeded, size, storeBox, q.bytes+q.reservedBytes+bytes, q.maxBytes)
+	}
+	if q.maxObjects > 0 && q.objects+q.reservedObjects+objects > q.maxObjects {
+		return nil, fmt.Errorf("%w: writing %s to %s would make %d objects, the limit is %d",
+			ErrQuotaExceeded, fileName, storeBox, q.objects+q.reservedObjects+objects, q.maxObjects)
+	}
+	q.reservedBytes += bytes
+	q.reservedObjects += objects
+
+	return func(ok bool) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.reservedBytes -= bytes
+		q.reservedObjects -= objects
+		if !ok {
+			return
+		}
+		old, exists := q.sizes[fileName]
+		q.bytes += size - old
+		if !exists {
+			q.objects++
+		}
+		q.sizes[fileName] = size
+	}, nil
+}
+
+// remove releases the budget used by fileName, if known.
+func (q *boxQuota) remove(fileName string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if size, ok := q.sizes[fileName]; ok {
+		q.bytes -= size
+		q.objects--
+		delete(q.sizes, fileName)
+	}
+}
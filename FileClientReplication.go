@@ -0,0 +1,109 @@
+package m2cs
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/tizianocitro/m2cs/internal/clock"
+	"github.com/tizianocitro/m2cs/internal/replication"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// WithReplicationWorkers bounds the background replications of an ASYNC_REPLICATION client running
+// at the same time, unbounded by default; the other ones wait for a worker, and count in the
+// backlog reported by ReplicationStatus. Whatever the workers, the background replications of the
+// same store box and key run one at a time, in the order of their writes.
+func WithReplicationWorkers(n int) FileClientOption {
+	return func(f *FileClient) error {
+		if n <= 0 {
+			return fmt.Errorf("replication workers must be positive, got %d", n)
+		}
+		f.replicationWorkers = n
+		return nil
+	}
+}
+
+// replicator returns the engine of the background replications of the client, created on first
+// use so that it is configured by all the options.
+func (f *FileClient) replicator() *replication.Engine {
+	f.replicatorOnce.Do(func() {
+		var queue replication.Queue
+		if f.journal != nil {
+			queue = f.journal
+		}
+		f.engine = replication.New(replication.Options{
+			Workers:           f.replicationWorkers,
+			TargetConcurrency: f.storageConcurrency,
+			Queue:             queue,
+			Copy:              f.copyTarget,
+			Retry: func(ctx context.Context, write func() error) error {
+				return f.retry(ctx, WRITE_OPERATION, write)
+			},
+			Clock: f.clock,
+		})
+	})
+	return f.engine
+}
+
+// fanOut replicates in background the write of the request, already written to src, to targets,
+// indexes holding their indexes among the main storages for the progress reporting. The request
+// is finished once every target has been written.
+func (f *FileClient) fanOut(ctx context.Context, req *putRequest, src filestorage.FileStorage, targets []filestorage.FileStorage, indexes []int) {
+	if len(targets) == 0 {
+		req.finish()
+		return
+	}
+
+	storeBox, fileName := req.storeBox, req.fileName
+	f.lag.start(targets, storeBox, fileName)
+	background := f.startBackground()
+	t := &replication.Task{
+		StoreBox: storeBox,
+		Key:      fileName,
+		Source:   storageLabel(src),
+		Targets:  storageLabels(targets),
+		Created:  clock.Or(f.clock).Now().UTC(),
+		Write: func(ctx context.Context, i int) error {
+			return f.throttles.do(ctx, targets[i], func() error {
+				return req.put(ctx, indexes[i], targets[i])
+			})
+		},
+		Written: func(i int, err error) {
+			f.lag.finish(targets[i], storeBox, fileName, err)
+			if err != nil {
+				log.Printf("[async] PutObject failed on %T: %v", targets[i], err)
+			}
+		},
+		Done: func([]error) {
+			req.finish()
+			background()
+		},
+	}
+	if err := f.replicator().Enqueue(detach(ctx), t); err != nil {
+		log.Printf("[journal] %s/%s: %v", storeBox, fileName, err)
+	}
+}
+
+// copyTarget copies the object of a task from its source to its i-th target.
+func (f *FileClient) copyTarget(ctx context.Context, t *replication.Task, i int) error {
+	src := f.storageByLabel(t.Source)
+	if src == nil {
+		return fmt.Errorf("no storage labeled %q found", t.Source)
+	}
+	dst := f.storageByLabel(t.Targets[i])
+	if dst == nil {
+		return fmt.Errorf("no storage labeled %q found", t.Targets[i])
+	}
+	return copyObject(ctx, src, dst, t.StoreBox, t.Key)
+}
+
+// storageByLabel returns the storage of the client with the given label, or nil.
+func (f *FileClient) storageByLabel(label string) filestorage.FileStorage {
+	for _, s := range f.storages {
+		if storageLabel(s) == label {
+			return s
+		}
+	}
+	return nil
+}
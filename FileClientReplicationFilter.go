@@ -0,0 +1,40 @@
+package m2cs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReplicationFilter decides whether a write is replicated to all the main storages, returning
+// true, or kept on the primary only, returning false, see WithReplicationFilter. It receives the
+// store box and the key as given by the caller.
+type ReplicationFilter func(storeBox, key string, opts PutOptions) bool
+
+// WithReplicationFilter evaluates filter on every write, e.g. to keep temporary files and
+// thumbnails off the other clouds:
+//
+//	m2cs.WithReplicationFilter(func(storeBox, key string, _ m2cs.PutOptions) bool {
+//		return !strings.HasPrefix(key, "tmp/")
+//	})
+//
+// The writes the filter rejects are written to the PRIMARY storage or, without one, to the first
+// main storage accepting them, like the first write of ASYNC_REPLICATION, and are neither fanned
+// out nor journaled, whatever the replication mode. PutObjectWithReport reports them as Local.
+func WithReplicationFilter(filter ReplicationFilter) FileClientOption {
+	return func(f *FileClient) error {
+		if filter == nil {
+			return fmt.Errorf("replication filter is nil")
+		}
+		f.replicationFilter = filter
+		return nil
+	}
+}
+
+// replicates reports whether the write of the request is replicated to all the main storages.
+func (f *FileClient) replicates(req *putRequest) bool {
+	if f.replicationFilter == nil {
+		return true
+	}
+	key, _ := f.unscopeKey(req.fileName)
+	return f.replicationFilter(strings.TrimPrefix(req.storeBox, f.boxPrefix), key, req.opts)
+}
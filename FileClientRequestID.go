@@ -0,0 +1,66 @@
+package m2cs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tizianocitro/m2cs/internal/connection"
+)
+
+// WithRequestIDHeader attaches a value taken from the context of the operations, e.g. the request
+// ID set by the caller's platform, as the header headerName of the requests sent by the storages,
+// so that it shows up in the access logs of the providers: through the transport of the MinIO
+// client, a middleware of the AWS S3 client and a per-call policy of the Azure Blob client. The
+// header is attached by the storages created by the connections of this package, to the requests
+// of the operations run through the interceptors (see WithInterceptors) and of the background
+// replications they start. extractor is called with the context passed by the innermost
+// interceptor, so that an interceptor may set the request ID; an empty value attaches nothing.
+// MinIO requests are signed before the header is attached, so headerName must not start with
+// "X-Amz-".
+func WithRequestIDHeader(headerName string, extractor func(ctx context.Context) string) FileClientOption {
+	return func(f *FileClient) error {
+		if !validHeaderName(headerName) {
+			return fmt.Errorf("invalid request ID header name %q", headerName)
+		}
+		if extractor == nil {
+			return fmt.Errorf("request ID extractor is nil")
+		}
+		f.requestIDHeader, f.requestID = headerName, extractor
+		return nil
+	}
+}
+
+// validHeaderName reports whether name is a valid HTTP header name, i.e. a non-empty token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c >= 0x7f || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID returns ctx carrying the request ID header, see WithRequestIDHeader, or ctx
+// itself when it is not configured or the value is empty.
+func (f *FileClient) withRequestID(ctx context.Context) context.Context {
+	if f.requestID == nil {
+		return ctx
+	}
+	if value := f.requestID(ctx); value != "" {
+		return connection.WithRequestHeader(ctx, f.requestIDHeader, value)
+	}
+	return ctx
+}
+
+// detach returns a context for the background work started by an operation running with ctx: it
+// is never canceled, and only carries the request ID header of ctx.
+func detach(ctx context.Context) context.Context {
+	if name, value := connection.RequestHeader(ctx); name != "" {
+		return connection.WithRequestHeader(context.Background(), name, value)
+	}
+	return context.Background()
+}
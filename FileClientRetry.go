@@ -0,0 +1,157 @@
+package m2cs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/tizianocitro/m2cs/internal/clock"
+	"github.com/tizianocitro/m2cs/internal/loadbalancing"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// Defaults of RetryPolicy.
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseDelay   = 100 * time.Millisecond
+	defaultRetryMaxDelay    = 5 * time.Second
+)
+
+// OperationType identifies the operations a RetryPolicy applies to.
+// READ_OPERATION covers the reads of objects and of their attributes through the load balancer,
+// WRITE_OPERATION the writes of objects on the main storages, REMOVE_OPERATION the deletions of
+// objects on the main storages and EXIST_OPERATION the existence checks.
+type OperationType int
+
+const (
+	READ_OPERATION OperationType = iota
+	WRITE_OPERATION
+	REMOVE_OPERATION
+	EXIST_OPERATION
+)
+
+// String returns the name of the operation type.
+func (o OperationType) String() string {
+	switch o {
+	case READ_OPERATION:
+		return "READ_OPERATION"
+	case WRITE_OPERATION:
+		return "WRITE_OPERATION"
+	case REMOVE_OPERATION:
+		return "REMOVE_OPERATION"
+	case EXIST_OPERATION:
+		return "EXIST_OPERATION"
+	default:
+		return fmt.Sprintf("OperationType(%d)", int(o))
+	}
+}
+
+// WithRetryPolicy retries the failures of the given operation types, or of all of them when none
+// is given, on top of the retries of the provider SDKs. A failed operation is retried on the same
+// storage, up to MaxAttempts attempts, when RetryOn reports its failure as transient, waiting an
+// exponential delay with jitter between the attempts: the storage is declared failed only once
+// its attempts are exhausted, so that reads fall back to the next storage and replicated writes
+// count it as failed only then. A throttled storage, see filestorage.IsThrottled, is retried the
+// same way, waiting at least the Retry-After delay it asks for, up to MaxDelay, rather than being
+// treated as down, while its concurrent writes are narrowed, see WriteConcurrencyLimits. Waiting stops when the context of the operation is done.
+// Options given later override the policy of the same operation types.
+func WithRetryPolicy(policy RetryPolicy, ops ...OperationType) FileClientOption {
+	return func(f *FileClient) error {
+		if policy.MaxAttempts < 0 || policy.BaseDelay < 0 || policy.MaxDelay < 0 {
+			return fmt.Errorf("retry policy attempts and delays must not be negative")
+		}
+		if policy.MaxAttempts == 0 {
+			policy.MaxAttempts = defaultRetryMaxAttempts
+		}
+		if policy.BaseDelay == 0 {
+			policy.BaseDelay = defaultRetryBaseDelay
+		}
+		if policy.MaxDelay == 0 {
+			policy.MaxDelay = max(defaultRetryMaxDelay, policy.BaseDelay)
+		}
+		if policy.BaseDelay > policy.MaxDelay {
+			return fmt.Errorf("retry policy BaseDelay %v exceeds MaxDelay %v", policy.BaseDelay, policy.MaxDelay)
+		}
+		if policy.RetryOn == nil {
+			policy.RetryOn = filestorage.IsRetriable
+		}
+
+		if len(ops) == 0 {
+			ops = []OperationType{READ_OPERATION, WRITE_OPERATION, REMOVE_OPERATION, EXIST_OPERATION}
+		}
+		for _, op := range ops {
+			if op < READ_OPERATION || op > EXIST_OPERATION {
+				return fmt.Errorf("unknown operation type %v", op)
+			}
+			if f.retryPolicies == nil {
+				f.retryPolicies = make(map[OperationType]RetryPolicy)
+			}
+			f.retryPolicies[op] = policy
+		}
+		return nil
+	}
+}
+
+// retry runs an attempt of an operation of type op on a single storage, retrying it with the
+// retry policy of op, if any.
+func (f *FileClient) retry(ctx context.Context, op OperationType, attempt func() error) error {
+	policy, ok := f.retryPolicies[op]
+	if !ok {
+		return attempt()
+	}
+	return policy.run(ctx, clock.Or(f.clock), attempt)
+}
+
+// balancer returns the load balancer of the client, retrying the failures of each storage with
+// the retry policy of op, if any.
+func (f *FileClient) balancer(op OperationType) (loadbalancing.LoadBalancer, error) {
+	if f.lb == nil {
+		lb, err := NewLoadBalancer(f.lbStrategy, f.storages...)
+		if err != nil {
+			return nil, err
+		}
+		f.lb = lb
+	}
+
+	policy, ok := f.retryPolicies[op]
+	if !ok {
+		return f.lb, nil
+	}
+	return loadbalancing.WithRetry(f.lb, func(ctx context.Context, attempt func() error) error {
+		return policy.run(ctx, clock.Or(f.clock), attempt)
+	}), nil
+}
+
+// run runs attempt, waiting on clk between the attempts, until it succeeds, fails with an error not retried or exhausts the attempts,
+// and returns the last failure. The delays grow exponentially up to MaxDelay, each one drawn
+// between half and all of its nominal value, so that the clients failing together spread out;
+// a throttled attempt waits at least the delay asked by the storage, still up to MaxDelay. The
+// failure of attempts exhausted by throttling wraps filestorage.ErrThrottled.
+func (p RetryPolicy) run(ctx context.Context, clk clock.Clock, attempt func() error) error {
+	delay := p.BaseDelay
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || !p.RetryOn(err) {
+			return err
+		}
+		if n >= p.MaxAttempts {
+			if filestorage.IsThrottled(err) && !errors.Is(err, filestorage.ErrThrottled) {
+				err = &filestorage.ThrottledError{Err: err}
+			}
+			return fmt.Errorf("failed after %d attempts: %w", n, err)
+		}
+
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay-delay/2)+1))
+		if after, ok := filestorage.RetryAfter(err); ok && filestorage.IsThrottled(err) {
+			wait = max(wait, min(after, p.MaxDelay))
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed after %d attempts, retry interrupted: %w", n, err)
+		case <-clk.After(wait):
+		}
+		delay = min(2*delay, p.MaxDelay)
+	}
+}
@@ -0,0 +1,79 @@
+package m2cs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// revalidateTimeout bounds the background fetch refreshing a stale cached object.
+const revalidateTimeout = time.Minute
+
+// cachedObject returns the cached copy of an object, or nil when it is not cached or the cache is
+// not enabled for its store box. With StaleWhileRevalidate, an expired copy within the stale windows is returned as
+// well, and refreshed in background; the cache backends only return fresh copies.
+func (f *FileClient) cachedObject(storeBox, fileName string) io.ReadCloser {
+	if _, enabled := f.cachePolicy(storeBox); !enabled {
+		return nil
+	}
+	if f.backend != nil {
+		return f.backendObject(storeBox, fileName)
+	}
+
+	rc, stale, storedAt := f.cache.Lookup(storeBox + "/" + fileName)
+	if rc == nil {
+		return nil
+	}
+	if stale {
+		f.revalidate(storeBox, fileName, storedAt)
+	}
+	return rc
+}
+
+// revalidate refreshes the stale cached copy of an object, stored at storedAt, from the load-balanced
+// storages in background. Concurrent revalidations of the same object are coalesced into one; when it
+// fails, the stale copy is served until MaxStale after its expiry.
+func (f *FileClient) revalidate(storeBox, fileName string, storedAt time.Time) {
+	key := storeBox + "/" + fileName
+	if _, running := f.revalidating.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	cache := f.cache
+	go func() {
+		defer f.revalidating.Delete(key)
+
+		ctx, cancel := context.WithTimeout(context.Background(), revalidateTimeout)
+		defer cancel()
+
+		data, err := f.fetchObject(ctx, storeBox, fileName)
+		if err != nil {
+			log.Printf("[cache] failed to revalidate %s: %v", key, err)
+			cache.RevalidationFailed(key, storedAt)
+			return
+		}
+		cache.Revalidate(key, storedAt, data)
+	}()
+}
+
+// fetchObject reads a whole object from the load-balanced storages, bypassing the cache.
+func (f *FileClient) fetchObject(ctx context.Context, storeBox, fileName string) ([]byte, error) {
+	lb, err := f.loadBalancer()
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := lb.Apply(ctx, storeBox, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object data: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,140 @@
+package m2cs
+
+import (
+	"fmt"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// WithBestEffortSecondaries makes the SYNC_REPLICATION writes of a client with a PRIMARY storage
+// succeed once the primary is written: the failures of the SECONDARY_MAIN storages are logged
+// instead of being returned. Without a primary, the option has no effect.
+func WithBestEffortSecondaries() FileClientOption {
+	return func(f *FileClient) error {
+		f.bestEffortSecondaries = true
+		return nil
+	}
+}
+
+// PromoteToPrimary makes the main storage with the given label the PRIMARY storage of the client,
+// e.g. when the primary is down; the former primary, if any, becomes a SECONDARY_MAIN storage.
+// The roles are swapped atomically: every write uses the primary at the time it starts.
+// REPLICA storages cannot be promoted.
+func (f *FileClient) PromoteToPrimary(label string) error {
+	for i, s := range f.storages {
+		if storageLabel(s) != label {
+			continue
+		}
+		if !s.GetConnectionProperties().IsMainInstance {
+			return fmt.Errorf("storage %s is not a main storage", label)
+		}
+
+		f.rolesMu.Lock()
+		f.primary = i
+		f.rolesMu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("storage %s not found", label)
+}
+
+// Primary returns the label of the PRIMARY storage, reporting false when the client has none.
+func (f *FileClient) Primary() (string, bool) {
+	f.rolesMu.RLock()
+	defer f.rolesMu.RUnlock()
+
+	if f.primary < 0 {
+		return "", false
+	}
+	return storageLabel(f.storages[f.primary]), true
+}
+
+// role returns the current role of the i-th storage.
+func (f *FileClient) role(i int) StorageRole {
+	f.rolesMu.RLock()
+	defer f.rolesMu.RUnlock()
+
+	switch {
+	case i == f.primary:
+		return PRIMARY
+	case f.storages[i].GetConnectionProperties().IsMainInstance:
+		return SECONDARY_MAIN
+	default:
+		return REPLICA
+	}
+}
+
+// primaryMain returns the index of the PRIMARY storage among the main storages,
+// as returned by mainStorages, or -1 when there is none.
+func (f *FileClient) primaryMain() int {
+	f.rolesMu.RLock()
+	primary := f.primary
+	f.rolesMu.RUnlock()
+
+	if primary < 0 {
+		return -1
+	}
+	index := 0
+	for _, s := range f.storages[:primary] {
+		if s.GetConnectionProperties().IsMainInstance {
+			index++
+		}
+	}
+	return index
+}
+
+// primaryIndex returns the index of the first main storage configured as PRIMARY, or -1.
+func primaryIndex(storages []filestorage.FileStorage) int {
+	for i, s := range storages {
+		if s == nil {
+			continue
+		}
+		props := s.GetConnectionProperties()
+		if props.Role == PRIMARY && props.IsMainInstance {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateRoles checks that at most one storage is PRIMARY and that the roles agree with IsMainInstance.
+func validateRoles(storages []filestorage.FileStorage) error {
+	primaries := 0
+	for _, s := range storages {
+		props := s.GetConnectionProperties()
+		switch props.Role {
+		case NO_ROLE:
+		case PRIMARY, SECONDARY_MAIN:
+			if !props.IsMainInstance {
+				return fmt.Errorf("%w: storage %s has role %v but is not a main instance", ErrInvalidConfiguration, storageLabel(s), props.Role)
+			}
+			if props.Role == PRIMARY {
+				primaries++
+			}
+		case REPLICA:
+			if props.IsMainInstance {
+				return fmt.Errorf("%w: storage %s has role %v but is a main instance", ErrInvalidConfiguration, storageLabel(s), props.Role)
+			}
+		default:
+			return fmt.Errorf("%w: storage %s has unknown role %v", ErrInvalidConfiguration, storageLabel(s), props.Role)
+		}
+	}
+	if primaries > 1 {
+		return fmt.Errorf("%w: %d storages have role %v, at most one is allowed", ErrInvalidConfiguration, primaries, PRIMARY)
+	}
+	return nil
+}
+
+// followers returns the main storages other than the one at index skip, with their indexes among mains.
+func followers(mains []filestorage.FileStorage, skip int) ([]filestorage.FileStorage, []int) {
+	var (
+		targets []filestorage.FileStorage
+		indexes []int
+	)
+	for i, s := range mains {
+		if i != skip {
+			targets = append(targets, s)
+			indexes = append(indexes, i)
+		}
+	}
+	return targets, indexes
+}
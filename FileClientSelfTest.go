@@ -0,0 +1,211 @@
+package m2cs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// DefaultDiagnosticsBox is the store box of the objects written by SelfTest, see WithDiagnosticsBox.
+const DefaultDiagnosticsBox = "m2cs-diagnostics"
+
+// Steps of SelfTest on a storage, in the order they are run, see SelfTestStep.
+const (
+	SelfTestPut    = "put"
+	SelfTestGet    = "get"
+	SelfTestRemove = "remove"
+	SelfTestCanary = "canary"
+)
+
+// selfTestSize is the size of the random object written by SelfTest.
+const selfTestSize = 1024
+
+// selfTestCanaryKey is the key of the object kept by SelfTest to check the EncryptKey across runs.
+const selfTestCanaryKey = "m2cs-selftest-canary"
+
+// selfTestCanary is the content of the canary object of SelfTest.
+var selfTestCanary = []byte("m2cs self-test canary")
+
+// WithDiagnosticsBox sets the store box of the objects written by SelfTest (default:
+// DefaultDiagnosticsBox). It must exist on every storage, and WithBoxPrefix applies to it.
+func WithDiagnosticsBox(storeBox string) FileClientOption {
+	return func(f *FileClient) error {
+		if storeBox == "" {
+			return fmt.Errorf("diagnostics box is empty")
+		}
+		f.diagnosticsBox = storeBox
+		return nil
+	}
+}
+
+// SelfTestStep is the outcome of a step of SelfTest on a storage.
+type SelfTestStep struct {
+	Name     string // "put", "get", "remove" or "canary"
+	Duration time.Duration
+	Err      error // Nil when the step succeeded
+}
+
+// SelfTestResult is the outcome of SelfTest on a storage.
+type SelfTestResult struct {
+	Label    string
+	Steps    []SelfTestStep // Steps run, in order
+	Duration time.Duration
+	Err      error // First failing step, nil when the storage passed
+}
+
+// SelfTestReport summarizes the outcome of a SelfTest call.
+type SelfTestReport struct {
+	StoreBox string           // Diagnostics box, as stored
+	Storages []SelfTestResult // Outcome on every storage, in configuration order
+	Duration time.Duration
+}
+
+// SelfTest checks every storage, main storages and replicas alike, at deploy time rather than
+// under load: a small random object is written to the diagnostics box (see WithDiagnosticsBox)
+// through the compression and encryption of the storage, read back, compared and removed, which
+// catches missing store boxes, rejected credentials and skewed clocks. A canary object with a
+// known content is then read back too, and written when missing: kept across runs, it catches an
+// EncryptKey differing from the one of the previous deployments, which the round trip of a new
+// object cannot. After rotating the key on purpose and rewriting the objects, the canary must be
+// removed as well. On the read-only storages, see WithEnforceReplicaReadOnly, only the canary is
+// read back, when present. The storages are checked concurrently, see WithMaxStorageConcurrency,
+// directly, without the cache, the interceptors and the retries. The failing storages are returned
+// as StorageError values, like RemoveObject: a *PartialFailureError when some storages pass.
+func (f *FileClient) SelfTest(ctx context.Context) error {
+	_, err := f.SelfTestWithReport(ctx)
+	return err
+}
+
+// SelfTestWithReport behaves like SelfTest, returning the outcome and the duration of every step.
+func (f *FileClient) SelfTestWithReport(ctx context.Context) (SelfTestReport, error) {
+	start := time.Now()
+	box := f.diagnosticsBox
+	if box == "" {
+		box = DefaultDiagnosticsBox
+	}
+
+	var suffix [8]byte
+	_, _ = rand.Read(suffix[:])
+	storeBox, key, err := f.scope(box, "m2cs-selftest-"+hex.EncodeToString(suffix[:]))
+	if err != nil {
+		return SelfTestReport{}, err
+	}
+	_, canaryKey, err := f.scope(box, selfTestCanaryKey)
+	if err != nil {
+		return SelfTestReport{}, err
+	}
+	content := make([]byte, selfTestSize)
+	_, _ = rand.Read(content)
+
+	report := SelfTestReport{StoreBox: storeBox, Storages: make([]SelfTestResult, len(f.storages))}
+	for i, s := range f.storages {
+		report.Storages[i].Label = storageLabel(s)
+	}
+	errs := f.forEachStorage(ctx, f.storages, func(i int, s filestorage.FileStorage) error {
+		report.Storages[i] = selfTest(ctx, s, storeBox, key, canaryKey, content)
+		return report.Storages[i].Err
+	})
+	report.Duration = time.Since(start)
+
+	var failures []*StorageError
+	for i, err := range errs {
+		if err != nil {
+			report.Storages[i].Err = err
+			failures = append(failures, &StorageError{Op: "SelfTest", Label: report.Storages[i].Label, Err: err})
+		}
+	}
+	switch {
+	case len(failures) == 0:
+		return report, nil
+	case len(failures) == len(f.storages):
+		return report, fmt.Errorf("SelfTest failed on all storages: %w", joinStorageErrors(failures))
+	default:
+		return report, &PartialFailureError{Op: "SelfTest", Total: len(f.storages), Failures: failures}
+	}
+}
+
+// selfTest runs the steps of SelfTest on s.
+func selfTest(ctx context.Context, s filestorage.FileStorage, storeBox, key, canaryKey string, content []byte) (result SelfTestResult) {
+	result.Label = storageLabel(s)
+	start := time.Now()
+	step := func(name string, run func() error) bool {
+		stepStart := time.Now()
+		err := run()
+		result.Steps = append(result.Steps, SelfTestStep{Name: name, Duration: time.Since(stepStart), Err: err})
+		if err != nil && result.Err == nil {
+			result.Err = fmt.Errorf("%s: %w", name, err)
+		}
+		return err == nil
+	}
+	defer func() { result.Duration = time.Since(start) }()
+
+	// the writes of a read-only storage are rejected: only the canary is read back, when present
+	if s.GetConnectionProperties().ReadOnly {
+		step(SelfTestCanary, func() error {
+			return checkCanary(ctx, s, storeBox, canaryKey, false)
+		})
+		return result
+	}
+
+	if !step(SelfTestPut, func() error {
+		return s.PutObject(ctx, storeBox, key, bytes.NewReader(content))
+	}) {
+		return result
+	}
+	read := step(SelfTestGet, func() error {
+		return readBack(ctx, s, storeBox, key, content)
+	})
+	// the object is removed even when it could not be read back
+	removed := step(SelfTestRemove, func() error {
+		return s.RemoveObject(ctx, storeBox, key)
+	})
+	if !read || !removed {
+		return result
+	}
+
+	step(SelfTestCanary, func() error {
+		return checkCanary(ctx, s, storeBox, canaryKey, true)
+	})
+	return result
+}
+
+// checkCanary reads back the canary object of SelfTest from s, writing it when missing if write
+// is set.
+func checkCanary(ctx context.Context, s filestorage.FileStorage, storeBox, canaryKey string, write bool) error {
+	err := readBack(ctx, s, storeBox, canaryKey, selfTestCanary)
+	if errors.Is(err, filestorage.ErrObjectNotFound) {
+		if !write {
+			return nil
+		}
+		return s.PutObject(ctx, storeBox, canaryKey, bytes.NewReader(selfTestCanary))
+	}
+	if err != nil {
+		return fmt.Errorf("the EncryptKey may differ from the one of the canary %s/%s: %w", storeBox, canaryKey, err)
+	}
+	return nil
+}
+
+// readBack reads the object storeBox/key from s and checks it holds want.
+func readBack(ctx context.Context, s filestorage.FileStorage, storeBox, key string, want []byte) error {
+	obj, err := s.GetObject(ctx, storeBox, key)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	got, err := io.ReadAll(io.LimitReader(obj, int64(len(want))+1))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("content read back differs from the one written")
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package m2cs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultShadowBandwidth     = 1 << 20
+	defaultShadowMaxConcurrent = 4
+	defaultShadowTimeout       = time.Minute
+
+	// shadowChunkSize is the largest read of a shadow copy, so that the bandwidth
+	// is throttled in small steps.
+	shadowChunkSize = 32 << 10
+)
+
+// WithShadowReads makes the FileClient validate a storage, such as a new replica, by reading
+// a share of the objects it serves from that storage too and comparing their digests.
+// The shadow read runs in the background after GetObject has read the object from the
+// load-balanced storages, so it never adds latency to the caller, and its data is never served.
+// Objects served by the cache are not shadowed. The shadow storage should not be one of the
+// storages of the FileClient, otherwise it may serve the reads it is validating.
+func WithShadowReads(opts ShadowOptions) FileClientOption {
+	return func(f *FileClient) error {
+		if opts.Storage == nil {
+			return fmt.Errorf("shadow storage is nil")
+		}
+		if opts.Percentage <= 0 || opts.Percentage > 100 {
+			return fmt.Errorf("shadow percentage must be in (0, 100], got %v", opts.Percentage)
+		}
+		if opts.MaxBytesPerSecond <= 0 {
+			opts.MaxBytesPerSecond = defaultShadowBandwidth
+		}
+		if opts.MaxConcurrent <= 0 {
+			opts.MaxConcurrent = defaultShadowMaxConcurrent
+		}
+		if opts.Timeout <= 0 {
+			opts.Timeout = defaultShadowTimeout
+		}
+
+		f.shadow = &shadowReader{
+			opts:    opts,
+			label:   storageLabel(opts.Storage),
+			slots:   make(chan struct{}, opts.MaxConcurrent),
+			limiter: &bandwidthLimiter{bytesPerSecond: opts.MaxBytesPerSecond},
+		}
+		return nil
+	}
+}
+
+// shadowReader compares the objects served by a FileClient with their copy on the shadow storage.
+type shadowReader struct {
+	opts    ShadowOptions
+	label   string
+	slots   chan struct{}
+	limiter *bandwidthLimiter
+}
+
+// maybeCompare starts the comparison of data, served for storeBox/fileName, with the shadow copy
+// when the call is sampled and a slot is free. It never blocks.
+func (s *shadowReader) maybeCompare(storeBox, fileName string, data []byte) {
+	if rand.Float64()*100 >= s.opts.Percentage {
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		return
+	}
+
+	go func() {
+		defer func() { <-s.slots }()
+		s.report(s.compare(storeBox, fileName, data))
+	}()
+}
+
+// compare reads the shadow copy of the object, throttled by the limiter, and compares its digest
+// with the one of the served data.
+func (s *shadowReader) compare(storeBox, fileName string, data []byte) ShadowResult {
+	sum := sha256.Sum256(data)
+	result := ShadowResult{
+		StoreBox: storeBox,
+		FileName: fileName,
+		Label:    s.label,
+		Primary:  hex.EncodeToString(sum[:]),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	obj, err := s.opts.Storage.GetObject(ctx, storeBox, fileName)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to read the shadow copy: %w", err)
+		result.Duration = time.Since(start)
+		return result
+	}
+	defer obj.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, &throttledReader{ctx: ctx, r: obj, limiter: s.limiter}); err != nil {
+		result.Err = fmt.Errorf("failed to read the shadow copy: %w", err)
+		result.Duration = time.Since(start)
+		return result
+	}
+
+	result.Shadow = hex.EncodeToString(hash.Sum(nil))
+	result.Match = result.Shadow == result.Primary
+	result.Duration = time.Since(start)
+	return result
+}
+
+// report delivers the result to the OnResult hook or, without a hook, logs mismatches and errors.
+func (s *shadowReader) report(result ShadowResult) {
+	if s.opts.OnResult != nil {
+		s.opts.OnResult(result)
+		return
+	}
+
+	switch {
+	case result.Err != nil:
+		log.Printf("[shadow] %s/%s on %s: %v", result.StoreBox, result.FileName, result.Label, result.Err)
+	case !result.Match:
+		log.Printf("[shadow] %s/%s differs on %s: sha256 %s, served %s",
+			result.StoreBox, result.FileName, result.Label, result.Shadow, result.Primary)
+	}
+}
+
+// bandwidthLimiter spreads reads over time so that, together, they do not exceed bytesPerSecond.
+type bandwidthLimiter struct {
+	mu             sync.Mutex
+	bytesPerSecond int64
+	next           time.Time
+}
+
+// wait accounts for n bytes just read and sleeps until the bandwidth they used is available again.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSecond))
+	until := l.next
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledReader reads through a bandwidthLimiter, in chunks of at most shadowChunkSize bytes.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > shadowChunkSize {
+		p = p[:shadowChunkSize]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.limiter.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,98 @@
+package m2cs
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// WithAllowDuplicates makes NewFileClientWithOptions accept storages given twice, as the same
+// instance or with the same label, e.g. to weigh a storage more in the load balancing.
+// The duplicates are written and read as distinct storages.
+func WithAllowDuplicates() FileClientOption {
+	return func(f *FileClient) error {
+		f.allowDuplicates = true
+		return nil
+	}
+}
+
+// WithEnforceReplicaReadOnly makes NewFileClientWithOptions set the replicas, the storages that
+// are not main instances, read-only, see filestorage.ReadOnlySetter: their writes, removals and
+// changes of store boxes fail with ErrReadOnlyStorage, including the ones of the application code
+// calling the storages directly, while the FileClient keeps reading from them. The client fails
+// with ErrInvalidConfiguration on replicas not implementing filestorage.ReadOnlySetter, such as
+// decorated storages. The storages stay read-only when the client is closed.
+func WithEnforceReplicaReadOnly() FileClientOption {
+	return func(f *FileClient) error {
+		f.enforceReplicaReadOnly = true
+		return nil
+	}
+}
+
+// validateNotNil checks that none of the storages is nil.
+func validateNotNil(storages []filestorage.FileStorage) error {
+	for i, s := range storages {
+		if s == nil || isNilPointer(s) {
+			return fmt.Errorf("%w: storage at index %d is nil", ErrInvalidConfiguration, i)
+		}
+	}
+	return nil
+}
+
+// validateUnique checks that no storage is given twice, as the same instance or with the same
+// label. Storages without a label are only compared by instance.
+func validateUnique(storages []filestorage.FileStorage) error {
+	type instance struct {
+		typ reflect.Type
+		ptr uintptr
+	}
+	instances := make(map[instance]int, len(storages))
+	labels := make(map[string]int, len(storages))
+
+	for i, s := range storages {
+		if v := reflect.ValueOf(s); v.Kind() == reflect.Pointer {
+			key := instance{typ: v.Type(), ptr: v.Pointer()}
+			if first, ok := instances[key]; ok {
+				return fmt.Errorf("%w: storages at index %d and %d are the same instance", ErrInvalidConfiguration, first, i)
+			}
+			instances[key] = i
+		}
+
+		label := s.GetConnectionProperties().Label
+		if label == "" {
+			continue
+		}
+		if first, ok := labels[label]; ok {
+			return fmt.Errorf("%w: storages at index %d and %d have the same label %q", ErrInvalidConfiguration, first, i, label)
+		}
+		labels[label] = i
+	}
+	return nil
+}
+
+// isNilPointer reports whether s holds a nil pointer, e.g. a (*filestorage.MinioClient)(nil).
+func isNilPointer(s filestorage.FileStorage) bool {
+	v := reflect.ValueOf(s)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
+
+// enforceReadOnly sets the replicas among storages read-only, once all of them are known to
+// implement filestorage.ReadOnlySetter, see WithEnforceReplicaReadOnly.
+func enforceReadOnly(storages []filestorage.FileStorage) error {
+	var setters []filestorage.ReadOnlySetter
+	for _, s := range storages {
+		if s.GetConnectionProperties().IsMainInstance {
+			continue
+		}
+		setter, ok := s.(filestorage.ReadOnlySetter)
+		if !ok {
+			return fmt.Errorf("%w: replica %s cannot be made read-only", ErrInvalidConfiguration, storageLabel(s))
+		}
+		setters = append(setters, setter)
+	}
+	for _, setter := range setters {
+		setter.SetReadOnly(true)
+	}
+	return nil
+}
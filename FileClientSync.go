@@ -0,0 +1,225 @@
+package m2cs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/internal/replication"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// ObjectInfo describes a stored object as reported by a listing.
+type ObjectInfo = filestorage.ObjectInfo
+
+// SyncAction is a copy planned by SyncBox.
+type SyncAction struct {
+	Key    string // Key of the object
+	Target string // Label of the destination storage
+	Reason string // Why the copy is needed: "missing" or "size-mismatch"
+}
+
+// SyncReport summarizes the outcome of a SyncBox call.
+type SyncReport struct {
+	Source   string       // Label of the source storage
+	Scanned  int          // Objects listed on the source
+	Filtered int          // Objects skipped by the filter
+	Planned  []SyncAction // Copies needed to converge the destinations
+	Copied   int          // Copies performed successfully
+	Failed   int          // Copies that failed
+}
+
+// SyncBox replicates the content of storeBox from the storage labeled source into the other
+// main storages. Objects missing on a destination are copied; when source and destination save
+// objects with the same compression and encryption, objects whose stored size differs are copied
+// as well. Each copy is read through the source pipeline and written through the destination one.
+// With DryRun the plan is returned without copying anything. A client scoped with WithKeyPrefix
+// only syncs the objects of its namespace, and reports their keys without the prefix.
+func (f *FileClient) SyncBox(ctx context.Context, storeBox string, source string, opts SyncOptions) (SyncReport, error) {
+	report := SyncReport{Source: source}
+
+	storeBox, err := f.scopeBox(storeBox)
+	if err != nil {
+		return report, err
+	}
+
+	var src filestorage.FileStorage
+	for _, s := range f.storages {
+		if storageLabel(s) == source {
+			src = s
+			break
+		}
+	}
+	if src == nil {
+		return report, fmt.Errorf("no storage labeled %q found for SyncBox operation", source)
+	}
+
+	srcLister, ok := src.(filestorage.ObjectLister)
+	if !ok {
+		return report, fmt.Errorf("storage %q does not support listing", source)
+	}
+
+	var targets []filestorage.FileStorage
+	for _, s := range f.storages {
+		if s != src && s.GetConnectionProperties().IsMainInstance {
+			targets = append(targets, s)
+		}
+	}
+	if len(targets) == 0 {
+		return report, errors.New("no main instance found besides the source for SyncBox operation")
+	}
+
+	objects, err := srcLister.ListObjectsInfo(ctx, storeBox, f.keyPrefix)
+	if err != nil {
+		return report, fmt.Errorf("failed to list source storage %q: %w", source, err)
+	}
+
+	var selected []ObjectInfo
+	for _, obj := range objects {
+		key, ok := f.unscopeKey(obj.Key)
+		if !ok {
+			continue
+		}
+		obj.Key = key
+		report.Scanned++
+
+		if opts.Filter != nil && !opts.Filter(obj) {
+			report.Filtered++
+			continue
+		}
+		selected = append(selected, obj)
+	}
+
+	type copyTask struct {
+		action SyncAction
+		target filestorage.FileStorage
+	}
+	var tasks []copyTask
+
+	srcProps := src.GetConnectionProperties()
+	for _, target := range targets {
+		existing, err := f.listByKey(ctx, target, storeBox)
+		if err != nil {
+			return report, fmt.Errorf("failed to list destination storage %q: %w", storageLabel(target), err)
+		}
+
+		tgtProps := target.GetConnectionProperties()
+		sameFormat := srcProps.SaveCompress == tgtProps.SaveCompress && srcProps.SaveEncrypt == tgtProps.SaveEncrypt &&
+			slices.Equal(srcProps.TransformRules, tgtProps.TransformRules)
+
+		for _, obj := range selected {
+			reason := ""
+			if info, found := existing[obj.Key]; !found {
+				reason = "missing"
+			} else if sameFormat && info != nil && info.Size != obj.Size {
+				reason = "size-mismatch"
+			}
+			if reason == "" {
+				continue
+			}
+
+			action := SyncAction{Key: obj.Key, Target: storageLabel(target), Reason: reason}
+			report.Planned = append(report.Planned, action)
+			tasks = append(tasks, copyTask{action: action, target: target})
+		}
+	}
+
+	if opts.DryRun || len(tasks) == 0 {
+		return report, nil
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	// the copies of a key run as a task of a dedicated engine, writing one target at a time, so
+	// that at most concurrency copies are in flight
+	var (
+		mu   sync.Mutex
+		errs []error
+		keys []string
+	)
+	byKey := make(map[string][]copyTask)
+	for _, task := range tasks {
+		if _, ok := byKey[task.action.Key]; !ok {
+			keys = append(keys, task.action.Key)
+		}
+		byKey[task.action.Key] = append(byKey[task.action.Key], task)
+	}
+
+	engine := replication.New(replication.Options{Workers: concurrency, TargetConcurrency: 1})
+	for _, key := range keys {
+		copies := byKey[key]
+		t := &replication.Task{StoreBox: storeBox, Key: f.storedKey(key), Source: source}
+		for _, c := range copies {
+			t.Targets = append(t.Targets, c.action.Target)
+		}
+		t.Write = func(ctx context.Context, i int) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return copyObject(ctx, src, copies[i].target, t.StoreBox, t.Key)
+		}
+		t.Written = func(i int, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.Failed++
+				errs = append(errs, fmt.Errorf("SyncBox copy of %s to %s failed: %w", key, copies[i].action.Target, err))
+				return
+			}
+			report.Copied++
+		}
+		_ = engine.Enqueue(ctx, t)
+	}
+	_ = engine.Drain(context.Background())
+
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	if len(errs) > 0 {
+		return report, fmt.Errorf("SyncBox failed %d/%d copies: %w", len(errs), len(tasks), errors.Join(errs...))
+	}
+
+	return report, nil
+}
+
+// listByKey indexes the objects of storeBox within the namespace of the client on the storage
+// by key, as given by the caller. When the storage cannot list, the map is nil and every lookup
+// reports the object as missing.
+func (f *FileClient) listByKey(ctx context.Context, s filestorage.FileStorage, storeBox string) (map[string]*ObjectInfo, error) {
+	lister, ok := s.(filestorage.ObjectLister)
+	if !ok {
+		return nil, nil
+	}
+
+	objects, err := lister.ListObjectsInfo(ctx, storeBox, f.keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]*ObjectInfo, len(objects))
+	for i := range objects {
+		if key, ok := f.unscopeKey(objects[i].Key); ok {
+			byKey[key] = &objects[i]
+		}
+	}
+	return byKey, nil
+}
+
+// copyObject reads an object from src and writes it to dst, each through its own pipeline.
+func copyObject(ctx context.Context, src, dst filestorage.FileStorage, storeBox, fileName string) error {
+	obj, err := src.GetObject(ctx, storeBox, fileName)
+	if err != nil {
+		return fmt.Errorf("read from source: %w", err)
+	}
+	defer obj.Close()
+
+	if err := dst.PutObject(ctx, storeBox, fileName, obj); err != nil {
+		return fmt.Errorf("write to destination: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package m2cs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// WriteConcurrencyLimits returns the writes each throttled storage is allowed to have in flight,
+// by label. A storage throttling a write, see IsThrottled, is degraded rather than down: the writes
+// in flight to it are halved at each throttled write, then widened by one at each successful write
+// until they reach again the concurrency the storage was throttled at, when the limit is lifted.
+// Storages not throttled are absent, their writes being only bounded by WithMaxConcurrentWrites.
+func (f *FileClient) WriteConcurrencyLimits() map[string]int {
+	return f.throttles.limits()
+}
+
+// writeThrottles narrows the writes to the storages throttling them, see WriteConcurrencyLimits.
+// Its zero value narrows nothing yet.
+type writeThrottles struct {
+	mu       sync.Mutex
+	storages map[string]*writeThrottle // By label
+}
+
+// writeThrottle is the narrowing of the writes to a storage.
+type writeThrottle struct {
+	inFlight int
+	limit    int           // Writes allowed in flight, unlimited when 0
+	ceiling  int           // Writes in flight when the storage started throttling, lifting the limit once reached
+	changed  chan struct{} // Closed when a write returns, to wake up the writes waiting for the limit
+}
+
+// do runs a write attempt on s, waiting first for the limit of s, if it is throttled, and
+// narrowing or widening the limit depending on the outcome of the attempt.
+func (t *writeThrottles) do(ctx context.Context, s filestorage.FileStorage, attempt func() error) error {
+	label := storageLabel(s)
+	if err := t.acquire(ctx, label); err != nil {
+		return err
+	}
+	err := attempt()
+	t.release(label, err)
+	return err
+}
+
+// acquire waits until label is allowed one more write in flight, or until ctx is done.
+func (t *writeThrottles) acquire(ctx context.Context, label string) error {
+	for {
+		t.mu.Lock()
+		if t.storages == nil {
+			t.storages = make(map[string]*writeThrottle)
+		}
+		w, ok := t.storages[label]
+		if !ok {
+			w = &writeThrottle{changed: make(chan struct{})}
+			t.storages[label] = w
+		}
+		if w.limit == 0 || w.inFlight < w.limit {
+			w.inFlight++
+			t.mu.Unlock()
+			return nil
+		}
+		changed := w.changed
+		t.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return fmt.Errorf("failed to write to throttled storage %s: %w", label, ctx.Err())
+		}
+	}
+}
+
+// release records a write to label returning err, narrowing the limit when it was throttled and
+// widening it when it succeeded.
+func (t *writeThrottles) release(label string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w := t.storages[label]
+	switch {
+	case filestorage.IsThrottled(err):
+		current := w.limit
+		if current == 0 {
+			current = w.inFlight
+			w.ceiling = w.inFlight
+		}
+		w.limit = max(current/2, 1)
+	case err == nil && w.limit > 0:
+		w.limit++
+		if w.limit >= w.ceiling {
+			w.limit, w.ceiling = 0, 0
+		}
+	}
+	w.inFlight--
+
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// limits returns the limits of the throttled storages, by label.
+func (t *writeThrottles) limits() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limits := make(map[string]int)
+	for label, w := range t.storages {
+		if w.limit > 0 {
+			limits[label] = w.limit
+		}
+	}
+	return limits
+}
@@ -0,0 +1,43 @@
+package m2cs
+
+import (
+	"context"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// SetObjectTier moves an object to the given tier on every main storage.
+// Storages without tier support fail with filestorage.ErrTierNotSupported, so that the
+// returned *PartialFailureError reports which storages applied the change.
+func (f *FileClient) SetObjectTier(ctx context.Context, storeBox, fileName string, tier StorageTier) error {
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return err
+	}
+
+	return f.onMainStorages(ctx, "SetObjectTier", func(s filestorage.FileStorage) error {
+		tm, ok := s.(filestorage.TierManager)
+		if !ok {
+			return filestorage.ErrTierNotSupported
+		}
+		return tm.SetObjectTier(ctx, storeBox, fileName, tier)
+	})
+}
+
+// RehydrateObject initiates the restore of an archived object on every main storage.
+// Providers keeping a temporary restored copy keep it for the given number of days.
+// Errors are reported as in SetObjectTier.
+func (f *FileClient) RehydrateObject(ctx context.Context, storeBox, fileName string, days int) error {
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return err
+	}
+
+	return f.onMainStorages(ctx, "RehydrateObject", func(s filestorage.FileStorage) error {
+		tm, ok := s.(filestorage.TierManager)
+		if !ok {
+			return filestorage.ErrTierNotSupported
+		}
+		return tm.RestoreObject(ctx, storeBox, fileName, days)
+	})
+}
@@ -0,0 +1,410 @@
+package m2cs
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/tizianocitro/m2cs/internal/loadbalancing"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// PartFileSuffix is appended to the destination path of FGetObject while the download
+// is in progress. A leftover part file is used to resume the next download.
+const PartFileSuffix = ".m2cs.part"
+
+// FPutObject uploads the file at localPath to all main storages based on the replication mode.
+// Every storage reads from its own view of the file, so the content is never loaded in memory
+// by the FileClient. In ASYNC_REPLICATION mode the file is kept open until the background
+// writes complete.
+func (f *FileClient) FPutObject(ctx context.Context, storeBox, fileName, localPath string) error {
+	return f.intercept(ctx, OpInfo{Name: "FPutObject", Type: WRITE_OPERATION, StoreBox: storeBox, Key: fileName, Size: -1}, func(ctx context.Context) error {
+		return f.fPutObject(ctx, storeBox, fileName, localPath)
+	})
+}
+
+// fPutObject implements FPutObject.
+func (f *FileClient) fPutObject(ctx context.Context, storeBox, fileName, localPath string) error {
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat local file: %w", err)
+	}
+	if info.IsDir() {
+		_ = file.Close()
+		return fmt.Errorf("local path %s is a directory", localPath)
+	}
+
+	size := info.Size()
+	if err := checkSize(size, f.maxObjectSize); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return f.replicate(ctx, &putRequest{
+		storeBox:  storeBox,
+		fileName:  fileName,
+		newReader: func() io.Reader { return io.NewSectionReader(file, 0, size) },
+		size:      size,
+		done:      func() { _ = file.Close() },
+	})
+}
+
+// FGetObject downloads an object into the file at localPath, creating the parent
+// directories if needed. The content is streamed to a part file (localPath + PartFileSuffix)
+// which is renamed to localPath once the download completes.
+// If a part file is left over by a previous download, FGetObject resumes from its size
+// using a ranged read on a storage that supports it, provided that the object still has the
+// ETag and the size recorded when the part file was started; otherwise the part file is
+// discarded and the download restarts from the beginning. Storages saving compressed or
+// encrypted objects cannot serve ranges, and the part files of the objects read from the
+// cache cannot be verified, so their downloads restart as well. The other failures of the
+// ranged reads, e.g. a missing object, are returned.
+func (f *FileClient) FGetObject(ctx context.Context, storeBox, fileName, localPath string) error {
+	return f.intercept(ctx, OpInfo{Name: "FGetObject", Type: READ_OPERATION, StoreBox: storeBox, Key: fileName, Size: -1}, func(ctx context.Context) error {
+		return f.fGetObject(ctx, storeBox, fileName, localPath)
+	})
+}
+
+// fGetObject implements FGetObject.
+func (f *FileClient) fGetObject(ctx context.Context, storeBox, fileName, localPath string) error {
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create parent directories: %w", err)
+	}
+
+	partPath := localPath + PartFileSuffix
+
+	resumed := false
+	if info, err := os.Stat(partPath); err == nil && info.Size() > 0 {
+		resumed, err = f.resumeDownload(ctx, storeBox, fileName, partPath, info.Size())
+		if err != nil {
+			return err
+		}
+	}
+
+	if !resumed {
+		if err := f.download(ctx, storeBox, fileName, partPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(partPath, localPath); err != nil {
+		return fmt.Errorf("failed to move downloaded file into place: %w", err)
+	}
+	_ = os.Remove(partPath + partInfoSuffix)
+
+	return nil
+}
+
+// partInfoSuffix is appended to the path of a part file for the file recording the partInfo of
+// the object it holds the beginning of.
+const partInfoSuffix = ".info"
+
+// partInfo identifies the version of an object a part file was started with.
+type partInfo struct {
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+}
+
+// readPartInfo returns the partInfo recorded along with partPath, and false when there is none.
+func readPartInfo(partPath string) (partInfo, bool) {
+	data, err := os.ReadFile(partPath + partInfoSuffix)
+	if err != nil {
+		return partInfo{}, false
+	}
+	var info partInfo
+	if err := json.Unmarshal(data, &info); err != nil || info.ETag == "" {
+		return partInfo{}, false
+	}
+	return info, true
+}
+
+// writePartInfo records info along with partPath, or removes the recorded one when info is empty,
+// i.e. when the download cannot be resumed.
+func writePartInfo(partPath string, info partInfo) error {
+	if info.ETag == "" {
+		if err := os.Remove(partPath + partInfoSuffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove part file info: %w", err)
+		}
+		return nil
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode part file info: %w", err)
+	}
+	if err := os.WriteFile(partPath+partInfoSuffix, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write part file info: %w", err)
+	}
+	return nil
+}
+
+// download writes the whole object into partPath, truncating any previous content. The ETag
+// and the size of the object are recorded along with partPath when the storage serving it can
+// resume the download, see resumeDownload.
+func (f *FileClient) download(ctx context.Context, storeBox, fileName, partPath string) error {
+	type downloadedObject struct {
+		obj  io.ReadCloser
+		info partInfo
+	}
+
+	res := downloadedObject{obj: f.cachedObject(storeBox, fileName)}
+	if res.obj == nil {
+		lb, err := f.loadBalancer()
+		if err != nil {
+			return err
+		}
+
+		res, err = loadbalancing.Execute(ctx, lb, func(client loadbalancing.Client) (downloadedObject, error) {
+			var info partInfo
+			// the stat comes first: an object rewritten in between is recorded with a stale ETag,
+			// which only makes the next resume restart the download
+			if stater, ok := client.(filestorage.ObjectStater); ok && resumable(client) {
+				if stat, err := stater.StatObject(ctx, storeBox, fileName); err == nil {
+					info = partInfo{ETag: stat.ETag, Size: stat.Size}
+				}
+			}
+			obj, err := client.GetObject(ctx, storeBox, fileName)
+			return downloadedObject{obj: obj, info: info}, err
+		})
+		if err != nil {
+			return fmt.Errorf("FileClient FGetObject error: all clients failed to get the object: %w", err)
+		}
+	}
+	defer res.obj.Close()
+
+	file, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create part file: %w", err)
+	}
+	if err := writePartInfo(partPath, res.info); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if _, err := f.copy(file, res.obj); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write object data: %w", err)
+	}
+
+	return file.Close()
+}
+
+// resumable reports whether client can resume a download: it serves ranges of the objects it
+// saves without transforms, and reports their ETag and size.
+func resumable(client loadbalancing.Client) bool {
+	_, ranges := client.(filestorage.RangeReader)
+	_, stats := client.(filestorage.ObjectStater)
+	return ranges && stats && servesPlaintext(client)
+}
+
+// resumeDownload appends the remainder of the object, starting at offset, to partPath, once
+// checked that the object still has the ETag and the size recorded with partPath, and that
+// offset is within it. It returns false when partPath cannot be verified, or when every storage
+// failed with ErrRangeNotSupported or ErrPreconditionFailed, so that the caller falls back to a
+// full download; the other failures are returned.
+func (f *FileClient) resumeDownload(ctx context.Context, storeBox, fileName, partPath string, offset int64) (bool, error) {
+	recorded, ok := readPartInfo(partPath)
+	if !ok {
+		return false, nil
+	}
+
+	lb, err := f.loadBalancer()
+	if err != nil {
+		return false, err
+	}
+
+	obj, err := loadbalancing.Execute(ctx, lb, func(client loadbalancing.Client) (io.ReadCloser, error) {
+		if !resumable(client) {
+			return nil, filestorage.ErrRangeNotSupported
+		}
+		stat, err := client.(filestorage.ObjectStater).StatObject(ctx, storeBox, fileName)
+		if err != nil {
+			return nil, err
+		}
+		if stat.ETag != recorded.ETag || stat.Size != recorded.Size || offset >= stat.Size {
+			return nil, fmt.Errorf("%w: the object changed since the part file was started", filestorage.ErrPreconditionFailed)
+		}
+		return client.(filestorage.RangeReader).GetObjectRange(ctx, storeBox, fileName, offset, 0)
+	})
+	if err != nil {
+		if restartable(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("FileClient FGetObject error: failed to resume the download: %w", err)
+	}
+	defer obj.Close()
+
+	file, err := os.OpenFile(partPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return false, fmt.Errorf("failed to open part file: %w", err)
+	}
+
+	if _, err := f.copy(file, obj); err != nil {
+		_ = file.Close()
+		return false, fmt.Errorf("failed to resume object download: %w", err)
+	}
+
+	return true, file.Close()
+}
+
+// restartable reports whether err, as returned by loadbalancing.Execute, only holds failures
+// calling for a full download: ErrRangeNotSupported and ErrPreconditionFailed, on every client.
+func restartable(err error) bool {
+	restart := func(err error) bool {
+		return errors.Is(err, filestorage.ErrRangeNotSupported) || errors.Is(err, filestorage.ErrPreconditionFailed)
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return restart(err)
+	}
+	for _, e := range joined.Unwrap() {
+		if !restart(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetObjectToWriter streams an object into w, e.g. an HTTP response or a file, and returns the
+// number of bytes written. Unlike GetObject, the object is not read in memory first: it is copied
+// to w as it is read from the storage serving it, or from the cached copy. An object no larger
+// than the cache item limit of its store box is kept while streamed, and cached once complete;
+// larger ones are not cached. When the serving storage saves objects without compression and
+// encryption and reports an ETag holding a plain MD5 digest, the content is verified against it
+// and ErrChecksumMismatch is returned on mismatch. The copy stops with the error of ctx once ctx is
+// done. On error, w may hold part of the object.
+func (f *FileClient) GetObjectToWriter(ctx context.Context, storeBox, fileName string, w io.Writer) (int64, error) {
+	var n int64
+	err := f.intercept(ctx, OpInfo{Name: "GetObjectToWriter", Type: READ_OPERATION, StoreBox: storeBox, Key: fileName, Size: -1}, func(ctx context.Context) (err error) {
+		n, err = f.getObjectToWriter(ctx, storeBox, fileName, w)
+		return err
+	})
+	return n, err
+}
+
+// getObjectToWriter implements GetObjectToWriter.
+func (f *FileClient) getObjectToWriter(ctx context.Context, storeBox, fileName string, w io.Writer) (int64, error) {
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	if obj := f.cachedObject(storeBox, fileName); obj != nil {
+		defer obj.Close()
+		n, err := f.copy(w, &contextReader{ctx: ctx, r: obj})
+		if err != nil {
+			return n, fmt.Errorf("failed to write object data: %w", err)
+		}
+		return n, nil
+	}
+
+	lb, err := f.loadBalancer()
+	if err != nil {
+		return 0, err
+	}
+
+	type streamedObject struct {
+		obj  io.ReadCloser
+		etag string // Plain MD5 digest of the content, empty when it cannot be verified
+	}
+
+	res, err := loadbalancing.Execute(ctx, lb, func(client loadbalancing.Client) (streamedObject, error) {
+		ig, ok := client.(filestorage.InfoGetter)
+		if !ok || !servesPlaintext(client) {
+			obj, err := client.GetObject(ctx, storeBox, fileName)
+			return streamedObject{obj: obj}, err
+		}
+		obj, stat, err := ig.GetObjectWithInfo(ctx, storeBox, fileName)
+		return streamedObject{obj: obj, etag: md5ETag(stat.ETag)}, err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("FileClient GetObjectToWriter error: all clients failed to get the object: %w", err)
+	}
+	defer res.obj.Close()
+
+	dst := []io.Writer{w}
+	hash := md5.New()
+	if res.etag != "" {
+		dst = append(dst, hash)
+	}
+	var kept *cappedBuffer
+	if policy, enabled := f.cachePolicy(storeBox); enabled {
+		kept = &cappedBuffer{limit: policy.maxItemBytes}
+		dst = append(dst, kept)
+	}
+
+	n, err := f.copy(io.MultiWriter(dst...), &contextReader{ctx: ctx, r: res.obj})
+	if err != nil {
+		return n, fmt.Errorf("failed to write object data: %w", err)
+	}
+	if res.etag != "" && res.etag != hex.EncodeToString(hash.Sum(nil)) {
+		return n, fmt.Errorf("%w: ETag is %s", ErrChecksumMismatch, res.etag)
+	}
+
+	if kept != nil && !kept.exceeded {
+		f.cacheStore(storeBox, fileName, kept.buf.Bytes())
+	}
+
+	return n, nil
+}
+
+// servesPlaintext reports whether client saves objects without transforms, so that the ETag it
+// reports is the one of the content it serves.
+func servesPlaintext(client loadbalancing.Client) bool {
+	storage, ok := client.(filestorage.FileStorage)
+	return ok && !storage.GetConnectionProperties().Transformed()
+}
+
+// contextReader reads from r until ctx is done, then fails with the error of ctx.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// cappedBuffer holds the bytes written to it, up to limit: once more are written, it drops them
+// and reports exceeded. Writes never fail, so that it can be fed by an io.MultiWriter.
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	exceeded bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if c.exceeded {
+		return len(p), nil
+	}
+	if int64(c.buf.Len()+len(p)) > c.limit {
+		c.exceeded = true
+		c.buf = bytes.Buffer{}
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
@@ -0,0 +1,229 @@
+package m2cs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// trashTimeLayout formats the deletion time in trash keys so that they sort chronologically.
+const trashTimeLayout = "20060102T150405.000000000Z"
+
+// ConfigureSoftDelete configures the soft-delete mode of the client.
+// When enabled, RemoveObject copies each object to "<TrashBox>/<storeBox>/<fileName>/<timestamp>"
+// on every main storage before deleting it, so that it can be brought back with RestoreObject.
+// The trash box must exist on every main storage.
+func (f *FileClient) ConfigureSoftDelete(options SoftDeleteOptions) error {
+	if f == nil {
+		return fmt.Errorf("file client is nil")
+	}
+
+	if options.TrashBox == "" {
+		options.TrashBox = "m2cs-trash"
+	}
+
+	f.softDelete = options
+	return nil
+}
+
+// removeFrom deletes an object from a single storage, moving it to the trash first
+// when soft-delete is enabled.
+func (f *FileClient) removeFrom(ctx context.Context, s filestorage.FileStorage, storeBox, fileName string) error {
+	if f.softDelete.Enabled {
+		trashKey := trashPrefix(storeBox, fileName) + time.Now().UTC().Format(trashTimeLayout)
+		if err := copyBetweenBoxes(ctx, s, storeBox, fileName, f.softDelete.TrashBox, trashKey); err != nil {
+			return fmt.Errorf("failed to move object to trash: %w", err)
+		}
+	}
+
+	return s.RemoveObject(ctx, storeBox, fileName)
+}
+
+// RestoreObject brings back the most recently trashed version of an object on every main
+// storage holding it in its trash, removing it from the trash afterwards.
+// If caching is enabled, the restored content is stored in the cache.
+func (f *FileClient) RestoreObject(ctx context.Context, storeBox string, fileName string) error {
+	if !f.softDelete.Enabled {
+		return errors.New("soft-delete is not enabled")
+	}
+
+	box, key, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return err
+	}
+
+	mainStorages := f.mainStorages()
+	if len(mainStorages) == 0 {
+		return errors.New("no main instance found for RestoreObject operation")
+	}
+
+	var restored atomic.Int64
+	var errs []error
+
+	results := f.forEachStorage(ctx, mainStorages, func(_ int, s filestorage.FileStorage) error {
+		found, err := f.restoreOn(ctx, s, box, key)
+		if found && err == nil {
+			restored.Add(1)
+		}
+		return err
+	})
+	for i, err := range results {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("RestoreObject failed on storage %T: %w", mainStorages[i], err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("RestoreObject failed on %d/%d storages: %w", len(errs), len(mainStorages), errors.Join(errs...))
+	}
+	if restored.Load() == 0 {
+		return fmt.Errorf("object %s/%s not found in trash", storeBox, fileName)
+	}
+
+	if f.cache != nil && f.cache.Enabled() {
+		f.cacheInvalidate(box, key)
+		if obj, err := f.getObject(ctx, storeBox, fileName, GetOptions{}); err == nil {
+			_ = obj.Close()
+		}
+	}
+
+	return nil
+}
+
+// restoreOn restores the latest trashed version of an object on a single storage.
+// It reports false if the storage holds no trashed version of the object.
+func (f *FileClient) restoreOn(ctx context.Context, s filestorage.FileStorage, storeBox, fileName string) (bool, error) {
+	lister, ok := s.(filestorage.ObjectLister)
+	if !ok {
+		return false, fmt.Errorf("storage does not support listing")
+	}
+
+	prefix := trashPrefix(storeBox, fileName)
+	objects, err := lister.ListObjectsInfo(ctx, f.softDelete.TrashBox, prefix)
+	if err != nil {
+		return false, fmt.Errorf("failed to list trash: %w", err)
+	}
+
+	var versions []string
+	for _, obj := range objects {
+		if _, ok := trashedAt(obj.Key, prefix); ok {
+			versions = append(versions, obj.Key)
+		}
+	}
+	if len(versions) == 0 {
+		return false, nil
+	}
+
+	sort.Strings(versions)
+	latest := versions[len(versions)-1]
+
+	if err := copyBetweenBoxes(ctx, s, f.softDelete.TrashBox, latest, storeBox, fileName); err != nil {
+		return false, fmt.Errorf("failed to restore object: %w", err)
+	}
+	if err := s.RemoveObject(ctx, f.softDelete.TrashBox, latest); err != nil {
+		return true, fmt.Errorf("object restored but not removed from trash: %w", err)
+	}
+
+	return true, nil
+}
+
+// PurgeTrash permanently deletes the trashed objects older than olderThan from every
+// main storage, returning the number of deleted objects. A client scoped with WithKeyPrefix
+// or WithBoxPrefix only deletes the objects trashed from its namespace.
+func (f *FileClient) PurgeTrash(ctx context.Context, olderThan time.Duration) (int, error) {
+	if !f.softDelete.Enabled {
+		return 0, errors.New("soft-delete is not enabled")
+	}
+
+	mainStorages := f.mainStorages()
+	if len(mainStorages) == 0 {
+		return 0, errors.New("no main instance found for PurgeTrash operation")
+	}
+
+	threshold := time.Now().Add(-olderThan)
+	purged := 0
+	var errs []error
+
+	for _, s := range mainStorages {
+		lister, ok := s.(filestorage.ObjectLister)
+		if !ok {
+			errs = append(errs, fmt.Errorf("PurgeTrash failed on storage %T: storage does not support listing", s))
+			continue
+		}
+
+		objects, err := lister.ListObjectsInfo(ctx, f.softDelete.TrashBox, "")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("PurgeTrash failed on storage %T: %w", s, err))
+			continue
+		}
+
+		for _, obj := range objects {
+			at, ok := trashedAt(obj.Key, "")
+			if !ok || !at.Before(threshold) || !f.trashedFromNamespace(obj.Key) {
+				continue
+			}
+			if err := s.RemoveObject(ctx, f.softDelete.TrashBox, obj.Key); err != nil {
+				errs = append(errs, fmt.Errorf("PurgeTrash failed on storage %T: %w", s, err))
+				continue
+			}
+			purged++
+		}
+	}
+
+	if len(errs) > 0 {
+		return purged, fmt.Errorf("PurgeTrash failed: %w", errors.Join(errs...))
+	}
+
+	return purged, nil
+}
+
+// trashPrefix returns the prefix of the trash keys of an object.
+func trashPrefix(storeBox, fileName string) string {
+	return storeBox + "/" + fileName + "/"
+}
+
+// trashedFromNamespace reports whether a trash key holds an object of the namespace of the client.
+func (f *FileClient) trashedFromNamespace(key string) bool {
+	storeBox, fileName, _ := strings.Cut(key, "/")
+	return strings.HasPrefix(storeBox, f.boxPrefix) && strings.HasPrefix(fileName, f.keyPrefix)
+}
+
+// trashedAt parses the deletion time of a trash key. If prefix is not empty, the key must
+// be made of the prefix followed by the timestamp only.
+func trashedAt(key, prefix string) (time.Time, bool) {
+	var stamp string
+	if prefix != "" {
+		if !strings.HasPrefix(key, prefix) {
+			return time.Time{}, false
+		}
+		stamp = key[len(prefix):]
+		if strings.Contains(stamp, "/") {
+			return time.Time{}, false
+		}
+	} else {
+		stamp = key[strings.LastIndex(key, "/")+1:]
+	}
+
+	at, err := time.Parse(trashTimeLayout, stamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// copyBetweenBoxes copies an object within a single storage, through its pipelines.
+func copyBetweenBoxes(ctx context.Context, s filestorage.FileStorage, srcBox, srcKey, dstBox, dstKey string) error {
+	obj, err := s.GetObject(ctx, srcBox, srcKey)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	return s.PutObject(ctx, dstBox, dstKey, obj)
+}
@@ -0,0 +1,166 @@
+package m2cs
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// ErrObjectTooLarge is returned by the writes exceeding the maximum object size, see WithMaxObjectSize,
+// and by PutObjectFromURL when the source exceeds URLOptions.MaxSize.
+var ErrObjectTooLarge = errors.New("object exceeds the maximum size")
+
+// ErrChecksumMismatch is returned by PutObjectFromURL and DownloadParallel when the downloaded
+// content does not match the checksum announced by the source, and by the writes with a
+// PutOptions.ChecksumAlgorithm whose stored bytes do not match the checksum.
+var ErrChecksumMismatch = filestorage.ErrChecksumMismatch
+
+// defaultMaxRedirects is the number of redirects followed by PutObjectFromURL by default,
+// the same as net/http.
+const defaultMaxRedirects = 10
+
+// PutObjectFromURL downloads the object at url with a GET request and replicates it to the
+// main storages like PutObjectWithOptions. The body is buffered once, so that it can be
+// verified before any storage is written, and every storage reads its own view of it.
+// Only 200 OK responses are accepted. If the response carries a Content-MD5 header, or an
+// ETag holding a plain MD5 digest, the downloaded content is verified against it.
+func (f *FileClient) PutObjectFromURL(ctx context.Context, storeBox, fileName, url string, opts URLOptions) error {
+	return f.intercept(ctx, OpInfo{Name: "PutObjectFromURL", Type: WRITE_OPERATION, StoreBox: storeBox, Key: fileName, Size: -1}, func(ctx context.Context) error {
+		return f.putObjectFromURL(ctx, storeBox, fileName, url, opts)
+	})
+}
+
+// putObjectFromURL implements PutObjectFromURL.
+func (f *FileClient) putObjectFromURL(ctx context.Context, storeBox, fileName, url string, opts URLOptions) error {
+	storeBox, fileName, err := f.scope(storeBox, fileName)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid source URL: %w", err)
+	}
+
+	resp, err := opts.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download source: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download source: unexpected status %s", resp.Status)
+	}
+	maxSize := opts.MaxSize
+	if maxSize <= 0 {
+		maxSize = f.maxSize(opts.Put)
+	}
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		return fmt.Errorf("failed to download source: %w (%d > %d bytes)", ErrObjectTooLarge, resp.ContentLength, maxSize)
+	}
+
+	var body io.Reader = resp.Body
+	if maxSize > 0 {
+		body = io.LimitReader(resp.Body, maxSize+1)
+	}
+
+	buf, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to download source: %w", err)
+	}
+	if maxSize > 0 && int64(len(buf)) > maxSize {
+		return fmt.Errorf("failed to download source: %w (more than %d bytes)", ErrObjectTooLarge, maxSize)
+	}
+
+	// a transparently decompressed body no longer matches the checksums of the response
+	if !resp.Uncompressed {
+		if err := verifyChecksum(resp.Header, buf); err != nil {
+			return fmt.Errorf("failed to download source: %w", err)
+		}
+	}
+
+	return f.replicate(ctx, &putRequest{
+		storeBox:  storeBox,
+		fileName:  fileName,
+		newReader: func() io.Reader { return bytes.NewReader(buf) },
+		size:      int64(len(buf)),
+		opts:      opts.Put,
+	})
+}
+
+// httpClient returns the client to download the source with, enforcing the redirect limit.
+func (o URLOptions) httpClient() *http.Client {
+	client := http.DefaultClient
+	if o.HTTPClient != nil {
+		client = o.HTTPClient
+	}
+
+	maxRedirects := o.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+
+	limited := *client
+	limited.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if maxRedirects < 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(req, via)
+		}
+		return nil
+	}
+
+	return &limited
+}
+
+// verifyChecksum compares data with the Content-MD5 header or, if missing, with an ETag
+// holding a plain MD5 digest. Other ETags, such as multipart or weak ones, are ignored.
+func verifyChecksum(header http.Header, data []byte) error {
+	sum := md5.Sum(data)
+
+	if contentMD5 := header.Get("Content-MD5"); contentMD5 != "" {
+		if contentMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+			return fmt.Errorf("%w: Content-MD5 is %s", ErrChecksumMismatch, contentMD5)
+		}
+		return nil
+	}
+
+	etag := md5ETag(header.Get("ETag"))
+	if etag == "" {
+		return nil
+	}
+	if etag != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("%w: ETag is %s", ErrChecksumMismatch, etag)
+	}
+
+	return nil
+}
+
+// md5ETag returns the lowercase hex MD5 digest held by etag, or an empty string when
+// etag is not a plain MD5 digest, as with weak, multipart or Azure Blob ETags.
+func md5ETag(etag string) string {
+	if strings.HasPrefix(etag, "W/") {
+		return ""
+	}
+	etag = strings.Trim(etag, `"`)
+	if len(etag) != md5.Size*2 {
+		return ""
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+	return strings.ToLower(etag)
+}
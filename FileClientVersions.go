@@ -0,0 +1,153 @@
+package m2cs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// ErrPreconditionFailed is returned by the writes with PutOptions.IfMatch when a storage holds
+// the object with another ETag, or does not hold it; the storage is not written.
+var ErrPreconditionFailed = filestorage.ErrPreconditionFailed
+
+// Version identifies the state of an object written by PutObjectWithReport, as the ETags of the
+// object on the main storages, by label. It is an opaque string, to be stored by the caller and
+// given back as PutOptions.IfMatch, so that a later write only succeeds over the same object.
+type Version string
+
+// ETags returns the ETags of the object on the main storages, by label.
+func (v Version) ETags() (map[string]string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(string(v))
+	if err != nil {
+		return nil, fmt.Errorf("invalid version: %w", err)
+	}
+	var etags map[string]string
+	if err := json.Unmarshal(data, &etags); err != nil {
+		return nil, fmt.Errorf("invalid version: %w", err)
+	}
+	return etags, nil
+}
+
+// newVersion returns the version of an object with the given ETags, by label.
+func newVersion(etags map[string]string) Version {
+	if len(etags) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(etags)
+	return Version(base64.RawURLEncoding.EncodeToString(data))
+}
+
+// StorageWrite describes an object as written by a storage.
+type StorageWrite struct {
+	Label     string // Label of the storage
+	ETag      string // ETag reported by the storage, in the format of ObjectStat.ETag
+	VersionID string // Version of the object, empty on storages without versioning
+}
+
+// PutReport describes the outcome of a PutObjectWithReport call.
+type PutReport struct {
+	Storages []StorageWrite // Main storages written before the call returned
+	Version  Version        // Version of the object on those storages, empty if none reported an ETag
+	Local    bool           // The write was kept on the primary by the replication filter, see WithReplicationFilter
+}
+
+// PutObjectWithReport behaves like PutObjectWithOptions, reporting the ETag and version ID of the
+// object on each main storage written before it returns, i.e. the first one, or the primary, with
+// ASYNC_REPLICATION and all of them with SYNC_REPLICATION. Storages not implementing
+// filestorage.ResultPutter are written as usual and not reported.
+//
+// The returned Version can be passed as PutOptions.IfMatch to build compare-and-swap flows: each
+// main storage listed in the version is written only if it still holds the object with the same
+// ETag, and fails with ErrPreconditionFailed otherwise, while the storages missing from it are
+// written unconditionally. Storages not implementing filestorage.ResultPutter cannot evaluate the
+// condition and fail. The condition is checked by each storage independently: with
+// SYNC_REPLICATION the other main storages are still written when one fails it, so that concurrent
+// writers should use a PRIMARY storage, see PromoteToPrimary, which is the only one the condition is
+// checked on and rejects the write before any other storage is written.
+func (f *FileClient) PutObjectWithReport(ctx context.Context, storeBox, fileName string, reader io.Reader, opts PutOptions) (PutReport, error) {
+	var report PutReport
+	err := f.intercept(ctx, OpInfo{Name: "PutObjectWithReport", Type: WRITE_OPERATION, StoreBox: storeBox, Key: fileName, Size: writeSize(reader, opts)}, func(ctx context.Context) error {
+		return f.writeObject(ctx, storeBox, fileName, reader, opts, &report)
+	})
+	return report, err
+}
+
+// putResults collects the results of the writes of a putRequest, by storage label.
+type putResults struct {
+	mu      sync.Mutex
+	results map[string]filestorage.PutResult
+	report  *PutReport
+}
+
+// record saves the result of the write on the storage with the given label.
+func (r *putResults) record(label string, result filestorage.PutResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.results == nil {
+		r.results = make(map[string]filestorage.PutResult)
+	}
+	r.results[label] = result
+}
+
+// fillReport sets the report of the request, if any, with the results of the written storages,
+// ignoring the storages whose result is not known.
+func (req *putRequest) fillReport(written []filestorage.FileStorage) {
+	r := req.results
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	etags := make(map[string]string)
+	r.report.Storages = nil
+	for _, s := range written {
+		label := storageLabel(s)
+		result, ok := r.results[label]
+		if !ok {
+			continue
+		}
+		r.report.Storages = append(r.report.Storages, StorageWrite{Label: label, ETag: result.ETag, VersionID: result.VersionID})
+		if result.ETag != "" {
+			etags[label] = result.ETag
+		}
+	}
+	r.report.Version = newVersion(etags)
+}
+
+// conditions returns the ETags the storages are written over for the given IfMatch, by label.
+// With a primary storage, only its condition is kept.
+func (f *FileClient) conditions(ifMatch Version) (map[string]string, error) {
+	if ifMatch == "" {
+		return nil, nil
+	}
+	etags, err := ifMatch.ETags()
+	if err != nil {
+		return nil, err
+	}
+	if primary := f.primaryMain(); primary >= 0 {
+		label := storageLabel(f.mainStorages()[primary])
+		return map[string]string{label: etags[label]}, nil
+	}
+	return etags, nil
+}
+
+// putWithResult writes the payload of the request on the i-th target storage with its condition,
+// if any, recording the result when requested.
+func (req *putRequest) putWithResult(ctx context.Context, i int, s filestorage.FileStorage, putter filestorage.ResultPutter, opts filestorage.PutOptions) error {
+	label := storageLabel(s)
+	opts.IfMatch = req.conditions[label]
+	result, err := putter.PutObjectWithResult(ctx, req.storeBox, req.fileName, req.readerFor(i, s), opts)
+	if err != nil {
+		return err
+	}
+	if req.results != nil {
+		req.results.record(label, result)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package m2cs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// defaultDedupWindow is the window used by WatchWithOptions when none is given.
+const defaultDedupWindow = 5 * time.Second
+
+// Watch reports the changes of the objects of a store box on every storage, see WatchWithOptions.
+func (f *FileClient) Watch(ctx context.Context, storeBox string, events []EventType) (<-chan ObjectEvent, error) {
+	return f.WatchWithOptions(ctx, storeBox, events, WatchOptions{})
+}
+
+// WatchWithOptions reports the changes of the objects of a store box on every storage.
+// An empty events slice watches every event type.
+// Storages pushing notifications, such as MinIO or an S3Client with an event queue, are
+// watched natively; the others are listed every PollInterval with filestorage.PollWatch.
+// The same change is usually reported by several storages, e.g. a PutObject replicated to
+// all of them, so an event of the same type on the same key is delivered once per DedupWindow,
+// labeled with the first storage reporting it.
+// A client scoped with WithKeyPrefix or WithBoxPrefix drops the events outside its namespace
+// and reports the store box and the keys without the prefixes.
+// The channel is closed when ctx is done.
+func (f *FileClient) WatchWithOptions(ctx context.Context, storeBox string, events []EventType, opts WatchOptions) (<-chan ObjectEvent, error) {
+	if len(f.storages) == 0 {
+		return nil, errors.New("no storage to watch")
+	}
+
+	scopedBox, err := f.scopeBox(storeBox)
+	if err != nil {
+		return nil, err
+	}
+
+	window := opts.DedupWindow
+	if window <= 0 {
+		window = defaultDedupWindow
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+
+	var sources []<-chan ObjectEvent
+	for _, s := range f.storages {
+		source, err := watchStorage(watchCtx, s, scopedBox, events, opts.PollInterval)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to watch storage %s: %w", storageLabel(s), err)
+		}
+		sources = append(sources, source)
+	}
+
+	merged := make(chan ObjectEvent)
+	var wg sync.WaitGroup
+	wg.Add(len(sources))
+	for _, source := range sources {
+		go func(source <-chan ObjectEvent) {
+			defer wg.Done()
+			for event := range source {
+				select {
+				case merged <- event:
+				case <-watchCtx.Done():
+					return
+				}
+			}
+		}(source)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	out := make(chan ObjectEvent)
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		dedup := newEventDeduplicator(window)
+		for event := range merged {
+			key, ok := f.unscopeKey(event.Key)
+			if !ok {
+				continue
+			}
+			event.StoreBox, event.Key = storeBox, key
+
+			if !dedup.first(event, time.Now()) {
+				continue
+			}
+			select {
+			case out <- event:
+			case <-watchCtx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// watchStorage watches a single storage, natively when it pushes notifications and by
+// listing it otherwise.
+func watchStorage(ctx context.Context, s filestorage.FileStorage, storeBox string, events []EventType, interval time.Duration) (<-chan ObjectEvent, error) {
+	if w, ok := s.(filestorage.Watcher); ok {
+		source, err := w.Watch(ctx, storeBox, events)
+		if !errors.Is(err, filestorage.ErrWatchNotSupported) {
+			return source, err
+		}
+	}
+
+	lister, ok := s.(filestorage.ObjectLister)
+	if !ok {
+		return nil, errors.New("storage can neither push nor list objects")
+	}
+
+	return filestorage.PollWatch(ctx, lister, storageLabel(s), storeBox, events, interval)
+}
+
+// eventDeduplicator drops the events already seen within a window.
+type eventDeduplicator struct {
+	window time.Duration
+	seen   map[eventKey]time.Time
+}
+
+// eventKey identifies the events reporting the same change.
+type eventKey struct {
+	storeBox  string
+	key       string
+	eventType EventType
+}
+
+func newEventDeduplicator(window time.Duration) *eventDeduplicator {
+	return &eventDeduplicator{window: window, seen: make(map[eventKey]time.Time)}
+}
+
+// first reports whether event is the first of its kind within the window, recording it if so.
+func (d *eventDeduplicator) first(event ObjectEvent, now time.Time) bool {
+	k := eventKey{storeBox: event.StoreBox, key: event.Key, eventType: event.Type}
+	if at, ok := d.seen[k]; ok && now.Sub(at) < d.window {
+		return false
+	}
+
+	// forget the expired events, so that the map does not grow unbounded
+	for other, at := range d.seen {
+		if now.Sub(at) >= d.window {
+			delete(d.seen, other)
+		}
+	}
+
+	d.seen[k] = now
+	return true
+}
@@ -0,0 +1,53 @@
+// Package m2cs replicates and balances the objects of an application across storages of several
+// providers, e.g. MinIO, AWS S3 and Azure Blob Storage, behind a single FileClient.
+//
+// The connections are created with NewMinIOConnection, NewS3Connection and NewAzBlobConnection,
+// or NewMemoryClient for the tests, and handed to NewFileClient or NewFileClientWithOptions. The
+// types of the subpackages an application needs are re-exported here, so that importing
+// github.com/tizianocitro/m2cs is enough to use the library: the storages and their errors below,
+// the transform options (CompressionAlgorithm, EncryptionAlgorithm, TransformRule) and the cache
+// options (CacheOptions, CacheBackend).
+package m2cs
+
+import (
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// FileStorage is a storage a FileClient writes to and reads from.
+type FileStorage = filestorage.FileStorage
+
+// ConnectionProperties are the properties of a storage, as reported by its GetConnectionProperties.
+type ConnectionProperties = common.ConnectionProperties
+
+// Re-export the storages
+type (
+	MinioClient     = filestorage.MinioClient
+	S3Client        = filestorage.S3Client
+	AzBlobClient    = filestorage.AzBlobClient
+	MemoryClient    = filestorage.MemoryClient
+	MirroredStorage = filestorage.MirroredStorage
+	MirrorOptions   = filestorage.MirrorOptions
+)
+
+// Re-export the errors of the storages
+var (
+	ErrObjectNotFound    = filestorage.ErrObjectNotFound
+	ErrBoxNotFound       = filestorage.ErrBoxNotFound
+	ErrAccessDenied      = filestorage.ErrAccessDenied
+	ErrTransient         = filestorage.ErrTransient
+	ErrThrottled         = filestorage.ErrThrottled
+	ErrRangeNotSupported = filestorage.ErrRangeNotSupported
+)
+
+// NewMemoryClient creates an empty in-memory storage, for the tests and benchmarks that must not
+// depend on a real backend, see filestorage.NewMemoryClient.
+func NewMemoryClient(properties ConnectionProperties) *MemoryClient {
+	return filestorage.NewMemoryClient(properties)
+}
+
+// NewMirroredStorage presents primary and secondary as a single storage, writing to both and
+// reading from secondary when primary fails, see filestorage.NewMirroredStorage.
+func NewMirroredStorage(primary, secondary FileStorage, opts MirrorOptions) (*MirroredStorage, error) {
+	return filestorage.NewMirroredStorage(primary, secondary, opts)
+}
@@ -4,8 +4,8 @@ package main
 //import (
 //	"context"
 //	"log"
-//	"m2cs"
-//	"m2cs/pkg/filestorage"
+//	"github.com/tizianocitro/m2cs"
+//	"github.com/tizianocitro/m2cs/pkg/filestorage"
 //	"strings"
 //)
 //
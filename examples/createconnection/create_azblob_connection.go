@@ -1,3 +1,6 @@
+//go:build ignore
+
+// Run with: go run create_azblob_connection.go
 package main
 
 import (
@@ -29,6 +32,7 @@ func main() {
 			ConnectionMethod: m2cs.ConnectWithEnvCredentials(),
 			IsMainInstance:   true,
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
+			EncryptKey:       "replace-with-a-long-random-passphrase", // Required with AES256_ENCRYPTION
 			SaveCompress:     m2cs.NO_COMPRESSION})
 	if err != nil {
 		log.Fatalln(err)
@@ -1,3 +1,6 @@
+//go:build ignore
+
+// Run with: go run create_minio_connection.go
 package main
 
 import (
@@ -1,3 +1,6 @@
+//go:build ignore
+
+// Run with: go run create_s3_connection.go
 package main
 
 import (
@@ -31,6 +34,7 @@ func main() {
 			ConnectionMethod: m2cs.ConnectWithEnvCredentials(),
 			IsMainInstance:   true,
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
+			EncryptKey:       "replace-with-a-long-random-passphrase", // Required with AES256_ENCRYPTION
 			SaveCompress:     m2cs.NO_COMPRESSION,
 		},
 		"us-east-1")
@@ -0,0 +1,73 @@
+package bufpool
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// DefaultMaxPooledSize is the capacity above which buffers are dropped instead of being
+// returned to the pool, so that a few giant payloads are not retained for the lifetime
+// of the process.
+const DefaultMaxPooledSize = 16 << 20
+
+// Pool recycles the buffers holding whole payloads in memory.
+// A buffer obtained from Get or ReadAll must be returned with Put only once nothing,
+// readers of its bytes included, references it anymore.
+type Pool struct {
+	pool    sync.Pool
+	maxSize int
+}
+
+// New returns a Pool retaining buffers up to maxSize bytes of capacity.
+// A maxSize <= 0 uses DefaultMaxPooledSize.
+func New(maxSize int) *Pool {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxPooledSize
+	}
+	return &Pool{
+		pool:    sync.Pool{New: func() any { return new(bytes.Buffer) }},
+		maxSize: maxSize,
+	}
+}
+
+// Default is the pool shared by the FileClients.
+var Default = New(DefaultMaxPooledSize)
+
+// Get returns an empty buffer.
+func (p *Pool) Get() *bytes.Buffer {
+	return p.pool.Get().(*bytes.Buffer)
+}
+
+// Put resets b and returns it to the pool, unless it grew beyond the maximum pooled size.
+func (p *Pool) Put(b *bytes.Buffer) {
+	if b == nil || b.Cap() > p.maxSize {
+		return
+	}
+	b.Reset()
+	p.pool.Put(b)
+}
+
+// ReadAll reads r until EOF into a pooled buffer. When r reports its remaining length,
+// as bytes.Reader and strings.Reader do, the buffer is grown once upfront.
+// On error the buffer is returned to the pool and nil is returned.
+func (p *Pool) ReadAll(r io.Reader) (*bytes.Buffer, error) {
+	return p.ReadAllSize(r, -1)
+}
+
+// ReadAllSize behaves like ReadAll, growing the buffer upfront to size bytes when size > 0,
+// e.g. for readers of a known size not reporting their length.
+func (p *Pool) ReadAllSize(r io.Reader, size int64) (*bytes.Buffer, error) {
+	b := p.Get()
+	if l, ok := r.(interface{ Len() int }); ok && size <= 0 {
+		size = int64(l.Len())
+	}
+	if size > 0 {
+		b.Grow(int(size))
+	}
+	if _, err := b.ReadFrom(r); err != nil {
+		p.Put(b)
+		return nil, err
+	}
+	return b, nil
+}
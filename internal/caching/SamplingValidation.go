@@ -12,37 +12,46 @@ type SamplingValidation struct {
 }
 
 func (sv *SamplingValidation) Apply(cache *FileCache) error {
+	_, err := sv.ApplyWithStats(cache)
+	return err
+}
+
+// ApplyWithStats validates a sample of the entries like Apply, reporting the entries listed,
+// sampled and removed, see StatsRunner.
+func (sv *SamplingValidation) ApplyWithStats(cache *FileCache) (ValidationStats, error) {
+	var stats ValidationStats
 	if cache == nil {
-		return fmt.Errorf("cache is nil")
+		return stats, fmt.Errorf("cache is nil")
 	}
 
 	if cache.Options.TTL <= 0 {
-		return fmt.Errorf("cache TTL must be greater than zero for sampling validation")
+		return stats, fmt.Errorf("cache TTL must be greater than zero for sampling validation")
 	}
 	rate := sv.SampleRate
 	if rate > 100 {
 		rate = 100
 	}
 	if rate <= 0 {
-		return nil
+		return stats, nil
 	}
 
 	cache.mu.Lock()
 	n := len(cache.File)
 	if n == 0 {
 		cache.mu.Unlock()
-		return nil
+		return stats, nil
 	}
 
 	type entry struct {
-		key      string
-		createAt time.Time
+		key       string
+		createAt  time.Time
+		retention time.Duration
 	}
 	entries := make([]entry, 0, n)
-	ttl := cache.Options.TTL
 	for k, fi := range cache.File {
 		if fi != nil {
-			entries = append(entries, entry{key: k, createAt: fi.createAt})
+			// files within the stale windows may still be served, see Lookup
+			entries = append(entries, entry{key: k, createAt: fi.createAt, retention: cache.retentionLocked(cache.ttlOf(fi))})
 		} else {
 			entries = append(entries, entry{key: k})
 		}
@@ -58,23 +67,25 @@ func (sv *SamplingValidation) Apply(cache *FileCache) error {
 	}
 
 	rand.Shuffle(len(entries), func(i, j int) { entries[i], entries[j] = entries[j], entries[i] })
+	stats.Scanned, stats.SampleSize = len(entries), sampleCount
 
-	now := time.Now()
+	now := cache.now()
 	for i := 0; i < sampleCount; i++ {
 		e := entries[i]
 		if e.createAt.IsZero() {
 			continue
 		}
-		if e.createAt.Add(ttl).Before(now) {
+		if e.createAt.Add(e.retention).Before(now) {
 			// Lock only to verify current state and delete if still expired.
 			cache.mu.Lock()
 			if fi, ok := cache.File[e.key]; ok && fi != nil && fi.createAt.Equal(e.createAt) {
-				if fi.createAt.Add(ttl).Before(time.Now()) {
+				if fi.createAt.Add(e.retention).Before(cache.now()) {
 					delete(cache.File, e.key)
+					stats.Expired++
 				}
 			}
 			cache.mu.Unlock()
 		}
 	}
-	return nil
+	return stats, nil
 }
@@ -0,0 +1,69 @@
+package caching
+
+import (
+	"hash/maphash"
+	"math/bits"
+)
+
+// sketchDepth is the number of rows of a frequencySketch; an estimate is the minimum of the rows.
+const sketchDepth = 4
+
+// frequencySketch is a count-min sketch estimating how many times the keys were seen within a window,
+// as used by TinyLFU: once window keys have been recorded, the counters are halved, so that the keys
+// which stopped being read fade out. Estimates may be too high, by collisions, but never too low.
+type frequencySketch struct {
+	seed     maphash.Seed
+	counters [sketchDepth][]uint8
+	mask     uint64
+	window   int
+	recorded int
+}
+
+// newFrequencySketch returns a sketch whose rows have a counter per key of the window.
+func newFrequencySketch(window int) *frequencySketch {
+	width := 64
+	if window > width {
+		width = 1 << bits.Len(uint(window-1))
+	}
+
+	s := &frequencySketch{
+		seed:   maphash.MakeSeed(),
+		mask:   uint64(width - 1),
+		window: window,
+	}
+	for i := range s.counters {
+		s.counters[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// record counts an access to key and returns the estimated number of accesses within the window.
+func (s *frequencySketch) record(key string) int {
+	h := maphash.String(s.seed, key)
+	h1, h2 := h&0xffffffff, h>>32|1
+
+	estimate := uint8(255)
+	for i := range s.counters {
+		idx := (h1 + uint64(i)*h2) & s.mask
+		if s.counters[i][idx] < 255 {
+			s.counters[i][idx]++
+		}
+		estimate = min(estimate, s.counters[i][idx])
+	}
+
+	s.recorded++
+	if s.recorded >= s.window {
+		s.age()
+	}
+	return int(estimate)
+}
+
+// age halves every counter and starts a new window.
+func (s *frequencySketch) age() {
+	for i := range s.counters {
+		for j := range s.counters[i] {
+			s.counters[i][j] >>= 1
+		}
+	}
+	s.recorded = 0
+}
@@ -0,0 +1,40 @@
+package caching
+
+import (
+	"context"
+	"time"
+
+	"github.com/tizianocitro/m2cs/pkg/cache"
+)
+
+var _ cache.Backend = (*FileCache)(nil)
+
+// Get implements cache.Backend, returning the content of a file like GetFile.
+// Stale files are not served. The returned data must not be modified.
+func (s *FileCache) Get(_ context.Context, fileName string) ([]byte, bool, error) {
+	if !s.Enabled() {
+		return nil, false, nil
+	}
+
+	fileInfo, _ := s.lookupEntry(fileName, false)
+	if fileInfo == nil {
+		return nil, false, nil
+	}
+	data, err := fileInfo.content()
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Set implements cache.Backend, adding a file to the cache like StoreWithTTL.
+func (s *FileCache) Set(_ context.Context, fileName string, data []byte, ttl time.Duration) error {
+	s.StoreWithTTL(fileName, data, ttl)
+	return nil
+}
+
+// Delete implements cache.Backend, removing a file from the cache like Invalidate.
+func (s *FileCache) Delete(_ context.Context, fileName string) error {
+	s.Invalidate(fileName)
+	return nil
+}
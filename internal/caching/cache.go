@@ -7,11 +7,21 @@ import (
 	"io"
 	"sync"
 	"time"
+
+	"github.com/tizianocitro/m2cs/internal/clock"
 )
 
+// FileInformation is a cached item. Its data and size are not modified once stored:
+// storing a file again replaces its FileInformation.
 type FileInformation struct {
-	data     []byte
-	createAt time.Time
+	data       []byte
+	size       int64 // Size of the data once decompressed
+	compressed bool  // The data is gzip-compressed, see CompressEntries
+	createAt   time.Time
+	ttl        time.Duration // Time-to-live of the data, overriding the cache TTL when positive
+	hits       int64         // Reads served since the data was stored
+
+	refreshFailed bool // The last revalidation of the stale data failed
 }
 
 type CacheOptions struct {
@@ -21,6 +31,25 @@ type CacheOptions struct {
 	MaxItems          int                // Maximum number of items in the cache (default: 5)
 	ValidationOptions *ValidationOptions // Options for cache validation strategy
 
+	MaxItemSizeMB    int64 // Items larger than this are not cached (default: MaxSizeMB)
+	AdmissionMinHits int   // Items are cached from their AdmissionMinHits-th read within the window (default: 1, i.e. always)
+	AdmissionWindow  int   // Number of reads after which the admission counters are halved (default: 10 * MaxItems)
+
+	StaleWhileRevalidate time.Duration // Expired items are served for this long while revalidated (default: 0, disabled)
+	MaxStale             time.Duration // Expired items are served for this long while their revalidation fails (default: StaleWhileRevalidate)
+
+	CompressEntries  bool  // Gzip-compress the items of at least CompressMinBytes (default: false)
+	CompressMinBytes int64 // Smallest item compressed with CompressEntries (default: 64 KiB)
+	CopyOnRead       bool  // Serve copies of the items, which callers may modify (default: false, the items are shared)
+
+	SnapshotMaxItemBytes int64         // Entries larger than this are not snapshotted (default: no limit)
+	SnapshotMaxAge       time.Duration // Entries older than this are not snapshotted (default: no limit)
+
+	ExistenceTTL time.Duration // Existence markers answer Exists for this long (default: 10 seconds)
+
+	OnValidation func(stats ValidationStats) // Called after every run of the validation routine (default: none)
+
+	Clock clock.Clock // Tells the age of the entries and ticks the validation routine (default: clock.Real)
 }
 
 type FileCache struct {
@@ -28,42 +57,118 @@ type FileCache struct {
 	File    map[string]*FileInformation // In-memory map to store cached files
 	Options CacheOptions                // Cache configuration options
 
+	sketch *frequencySketch           // Created on the first store when AdmissionMinHits > 1
+	exists map[string]existenceMarker // Created on the first MarkExists, see Exists
+	stats  Stats                      // Counters of the cache, see Stats
+
 	// lifecycle validation routine
-	valMu     sync.Mutex
-	valCancel context.CancelFunc
-	valWG     sync.WaitGroup
+	valMu       sync.Mutex // Held across the starts and stops of the routine
+	valCancel   context.CancelFunc
+	valInterval time.Duration // Interval the routine was started with
+	valWG       sync.WaitGroup
 }
 
-// Store adds a file to the cache.
+// Store adds a file to the cache, unless it is larger than the maximum item size or, with
+// AdmissionMinHits, it has been read fewer than AdmissionMinHits times within the window,
+// so that one-off reads do not evict the items read repeatedly. Every read is counted once:
+// by GetFile when served from the cache, by Store otherwise.
 func (s *FileCache) Store(fileName string, data []byte) {
+	s.StoreWithTTL(fileName, data, 0)
+}
+
+// StoreWithTTL adds a file to the cache like Store, expiring after ttl instead of the
+// cache TTL when ttl is positive.
+func (s *FileCache) StoreWithTTL(fileName string, data []byte, ttl time.Duration) {
 	if !s.Enabled() {
 		return
 	}
 
+	s.mu.Lock()
+	admitted := s.admitLocked(fileName, data)
+	s.mu.Unlock()
+	if !admitted {
+		return
+	}
+
+	entry := s.newEntry(data, s.now())
+	entry.ttl = ttl
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.storeLocked(fileName, entry)
+}
 
-	size := int64(len(data))
-	if size > int64(s.Options.MaxSizeMB*1024*1024) {
-		return
+// admitLocked reports whether a file passes the size limit and the admission filter.
+// The caller must hold s.mu.
+func (s *FileCache) admitLocked(fileName string, data []byte) bool {
+	if int64(len(data)) > s.maxItemBytes() {
+		return false
+	}
+
+	if s.Options.AdmissionMinHits > 1 {
+		if s.sketch == nil {
+			window := s.Options.AdmissionWindow
+			if window <= 0 {
+				window = 10 * s.Options.MaxItems
+			}
+			s.sketch = newFrequencySketch(window)
+		}
+		_, cached := s.File[fileName]
+		if hits := s.sketch.record(fileName); !cached && hits < s.Options.AdmissionMinHits {
+			return false
+		}
 	}
+	return true
+}
+
+// Preload adds a file to the cache like Store, bypassing the admission filter,
+// for the items explicitly requested to be cached.
+func (s *FileCache) Preload(fileName string, data []byte) {
+	s.PreloadWithTTL(fileName, data, 0)
+}
 
-	// If the file already exists, update its data and timestamp
-	if _, exists := s.File[fileName]; exists {
-		s.File[fileName].data = data
-		s.File[fileName].createAt = time.Now()
+// PreloadWithTTL adds a file to the cache like Preload, expiring after ttl instead of the
+// cache TTL when ttl is positive.
+func (s *FileCache) PreloadWithTTL(fileName string, data []byte, ttl time.Duration) {
+	if !s.Enabled() || int64(len(data)) > s.MaxItemBytes() {
 		return
 	}
 
-	s.File[fileName] = &FileInformation{
-		data:     data,
-		createAt: time.Now(),
+	entry := s.newEntry(data, s.now())
+	entry.ttl = ttl
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.storeLocked(fileName, entry)
+}
+
+// MaxItemBytes returns the size in bytes of the largest item the cache admits.
+func (s *FileCache) MaxItemBytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxItemBytes()
+}
+
+// maxItemBytes is MaxItemBytes for callers holding s.mu.
+func (s *FileCache) maxItemBytes() int64 {
+	if s.Options.MaxItemSizeMB > 0 && s.Options.MaxItemSizeMB < s.Options.MaxSizeMB {
+		return s.Options.MaxItemSizeMB * 1024 * 1024
+	}
+	return s.Options.MaxSizeMB * 1024 * 1024
+}
+
+// storeLocked adds an entry to the cache, evicting the oldest item when the cache exceeds
+// the maximum number of items. The caller must hold s.mu.
+func (s *FileCache) storeLocked(fileName string, entry *FileInformation) {
+	// If the file already exists, its entry is replaced
+	_, exists := s.File[fileName]
+	s.File[fileName] = entry
+	if exists {
+		return
 	}
 
 	// If the cache exceeds the maximum number of items, remove the oldest item
 	if len(s.File) > s.Options.MaxItems {
 		var oldestFile string
-		var oldestTime = time.Now()
+		var oldestTime = s.now()
 		for name, file := range s.File {
 			if file.createAt.Before(oldestTime) {
 				oldestTime = file.createAt
@@ -76,41 +181,148 @@ func (s *FileCache) Store(fileName string, data []byte) {
 
 // GetFile retrieves a file from the cache.
 // Returns nil if the file is not found or has expired.
-// If has expired, it is removed from the cache.
+// If has expired past the stale windows, it is removed from the cache.
 func (s *FileCache) GetFile(fileName string) io.ReadCloser {
+	rc, _, _ := s.lookup(fileName, false)
+	return rc
+}
+
+// Lookup retrieves a file from the cache like GetFile and, with StaleWhileRevalidate, serves the
+// expired files as well: within StaleWhileRevalidate after their expiry, or within MaxStale while
+// their revalidation fails. A stale file is reported with the time it was stored, to be passed to
+// Revalidate or RevalidationFailed. Files expired past the stale windows are removed from the cache.
+func (s *FileCache) Lookup(fileName string) (rc io.ReadCloser, stale bool, storedAt time.Time) {
+	return s.lookup(fileName, true)
+}
+
+// lookup implements GetFile and, with allowStale, Lookup.
+func (s *FileCache) lookup(fileName string, allowStale bool) (rc io.ReadCloser, stale bool, storedAt time.Time) {
 	if !s.Enabled() {
-		return nil
+		return nil, false, time.Time{}
+	}
+
+	fileInfo, stale := s.lookupEntry(fileName, allowStale)
+	if fileInfo == nil {
+		return nil, false, time.Time{}
 	}
 
+	// the entry is not modified once stored, so it is decompressed without holding the lock
+	data, err := fileInfo.content()
+	if err != nil {
+		return nil, false, time.Time{}
+	}
+	if s.Options.CopyOnRead && !fileInfo.compressed {
+		data = bytes.Clone(data)
+	}
+	return NewReadCloser(data), stale, fileInfo.createAt
+}
+
+// lookupEntry returns the entry of a file served by lookup, counting the hit.
+func (s *FileCache) lookupEntry(fileName string, allowStale bool) (*FileInformation, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	fileInfo, exists := s.File[fileName]
 	if !exists {
-		return nil
+		return nil, false
 	}
 
-	if fileInfo.createAt.Before(time.Now().Add(-s.Options.TTL)) {
-		delete(s.File, fileName)
-		return nil
+	stale := false
+	ttl := s.ttlOf(fileInfo)
+	age := s.now().Sub(fileInfo.createAt)
+	if age > ttl {
+		window := s.Options.StaleWhileRevalidate
+		if fileInfo.refreshFailed {
+			window = max(window, s.maxStaleLocked())
+		}
+		if !allowStale || age > ttl+window {
+			if age > s.retentionLocked(ttl) {
+				delete(s.File, fileName)
+			}
+			return nil, false
+		}
+		stale = true
 	}
 
-	return io.NopCloser(bytes.NewReader(fileInfo.data))
+	fileInfo.hits++
+	if s.sketch != nil {
+		s.sketch.record(fileName)
+	}
+	return fileInfo, stale
 }
 
-// Invalidate removes a file from the cache.
+// Revalidate replaces the data of a stale file with data fetched since it was served,
+// unless the file was invalidated or replaced meanwhile, and reports whether it did.
+func (s *FileCache) Revalidate(fileName string, storedAt time.Time, data []byte) bool {
+	entry := s.newEntry(data, s.now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fileInfo, exists := s.File[fileName]
+	if !exists || !fileInfo.createAt.Equal(storedAt) || entry.size > s.maxItemBytes() {
+		return false
+	}
+	entry.ttl = fileInfo.ttl
+	s.storeLocked(fileName, entry)
+	return true
+}
+
+// RevalidationFailed records that the revalidation of a stale file failed, so that it
+// is served within MaxStale after its expiry, unless it was invalidated or replaced meanwhile.
+func (s *FileCache) RevalidationFailed(fileName string, storedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fileInfo, exists := s.File[fileName]; exists && fileInfo.createAt.Equal(storedAt) {
+		fileInfo.refreshFailed = true
+	}
+}
+
+// maxStaleLocked returns the stale window of the files whose revalidation failed.
+// The caller must hold s.mu.
+func (s *FileCache) maxStaleLocked() time.Duration {
+	if s.Options.MaxStale > 0 {
+		return s.Options.MaxStale
+	}
+	return s.Options.StaleWhileRevalidate
+}
+
+// retentionLocked returns how long the files expiring after ttl are kept after being stored:
+// the TTL, extended by the stale windows. The caller must hold s.mu.
+func (s *FileCache) retentionLocked(ttl time.Duration) time.Duration {
+	return ttl + max(s.Options.StaleWhileRevalidate, s.maxStaleLocked())
+}
+
+// ttlOf returns the time-to-live of a file: its own, or the cache TTL.
+func (s *FileCache) ttlOf(file *FileInformation) time.Duration {
+	if file.ttl > 0 {
+		return file.ttl
+	}
+	return s.Options.TTL
+}
+
+// Invalidate removes a file from the cache, along with its existence marker.
 func (s *FileCache) Invalidate(fileName string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	delete(s.File, fileName)
+	delete(s.exists, fileName)
 }
 
-// Clear removes all files from the cache.
-func (s *FileCache) Clear() {
+// Clear removes all files from the cache. It implements cache.Backend and never fails.
+func (s *FileCache) Clear(_ context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.File = make(map[string]*FileInformation)
+	s.exists = nil
+	return nil
+}
+
+// now returns the time of the clock of the cache.
+func (s *FileCache) now() time.Time {
+	return clock.Or(s.Options.Clock).Now()
 }
 
 func (s *FileCache) Enabled() bool {
@@ -126,9 +338,15 @@ func (s *FileCache) StartValidationRoutine() error {
 
 	s.valMu.Lock()
 	defer s.valMu.Unlock()
+	s.startValidationLocked()
+	return nil
+}
 
+// startValidationLocked starts the validation routine, unless it is running or there is nothing
+// to validate. The caller must hold s.valMu.
+func (s *FileCache) startValidationLocked() {
 	if s.valCancel != nil {
-		return nil
+		return
 	}
 
 	s.mu.Lock()
@@ -136,50 +354,44 @@ func (s *FileCache) StartValidationRoutine() error {
 	enabled := s.Options.Enabled
 	s.mu.Unlock()
 
-	if !enabled || v == nil || v.Strategy == NO_VALIDATION {
-		return nil
-	}
-
-	iv := v.ValidationInterval
-	if iv <= 0 {
-		iv = 30 * time.Minute
+	if !enabled || !v.active() {
+		return
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	s.valCancel = cancel
+	s.valInterval = v.interval()
 	s.valWG.Add(1)
 
 	go func(interval time.Duration) {
 		defer s.valWG.Done()
-		ticker := time.NewTicker(interval)
+		ticker := clock.Or(s.Options.Clock).NewTicker(interval)
 		defer ticker.Stop()
 
 		for {
 			select {
-			case <-ticker.C:
+			case <-ticker.C():
 				s.mu.Lock()
 				v := s.Options.ValidationOptions
 				enabled := s.Options.Enabled
-				curIV := interval
-				if v != nil && v.ValidationInterval > 0 {
-					curIV = v.ValidationInterval
-				}
-				stop := !enabled || v == nil || v.Strategy == NO_VALIDATION
-				change := curIV != interval
 				s.mu.Unlock()
 
-				if stop || change {
-					return
+				// the routine keeps running until stopped, skipping the ticks with nothing to validate,
+				// and follows the interval of the options in place
+				if !enabled || !v.active() {
+					continue
+				}
+				if cur := v.interval(); cur != interval {
+					interval = cur
+					ticker.Reset(interval)
 				}
-				s.validateCache()
+				s.validateCache(interval)
 
 			case <-ctx.Done():
 				return
 			}
 		}
-	}(iv)
-
-	return nil
+	}(s.valInterval)
 }
 
 // StopValidationRoutine stops the cache validation routine if it is running.
@@ -187,64 +399,84 @@ func (s *FileCache) StopValidationRoutine() {
 	if s == nil {
 		return
 	}
-	s.valMu.Lock()
-	cancel := s.valCancel
-	s.valCancel = nil
-	s.valMu.Unlock()
 
-	if cancel != nil {
-		cancel()
-		s.valWG.Wait()
-	}
+	s.valMu.Lock()
+	defer s.valMu.Unlock()
+	s.stopValidationLocked()
 }
 
-// validateCache performs cache validation based on the configured strategy.
-func (s *FileCache) validateCache() error {
-	s.mu.Lock()
-	v := s.Options.ValidationOptions
-	s.mu.Unlock()
-
-	runner, err := ValidationStrategyFactory(v)
-	if err != nil {
-		return fmt.Errorf("failed to create validation strategy: %w", err)
+// stopValidationLocked stops the validation routine and waits for it to return.
+// The caller must hold s.valMu.
+func (s *FileCache) stopValidationLocked() {
+	if s.valCancel == nil {
+		return
 	}
-	return runner.Apply(s)
+	s.valCancel()
+	s.valCancel = nil
+	s.valWG.Wait()
 }
 
+// ValidationStrategyFactory returns the runner of the validation options: the Runner of the
+// options when set, else the runner built by the constructor registered for their strategy.
 func ValidationStrategyFactory(v *ValidationOptions) (ValidationRunner, error) {
+	if v != nil && v.Runner != nil {
+		return v.Runner, nil
+	}
 	if v == nil || v.Strategy == NO_VALIDATION {
 		return nil, nil
 	}
 
-	switch v.Strategy {
-
-	case SAMPLING_VALIDATION:
-		return &SamplingValidation{SampleRate: v.SamplingPercent}, nil
-
-	default:
+	constructor, ok := validationConstructor(v.Strategy)
+	if !ok {
 		return nil, fmt.Errorf("unsupported validation strategy: %v", v.Strategy)
 	}
+	return constructor(v)
 }
 
+// SetValidationOptions replaces the validation options and restarts the validation routine
+// when its interval changes, or starts or stops it as needed. It is safe to call concurrently
+// with StartValidationRoutine and StopValidationRoutine, and setting the current options does nothing.
 func (s *FileCache) SetValidationOptions(v *ValidationOptions) {
+	s.valMu.Lock()
+	defer s.valMu.Unlock()
+
 	s.mu.Lock()
 	s.Options.ValidationOptions = v
+	enabled := s.Options.Enabled
 	s.mu.Unlock()
 
-	s.StopValidationRoutine()
-	_ = s.StartValidationRoutine()
+	if s.valCancel != nil && (!enabled || !v.active() || v.interval() != s.valInterval) {
+		s.stopValidationLocked()
+	}
+	s.startValidationLocked()
 }
 
 type ValidationOptions struct {
 	Strategy           Strategy
 	SamplingPercent    uint8
 	ValidationInterval time.Duration
+	Runner             ValidationRunner // Applied instead of the runner of the strategy, when set
+}
+
+// active reports whether v validates anything.
+func (v *ValidationOptions) active() bool {
+	return v != nil && (v.Strategy != NO_VALIDATION || v.Runner != nil)
 }
+
+// interval returns the validation interval of v, 30 minutes when not set.
+func (v *ValidationOptions) interval() time.Duration {
+	if v == nil || v.ValidationInterval <= 0 {
+		return 30 * time.Minute
+	}
+	return v.ValidationInterval
+}
+
 type Strategy int
 
 const (
 	NO_VALIDATION Strategy = iota
 	SAMPLING_VALIDATION
+	CUSTOM_VALIDATION // Validated by the Runner of the options only
 )
 
 type ValidationRunner interface {
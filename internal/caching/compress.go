@@ -0,0 +1,91 @@
+package caching
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// defaultCompressMinBytes is the default CompressMinBytes: smaller items gain little from the
+// compression, which would cost a gzip header and a decompression on every read.
+const defaultCompressMinBytes = 64 << 10
+
+// newEntry returns the entry holding data, created at createAt. With CompressEntries, data of at
+// least CompressMinBytes is gzip-compressed at the fastest level, and kept as is when it does not
+// shrink by at least an eighth, e.g. when it is already compressed.
+// It compresses without holding s.mu, so that the cache is not stalled meanwhile.
+func (s *FileCache) newEntry(data []byte, createAt time.Time) *FileInformation {
+	entry := &FileInformation{data: data, size: int64(len(data)), createAt: createAt}
+
+	minBytes := s.Options.CompressMinBytes
+	if minBytes <= 0 {
+		minBytes = defaultCompressMinBytes
+	}
+	if !s.Options.CompressEntries || int64(len(data)) < minBytes {
+		return entry
+	}
+
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return entry
+	}
+	if _, err := zw.Write(data); err != nil {
+		return entry
+	}
+	if err := zw.Close(); err != nil {
+		return entry
+	}
+	if buf.Len() > len(data)-len(data)/8 {
+		return entry
+	}
+
+	entry.data = bytes.Clone(buf.Bytes())
+	entry.compressed = true
+	return entry
+}
+
+// content returns the data of the entry, decompressed when needed.
+func (f *FileInformation) content() ([]byte, error) {
+	if !f.compressed {
+		return f.data, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(f.data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	data := make([]byte, 0, f.size)
+	buf := bytes.NewBuffer(data)
+	if _, err := io.Copy(buf, zr); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CurrentSizeBytes returns the memory held by the data of the cached items, compressed or not.
+func (s *FileCache) CurrentSizeBytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total int64
+	for _, file := range s.File {
+		total += int64(len(file.data))
+	}
+	return total
+}
+
+// LogicalSizeBytes returns the size of the cached items once decompressed.
+func (s *FileCache) LogicalSizeBytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total int64
+	for _, file := range s.File {
+		total += file.size
+	}
+	return total
+}
@@ -0,0 +1,81 @@
+package caching
+
+import "time"
+
+// Defaults of the existence markers.
+const (
+	defaultExistenceTTL = 10 * time.Second
+	maxExistenceMarkers = 10000
+)
+
+// existenceMarker records whether a file exists, as last checked, put or removed.
+type existenceMarker struct {
+	exists bool
+	at     time.Time
+}
+
+// Exists reports whether a file exists, as known to the cache: a file cached and not expired exists,
+// else the marker recorded by MarkExists answers within ExistenceTTL. It reports known false when
+// the cache does not know, and the storages must be checked. The hits are not counted.
+func (s *FileCache) Exists(fileName string) (exists bool, known bool) {
+	if !s.Enabled() {
+		return false, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if file, ok := s.File[fileName]; ok && now.Sub(file.createAt) <= s.ttlOf(file) {
+		return true, true
+	}
+	marker, ok := s.exists[fileName]
+	if !ok {
+		return false, false
+	}
+	if now.Sub(marker.at) > s.existenceTTL() {
+		delete(s.exists, fileName)
+		return false, false
+	}
+	return marker.exists, true
+}
+
+// MarkExists records whether a file exists, e.g. once checked on the storages, put or removed,
+// so that Exists answers for ExistenceTTL. At most 10000 markers are kept: once full, the expired
+// markers are dropped and, when none is, an arbitrary one.
+func (s *FileCache) MarkExists(fileName string, exists bool) {
+	if !s.Enabled() {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.exists == nil {
+		s.exists = make(map[string]existenceMarker)
+	}
+	if _, ok := s.exists[fileName]; !ok && len(s.exists) >= maxExistenceMarkers {
+		ttl := s.existenceTTL()
+		for key, marker := range s.exists {
+			if now.Sub(marker.at) > ttl {
+				delete(s.exists, key)
+			}
+		}
+		if len(s.exists) >= maxExistenceMarkers {
+			for key := range s.exists {
+				delete(s.exists, key)
+				break
+			}
+		}
+	}
+	s.exists[fileName] = existenceMarker{exists: exists, at: now}
+}
+
+// existenceTTL returns how long the existence markers answer.
+func (s *FileCache) existenceTTL() time.Duration {
+	if s.Options.ExistenceTTL > 0 {
+		return s.Options.ExistenceTTL
+	}
+	return defaultExistenceTTL
+}
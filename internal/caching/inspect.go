@@ -0,0 +1,80 @@
+package caching
+
+import (
+	"sort"
+	"time"
+)
+
+// entriesPageSize is the number of entries described per acquisition of the lock by Entries.
+const entriesPageSize = 256
+
+// EntryInfo describes a cached item, without its content.
+type EntryInfo struct {
+	Key         string    // Key of the item in the cache
+	SizeBytes   int64     // Size of the cached content
+	StoredBytes int64     // Memory held by the cached content, smaller than SizeBytes when compressed
+	StoredAt    time.Time // When the content was stored, or restored from a snapshot with its original time
+	ExpiresAt   time.Time // When the item expires, after the TTL
+	Hits        int64     // Reads served from the item since its content was stored
+}
+
+// Entries describes the items of the cache which are not expired, sorted by key. The keys are
+// collected first, then described page by page, releasing the lock in between so that the
+// cache is not stalled: items stored meanwhile are not reported, items removed meanwhile are skipped.
+func (s *FileCache) Entries() []EntryInfo {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.File))
+	for key := range s.File {
+		keys = append(keys, key)
+	}
+	s.mu.Unlock()
+	sort.Strings(keys)
+
+	entries := make([]EntryInfo, 0, len(keys))
+	for start := 0; start < len(keys); start += entriesPageSize {
+		now := s.now()
+		s.mu.Lock()
+		for _, key := range keys[start:min(start+entriesPageSize, len(keys))] {
+			if info, ok := s.entryLocked(key, now); ok {
+				entries = append(entries, info)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return entries
+}
+
+// Entry describes the item stored under key, reporting false when it is missing or expired.
+func (s *FileCache) Entry(key string) (EntryInfo, bool) {
+	if s == nil {
+		return EntryInfo{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entryLocked(key, s.now())
+}
+
+// entryLocked describes the item stored under key, if not expired at now. The caller must hold s.mu.
+func (s *FileCache) entryLocked(key string, now time.Time) (EntryInfo, bool) {
+	file, ok := s.File[key]
+	if !ok || file == nil {
+		return EntryInfo{}, false
+	}
+	expiresAt := file.createAt.Add(s.ttlOf(file))
+	if expiresAt.Before(now) {
+		return EntryInfo{}, false
+	}
+	return EntryInfo{
+		Key:         key,
+		SizeBytes:   file.size,
+		StoredBytes: int64(len(file.data)),
+		StoredAt:    file.createAt,
+		ExpiresAt:   expiresAt,
+		Hits:        file.hits,
+	}, true
+}
@@ -0,0 +1,27 @@
+package caching
+
+import "bytes"
+
+// ReadCloser serves an in-memory object. Besides io.ReadCloser, it implements
+// io.WriterTo, io.Seeker and io.ReaderAt, so that io.Copy writes the data without
+// intermediate buffers and http.ServeContent can serve ranges from it.
+type ReadCloser struct {
+	*bytes.Reader
+	data []byte
+}
+
+// NewReadCloser returns a ReadCloser over data. The data is not copied.
+func NewReadCloser(data []byte) *ReadCloser {
+	return &ReadCloser{Reader: bytes.NewReader(data), data: data}
+}
+
+// Bytes returns the whole object, whatever has been read. The slice may be shared with the
+// cache: it must not be modified, and is valid until Close.
+func (r *ReadCloser) Bytes() []byte {
+	return r.data
+}
+
+// Close is a no-op, since the data is held in memory.
+func (r *ReadCloser) Close() error {
+	return nil
+}
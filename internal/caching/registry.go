@@ -0,0 +1,47 @@
+package caching
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ValidationConstructor builds the runner of a validation strategy from its options.
+type ValidationConstructor func(*ValidationOptions) (ValidationRunner, error)
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = map[Strategy]ValidationConstructor{
+		SAMPLING_VALIDATION: func(v *ValidationOptions) (ValidationRunner, error) {
+			return &SamplingValidation{SampleRate: v.SamplingPercent}, nil
+		},
+	}
+)
+
+// RegisterValidationStrategy registers the constructor of the runner of a validation strategy, so that
+// ValidationStrategyFactory builds it for the options with that strategy, e.g. to validate the cached
+// items against a database of authoritative hashes. Registering a strategy twice, the built-in ones
+// included, fails; it is safe to register strategies while the caches are validated.
+func RegisterValidationStrategy(name Strategy, constructor ValidationConstructor) error {
+	if name == NO_VALIDATION || name == CUSTOM_VALIDATION {
+		return fmt.Errorf("validation strategy %v is reserved", name)
+	}
+	if constructor == nil {
+		return fmt.Errorf("validation strategy %v: constructor is nil", name)
+	}
+
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	if _, exists := strategies[name]; exists {
+		return fmt.Errorf("validation strategy %v is already registered", name)
+	}
+	strategies[name] = constructor
+	return nil
+}
+
+// validationConstructor returns the constructor registered for a strategy.
+func validationConstructor(name Strategy) (ValidationConstructor, bool) {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	constructor, ok := strategies[name]
+	return constructor, ok
+}
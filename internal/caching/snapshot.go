@@ -0,0 +1,181 @@
+package caching
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ErrCorruptSnapshot is returned by LoadSnapshot when the snapshot file cannot be decoded.
+var ErrCorruptSnapshot = errors.New("corrupt cache snapshot")
+
+// snapshotMagic starts every snapshot file, followed by the gob encoding of a snapshot.
+const snapshotMagic = "M2CS-CACHE-SNAPSHOT/1\n"
+
+type snapshot struct {
+	SavedAt time.Time
+	Entries []snapshotEntry
+}
+
+type snapshotEntry struct {
+	Key          string
+	Data         []byte // Data as cached, gzip-compressed when Compressed
+	Size         int64  // Size of the data once decompressed
+	Compressed   bool
+	CreateAt     time.Time
+	TTL          time.Duration // Time-to-live of the entry, when it overrides the cache TTL
+	TTLRemaining time.Duration // Time left to the entry when the snapshot was saved
+	Checksum     uint32        // CRC-32 (IEEE) of Data
+}
+
+// SaveSnapshot writes the entries of the cache to path, except the expired ones and those
+// filtered out by SnapshotMaxItemBytes and SnapshotMaxAge. The snapshot is written to a
+// temporary file renamed over path, so that a failed save leaves the previous snapshot intact.
+func (s *FileCache) SaveSnapshot(path string) error {
+	if s == nil {
+		return fmt.Errorf("cache is nil")
+	}
+
+	now := s.now()
+	snap := snapshot{SavedAt: now}
+
+	s.mu.Lock()
+	for key, file := range s.File {
+		if !s.keepInSnapshot(file.size, file.createAt, s.ttlOf(file), now) {
+			continue
+		}
+		snap.Entries = append(snap.Entries, snapshotEntry{
+			Key:          key,
+			Data:         file.data,
+			Size:         file.size,
+			Compressed:   file.compressed,
+			CreateAt:     file.createAt,
+			TTL:          file.ttl,
+			TTLRemaining: file.createAt.Add(s.ttlOf(file)).Sub(now),
+			Checksum:     crc32.ChecksumIEEE(file.data),
+		})
+	}
+	s.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if _, err := io.WriteString(w, snapshotMagic); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := gob.NewEncoder(w).Encode(snap); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// LoadSnapshot adds the entries saved in path to the cache, keeping their creation time, so that
+// they expire as if the cache had not been restarted. Expired entries, entries filtered out by
+// SnapshotMaxItemBytes and SnapshotMaxAge or larger than the maximum item size, and entries older than the
+// cached ones are skipped; when the entries exceed MaxItems, the oldest ones are evicted.
+// A snapshot which cannot be decoded, or whose entries do not match their checksum, fails with
+// ErrCorruptSnapshot and leaves the cache unchanged.
+func (s *FileCache) LoadSnapshot(path string) error {
+	if s == nil {
+		return fmt.Errorf("cache is nil")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open cache snapshot: %w", err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != snapshotMagic {
+		return fmt.Errorf("%w: %s is not a cache snapshot", ErrCorruptSnapshot, path)
+	}
+	var snap snapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	for _, entry := range snap.Entries {
+		if crc32.ChecksumIEEE(entry.Data) != entry.Checksum {
+			return fmt.Errorf("%w: checksum mismatch for %s", ErrCorruptSnapshot, entry.Key)
+		}
+	}
+
+	// the oldest entries are added first, so that they are the ones evicted
+	sort.Slice(snap.Entries, func(i, j int) bool {
+		return snap.Entries[i].CreateAt.Before(snap.Entries[j].CreateAt)
+	})
+
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range snap.Entries {
+		if !snap.SavedAt.Add(entry.TTLRemaining).After(now) {
+			continue
+		}
+		ttl := entry.TTL
+		if ttl <= 0 {
+			ttl = s.Options.TTL
+		}
+		if !s.keepInSnapshot(entry.Size, entry.CreateAt, ttl, now) {
+			continue
+		}
+		if entry.Size > s.maxItemBytes() {
+			continue
+		}
+		if cached, exists := s.File[entry.Key]; exists && !cached.createAt.Before(entry.CreateAt) {
+			continue
+		}
+		s.storeLocked(entry.Key, &FileInformation{
+			data:       entry.Data,
+			size:       entry.Size,
+			compressed: entry.Compressed,
+			createAt:   entry.CreateAt,
+			ttl:        entry.TTL,
+		})
+	}
+
+	return nil
+}
+
+// keepInSnapshot reports whether an entry expiring after ttl is neither expired nor filtered
+// out by the snapshot options. The caller must hold s.mu.
+func (s *FileCache) keepInSnapshot(size int64, createAt time.Time, ttl time.Duration, now time.Time) bool {
+	age := now.Sub(createAt)
+	if age >= ttl {
+		return false
+	}
+	if s.Options.SnapshotMaxAge > 0 && age > s.Options.SnapshotMaxAge {
+		return false
+	}
+	if s.Options.SnapshotMaxItemBytes > 0 && size > s.Options.SnapshotMaxItemBytes {
+		return false
+	}
+	return true
+}
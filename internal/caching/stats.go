@@ -0,0 +1,100 @@
+package caching
+
+import (
+	"fmt"
+	"time"
+)
+
+// ValidationStats describes a run of the validation routine, see CacheOptions.OnValidation.
+// Runners not implementing StatsRunner report neither the entries scanned nor the expired ones.
+type ValidationStats struct {
+	Strategy   string        // Name of the strategy, e.g. "SAMPLING_VALIDATION"
+	Scanned    int           // Entries in the cache listed by the strategy
+	Expired    int           // Entries removed as expired
+	SampleSize int           // Entries checked among the scanned ones, by SAMPLING_VALIDATION only
+	Duration   time.Duration // Duration of the run
+	NextTick   time.Time     // When the routine runs next, unless its options change
+	Err        error         // Failure of the run, nil when it succeeded
+}
+
+// StatsRunner is implemented by the validation runners reporting the entries they validated.
+// The routine fills Strategy, Duration, NextTick and Err of the returned stats.
+type StatsRunner interface {
+	ApplyWithStats(cache *FileCache) (ValidationStats, error)
+}
+
+// Stats holds the counters of a cache, cumulated since it was created.
+type Stats struct {
+	ValidationRuns   int64           // Runs of the validation routine
+	ValidationErrors int64           // Runs of the validation routine which failed
+	EntriesScanned   int64           // Entries listed by the validation runs
+	EntriesExpired   int64           // Entries removed as expired by the validation runs
+	LastValidation   ValidationStats // Last run of the validation routine, zero before the first one
+}
+
+// Stats returns the counters of the cache.
+func (s *FileCache) Stats() Stats {
+	if s == nil {
+		return Stats{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// String returns the name of the strategy.
+func (s Strategy) String() string {
+	switch s {
+	case NO_VALIDATION:
+		return "NO_VALIDATION"
+	case SAMPLING_VALIDATION:
+		return "SAMPLING_VALIDATION"
+	case CUSTOM_VALIDATION:
+		return "CUSTOM_VALIDATION"
+	default:
+		return fmt.Sprintf("Strategy(%d)", int(s))
+	}
+}
+
+// validateCache runs the validation routine once, with the options in place, recording the
+// stats of the run and passing them to OnValidation. interval is the one of the routine.
+func (s *FileCache) validateCache(interval time.Duration) {
+	s.mu.Lock()
+	v := s.Options.ValidationOptions
+	hook := s.Options.OnValidation
+	s.mu.Unlock()
+
+	start := s.now()
+	var stats ValidationStats
+	runner, err := ValidationStrategyFactory(v)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("failed to create validation strategy: %w", err)
+	case runner == nil:
+		return
+	default:
+		if statsRunner, ok := runner.(StatsRunner); ok {
+			stats, err = statsRunner.ApplyWithStats(s)
+		} else {
+			err = runner.Apply(s)
+		}
+	}
+	stats.Strategy = v.Strategy.String()
+	stats.Duration = s.now().Sub(start)
+	stats.NextTick = start.Add(interval)
+	stats.Err = err
+
+	s.mu.Lock()
+	s.stats.ValidationRuns++
+	if err != nil {
+		s.stats.ValidationErrors++
+	}
+	s.stats.EntriesScanned += int64(stats.Scanned)
+	s.stats.EntriesExpired += int64(stats.Expired)
+	s.stats.LastValidation = stats
+	s.mu.Unlock()
+
+	if hook != nil {
+		hook(stats)
+	}
+}
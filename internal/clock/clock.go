@@ -0,0 +1,46 @@
+// Package clock abstracts the time read and waited for by the cache, the retries and the
+// replication journal, so that tests can drive it instead of sleeping.
+package clock
+
+import "time"
+
+// Clock tells the time and waits for it to pass. Real is the one of the system.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker       // Like time.NewTicker
+	After(d time.Duration) <-chan time.Time // Like time.After
+	Sleep(d time.Duration)                  // Like time.Sleep
+}
+
+// Ticker delivers the ticks of a Clock, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// Real is the Clock of the system, backed by the time package.
+var Real Clock = realClock{}
+
+// Or returns c, or Real when c is nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -9,6 +9,9 @@ type AuthConfig struct {
 	accessKey            string
 	secretKey            string
 	connectionString     string
+	serviceURL           string
+	sasToken             string
+	profile              string // Name of the credential profile, if any
 	connectionProperties common.Properties
 }
 
@@ -32,6 +35,18 @@ func (a *AuthConfig) GetConnectionString() string {
 	return a.connectionString
 }
 
+func (a *AuthConfig) GetServiceURL() string {
+	return a.serviceURL
+}
+
+func (a *AuthConfig) GetSASToken() string {
+	return a.sasToken
+}
+
+func (a *AuthConfig) GetProfile() string {
+	return a.profile
+}
+
 func (a *AuthConfig) SetConnectType(connectType string) {
 	a.connectType = connectType
 }
@@ -48,6 +63,18 @@ func (a *AuthConfig) SetConnectionString(connectionString string) {
 	a.connectionString = connectionString
 }
 
+func (a *AuthConfig) SetServiceURL(serviceURL string) {
+	a.serviceURL = serviceURL
+}
+
+func (a *AuthConfig) SetSASToken(sasToken string) {
+	a.sasToken = sasToken
+}
+
+func (a *AuthConfig) SetProfile(profile string) {
+	a.profile = profile
+}
+
 func (a *AuthConfig) GetProperties() common.Properties {
 	return a.connectionProperties
 }
@@ -55,3 +82,11 @@ func (a *AuthConfig) GetProperties() common.Properties {
 func (a *AuthConfig) SetProperties(properties common.Properties) {
 	a.connectionProperties = properties
 }
+
+// WithProperties returns a copy of the configuration with the given properties, leaving a
+// unchanged, so that the same configuration can be shared by several connections.
+func (a *AuthConfig) WithProperties(properties common.Properties) *AuthConfig {
+	c := *a
+	c.connectionProperties = properties
+	return &c
+}
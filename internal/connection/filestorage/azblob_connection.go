@@ -1,21 +1,35 @@
 package connfilestorage
 
 import (
-	"context"
 	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
 	"github.com/tizianocitro/m2cs/internal/connection"
 	common "github.com/tizianocitro/m2cs/pkg"
 	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 )
 
 // CreateAzBlobConnection creates a new AzBlobClient.
 // It returns an AzBlobClient or an error if the connection could not be established.
-func CreateAzBlobConnection(endpoint string, config *connection.AuthConfig) (*filestorage.AzBlobClient, error) {
+func CreateAzBlobConnection(endpoint string, config *connection.AuthConfig) (conn *filestorage.AzBlobClient, err error) {
 	if config == nil {
 		return nil, fmt.Errorf("AuthConfig cannot be nil")
 	}
+	defer func() {
+		err = config.RedactError(err, os.Getenv("AZURE_STORAGE_ACCOUNT_KEY"))
+	}()
+
+	if err := config.GetProperties().ValidateEncryption(); err != nil {
+		return nil, fmt.Errorf("invalid Azure Blob connection: %w", err)
+	}
 
 	var azClient *azblob.Client = nil
 
@@ -37,7 +51,7 @@ func CreateAzBlobConnection(endpoint string, config *connection.AuthConfig) (*fi
 			accountURL = endpoint
 		}
 
-		client, err := azblob.NewClientWithSharedKeyCredential(accountURL, credential, nil)
+		client, err := azblob.NewClientWithSharedKeyCredential(accountURL, credential, azBlobOptions())
 		if err != nil {
 			return nil, fmt.Errorf("failed to create Azure Blob Storage client: %v", err)
 		}
@@ -61,19 +75,29 @@ func CreateAzBlobConnection(endpoint string, config *connection.AuthConfig) (*fi
 		} else {
 			accountURL = endpoint
 		}
-		client, err := azblob.NewClientWithSharedKeyCredential(accountURL, credential, nil)
+		client, err := azblob.NewClientWithSharedKeyCredential(accountURL, credential, azBlobOptions())
 		if err != nil {
 			return nil, fmt.Errorf("failed to create Azure Blob Storage client: %v", err)
 		}
 
 		azClient = client
 	case "withConnectionString":
-		client, err := azblob.NewClientFromConnectionString(config.GetConnectionString(), nil)
+		client, err := azblob.NewClientFromConnectionString(config.GetConnectionString(), azBlobOptions())
 		if err != nil {
 			return nil, fmt.Errorf("failed to create Azure Blob Storage client: %v", err)
 		}
 
 		azClient = client
+	case "withSASToken":
+		client, err := newAzBlobSASClient(config)
+		if err != nil {
+			return nil, err
+		}
+
+		azClient = client
+	case "anonymous":
+		return nil, fmt.Errorf("anonymous credentials are not supported for azure blob; " +
+			"use a SAS token instead")
 	default:
 		return nil, fmt.Errorf("invalid connection type for azure blob: %s", config.GetConnectType())
 	}
@@ -81,17 +105,91 @@ func CreateAzBlobConnection(endpoint string, config *connection.AuthConfig) (*fi
 		return nil, fmt.Errorf("client is not initialized")
 	}
 
-	pager := azClient.NewListContainersPager(nil)
-	_, err := pager.NextPage(context.TODO())
+	conn, err = filestorage.NewAzBlobClient(azClient, common.ConnectionProperties{
+		Label:                config.GetProperties().Label,
+		IsMainInstance:       config.GetProperties().IsMainInstance,
+		Role:                 config.GetProperties().Role,
+		SaveEncrypt:          config.GetProperties().SaveEncrypted,
+		SaveCompress:         config.GetProperties().SaveCompressed,
+		EncryptKey:           config.GetProperties().EncryptKey,
+		EncryptKeyBytes:      config.GetProperties().EncryptKeyBytes,
+		EncryptKeysByBox:     config.GetProperties().EncryptKeysByBox,
+		DecryptKeys:          config.GetProperties().DecryptKeys,
+		EncryptOnly:          config.GetProperties().EncryptOnly,
+		ProbeBox:             config.GetProperties().ProbeBox,
+		BoxAliases:           config.GetProperties().BoxAliases,
+		AutoCreateBox:        config.GetProperties().AutoCreateBox,
+		ReadOnly:             config.GetProperties().ReadOnly,
+		TransformRules:       config.GetProperties().TransformRules,
+		CompressionThreshold: config.GetProperties().CompressionThreshold})
+
+	return conn, err
+}
+
+// azBlobOptions returns the options of the Azure Blob clients, running the given per-call
+// policies, then the one attaching the request header, see requestHeaderPolicy.
+func azBlobOptions(policies ...policy.Policy) *azblob.ClientOptions {
+	return &azblob.ClientOptions{
+		ClientOptions: azcore.ClientOptions{PerCallPolicies: append(policies, requestHeaderPolicy{})},
+	}
+}
+
+// newAzBlobSASClient creates a client authorized by the SAS token appended to the service URL.
+// A container-scoped SAS cannot list the containers, so ProbeBox is required to check the connection.
+func newAzBlobSASClient(config *connection.AuthConfig) (*azblob.Client, error) {
+	if config.GetServiceURL() == "" || config.GetSASToken() == "" {
+		return nil, fmt.Errorf("service URL and/or SAS token not set")
+	}
+	if config.GetProperties().ProbeBox == "" {
+		return nil, fmt.Errorf("ProbeBox must be set with a SAS token, which may not allow listing the containers")
+	}
+
+	token := strings.TrimPrefix(config.GetSASToken(), "?")
+	values, err := url.ParseQuery(token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to azure blob: %w", err)
+		return nil, fmt.Errorf("malformed SAS token: %v", err)
 	}
 
-	conn, err := filestorage.NewAzBlobClient(azClient, common.ConnectionProperties{
-		IsMainInstance: config.GetProperties().IsMainInstance,
-		SaveEncrypt:    config.GetProperties().SaveEncrypted,
-		SaveCompress:   config.GetProperties().SaveCompressed,
-		EncryptKey:     config.GetProperties().EncryptKey})
+	params := sas.NewQueryParameters(values, false)
+	if params.Signature() == "" {
+		return nil, fmt.Errorf("malformed SAS token: no signature")
+	}
+	if expiry := params.ExpiryTime(); !expiry.IsZero() && time.Now().After(expiry) {
+		return nil, fmt.Errorf("SAS token expired at %s", expiry.Format(time.RFC3339))
+	}
+
+	serviceURL, err := url.Parse(config.GetServiceURL())
+	if err != nil {
+		return nil, fmt.Errorf("invalid service URL: %v", err)
+	}
+	serviceURL.RawQuery = token
+
+	client, err := azblob.NewClientWithNoCredential(serviceURL.String(), azBlobOptions(sasErrorPolicy{}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure Blob Storage client: %v", err)
+	}
+
+	return client, nil
+}
+
+// sasErrorPolicy turns the authorization failures of SAS requests into descriptive errors,
+// since the service only answers 403 without telling whether the token expired or lacks a permission.
+// The original *azcore.ResponseError is wrapped, so it is still reachable with errors.As.
+type sasErrorPolicy struct{}
 
-	return conn, nil
+func (sasErrorPolicy) Do(req *policy.Request) (*http.Response, error) {
+	resp, err := req.Next()
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		return resp, err
+	}
+
+	respErr := runtime.NewResponseError(resp)
+	switch resp.Header.Get("x-ms-error-code") {
+	case "AuthenticationFailed", "AuthorizationFailure":
+		return nil, fmt.Errorf("SAS token rejected for %s %s, it is invalid, expired or scoped to another resource: %w",
+			req.Raw().Method, req.Raw().URL.Path, respErr)
+	case "AuthorizationPermissionMismatch", "AuthorizationResourceTypeMismatch":
+		return nil, fmt.Errorf("SAS token does not allow %s %s: %w", req.Raw().Method, req.Raw().URL.Path, respErr)
+	}
+	return nil, respErr
 }
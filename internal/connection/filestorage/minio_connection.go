@@ -1,7 +1,7 @@
 package connfilestorage
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -15,12 +15,34 @@ import (
 // CreateMinioConnection creates a new MinioClient.
 // It takes an endpoint, an AuthConfig, and optional MinIO options.
 // It returns a MinioClient or an error if the connection could not be established.
-func CreateMinioConnection(endpoint string, config *connection.AuthConfig, minioOptions *minio.Options) (*filestorage.MinioClient, error) {
+// Without options, trailing headers are enabled, so that the uploads can carry additional checksums.
+func CreateMinioConnection(endpoint string, config *connection.AuthConfig, minioOptions *minio.Options) (conn *filestorage.MinioClient, err error) {
+	defer func() {
+		err = config.RedactError(err, os.Getenv("MINIO_SECRET_KEY"))
+	}()
+
+	if err := config.GetProperties().ValidateEncryption(); err != nil {
+		return nil, fmt.Errorf("invalid MinIO connection: %w", err)
+	}
+
 	if minioOptions == nil {
 		minioOptions = &minio.Options{
-			Secure: false,
+			Secure:          false,
+			TrailingHeaders: true,
+		}
+	}
+
+	// the transport of the options is wrapped, the caller's options are left as they are
+	options := *minioOptions
+	minioOptions = &options
+	if minioOptions.Transport == nil {
+		transport, err := minio.DefaultTransport(minioOptions.Secure)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create MinIO transport: %w", err)
 		}
+		minioOptions.Transport = transport
 	}
+	minioOptions.Transport = requestHeaderTransport{base: minioOptions.Transport}
 
 	if endpoint == "" || endpoint == "default" {
 		endpoint = "localhost:9000"
@@ -45,6 +67,24 @@ func CreateMinioConnection(endpoint string, config *connection.AuthConfig, minio
 			return nil, fmt.Errorf("environment variables MINIO_ACCESS_KEY and/or MINIO_SECRET_KEY are not set")
 		}
 		minioOptions.Creds = credentials.NewStaticV4(accessKey, secretKey, "")
+	case "withDefault":
+		creds := credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvMinio{},
+			&credentials.EnvAWS{},
+			&credentials.FileMinioClient{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+		if value, err := creds.Get(); err != nil || value.SignerType.IsAnonymous() {
+			return nil, fmt.Errorf("no MinIO credentials found by any provider " +
+				"(MINIO_*/AWS_* environment variables, mc config, AWS shared credentials, IAM/IRSA)")
+		}
+		minioOptions.Creds = creds
+	case "anonymous":
+		if config.GetProperties().ProbeBox == "" {
+			return nil, fmt.Errorf("ProbeBox must be set with anonymous credentials, which cannot list the buckets")
+		}
+		minioOptions.Creds = credentials.NewStatic("", "", "", credentials.SignatureAnonymous)
 
 	default:
 		return nil, fmt.Errorf("invalid connection type for MinIO: %s", config.GetConnectType())
@@ -55,16 +95,39 @@ func CreateMinioConnection(endpoint string, config *connection.AuthConfig, minio
 		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
 	}
 
-	_, err = minioClient.ListBuckets(context.Background())
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to MinIO: %w", err)
+	conn, err = filestorage.NewMinioClient(minioClient, common.ConnectionProperties{
+		Label:                config.GetProperties().Label,
+		IsMainInstance:       config.GetProperties().IsMainInstance,
+		Role:                 config.GetProperties().Role,
+		SaveEncrypt:          config.GetProperties().SaveEncrypted,
+		SaveCompress:         config.GetProperties().SaveCompressed,
+		EncryptKey:           config.GetProperties().EncryptKey,
+		EncryptKeyBytes:      config.GetProperties().EncryptKeyBytes,
+		EncryptKeysByBox:     config.GetProperties().EncryptKeysByBox,
+		DecryptKeys:          config.GetProperties().DecryptKeys,
+		EncryptOnly:          config.GetProperties().EncryptOnly,
+		ProbeBox:             config.GetProperties().ProbeBox,
+		BoxAliases:           config.GetProperties().BoxAliases,
+		AutoCreateBox:        config.GetProperties().AutoCreateBox,
+		ReadOnly:             config.GetProperties().ReadOnly,
+		TransformRules:       config.GetProperties().TransformRules,
+		CompressionThreshold: config.GetProperties().CompressionThreshold})
+	if err != nil && config.GetConnectType() == "withDefault" && minioCredentialsRejected(err) {
+		return nil, fmt.Errorf("MinIO credentials found by the default providers are invalid: %w", err)
 	}
 
-	conn, err := filestorage.NewMinioClient(minioClient, common.ConnectionProperties{
-		IsMainInstance: config.GetProperties().IsMainInstance,
-		SaveEncrypt:    config.GetProperties().SaveEncrypted,
-		SaveCompress:   config.GetProperties().SaveCompressed,
-		EncryptKey:     config.GetProperties().EncryptKey})
+	return conn, err
+}
 
-	return conn, nil
+// minioCredentialsRejected reports whether err is caused by unknown or mismatching credentials.
+func minioCredentialsRejected(err error) bool {
+	var resp minio.ErrorResponse
+	if !errors.As(err, &resp) {
+		return false
+	}
+	switch resp.Code {
+	case "InvalidAccessKeyId", "SignatureDoesNotMatch", "InvalidToken", "ExpiredToken":
+		return true
+	}
+	return false
 }
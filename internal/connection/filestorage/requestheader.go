@@ -0,0 +1,59 @@
+package connfilestorage
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/tizianocitro/m2cs/internal/connection"
+)
+
+// requestHeaderTransport attaches the header carried by the context of the requests, see
+// connection.WithRequestHeader, before sending them with base. MinIO signs the requests before
+// they reach the transport, so the header is sent unsigned.
+type requestHeaderTransport struct {
+	base http.RoundTripper
+}
+
+func (t requestHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name, value := connection.RequestHeader(req.Context())
+	if name == "" {
+		return t.base.RoundTrip(req)
+	}
+	// a RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set(name, value)
+	return t.base.RoundTrip(req)
+}
+
+// withS3RequestHeader adds a middleware attaching the header carried by the context of the calls,
+// see connection.WithRequestHeader, to the requests of the S3 client. It runs at the build step,
+// so that the header is signed along with the request.
+func withS3RequestHeader(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Build.Add(middleware.BuildMiddlewareFunc("M2csRequestHeader",
+			func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+				if name, value := connection.RequestHeader(ctx); name != "" {
+					if req, ok := in.Request.(*smithyhttp.Request); ok {
+						req.Header.Set(name, value)
+					}
+				}
+				return next.HandleBuild(ctx, in)
+			}), middleware.After)
+	})
+}
+
+// requestHeaderPolicy attaches the header carried by the context of the calls, see
+// connection.WithRequestHeader, to the requests of the Azure Blob client. Like every per-call
+// policy, it runs before the request is signed.
+type requestHeaderPolicy struct{}
+
+func (requestHeaderPolicy) Do(req *policy.Request) (*http.Response, error) {
+	if name, value := connection.RequestHeader(req.Raw().Context()); name != "" {
+		req.Raw().Header.Set(name, value)
+	}
+	return req.Next()
+}
@@ -2,11 +2,13 @@ package connfilestorage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	s3config "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
 	"github.com/tizianocitro/m2cs/internal/connection"
 	common "github.com/tizianocitro/m2cs/pkg"
 	"github.com/tizianocitro/m2cs/pkg/filestorage"
@@ -15,7 +17,15 @@ import (
 
 // CreateS3Connection creates a new S3Client.
 // It returns an S3Connection or an error if the connection could not be established.
-func CreateS3Connection(endpoint string, config *connection.AuthConfig, awsRegion string) (*filestorage.S3Client, error) {
+func CreateS3Connection(endpoint string, config *connection.AuthConfig, awsRegion string) (conn *filestorage.S3Client, err error) {
+	defer func() {
+		err = config.RedactError(err, os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	}()
+
+	if err := config.GetProperties().ValidateEncryption(); err != nil {
+		return nil, fmt.Errorf("invalid AWS S3 connection: %w", err)
+	}
+
 	if endpoint == "default" {
 		endpoint = ""
 	}
@@ -48,12 +58,12 @@ func CreateS3Connection(endpoint string, config *connection.AuthConfig, awsRegio
 		if endpoint == "" {
 			client = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
 				o.UsePathStyle = true
-			})
+			}, withS3RequestHeader)
 		} else {
 			client = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
 				o.UsePathStyle = true
 				o.BaseEndpoint = aws.String(endpoint)
-			})
+			}, withS3RequestHeader)
 		}
 	case "withEnv":
 		accountName := os.Getenv("AWS_ACCESS_KEY_ID")
@@ -72,13 +82,51 @@ func CreateS3Connection(endpoint string, config *connection.AuthConfig, awsRegio
 		if endpoint == "" {
 			client = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
 				o.UsePathStyle = true
-			})
+			}, withS3RequestHeader)
 		} else {
 			client = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
 				o.UsePathStyle = true
 				o.BaseEndpoint = aws.String(endpoint)
-			})
+			}, withS3RequestHeader)
+		}
+	case "withDefault":
+		awsCfg, err := s3config.LoadDefaultConfig(context.TODO(),
+			s3config.WithRegion(awsRegion),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the AWS configuration: %s", err)
+		}
+
+		if _, err := awsCfg.Credentials.Retrieve(context.TODO()); err != nil {
+			return nil, fmt.Errorf("no AWS credentials found by any provider "+
+				"(environment, shared config, container/IMDS, web identity): %w", err)
+		}
+
+		client = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+			o.UsePathStyle = true
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		}, withS3RequestHeader)
+	case "anonymous":
+		if config.GetProperties().ProbeBox == "" {
+			return nil, fmt.Errorf("ProbeBox must be set with anonymous credentials, which cannot list the buckets")
 		}
+
+		awsCfg, err := s3config.LoadDefaultConfig(context.TODO(),
+			s3config.WithCredentialsProvider(aws.AnonymousCredentials{}),
+			s3config.WithRegion(awsRegion),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the AWS configuration: %s", err)
+		}
+
+		client = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+			o.UsePathStyle = true
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		}, withS3RequestHeader)
 	default:
 		return nil, fmt.Errorf("invalid connection type for AWS S3: %s", config.GetConnectType())
 	}
@@ -86,16 +134,39 @@ func CreateS3Connection(endpoint string, config *connection.AuthConfig, awsRegio
 		return nil, fmt.Errorf("client is not initialized")
 	}
 
-	_, err := client.ListBuckets(context.TODO(), nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to AWS S3: %w", err)
+	conn, err = filestorage.NewS3Client(client, common.ConnectionProperties{
+		Label:                config.GetProperties().Label,
+		IsMainInstance:       config.GetProperties().IsMainInstance,
+		Role:                 config.GetProperties().Role,
+		SaveEncrypt:          config.GetProperties().SaveEncrypted,
+		SaveCompress:         config.GetProperties().SaveCompressed,
+		EncryptKey:           config.GetProperties().EncryptKey,
+		EncryptKeyBytes:      config.GetProperties().EncryptKeyBytes,
+		EncryptKeysByBox:     config.GetProperties().EncryptKeysByBox,
+		DecryptKeys:          config.GetProperties().DecryptKeys,
+		EncryptOnly:          config.GetProperties().EncryptOnly,
+		ProbeBox:             config.GetProperties().ProbeBox,
+		BoxAliases:           config.GetProperties().BoxAliases,
+		AutoCreateBox:        config.GetProperties().AutoCreateBox,
+		ReadOnly:             config.GetProperties().ReadOnly,
+		TransformRules:       config.GetProperties().TransformRules,
+		CompressionThreshold: config.GetProperties().CompressionThreshold})
+	if err != nil && config.GetConnectType() == "withDefault" && s3CredentialsRejected(err) {
+		return nil, fmt.Errorf("AWS credentials found by the default chain are invalid: %w", err)
 	}
 
-	conn, err := filestorage.NewS3Client(client, common.ConnectionProperties{
-		IsMainInstance: config.GetProperties().IsMainInstance,
-		SaveEncrypt:    config.GetProperties().SaveEncrypted,
-		SaveCompress:   config.GetProperties().SaveCompressed,
-		EncryptKey:     config.GetProperties().EncryptKey})
+	return conn, err
+}
 
-	return conn, nil
+// s3CredentialsRejected reports whether err is caused by unknown, mismatching or expired credentials.
+func s3CredentialsRejected(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "InvalidAccessKeyId", "SignatureDoesNotMatch", "InvalidToken", "ExpiredToken":
+		return true
+	}
+	return false
 }
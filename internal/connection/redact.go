@@ -0,0 +1,120 @@
+package connection
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// connectionStringSecrets lists the Azure connection string fields holding secrets.
+var connectionStringSecrets = []string{"AccountKey", "SharedAccessSignature"}
+
+// Mask hides a secret, keeping only its first and last 4 characters.
+// Secrets shorter than 12 characters are hidden entirely.
+func Mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) < 12 {
+		return "****"
+	}
+	return secret[:4] + "****" + secret[len(secret)-4:]
+}
+
+// String returns a description of the configuration in which secrets are masked.
+func (a *AuthConfig) String() string {
+	if a == nil {
+		return "AuthConfig(nil)"
+	}
+	return fmt.Sprintf("AuthConfig{connectType: %q, profile: %q, accessKey: %q, secretKey: %q, connectionString: %q, serviceURL: %q, sasToken: %q, label: %q, isMainInstance: %t, encryptKey: %q}",
+		a.connectType, a.profile, a.accessKey, Mask(a.secretKey), Mask(a.connectionString), a.serviceURL, Mask(a.sasToken),
+		a.connectionProperties.Label, a.connectionProperties.IsMainInstance, Mask(a.connectionProperties.EncryptKey))
+}
+
+// GoString makes %#v print the masked description instead of the raw fields.
+func (a *AuthConfig) GoString() string {
+	return a.String()
+}
+
+// RedactError returns err with every secret of the configuration, and the given
+// additional secrets, replaced by its masked form. The original error is still
+// reachable with errors.Unwrap, errors.Is and errors.As.
+func (a *AuthConfig) RedactError(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+
+	if a != nil {
+		secrets = append(secrets, a.secretKey, a.connectionString, a.sasToken, a.connectionProperties.EncryptKey)
+		for _, key := range a.connectionProperties.EncryptKeysByBox {
+			secrets = append(secrets, key)
+		}
+		secrets = append(secrets, a.connectionProperties.DecryptKeys...)
+		secrets = append(secrets, connectionStringValues(a.connectionString)...)
+		secrets = append(secrets, sasSignatures(a.sasToken)...)
+	}
+
+	// Replace longer secrets first, so that a secret contained in another one
+	// (e.g. the account key in the connection string) does not break the masking.
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+
+	msg := err.Error()
+	redacted := msg
+	for _, secret := range secrets {
+		if secret != "" {
+			redacted = strings.ReplaceAll(redacted, secret, Mask(secret))
+		}
+	}
+	if redacted == msg {
+		return err
+	}
+
+	return &redactedError{msg: redacted, err: err}
+}
+
+// connectionStringValues returns the values of the secret fields of an Azure connection string.
+func connectionStringValues(connectionString string) []string {
+	var values []string
+	for _, part := range strings.Split(connectionString, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		for _, field := range connectionStringSecrets {
+			if strings.EqualFold(key, field) {
+				values = append(values, value)
+			}
+		}
+		// The SAS parameters may be reordered in request URLs, so the signature is
+		// redacted on its own as well.
+		if strings.EqualFold(key, "SharedAccessSignature") {
+			values = append(values, sasSignatures(value)...)
+		}
+	}
+	return values
+}
+
+// sasSignatures returns the signature of a SAS token, both decoded and as found in URLs.
+func sasSignatures(sasToken string) []string {
+	query, err := url.ParseQuery(strings.TrimPrefix(sasToken, "?"))
+	if err != nil || query.Get("sig") == "" {
+		return nil
+	}
+	return []string{query.Get("sig"), url.QueryEscape(query.Get("sig"))}
+}
+
+// redactedError is an error whose message has been stripped of secrets.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
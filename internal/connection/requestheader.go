@@ -0,0 +1,26 @@
+package connection
+
+import "context"
+
+// requestHeaderKey is the context key of the header attached to the requests of the SDKs.
+type requestHeaderKey struct{}
+
+// requestHeader is a header carried by a context, see WithRequestHeader.
+type requestHeader struct {
+	name  string
+	value string
+}
+
+// WithRequestHeader returns a copy of ctx carrying a header, attached by the clients created by
+// the connections to the requests their SDK sends with ctx, e.g. a request ID to correlate the
+// access logs of the provider.
+func WithRequestHeader(ctx context.Context, name, value string) context.Context {
+	return context.WithValue(ctx, requestHeaderKey{}, requestHeader{name: name, value: value})
+}
+
+// RequestHeader returns the header carried by ctx, see WithRequestHeader. name is empty when ctx
+// carries none.
+func RequestHeader(ctx context.Context) (name, value string) {
+	h, _ := ctx.Value(requestHeaderKey{}).(requestHeader)
+	return h.name, h.value
+}
@@ -15,18 +15,21 @@ func NewClassicLB(group []ClientGroup) *classicLB {
 }
 
 func (c *classicLB) Apply(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, error) {
-	if len(c.group) == 0 {
-		return nil, fmt.Errorf("no clients available in the group")
+	obj, err := Execute(ctx, c, func(client Client) (io.ReadCloser, error) {
+		return client.GetObject(ctx, storeBox, fileName)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("all clients failed to get the object: %w", err)
 	}
 
+	return obj, nil
+}
+
+// Order returns the clients of all groups, in group order.
+func (c *classicLB) Order() []Client {
+	var clients []Client
 	for _, g := range c.group {
-		for _, client := range g.Clients {
-			obj, err := client.GetObject(ctx, storeBox, fileName)
-			if err == nil {
-				return obj, nil
-			}
-		}
+		clients = append(clients, g.Clients...)
 	}
-
-	return nil, fmt.Errorf("all clients failed to get the object")
+	return clients
 }
@@ -0,0 +1,38 @@
+package loadbalancing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoClients is returned when a balancer has no client to run an operation on.
+var ErrNoClients = errors.New("no clients available")
+
+// Execute runs op on the clients of lb, in the order chosen by the balancer, and returns
+// the result of the first client that succeeds. Failed clients are skipped and their
+// errors are joined in the returned error. Execution stops early if ctx is done.
+// With a balancer returned by WithRetry, the failures of a client are retried first.
+func Execute[T any](ctx context.Context, lb LoadBalancer, op func(Client) (T, error)) (T, error) {
+	var zero T
+
+	clients := lb.Order()
+	if len(clients) == 0 {
+		return zero, ErrNoClients
+	}
+
+	var errs []error
+	for i, client := range clients {
+		if err := ctx.Err(); err != nil {
+			return zero, err
+		}
+
+		result, err := run(ctx, lb, client, op)
+		if err == nil {
+			return result, nil
+		}
+		errs = append(errs, fmt.Errorf("client#%d: %w", i, err))
+	}
+
+	return zero, errors.Join(errs...)
+}
@@ -8,6 +8,7 @@ import (
 
 type Client interface {
 	GetObject(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, error)
+	ExistObject(ctx context.Context, storeBox string, fileName string) (bool, error)
 }
 
 type ClientGroup struct {
@@ -16,6 +17,9 @@ type ClientGroup struct {
 
 type LoadBalancer interface {
 	Apply(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, error)
+	// Order returns the clients in the order they must be tried by the next operation.
+	// Balancers with a rotation advance it on every call.
+	Order() []Client
 }
 
 type Strategy int
@@ -25,6 +29,18 @@ const (
 	ROUND_ROBIN
 )
 
+// String returns the name of the strategy.
+func (s Strategy) String() string {
+	switch s {
+	case CLASSIC:
+		return "CLASSIC"
+	case ROUND_ROBIN:
+		return "ROUND_ROBIN"
+	default:
+		return fmt.Sprintf("Strategy(%d)", int(s))
+	}
+}
+
 type Factory struct {
 }
 
@@ -0,0 +1,49 @@
+package loadbalancing
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Retrier runs an attempt of an operation on a client, retrying its failures as it sees fit,
+// and returns the last failure.
+type Retrier func(ctx context.Context, attempt func() error) error
+
+// WithRetry returns a balancer trying the clients of lb in the same order, retrying the failures
+// of each client with retry before falling back to the next one.
+func WithRetry(lb LoadBalancer, retry Retrier) LoadBalancer {
+	return &retryLB{LoadBalancer: lb, retry: retry}
+}
+
+type retryLB struct {
+	LoadBalancer
+	retry Retrier
+}
+
+func (r *retryLB) Apply(ctx context.Context, storeBox, fileName string) (io.ReadCloser, error) {
+	obj, err := Execute(ctx, r, func(client Client) (io.ReadCloser, error) {
+		return client.GetObject(ctx, storeBox, fileName)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("all clients failed to get the object: %w", err)
+	}
+
+	return obj, nil
+}
+
+// run runs op on client through the retrier of lb, if any.
+func run[T any](ctx context.Context, lb LoadBalancer, client Client, op func(Client) (T, error)) (T, error) {
+	r, ok := lb.(*retryLB)
+	if !ok {
+		return op(client)
+	}
+
+	var result T
+	err := r.retry(ctx, func() error {
+		var err error
+		result, err = op(client)
+		return err
+	})
+	return result, err
+}
@@ -18,50 +18,46 @@ func NewRoundRobinLB(group []ClientGroup) *roundRobinLB {
 }
 
 func (r *roundRobinLB) Apply(ctx context.Context, storeBox, fileName string) (io.ReadCloser, error) {
-	if len(r.group) == 0 {
-		return nil, fmt.Errorf("no client groups configured")
+	obj, err := Execute(ctx, r, func(client Client) (io.ReadCloser, error) {
+		return client.GetObject(ctx, storeBox, fileName)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("all clients failed to get the object: %w", err)
 	}
 
-	var primary []Client
-	var errs []error
+	return obj, nil
+}
 
-	if len(r.group[0].Clients) > 0 {
-		r.mu.Lock()
-		clientNum := len(r.group[0].Clients)
-		start := r.currentClient % clientNum
+// Order returns the clients of the first non-empty group rotated by one position per call,
+// followed by the clients of the other groups in classic order. Leading empty groups are
+// skipped, so that the rotation applies to the group serving the reads, e.g. to the main
+// clients when there are no replicas.
+func (r *roundRobinLB) Order() []Client {
+	first := 0
+	for first < len(r.group) && len(r.group[first].Clients) == 0 {
+		first++
+	}
+	if first == len(r.group) {
+		return nil
+	}
 
-		r.currentClient = (start + 1) % clientNum
+	rotated := r.group[first].Clients
+	clientNum := len(rotated)
 
-		primary = make([]Client, 0, clientNum)
-		for i := 0; i < clientNum; i++ {
-			idx := (start + i) % clientNum
-			primary = append(primary, r.group[0].Clients[idx])
-		}
-		r.mu.Unlock()
+	r.mu.Lock()
+	start := r.currentClient % clientNum
+	r.currentClient = (start + 1) % clientNum
+	r.mu.Unlock()
 
-		for _, client := range primary {
-			obj, err := client.GetObject(ctx, storeBox, fileName)
-			if err == nil {
-				return obj, nil
-			}
-			errs = append(errs, fmt.Errorf("group#0: %w", err))
-		}
+	clients := make([]Client, 0, clientNum)
+	for i := 0; i < clientNum; i++ {
+		clients = append(clients, rotated[(start+i)%clientNum])
 	}
 
 	// --- fallback: other groups in classic balancing
-	for gi, group := range r.group[1:] {
-		for _, client := range group.Clients {
-			obj, err := client.GetObject(ctx, storeBox, fileName)
-			if err == nil {
-				return obj, nil
-			}
-			errs = append(errs, fmt.Errorf("group#%d: %w", gi+1, err))
-		}
-	}
-
-	if len(errs) == 0 {
-		return nil, fmt.Errorf("no clients available")
+	for _, group := range r.group[first+1:] {
+		clients = append(clients, group.Clients...)
 	}
 
-	return nil, fmt.Errorf("all clients failed to get the object")
+	return clients
 }
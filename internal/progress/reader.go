@@ -0,0 +1,130 @@
+package progress
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Func receives the number of bytes transferred so far and the total size of the
+// payload, or -1 when the total is unknown.
+type Func func(transferred, total int64)
+
+// Options controls how often a Reader reports its progress.
+// Interval is the minimum time between two reports and Bytes the minimum number of
+// bytes between two reports; when both are zero every read is reported.
+// The final report, at end of stream, is always delivered.
+type Options struct {
+	Interval time.Duration
+	Bytes    int64
+}
+
+// Reader wraps an io.Reader counting the bytes read through it.
+type Reader struct {
+	inner    io.Reader
+	total    int64
+	report   Func
+	opts     Options
+	pos      int64
+	reported int64
+	lastTime time.Time
+	done     bool
+}
+
+// seekReader is a Reader whose inner reader is also an io.Seeker, so that
+// consumers checking for io.Seeker keep their optimized path.
+type seekReader struct {
+	*Reader
+}
+
+// NewReader wraps inner so that report is invoked while it is consumed.
+// The returned reader implements io.Seeker when inner does.
+func NewReader(inner io.Reader, total int64, report Func, opts Options) io.Reader {
+	r := &Reader{inner: inner, total: total, report: report, opts: opts, reported: -1}
+	if _, ok := inner.(io.Seeker); ok {
+		return seekReader{r}
+	}
+	return r
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.inner.Read(p)
+	r.pos += int64(n)
+
+	if err == io.EOF || (r.total >= 0 && r.pos >= r.total) {
+		r.finish()
+	} else if n > 0 {
+		r.maybeReport()
+	}
+
+	return n, err
+}
+
+func (r seekReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.inner.(io.Seeker).Seek(offset, whence)
+	if err == nil {
+		r.pos = pos
+	}
+	return pos, err
+}
+
+// maybeReport delivers a report if the configured thresholds are met.
+// Reports never go backwards, even if the reader is rewound.
+func (r *Reader) maybeReport() {
+	if r.pos <= r.reported {
+		return
+	}
+	if r.opts.Bytes > 0 && r.reported >= 0 && r.pos-r.reported < r.opts.Bytes {
+		return
+	}
+	now := time.Now()
+	if r.opts.Interval > 0 && !r.lastTime.IsZero() && now.Sub(r.lastTime) < r.opts.Interval {
+		return
+	}
+
+	r.reported = r.pos
+	r.lastTime = now
+	r.report(r.pos, r.total)
+}
+
+// finish delivers the final report once.
+func (r *Reader) finish() {
+	if r.done || r.pos <= r.reported {
+		return
+	}
+	r.done = true
+	r.reported = r.pos
+
+	total := r.total
+	if total < 0 {
+		total = r.pos
+	}
+	r.report(r.pos, total)
+}
+
+// Aggregator sums the progress of several concurrent transfers of the same payload.
+type Aggregator struct {
+	mu          sync.Mutex
+	transferred map[string]int64
+	total       int64
+	report      Func
+}
+
+// NewAggregator returns an Aggregator reporting the sum of all transfers against total.
+func NewAggregator(total int64, report Func) *Aggregator {
+	return &Aggregator{transferred: make(map[string]int64), total: total, report: report}
+}
+
+// Update records the progress of the transfer identified by id and reports the sum.
+func (a *Aggregator) Update(id string, transferred int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.transferred[id] = transferred
+
+	var sum int64
+	for _, n := range a.transferred {
+		sum += n
+	}
+	a.report(sum, a.total)
+}
@@ -0,0 +1,152 @@
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	diskEntrySuffix   = ".json"
+	diskCorruptSuffix = ".corrupt"
+	diskTempPattern   = ".tmp-*"
+)
+
+// ErrSetAside is wrapped by the failures to rename a corrupt record returned by DiskQueue.Pending.
+var ErrSetAside = errors.New("failed to set aside corrupt entry")
+
+// DiskQueue stores a JSON file per task in a directory, written durably before Add returns. The
+// directory must not be shared by processes running at the same time.
+type DiskQueue struct {
+	dir string
+}
+
+// NewDiskQueue creates a DiskQueue in dir, creating the directory if needed.
+func NewDiskQueue(dir string) (*DiskQueue, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("journal directory is empty")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	return &DiskQueue{dir: dir}, nil
+}
+
+// Add saves t in a new file, named after its creation time, setting its ID to the path.
+func (q *DiskQueue) Add(t *Task) error {
+	var suffix [4]byte
+	_, _ = rand.Read(suffix[:])
+	t.ID = filepath.Join(q.dir, strconv.FormatInt(t.Created.UnixNano(), 10)+"-"+hex.EncodeToString(suffix[:])+diskEntrySuffix)
+	return q.save(record(t, t.Targets))
+}
+
+// Update removes the file of t when no target is left, and saves it with the targets left
+// otherwise. A file already removed, e.g. by a recovery, is not written again.
+func (q *DiskQueue) Update(t *Task, left []string) error {
+	if len(left) == 0 {
+		if err := os.Remove(t.ID); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove journal entry: %w", err)
+		}
+		return nil
+	}
+	if _, err := os.Stat(t.ID); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return q.save(record(t, left))
+}
+
+// Pending reads the tasks of the directory, oldest first, removing the leftovers of the files
+// being written during a crash. The files that cannot be decoded are renamed with the ".corrupt"
+// suffix and reported with their error; a failure to rename one wraps ErrSetAside.
+func (q *DiskQueue) Pending() ([]*Task, []error, error) {
+	files, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read journal directory: %w", err)
+	}
+
+	var paths []string
+	for _, file := range files {
+		name := file.Name()
+		path := filepath.Join(q.dir, name)
+		switch {
+		case file.IsDir():
+		case strings.Contains(name, strings.TrimSuffix(diskTempPattern, "*")):
+			_ = os.Remove(path)
+		case strings.HasSuffix(name, diskEntrySuffix):
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var (
+		tasks   []*Task
+		corrupt []error
+	)
+	for _, path := range paths {
+		t, err := readTask(path)
+		if err == nil {
+			tasks = append(tasks, t)
+			continue
+		}
+		err = fmt.Errorf("corrupt entry %s: %w", path, err)
+		if renameErr := os.Rename(path, path+diskCorruptSuffix); renameErr != nil {
+			err = errors.Join(err, fmt.Errorf("%w %s: %w", ErrSetAside, path, renameErr))
+		}
+		corrupt = append(corrupt, err)
+	}
+	return tasks, corrupt, nil
+}
+
+// save writes t to a temporary file, then renames it over the file of t, so that the file is
+// never seen partially written.
+func (q *DiskQueue) save(t *Task) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(q.dir, filepath.Base(t.ID)+diskTempPattern)
+	if err != nil {
+		return fmt.Errorf("failed to write journal entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write journal entry: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write journal entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write journal entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), t.ID); err != nil {
+		return fmt.Errorf("failed to write journal entry: %w", err)
+	}
+	return nil
+}
+
+// readTask decodes the task saved in path.
+func readTask(path string) (*Task, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &Task{ID: path}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, err
+	}
+	if t.StoreBox == "" || t.Key == "" || t.Source == "" || len(t.Targets) == 0 {
+		return nil, errors.New("missing fields")
+	}
+	return t, nil
+}
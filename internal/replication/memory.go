@@ -0,0 +1,68 @@
+package replication
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// MemoryQueue keeps the tasks in memory, e.g. for the tests; its tasks are lost with the process.
+type MemoryQueue struct {
+	mu    sync.Mutex
+	next  int
+	tasks []*Task // Copies of the recorded tasks, oldest first
+}
+
+// NewMemoryQueue creates an empty MemoryQueue.
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{}
+}
+
+// Add records a copy of t.
+func (q *MemoryQueue) Add(t *Task) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.next++
+	t.ID = strconv.Itoa(q.next)
+	q.tasks = append(q.tasks, record(t, t.Targets))
+	return nil
+}
+
+// Update records the targets left of t, removing it when none is left.
+func (q *MemoryQueue) Update(t *Task, left []string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := slices.IndexFunc(q.tasks, func(r *Task) bool { return r.ID == t.ID })
+	switch {
+	case i < 0:
+	case len(left) == 0:
+		q.tasks = slices.Delete(q.tasks, i, i+1)
+	default:
+		q.tasks[i] = record(t, left)
+	}
+	return nil
+}
+
+// Pending returns copies of the recorded tasks, oldest first.
+func (q *MemoryQueue) Pending() ([]*Task, []error, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	tasks := make([]*Task, len(q.tasks))
+	for i, t := range q.tasks {
+		tasks[i] = record(t, t.Targets)
+	}
+	return tasks, nil, nil
+}
+
+// record returns the persisted fields of t, with the given targets.
+func record(t *Task, targets []string) *Task {
+	return &Task{
+		StoreBox: t.StoreBox,
+		Key:      t.Key,
+		Source:   t.Source,
+		Targets:  slices.Clone(targets),
+		Attempts: t.Attempts,
+		Created:  t.Created,
+		ID:       t.ID,
+	}
+}
@@ -0,0 +1,250 @@
+// Package replication runs the copies of objects to storages in background: the fan-out of the
+// ASYNC_REPLICATION writes, the replay of the pending ones after a restart and the copies of
+// SyncBox. The storages are identified by their label, so that the tasks can be persisted by a
+// Queue and replayed by another process.
+package replication
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tizianocitro/m2cs/internal/clock"
+)
+
+// Task is the copy of an object to a set of storages.
+type Task struct {
+	StoreBox string    `json:"box"`
+	Key      string    `json:"key"`
+	Source   string    `json:"source"`             // Label of the storage holding the object
+	Targets  []string  `json:"targets"`            // Labels of the storages left to write
+	Attempts int       `json:"attempts,omitempty"` // Writes attempted so far, over all the targets and the runs
+	Created  time.Time `json:"created"`
+
+	// ID identifies the task in its Queue, empty until it is recorded.
+	ID string `json:"-"`
+	// Write writes the object to Targets[i], e.g. from the bytes of the write being replicated;
+	// when nil, as for the tasks loaded from a Queue, Options.Copy copies it from Source.
+	Write func(ctx context.Context, i int) error `json:"-"`
+	// Written, if not nil, is called as soon as the write of Targets[i] has completed, retries
+	// included, with its result.
+	Written func(i int, err error) `json:"-"`
+	// Done, if not nil, is called once the task has run, with the result of the write of each
+	// target, in the order of Targets.
+	Done func(results []error) `json:"-"`
+
+	ctx      context.Context
+	enqueued time.Time
+}
+
+// Queue persists the tasks of an Engine until all their targets are written, so that they can be
+// replayed after a restart.
+type Queue interface {
+	// Add records a new task, setting its ID.
+	Add(t *Task) error
+	// Update records the targets left to write of a task that has run, removing the task when
+	// none is left. It must not modify t.
+	Update(t *Task, left []string) error
+	// Pending returns the tasks recorded, oldest first, and the failures to decode the records
+	// set aside.
+	Pending() ([]*Task, []error, error)
+}
+
+// Options configures an Engine.
+type Options struct {
+	Workers           int   // Tasks running at the same time, unbounded when 0
+	TargetConcurrency int   // Targets of a task written at the same time, all of them when 0
+	Queue             Queue // Persistence of the tasks, none when nil
+
+	// Copy writes Targets[i] of the tasks without Write, e.g. copying the object from Source.
+	Copy func(ctx context.Context, t *Task, i int) error
+	// Retry runs the write of a target, retrying its failures (default: a single attempt).
+	Retry func(ctx context.Context, write func() error) error
+	// Clock measures the age of the tasks (default: the system clock).
+	Clock clock.Clock
+}
+
+// Stats describes the tasks of an Engine, e.g. to be exported as metrics.
+type Stats struct {
+	Pending   int           // Tasks enqueued and not done yet
+	Running   int           // Tasks running, the other pending ones wait for a worker or for an earlier task of their key
+	OldestAge time.Duration // Age of the oldest pending task, 0 without any
+}
+
+// Engine runs tasks with a pool of workers. The tasks of the same store box and key run one at
+// a time, in the order they were enqueued, so that an older write never overwrites a newer one.
+type Engine struct {
+	opts Options
+
+	mu      sync.Mutex
+	keys    map[string][]*Task // Pending tasks by key, the first one running or ready
+	ready   []*Task            // Tasks waiting for a worker
+	running int
+	pending int
+	idle    chan struct{} // Closed when no task is pending
+}
+
+// New creates an Engine.
+func New(opts Options) *Engine {
+	idle := make(chan struct{})
+	close(idle)
+	return &Engine{opts: opts, keys: make(map[string][]*Task), idle: idle}
+}
+
+// Enqueue schedules t to run with ctx, recording it in the queue first unless it has an ID, i.e.
+// it was loaded from the queue. A task the queue fails to record still runs, and the failure is
+// returned.
+func (e *Engine) Enqueue(ctx context.Context, t *Task) error {
+	var err error
+	if e.opts.Queue != nil && t.ID == "" {
+		err = e.opts.Queue.Add(t)
+	}
+
+	t.ctx = ctx
+	t.enqueued = clock.Or(e.opts.Clock).Now()
+	key := taskKey(t)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pending == 0 {
+		e.idle = make(chan struct{})
+	}
+	e.pending++
+	e.keys[key] = append(e.keys[key], t)
+	if len(e.keys[key]) == 1 {
+		e.ready = append(e.ready, t)
+		e.dispatch()
+	}
+	return err
+}
+
+// Drain waits for the pending tasks to be done, or for ctx to be done.
+func (e *Engine) Drain(ctx context.Context) error {
+	e.mu.Lock()
+	idle := e.idle
+	e.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the current statistics of the engine.
+func (e *Engine) Stats() Stats {
+	now := clock.Or(e.opts.Clock).Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stats := Stats{Pending: e.pending, Running: e.running}
+	for _, tasks := range e.keys {
+		for _, t := range tasks {
+			stats.OldestAge = max(stats.OldestAge, now.Sub(t.enqueued))
+		}
+	}
+	return stats
+}
+
+// dispatch starts the ready tasks the workers allow. It must be called with mu held.
+func (e *Engine) dispatch() {
+	for len(e.ready) > 0 && (e.opts.Workers <= 0 || e.running < e.opts.Workers) {
+		t := e.ready[0]
+		e.ready = e.ready[1:]
+		e.running++
+		go e.run(t)
+	}
+}
+
+// run runs t, then schedules the next task of its key.
+func (e *Engine) run(t *Task) {
+	results := e.write(t)
+
+	var left []string
+	for i, err := range results {
+		if err != nil {
+			left = append(left, t.Targets[i])
+		}
+	}
+	if e.opts.Queue != nil && t.ID != "" {
+		if err := e.opts.Queue.Update(t, left); err != nil {
+			log.Printf("[replication] %s/%s: %v", t.StoreBox, t.Key, err)
+		}
+	}
+
+	// the next task of the key is ready, and starts once a worker is free
+	key := taskKey(t)
+	e.mu.Lock()
+	if next := e.keys[key][1:]; len(next) > 0 {
+		e.keys[key] = next
+		e.ready = append(e.ready, next[0])
+	} else {
+		delete(e.keys, key)
+	}
+	e.dispatch()
+	e.mu.Unlock()
+
+	if t.Done != nil {
+		t.Done(results)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.running--
+	e.pending--
+	if e.pending == 0 {
+		close(e.idle)
+	}
+	e.dispatch()
+}
+
+// write writes the targets of t, returning the result of each one.
+func (e *Engine) write(t *Task) []error {
+	results := make([]error, len(t.Targets))
+	limit := e.opts.TargetConcurrency
+	if limit <= 0 || limit > len(t.Targets) {
+		limit = len(t.Targets)
+	}
+	sem := make(chan struct{}, limit)
+
+	var (
+		attempts atomic.Int64
+		wg       sync.WaitGroup
+	)
+	for i := range t.Targets {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			write := func() error {
+				attempts.Add(1)
+				if t.Write != nil {
+					return t.Write(t.ctx, i)
+				}
+				return e.opts.Copy(t.ctx, t, i)
+			}
+			if e.opts.Retry != nil {
+				results[i] = e.opts.Retry(t.ctx, write)
+			} else {
+				results[i] = write()
+			}
+			if t.Written != nil {
+				t.Written(i, results[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	t.Attempts += int(attempts.Load())
+	return results
+}
+
+// taskKey identifies the object of a task, whose tasks run one at a time.
+func taskKey(t *Task) string {
+	return t.StoreBox + "/" + t.Key
+}
@@ -12,10 +12,85 @@ type CacheOptions struct {
 	TTL                time.Duration      // Time-to-live for cache entries (default: 10 * time.Minute)
 	MaxItems           int                // Maximum number of items in the cache (default: 5)
 	ValidationStrategy ValidationStrategy // Strategy for validating cached items (default: No Validation)
+
+	MaxItemSizeMB    int64 // Items larger than this are not cached (default: MaxSizeMB)
+	AdmissionMinHits int   // Items are cached from their AdmissionMinHits-th read within the window (default: 1, i.e. on the first read)
+	AdmissionWindow  int   // Number of reads after which the read counts are halved (default: 10 * MaxItems)
+
+	StaleWhileRevalidate time.Duration // Expired items are served for this long while refreshed in background (default: 0, disabled)
+	MaxStale             time.Duration // Expired items are served for this long while their refresh fails (default: StaleWhileRevalidate)
+
+	CompressEntries  bool  // Gzip-compress the items of at least CompressMinBytes, at the fastest level (default: false)
+	CompressMinBytes int64 // Smallest item compressed with CompressEntries (default: 64 KiB)
+	CopyOnRead       bool  // Hand out copies of the items, whose BytesReadCloser.Bytes callers may modify (default: false, shared)
+
+	SnapshotPath         string        // File the cache is restored from by ConfigureCache, when it exists (default: none)
+	SnapshotOnClose      bool          // Save the cache to SnapshotPath on Close (default: false)
+	SnapshotMaxItemBytes int64         // Items larger than this are not saved (default: no limit)
+	SnapshotMaxAge       time.Duration // Items older than this are neither saved nor restored (default: no limit)
+
+	// Backend caches the objects in place of the in-memory cache, e.g. in Redis, so that several clients
+	// share them and the invalidations of one are seen by the others (default: none, in-memory cache).
+	// The items expire after TTL; the size, admission, compression, stale and snapshot options, as well
+	// as the validation strategy, CacheEntries and CacheContains, apply to the in-memory cache only.
+	Backend CacheBackend
+
+	// ExistenceTTL is how long ExistsObject answers from the result of the last check, put or removal of an
+	// object, without calling the storages (default: 10 seconds). The existence is kept in memory, with a Backend as well.
+	ExistenceTTL time.Duration
+
+	// OnValidation is called with the stats of every run of the validation routine, e.g. to export them as
+	// metrics or log them, from the goroutine of the routine, which it must not block (default: none). The
+	// counters cumulated over the runs are returned by FileClient.CacheStats.
+	OnValidation func(stats CacheValidationStats)
+
+	// Rules override the options above for the store boxes they match, see CacheRule. The first matching
+	// rule applies; the boxes matched by none use the options above (default: none).
+	Rules []CacheRule
+}
+
+// CacheRule overrides the cache options for the store boxes matching BoxPattern, e.g. to cache the
+// immutable assets for long and never cache the data changing often. The pattern is matched against
+// the store box as given by the caller, with the syntax of path.Match; "*" matches every box, so that
+// a last rule with it replaces the defaults, and no rule can follow it.
+type CacheRule struct {
+	BoxPattern    string        // Glob pattern of the store boxes, e.g. "assets-*"
+	Enabled       bool          // Cache the objects of the matching boxes; when false, they bypass the cache entirely
+	TTL           time.Duration // Time-to-live of the cached objects (default: the cache TTL)
+	MaxItemSizeMB int64         // Objects larger than this are not cached, up to the cache MaxItemSizeMB (default: the cache MaxItemSizeMB)
 }
 
 type ValidationStrategy *caching.ValidationOptions
 
+// ValidationRunner validates the items of a cache, e.g. invalidating those whose content is stale,
+// see CustomValidationStrategy.
+type ValidationRunner = caching.ValidationRunner
+
+// FileCache is the cache of a FileClient, as handed to a ValidationRunner.
+type FileCache = caching.FileCache
+
+// CacheValidationStats describes a run of the validation routine of the cache, see
+// CacheOptions.OnValidation. The runners of CustomValidationStrategy report the entries they
+// scanned and expired by implementing CacheStatsRunner.
+type CacheValidationStats = caching.ValidationStats
+
+// CacheStatsRunner is implemented by the ValidationRunner values reporting what they validated.
+type CacheStatsRunner = caching.StatsRunner
+
+// CacheStats holds the counters of the cache, cumulated since it was configured, see CacheStats.
+type CacheStats = caching.Stats
+
+// CacheEntryInfo describes a cached object, without its content, see CacheEntries.
+type CacheEntryInfo = caching.EntryInfo
+
+// BytesReadCloser is the reader of the objects served from memory by GetObject, from the cache or
+// read from the storages, and by GetObjectWithInfo. Len reports the bytes not yet read, the whole
+// object before the first read, and Size the whole object, e.g. to set Content-Length without
+// buffering the object again. Bytes returns the whole object: the slice must not be modified, since
+// it may be the one held by the cache unless CacheOptions.CopyOnRead is set, and it is valid until
+// Close.
+type BytesReadCloser = caching.ReadCloser
+
 // NoValidationStrategy returns a strategy that performs no validation on cache entries.
 // Validation is only performed when an item is retrieved from the cache; at read time
 // the item's validity is checked.
@@ -46,3 +121,17 @@ func SamplingValidationStrategy(samplingPercent uint8, validationInterval time.D
 		ValidationInterval: validationInterval,
 	}
 }
+
+// CustomValidationStrategy creates a strategy that, at regular intervals, applies runner
+// to the cache, e.g. to invalidate the items whose hash differs from the one recorded in a
+// database of authoritative hashes, see FileCache.Entries and FileCache.Invalidate.
+func CustomValidationStrategy(runner ValidationRunner, validationInterval time.Duration) ValidationStrategy {
+	if validationInterval <= 0 {
+		validationInterval = 30 * time.Minute
+	}
+	return &caching.ValidationOptions{
+		Strategy:           caching.CUSTOM_VALIDATION,
+		ValidationInterval: validationInterval,
+		Runner:             runner,
+	}
+}
@@ -1,107 +1,409 @@
 package m2cs
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"maps"
+	"math"
+	"slices"
+	"unicode/utf8"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/tizianocitro/m2cs/internal/connection"
 	connfilestorage "github.com/tizianocitro/m2cs/internal/connection/filestorage"
 	common "github.com/tizianocitro/m2cs/pkg"
 	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/transform/compression"
 )
 
 // ConnectionOptions holds the options for creating a connection.
 // parameters:
 // - ConnectionMethod: The method used to establish the connection.
 // - IsMainInstance:Indicates if this is the main instance.
+// - Role: Optional role in the writes, implying IsMainInstance for PRIMARY and SECONDARY_MAIN.
 // - SaveEncrypt: Indicates if the data should be saved with encryption.
 // - SaveCompress: Indicates if the data should be saved with compression.
 // - CompressKey: Optional key for encrypt , if needed.
+// - Label: Optional name identifying the connection in reports and logs.
+// - ProbeBox: Optional store box checked when connecting, instead of listing all the store boxes.
+// - Region: Optional region, used by MinIO and AWS S3 when none is passed to their constructors.
+// - BoxAliases: Optional physical names of the store boxes of the connection, by logical name.
+// - OnWarning: Optional hook of the likely misconfigurations, see ErrUnusedEncryptKey.
+// - EncryptKeyBytes: Optional raw 32-byte key, instead of the passphrase EncryptKey.
+// - EncryptKeysByBox: Optional passphrases of the store boxes encrypted with their own key.
+// - DecryptKeys: Optional passphrases decrypting the objects, e.g. of a client only reading them.
+// - EncryptOnly: Keeps EncryptKey from decrypting, e.g. for a client only writing the objects.
+// - MinEncryptKeyLength: Optional minimum length of EncryptKey, 16 by default.
+// - AllowWeakKeys: Accepts any EncryptKey, e.g. in tests.
+// - AutoCreateBox: Creates the missing store boxes on the first write, see ErrBoxCreationFailed.
+// - ReadOnly: Rejects the writes and removals of the connection with ErrReadOnlyStorage.
+// - TransformRules: Optional compression and encryption of the objects by key or content type.
+// - CompressionThreshold: Optional maximum compression ratio of the objects stored compressed.
 type ConnectionOptions struct {
 	ConnectionMethod connectionFunc
 	IsMainInstance   bool
+	Role             StorageRole // Optional role in the writes, see StorageRole
 	SaveEncrypt      EncryptionAlgorithm
 	SaveCompress     CompressionAlgorithm
-	EncryptKey       string // Optional key for encrypt , if needed
+	EncryptKey       string            // Optional key for encrypt , if needed
+	Label            string            // Optional name identifying the connection
+	ProbeBox         string            // Optional store box checked instead of listing the store boxes
+	Region           string            // Optional region, for MinIO and AWS S3
+	BoxAliases       map[string]string // Optional logical to physical store box names
+
+	// OnWarning is called with the likely misconfigurations of the connection, such as
+	// ErrUnusedEncryptKey: when it returns an error, the connection fails with it. By default
+	// the warnings are logged.
+	OnWarning func(warning error) error
+
+	// EncryptKeyBytes is the AES-256 key used as is, instead of the one derived from the
+	// passphrase EncryptKey with SHA-256. It must be 32 bytes long, e.g. from crypto/rand.
+	EncryptKeyBytes []byte
+	// EncryptKeysByBox holds the passphrases of the store boxes encrypted with their own key, by
+	// logical name, e.g. one per tenant; the other store boxes are encrypted with EncryptKey, or
+	// EncryptKeyBytes. The passphrases are checked like EncryptKey.
+	EncryptKeysByBox map[string]string
+	// DecryptKeys holds the passphrases decrypting the objects, tried in turn after EncryptKey,
+	// e.g. the previous keys of a rotation. A client with DecryptKeys but without EncryptKey and
+	// EncryptKeyBytes only reads the objects saved encrypted: its encrypted writes fail with
+	// ErrKeyUnavailable. The passphrases are checked like EncryptKey.
+	DecryptKeys []string
+	// EncryptOnly keeps EncryptKey, or EncryptKeyBytes, and the passphrases of EncryptKeysByBox
+	// from decrypting, e.g. for a client only writing the objects: its reads of the objects saved
+	// encrypted fail with ErrKeyUnavailable, unless DecryptKeys are set, and FileClients read from
+	// its storage last. AES-256-GCM being symmetric, the split of the keys is enforced by the
+	// clients only: whoever holds EncryptKey can still decrypt the objects.
+	EncryptOnly bool
+	// MinEncryptKeyLength is the minimum number of characters of EncryptKey, see ErrWeakEncryptKey.
+	// By default it is DefaultMinEncryptKeyLength.
+	MinEncryptKeyLength int
+	// AllowWeakKeys accepts the passphrases rejected with ErrWeakEncryptKey, e.g. in tests.
+	AllowWeakKeys bool
+	// AutoCreateBox creates the store box of a PutObject failing because it does not exist, and
+	// retries the put once, e.g. in ephemeral environments. AWS S3 buckets are created in the
+	// region of the connection.
+	AutoCreateBox bool
+	// ReadOnly rejects the writes, the removals and the changes of the store boxes of the
+	// connection with ErrReadOnlyStorage, without sending them, e.g. for the replicas, which must
+	// only be written by the replication of the provider; reads keep working. See
+	// WithEnforceReplicaReadOnly to make every replica of a FileClient read-only.
+	ReadOnly bool
+	// TransformRules selects the compression and the encryption of the objects whose key or
+	// content type match a rule, the first one matching, instead of SaveCompress and SaveEncrypt,
+	// e.g. to skip the compression of media compressed already. The choice is recorded in the
+	// metadata of the objects, which are read with the pipeline they were written with.
+	TransformRules []TransformRule
+	// CompressionThreshold is the maximum ratio of the compressed to the original size of the
+	// objects stored compressed, estimated on their first 64 KiB: the others, such as random or
+	// already compressed data, are stored uncompressed, which is recorded in their metadata. It is
+	// DefaultCompressionThreshold by default, and a negative one compresses everything.
+	CompressionThreshold float64
+}
+
+// DefaultCompressionThreshold is the default of ConnectionOptions.CompressionThreshold: the
+// objects are stored compressed only when compressed to at most 97% of their size.
+const DefaultCompressionThreshold = compression.DefaultMaxRatio
+
+// DefaultMinEncryptKeyLength is the default of ConnectionOptions.MinEncryptKeyLength.
+const DefaultMinEncryptKeyLength = 16
+
+// ErrBoxCreationFailed is returned by the writes of the connections with
+// ConnectionOptions.AutoCreateBox whose store box is missing and could not be created.
+var ErrBoxCreationFailed = filestorage.ErrBoxCreationFailed
+
+// ErrReadOnlyStorage is returned by the writes, the removals and the changes of the store boxes
+// of the connections with ConnectionOptions.ReadOnly, and of the replicas of the FileClients with
+// WithEnforceReplicaReadOnly.
+var ErrReadOnlyStorage = filestorage.ErrReadOnlyStorage
+
+// ErrKeyUnavailable is returned by the writes of objects saved encrypted on the connections with
+// only ConnectionOptions.DecryptKeys, and by their reads on the connections with EncryptOnly and
+// no DecryptKeys.
+var ErrKeyUnavailable = filestorage.ErrKeyUnavailable
+
+type connectionFunc = *connection.AuthConfig
+
+// NewCredentialProfile returns a copy of method named name, e.g. to connect a MinIO main instance
+// and a MinIO replica on different endpoints with the same credentials. The New*Connection
+// functions never modify the connectionFunc of their ConnectionOptions, so a profile can be passed
+// to any number of them, concurrently too; each connection holds its own properties. The errors
+// of the connections using the profile are prefixed with its name. It returns nil when method is nil.
+func NewCredentialProfile(name string, method connectionFunc) connectionFunc {
+	if method == nil {
+		return nil
+	}
+	profile := method.WithProperties(common.Properties{})
+	profile.SetProfile(name)
+	return profile
 }
 
-type connectionFunc *connection.AuthConfig
+// profileError prefixes err with the name of the credential profile of authConfig, if any.
+func profileError(authConfig *connection.AuthConfig, err error) error {
+	if authConfig == nil || authConfig.GetProfile() == "" {
+		return err
+	}
+	return fmt.Errorf("credential profile %s: %w", authConfig.GetProfile(), err)
+}
+
+// isMain reports whether the connection is a main instance, as set by its role when any.
+func (o ConnectionOptions) isMain() bool {
+	switch o.Role {
+	case PRIMARY, SECONDARY_MAIN:
+		return true
+	case REPLICA:
+		return false
+	default:
+		return o.IsMainInstance
+	}
+}
+
+// Validate checks the options of a connection to backend, returning every problem found, joined
+// with errors.Join, or nil: an unsupported ConnectionMethod, unknown SaveCompress, SaveEncrypt and
+// Role values, a missing or weak encryption key and invalid BoxAliases. The New*Connection
+// functions call it before connecting.
+func (o ConnectionOptions) Validate(backend BackendType) error {
+	name, ok := backendNames[backend]
+	if !ok {
+		return fmt.Errorf("unknown backend %v", backend)
+	}
+
+	var errs []error
+	if err := o.validateMethod(backend, name); err != nil {
+		errs = append(errs, err)
+	}
+
+	invalid := func(err error) {
+		errs = append(errs, fmt.Errorf("invalid %s connection: %w", name, err))
+	}
+	switch o.SaveCompress {
+	case NO_COMPRESSION, GZIP_COMPRESSION:
+	case GZIP_CONTENT_ENCODING:
+		if o.SaveEncrypt != NO_ENCRYPTION {
+			invalid(fmt.Errorf("GZIP_CONTENT_ENCODING cannot be combined with encryption"))
+		}
+	default:
+		invalid(fmt.Errorf("unsupported compression algorithm: %v", o.SaveCompress))
+	}
+	switch o.SaveEncrypt {
+	case NO_ENCRYPTION, AES256_ENCRYPTION:
+		keys := common.Properties{SaveEncrypted: o.SaveEncrypt, EncryptKey: o.EncryptKey,
+			EncryptKeyBytes: o.EncryptKeyBytes, EncryptKeysByBox: o.EncryptKeysByBox, DecryptKeys: o.DecryptKeys,
+			EncryptOnly: o.EncryptOnly, TransformRules: o.TransformRules}
+		if err := keys.ValidateEncryption(); err != nil {
+			invalid(err)
+		} else if o.encrypts() {
+			if err := o.checkPassphrase("EncryptKey", o.EncryptKey); err != nil {
+				invalid(err)
+			}
+			for _, box := range slices.Sorted(maps.Keys(o.EncryptKeysByBox)) {
+				if err := o.checkPassphrase(fmt.Sprintf("EncryptKeysByBox[%q]", box), o.EncryptKeysByBox[box]); err != nil {
+					invalid(err)
+				}
+			}
+			for i, key := range o.DecryptKeys {
+				if err := o.checkPassphrase(fmt.Sprintf("DecryptKeys[%d]", i), key); err != nil {
+					invalid(err)
+				}
+			}
+		}
+	default:
+		invalid(fmt.Errorf("unsupported encryption algorithm: %v", o.SaveEncrypt))
+	}
+	if math.IsNaN(o.CompressionThreshold) || math.IsInf(o.CompressionThreshold, 0) {
+		invalid(fmt.Errorf("CompressionThreshold must be a finite number, got %v", o.CompressionThreshold))
+	}
+	if o.MinEncryptKeyLength < 0 {
+		invalid(fmt.Errorf("MinEncryptKeyLength cannot be negative, got %d", o.MinEncryptKeyLength))
+	}
+	switch o.Role {
+	case NO_ROLE, PRIMARY, SECONDARY_MAIN, REPLICA:
+	default:
+		invalid(fmt.Errorf("unknown storage role: %v", o.Role))
+	}
+	if err := (common.ConnectionProperties{BoxAliases: o.BoxAliases}).ValidateBoxAliases(); err != nil {
+		invalid(err)
+	}
+	return errors.Join(errs...)
+}
+
+// backendNames are the names of the backends in the errors of the connections.
+var backendNames = map[BackendType]string{
+	MINIO_BACKEND:  "MinIO",
+	S3_BACKEND:     "AWS S3",
+	AZBLOB_BACKEND: "Azure Blob",
+}
+
+// validateMethod fails when ConnectionMethod is not supported by backend, or requires a ProbeBox.
+func (o ConnectionOptions) validateMethod(backend BackendType, name string) error {
+	if o.ConnectionMethod == nil {
+		return fmt.Errorf("connectionMethod cannot be nil")
+	}
+
+	connectType := o.ConnectionMethod.GetConnectType()
+	if backend == AZBLOB_BACKEND {
+		switch connectType {
+		case "withCredential", "withEnv", "withConnectionString":
+			return nil
+		case "withSASToken":
+			if o.ProbeBox == "" {
+				return fmt.Errorf("ProbeBox must be set with a SAS token, which may not allow listing the containers")
+			}
+			return nil
+		case "anonymous":
+			return fmt.Errorf("anonymous credentials are not supported for Azure Blob; " +
+				"use ConnectWithSASToken instead")
+		}
+		return fmt.Errorf("invalid connection method for Azure Blob; " +
+			"use: ConnectWithCredentials, ConnectWithEnvCredentials, ConnectWithConnectionString or ConnectWithSASToken")
+	}
+
+	switch connectType {
+	case "withCredential", "withEnv", "withDefault":
+		return nil
+	case "anonymous":
+		if o.ProbeBox == "" {
+			return fmt.Errorf("ProbeBox must be set with anonymous credentials, which cannot list the buckets")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid connection method for %s; "+
+		"use: ConnectWithCredentials, ConnectWithEnvCredentials, ConnectWithDefaultCredentials or ConnectWithAnonymousCredentials", name)
+}
+
+// warnUnusedKey reports an encryption key set without encryption to OnWarning.
+func (o ConnectionOptions) warnUnusedKey(name string) error {
+	if o.encrypts() || (o.EncryptKey == "" && len(o.EncryptKeyBytes) == 0 && len(o.EncryptKeysByBox) == 0 && len(o.DecryptKeys) == 0) {
+		return nil
+	}
+
+	warning := fmt.Errorf("%s connection: %w", name, ErrUnusedEncryptKey)
+	if o.OnWarning != nil {
+		return o.OnWarning(warning)
+	}
+	log.Printf("warning: %v", warning)
+	return nil
+}
+
+// encrypts reports whether objects may be saved encrypted, by SaveEncrypt or by a TransformRule.
+func (o ConnectionOptions) encrypts() bool {
+	return o.SaveEncrypt != NO_ENCRYPTION ||
+		slices.ContainsFunc(o.TransformRules, func(r TransformRule) bool { return r.Encrypt != NO_ENCRYPTION })
+}
+
+// authConfig validates the options of a connection to backend, see Validate, and returns a
+// copy of ConnectionMethod holding the properties of the connection.
+func (o ConnectionOptions) authConfig(backend BackendType) (*connection.AuthConfig, error) {
+	if err := o.Validate(backend); err != nil {
+		return nil, profileError(o.ConnectionMethod, err)
+	}
+	if err := o.warnUnusedKey(backendNames[backend]); err != nil {
+		return nil, profileError(o.ConnectionMethod, err)
+	}
+
+	return o.ConnectionMethod.WithProperties(common.Properties{
+		Label:                o.Label,
+		IsMainInstance:       o.isMain(),
+		Role:                 o.Role,
+		SaveEncrypted:        o.SaveEncrypt,
+		SaveCompressed:       o.SaveCompress,
+		EncryptKey:           o.EncryptKey,
+		EncryptKeyBytes:      o.EncryptKeyBytes,
+		EncryptKeysByBox:     o.EncryptKeysByBox,
+		DecryptKeys:          o.DecryptKeys,
+		EncryptOnly:          o.EncryptOnly,
+		ProbeBox:             o.ProbeBox,
+		BoxAliases:           o.BoxAliases,
+		AutoCreateBox:        o.AutoCreateBox,
+		ReadOnly:             o.ReadOnly,
+		TransformRules:       o.TransformRules,
+		CompressionThreshold: o.CompressionThreshold}), nil
+}
+
+// checkPassphrase fails with ErrWeakEncryptKey when key, the passphrase named name, is shorter
+// than the minimum length, or when its estimated entropy, its length times the log2 of the number
+// of its distinct characters, is below half the one of a passphrase of the minimum length without
+// repetitions.
+func (o ConnectionOptions) checkPassphrase(name, key string) error {
+	if key == "" || o.AllowWeakKeys {
+		return nil
+	}
+	minLength := o.MinEncryptKeyLength
+	if minLength <= 0 {
+		minLength = DefaultMinEncryptKeyLength
+	}
+
+	length := utf8.RuneCountInString(key)
+	if length < minLength {
+		return fmt.Errorf("%w: %s has %d characters, at least %d are required; "+
+			"use a longer random passphrase, or a 32-byte EncryptKeyBytes", ErrWeakEncryptKey, name, length, minLength)
+	}
+
+	distinct := make(map[rune]struct{})
+	for _, r := range key {
+		distinct[r] = struct{}{}
+	}
+	bits := float64(length) * math.Log2(float64(len(distinct)))
+	if required := float64(minLength) * math.Log2(float64(minLength)) / 2; bits < required {
+		return fmt.Errorf("%w: %s has too few distinct characters (about %d bits of entropy, at least %d are required); "+
+			"use a random passphrase, e.g. 32 random bytes encoded in base64, or a 32-byte EncryptKeyBytes", ErrWeakEncryptKey, name, int(bits), int(math.Ceil(required)))
+	}
+	return nil
+}
 
 // NewMinIOConnection creates a new MinIO connection.
 // It takes an endpoint, connection options, and optional MinIO options.
 // It returns a MinioConnection or an error if the connection could not be established.
 func NewMinIOConnection(endpoint string, connectionOptions ConnectionOptions, minioOptions *minio.Options) (*filestorage.MinioClient, error) {
-	var authConfing *connection.AuthConfig = connectionOptions.ConnectionMethod
-	if authConfing == nil {
-		return nil, fmt.Errorf("connectionMethod cannot be nil")
+	authConfing, err := connectionOptions.authConfig(MINIO_BACKEND)
+	if err != nil {
+		return nil, err
 	}
 
-	if authConfing.GetConnectType() != "withCredential" && authConfing.GetConnectType() != "withEnv" {
-		return nil, fmt.Errorf("invalid connection method for MinIO; use: ConnectWithCredentials or ConnectWithEnvCredentials")
+	if connectionOptions.Region != "" && (minioOptions == nil || minioOptions.Region == "") {
+		withRegion := minio.Options{TrailingHeaders: true}
+		if minioOptions != nil {
+			withRegion = *minioOptions
+		}
+		withRegion.Region = connectionOptions.Region
+		minioOptions = &withRegion
 	}
 
-	authConfing.SetProperties(common.Properties{
-		IsMainInstance: connectionOptions.IsMainInstance,
-		SaveEncrypted:  connectionOptions.SaveEncrypt,
-		SaveCompressed: connectionOptions.SaveCompress,
-		EncryptKey:     connectionOptions.EncryptKey})
-
 	minioConn, err := connfilestorage.CreateMinioConnection(endpoint, authConfing, minioOptions)
 	if err != nil {
-		return nil, err
+		return nil, profileError(authConfing, err)
 	}
 
 	return minioConn, nil
 }
 
 func NewAzBlobConnection(endpoint string, connectionOptions ConnectionOptions) (*filestorage.AzBlobClient, error) {
-	var authConfing *connection.AuthConfig = connectionOptions.ConnectionMethod
-	if authConfing == nil {
-		return nil, fmt.Errorf("connectionMethod cannot be nil")
-	}
-
-	if authConfing.GetConnectType() != "withCredential" &&
-		authConfing.GetConnectType() != "withEnv" &&
-		authConfing.GetConnectType() != "withConnectionString" {
-		return nil, fmt.Errorf("invalid connection method for Azure Blob; " +
-			"use: ConnectWithCredentials, ConnectWithEnvCredentials or ConnectWithConnectionString")
+	authConfing, err := connectionOptions.authConfig(AZBLOB_BACKEND)
+	if err != nil {
+		return nil, err
 	}
 
-	authConfing.SetProperties(common.Properties{
-		IsMainInstance: connectionOptions.IsMainInstance,
-		SaveEncrypted:  connectionOptions.SaveEncrypt,
-		SaveCompressed: connectionOptions.SaveCompress,
-		EncryptKey:     connectionOptions.EncryptKey})
-
 	azBlobConn, err := connfilestorage.CreateAzBlobConnection(endpoint, authConfing)
 	if err != nil {
-		return nil, err
+		return nil, profileError(authConfing, err)
 	}
 
 	return azBlobConn, nil
 }
 
 func NewS3Connection(endpoint string, connectionOptions ConnectionOptions, awsRegion string) (*filestorage.S3Client, error) {
-	var authConfing *connection.AuthConfig = connectionOptions.ConnectionMethod
-	if authConfing == nil {
-		return nil, fmt.Errorf("connectionMethod cannot be nil")
+	authConfing, err := connectionOptions.authConfig(S3_BACKEND)
+	if err != nil {
+		return nil, err
 	}
 
-	if authConfing.GetConnectType() != "withCredential" &&
-		authConfing.GetConnectType() != "withEnv" {
-		return nil, fmt.Errorf("invalid connection method for AWS S3; " +
-			"use: ConnectWithCredentials or ConnectWithEnvCredentials")
+	if awsRegion == "" {
+		awsRegion = connectionOptions.Region
 	}
 
-	authConfing.SetProperties(common.Properties{
-		IsMainInstance: connectionOptions.IsMainInstance,
-		SaveEncrypted:  connectionOptions.SaveEncrypt,
-		SaveCompressed: connectionOptions.SaveCompress,
-		EncryptKey:     connectionOptions.EncryptKey})
-
 	s3Conn, err := connfilestorage.CreateS3Connection(endpoint, authConfing, awsRegion)
 	if err != nil {
-		return nil, err
+		return nil, profileError(authConfing, err)
 	}
 
 	return s3Conn, nil
@@ -123,6 +425,16 @@ func ConnectWithEnvCredentials() connectionFunc {
 	return authConfig
 }
 
+// ConnectWithDefaultCredentials returns a connectionFunc resolving the credentials through the
+// provider default chain, as on EC2 or EKS where no environment variables are set.
+// For AWS S3 it is the SDK chain (environment, shared config, container/IMDS, web identity); for
+// MinIO it tries the MinIO and AWS environment variables, the mc and AWS credential files and IAM.
+func ConnectWithDefaultCredentials() connectionFunc {
+	authConfig := &connection.AuthConfig{}
+	authConfig.SetConnectType("withDefault")
+	return authConfig
+}
+
 // ConnectWithEnvCredentials returns a connectionFunc configured with the connection string.
 func ConnectWithConnectionString(connectionString string) connectionFunc {
 	authConfig := &connection.AuthConfig{}
@@ -130,3 +442,23 @@ func ConnectWithConnectionString(connectionString string) connectionFunc {
 	authConfig.SetConnectionString(connectionString)
 	return authConfig
 }
+
+// ConnectWithSASToken returns a connectionFunc authorized by a shared access signature, appended
+// to serviceURL on every request. It is supported by Azure Blob and requires ConnectionOptions.ProbeBox,
+// since a container-scoped SAS cannot list the containers.
+func ConnectWithSASToken(serviceURL string, sasToken string) connectionFunc {
+	authConfig := &connection.AuthConfig{}
+	authConfig.SetConnectType("withSASToken")
+	authConfig.SetServiceURL(serviceURL)
+	authConfig.SetSASToken(sasToken)
+	return authConfig
+}
+
+// ConnectWithAnonymousCredentials returns a connectionFunc sending unsigned requests, to read
+// public store boxes without credentials. It is supported by MinIO and AWS S3 and requires
+// ConnectionOptions.ProbeBox, since anonymous clients cannot list the store boxes.
+func ConnectWithAnonymousCredentials() connectionFunc {
+	authConfig := &connection.AuthConfig{}
+	authConfig.SetConnectType("anonymous")
+	return authConfig
+}
@@ -0,0 +1,89 @@
+package m2cs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrBoxAccessDiverged is returned by GetBoxAccess when the main storages grant different
+// anonymous access to the same store box.
+var ErrBoxAccessDiverged = errors.New("store box access diverged across storages")
+
+// ErrInvalidConfiguration is returned by NewFileClientWithOptions when the storages cannot be
+// used together, e.g. when the same storage is given twice.
+var ErrInvalidConfiguration = errors.New("invalid FileClient configuration")
+
+// ErrPrimaryUnavailable is returned by the writes failing on the PRIMARY storage, which are not
+// replicated to the other main storages.
+var ErrPrimaryUnavailable = errors.New("primary storage unavailable")
+
+// ErrAuditFailed is reported when a batch of audit records cannot be written, see WithAudit.
+var ErrAuditFailed = errors.New("audit log write failed")
+
+// ErrAuditUnavailable is returned by the writes and removals of a client with a strict audit log
+// while the audit records cannot be written; the operation is not applied.
+var ErrAuditUnavailable = errors.New("audit log unavailable")
+
+// ErrQuotaExceeded is returned by the writes that would take a store box beyond its quota,
+// see ConfigureQuota; no storage is written.
+var ErrQuotaExceeded = errors.New("store box quota exceeded")
+
+// ErrUnusedEncryptKey is reported by the New*Connection functions when ConnectionOptions.EncryptKey,
+// EncryptKeyBytes, EncryptKeysByBox or DecryptKeys is set while SaveEncrypt is NO_ENCRYPTION, so that the objects are saved in clear, see
+// ConnectionOptions.OnWarning.
+var ErrUnusedEncryptKey = errors.New("EncryptKey is set but SaveEncrypt is NO_ENCRYPTION")
+
+// ErrWeakEncryptKey is returned by the New*Connection functions when the passphrase EncryptKey is
+// too short or too predictable, see ConnectionOptions.MinEncryptKeyLength and AllowWeakKeys.
+var ErrWeakEncryptKey = errors.New("weak encryption key")
+
+// StorageError is the failure of an operation on a single storage.
+// Label is the label of the storage, or its type name when no label is set.
+type StorageError struct {
+	Op    string
+	Label string
+	Err   error
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("%s failed on storage %s: %v", e.Op, e.Label, e.Err)
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// PartialFailureError is returned when an operation fails on some, but not all, of the
+// Total storages it targets. Failures can be inspected with errors.As.
+type PartialFailureError struct {
+	Op       string
+	Total    int
+	Failures []*StorageError
+}
+
+func (e *PartialFailureError) Error() string {
+	msgs := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		msgs = append(msgs, failure.Error())
+	}
+	return fmt.Sprintf("%s partially failed on %d/%d storages: %s", e.Op, len(e.Failures), e.Total, strings.Join(msgs, "\n"))
+}
+
+// Unwrap returns the failures, so that errors.Is and errors.As can match any of them.
+func (e *PartialFailureError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		errs = append(errs, failure)
+	}
+	return errs
+}
+
+// joinStorageErrors joins the failures into a single error.
+func joinStorageErrors(failures []*StorageError) error {
+	errs := make([]error, 0, len(failures))
+	for _, failure := range failures {
+		errs = append(errs, failure)
+	}
+	return errors.Join(errs...)
+}
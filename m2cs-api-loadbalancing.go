@@ -0,0 +1,55 @@
+package m2cs
+
+import (
+	"fmt"
+
+	"github.com/tizianocitro/m2cs/internal/loadbalancing"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// LoadBalancer selects the storage serving each read of a FileClient.
+// A LoadBalancer is safe for concurrent use and can be shared by several FileClients.
+type LoadBalancer = loadbalancing.LoadBalancer
+
+// FileClientOption configures a FileClient created with NewFileClientWithOptions.
+type FileClientOption func(*FileClient) error
+
+// NewLoadBalancer creates a load balancer with the given strategy over the given storages.
+// Reads are served by the non-main storages first, then by the main storages.
+func NewLoadBalancer(strategy LoadBalancingStrategy, storages ...filestorage.FileStorage) (LoadBalancer, error) {
+	var lbStrategy loadbalancing.Strategy
+	switch strategy {
+	case READ_REPLICA_FIRST:
+		lbStrategy = loadbalancing.CLASSIC
+	case ROUND_ROBIN:
+		lbStrategy = loadbalancing.ROUND_ROBIN
+	default:
+		return nil, fmt.Errorf("unsupported load balancing strategy: %v", strategy)
+	}
+
+	lb, err := loadbalancing.Factory{}.NewLoadBalancer(lbStrategy, readGroups(storages))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create load balancer: %w", err)
+	}
+
+	return lb, nil
+}
+
+// NewRoundRobinLoadBalancer creates a ROUND_ROBIN load balancer over the given storages.
+// FileClients sharing it through WithSharedLoadBalancer share a single rotation, so that
+// short-lived clients do not all start from the first replica.
+func NewRoundRobinLoadBalancer(storages ...filestorage.FileStorage) LoadBalancer {
+	return loadbalancing.NewRoundRobinLB(readGroups(storages))
+}
+
+// WithSharedLoadBalancer makes the FileClient use the given load balancer instead of
+// building its own. The balancer should be created over the same storages as the client.
+func WithSharedLoadBalancer(lb LoadBalancer) FileClientOption {
+	return func(f *FileClient) error {
+		if lb == nil {
+			return fmt.Errorf("load balancer is nil")
+		}
+		f.lb = lb
+		return nil
+	}
+}
@@ -0,0 +1,158 @@
+package m2cs
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tizianocitro/m2cs/internal/progress"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// PutOptions holds the optional settings of a PutObjectWithOptions call.
+// Progress callbacks of ASYNC_REPLICATION background writes may be invoked after the call returns.
+type PutOptions struct {
+	Progress          func(transferred, total int64)               // Progress summed over all target storages; total is the payload size times the number of targets
+	StorageProgress   func(label string, transferred, total int64) // Progress of each target storage, identified by its label
+	ProgressInterval  time.Duration                                // Minimum time between two callbacks of the same storage (default: every read)
+	ProgressBytes     int64                                        // Minimum number of bytes between two callbacks of the same storage (default: every read)
+	IdempotencyKey    string                                       // Key identifying the logical write: storages already holding the object written with it skip the write (default: none)
+	Retention         Retention                                    // WORM retention of the written object (default: none)
+	Size              int64                                        // Size of the payload in bytes, read upfront and passed to the storages so that they do not measure it (default: measured)
+	MaxSize           int64                                        // Maximum size of the payload in bytes, failing with ErrObjectTooLarge beyond it (default: the limit of WithMaxObjectSize)
+	ChecksumAlgorithm ChecksumAlgorithm                            // Additional checksum of the stored bytes verified by the providers (default: none)
+	IfMatch           Version                                      // Version the object must still have on the storages, see PutObjectWithReport (default: none)
+	ContentType       string                                       // Content type stored with the object by the storages implementing filestorage.OptionsPutter (default: none)
+	ExpectNonEmpty    bool                                         // Fail with ErrUnexpectedPayload when the payload is empty, e.g. read from an already consumed reader (default: empty payloads are written)
+}
+
+// GetOptions holds the optional settings of a GetObjectWithOptions call.
+type GetOptions struct {
+	Progress         func(transferred, total int64) // Progress of the download; total is -1 until the end of the object is reached
+	ProgressInterval time.Duration                  // Minimum time between two callbacks (default: every read)
+	ProgressBytes    int64                          // Minimum number of bytes between two callbacks (default: every read)
+	ReadConsistency  ReadConsistency                // Freshness required from the copy read, see ReadConsistency (default: EVENTUAL_CONSISTENCY)
+}
+
+// progressReader wraps r so that it reports to the Progress callback of the options.
+func (o GetOptions) progressReader(r io.Reader) io.Reader {
+	return progress.NewReader(r, -1, o.Progress, progress.Options{Interval: o.ProgressInterval, Bytes: o.ProgressBytes})
+}
+
+// SyncOptions holds the settings of a SyncBox call.
+type SyncOptions struct {
+	Concurrency int                   // Maximum number of concurrent copies (default: 4)
+	DryRun      bool                  // Only plan the copies, without performing them
+	Filter      func(ObjectInfo) bool // Optional filter; objects for which it returns false are skipped
+}
+
+// PrefetchOptions holds the settings of a Prefetch call.
+type PrefetchOptions struct {
+	Concurrency int // Maximum number of concurrent fetches (default: 4)
+}
+
+// SoftDeleteOptions defines the configuration of the soft-delete mode of a FileClient.
+type SoftDeleteOptions struct {
+	Enabled  bool   // Indicates if RemoveObject moves objects to the trash (default: false)
+	TrashBox string // Store box holding the trashed objects on each main storage (default: "m2cs-trash")
+}
+
+// ParallelOptions holds the optional settings of the DownloadParallel and UploadParallel calls.
+type ParallelOptions = filestorage.ParallelOptions
+
+// URLOptions holds the optional settings of a PutObjectFromURL call.
+type URLOptions struct {
+	HTTPClient   *http.Client // Client used to download the source (default: http.DefaultClient)
+	MaxSize      int64        // Maximum size of the source in bytes (default: the limit of the put options)
+	MaxRedirects int          // Maximum number of redirects to follow; a negative value disables redirects (default: 10)
+	Put          PutOptions   // Options of the replicated write
+}
+
+// LifecycleRule expires or transitions the objects of a store box, see SetBoxLifecycle.
+type LifecycleRule = filestorage.LifecycleRule
+
+// WatchOptions holds the optional settings of a WatchWithOptions call.
+type WatchOptions struct {
+	PollInterval time.Duration // Interval between two listings of the storages without push notifications (default: 10s)
+	DedupWindow  time.Duration // Window in which an event reported by several storages is delivered once (default: 5s)
+}
+
+// ObjectEvent is a change of an object reported by a storage, see Watch.
+type ObjectEvent = filestorage.ObjectEvent
+
+// Retention protects an object from being overwritten or deleted until Until, see PutOptions.
+type Retention = filestorage.Retention
+
+// LockStatus reports the retention and the legal hold of an object, see ObjectStat.
+type LockStatus = filestorage.LockStatus
+
+// ObjectStat describes an object, see GetObjectWithInfo.
+type ObjectStat = filestorage.ObjectStat
+
+// BoxAccess describes the anonymous access granted on a store box, see GetBoxAccess.
+type BoxAccess = filestorage.BoxAccess
+
+// ShadowOptions defines the shadow reads of a FileClient, see WithShadowReads.
+type ShadowOptions struct {
+	Storage           filestorage.FileStorage // Storage read in the background and compared with the served objects
+	Percentage        float64                 // Share of the GetObject calls shadowed, from 0 to 100
+	MaxBytesPerSecond int64                   // Bandwidth of all the shadow reads together (default: 1 MB/s)
+	MaxConcurrent     int                     // Maximum number of shadow reads in flight; further calls are not shadowed (default: 4)
+	Timeout           time.Duration           // Timeout of a single shadow read (default: 1m)
+	OnResult          func(ShadowResult)      // Receives every comparison (default: mismatches and errors are logged)
+}
+
+// ShadowResult is the outcome of the comparison of an object served by a FileClient with its
+// copy on the shadow storage.
+type ShadowResult struct {
+	StoreBox string
+	FileName string
+	Label    string        // Label of the shadow storage
+	Match    bool          // The copies have the same SHA-256 digest
+	Primary  string        // Hex SHA-256 digest of the served object
+	Shadow   string        // Hex SHA-256 digest of the shadow copy, empty when it could not be read
+	Err      error         // Error reading the shadow copy
+	Duration time.Duration // Time spent reading the shadow copy
+}
+
+// RetryPolicy defines how the failures of an operation on a storage are retried, see WithRetryPolicy.
+type RetryPolicy struct {
+	MaxAttempts int              // Attempts of an operation on a storage, the first one included (default: 3)
+	BaseDelay   time.Duration    // Delay before the first retry, doubled at every retry (default: 100ms)
+	MaxDelay    time.Duration    // Longest delay between two attempts (default: 5s)
+	RetryOn     func(error) bool // Reports whether a failure is retried (default: filestorage.IsRetriable)
+}
+
+// AuditOptions defines the audit log of a FileClient, see WithAudit.
+type AuditOptions struct {
+	Storage       filestorage.FileStorage // Storage holding the audit log
+	StoreBox      string                  // Store box of Storage the batches of records are written to
+	FlushInterval time.Duration           // Maximum time a record waits before being written (default: 5s)
+	MaxBatch      int                     // Number of pending records triggering a write before FlushInterval (default: 100)
+	MaxPending    int                     // Records kept while the audit storage fails; the oldest ones are dropped beyond (default: 10000)
+	Timeout       time.Duration           // Timeout of the write of a batch (default: 30s)
+	Strict        bool                    // Writes and removals fail with ErrAuditUnavailable while the last batch could not be written
+	OnError       func(error)             // Receives the failures to write or keep the records (default: they are logged)
+}
+
+// AuditRecord is an entry of the audit log, describing a successful write or removal.
+// The store box and the key are the ones stored, with the prefixes and the encoding of the client.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Op        string    `json:"op"` // "PutObject" or "RemoveObject"
+	StoreBox  string    `json:"box"`
+	Key       string    `json:"key"`
+	Size      int64     `json:"size,omitempty"`      // Size of the written object
+	Checksum  string    `json:"checksum,omitempty"`  // Hex SHA-256 digest of the written object
+	Storages  []string  `json:"storages"`            // Labels of the storages holding the change when the operation returned
+	Principal string    `json:"principal,omitempty"` // Principal set on the context of the operation, see ContextWithPrincipal
+}
+
+// BackpressureOptions defines when an ASYNC_REPLICATION client stops replicating in background,
+// see WithBackpressure.
+type BackpressureOptions struct {
+	HighWater    int                   // Background replications in flight from which the writes are throttled
+	LowWater     int                   // Background replications in flight at or below which the throttling stops (default: HighWater / 2)
+	Block        bool                  // Throttled writes wait for the backlog to drain instead of replicating synchronously
+	OnModeChange func(ReplicationMode) // Receives the mode the writes switch to, SYNC_REPLICATION when throttled; it must not block
+}
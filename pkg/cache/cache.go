@@ -0,0 +1,18 @@
+// Package cache defines the interface of the cache backends of a FileClient, so that the
+// objects can be cached in a store shared by several FileClient instances, e.g. Redis.
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Backend caches objects by key. Get reports false on a miss. The TTL and the size of the
+// data given to Set are hints: a backend may expire items earlier, or not store items too
+// large for it, without failing. Clear removes every item of the cache, and only those.
+type Backend interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, data []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	Clear(ctx context.Context) error
+}
@@ -0,0 +1,161 @@
+// Package redis implements a cache.Backend storing the objects in Redis, so that the
+// FileClient instances of several replicas of a service share their cache.
+// It is built on go-redis, whose UniversalClient serves a single server, a cluster or a
+// failover group behind Sentinel, with its TLS, pool and timeout options.
+package redis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/tizianocitro/m2cs/pkg/cache"
+)
+
+// Defaults of the Options.
+const (
+	defaultKeyPrefix    = "m2cs:"
+	defaultMaxItemBytes = 512 << 20 // Largest string value of Redis
+	scanCount           = 1000
+)
+
+// UniversalOptions are the go-redis options of the Redis deployment of New: its addresses,
+// credentials, database, TLSConfig, pool and timeouts. With several Addrs it is a cluster, and
+// with MasterName a failover group behind Sentinel.
+type UniversalOptions = goredis.UniversalOptions
+
+// Options holds the settings of a Client.
+type Options struct {
+	KeyPrefix    string // Prefix of the keys of the cache, so that it can share a database (default: "m2cs:")
+	MaxItemBytes int64  // Items larger than this are not stored (default: 512 MB)
+}
+
+// Client is a cache.Backend storing the objects as Redis strings, expiring with their TTL.
+// It is safe for concurrent use.
+type Client struct {
+	rdb   goredis.UniversalClient
+	opts  Options
+	owned bool // Whether Close closes rdb
+}
+
+var _ cache.Backend = (*Client)(nil)
+
+// New returns a Client of the Redis deployment described by redisOpts, e.g.
+// &UniversalOptions{Addrs: []string{"localhost:6379"}}. The connections are opened on demand
+// and closed by Close.
+func New(redisOpts *UniversalOptions, opts Options) *Client {
+	c := NewWithClient(goredis.NewUniversalClient(redisOpts), opts)
+	c.owned = true
+	return c
+}
+
+// NewWithClient returns a Client using rdb, e.g. a client shared with other parts of the
+// service. Close leaves rdb open.
+func NewWithClient(rdb goredis.UniversalClient, opts Options) *Client {
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = defaultKeyPrefix
+	}
+	if opts.MaxItemBytes <= 0 {
+		opts.MaxItemBytes = defaultMaxItemBytes
+	}
+	return &Client{rdb: rdb, opts: opts}
+}
+
+// Ping checks that the server is reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.rdb.Ping(ctx).Err()
+}
+
+// Get returns the object cached under key.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	data, err := c.rdb.Get(ctx, c.opts.KeyPrefix+key).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Set caches data under key for ttl, or until evicted by the server when ttl is not positive.
+// Data larger than MaxItemBytes is not stored.
+func (c *Client) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	if int64(len(data)) > c.opts.MaxItemBytes {
+		return nil
+	}
+	if ttl > 0 {
+		ttl = max(ttl, time.Millisecond)
+	} else {
+		ttl = 0
+	}
+	return c.rdb.Set(ctx, c.opts.KeyPrefix+key, data, ttl).Err()
+}
+
+// Delete removes the object cached under key, if any.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.rdb.Del(ctx, c.opts.KeyPrefix+key).Err()
+}
+
+// Clear removes the objects of the cache, i.e. the keys starting with KeyPrefix, scanning
+// the database so that the server is not blocked; on a cluster, every master is scanned.
+// The other keys are left untouched.
+func (c *Client) Clear(ctx context.Context) error {
+	if cluster, ok := c.rdb.(*goredis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *goredis.Client) error {
+			return c.clear(ctx, node)
+		})
+	}
+	return c.clear(ctx, c.rdb)
+}
+
+// clear removes the keys of the cache held by rdb, a page of SCAN at a time. The keys are
+// deleted one by one in a pipeline, as those of a cluster node may belong to different slots.
+func (c *Client) clear(ctx context.Context, rdb goredis.Cmdable) error {
+	var cursor uint64
+	for {
+		keys, next, err := rdb.Scan(ctx, cursor, escapePattern(c.opts.KeyPrefix)+"*", scanCount).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if _, err := rdb.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+				for _, key := range keys {
+					pipe.Del(ctx, key)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Close closes the connections of the Client created by New. The ones of a Client created by
+// NewWithClient are left open.
+func (c *Client) Close() error {
+	if !c.owned {
+		return nil
+	}
+	return c.rdb.Close()
+}
+
+// escapePattern escapes the glob characters of a SCAN pattern.
+func escapePattern(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
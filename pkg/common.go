@@ -1,21 +1,207 @@
 package common
 
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
 // ConnectionProperties defines the properties for a connection.
 // IsMainInstance indicates if this is the main instance (can read and write).
 // SaveEncrypt indicates if data should be saved in an encrypted format.
 // SaveCompress indicates if data should be saved in a compressed format.
+// Label is an optional human readable name identifying the connection.
+// Role is the role of the connection in the writes of a FileClient, see StorageRole.
+// ProbeBox is an optional store box checked when the client is created, instead of
+// listing all the store boxes, for credentials not allowed to list them.
+// BoxAliases maps the logical names of the store boxes, used by the callers, to the physical
+// names of the store boxes of this connection, see PhysicalBox.
+// AutoCreateBox creates the missing store boxes on the first write.
+// ReadOnly rejects the writes, the removals and the changes of the store boxes, see
+// filestorage.ReadOnlySetter.
+// EncryptKeysByBox holds the keys of the store boxes encrypted with their own key, by logical
+// name; the other store boxes are encrypted with EncryptKey, or EncryptKeyBytes.
+// DecryptKeys holds passphrases decrypting the objects besides EncryptKey, e.g. on a read-only
+// client without EncryptKey, and EncryptOnly keeps EncryptKey from decrypting, see CanDecrypt.
+// TransformRules selects the compression and the encryption of the objects by key or content
+// type, instead of SaveCompress and SaveEncrypt, see ForObject.
+// CompressionThreshold is the maximum ratio of the compressed to the original size of the
+// objects stored compressed: the others are stored uncompressed.
 type ConnectionProperties struct {
-	IsMainInstance bool
-	SaveEncrypt    EncryptionAlgorithm
-	SaveCompress   CompressionAlgorithm
-	EncryptKey     string // Optional key for encryption, if needed
+	Label                string
+	IsMainInstance       bool
+	Role                 StorageRole
+	SaveEncrypt          EncryptionAlgorithm
+	SaveCompress         CompressionAlgorithm
+	EncryptKey           string            // Optional key for encryption, if needed
+	EncryptKeyBytes      []byte            // Optional raw 32-byte key, used when EncryptKey is empty
+	EncryptKeysByBox     map[string]string // Optional keys of the store boxes, by logical name, instead of EncryptKey
+	DecryptKeys          []string          // Optional keys decrypting the objects, tried after EncryptKey
+	EncryptOnly          bool              // EncryptKey, or EncryptKeyBytes, only encrypts
+	ProbeBox             string            // Optional store box checked instead of listing the store boxes
+	BoxAliases           map[string]string // Optional logical to physical store box names
+	AutoCreateBox        bool              // Creates the store box of a put failing because it is missing
+	ReadOnly             bool              // Rejects the operations changing the content of the storage
+	TransformRules       []TransformRule   // Optional transforms of the objects by key or content type
+	CompressionThreshold float64           // Optional maximum compression ratio, 0.97 by default, or negative to always compress
+}
+
+// PhysicalBox returns the physical name of the logical store box box, which is box itself
+// when it has no alias.
+func (p ConnectionProperties) PhysicalBox(box string) string {
+	if physical, ok := p.BoxAliases[box]; ok {
+		return physical
+	}
+	return box
+}
+
+// LogicalBox returns the logical name of the physical store box physical, as listed by the
+// storages, which is physical itself when no alias maps to it.
+func (p ConnectionProperties) LogicalBox(physical string) string {
+	for logical, box := range p.BoxAliases {
+		if box == physical {
+			return logical
+		}
+	}
+	return physical
+}
+
+// ValidateBoxAliases returns an error when BoxAliases maps a name to an empty one, or two
+// names to the same store box, whose listings could not be mapped back.
+func (p ConnectionProperties) ValidateBoxAliases() error {
+	logical := make(map[string]string, len(p.BoxAliases))
+	for alias, box := range p.BoxAliases {
+		if alias == "" || box == "" {
+			return fmt.Errorf("invalid store box alias %q -> %q: names cannot be empty", alias, box)
+		}
+		if other, ok := logical[box]; ok {
+			first, second := min(alias, other), max(alias, other)
+			return fmt.Errorf("invalid store box aliases: both %q and %q map to %q", first, second, box)
+		}
+		logical[box] = alias
+	}
+	return nil
+}
+
+// TransformRule selects the compression and the encryption of the objects whose key matches
+// KeyGlob and whose content type matches ContentType, e.g. to skip the compression of media that
+// are compressed already. Both are path.Match patterns, and an empty one matches everything:
+// KeyGlob is matched against the base name of the keys when it has no '/', so that "*.jpg"
+// matches "photos/cat.jpg", and ContentType against the media type, without parameters and
+// case-insensitively, so that "image/*" matches "image/JPEG; q=0.9".
+type TransformRule struct {
+	KeyGlob     string
+	ContentType string
+	Compress    CompressionAlgorithm
+	Encrypt     EncryptionAlgorithm
+}
+
+// Matches reports whether the rule applies to the object key written with contentType.
+func (r TransformRule) Matches(key, contentType string) bool {
+	if r.KeyGlob != "" {
+		name := key
+		if !strings.Contains(r.KeyGlob, "/") {
+			name = path.Base(key)
+		}
+		if ok, _ := path.Match(r.KeyGlob, name); !ok {
+			return false
+		}
+	}
+	if r.ContentType != "" {
+		mediaType, _, _ := strings.Cut(contentType, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if ok, _ := path.Match(strings.ToLower(r.ContentType), mediaType); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ForObject returns the properties the object key, written with contentType, is saved with: p
+// with the compression and the encryption of the first of its TransformRules matching the
+// object, or p itself when none does.
+func (p ConnectionProperties) ForObject(key, contentType string) ConnectionProperties {
+	for _, rule := range p.TransformRules {
+		if rule.Matches(key, contentType) {
+			p.SaveCompress, p.SaveEncrypt = rule.Compress, rule.Encrypt
+			return p
+		}
+	}
+	return p
+}
+
+// Transformed reports whether objects may be saved compressed or encrypted, by SaveCompress and
+// SaveEncrypt or by one of the TransformRules.
+func (p ConnectionProperties) Transformed() bool {
+	if p.SaveCompress != NO_COMPRESSION || p.SaveEncrypt != NO_ENCRYPTION {
+		return true
+	}
+	for _, rule := range p.TransformRules {
+		if rule.Compress != NO_COMPRESSION || rule.Encrypt != NO_ENCRYPTION {
+			return true
+		}
+	}
+	return false
+}
+
+// CanEncrypt reports whether the storage has a key encrypting the objects: EncryptKey or
+// EncryptKeyBytes.
+func (p ConnectionProperties) CanEncrypt() bool {
+	return p.EncryptKey != "" || len(p.EncryptKeyBytes) > 0
+}
+
+// CanDecrypt reports whether the storage can read the objects it stores: it saves them
+// unencrypted, or has a key decrypting them, DecryptKeys or EncryptKey without EncryptOnly.
+// AES-256-GCM being symmetric, EncryptOnly and DecryptKeys only restrict what the client does
+// with its keys: whoever holds the key of an object can both read and forge it.
+func (p ConnectionProperties) CanDecrypt() bool {
+	encrypts := p.SaveEncrypt != NO_ENCRYPTION ||
+		slices.ContainsFunc(p.TransformRules, func(r TransformRule) bool { return r.Encrypt != NO_ENCRYPTION })
+	return !encrypts || len(p.DecryptKeys) > 0 || p.CanEncrypt() && !p.EncryptOnly
+}
+
+// ValidateTransformRules returns an error when a rule of TransformRules has a malformed pattern,
+// an unknown algorithm, GZIP_CONTENT_ENCODING with encryption, or encrypts without EncryptKey,
+// EncryptKeyBytes nor DecryptKeys.
+func (p ConnectionProperties) ValidateTransformRules() error {
+	for i, rule := range p.TransformRules {
+		if _, err := path.Match(rule.KeyGlob, ""); err != nil {
+			return fmt.Errorf("invalid transform rule %d: KeyGlob %q: %w", i, rule.KeyGlob, err)
+		}
+		if _, err := path.Match(rule.ContentType, ""); err != nil {
+			return fmt.Errorf("invalid transform rule %d: ContentType %q: %w", i, rule.ContentType, err)
+		}
+		switch rule.Compress {
+		case NO_COMPRESSION, GZIP_COMPRESSION, GZIP_CONTENT_ENCODING:
+		default:
+			return fmt.Errorf("invalid transform rule %d: unsupported compression algorithm: %v", i, rule.Compress)
+		}
+		switch rule.Encrypt {
+		case NO_ENCRYPTION:
+		case AES256_ENCRYPTION:
+			if rule.Compress == GZIP_CONTENT_ENCODING {
+				return fmt.Errorf("invalid transform rule %d: GZIP_CONTENT_ENCODING cannot be combined with encryption", i)
+			}
+			if !p.CanEncrypt() && len(p.DecryptKeys) == 0 {
+				return fmt.Errorf("invalid transform rule %d: EncryptKey, EncryptKeyBytes or DecryptKeys must be set with %v", i, rule.Encrypt)
+			}
+		default:
+			return fmt.Errorf("invalid transform rule %d: unsupported encryption algorithm: %v", i, rule.Encrypt)
+		}
+	}
+	return nil
 }
 
 type CompressionAlgorithm int
 
+// GZIP_CONTENT_ENCODING stores gzip compressed objects tagged with the
+// Content-Encoding: gzip header, so that provider tools and browsers decompress
+// them transparently.
 const (
 	NO_COMPRESSION CompressionAlgorithm = iota
 	GZIP_COMPRESSION
+	GZIP_CONTENT_ENCODING
 )
 
 type EncryptionAlgorithm int
@@ -25,9 +211,241 @@ const (
 	AES256_ENCRYPTION
 )
 
+// StorageTier is the access tier of an object, from the most to the least accessible.
+// Each provider maps it to its own storage classes or access tiers.
+type StorageTier int
+
+const (
+	HOT_TIER StorageTier = iota
+	COOL_TIER
+	COLD_TIER
+	ARCHIVE_TIER
+)
+
+// StorageRole is the role of a storage in the writes of a FileClient. The PRIMARY storage is the
+// authoritative write target, the SECONDARY_MAIN storages follow it, and REPLICA storages are only read.
+// PRIMARY and SECONDARY_MAIN storages must be main instances, REPLICA storages must not.
+// NO_ROLE leaves the role to IsMainInstance: SECONDARY_MAIN for main instances, REPLICA otherwise.
+type StorageRole int
+
+const (
+	NO_ROLE StorageRole = iota
+	PRIMARY
+	SECONDARY_MAIN
+	REPLICA
+)
+
+// RetentionMode is the write-once-read-many protection of an object until its retention expires.
+// GOVERNANCE_RETENTION can be lifted or shortened by privileged users, COMPLIANCE_RETENTION by nobody.
+type RetentionMode int
+
+const (
+	NO_RETENTION RetentionMode = iota
+	GOVERNANCE_RETENTION
+	COMPLIANCE_RETENTION
+)
+
+// ChecksumAlgorithm is the additional checksum computed over the stored bytes of an object,
+// which the providers verify on upload.
+type ChecksumAlgorithm int
+
+const (
+	NO_CHECKSUM ChecksumAlgorithm = iota
+	CRC32C_CHECKSUM
+	SHA1_CHECKSUM
+	SHA256_CHECKSUM
+)
+
+// EventType is the kind of change reported by an object event.
+// OBJECT_CREATED covers both new and overwritten objects.
+type EventType int
+
+const (
+	OBJECT_CREATED EventType = iota
+	OBJECT_REMOVED
+)
+
 type Properties struct {
-	IsMainInstance bool
-	SaveEncrypted  EncryptionAlgorithm
-	SaveCompressed CompressionAlgorithm
-	EncryptKey     string // Optional key for encryption, if needed
+	Label                string
+	IsMainInstance       bool
+	Role                 StorageRole
+	SaveEncrypted        EncryptionAlgorithm
+	SaveCompressed       CompressionAlgorithm
+	EncryptKey           string            // Optional key for encryption, if needed
+	EncryptKeyBytes      []byte            // Optional raw 32-byte key, used when EncryptKey is empty
+	EncryptKeysByBox     map[string]string // Optional keys of the store boxes, by logical name, instead of EncryptKey
+	DecryptKeys          []string          // Optional keys decrypting the objects, tried after EncryptKey
+	EncryptOnly          bool              // EncryptKey, or EncryptKeyBytes, only encrypts
+	ProbeBox             string            // Optional store box checked instead of listing the store boxes
+	BoxAliases           map[string]string // Optional logical to physical store box names
+	AutoCreateBox        bool              // Creates the store box of a put failing because it is missing
+	ReadOnly             bool              // Rejects the operations changing the content of the storage
+	TransformRules       []TransformRule   // Optional transforms of the objects by key or content type
+	CompressionThreshold float64           // Optional maximum compression ratio, 0.97 by default, or negative to always compress
+}
+
+// EncryptKeySize is the size of the raw keys of EncryptKeyBytes.
+const EncryptKeySize = 32
+
+// ValidateEncryption fails when the objects are saved encrypted without a key, or when the key
+// is given both as a passphrase and as raw bytes, or as raw bytes of the wrong size, or when
+// EncryptKeysByBox holds empty names or keys, or DecryptKeys empty keys, or when EncryptOnly is
+// set without a key to encrypt with, or when TransformRules is invalid, see
+// ConnectionProperties.ValidateTransformRules. Objects saved encrypted need EncryptKey,
+// EncryptKeyBytes or, for a client only reading them, DecryptKeys.
+func (p Properties) ValidateEncryption() error {
+	switch {
+	case p.EncryptKey != "" && len(p.EncryptKeyBytes) > 0:
+		return fmt.Errorf("EncryptKey and EncryptKeyBytes cannot both be set; keep either the passphrase or the raw key")
+	case len(p.EncryptKeyBytes) > 0 && len(p.EncryptKeyBytes) != EncryptKeySize:
+		return fmt.Errorf("EncryptKeyBytes must be %d bytes, got %d; generate it with crypto/rand, or derive it with sha256.Sum256", EncryptKeySize, len(p.EncryptKeyBytes))
+	case p.SaveEncrypted == AES256_ENCRYPTION && p.EncryptKey == "" && len(p.EncryptKeyBytes) == 0 && len(p.DecryptKeys) == 0:
+		return fmt.Errorf("EncryptKey, EncryptKeyBytes or DecryptKeys must be set with %v", p.SaveEncrypted)
+	case p.EncryptOnly && p.EncryptKey == "" && len(p.EncryptKeyBytes) == 0:
+		return fmt.Errorf("EncryptOnly requires EncryptKey or EncryptKeyBytes")
+	}
+	if slices.Contains(p.DecryptKeys, "") {
+		return fmt.Errorf("DecryptKeys cannot hold empty keys")
+	}
+	for box, key := range p.EncryptKeysByBox {
+		if box == "" || key == "" {
+			return fmt.Errorf("invalid EncryptKeysByBox entry %q: store box names and keys cannot be empty", box)
+		}
+	}
+	rules := ConnectionProperties{EncryptKey: p.EncryptKey, EncryptKeyBytes: p.EncryptKeyBytes, DecryptKeys: p.DecryptKeys, TransformRules: p.TransformRules}
+	return rules.ValidateTransformRules()
+}
+
+// String returns the name of the compression algorithm.
+func (c CompressionAlgorithm) String() string {
+	switch c {
+	case NO_COMPRESSION:
+		return "NO_COMPRESSION"
+	case GZIP_COMPRESSION:
+		return "GZIP_COMPRESSION"
+	case GZIP_CONTENT_ENCODING:
+		return "GZIP_CONTENT_ENCODING"
+	default:
+		return fmt.Sprintf("CompressionAlgorithm(%d)", int(c))
+	}
+}
+
+// ParseCompressionAlgorithm returns the compression algorithm named s, case-insensitively: the
+// name of its constant, as returned by String, or "none", "gzip" and "gzip-content-encoding".
+func ParseCompressionAlgorithm(s string) (CompressionAlgorithm, error) {
+	return ParseName(s, "compression algorithm",
+		[]CompressionAlgorithm{NO_COMPRESSION, GZIP_COMPRESSION, GZIP_CONTENT_ENCODING},
+		"none", "gzip", "gzip-content-encoding")
+}
+
+// String returns the name of the encryption algorithm.
+func (e EncryptionAlgorithm) String() string {
+	switch e {
+	case NO_ENCRYPTION:
+		return "NO_ENCRYPTION"
+	case AES256_ENCRYPTION:
+		return "AES256_ENCRYPTION"
+	default:
+		return fmt.Sprintf("EncryptionAlgorithm(%d)", int(e))
+	}
+}
+
+// ParseEncryptionAlgorithm returns the encryption algorithm named s, case-insensitively: the
+// name of its constant, as returned by String, or "none" and "aes256".
+func ParseEncryptionAlgorithm(s string) (EncryptionAlgorithm, error) {
+	return ParseName(s, "encryption algorithm",
+		[]EncryptionAlgorithm{NO_ENCRYPTION, AES256_ENCRYPTION},
+		"none", "aes256")
+}
+
+// ParseName returns the value of values named s, case-insensitively: the name returned by its
+// String method, or the short name of the same index, used in the configurations. kind names the
+// type of the values in the errors.
+func ParseName[T fmt.Stringer](s, kind string, values []T, short ...string) (T, error) {
+	for i, v := range values {
+		if strings.EqualFold(s, v.String()) || (i < len(short) && strings.EqualFold(s, short[i])) {
+			return v, nil
+		}
+	}
+
+	var zero T
+	expected := strings.Join(short, ", ")
+	if i := strings.LastIndex(expected, ", "); i >= 0 {
+		expected = expected[:i] + " or " + expected[i+2:]
+	}
+	return zero, fmt.Errorf("unknown %s %q; use %s", kind, s, expected)
+}
+
+// String returns the name of the storage tier.
+func (t StorageTier) String() string {
+	switch t {
+	case HOT_TIER:
+		return "HOT_TIER"
+	case COOL_TIER:
+		return "COOL_TIER"
+	case COLD_TIER:
+		return "COLD_TIER"
+	case ARCHIVE_TIER:
+		return "ARCHIVE_TIER"
+	default:
+		return fmt.Sprintf("StorageTier(%d)", int(t))
+	}
+}
+
+// String returns the name of the storage role.
+func (r StorageRole) String() string {
+	switch r {
+	case NO_ROLE:
+		return "NO_ROLE"
+	case PRIMARY:
+		return "PRIMARY"
+	case SECONDARY_MAIN:
+		return "SECONDARY_MAIN"
+	case REPLICA:
+		return "REPLICA"
+	default:
+		return fmt.Sprintf("StorageRole(%d)", int(r))
+	}
+}
+
+// String returns the name of the retention mode.
+func (r RetentionMode) String() string {
+	switch r {
+	case NO_RETENTION:
+		return "NO_RETENTION"
+	case GOVERNANCE_RETENTION:
+		return "GOVERNANCE_RETENTION"
+	case COMPLIANCE_RETENTION:
+		return "COMPLIANCE_RETENTION"
+	default:
+		return fmt.Sprintf("RetentionMode(%d)", int(r))
+	}
+}
+
+// String returns the name of the checksum algorithm.
+func (c ChecksumAlgorithm) String() string {
+	switch c {
+	case NO_CHECKSUM:
+		return "NO_CHECKSUM"
+	case CRC32C_CHECKSUM:
+		return "CRC32C_CHECKSUM"
+	case SHA1_CHECKSUM:
+		return "SHA1_CHECKSUM"
+	case SHA256_CHECKSUM:
+		return "SHA256_CHECKSUM"
+	default:
+		return fmt.Sprintf("ChecksumAlgorithm(%d)", int(c))
+	}
+}
+
+// String returns the name of the event type.
+func (e EventType) String() string {
+	switch e {
+	case OBJECT_CREATED:
+		return "OBJECT_CREATED"
+	case OBJECT_REMOVED:
+		return "OBJECT_REMOVED"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(e))
+	}
 }
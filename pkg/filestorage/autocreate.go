@@ -0,0 +1,70 @@
+package filestorage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/aws/smithy-go"
+	"github.com/minio/minio-go/v7"
+	common "github.com/tizianocitro/m2cs/pkg"
+)
+
+// ErrBoxCreationFailed is returned by the puts of the clients with AutoCreateBox whose store box
+// is missing and could not be created. It wraps the failure of the creation.
+var ErrBoxCreationFailed = errors.New("failed to create the missing store box")
+
+// isBoxNotFound reports whether err is the failure of a provider reporting a missing store box:
+// the NoSuchBucket code of MinIO and S3, and the ContainerNotFound code of Azure.
+func isBoxNotFound(err error) bool {
+	if errors.Is(err, ErrBoxNotFound) {
+		return true
+	}
+	var minioErr minio.ErrorResponse
+	if errors.As(err, &minioErr) {
+		return minioErr.Code == "NoSuchBucket"
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == "NoSuchBucket"
+	}
+	return bloberror.HasCode(err, bloberror.ContainerNotFound)
+}
+
+// putCreatingBox runs put and, when it fails because the store box is missing and
+// properties.AutoCreateBox is set, creates the store box with create, then runs put once more
+// with reader rewound. Readers that cannot be rewound are not sent again: the put fails, and
+// succeeds once retried by the caller. create must succeed when the store box already exists,
+// e.g. when created by a concurrent put.
+func putCreatingBox(properties common.ConnectionProperties, reader io.Reader, put func() (PutResult, error), create func() error) (PutResult, error) {
+	if !properties.AutoCreateBox {
+		return put()
+	}
+
+	seeker, seekable := reader.(io.Seeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	result, err := put()
+	if err == nil || !isBoxNotFound(err) {
+		return result, err
+	}
+	if createErr := create(); createErr != nil {
+		return PutResult{}, fmt.Errorf("%w: %w (put: %w)", ErrBoxCreationFailed, createErr, err)
+	}
+	if !seekable {
+		return PutResult{}, fmt.Errorf("store box created, but the payload cannot be sent again: %w", err)
+	}
+	if _, seekErr := seeker.Seek(start, io.SeekStart); seekErr != nil {
+		return PutResult{}, fmt.Errorf("store box created, but the payload cannot be sent again: %w", seekErr)
+	}
+
+	// once only: a store box missing again is not created a second time
+	return put()
+}
@@ -1,11 +1,26 @@
 package filestorage
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"math"
+	"net/http"
+	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	common "github.com/tizianocitro/m2cs/pkg"
 	"github.com/tizianocitro/m2cs/pkg/transform"
 )
@@ -13,23 +28,58 @@ import (
 type AzBlobClient struct {
 	client     *azblob.Client
 	properties common.ConnectionProperties
+	pipelines  *transform.Cache // Pipelines built from properties, see RotateEncryptKey
+	readOnly   readOnlyFlag
 }
 
 func NewAzBlobClient(client *azblob.Client, properties common.ConnectionProperties) (*AzBlobClient, error) {
 	if client == nil {
 		return nil, fmt.Errorf("failed to create AzBlobClient: client is nil")
 	}
+	if err := properties.ValidateBoxAliases(); err != nil {
+		return nil, fmt.Errorf("failed to create AzBlobClient: %w", err)
+	}
 
-	pager := client.NewListContainersPager(nil)
-	_, err := pager.NextPage(context.TODO())
-	if err != nil {
+	if err := probeAzBlob(context.TODO(), client, properties.PhysicalBox(properties.ProbeBox)); err != nil {
 		return nil, fmt.Errorf("failed to connect to azure blob: %w", err)
 	}
 
-	return &AzBlobClient{
+	a := &AzBlobClient{
 		client:     client,
 		properties: properties,
-	}, nil
+		pipelines:  transform.NewCache(properties),
+	}
+	a.readOnly.on.Store(properties.ReadOnly)
+	return a, nil
+}
+
+// probeAzBlob checks the connection by listing the containers or, when probeBox is set, by
+// checking that the container exists. Access denied responses on probeBox are accepted, since
+// scoped credentials may not be allowed to inspect the container, unless the credentials
+// themselves are rejected.
+func probeAzBlob(ctx context.Context, client *azblob.Client, probeBox string) error {
+	if probeBox == "" {
+		_, err := client.NewListContainersPager(nil).NextPage(ctx)
+		return err
+	}
+
+	_, err := client.ServiceClient().NewContainerClient(probeBox).GetProperties(ctx, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) {
+			switch respErr.StatusCode {
+			case http.StatusForbidden:
+				if respErr.ErrorCode != "AuthenticationFailed" && respErr.ErrorCode != "AuthorizationFailure" {
+					return nil
+				}
+			case http.StatusNotFound:
+				return fmt.Errorf("store box %s not found", probeBox)
+			}
+		}
+		return err
+	}
+
+	return nil
 }
 
 func (a *AzBlobClient) GetClient() *azblob.Client {
@@ -37,6 +87,10 @@ func (a *AzBlobClient) GetClient() *azblob.Client {
 }
 
 func (a *AzBlobClient) CreateContainer(ctx context.Context, containerName string) error {
+	if err := a.readOnly.writable("store box creation"); err != nil {
+		return err
+	}
+	containerName = a.properties.PhysicalBox(containerName)
 	_, err := a.client.CreateContainer(ctx, containerName, nil)
 	if err != nil {
 		return err
@@ -46,6 +100,10 @@ func (a *AzBlobClient) CreateContainer(ctx context.Context, containerName string
 }
 
 func (a *AzBlobClient) DeleteContainer(ctx context.Context, containerName string) error {
+	if err := a.readOnly.writable("store box removal"); err != nil {
+		return err
+	}
+	containerName = a.properties.PhysicalBox(containerName)
 	_, err := a.client.DeleteContainer(ctx, containerName, nil)
 	if err != nil {
 		return err
@@ -67,75 +125,328 @@ func (a *AzBlobClient) ListContainers() ([]string, error) {
 		}
 
 		for _, container := range resp.ContainerItems {
-			containers = append(containers, fmt.Sprintf("Name: %s, CreatedOn: %s", *container.Name, container.Properties.LastModified))
+			containers = append(containers, fmt.Sprintf("Name: %s, CreatedOn: %s", a.properties.LogicalBox(*container.Name), container.Properties.LastModified))
 		}
 	}
 	return containers, nil
 }
 
 func (a *AzBlobClient) GetObject(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, error) {
+	storeBox = a.properties.PhysicalBox(storeBox)
+
+	get, err := a.client.DownloadStream(ctx, storeBox, fileName, nil)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	retryReader := get.NewRetryReader(ctx, &azblob.RetryReaderOptions{})
+
+	var contentEncoding string
+	if get.ContentEncoding != nil {
+		contentEncoding = *get.ContentEncoding
+	}
 
-	pipe, err := transform.Factory{}.BuildRPipelineDecryptDecompress(a.properties, a.properties.EncryptKey)
+	pipe, err := readPipeline(a.properties, a.pipelines.Box(storeBox), blobMetadata(get.Metadata), contentEncoding, sizeOf(get.ContentLength))
 	if err != nil {
+		_ = retryReader.Close()
 		return nil, fmt.Errorf("build read pipeline: %w", err)
 	}
 
+	obj, err := pipe.Apply(retryReader)
+	if err != nil {
+		return nil, fmt.Errorf("fail to transform reader: %w", err)
+	}
+
+	return obj, nil
+}
+
+// GetObjectWithInfo retrieves a blob along with its attributes, see InfoGetter.
+// The attributes are taken from the response of the download.
+func (a *AzBlobClient) GetObjectWithInfo(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, ObjectStat, error) {
+	storeBox = a.properties.PhysicalBox(storeBox)
 	get, err := a.client.DownloadStream(ctx, storeBox, fileName, nil)
 	if err != nil {
-		return nil, err
+		return nil, ObjectStat{}, notFound(err)
 	}
 
 	retryReader := get.NewRetryReader(ctx, &azblob.RetryReaderOptions{})
 
+	var contentEncoding string
+	if get.ContentEncoding != nil {
+		contentEncoding = *get.ContentEncoding
+	}
+	metadata := blobMetadata(get.Metadata)
+
+	pipe, err := readPipeline(a.properties, a.pipelines.Box(storeBox), metadata, contentEncoding, sizeOf(get.ContentLength))
+	if err != nil {
+		_ = retryReader.Close()
+		return nil, ObjectStat{}, fmt.Errorf("build read pipeline: %w", err)
+	}
+
 	obj, err := pipe.Apply(retryReader)
 	if err != nil {
-		return nil, fmt.Errorf("fail to transform reader: %w", err)
+		_ = retryReader.Close()
+		return nil, ObjectStat{}, fmt.Errorf("fail to transform reader: %w", err)
 	}
 
-	return obj, nil
+	stat := ObjectStat{ObjectInfo: ObjectInfo{Key: fileName}}
+	var storedSize int64
+	if get.ContentLength != nil {
+		storedSize = *get.ContentLength
+	}
+	stat.Size = logicalSize(a.properties, storedSize, metadata)
+	if get.LastModified != nil {
+		stat.LastModified = *get.LastModified
+	}
+	if get.ETag != nil {
+		stat.ETag = string(*get.ETag)
+	}
+	if get.ContentType != nil {
+		stat.ContentType = *get.ContentType
+	}
+
+	return obj, stat, nil
+}
+
+// GetObjectRange retrieves length bytes of a blob starting at offset.
+// A length <= 0 reads until the end of the blob.
+func (a *AzBlobClient) GetObjectRange(ctx context.Context, storeBox string, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	storeBox = a.properties.PhysicalBox(storeBox)
+	if !supportsRange(a.properties) {
+		return nil, ErrRangeNotSupported
+	}
+
+	count := int64(0)
+	if length > 0 {
+		count = length
+	}
+
+	get, err := a.client.DownloadStream(ctx, storeBox, fileName, &azblob.DownloadStreamOptions{
+		Range: azblob.HTTPRange{Offset: offset, Count: count},
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return get.NewRetryReader(ctx, &azblob.RetryReaderOptions{}), nil
 }
 
 func (a *AzBlobClient) PutObject(ctx context.Context, storeBox, fileName string, reader io.Reader) error {
+	return a.PutObjectWithOptions(ctx, storeBox, fileName, reader, PutOptions{})
+}
+
+// PutObjectWithOptions uploads a blob storing the given content headers and metadata.
+func (a *AzBlobClient) PutObjectWithOptions(ctx context.Context, storeBox, fileName string, reader io.Reader, opts PutOptions) error {
+	_, err := a.PutObjectWithResult(ctx, storeBox, fileName, reader, opts)
+	return err
+}
+
+// PutObjectWithResult uploads a blob like PutObjectWithOptions, reporting its ETag and version.
+func (a *AzBlobClient) PutObjectWithResult(ctx context.Context, storeBox, fileName string, reader io.Reader, opts PutOptions) (PutResult, error) {
+	if err := a.readOnly.writable("put"); err != nil {
+		return PutResult{}, err
+	}
+	return putCreatingBox(a.properties, reader, func() (PutResult, error) {
+		return a.putObject(ctx, storeBox, fileName, reader, opts)
+	}, func() error {
+		return a.createBox(ctx, a.properties.PhysicalBox(storeBox))
+	})
+}
+
+// createBox creates the container of a put, see ConnectionProperties.AutoCreateBox. A container
+// already existing is not an error.
+func (a *AzBlobClient) createBox(ctx context.Context, containerName string) error {
+	_, err := a.client.CreateContainer(ctx, containerName, nil)
+	if bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		return nil
+	}
+	return err
+}
+
+func (a *AzBlobClient) putObject(ctx context.Context, storeBox, fileName string, reader io.Reader, opts PutOptions) (PutResult, error) {
+	storeBox = a.properties.PhysicalBox(storeBox)
 	if reader == nil {
-		return fmt.Errorf("reader is nil")
+		return PutResult{}, fmt.Errorf("reader is nil")
+	}
+	if err := validateRetention(opts.Retention); err != nil {
+		return PutResult{}, err
+	}
+	if opts.Retention.Mode != common.NO_RETENTION {
+		if err := a.checkImmutability(ctx, storeBox); err != nil {
+			return PutResult{}, err
+		}
 	}
 
-	pipe, err := transform.Factory{}.BuildWPipelineCompressEncrypt(a.properties, a.properties.EncryptKey)
+	opts, applied, err := checkIdempotencyKey(opts, func() (map[string]string, bool, error) {
+		return a.objectMetadata(ctx, storeBox, fileName)
+	})
 	if err != nil {
-		return fmt.Errorf("build write pipeline: %w", err)
+		return PutResult{}, fmt.Errorf("failed to check the idempotency key: %w", err)
+	}
+	if applied {
+		return PutResult{}, nil
 	}
 
-	obj, closer, err := pipe.Apply(reader)
+	properties, reader, logical, err := skipEmptyTransforms(a.properties.ForObject(fileName, opts.ContentType), reader, payloadSize(reader, opts))
+	if err != nil {
+		return PutResult{}, err
+	}
+	properties, reader, err = skipPoorCompression(properties, reader)
 	if err != nil {
-		return fmt.Errorf("apply write pipeline: %w", err)
+		return PutResult{}, err
+	}
+	obj, closer, err := writePipeline(properties, a.pipelines.Box(storeBox), reader)
+	if err != nil {
+		return PutResult{}, err
 	}
 
 	if closer != nil {
 		defer closer.Close()
 	}
 
-	_, err = a.client.UploadStream(ctx, storeBox, fileName, obj, nil)
+	opts = withTransformHeaders(opts, a.properties, properties, logical)
+	uploadOptions := &azblob.UploadStreamOptions{}
+	if opts.ContentType != "" || opts.ContentEncoding != "" {
+		uploadOptions.HTTPHeaders = &blob.HTTPHeaders{}
+		if opts.ContentType != "" {
+			uploadOptions.HTTPHeaders.BlobContentType = &opts.ContentType
+		}
+		if opts.ContentEncoding != "" {
+			uploadOptions.HTTPHeaders.BlobContentEncoding = &opts.ContentEncoding
+		}
+	}
+	if len(opts.Metadata) > 0 {
+		uploadOptions.Metadata = make(map[string]*string, len(opts.Metadata))
+		for k, v := range opts.Metadata {
+			v := v
+			uploadOptions.Metadata[k] = &v
+		}
+	}
+	if opts.IfMatch != "" {
+		etag := azcore.ETag(opts.IfMatch)
+		uploadOptions.AccessConditions = &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: &etag}}
+	}
+
+	var result *PutResult
+	if opts.ChecksumAlgorithm != common.NO_CHECKSUM {
+		if obj, result, err = a.uploadWithMD5(ctx, storeBox, fileName, obj, uploadOptions); err != nil {
+			return PutResult{}, fmt.Errorf("azure upload: %w", azPreconditionError(opts.IfMatch, err))
+		}
+	}
+	if result == nil {
+		resp, err := a.client.UploadStream(ctx, storeBox, fileName, obj, uploadOptions)
+		if err != nil {
+			return PutResult{}, fmt.Errorf("azure upload stream: %w", azPreconditionError(opts.IfMatch, err))
+		}
+		result = azPutResult(resp.ETag, resp.VersionID)
+	}
+
+	// uploads cannot carry an immutability policy, which is set once the blob is committed
+	if mode, ok := azImmutabilityPolicies[opts.Retention.Mode]; ok {
+		_, err = a.blobClient(storeBox, fileName).SetImmutabilityPolicy(ctx, opts.Retention.Until, &blob.SetImmutabilityPolicyOptions{Mode: &mode})
+		if err != nil {
+			return PutResult{}, fmt.Errorf("failed to set the immutability policy, the blob is written unprotected: %w", err)
+		}
+	}
+
+	return *result, nil
+}
+
+// UploadParallel uploads a block blob whose blocks are staged concurrently, see ParallelUploader.
+// Blobs fitting in a single block, and blobs saved compressed or encrypted, whose stored size is
+// only known once written, are uploaded with PutObject. Nothing is aborted on error: Azure
+// discards the uncommitted blocks after a week.
+func (a *AzBlobClient) UploadParallel(ctx context.Context, storeBox string, fileName string, r io.ReaderAt, size int64, opts ParallelOptions) error {
+	if err := a.readOnly.writable("upload"); err != nil {
+		return err
+	}
+	partSize, err := azBlockLimits.partSize(size, opts.PartSize)
+	if err != nil {
+		return err
+	}
+	if size <= partSize || !supportsRange(a.properties) {
+		return a.PutObject(ctx, storeBox, fileName, io.NewSectionReader(r, 0, size))
+	}
+	storeBox = a.properties.PhysicalBox(storeBox)
+
+	// Block IDs are unique to the upload, so that concurrent uploads of the same blob do not
+	// stage blocks over each other, and all of the same length, as required by Azure.
+	prefix := make([]byte, 8)
+	if _, err := rand.Read(prefix); err != nil {
+		return fmt.Errorf("failed to generate block IDs: %w", err)
+	}
+
+	blockBlob := a.client.ServiceClient().NewContainerClient(storeBox).NewBlockBlobClient(fileName)
+	blockIDs := make([]string, (size+partSize-1)/partSize)
+	err = uploadParts(ctx, size, partSize, opts.Concurrency, func(ctx context.Context, number int, offset int64, length int64) error {
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%x-%06d", prefix, number)))
+		if _, err := blockBlob.StageBlock(ctx, id, streaming.NopCloser(io.NewSectionReader(r, offset, length)), nil); err != nil {
+			return err
+		}
+		blockIDs[number-1] = id
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("azure upload stream: %w", err)
+		return fmt.Errorf("block upload of %s failed: %w", fileName, err)
+	}
+
+	if _, err := blockBlob.CommitBlockList(ctx, blockIDs, nil); err != nil {
+		return fmt.Errorf("failed to commit the blocks of %s: %w", fileName, err)
 	}
 
 	return nil
 }
 
 func (a *AzBlobClient) RemoveObject(ctx context.Context, storeBox string, fileName string) error {
+	if err := a.readOnly.writable("removal"); err != nil {
+		return err
+	}
+	storeBox = a.properties.PhysicalBox(storeBox)
 	_, err := a.client.DeleteBlob(ctx, storeBox, fileName, nil)
 	if err != nil {
-		return err
+		return notFound(err)
 	}
 
 	return nil
 }
 
 func (a *AzBlobClient) GetConnectionProperties() common.ConnectionProperties {
-	return a.properties
+	properties := a.properties
+	properties.EncryptKey, properties.EncryptKeyBytes = a.pipelines.Key(), a.pipelines.KeyBytes()
+	properties.ReadOnly = a.readOnly.on.Load()
+	return properties
+}
+
+// SetReadOnly makes the storage read-only, or writable again, see ReadOnlySetter.
+func (a *AzBlobClient) SetReadOnly(readOnly bool) {
+	a.readOnly.on.Store(readOnly)
+}
+
+// RotateEncryptKey replaces the encryption key, see KeyRotator.
+func (a *AzBlobClient) RotateEncryptKey(key string) error {
+	if err := a.pipelines.SetKey(key); err != nil {
+		return fmt.Errorf("failed to rotate the encryption key: %w", err)
+	}
+	return nil
 }
 
+// ExistObject reports whether a blob exists, looking for its name in the listing of the
+// container. The directory markers, i.e. the names ending with "/", are looked up with the
+// properties of the blob instead, as StatObject does, since a hierarchical namespace lists them
+// as directories, without the trailing "/".
 func (a *AzBlobClient) ExistObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
+	storeBox = a.properties.PhysicalBox(storeBox)
+	if isDirectoryMarker(fileName) {
+		_, err := a.blobClient(storeBox, fileName).GetProperties(ctx, nil)
+		if isObjectNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to get blob properties: %w", err)
+		}
+		return true, nil
+	}
+
 	pager := a.client.NewListBlobsFlatPager(storeBox, &azblob.ListBlobsFlatOptions{
 		Prefix: &fileName,
 	})
@@ -157,7 +468,58 @@ func (a *AzBlobClient) ExistObject(ctx context.Context, storeBox string, fileNam
 	return false, nil
 }
 
+// ListObjectsInfo lists the blobs of a container whose name starts with prefix.
+func (a *AzBlobClient) ListObjectsInfo(ctx context.Context, storeBox string, prefix string) ([]ObjectInfo, error) {
+	return collectObjects(a.Objects(ctx, storeBox, ListOptions{Prefix: prefix}))
+}
+
+// Objects iterates the blobs of a container, one page of List Blobs at a time, see ObjectIterator.
+func (a *AzBlobClient) Objects(ctx context.Context, storeBox string, opts ListOptions) iter.Seq2[ObjectInfo, error] {
+	storeBox = a.properties.PhysicalBox(storeBox)
+	listOptions := &azblob.ListBlobsFlatOptions{}
+	if opts.Prefix != "" {
+		listOptions.Prefix = &opts.Prefix
+	}
+	if opts.PageSize > 0 {
+		pageSize := int32(min(opts.PageSize, math.MaxInt32))
+		listOptions.MaxResults = &pageSize
+	}
+
+	return func(yield func(ObjectInfo, error) bool) {
+		pager := a.client.NewListBlobsFlatPager(storeBox, listOptions)
+		for pager.More() {
+			resp, err := pager.NextPage(ctx)
+			if err != nil {
+				yield(ObjectInfo{}, fmt.Errorf("failed to list blobs: %w", err))
+				return
+			}
+
+			for _, item := range resp.Segment.BlobItems {
+				if item.Name == nil {
+					continue
+				}
+				info := ObjectInfo{Key: *item.Name}
+				if item.Properties != nil {
+					if item.Properties.ContentLength != nil {
+						info.Size = *item.Properties.ContentLength
+					}
+					if item.Properties.LastModified != nil {
+						info.LastModified = *item.Properties.LastModified
+					}
+					if item.Properties.ETag != nil {
+						info.ETag = string(*item.Properties.ETag)
+					}
+				}
+				if !yield(info, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
 func (a *AzBlobClient) ListObjects(ctx context.Context, storeBox string) ([]string, error) {
+	storeBox = a.properties.PhysicalBox(storeBox)
 	pager := a.client.NewListBlobsFlatPager(storeBox, &azblob.ListBlobsFlatOptions{
 		Include: azblob.ListBlobsInclude{Snapshots: true, Versions: true},
 	})
@@ -180,3 +542,313 @@ func (a *AzBlobClient) ListObjects(ctx context.Context, storeBox string) ([]stri
 	}
 	return blobs, nil
 }
+
+// SetObjectTier moves a blob to the access tier of the given tier.
+func (a *AzBlobClient) SetObjectTier(ctx context.Context, storeBox string, fileName string, tier common.StorageTier) error {
+	if err := a.readOnly.writable("tier change"); err != nil {
+		return err
+	}
+	storeBox = a.properties.PhysicalBox(storeBox)
+	accessTier, ok := azAccessTiers[tier]
+	if !ok {
+		return fmt.Errorf("unknown storage tier %v", tier)
+	}
+
+	_, err := a.blobClient(storeBox, fileName).SetTier(ctx, accessTier, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set access tier: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreObject initiates the rehydration of an archived blob to the hot tier.
+// Rehydrated blobs stay in the hot tier, so days is only validated.
+func (a *AzBlobClient) RestoreObject(ctx context.Context, storeBox string, fileName string, days int) error {
+	storeBox = a.properties.PhysicalBox(storeBox)
+	if days <= 0 {
+		return fmt.Errorf("restore days must be positive, got %d", days)
+	}
+
+	priority := blob.RehydratePriorityStandard
+	_, err := a.blobClient(storeBox, fileName).SetTier(ctx, blob.AccessTierHot, &blob.SetTierOptions{
+		RehydratePriority: &priority,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rehydrate blob: %w", err)
+	}
+
+	return nil
+}
+
+// StatObject returns the properties of a blob, including its access tier and the state
+// of its rehydration.
+func (a *AzBlobClient) StatObject(ctx context.Context, storeBox string, fileName string) (ObjectStat, error) {
+	storeBox = a.properties.PhysicalBox(storeBox)
+	props, err := a.blobClient(storeBox, fileName).GetProperties(ctx, nil)
+	if err != nil {
+		return ObjectStat{}, fmt.Errorf("failed to get blob properties: %w", notFound(err))
+	}
+
+	stat := ObjectStat{ObjectInfo: ObjectInfo{Key: fileName}}
+	if props.ContentLength != nil {
+		stat.Size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		stat.LastModified = *props.LastModified
+	}
+	if props.ETag != nil {
+		stat.ETag = string(*props.ETag)
+	}
+	if props.ContentType != nil {
+		stat.ContentType = *props.ContentType
+	}
+
+	accessTier := string(blob.AccessTierHot)
+	if props.AccessTier != nil {
+		accessTier = *props.AccessTier
+	}
+	stat.TierStatus = TierStatus{
+		Tier:         tierOfAccessTier(blob.AccessTier(accessTier)),
+		StorageClass: accessTier,
+		Restoring:    props.ArchiveStatus != nil && strings.HasPrefix(*props.ArchiveStatus, "rehydrate-pending"),
+	}
+
+	if props.ImmutabilityPolicyMode != nil {
+		stat.Lock.Retention = Retention{Mode: retentionModeOfPolicy(*props.ImmutabilityPolicyMode)}
+		if stat.Lock.Retention.Mode != common.NO_RETENTION && props.ImmutabilityPolicyExpiresOn != nil {
+			stat.Lock.Retention.Until = *props.ImmutabilityPolicyExpiresOn
+		}
+	}
+	stat.Lock.LegalHold = props.LegalHold != nil && *props.LegalHold
+
+	return stat, nil
+}
+
+// SetObjectLegalHold places or removes the legal hold of a blob.
+// The container must have version-level immutability support enabled.
+func (a *AzBlobClient) SetObjectLegalHold(ctx context.Context, storeBox string, fileName string, on bool) error {
+	if err := a.readOnly.writable("legal hold change"); err != nil {
+		return err
+	}
+	storeBox = a.properties.PhysicalBox(storeBox)
+	if err := a.checkImmutability(ctx, storeBox); err != nil {
+		return err
+	}
+
+	_, err := a.blobClient(storeBox, fileName).SetLegalHold(ctx, on, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set the legal hold: %w", err)
+	}
+
+	return nil
+}
+
+// checkImmutability returns ErrObjectLockUnsupported when the container does not have
+// version-level immutability support, which blob immutability policies and legal holds require.
+func (a *AzBlobClient) checkImmutability(ctx context.Context, storeBox string) error {
+	props, err := a.client.ServiceClient().NewContainerClient(storeBox).GetProperties(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get container properties: %w", err)
+	}
+	if props.IsImmutableStorageWithVersioningEnabled == nil || !*props.IsImmutableStorageWithVersioningEnabled {
+		return fmt.Errorf("%w: container %s has no version-level immutability support", ErrObjectLockUnsupported, storeBox)
+	}
+	return nil
+}
+
+// azImmutabilityPolicies maps the retention modes to the modes of the Azure immutability policies:
+// unlocked policies can be shortened or removed, locked ones only extended.
+var azImmutabilityPolicies = map[common.RetentionMode]blob.ImmutabilityPolicySetting{
+	common.GOVERNANCE_RETENTION: blob.ImmutabilityPolicySettingUnlocked,
+	common.COMPLIANCE_RETENTION: blob.ImmutabilityPolicySettingLocked,
+}
+
+// retentionModeOfPolicy returns the retention mode of an Azure immutability policy mode.
+func retentionModeOfPolicy(mode blob.ImmutabilityPolicyMode) common.RetentionMode {
+	switch mode {
+	case blob.ImmutabilityPolicyModeUnlocked:
+		return common.GOVERNANCE_RETENTION
+	case blob.ImmutabilityPolicyModeLocked:
+		return common.COMPLIANCE_RETENTION
+	default:
+		return common.NO_RETENTION
+	}
+}
+
+// azMD5UploadLimit is the size up to which the blobs written with a checksum are uploaded in a
+// single request carrying their MD5 digest: Azure has no additional checksums, and cannot verify
+// the digest of a whole blob uploaded in blocks.
+const azMD5UploadLimit = defaultUploadPartSize
+
+// uploadWithMD5 uploads the blob read from r in a single request carrying its MD5 digest when it
+// holds at most azMD5UploadLimit bytes: Azure verifies the digest, failing with ErrChecksumMismatch,
+// and stores it as the Content-MD5 of the blob. Larger blobs are not uploaded, and the returned
+// reader yields the whole payload, to be uploaded without verification, with a nil result.
+func (a *AzBlobClient) uploadWithMD5(ctx context.Context, storeBox string, fileName string, r io.Reader, opts *azblob.UploadStreamOptions) (io.Reader, *PutResult, error) {
+	head, err := io.ReadAll(io.LimitReader(r, azMD5UploadLimit+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(head) > azMD5UploadLimit {
+		return io.MultiReader(bytes.NewReader(head), r), nil, nil
+	}
+
+	sum := md5.Sum(head)
+	headers := blob.HTTPHeaders{}
+	if opts.HTTPHeaders != nil {
+		headers = *opts.HTTPHeaders
+	}
+	headers.BlobContentMD5 = sum[:]
+
+	blockBlob := a.client.ServiceClient().NewContainerClient(storeBox).NewBlockBlobClient(fileName)
+	resp, err := blockBlob.Upload(ctx, streaming.NopCloser(bytes.NewReader(head)), &blockblob.UploadOptions{
+		HTTPHeaders:             &headers,
+		Metadata:                opts.Metadata,
+		AccessConditions:        opts.AccessConditions,
+		TransactionalValidation: blob.TransferValidationTypeMD5(sum[:]),
+	})
+	if bloberror.HasCode(err, bloberror.MD5Mismatch) {
+		err = fmt.Errorf("%w: %w", ErrChecksumMismatch, err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, azPutResult(resp.ETag, resp.VersionID), nil
+}
+
+// azPutResult returns the result of an upload from the ETag and version reported by Azure.
+func azPutResult(etag *azcore.ETag, versionID *string) *PutResult {
+	result := &PutResult{}
+	if etag != nil {
+		result.ETag = string(*etag)
+	}
+	if versionID != nil {
+		result.VersionID = *versionID
+	}
+	return result
+}
+
+// azPreconditionError returns err wrapping ErrPreconditionFailed when the upload was rejected
+// by its If-Match condition.
+func azPreconditionError(ifMatch string, err error) error {
+	if ifMatch != "" && bloberror.HasCode(err, bloberror.ConditionNotMet, bloberror.BlobNotFound) {
+		return fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+	}
+	return err
+}
+
+// blobClient returns the client of a single blob.
+func (a *AzBlobClient) blobClient(storeBox string, fileName string) *blob.Client {
+	return a.client.ServiceClient().NewContainerClient(storeBox).NewBlobClient(fileName)
+}
+
+// azAccessTiers maps the storage tiers to Azure access tiers.
+var azAccessTiers = map[common.StorageTier]blob.AccessTier{
+	common.HOT_TIER:     blob.AccessTierHot,
+	common.COOL_TIER:    blob.AccessTierCool,
+	common.COLD_TIER:    blob.AccessTierCold,
+	common.ARCHIVE_TIER: blob.AccessTierArchive,
+}
+
+// tierOfAccessTier returns the tier of an Azure access tier. Premium tiers are hot.
+func tierOfAccessTier(accessTier blob.AccessTier) common.StorageTier {
+	switch accessTier {
+	case blob.AccessTierCool:
+		return common.COOL_TIER
+	case blob.AccessTierCold:
+		return common.COLD_TIER
+	case blob.AccessTierArchive:
+		return common.ARCHIVE_TIER
+	default:
+		return common.HOT_TIER
+	}
+}
+
+// SetBoxLifecycle is not supported: Azure lifecycle management policies are scoped to the
+// storage account and are managed through the Azure Resource Manager, not per container.
+func (a *AzBlobClient) SetBoxLifecycle(ctx context.Context, storeBox string, rules []LifecycleRule) error {
+	if err := a.readOnly.writable("lifecycle change"); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: azure lifecycle management policies are account-scoped", ErrLifecycleNotSupported)
+}
+
+// GetBoxLifecycle is not supported, see SetBoxLifecycle.
+func (a *AzBlobClient) GetBoxLifecycle(ctx context.Context, storeBox string) ([]LifecycleRule, error) {
+	return nil, fmt.Errorf("%w: azure lifecycle management policies are account-scoped", ErrLifecycleNotSupported)
+}
+
+// SetBoxPublicRead grants or revokes anonymous read access to the blobs of a container,
+// through the blob public access level. The stored access policies of the container are kept.
+// Public access disabled at account level still prevents anonymous reads.
+func (a *AzBlobClient) SetBoxPublicRead(ctx context.Context, storeBox string, public bool) error {
+	if err := a.readOnly.writable("access change"); err != nil {
+		return err
+	}
+	storeBox = a.properties.PhysicalBox(storeBox)
+	containerClient := a.client.ServiceClient().NewContainerClient(storeBox)
+
+	current, err := containerClient.GetAccessPolicy(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get container access policy: %w", err)
+	}
+
+	options := &container.SetAccessPolicyOptions{ContainerACL: current.SignedIdentifiers}
+	if public {
+		access := container.PublicAccessTypeBlob
+		options.Access = &access
+	}
+
+	if _, err := containerClient.SetAccessPolicy(ctx, options); err != nil {
+		return fmt.Errorf("failed to set container access policy: %w", err)
+	}
+
+	return nil
+}
+
+// GetBoxAccess returns the anonymous access granted by the public access level of a container.
+func (a *AzBlobClient) GetBoxAccess(ctx context.Context, storeBox string) (BoxAccess, error) {
+	storeBox = a.properties.PhysicalBox(storeBox)
+	policy, err := a.client.ServiceClient().NewContainerClient(storeBox).GetAccessPolicy(ctx, nil)
+	if err != nil {
+		return BoxAccess{}, fmt.Errorf("failed to get container access policy: %w", err)
+	}
+
+	var access BoxAccess
+	if policy.BlobPublicAccess != nil {
+		switch *policy.BlobPublicAccess {
+		case container.PublicAccessTypeBlob:
+			access.PublicRead = true
+		case container.PublicAccessTypeContainer:
+			access.PublicRead, access.PublicList = true, true
+		}
+	}
+
+	return access, nil
+}
+
+// objectMetadata returns the user metadata of a blob, reporting false when it does not exist.
+func (a *AzBlobClient) objectMetadata(ctx context.Context, storeBox string, fileName string) (map[string]string, bool, error) {
+	props, err := a.blobClient(storeBox, fileName).GetProperties(ctx, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	return blobMetadata(props.Metadata), true, nil
+}
+
+// blobMetadata returns the metadata of a blob, as returned by the Azure SDK, without the nil values.
+func blobMetadata(metadata map[string]*string) map[string]string {
+	values := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if v != nil {
+			values[k] = *v
+		}
+	}
+	return values
+}
@@ -0,0 +1,111 @@
+package filestorage
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	common "github.com/tizianocitro/m2cs/pkg"
+)
+
+// ErrChecksumMismatch is returned when the bytes stored by a provider do not match the checksum
+// computed while uploading them, whether the provider rejected the upload or reported a different
+// checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// payloadChecksum is the checksum of the bytes of an upload, as read by the provider SDK.
+type payloadChecksum struct {
+	algorithm common.ChecksumAlgorithm
+	hash      hash.Hash
+}
+
+// newChecksumHash returns the hash of the given algorithm.
+func newChecksumHash(algorithm common.ChecksumAlgorithm) (hash.Hash, error) {
+	switch algorithm {
+	case common.CRC32C_CHECKSUM:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	case common.SHA1_CHECKSUM:
+		return sha1.New(), nil
+	case common.SHA256_CHECKSUM:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown checksum algorithm %v", algorithm)
+	}
+}
+
+// checksumPayload returns a reader of r hashing the bytes read with the given algorithm.
+// Seekable readers are hashed upfront and rewound, so that the SDKs can still seek them,
+// e.g. to retry a request; the others are hashed as the SDK reads them.
+func checksumPayload(r io.Reader, algorithm common.ChecksumAlgorithm) (io.Reader, *payloadChecksum, error) {
+	h, err := newChecksumHash(algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := &payloadChecksum{algorithm: algorithm, hash: h}
+
+	seeker, ok := r.(io.ReadSeeker)
+	if !ok {
+		return io.TeeReader(r, h), sum, nil
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to checksum the payload: %w", err)
+	}
+	if _, err := io.Copy(h, seeker); err != nil {
+		return nil, nil, fmt.Errorf("failed to checksum the payload: %w", err)
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("failed to checksum the payload: %w", err)
+	}
+	return r, sum, nil
+}
+
+// String returns the checksum of the bytes hashed so far, base64-encoded like the providers do.
+func (c *payloadChecksum) String() string {
+	return base64.StdEncoding.EncodeToString(c.hash.Sum(nil))
+}
+
+// verify compares the checksum reported by the provider with the computed one. Empty checksums,
+// not returned by the provider, and composite checksums of multipart uploads, holding a "-",
+// cannot be compared and are accepted.
+func (c *payloadChecksum) verify(reported string) error {
+	if reported == "" || strings.Contains(reported, "-") {
+		return nil
+	}
+	if computed := c.String(); reported != computed {
+		return fmt.Errorf("%w: %v is %s, computed %s", ErrChecksumMismatch, c.algorithm, reported, computed)
+	}
+	return nil
+}
+
+// checksumErrorCodes are the error codes of the S3 API rejecting an upload whose checksum
+// does not match its bytes.
+var checksumErrorCodes = map[string]bool{
+	"BadDigest":                   true,
+	"XAmzContentChecksumMismatch": true,
+}
+
+// checksumsOf returns the non-empty checksums among the given ones, nil when there is none.
+func checksumsOf(crc32c, sha1, sha256 string) map[common.ChecksumAlgorithm]string {
+	var checksums map[common.ChecksumAlgorithm]string
+	for algorithm, value := range map[common.ChecksumAlgorithm]string{
+		common.CRC32C_CHECKSUM: crc32c,
+		common.SHA1_CHECKSUM:   sha1,
+		common.SHA256_CHECKSUM: sha256,
+	} {
+		if value == "" {
+			continue
+		}
+		if checksums == nil {
+			checksums = make(map[common.ChecksumAlgorithm]string, 1)
+		}
+		checksums[algorithm] = value
+	}
+	return checksums
+}
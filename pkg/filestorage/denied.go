@@ -0,0 +1,40 @@
+package filestorage
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrAccessDenied marks an operation refused by the permissions of the credentials, e.g. for
+// storages wrapping other services; see IsAccessDenied.
+var ErrAccessDenied = errors.New("access denied")
+
+// IsAccessDenied reports whether err is the refusal of an operation by the permissions of the
+// credentials: the HTTP 403 answers of the providers, and errors wrapping ErrAccessDenied or
+// ErrReadOnlyStorage.
+func IsAccessDenied(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrAccessDenied) || errors.Is(err, ErrReadOnlyStorage) {
+		return true
+	}
+
+	var minioErr minio.ErrorResponse
+	if errors.As(err, &minioErr) {
+		return minioErr.StatusCode == http.StatusForbidden || minioErr.Code == "AccessDenied"
+	}
+	var s3Err *awshttp.ResponseError
+	if errors.As(err, &s3Err) {
+		return s3Err.HTTPStatusCode() == http.StatusForbidden
+	}
+	var azErr *azcore.ResponseError
+	if errors.As(err, &azErr) {
+		return azErr.StatusCode == http.StatusForbidden
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package filestorage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	common "github.com/tizianocitro/m2cs/pkg"
+)
+
+// skipEmptyTransforms returns properties without transforms when the payload of reader, of the
+// given logical size or -1 when unknown, is empty, along with the logical size, 0 once the
+// payload is found empty. Compressing or encrypting an empty payload yields a non-empty
+// object, whose emptiness would no longer show in its stored size, and which MinIO rejects for
+// the directory markers, i.e. the keys ending with "/"; empty objects are stored empty instead,
+// their transforms being recorded, see TransformMetadata. When the size is unknown, the first
+// byte of reader is read, and the returned reader reads the whole payload.
+func skipEmptyTransforms(properties common.ConnectionProperties, reader io.Reader, logical int64) (common.ConnectionProperties, io.Reader, int64, error) {
+	if !properties.Transformed() || logical > 0 {
+		return properties, reader, logical, nil
+	}
+
+	if logical < 0 {
+		var head [1]byte
+		n, err := io.ReadFull(reader, head[:])
+		if n > 0 {
+			return properties, io.MultiReader(bytes.NewReader(head[:n]), reader), logical, nil
+		}
+		if err != io.EOF {
+			return properties, nil, logical, fmt.Errorf("failed to read the payload: %w", err)
+		}
+	}
+
+	properties.SaveCompress = common.NO_COMPRESSION
+	properties.SaveEncrypt = common.NO_ENCRYPTION
+	return properties, reader, 0, nil
+}
+
+// isDirectoryMarker reports whether key is a directory marker, i.e. an object, usually empty,
+// whose key ends with "/", as created by the consoles of the providers for the folders.
+func isDirectoryMarker(key string) bool {
+	return strings.HasSuffix(key, "/")
+}
@@ -2,11 +2,29 @@ package filestorage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"time"
 
 	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/transform"
 )
 
+// ErrRangeNotSupported is returned by ranged reads when the stored representation
+// of the object (compressed and/or encrypted) cannot be addressed by logical offset.
+var ErrRangeNotSupported = errors.New("ranged read not supported with the configured transforms")
+
+// ErrKeyUnavailable is returned by the writes of objects saved encrypted on storages with only
+// ConnectionProperties.DecryptKeys, and by their reads on storages with EncryptOnly and no
+// DecryptKeys; see ConnectionProperties.CanDecrypt.
+var ErrKeyUnavailable = transform.ErrKeyUnavailable
+
+// FileStorage is the interface of the storages a FileClient replicates and balances across. The
+// other capabilities of the storages, e.g. RangeReader or ObjectLister, are optional interfaces
+// checked for at run time, so that FileStorage stays implementable by any backend.
 type FileStorage interface {
 	GetObject(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, error)
 	PutObject(ctx context.Context, storeBox string, fileName string, reader io.Reader) error
@@ -14,3 +32,449 @@ type FileStorage interface {
 	ExistObject(ctx context.Context, storeBox string, fileName string) (bool, error)
 	GetConnectionProperties() common.ConnectionProperties
 }
+
+// The storages of this package implement FileStorage.
+var (
+	_ FileStorage = (*MinioClient)(nil)
+	_ FileStorage = (*S3Client)(nil)
+	_ FileStorage = (*AzBlobClient)(nil)
+	_ FileStorage = (*MemoryClient)(nil)
+	_ FileStorage = (*MirroredStorage)(nil)
+)
+
+// RangeReader is implemented by storages able to serve a byte range of an object.
+// A length <= 0 reads from offset to the end of the object.
+type RangeReader interface {
+	GetObjectRange(ctx context.Context, storeBox string, fileName string, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// ObjectInfo describes a stored object as reported by a listing.
+// Size is the stored size, after compression and encryption.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+// ObjectLister is implemented by storages able to list the objects of a store box.
+type ObjectLister interface {
+	ListObjectsInfo(ctx context.Context, storeBox string, prefix string) ([]ObjectInfo, error)
+}
+
+// ErrTierNotSupported is returned by storages that cannot move objects across tiers.
+var ErrTierNotSupported = errors.New("storage tiers not supported")
+
+// TierStatus reports the tier of an object and the state of its rehydration.
+// StorageClass is the provider name of the tier, e.g. an S3 storage class or an Azure access tier.
+type TierStatus struct {
+	Tier          common.StorageTier
+	StorageClass  string
+	Restoring     bool      // A rehydration from the archive tier is in progress
+	RestoredUntil time.Time // Expiry of the temporary restored copy, when reported by the provider
+}
+
+// ObjectStat describes a single object, including its tier and its object lock state.
+type ObjectStat struct {
+	ObjectInfo
+	ContentType string
+	TierStatus  TierStatus
+	Lock        LockStatus
+	Checksums   map[common.ChecksumAlgorithm]string // Additional checksums of the stored bytes reported by the provider, base64-encoded
+}
+
+// ErrObjectLockUnsupported is returned by storages that cannot lock objects, or whose store box
+// does not have object lock enabled.
+var ErrObjectLockUnsupported = errors.New("object lock not supported")
+
+// Retention protects an object from being overwritten or deleted until Until.
+// The zero value sets no retention.
+type Retention struct {
+	Mode  common.RetentionMode
+	Until time.Time
+}
+
+// LockStatus reports the retention and the legal hold of an object.
+type LockStatus struct {
+	Retention Retention
+	LegalHold bool
+}
+
+// ObjectLocker is implemented by storages able to lock objects, i.e. S3 Object Lock and the
+// immutability policies of Azure Blob, which must be enabled on the store box.
+// The retention of an object is set when writing it, see PutOptions.Retention; a legal hold
+// protects the object, independently of its retention, until it is removed.
+type ObjectLocker interface {
+	SetObjectLegalHold(ctx context.Context, storeBox string, fileName string, on bool) error
+}
+
+// ErrInfoNotSupported is returned by storages that cannot return the attributes of an object along with its content.
+var ErrInfoNotSupported = errors.New("object attributes on read not supported")
+
+// InfoGetter is implemented by storages able to return the attributes of an object along
+// with its content, from the same response. The Size of the returned ObjectStat is the
+// logical size, i.e. the number of bytes read from the reader: when the object is stored
+// compressed or encrypted, it is the size recorded in the LogicalSizeMetadata metadata at
+// write time, or -1 when the object was written without it. TierStatus is not filled.
+type InfoGetter interface {
+	GetObjectWithInfo(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, ObjectStat, error)
+}
+
+// LogicalSizeMetadata is the metadata key recording the size of an object before compression
+// and encryption. It has no separator, as Azure Blob requires metadata keys to be identifiers.
+const LogicalSizeMetadata = "M2csLogicalSize"
+
+// TransformMetadata is the metadata key recording the compression and the encryption of the
+// objects written by a connection with TransformRules, or stored uncompressed as their compression
+// was not worth it, e.g. "NO_COMPRESSION,NO_ENCRYPTION", so that they are read with the pipeline
+// they were written with. The objects without it are read with the SaveCompress and SaveEncrypt
+// of the connection.
+const TransformMetadata = "M2csTransform"
+
+// TierManager is implemented by storages able to move objects across access tiers.
+// RestoreObject initiates the rehydration of an archived object; days is the lifetime of
+// the restored copy on providers keeping it temporarily.
+type TierManager interface {
+	ObjectStater
+	SetObjectTier(ctx context.Context, storeBox string, fileName string, tier common.StorageTier) error
+	RestoreObject(ctx context.Context, storeBox string, fileName string, days int) error
+}
+
+// ObjectStater is implemented by storages able to return the attributes of a single object.
+// The Size of the returned ObjectStat is the stored size, after compression and encryption; empty
+// objects are stored empty whatever the transforms, so their size is 0. The directory markers,
+// i.e. the keys ending with "/", are reported as ExistObject does: an implied directory, holding
+// objects without a marker, is not an object.
+type ObjectStater interface {
+	StatObject(ctx context.Context, storeBox string, fileName string) (ObjectStat, error)
+}
+
+// ErrLifecycleNotSupported is returned by storages that cannot manage the lifecycle rules of a store box.
+var ErrLifecycleNotSupported = errors.New("lifecycle rules not supported")
+
+// LifecycleRule expires or transitions the objects of a store box whose key starts with Prefix.
+// A zero number of days disables the corresponding action. When ID is empty, the rule is
+// identified by its position, as m2cs-rule-<index>.
+type LifecycleRule struct {
+	ID                  string
+	Prefix              string
+	ExpireAfterDays     int
+	TransitionAfterDays int
+	TargetTier          common.StorageTier
+}
+
+// LifecycleManager is implemented by storages able to manage the lifecycle rules of a store box.
+// SetBoxLifecycle replaces all the rules of the box; an empty slice removes them.
+type LifecycleManager interface {
+	SetBoxLifecycle(ctx context.Context, storeBox string, rules []LifecycleRule) error
+	GetBoxLifecycle(ctx context.Context, storeBox string) ([]LifecycleRule, error)
+}
+
+// ErrAccessNotSupported is returned by storages that cannot manage the anonymous access to a store box.
+var ErrAccessNotSupported = errors.New("public access management not supported")
+
+// BoxAccess describes the anonymous access granted on a store box.
+type BoxAccess struct {
+	PublicRead bool // Anonymous clients can read the objects
+	PublicList bool // Anonymous clients can list the objects
+}
+
+// AccessManager is implemented by storages able to grant anonymous read access to a store box.
+// The provider settings, such as bucket policies, are generated from the public flag.
+type AccessManager interface {
+	SetBoxPublicRead(ctx context.Context, storeBox string, public bool) error
+	GetBoxAccess(ctx context.Context, storeBox string) (BoxAccess, error)
+}
+
+// ErrUploadCleanupNotSupported is returned by storages without multipart uploads to clean up.
+var ErrUploadCleanupNotSupported = errors.New("incomplete uploads cleanup not supported")
+
+// IncompleteUpload is a multipart upload initiated and neither completed nor aborted,
+// whose parts are retained, and billed, by the provider.
+type IncompleteUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// UploadCleaner is implemented by storages able to list and abort the incomplete multipart
+// uploads of a store box, whether they were initiated by m2cs or by other tools.
+// AbortIncompleteUploads aborts the uploads initiated more than olderThan ago and returns
+// how many were aborted; it goes on after a failed abort and reports the failures together.
+type UploadCleaner interface {
+	ListIncompleteUploads(ctx context.Context, storeBox string) ([]IncompleteUpload, error)
+	AbortIncompleteUploads(ctx context.Context, storeBox string, olderThan time.Duration) (int, error)
+}
+
+// PrefixUploadCleaner is implemented by the UploadCleaner storages able to restrict the listing
+// and the aborts to the uploads whose key starts with prefix, e.g. to the namespace of a FileClient
+// scoped by WithKeyPrefix. An empty prefix selects all the uploads of the store box.
+type PrefixUploadCleaner interface {
+	UploadCleaner
+	ListIncompleteUploadsWithPrefix(ctx context.Context, storeBox string, prefix string) ([]IncompleteUpload, error)
+	AbortIncompleteUploadsWithPrefix(ctx context.Context, storeBox string, prefix string, olderThan time.Duration) (int, error)
+}
+
+// KeyRotator is implemented by storages able to replace their encryption key at runtime, e.g.
+// after a key leak. The objects written afterwards are encrypted with the new key, which is also
+// the one decrypting the objects read afterwards: the objects written with the previous key must
+// be rewritten, e.g. by reading them before the rotation and writing them back after it.
+// GetConnectionProperties reports the current key.
+type KeyRotator interface {
+	RotateEncryptKey(key string) error
+}
+
+// PutOptions holds the per-object settings of PutObjectWithOptions.
+type PutOptions struct {
+	ContentType       string                   // MIME type stored with the object
+	ContentEncoding   string                   // Content-Encoding stored with the object (set automatically with GZIP_CONTENT_ENCODING)
+	Metadata          map[string]string        // User metadata stored with the object
+	IdempotencyKey    string                   // Skip the write when the stored object was written with the same key, see IdempotencyKeyMetadata
+	Retention         Retention                // Retention of the written object, failing with ErrObjectLockUnsupported on storages without object lock
+	Size              int64                    // Size of the payload in bytes when > 0, so that the storages do not measure it; it must match the bytes read
+	ChecksumAlgorithm common.ChecksumAlgorithm // Additional checksum of the stored bytes verified by the provider, see ErrChecksumMismatch
+	IfMatch           string                   // Write only over a stored object with this ETag, failing with ErrPreconditionFailed otherwise
+}
+
+// OptionsPutter is implemented by storages accepting per-object put options.
+// With an IdempotencyKey, the metadata of the stored object is checked before writing, and the
+// write is skipped, successfully, when the object was written with the same key: retries of the
+// same logical write are applied at most once, across restarts as well. The check and the write
+// are not atomic, so that concurrent writes with the same key may both be applied.
+type OptionsPutter interface {
+	PutObjectWithOptions(ctx context.Context, storeBox string, fileName string, reader io.Reader, opts PutOptions) error
+}
+
+// ErrPreconditionFailed is returned by the writes with a PutOptions.IfMatch when the stored object
+// is missing or has another ETag; the object is not written.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// PutResult describes an object as written by a storage. ETag has the format reported by the
+// storage in ObjectStat.ETag; VersionID is empty on storages without versioning.
+type PutResult struct {
+	ETag      string
+	VersionID string
+}
+
+// ResultPutter is implemented by storages able to report the ETag and the version of the objects
+// they write. Writes skipped thanks to an IdempotencyKey report an empty PutResult.
+type ResultPutter interface {
+	PutObjectWithResult(ctx context.Context, storeBox string, fileName string, reader io.Reader, opts PutOptions) (PutResult, error)
+}
+
+// preconditionErrorCodes are the error codes of the S3 APIs rejecting a write with If-Match.
+var preconditionErrorCodes = map[string]bool{
+	"PreconditionFailed":         true,
+	"ConditionalRequestConflict": true,
+}
+
+// preconditionError reports whether a write with the given If-Match failed with code because of
+// the condition: conditional writes over missing objects fail as not found.
+func preconditionError(ifMatch string, code string) bool {
+	return ifMatch != "" && (preconditionErrorCodes[code] || code == "NoSuchKey")
+}
+
+// writePipeline applies the write transforms of the given properties, cached in pipelines, to
+// reader. Without transforms, reader is returned as is, so that the SDKs read the payload of the
+// caller directly.
+func writePipeline(properties common.ConnectionProperties, pipelines *transform.Cache, reader io.Reader) (io.Reader, io.Closer, error) {
+	if supportsRange(properties) {
+		return reader, nil, nil
+	}
+
+	pipe, err := pipelines.WriteWith(properties.SaveCompress, properties.SaveEncrypt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("build write pipeline: %w", err)
+	}
+	obj, closer, err := pipe.Apply(reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("apply write pipeline: %w", err)
+	}
+	return obj, closer, nil
+}
+
+// payloadSize returns the number of bytes to be read from reader: opts.Size when set,
+// else the size reported by reader, else -1.
+func payloadSize(reader io.Reader, opts PutOptions) int64 {
+	if opts.Size > 0 {
+		return opts.Size
+	}
+	return readerSize(reader)
+}
+
+// withTransformHeaders returns opts completed with the headers required by the
+// transforms of the given properties, those the object is written with, e.g. as returned
+// by ForObject, on a connection with the properties connection. When the object is
+// transformed and its logical size is known (>= 0), or is empty on a transforming connection,
+// the size is recorded in the LogicalSizeMetadata metadata. With TransformRules, or transforms
+// other than the ones of the connection, the transforms are recorded in the TransformMetadata
+// metadata. The metadata of opts is copied, never modified.
+func withTransformHeaders(opts PutOptions, connection, properties common.ConnectionProperties, logicalSize int64) PutOptions {
+	if enc := transform.ContentEncoding(properties); enc != "" {
+		opts.ContentEncoding = enc
+	}
+	recorded := len(connection.TransformRules) > 0 ||
+		properties.SaveCompress != connection.SaveCompress || properties.SaveEncrypt != connection.SaveEncrypt
+	sized := logicalSize >= 0 && (!supportsRange(properties) || logicalSize == 0 && recorded)
+	if !sized && !recorded {
+		return opts
+	}
+
+	metadata := make(map[string]string, len(opts.Metadata)+2)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	if sized {
+		metadata[LogicalSizeMetadata] = strconv.FormatInt(logicalSize, 10)
+	}
+	if recorded {
+		metadata[TransformMetadata] = properties.SaveCompress.String() + "," + properties.SaveEncrypt.String()
+	}
+	opts.Metadata = metadata
+	return opts
+}
+
+// objectProperties returns the properties an object with the given metadata was written with:
+// properties, with the transforms recorded in its TransformMetadata metadata, if any, and
+// without TransformRules.
+func objectProperties(properties common.ConnectionProperties, metadata map[string]string) (common.ConnectionProperties, error) {
+	properties.TransformRules = nil
+	v, ok := metadataValue(metadata, TransformMetadata)
+	if !ok {
+		return properties, nil
+	}
+
+	compress, encrypt, _ := strings.Cut(v, ",")
+	var err error
+	if properties.SaveCompress, err = common.ParseCompressionAlgorithm(compress); err != nil {
+		return properties, fmt.Errorf("invalid %s metadata: %w", TransformMetadata, err)
+	}
+	if properties.SaveEncrypt, err = common.ParseEncryptionAlgorithm(encrypt); err != nil {
+		return properties, fmt.Errorf("invalid %s metadata: %w", TransformMetadata, err)
+	}
+	return properties, nil
+}
+
+// readPipeline returns the read pipeline of an object with the given metadata, Content-Encoding
+// and stored size, -1 when unknown, see objectProperties. An object stored empty is read as is,
+// whatever the transforms, as no transform writes one: e.g. the directory markers created by
+// other tools, or the empty objects, see skipEmptyTransforms.
+func readPipeline(properties common.ConnectionProperties, pipelines *transform.Cache, metadata map[string]string, contentEncoding string, storedSize int64) (transform.ReadPipeline, error) {
+	if storedSize == 0 {
+		return pipelines.ReadWith(common.NO_COMPRESSION, common.NO_ENCRYPTION, "")
+	}
+	properties, err := objectProperties(properties, metadata)
+	if err != nil {
+		return transform.ReadPipeline{}, err
+	}
+	return pipelines.ReadWith(properties.SaveCompress, properties.SaveEncrypt, contentEncoding)
+}
+
+// validateRetention rejects a retention the providers cannot apply, before anything is written.
+func validateRetention(retention Retention) error {
+	switch retention.Mode {
+	case common.NO_RETENTION:
+		return nil
+	case common.GOVERNANCE_RETENTION, common.COMPLIANCE_RETENTION:
+		if retention.Until.IsZero() {
+			return fmt.Errorf("retention %v has no expiry", retention.Mode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown retention mode %v", retention.Mode)
+	}
+}
+
+// readerSize returns the number of bytes left in reader when it reports its length or
+// can seek, leaving its position unchanged, or -1.
+func readerSize(reader io.Reader) int64 {
+	switch r := reader.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case io.Seeker:
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return -1
+		}
+		if _, err := r.Seek(cur, io.SeekStart); err != nil {
+			return -1
+		}
+		return end - cur
+	}
+	return -1
+}
+
+// logicalSize returns the size of an object as read through the pipeline of the given
+// properties: the stored size when no transform is applied or the object is stored empty, else
+// the size recorded in the metadata, looked up case-insensitively as providers normalize the
+// keys, or -1.
+func logicalSize(properties common.ConnectionProperties, storedSize int64, metadata map[string]string) int64 {
+	if storedSize == 0 {
+		return 0
+	}
+	if object, err := objectProperties(properties, metadata); err == nil {
+		properties = object
+	}
+	if supportsRange(properties) {
+		return storedSize
+	}
+	if v, ok := metadataValue(metadata, LogicalSizeMetadata); ok {
+		if size, err := strconv.ParseInt(v, 10, 64); err == nil && size >= 0 {
+			return size
+		}
+	}
+	return -1
+}
+
+// sizeOf returns the size reported by a provider, or -1 when it is not reported.
+func sizeOf(size *int64) int64 {
+	if size == nil {
+		return -1
+	}
+	return *size
+}
+
+// ParallelOptions holds the settings of the transfers of an object in parts.
+type ParallelOptions struct {
+	PartSize    int64 // Size in bytes of the parts (default: 8 MB; uploads raise it to the provider limits)
+	Concurrency int   // Number of parts transferred at the same time (default: 4)
+}
+
+// ParallelUploader is implemented by storages able to upload an object in parts, concurrently.
+// On error, the incomplete upload is aborted so that the uploaded parts are not retained.
+type ParallelUploader interface {
+	UploadParallel(ctx context.Context, storeBox string, fileName string, r io.ReaderAt, size int64, opts ParallelOptions) error
+}
+
+// supportsRange reports whether objects written with the given properties can be
+// read by logical byte offset, which holds only when no transform is applied, see
+// ConnectionProperties.Transformed.
+func supportsRange(properties common.ConnectionProperties) bool {
+	return !properties.Transformed()
+}
+
+// validateLifecycleRules checks that every rule has an action and that transitions target
+// a tier colder than the hot one, and returns the rules with their IDs filled in.
+func validateLifecycleRules(rules []LifecycleRule) ([]LifecycleRule, error) {
+	validated := make([]LifecycleRule, len(rules))
+	for i, rule := range rules {
+		if rule.ID == "" {
+			rule.ID = fmt.Sprintf("m2cs-rule-%d", i)
+		}
+		if rule.ExpireAfterDays < 0 || rule.TransitionAfterDays < 0 {
+			return nil, fmt.Errorf("lifecycle rule %s: days must not be negative", rule.ID)
+		}
+		if rule.ExpireAfterDays == 0 && rule.TransitionAfterDays == 0 {
+			return nil, fmt.Errorf("lifecycle rule %s: no expiration nor transition", rule.ID)
+		}
+		if rule.TransitionAfterDays > 0 && rule.TargetTier == common.HOT_TIER {
+			return nil, fmt.Errorf("lifecycle rule %s: cannot transition to %v", rule.ID, rule.TargetTier)
+		}
+		validated[i] = rule
+	}
+	return validated, nil
+}
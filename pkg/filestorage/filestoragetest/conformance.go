@@ -0,0 +1,367 @@
+// Package filestoragetest provides a conformance suite for the implementations of
+// filestorage.FileStorage, so that every backend reports objects, overwrites and missing
+// objects alike.
+package filestoragetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// RunConformance runs the conformance suite against the storages returned by newStorage, called
+// once per subtest. Each subtest creates a fresh store box with the first method implemented by
+// the storage among MakeBucket, CreateBucket and CreateContainer, as the clients of this module
+// do, and fails otherwise. The boxes are not removed.
+//
+// The suite checks that:
+//   - written objects, empty ones and unicode keys included, are read back as written;
+//   - writing an object again overwrites it, also when the writes are concurrent;
+//   - reading a missing object fails with an error wrapping filestorage.ErrObjectNotFound, as do
+//     GetObjectWithInfo and StatObject on storages implementing InfoGetter and ObjectStater;
+//   - ExistObject reports missing objects without an error;
+//   - removing an object makes it missing, while removing a missing object either succeeds, as
+//     on S3, or fails with filestorage.ErrObjectNotFound;
+//   - writing a nil reader fails without creating the object;
+//   - ListObjectsInfo, on storages implementing ObjectLister, lists the keys with the prefix;
+//   - directory markers, i.e. empty objects whose key ends with "/", are read back empty, listed
+//     with the keys they prefix, and reported alike by ExistObject and StatObject.
+//
+// The suite also pins the method set of filestorage.FileStorage, so that a change of the
+// interface fails the suite of every implementation rather than going unnoticed.
+func RunConformance(t *testing.T, newStorage func(t *testing.T) filestorage.FileStorage) {
+	t.Run("MethodSet", testMethodSet)
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s filestorage.FileStorage, box string)
+	}{
+		{"PutGet", testPutGet},
+		{"EmptyObject", testEmptyObject},
+		{"DirectoryMarker", testDirectoryMarker},
+		{"UnicodeKeys", testUnicodeKeys},
+		{"Overwrite", testOverwrite},
+		{"NotFound", testNotFound},
+		{"Remove", testRemove},
+		{"NilReader", testNilReader},
+		{"List", testList},
+		{"ConcurrentWrites", testConcurrentWrites},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newStorage(t)
+			test.run(t, s, makeBox(t, s))
+		})
+	}
+}
+
+// fileStorageMethods is the method set of filestorage.FileStorage the suite checks.
+var fileStorageMethods = []string{"ExistObject", "GetConnectionProperties", "GetObject", "PutObject", "RemoveObject"}
+
+func testMethodSet(t *testing.T) {
+	iface := reflect.TypeOf((*filestorage.FileStorage)(nil)).Elem()
+	methods := make([]string, 0, iface.NumMethod())
+	for i := 0; i < iface.NumMethod(); i++ {
+		methods = append(methods, iface.Method(i).Name)
+	}
+	if !slices.Equal(methods, fileStorageMethods) {
+		t.Fatalf("FileStorage methods are %v, want %v", methods, fileStorageMethods)
+	}
+}
+
+// boxes numbers the store boxes created by the suite.
+var boxes atomic.Int64
+
+// makeBox creates a store box with a name unique to the run.
+func makeBox(t *testing.T, s filestorage.FileStorage) string {
+	t.Helper()
+
+	var suffix [4]byte
+	_, _ = rand.Read(suffix[:])
+	box := fmt.Sprintf("conformance-%d-%s", boxes.Add(1), hex.EncodeToString(suffix[:]))
+
+	ctx := context.Background()
+	var err error
+	switch maker := s.(type) {
+	case interface {
+		MakeBucket(ctx context.Context, name string) error
+	}:
+		err = maker.MakeBucket(ctx, box)
+	case interface {
+		CreateBucket(ctx context.Context, name string) error
+	}:
+		err = maker.CreateBucket(ctx, box)
+	case interface {
+		CreateContainer(ctx context.Context, name string) error
+	}:
+		err = maker.CreateContainer(ctx, box)
+	default:
+		t.Fatalf("%T cannot create store boxes: it implements none of MakeBucket, CreateBucket and CreateContainer", s)
+	}
+	if err != nil {
+		t.Fatalf("failed to create store box %s: %v", box, err)
+	}
+	return box
+}
+
+// put writes content as the object key, failing the test on error.
+func put(t *testing.T, s filestorage.FileStorage, box, key, content string) {
+	t.Helper()
+	if err := s.PutObject(context.Background(), box, key, strings.NewReader(content)); err != nil {
+		t.Fatalf("PutObject(%q): %v", key, err)
+	}
+}
+
+// get reads the object key, failing the test on error.
+func get(t *testing.T, s filestorage.FileStorage, box, key string) string {
+	t.Helper()
+	obj, err := s.GetObject(context.Background(), box, key)
+	if err != nil {
+		t.Fatalf("GetObject(%q): %v", key, err)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		t.Fatalf("GetObject(%q): read: %v", key, err)
+	}
+	return string(data)
+}
+
+// exists reports whether the object key exists, failing the test on error.
+func exists(t *testing.T, s filestorage.FileStorage, box, key string) bool {
+	t.Helper()
+	ok, err := s.ExistObject(context.Background(), box, key)
+	if err != nil {
+		t.Fatalf("ExistObject(%q): %v", key, err)
+	}
+	return ok
+}
+
+func testPutGet(t *testing.T, s filestorage.FileStorage, box string) {
+	content := strings.Repeat("conformance ", 1000)
+	put(t, s, box, "dir/object.txt", content)
+	if got := get(t, s, box, "dir/object.txt"); got != content {
+		t.Errorf("GetObject returned %d bytes, want the %d bytes written", len(got), len(content))
+	}
+	if !exists(t, s, box, "dir/object.txt") {
+		t.Errorf("ExistObject reported a written object as missing")
+	}
+}
+
+func testEmptyObject(t *testing.T, s filestorage.FileStorage, box string) {
+	put(t, s, box, "empty", "")
+	if got := get(t, s, box, "empty"); got != "" {
+		t.Errorf("GetObject of an empty object returned %q", got)
+	}
+	if !exists(t, s, box, "empty") {
+		t.Errorf("ExistObject reported an empty object as missing")
+	}
+}
+
+func testDirectoryMarker(t *testing.T, s filestorage.FileStorage, box string) {
+	ctx := context.Background()
+
+	put(t, s, box, "dir/", "")
+	put(t, s, box, "dir/child", "child")
+	put(t, s, box, "implied/child", "child")
+	if got := get(t, s, box, "dir/"); got != "" {
+		t.Errorf("GetObject of a directory marker returned %q", got)
+	}
+
+	stater, _ := s.(filestorage.ObjectStater)
+	for _, key := range []string{"dir/", "implied/"} {
+		ok := exists(t, s, box, key)
+		if key == "dir/" && !ok {
+			t.Errorf("ExistObject reported the directory marker %q as missing", key)
+		}
+		if stater == nil {
+			continue
+		}
+		stat, err := stater.StatObject(ctx, box, key)
+		switch {
+		case ok && err != nil:
+			t.Errorf("StatObject(%q) of an existing object: %v", key, err)
+		case ok && stat.Size != 0:
+			t.Errorf("StatObject(%q) reported a directory marker of %d bytes", key, stat.Size)
+		case !ok && !errors.Is(err, filestorage.ErrObjectNotFound):
+			t.Errorf("StatObject(%q) of a missing object: got error %v, want ErrObjectNotFound", key, err)
+		}
+	}
+
+	if lister, ok := s.(filestorage.ObjectLister); ok {
+		infos, err := lister.ListObjectsInfo(ctx, box, "dir/")
+		if err != nil {
+			t.Fatalf("ListObjectsInfo: %v", err)
+		}
+		var keys []string
+		for _, info := range infos {
+			keys = append(keys, info.Key)
+		}
+		sort.Strings(keys)
+		if want := []string{"dir/", "dir/child"}; strings.Join(keys, ",") != strings.Join(want, ",") {
+			t.Errorf("ListObjectsInfo returned %v, want %v", keys, want)
+		}
+	}
+
+	if err := s.RemoveObject(ctx, box, "dir/"); err != nil {
+		t.Fatalf("RemoveObject: %v", err)
+	}
+	if exists(t, s, box, "dir/") {
+		t.Errorf("ExistObject reported a removed directory marker as existing")
+	}
+	if !exists(t, s, box, "dir/child") {
+		t.Errorf("removing a directory marker removed the objects it prefixes")
+	}
+}
+
+func testUnicodeKeys(t *testing.T, s filestorage.FileStorage, box string) {
+	keys := []string{
+		"unicode/ünïcødé.txt",
+		"unicode/日本語/ファイル.txt",
+		"unicode/emoji 😀.txt",
+		"unicode/space and+plus=equals.txt",
+	}
+	for _, key := range keys {
+		put(t, s, box, key, "content of "+key)
+	}
+	for _, key := range keys {
+		if got := get(t, s, box, key); got != "content of "+key {
+			t.Errorf("GetObject(%q) returned %q", key, got)
+		}
+		if !exists(t, s, box, key) {
+			t.Errorf("ExistObject(%q) reported a written object as missing", key)
+		}
+	}
+}
+
+func testOverwrite(t *testing.T, s filestorage.FileStorage, box string) {
+	put(t, s, box, "object", "first version")
+	put(t, s, box, "object", "second")
+	if got := get(t, s, box, "object"); got != "second" {
+		t.Errorf("GetObject after an overwrite returned %q, want %q", got, "second")
+	}
+}
+
+func testNotFound(t *testing.T, s filestorage.FileStorage, box string) {
+	ctx := context.Background()
+
+	obj, err := s.GetObject(ctx, box, "missing")
+	if !errors.Is(err, filestorage.ErrObjectNotFound) {
+		t.Errorf("GetObject of a missing object: got error %v, want ErrObjectNotFound", err)
+	}
+	if obj != nil {
+		t.Errorf("GetObject of a missing object returned a reader")
+	}
+
+	if ig, ok := s.(filestorage.InfoGetter); ok {
+		if _, _, err := ig.GetObjectWithInfo(ctx, box, "missing"); !errors.Is(err, filestorage.ErrObjectNotFound) {
+			t.Errorf("GetObjectWithInfo of a missing object: got error %v, want ErrObjectNotFound", err)
+		}
+	}
+	if stater, ok := s.(filestorage.ObjectStater); ok {
+		if _, err := stater.StatObject(ctx, box, "missing"); !errors.Is(err, filestorage.ErrObjectNotFound) {
+			t.Errorf("StatObject of a missing object: got error %v, want ErrObjectNotFound", err)
+		}
+	}
+
+	if exists(t, s, box, "missing") {
+		t.Errorf("ExistObject reported a missing object as existing")
+	}
+}
+
+func testRemove(t *testing.T, s filestorage.FileStorage, box string) {
+	ctx := context.Background()
+
+	put(t, s, box, "object", "content")
+	if err := s.RemoveObject(ctx, box, "object"); err != nil {
+		t.Fatalf("RemoveObject: %v", err)
+	}
+	if exists(t, s, box, "object") {
+		t.Errorf("ExistObject reported a removed object as existing")
+	}
+	if _, err := s.GetObject(ctx, box, "object"); !errors.Is(err, filestorage.ErrObjectNotFound) {
+		t.Errorf("GetObject of a removed object: got error %v, want ErrObjectNotFound", err)
+	}
+
+	if err := s.RemoveObject(ctx, box, "missing"); err != nil && !errors.Is(err, filestorage.ErrObjectNotFound) {
+		t.Errorf("RemoveObject of a missing object: got error %v, want nil or ErrObjectNotFound", err)
+	}
+}
+
+func testNilReader(t *testing.T, s filestorage.FileStorage, box string) {
+	if err := s.PutObject(context.Background(), box, "nil", nil); err == nil {
+		t.Errorf("PutObject with a nil reader succeeded")
+	}
+	if exists(t, s, box, "nil") {
+		t.Errorf("PutObject with a nil reader created the object")
+	}
+}
+
+func testList(t *testing.T, s filestorage.FileStorage, box string) {
+	lister, ok := s.(filestorage.ObjectLister)
+	if !ok {
+		t.Skipf("%T does not implement ObjectLister", s)
+	}
+
+	for _, key := range []string{"list/a", "list/b/c", "other"} {
+		put(t, s, box, key, key)
+	}
+	infos, err := lister.ListObjectsInfo(context.Background(), box, "list/")
+	if err != nil {
+		t.Fatalf("ListObjectsInfo: %v", err)
+	}
+	var keys []string
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+	sort.Strings(keys)
+	if want := []string{"list/a", "list/b/c"}; strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("ListObjectsInfo returned %v, want %v", keys, want)
+	}
+}
+
+func testConcurrentWrites(t *testing.T, s filestorage.FileStorage, box string) {
+	const writers = 8
+
+	contents := make([]string, writers)
+	for i := range contents {
+		contents[i] = fmt.Sprintf("writer %d ", i) + string(bytes.Repeat([]byte{byte('a' + i)}, 4096))
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.PutObject(context.Background(), box, "contended", strings.NewReader(contents[i]))
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("concurrent PutObject %d: %v", i, err)
+		}
+	}
+	got := get(t, s, box, "contended")
+	for _, content := range contents {
+		if got == content {
+			return
+		}
+	}
+	t.Errorf("GetObject after concurrent writes returned %d bytes matching none of the writes", len(got))
+}
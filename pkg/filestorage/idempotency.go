@@ -0,0 +1,46 @@
+package filestorage
+
+import "strings"
+
+// IdempotencyKeyMetadata is the metadata key recording the idempotency key of the write of an
+// object, see PutOptions.IdempotencyKey. It has no separator, as Azure Blob requires metadata keys
+// to be identifiers.
+const IdempotencyKeyMetadata = "M2csIdempotencyKey"
+
+// checkIdempotencyKey returns opts with its idempotency key, if any, recorded in a copy of the metadata,
+// reporting true when the stored object, whose metadata is returned by stat, was written with the
+// same key: the write has already been applied and must be skipped. stat reports false when the
+// object does not exist.
+func checkIdempotencyKey(opts PutOptions, stat func() (map[string]string, bool, error)) (PutOptions, bool, error) {
+	if opts.IdempotencyKey == "" {
+		return opts, false, nil
+	}
+
+	metadata, found, err := stat()
+	if err != nil {
+		return opts, false, err
+	}
+	if found {
+		if key, ok := metadataValue(metadata, IdempotencyKeyMetadata); ok && key == opts.IdempotencyKey {
+			return opts, true, nil
+		}
+	}
+
+	withKey := make(map[string]string, len(opts.Metadata)+1)
+	for k, v := range opts.Metadata {
+		withKey[k] = v
+	}
+	withKey[IdempotencyKeyMetadata] = opts.IdempotencyKey
+	opts.Metadata = withKey
+	return opts, false, nil
+}
+
+// metadataValue looks up a metadata key case-insensitively, as providers normalize the keys.
+func metadataValue(metadata map[string]string, key string) (string, bool) {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,73 @@
+package filestorage
+
+import (
+	"context"
+	"fmt"
+	"iter"
+)
+
+// ListOptions selects the objects of a listing, see ObjectIterator.
+type ListOptions struct {
+	Prefix   string // Only the keys starting with Prefix are listed (default: all the keys)
+	PageSize int    // Objects requested per page from the provider (default: the provider default, 1000 on S3 and MinIO, 5000 on Azure)
+}
+
+// ObjectIterator is implemented by storages able to list the objects of a store box one page at a
+// time, e.g. with
+//
+//	for info, err := range s.Objects(ctx, "box", ListOptions{}) { ... }
+//
+// A page is requested from the provider only once the objects of the previous one have been
+// yielded, so that breaking out of the loop stops the paging. A failure is yielded once, with a
+// zero ObjectInfo, and ends the iteration.
+type ObjectIterator interface {
+	Objects(ctx context.Context, storeBox string, opts ListOptions) iter.Seq2[ObjectInfo, error]
+}
+
+// The storages of this package able to list implement ObjectIterator.
+var (
+	_ ObjectIterator = (*MinioClient)(nil)
+	_ ObjectIterator = (*S3Client)(nil)
+	_ ObjectIterator = (*AzBlobClient)(nil)
+	_ ObjectIterator = (*MemoryClient)(nil)
+	_ ObjectIterator = (*MirroredStorage)(nil)
+)
+
+// IterateObjects iterates the objects of a store box of s, see ObjectIterator. The storages
+// implementing only ObjectLister are listed at once, the iteration yielding their objects with
+// the prefix; the other storages yield a failure.
+func IterateObjects(ctx context.Context, s FileStorage, storeBox string, opts ListOptions) iter.Seq2[ObjectInfo, error] {
+	if iterator, ok := s.(ObjectIterator); ok {
+		return iterator.Objects(ctx, storeBox, opts)
+	}
+	return func(yield func(ObjectInfo, error) bool) {
+		lister, ok := s.(ObjectLister)
+		if !ok {
+			yield(ObjectInfo{}, fmt.Errorf("storage %q cannot list objects", s.GetConnectionProperties().Label))
+			return
+		}
+		infos, err := lister.ListObjectsInfo(ctx, storeBox, opts.Prefix)
+		if err != nil {
+			yield(ObjectInfo{}, err)
+			return
+		}
+		for _, info := range infos {
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
+
+// collectObjects returns the objects of an iteration, or its failure. It implements the
+// ListObjectsInfo of the storages implementing ObjectIterator.
+func collectObjects(objects iter.Seq2[ObjectInfo, error]) ([]ObjectInfo, error) {
+	var infos []ObjectInfo
+	for info, err := range objects {
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
@@ -0,0 +1,440 @@
+package filestorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/transform"
+)
+
+// ErrBoxNotFound is returned by MemoryClient when the store box does not exist.
+var ErrBoxNotFound = errors.New("store box not found")
+
+// ErrObjectNotFound is returned by the storages reading, stating or removing an object that
+// does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// MemoryClient is an in-memory storage, intended for tests and benchmarks that must not
+// depend on a real backend. Objects go through the same compression and encryption
+// pipelines as the other clients.
+// It implements the FileStorage interface.
+type MemoryClient struct {
+	mu         sync.RWMutex
+	boxes      map[string]map[string]*memoryObject
+	properties common.ConnectionProperties
+	pipelines  *transform.Cache // Pipelines built from properties, see RotateEncryptKey
+	readOnly   readOnlyFlag
+}
+
+// memoryObject is an object stored by MemoryClient, in its stored representation.
+type memoryObject struct {
+	data         []byte
+	options      PutOptions
+	lastModified time.Time
+	etag         string
+	checksums    map[common.ChecksumAlgorithm]string
+}
+
+// NewMemoryClient creates an empty MemoryClient with the given connection properties.
+func NewMemoryClient(properties common.ConnectionProperties) *MemoryClient {
+	m := &MemoryClient{
+		boxes:      make(map[string]map[string]*memoryObject),
+		properties: properties,
+		pipelines:  transform.NewCache(properties),
+	}
+	m.readOnly.on.Store(properties.ReadOnly)
+	return m
+}
+
+// MakeBucket creates a new store box in MemoryClient.
+func (m *MemoryClient) MakeBucket(ctx context.Context, bucketName string) error {
+	if err := m.readOnly.writable("store box creation"); err != nil {
+		return err
+	}
+	bucketName = m.properties.PhysicalBox(bucketName)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.boxes[bucketName]; ok {
+		return fmt.Errorf("store box %s already exists", bucketName)
+	}
+	m.boxes[bucketName] = make(map[string]*memoryObject)
+
+	return nil
+}
+
+// ListBuckets lists the store boxes of MemoryClient, sorted by name.
+func (m *MemoryClient) ListBuckets(ctx context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make([]string, 0, len(m.boxes))
+	for name := range m.boxes {
+		names = append(names, m.properties.LogicalBox(name))
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// RemoveBucket removes a store box, and all its objects, from MemoryClient.
+func (m *MemoryClient) RemoveBucket(ctx context.Context, bucketName string) error {
+	if err := m.readOnly.writable("store box removal"); err != nil {
+		return err
+	}
+	bucketName = m.properties.PhysicalBox(bucketName)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.boxes[bucketName]; !ok {
+		return fmt.Errorf("failed to remove bucket: %w", ErrBoxNotFound)
+	}
+	delete(m.boxes, bucketName)
+
+	return nil
+}
+
+// GetObject retrieves an object from the specified store box in MemoryClient.
+func (m *MemoryClient) GetObject(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	object, err := m.object(storeBox, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the object from memory client: %w", err)
+	}
+
+	pipe, err := readPipeline(m.properties, m.pipelines.Box(storeBox), object.options.Metadata, object.options.ContentEncoding, int64(len(object.data)))
+	if err != nil {
+		return nil, fmt.Errorf("build read pipeline: %w", err)
+	}
+
+	obj, err := pipe.Apply(io.NopCloser(bytes.NewReader(object.data)))
+	if err != nil {
+		return nil, fmt.Errorf("fail to transform reader: %w", err)
+	}
+
+	return obj, nil
+}
+
+// GetObjectWithInfo retrieves an object along with its attributes, see InfoGetter.
+func (m *MemoryClient) GetObjectWithInfo(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, ObjectStat, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	object, err := m.object(storeBox, fileName)
+	if err != nil {
+		return nil, ObjectStat{}, fmt.Errorf("failed to get the object from memory client: %w", err)
+	}
+
+	pipe, err := readPipeline(m.properties, m.pipelines.Box(storeBox), object.options.Metadata, object.options.ContentEncoding, int64(len(object.data)))
+	if err != nil {
+		return nil, ObjectStat{}, fmt.Errorf("build read pipeline: %w", err)
+	}
+
+	obj, err := pipe.Apply(io.NopCloser(bytes.NewReader(object.data)))
+	if err != nil {
+		return nil, ObjectStat{}, fmt.Errorf("fail to transform reader: %w", err)
+	}
+
+	return obj, ObjectStat{
+		ObjectInfo: ObjectInfo{
+			Key:          fileName,
+			Size:         logicalSize(m.properties, int64(len(object.data)), object.options.Metadata),
+			LastModified: object.lastModified,
+			ETag:         object.etag,
+		},
+		ContentType: object.options.ContentType,
+	}, nil
+}
+
+// GetObjectRange retrieves length bytes of an object starting at offset.
+// A length <= 0 reads until the end of the object.
+func (m *MemoryClient) GetObjectRange(ctx context.Context, storeBox string, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	if !supportsRange(m.properties) {
+		return nil, ErrRangeNotSupported
+	}
+
+	object, err := m.object(storeBox, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the object range from memory client: %w", err)
+	}
+
+	size := int64(len(object.data))
+	if offset < 0 || offset > size {
+		return nil, fmt.Errorf("invalid range: offset %d out of object size %d", offset, size)
+	}
+	end := size
+	if length > 0 && offset+length < size {
+		end = offset + length
+	}
+
+	return io.NopCloser(bytes.NewReader(object.data[offset:end])), nil
+}
+
+// PutObject uploads an object to the specified store box in MemoryClient.
+func (m *MemoryClient) PutObject(ctx context.Context, storeBox string, fileName string, reader io.Reader) error {
+	return m.PutObjectWithOptions(ctx, storeBox, fileName, reader, PutOptions{})
+}
+
+// PutObjectWithOptions uploads an object storing the given content headers and metadata.
+func (m *MemoryClient) PutObjectWithOptions(ctx context.Context, storeBox string, fileName string, reader io.Reader, opts PutOptions) error {
+	_, err := m.PutObjectWithResult(ctx, storeBox, fileName, reader, opts)
+	return err
+}
+
+// PutObjectWithResult uploads an object like PutObjectWithOptions, reporting its ETag.
+func (m *MemoryClient) PutObjectWithResult(ctx context.Context, storeBox string, fileName string, reader io.Reader, opts PutOptions) (PutResult, error) {
+	if err := m.readOnly.writable("put"); err != nil {
+		return PutResult{}, err
+	}
+	storeBox = m.properties.PhysicalBox(storeBox)
+	if reader == nil {
+		return PutResult{}, fmt.Errorf("reader is nil")
+	}
+	if opts.Retention.Mode != common.NO_RETENTION {
+		return PutResult{}, fmt.Errorf("failed to put the object into memory client: %w", ErrObjectLockUnsupported)
+	}
+
+	opts, applied, err := checkIdempotencyKey(opts, func() (map[string]string, bool, error) {
+		return m.objectMetadata(ctx, storeBox, fileName)
+	})
+	if err != nil {
+		return PutResult{}, fmt.Errorf("failed to check the idempotency key: %w", err)
+	}
+	if applied {
+		return PutResult{}, nil
+	}
+
+	properties, reader, logical, err := skipEmptyTransforms(m.properties.ForObject(fileName, opts.ContentType), reader, payloadSize(reader, opts))
+	if err != nil {
+		return PutResult{}, err
+	}
+	properties, reader, err = skipPoorCompression(properties, reader)
+	if err != nil {
+		return PutResult{}, err
+	}
+	obj, closer, err := writePipeline(properties, m.pipelines.Box(storeBox), reader)
+	if err != nil {
+		return PutResult{}, err
+	}
+
+	if closer != nil {
+		defer closer.Close()
+	}
+
+	// the stored size is the logical one only without transforms
+	size := int64(-1)
+	if supportsRange(properties) {
+		size = logical
+	}
+	data, err := readPayload(obj, size)
+	if err != nil {
+		return PutResult{}, fmt.Errorf("failed to read the object: %w", err)
+	}
+	if size >= 0 && opts.Size > 0 && int64(len(data)) != opts.Size {
+		return PutResult{}, fmt.Errorf("failed to read the object: read %d bytes, expected %d", len(data), opts.Size)
+	}
+
+	sum := md5.Sum(data)
+	object := &memoryObject{
+		data:         data,
+		options:      withTransformHeaders(opts, m.properties, properties, logical),
+		lastModified: time.Now().UTC(),
+		etag:         hex.EncodeToString(sum[:]),
+	}
+	if opts.ChecksumAlgorithm != common.NO_CHECKSUM {
+		_, checksum, err := checksumPayload(bytes.NewReader(data), opts.ChecksumAlgorithm)
+		if err != nil {
+			return PutResult{}, err
+		}
+		object.checksums = map[common.ChecksumAlgorithm]string{opts.ChecksumAlgorithm: checksum.String()}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	box, ok := m.boxes[storeBox]
+	if !ok {
+		return PutResult{}, fmt.Errorf("failed to put the object into memory client: %w", ErrBoxNotFound)
+	}
+	if opts.IfMatch != "" {
+		if stored, ok := box[fileName]; !ok || stored.etag != opts.IfMatch {
+			return PutResult{}, fmt.Errorf("failed to put the object into memory client: %w", ErrPreconditionFailed)
+		}
+	}
+	box[fileName] = object
+
+	return PutResult{ETag: object.etag}, nil
+}
+
+// RemoveObject removes an object from the specified store box in MemoryClient.
+func (m *MemoryClient) RemoveObject(ctx context.Context, storeBox string, fileName string) error {
+	if err := m.readOnly.writable("removal"); err != nil {
+		return err
+	}
+	storeBox = m.properties.PhysicalBox(storeBox)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	box, ok := m.boxes[storeBox]
+	if !ok {
+		return fmt.Errorf("failed to remove object from memory client: %w", ErrBoxNotFound)
+	}
+	if _, ok := box[fileName]; !ok {
+		return fmt.Errorf("failed to remove object from memory client: %w", ErrObjectNotFound)
+	}
+	delete(box, fileName)
+
+	return nil
+}
+
+// ListObjectsInfo lists the objects of a store box whose key starts with prefix, sorted by key.
+func (m *MemoryClient) ListObjectsInfo(ctx context.Context, storeBox string, prefix string) ([]ObjectInfo, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	box, ok := m.boxes[storeBox]
+	if !ok {
+		return nil, fmt.Errorf("failed to list objects in memory client: %w", ErrBoxNotFound)
+	}
+
+	var infos []ObjectInfo
+	for key, object := range box {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		infos = append(infos, ObjectInfo{
+			Key:          key,
+			Size:         int64(len(object.data)),
+			LastModified: object.lastModified,
+			ETag:         object.etag,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+
+	return infos, nil
+}
+
+// Objects iterates the objects of a store box, sorted by key, see ObjectIterator. The objects are
+// listed at once, as a single page whatever the page size, when the iteration starts.
+func (m *MemoryClient) Objects(ctx context.Context, storeBox string, opts ListOptions) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		infos, err := m.ListObjectsInfo(ctx, storeBox, opts.Prefix)
+		if err != nil {
+			yield(ObjectInfo{}, err)
+			return
+		}
+		for _, info := range infos {
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
+
+// StatObject returns the attributes of an object. MemoryClient has a single tier, the hot one.
+func (m *MemoryClient) StatObject(ctx context.Context, storeBox string, fileName string) (ObjectStat, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	object, err := m.object(storeBox, fileName)
+	if err != nil {
+		return ObjectStat{}, fmt.Errorf("failed to stat the object in memory client: %w", err)
+	}
+
+	return ObjectStat{
+		ObjectInfo: ObjectInfo{
+			Key:          fileName,
+			Size:         int64(len(object.data)),
+			LastModified: object.lastModified,
+			ETag:         object.etag,
+		},
+		ContentType: object.options.ContentType,
+		TierStatus:  TierStatus{Tier: common.HOT_TIER},
+		Checksums:   object.checksums,
+	}, nil
+}
+
+func (m *MemoryClient) GetConnectionProperties() common.ConnectionProperties {
+	properties := m.properties
+	properties.EncryptKey, properties.EncryptKeyBytes = m.pipelines.Key(), m.pipelines.KeyBytes()
+	properties.ReadOnly = m.readOnly.on.Load()
+	return properties
+}
+
+// SetReadOnly makes the storage read-only, or writable again, see ReadOnlySetter.
+func (m *MemoryClient) SetReadOnly(readOnly bool) {
+	m.readOnly.on.Store(readOnly)
+}
+
+// RotateEncryptKey replaces the encryption key, see KeyRotator.
+func (m *MemoryClient) RotateEncryptKey(key string) error {
+	if err := m.pipelines.SetKey(key); err != nil {
+		return fmt.Errorf("failed to rotate the encryption key: %w", err)
+	}
+	return nil
+}
+
+func (m *MemoryClient) ExistObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	_, err := m.object(storeBox, fileName)
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check object existence in memory client: %w", err)
+	}
+
+	return true, nil
+}
+
+// object returns a stored object. The stored data is never modified, so it can be
+// read after the lock is released.
+// objectMetadata returns the user metadata of an object, reporting false when it does not exist.
+func (m *MemoryClient) objectMetadata(_ context.Context, storeBox string, fileName string) (map[string]string, bool, error) {
+	object, err := m.object(storeBox, fileName)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return object.options.Metadata, true, nil
+}
+
+func (m *MemoryClient) object(storeBox string, fileName string) (*memoryObject, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	box, ok := m.boxes[storeBox]
+	if !ok {
+		return nil, ErrBoxNotFound
+	}
+	object, ok := box[fileName]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+
+	return object, nil
+}
+
+// readPayload reads r until EOF. When the size of the payload is known (>= 0), the
+// bytes are read into a buffer allocated once upfront.
+func readPayload(r io.Reader, size int64) ([]byte, error) {
+	if size < 0 {
+		return io.ReadAll(r)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size+bytes.MinRead))
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -3,11 +3,17 @@ package filestorage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"log"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
 	common "github.com/tizianocitro/m2cs/pkg"
 	"github.com/tizianocitro/m2cs/pkg/transform"
 )
@@ -17,6 +23,8 @@ import (
 type MinioClient struct {
 	client     *minio.Client
 	properties common.ConnectionProperties
+	pipelines  *transform.Cache // Pipelines built from properties, see RotateEncryptKey
+	readOnly   readOnlyFlag
 }
 
 // NewMinioClient creates a MinioClient, which is a cu stom client from the m2cs package.
@@ -27,16 +35,44 @@ func NewMinioClient(client *minio.Client, properties common.ConnectionProperties
 	if client == nil {
 		return nil, fmt.Errorf("failed to create MinIO client: client is nil")
 	}
+	if err := properties.ValidateBoxAliases(); err != nil {
+		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
+	}
 
-	_, err := client.ListBuckets(context.Background())
-	if err != nil {
+	if err := probeMinio(context.Background(), client, properties.PhysicalBox(properties.ProbeBox)); err != nil {
 		return nil, fmt.Errorf("failed to connect to MinIO: %w", err)
 	}
 
-	return &MinioClient{
+	m := &MinioClient{
 		client:     client,
 		properties: properties,
-	}, nil
+		pipelines:  transform.NewCache(properties),
+	}
+	m.readOnly.on.Store(properties.ReadOnly)
+	return m, nil
+}
+
+// probeMinio checks the connection by listing the buckets or, when probeBox is set, by
+// checking that the bucket exists. Access denied responses on probeBox are accepted, since
+// anonymous and scoped credentials may not be allowed to inspect the bucket.
+func probeMinio(ctx context.Context, client *minio.Client, probeBox string) error {
+	if probeBox == "" {
+		_, err := client.ListBuckets(ctx)
+		return err
+	}
+
+	exists, err := client.BucketExists(ctx, probeBox)
+	if err != nil {
+		if minio.ToErrorResponse(err).StatusCode == http.StatusForbidden {
+			return nil
+		}
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("store box %s not found", probeBox)
+	}
+
+	return nil
 }
 
 // GetClient returns the underlying MinIO client.
@@ -46,6 +82,10 @@ func (m *MinioClient) GetClient() *minio.Client {
 
 // MakeBucket creates a new bucket in MinioClient.
 func (m *MinioClient) MakeBucket(ctx context.Context, bucketName string) error {
+	if err := m.readOnly.writable("store box creation"); err != nil {
+		return err
+	}
+	bucketName = m.properties.PhysicalBox(bucketName)
 	if m.client == nil {
 		return fmt.Errorf("client is not initialized")
 	}
@@ -71,7 +111,7 @@ func (m *MinioClient) ListBuckets(ctx context.Context) ([]string, error) {
 
 	var bucketNames []string
 	for _, bucket := range buckets {
-		bucketNames = append(bucketNames, fmt.Sprintf("Name: %s, CreatedOn: %s", bucket.Name, bucket.CreationDate))
+		bucketNames = append(bucketNames, fmt.Sprintf("Name: %s, CreatedOn: %s", m.properties.LogicalBox(bucket.Name), bucket.CreationDate))
 	}
 
 	return bucketNames, nil
@@ -79,6 +119,10 @@ func (m *MinioClient) ListBuckets(ctx context.Context) ([]string, error) {
 
 // RemoveBucket removes a bucket from MinioClient.
 func (m *MinioClient) RemoveBucket(ctx context.Context, bucketName string) error {
+	if err := m.readOnly.writable("store box removal"); err != nil {
+		return err
+	}
+	bucketName = m.properties.PhysicalBox(bucketName)
 	if m.client == nil {
 		return fmt.Errorf("client is not initialized")
 	}
@@ -93,20 +137,28 @@ func (m *MinioClient) RemoveBucket(ctx context.Context, bucketName string) error
 
 // GetObject retrieves an object from the specified bucket and file name in MinioClient.
 func (m *MinioClient) GetObject(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
 	if _, err := m.client.StatObject(ctx, storeBox, fileName, minio.StatObjectOptions{}); err != nil {
-		return nil, fmt.Errorf("failed to get the object from MinIO client: %w", err)
+		return nil, fmt.Errorf("failed to get the object from MinIO client: %w", notFound(err))
 	}
 
-	pipe, err := transform.Factory{}.BuildRPipelineDecryptDecompress(m.properties, m.properties.EncryptKey)
+	object, err := m.client.GetObject(context.Background(), storeBox, fileName, minio.GetObjectOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("build read pipeline: %w", err)
+		return nil, fmt.Errorf("failed to get the object from MinIO client: %w", err)
 	}
 
-	object, err := m.client.GetObject(context.Background(), storeBox, fileName, minio.GetObjectOptions{})
+	info, err := object.Stat()
 	if err != nil {
+		_ = object.Close()
 		return nil, fmt.Errorf("failed to get the object from MinIO client: %w", err)
 	}
 
+	pipe, err := readPipeline(m.properties, m.pipelines.Box(storeBox), info.UserMetadata, info.Metadata.Get("Content-Encoding"), info.Size)
+	if err != nil {
+		_ = object.Close()
+		return nil, fmt.Errorf("build read pipeline: %w", err)
+	}
+
 	obj, err := pipe.Apply(object)
 	if err != nil {
 		return nil, fmt.Errorf("fail to transform reader: %w", err)
@@ -115,45 +167,312 @@ func (m *MinioClient) GetObject(ctx context.Context, storeBox string, fileName s
 	return obj, nil
 }
 
+// GetObjectWithInfo retrieves an object along with its attributes, see InfoGetter.
+// The attributes are taken from the response of the read.
+func (m *MinioClient) GetObjectWithInfo(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, ObjectStat, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	object, err := m.client.GetObject(ctx, storeBox, fileName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, ObjectStat{}, fmt.Errorf("failed to get the object from MinIO client: %w", err)
+	}
+
+	info, err := object.Stat()
+	if err != nil {
+		_ = object.Close()
+		return nil, ObjectStat{}, fmt.Errorf("failed to get the object from MinIO client: %w", notFound(err))
+	}
+
+	pipe, err := readPipeline(m.properties, m.pipelines.Box(storeBox), info.UserMetadata, info.Metadata.Get("Content-Encoding"), info.Size)
+	if err != nil {
+		_ = object.Close()
+		return nil, ObjectStat{}, fmt.Errorf("build read pipeline: %w", err)
+	}
+
+	obj, err := pipe.Apply(object)
+	if err != nil {
+		_ = object.Close()
+		return nil, ObjectStat{}, fmt.Errorf("fail to transform reader: %w", err)
+	}
+
+	return obj, ObjectStat{
+		ObjectInfo: ObjectInfo{
+			Key:          fileName,
+			Size:         logicalSize(m.properties, info.Size, info.UserMetadata),
+			LastModified: info.LastModified,
+			ETag:         info.ETag,
+		},
+		ContentType: info.ContentType,
+	}, nil
+}
+
+// GetObjectRange retrieves length bytes of an object starting at offset.
+// A length <= 0 reads until the end of the object.
+func (m *MinioClient) GetObjectRange(ctx context.Context, storeBox string, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	if !supportsRange(m.properties) {
+		return nil, ErrRangeNotSupported
+	}
+
+	// without a length, the object is read from offset to its end: bytes=offset-, or the whole
+	// object from offset 0, since SetRange(0, 0) asks for bytes=0-0, its first byte only
+	opts := minio.GetObjectOptions{}
+	if offset > 0 || length > 0 {
+		end := int64(0)
+		if length > 0 {
+			end = offset + length - 1
+		}
+		if err := opts.SetRange(offset, end); err != nil {
+			return nil, fmt.Errorf("invalid range: %w", err)
+		}
+	}
+
+	// a single ranged GET: the objects of Client.GetObject drop the range when stat before being read
+	core := minio.Core{Client: m.client}
+	object, _, _, err := core.GetObject(ctx, storeBox, fileName, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the object range from MinIO client: %w", notFound(err))
+	}
+
+	return object, nil
+}
+
 // PutObject uploads an object to the specified bucket and file name in MinioClient.
 func (m *MinioClient) PutObject(ctx context.Context, storeBox string, fileName string, reader io.Reader) error {
-	if reader == nil {
-		return fmt.Errorf("reader is nil")
+	return m.PutObjectWithOptions(ctx, storeBox, fileName, reader, PutOptions{})
+}
+
+// PutObjectWithOptions uploads an object storing the given content headers and metadata.
+func (m *MinioClient) PutObjectWithOptions(ctx context.Context, storeBox string, fileName string, reader io.Reader, opts PutOptions) error {
+	_, err := m.PutObjectWithResult(ctx, storeBox, fileName, reader, opts)
+	return err
+}
+
+// PutObjectWithResult uploads an object like PutObjectWithOptions, reporting its ETag and version.
+func (m *MinioClient) PutObjectWithResult(ctx context.Context, storeBox string, fileName string, reader io.Reader, opts PutOptions) (PutResult, error) {
+	if err := m.readOnly.writable("put"); err != nil {
+		return PutResult{}, err
 	}
+	return putCreatingBox(m.properties, reader, func() (PutResult, error) {
+		return m.putObject(ctx, storeBox, fileName, reader, opts)
+	}, func() error {
+		return m.createBox(ctx, m.properties.PhysicalBox(storeBox))
+	})
+}
 
-	var size int64
+// createBox creates the bucket of a put, see ConnectionProperties.AutoCreateBox, in the region of
+// the client. A bucket already existing is not an error.
+func (m *MinioClient) createBox(ctx context.Context, bucketName string) error {
+	err := m.client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{})
+	switch minio.ToErrorResponse(err).Code {
+	case "BucketAlreadyOwnedByYou", "BucketAlreadyExists":
+		return nil
+	}
+	return err
+}
+
+func (m *MinioClient) putObject(ctx context.Context, storeBox string, fileName string, reader io.Reader, opts PutOptions) (PutResult, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	if reader == nil {
+		return PutResult{}, fmt.Errorf("reader is nil")
+	}
+	if err := validateRetention(opts.Retention); err != nil {
+		return PutResult{}, err
+	}
 
-	pipe, err := transform.Factory{}.BuildWPipelineCompressEncrypt(m.properties, m.properties.EncryptKey)
+	opts, applied, err := checkIdempotencyKey(opts, func() (map[string]string, bool, error) {
+		return m.objectMetadata(ctx, storeBox, fileName)
+	})
 	if err != nil {
-		return fmt.Errorf("build write pipeline: %w", err)
+		return PutResult{}, fmt.Errorf("failed to check the idempotency key: %w", err)
+	}
+	if applied {
+		return PutResult{}, nil
 	}
 
-	obj, closer, err := pipe.Apply(reader)
+	properties, reader, logical, err := skipEmptyTransforms(m.properties.ForObject(fileName, opts.ContentType), reader, payloadSize(reader, opts))
+	if err != nil {
+		return PutResult{}, err
+	}
+	properties, reader, err = skipPoorCompression(properties, reader)
+	if err != nil {
+		return PutResult{}, err
+	}
+	obj, closer, err := writePipeline(properties, m.pipelines.Box(storeBox), reader)
 	if err != nil {
-		return fmt.Errorf("apply write pipeline: %w", err)
+		return PutResult{}, err
 	}
 
 	if closer != nil {
 		defer closer.Close()
 	}
 
-	obj, size, err = getSizeFromReader(obj)
+	// the size is measured unless given: minio-go buffers a whole part of the streams of unknown size
+	size := logical
+	if !supportsRange(properties) || opts.Size <= 0 {
+		if obj, size, err = getSizeFromReader(obj); err != nil {
+			return PutResult{}, err
+		}
+	}
+
+	opts = withTransformHeaders(opts, m.properties, properties, logical)
+	putOptions := minio.PutObjectOptions{
+		ContentType:     opts.ContentType,
+		ContentEncoding: opts.ContentEncoding,
+		UserMetadata:    opts.Metadata,
+	}
+	if mode, ok := s3RetentionModes[opts.Retention.Mode]; ok {
+		putOptions.Mode = minio.RetentionMode(mode)
+		putOptions.RetainUntilDate = opts.Retention.Until
+	}
+	if opts.IfMatch != "" {
+		putOptions.SetMatchETag(opts.IfMatch)
+	}
+
+	var sum *payloadChecksum
+	if opts.ChecksumAlgorithm != common.NO_CHECKSUM {
+		if obj, sum, err = checksumPayload(obj, opts.ChecksumAlgorithm); err != nil {
+			return PutResult{}, err
+		}
+		putOptions.Checksum = minioChecksumTypes[opts.ChecksumAlgorithm]
+	}
+
+	info, err := m.client.PutObject(ctx, storeBox, fileName, obj, size, putOptions)
+	if err != nil {
+		if opts.Retention.Mode != common.NO_RETENTION {
+			err = m.objectLockError(ctx, storeBox, err)
+		}
+		if checksumErrorCodes[minio.ToErrorResponse(err).Code] {
+			err = fmt.Errorf("%w: %w", ErrChecksumMismatch, err)
+		}
+		if preconditionError(opts.IfMatch, minio.ToErrorResponse(err).Code) {
+			err = fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+		}
+		return PutResult{}, fmt.Errorf("failed to put the object into minio bucket: %w", err)
+	}
+	if sum != nil {
+		reported := checksumsOf(info.ChecksumCRC32C, info.ChecksumSHA1, info.ChecksumSHA256)
+		if err := sum.verify(reported[opts.ChecksumAlgorithm]); err != nil {
+			return PutResult{}, fmt.Errorf("failed to put the object into minio bucket: %w", err)
+		}
+	}
+
+	return PutResult{ETag: info.ETag, VersionID: info.VersionID}, nil
+}
+
+// minioChecksumTypes maps the checksum algorithms to the minio-go checksum types, sent as
+// trailers: the client must be created with TrailingHeaders enabled.
+var minioChecksumTypes = map[common.ChecksumAlgorithm]minio.ChecksumType{
+	common.CRC32C_CHECKSUM: minio.ChecksumCRC32C,
+	common.SHA1_CHECKSUM:   minio.ChecksumSHA1,
+	common.SHA256_CHECKSUM: minio.ChecksumSHA256,
+}
+
+// UploadParallel uploads an object with a multipart upload whose parts are sent concurrently,
+// see ParallelUploader. Objects fitting in a single part, and objects saved compressed or
+// encrypted, whose stored size is only known once written, are uploaded with PutObject.
+func (m *MinioClient) UploadParallel(ctx context.Context, storeBox string, fileName string, r io.ReaderAt, size int64, opts ParallelOptions) error {
+	if err := m.readOnly.writable("upload"); err != nil {
+		return err
+	}
+	partSize, err := s3PartLimits.partSize(size, opts.PartSize)
+	if err != nil {
+		return err
+	}
+	if size <= partSize || !supportsRange(m.properties) {
+		return m.PutObject(ctx, storeBox, fileName, io.NewSectionReader(r, 0, size))
+	}
+	storeBox = m.properties.PhysicalBox(storeBox)
+
+	core := minio.Core{Client: m.client}
+	uploadID, err := core.NewMultipartUpload(ctx, storeBox, fileName, minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: %w", err)
+	}
 
-	_, err = m.client.PutObject(ctx, storeBox, fileName, obj, size, minio.PutObjectOptions{})
+	parts := make([]minio.CompletePart, (size+partSize-1)/partSize)
+	err = uploadParts(ctx, size, partSize, opts.Concurrency, func(ctx context.Context, number int, offset int64, length int64) error {
+		part, err := core.PutObjectPart(ctx, storeBox, fileName, uploadID, number, io.NewSectionReader(r, offset, length), length, minio.PutObjectPartOptions{})
+		if err != nil {
+			return err
+		}
+		parts[number-1] = minio.CompletePart{PartNumber: number, ETag: part.ETag}
+		return nil
+	})
+	if err == nil {
+		_, err = core.CompleteMultipartUpload(ctx, storeBox, fileName, uploadID, parts, minio.PutObjectOptions{})
+	}
 	if err != nil {
-		return fmt.Errorf("failed to put the object into minio bucket: %w", err)
+		// The upload is aborted even if ctx is done, so that its parts are not retained.
+		abortCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		if abortErr := core.AbortMultipartUpload(abortCtx, storeBox, fileName, uploadID); abortErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to abort multipart upload: %w", abortErr))
+		}
+		return fmt.Errorf("multipart upload of %s failed: %w", fileName, err)
 	}
 
 	return nil
 }
 
+// ListIncompleteUploads lists the incomplete multipart uploads of a bucket.
+func (m *MinioClient) ListIncompleteUploads(ctx context.Context, storeBox string) ([]IncompleteUpload, error) {
+	return m.ListIncompleteUploadsWithPrefix(ctx, storeBox, "")
+}
+
+// ListIncompleteUploadsWithPrefix lists the incomplete multipart uploads of a bucket whose key
+// starts with prefix, see PrefixUploadCleaner.
+func (m *MinioClient) ListIncompleteUploadsWithPrefix(ctx context.Context, storeBox string, prefix string) ([]IncompleteUpload, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	var uploads []IncompleteUpload
+	for upload := range m.client.ListIncompleteUploads(ctx, storeBox, prefix, true) {
+		if upload.Err != nil {
+			return nil, fmt.Errorf("failed to list incomplete uploads: %w", upload.Err)
+		}
+		uploads = append(uploads, IncompleteUpload{
+			Key:       upload.Key,
+			UploadID:  upload.UploadID,
+			Initiated: upload.Initiated,
+		})
+	}
+
+	return uploads, nil
+}
+
+// AbortIncompleteUploads aborts the multipart uploads of a bucket initiated more than
+// olderThan ago, see UploadCleaner.
+func (m *MinioClient) AbortIncompleteUploads(ctx context.Context, storeBox string, olderThan time.Duration) (int, error) {
+	return m.AbortIncompleteUploadsWithPrefix(ctx, storeBox, "", olderThan)
+}
+
+// AbortIncompleteUploadsWithPrefix aborts the multipart uploads of a bucket whose key starts
+// with prefix initiated more than olderThan ago, see PrefixUploadCleaner.
+func (m *MinioClient) AbortIncompleteUploadsWithPrefix(ctx context.Context, storeBox string, prefix string, olderThan time.Duration) (int, error) {
+	if err := m.readOnly.writable("upload abort"); err != nil {
+		return 0, err
+	}
+	uploads, err := m.ListIncompleteUploadsWithPrefix(ctx, storeBox, prefix)
+	if err != nil {
+		return 0, err
+	}
+	storeBox = m.properties.PhysicalBox(storeBox)
+
+	core := minio.Core{Client: m.client}
+	return abortUploads(uploads, olderThan, func(upload IncompleteUpload) error {
+		return core.AbortMultipartUpload(ctx, storeBox, upload.Key, upload.UploadID)
+	})
+}
+
 // RemoveObject removes an object from the specified bucket in MinioClient.
 func (m *MinioClient) RemoveObject(ctx context.Context, storeBox string, fileName string) error {
+	if err := m.readOnly.writable("removal"); err != nil {
+		return err
+	}
+	storeBox = m.properties.PhysicalBox(storeBox)
 	opts := minio.RemoveObjectOptions{}
 
 	_, err := m.client.StatObject(context.Background(), storeBox, fileName, minio.GetObjectOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to remove object from minio bucket: %w", err)
+		return fmt.Errorf("failed to remove object from minio bucket: %w", notFound(err))
 	}
 
 	err = m.client.RemoveObject(context.Background(), storeBox, fileName, opts)
@@ -164,11 +483,61 @@ func (m *MinioClient) RemoveObject(ctx context.Context, storeBox string, fileNam
 	return nil
 }
 
+// ListObjectsInfo lists the objects of a bucket whose key starts with prefix.
+func (m *MinioClient) ListObjectsInfo(ctx context.Context, storeBox string, prefix string) ([]ObjectInfo, error) {
+	return collectObjects(m.Objects(ctx, storeBox, ListOptions{Prefix: prefix}))
+}
+
+// Objects iterates the objects of a bucket, see ObjectIterator. The listing of minio-go runs in
+// its own goroutine, which is stopped when the iteration ends.
+func (m *MinioClient) Objects(ctx context.Context, storeBox string, opts ListOptions) iter.Seq2[ObjectInfo, error] {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	listOptions := minio.ListObjectsOptions{Prefix: opts.Prefix, Recursive: true, MaxKeys: max(opts.PageSize, 0)}
+
+	return func(yield func(ObjectInfo, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		for obj := range m.client.ListObjects(ctx, storeBox, listOptions) {
+			if obj.Err != nil {
+				yield(ObjectInfo{}, fmt.Errorf("failed to list objects in minio bucket: %w", obj.Err))
+				return
+			}
+			info := ObjectInfo{
+				Key:          obj.Key,
+				Size:         obj.Size,
+				LastModified: obj.LastModified,
+				ETag:         obj.ETag,
+			}
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
+
 func (m *MinioClient) GetConnectionProperties() common.ConnectionProperties {
-	return m.properties
+	properties := m.properties
+	properties.EncryptKey, properties.EncryptKeyBytes = m.pipelines.Key(), m.pipelines.KeyBytes()
+	properties.ReadOnly = m.readOnly.on.Load()
+	return properties
+}
+
+// SetReadOnly makes the storage read-only, or writable again, see ReadOnlySetter.
+func (m *MinioClient) SetReadOnly(readOnly bool) {
+	m.readOnly.on.Store(readOnly)
+}
+
+// RotateEncryptKey replaces the encryption key, see KeyRotator.
+func (m *MinioClient) RotateEncryptKey(key string) error {
+	if err := m.pipelines.SetKey(key); err != nil {
+		return fmt.Errorf("failed to rotate the encryption key: %w", err)
+	}
+	return nil
 }
 
 func (m *MinioClient) ExistObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
 	_, err := m.client.StatObject(ctx, storeBox, fileName, minio.StatObjectOptions{})
 	if err != nil {
 		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
@@ -176,10 +545,280 @@ func (m *MinioClient) ExistObject(ctx context.Context, storeBox string, fileName
 		}
 		return false, fmt.Errorf("failed to check object existence in minio: %w", err)
 	}
-	
+
 	return true, nil
 }
 
+// objectMetadata returns the user metadata of an object, reporting false when it does not exist.
+func (m *MinioClient) objectMetadata(ctx context.Context, storeBox string, fileName string) (map[string]string, bool, error) {
+	info, err := m.client.StatObject(ctx, storeBox, fileName, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return info.UserMetadata, true, nil
+}
+
+// SetObjectTier cannot move a single object in MinIO, whose tiers are driven by the ILM
+// transition rules of the bucket. It succeeds without changes when the bucket has such a
+// rule, and returns ErrTierNotSupported otherwise.
+func (m *MinioClient) SetObjectTier(ctx context.Context, storeBox string, fileName string, tier common.StorageTier) error {
+	if err := m.readOnly.writable("tier change"); err != nil {
+		return err
+	}
+	storeBox = m.properties.PhysicalBox(storeBox)
+	config, err := m.client.GetBucketLifecycle(ctx, storeBox)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration" {
+			return fmt.Errorf("%w: bucket %s has no ILM configuration", ErrTierNotSupported, storeBox)
+		}
+		return fmt.Errorf("failed to get minio bucket lifecycle: %w", err)
+	}
+
+	for _, rule := range config.Rules {
+		if rule.Status == "Enabled" && !rule.Transition.IsNull() {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: bucket %s has no ILM transition rule", ErrTierNotSupported, storeBox)
+}
+
+// RestoreObject initiates the restore of an object transitioned to a remote tier, whose
+// temporary copy is kept for the given number of days.
+func (m *MinioClient) RestoreObject(ctx context.Context, storeBox string, fileName string, days int) error {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	if days <= 0 {
+		return fmt.Errorf("restore days must be positive, got %d", days)
+	}
+
+	req := minio.RestoreRequest{}
+	req.SetDays(days)
+	if err := m.client.RestoreObject(ctx, storeBox, fileName, "", req); err != nil {
+		return fmt.Errorf("failed to restore object in minio: %w", err)
+	}
+
+	return nil
+}
+
+// StatObject returns the attributes of an object, including its storage class and the
+// state of its restore.
+func (m *MinioClient) StatObject(ctx context.Context, storeBox string, fileName string) (ObjectStat, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	info, err := m.client.StatObject(ctx, storeBox, fileName, minio.StatObjectOptions{Checksum: true})
+	if err != nil {
+		return ObjectStat{}, fmt.Errorf("failed to stat object in minio: %w", notFound(err))
+	}
+
+	class := info.StorageClass
+	if class == "" {
+		class = "STANDARD"
+	}
+
+	status := TierStatus{Tier: tierOfStorageClass(class), StorageClass: class}
+	if info.Restore != nil {
+		status.Restoring = info.Restore.OngoingRestore
+		status.RestoredUntil = info.Restore.ExpiryTime
+	}
+
+	return ObjectStat{
+		ObjectInfo: ObjectInfo{
+			Key:          fileName,
+			Size:         info.Size,
+			LastModified: info.LastModified,
+			ETag:         info.ETag,
+		},
+		ContentType: info.ContentType,
+		TierStatus:  status,
+		Lock:        lockStatusOf(info.Metadata),
+		Checksums:   checksumsOf(info.ChecksumCRC32C, info.ChecksumSHA1, info.ChecksumSHA256),
+	}, nil
+}
+
+// SetObjectLegalHold places or removes the legal hold of an object.
+// The bucket must have been created with object lock enabled.
+func (m *MinioClient) SetObjectLegalHold(ctx context.Context, storeBox string, fileName string, on bool) error {
+	if err := m.readOnly.writable("legal hold change"); err != nil {
+		return err
+	}
+	storeBox = m.properties.PhysicalBox(storeBox)
+	status := minio.LegalHoldDisabled
+	if on {
+		status = minio.LegalHoldEnabled
+	}
+
+	err := m.client.PutObjectLegalHold(ctx, storeBox, fileName, minio.PutObjectLegalHoldOptions{Status: &status})
+	if err != nil {
+		return fmt.Errorf("failed to set the legal hold in minio: %w", m.objectLockError(ctx, storeBox, err))
+	}
+
+	return nil
+}
+
+// objectLockError returns err wrapping ErrObjectLockUnsupported when the bucket does not have
+// object lock enabled, which the object lock failures do not report consistently.
+func (m *MinioClient) objectLockError(ctx context.Context, storeBox string, err error) error {
+	enabled, _, _, _, lookupErr := m.client.GetObjectLockConfig(ctx, storeBox)
+	if lookupErr != nil && minio.ToErrorResponse(lookupErr).Code != objectLockNotFoundCode {
+		return err
+	}
+	if enabled != "Enabled" {
+		return fmt.Errorf("%w: bucket %s has no object lock configuration", ErrObjectLockUnsupported, storeBox)
+	}
+	return err
+}
+
+// lockStatusOf returns the object lock state reported by the headers of an object.
+func lockStatusOf(header http.Header) LockStatus {
+	var status LockStatus
+	status.Retention.Mode = retentionModeOf(header.Get("X-Amz-Object-Lock-Mode"))
+	if status.Retention.Mode != common.NO_RETENTION {
+		status.Retention.Until, _ = time.Parse(time.RFC3339, header.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	}
+	status.LegalHold = strings.EqualFold(header.Get("X-Amz-Object-Lock-Legal-Hold"), string(minio.LegalHoldEnabled))
+	return status
+}
+
+// SetBoxLifecycle replaces the ILM configuration of a bucket with the given rules.
+// Transitions target the remote tier named after the S3 storage class of the tier, e.g.
+// GLACIER, which must be registered on the MinIO deployment.
+func (m *MinioClient) SetBoxLifecycle(ctx context.Context, storeBox string, rules []LifecycleRule) error {
+	if err := m.readOnly.writable("lifecycle change"); err != nil {
+		return err
+	}
+	storeBox = m.properties.PhysicalBox(storeBox)
+	rules, err := validateLifecycleRules(rules)
+	if err != nil {
+		return err
+	}
+
+	config := lifecycle.NewConfiguration()
+	for _, rule := range rules {
+		minioRule := lifecycle.Rule{
+			ID:         rule.ID,
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Prefix: rule.Prefix},
+		}
+		if rule.ExpireAfterDays > 0 {
+			minioRule.Expiration = lifecycle.Expiration{Days: lifecycle.ExpirationDays(rule.ExpireAfterDays)}
+		}
+		if rule.TransitionAfterDays > 0 {
+			minioRule.Transition = lifecycle.Transition{
+				Days:         lifecycle.ExpirationDays(rule.TransitionAfterDays),
+				StorageClass: string(s3StorageClasses[rule.TargetTier]),
+			}
+		}
+		config.Rules = append(config.Rules, minioRule)
+	}
+
+	if err := m.client.SetBucketLifecycle(ctx, storeBox, config); err != nil {
+		return fmt.Errorf("failed to set minio bucket lifecycle: %w", err)
+	}
+
+	return nil
+}
+
+// GetBoxLifecycle returns the ILM rules of a bucket. Rules using filters other than a
+// prefix are returned with their prefix only.
+func (m *MinioClient) GetBoxLifecycle(ctx context.Context, storeBox string) ([]LifecycleRule, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	config, err := m.client.GetBucketLifecycle(ctx, storeBox)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get minio bucket lifecycle: %w", err)
+	}
+
+	rules := make([]LifecycleRule, 0, len(config.Rules))
+	for _, minioRule := range config.Rules {
+		rule := LifecycleRule{ID: minioRule.ID, Prefix: minioRule.Prefix}
+		if minioRule.RuleFilter.Prefix != "" {
+			rule.Prefix = minioRule.RuleFilter.Prefix
+		}
+		rule.ExpireAfterDays = int(minioRule.Expiration.Days)
+		if !minioRule.Transition.IsNull() {
+			rule.TransitionAfterDays = int(minioRule.Transition.Days)
+			rule.TargetTier = tierOfStorageClass(minioRule.Transition.StorageClass)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Watch listens to the bucket notifications of MinIO and reports the object creations and
+// removals of the bucket. The listener reconnects on its own; it stops on errors reported
+// by MinIO, which are logged, and the channel is closed.
+func (m *MinioClient) Watch(ctx context.Context, storeBox string, events []common.EventType) (<-chan ObjectEvent, error) {
+	var names []string
+	if watchesEvent(events, common.OBJECT_CREATED) {
+		names = append(names, "s3:ObjectCreated:*")
+	}
+	if watchesEvent(events, common.OBJECT_REMOVED) {
+		names = append(names, "s3:ObjectRemoved:*")
+	}
+
+	notifications := m.client.ListenBucketNotification(ctx, m.properties.PhysicalBox(storeBox), "", "", names)
+
+	out := make(chan ObjectEvent)
+	go func() {
+		defer close(out)
+
+		for info := range notifications {
+			if info.Err != nil {
+				if ctx.Err() == nil {
+					log.Printf("[watch] minio bucket notification error on %s: %v", storeBox, info.Err)
+				}
+				continue
+			}
+			for _, record := range info.Records {
+				event, ok := eventFromRecord(m.properties.Label, record.EventName, record.EventTime, m.properties.LogicalBox(record.S3.Bucket.Name), record.S3.Object.Key)
+				if !ok {
+					continue
+				}
+				if !sendEvent(ctx, out, event, events) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// SetBoxPublicRead grants or revokes anonymous read access to the objects of a bucket.
+// The bucket policy is replaced by a generated one, or removed when public is false.
+func (m *MinioClient) SetBoxPublicRead(ctx context.Context, storeBox string, public bool) error {
+	if err := m.readOnly.writable("access change"); err != nil {
+		return err
+	}
+	storeBox = m.properties.PhysicalBox(storeBox)
+	policy := ""
+	if public {
+		policy = publicReadPolicy(storeBox)
+	}
+
+	if err := m.client.SetBucketPolicy(ctx, storeBox, policy); err != nil {
+		return fmt.Errorf("failed to set minio bucket policy: %w", err)
+	}
+
+	return nil
+}
+
+// GetBoxAccess returns the anonymous access granted by the policy of a bucket.
+func (m *MinioClient) GetBoxAccess(ctx context.Context, storeBox string) (BoxAccess, error) {
+	storeBox = m.properties.PhysicalBox(storeBox)
+	policy, err := m.client.GetBucketPolicy(ctx, storeBox)
+	if err != nil {
+		return BoxAccess{}, fmt.Errorf("failed to get minio bucket policy: %w", err)
+	}
+
+	return policyAccess(policy)
+}
+
 // getSizeFromReader ensures that the reader has a known size.
 // If the reader is seekable or supports Len(), it reuses it.
 // Otherwise it materializes into memory and returns a *bytes.Reader.
@@ -0,0 +1,272 @@
+package filestorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"slices"
+
+	common "github.com/tizianocitro/m2cs/pkg"
+)
+
+// MirrorOptions configures a MirroredStorage.
+type MirrorOptions struct {
+	Label string // Label of the mirrored storage (default: the label of the primary)
+	// OnSecondaryError is called with the operation and the error of every write or removal that
+	// succeeded on the primary but failed on the secondary (default: the failure is logged).
+	OnSecondaryError func(op, storeBox, fileName string, err error)
+}
+
+// MirroredStorage presents two storages of the same provider, e.g. two S3 buckets in different
+// regions, as a single FileStorage, so that a FileClient sees one storage while the redundancy
+// between them is handled beneath. It is created with NewMirroredStorage.
+//
+// Writes and removals are applied to the primary, which must succeed, then to the secondary,
+// whose failures are reported to MirrorOptions.OnSecondaryError without failing the call. Reads
+// are served by the primary and fall back to the secondary when the primary fails; an object
+// reported missing by the primary is missing, as the secondary may still hold objects whose
+// removal failed on it. When both fail, the returned error wraps the errors of both, so that
+// errors.Is(err, ErrObjectNotFound) holds when both report the object missing.
+type MirroredStorage struct {
+	primary          FileStorage
+	secondary        FileStorage
+	properties       common.ConnectionProperties
+	onSecondaryError func(op, storeBox, fileName string, err error)
+}
+
+// NewMirroredStorage creates a MirroredStorage writing to primary and secondary and reading from
+// primary, with secondary as fallback. Both must be main instances or replicas alike and store the
+// objects with the same compression and encryption, as a FileClient decides how to serve them from
+// the properties of the mirrored storage, which are the ones of primary. Their encryption keys may
+// differ, each storage decrypting its own objects.
+func NewMirroredStorage(primary, secondary FileStorage, opts MirrorOptions) (*MirroredStorage, error) {
+	if primary == nil {
+		return nil, fmt.Errorf("failed to create mirrored storage: primary storage is nil")
+	}
+	if secondary == nil {
+		return nil, fmt.Errorf("failed to create mirrored storage: secondary storage is nil")
+	}
+
+	p, s := primary.GetConnectionProperties(), secondary.GetConnectionProperties()
+	switch {
+	case p.IsMainInstance != s.IsMainInstance:
+		return nil, fmt.Errorf("failed to create mirrored storage: IsMainInstance differs between %q and %q", p.Label, s.Label)
+	case p.SaveCompress != s.SaveCompress:
+		return nil, fmt.Errorf("failed to create mirrored storage: compression differs between %q (%s) and %q (%s)", p.Label, p.SaveCompress, s.Label, s.SaveCompress)
+	case p.SaveEncrypt != s.SaveEncrypt:
+		return nil, fmt.Errorf("failed to create mirrored storage: encryption differs between %q (%s) and %q (%s)", p.Label, p.SaveEncrypt, s.Label, s.SaveEncrypt)
+	case !slices.Equal(p.TransformRules, s.TransformRules):
+		return nil, fmt.Errorf("failed to create mirrored storage: transform rules differ between %q and %q", p.Label, s.Label)
+	}
+
+	if opts.Label != "" {
+		p.Label = opts.Label
+	}
+	m := &MirroredStorage{primary: primary, secondary: secondary, properties: p, onSecondaryError: opts.OnSecondaryError}
+	if m.onSecondaryError == nil {
+		m.onSecondaryError = func(op, storeBox, fileName string, err error) {
+			log.Printf("[mirror] %s of %s/%s failed on the secondary storage of %s: %v", op, storeBox, fileName, p.Label, err)
+		}
+	}
+	return m, nil
+}
+
+// Primary returns the primary storage.
+func (m *MirroredStorage) Primary() FileStorage {
+	return m.primary
+}
+
+// Secondary returns the secondary storage.
+func (m *MirroredStorage) Secondary() FileStorage {
+	return m.secondary
+}
+
+func (m *MirroredStorage) GetConnectionProperties() common.ConnectionProperties {
+	return m.properties
+}
+
+func (m *MirroredStorage) GetObject(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, error) {
+	return fallback(m, func(s FileStorage) (io.ReadCloser, error) {
+		return s.GetObject(ctx, storeBox, fileName)
+	})
+}
+
+func (m *MirroredStorage) ExistObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
+	return fallback(m, func(s FileStorage) (bool, error) {
+		return s.ExistObject(ctx, storeBox, fileName)
+	})
+}
+
+// GetObjectWithInfo retrieves an object along with its attributes, see InfoGetter. A storage not
+// implementing InfoGetter fails with ErrInfoNotSupported.
+func (m *MirroredStorage) GetObjectWithInfo(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, ObjectStat, error) {
+	type withInfo struct {
+		obj  io.ReadCloser
+		stat ObjectStat
+	}
+	got, err := fallback(m, func(s FileStorage) (withInfo, error) {
+		getter, ok := s.(InfoGetter)
+		if !ok {
+			return withInfo{}, ErrInfoNotSupported
+		}
+		obj, stat, err := getter.GetObjectWithInfo(ctx, storeBox, fileName)
+		return withInfo{obj, stat}, err
+	})
+	return got.obj, got.stat, err
+}
+
+// GetObjectRange reads a byte range of an object, see RangeReader. A storage not implementing
+// RangeReader fails with ErrRangeNotSupported.
+func (m *MirroredStorage) GetObjectRange(ctx context.Context, storeBox string, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return fallback(m, func(s FileStorage) (io.ReadCloser, error) {
+		reader, ok := s.(RangeReader)
+		if !ok {
+			return nil, ErrRangeNotSupported
+		}
+		return reader.GetObjectRange(ctx, storeBox, fileName, offset, length)
+	})
+}
+
+// StatObject returns the attributes of an object, see ObjectStater.
+func (m *MirroredStorage) StatObject(ctx context.Context, storeBox string, fileName string) (ObjectStat, error) {
+	return fallback(m, func(s FileStorage) (ObjectStat, error) {
+		stater, ok := s.(ObjectStater)
+		if !ok {
+			return ObjectStat{}, fmt.Errorf("storage %q cannot stat objects", s.GetConnectionProperties().Label)
+		}
+		return stater.StatObject(ctx, storeBox, fileName)
+	})
+}
+
+// ListObjectsInfo lists the objects of a store box, see ObjectLister.
+func (m *MirroredStorage) ListObjectsInfo(ctx context.Context, storeBox string, prefix string) ([]ObjectInfo, error) {
+	return collectObjects(m.Objects(ctx, storeBox, ListOptions{Prefix: prefix}))
+}
+
+// Objects iterates the objects of a store box, see ObjectIterator. The listing falls back to the
+// secondary when the primary fails before yielding any object; a failure once objects have been
+// yielded ends the iteration, as the listing of the secondary cannot resume it.
+func (m *MirroredStorage) Objects(ctx context.Context, storeBox string, opts ListOptions) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		yielded := false
+		var primaryErr error
+		for info, err := range IterateObjects(ctx, m.primary, storeBox, opts) {
+			if err != nil {
+				primaryErr = err
+				break
+			}
+			yielded = true
+			if !yield(info, nil) {
+				return
+			}
+		}
+		if primaryErr == nil {
+			return
+		}
+		if yielded || errors.Is(primaryErr, ErrObjectNotFound) || errors.Is(primaryErr, ErrBoxNotFound) {
+			yield(ObjectInfo{}, primaryErr)
+			return
+		}
+
+		for info, err := range IterateObjects(ctx, m.secondary, storeBox, opts) {
+			if err != nil {
+				yield(ObjectInfo{}, fmt.Errorf("primary: %w; secondary: %w", primaryErr, err))
+				return
+			}
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
+
+// PutObject writes the object to the primary, then to the secondary. Payloads that cannot be
+// rewound are buffered in memory to be written twice.
+func (m *MirroredStorage) PutObject(ctx context.Context, storeBox string, fileName string, reader io.Reader) error {
+	return m.mirrorPut(ctx, "PutObject", storeBox, fileName, reader, func(s FileStorage, r io.Reader) error {
+		return s.PutObject(ctx, storeBox, fileName, r)
+	})
+}
+
+// PutObjectWithOptions writes the object with opts, see OptionsPutter, to the primary, then to
+// the secondary. The options are ignored by a storage not implementing OptionsPutter.
+func (m *MirroredStorage) PutObjectWithOptions(ctx context.Context, storeBox string, fileName string, reader io.Reader, opts PutOptions) error {
+	return m.mirrorPut(ctx, "PutObjectWithOptions", storeBox, fileName, reader, func(s FileStorage, r io.Reader) error {
+		if putter, ok := s.(OptionsPutter); ok {
+			return putter.PutObjectWithOptions(ctx, storeBox, fileName, r, opts)
+		}
+		return s.PutObject(ctx, storeBox, fileName, r)
+	})
+}
+
+// RemoveObject removes the object from the primary, then from the secondary. The object missing
+// from the secondary is not a failure.
+func (m *MirroredStorage) RemoveObject(ctx context.Context, storeBox string, fileName string) error {
+	if err := m.primary.RemoveObject(ctx, storeBox, fileName); err != nil {
+		return err
+	}
+	if err := m.secondary.RemoveObject(ctx, storeBox, fileName); err != nil && !errors.Is(err, ErrObjectNotFound) {
+		m.onSecondaryError("RemoveObject", storeBox, fileName, err)
+	}
+	return nil
+}
+
+// mirrorPut runs put with reader on the primary then, when it succeeds, with reader rewound on
+// the secondary.
+func (m *MirroredStorage) mirrorPut(ctx context.Context, op, storeBox, fileName string, reader io.Reader, put func(s FileStorage, r io.Reader) error) error {
+	seeker, seekable := reader.(io.Seeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+	var payload []byte
+	if !seekable && reader != nil {
+		var err error
+		if payload, err = readPayload(reader, readerSize(reader)); err != nil {
+			return fmt.Errorf("failed to read the payload: %w", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	if err := put(m.primary, reader); err != nil {
+		return err
+	}
+
+	if seekable {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			m.onSecondaryError(op, storeBox, fileName, fmt.Errorf("failed to rewind the payload: %w", err))
+			return nil
+		}
+	} else if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+	if err := ctx.Err(); err != nil {
+		m.onSecondaryError(op, storeBox, fileName, err)
+		return nil
+	}
+	if err := put(m.secondary, reader); err != nil {
+		m.onSecondaryError(op, storeBox, fileName, err)
+	}
+	return nil
+}
+
+// fallback runs read on the primary of m and, when it fails other than with a missing object or
+// store box, on the secondary, joining the errors of both when both fail.
+func fallback[T any](m *MirroredStorage, read func(s FileStorage) (T, error)) (T, error) {
+	got, err := read(m.primary)
+	if err == nil || errors.Is(err, ErrObjectNotFound) || errors.Is(err, ErrBoxNotFound) {
+		return got, err
+	}
+	got, secondaryErr := read(m.secondary)
+	if secondaryErr == nil {
+		return got, nil
+	}
+	return got, fmt.Errorf("primary: %w; secondary: %w", err, secondaryErr)
+}
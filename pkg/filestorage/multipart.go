@@ -0,0 +1,120 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUploadPartSize    = 8 << 20
+	defaultUploadConcurrency = 4
+)
+
+// partLimits are the limits of a provider on the parts of an upload.
+type partLimits struct {
+	minPartSize int64
+	maxPartSize int64
+	maxParts    int64
+}
+
+var (
+	// s3PartLimits are the limits of the multipart uploads of AWS S3, also enforced by MinIO.
+	// The minimum size does not apply to the last part.
+	s3PartLimits = partLimits{minPartSize: 5 << 20, maxPartSize: 5 << 30, maxParts: 10000}
+	// azBlockLimits are the limits of the blocks of an Azure block blob.
+	azBlockLimits = partLimits{minPartSize: 1, maxPartSize: 4000 << 20, maxParts: 50000}
+)
+
+// partSize returns the size of the parts of an upload of size bytes: the requested size,
+// or defaultUploadPartSize when zero, raised to the provider minimum and to the size needed
+// to stay within the maximum number of parts, rounded up to a whole MB.
+func (l partLimits) partSize(size int64, requested int64) (int64, error) {
+	partSize := requested
+	if partSize <= 0 {
+		partSize = defaultUploadPartSize
+	}
+	partSize = max(partSize, l.minPartSize)
+
+	if needed := (size + l.maxParts - 1) / l.maxParts; needed > partSize {
+		partSize = (needed + 1<<20 - 1) &^ (1<<20 - 1)
+	}
+	if partSize > l.maxPartSize {
+		return 0, fmt.Errorf("object of %d bytes exceeds %d parts of at most %d bytes", size, l.maxParts, l.maxPartSize)
+	}
+
+	return partSize, nil
+}
+
+// uploadParts calls upload for every part of an object of size bytes, with up to concurrency
+// calls at a time; part numbers start at 1. No part is started after the first error, which
+// is returned once the parts in flight have completed.
+func uploadParts(ctx context.Context, size int64, partSize int64, concurrency int, upload func(ctx context.Context, number int, offset int64, length int64) error) error {
+	if concurrency <= 0 {
+		concurrency = defaultUploadConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	slots := make(chan struct{}, concurrency)
+
+	parts := int((size + partSize - 1) / partSize)
+	for i := 0; i < parts; i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(number int, offset int64) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := upload(ctx, number, offset, min(partSize, size-offset)); err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("part %d: %w", number, err)
+					cancel()
+				})
+			}
+		}(i+1, int64(i)*partSize)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// abortUploads calls abort for every upload initiated more than olderThan ago and returns
+// the number of uploads aborted, along with the failures.
+func abortUploads(uploads []IncompleteUpload, olderThan time.Duration, abort func(IncompleteUpload) error) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	aborted := 0
+	var errs []error
+	for _, upload := range uploads {
+		if upload.Initiated.After(cutoff) {
+			continue
+		}
+		if err := abort(upload); err != nil {
+			errs = append(errs, fmt.Errorf("failed to abort upload %s of %s: %w", upload.UploadID, upload.Key, err))
+			continue
+		}
+		aborted++
+	}
+
+	return aborted, errors.Join(errs...)
+}
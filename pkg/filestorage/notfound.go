@@ -0,0 +1,34 @@
+package filestorage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/aws/smithy-go"
+	"github.com/minio/minio-go/v7"
+)
+
+// notFound returns err wrapping ErrObjectNotFound when it is the failure of a provider reporting
+// a missing object, and err otherwise, so that every storage reports missing objects alike.
+func notFound(err error) error {
+	if err == nil || errors.Is(err, ErrObjectNotFound) || !isObjectNotFound(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrObjectNotFound, err)
+}
+
+// isObjectNotFound reports whether err is the failure of a provider reporting a missing object:
+// the NoSuchKey code of MinIO and S3, the NotFound code of the S3 HEAD requests, which have no
+// body, and the BlobNotFound code of Azure.
+func isObjectNotFound(err error) bool {
+	var minioErr minio.ErrorResponse
+	if errors.As(err, &minioErr) {
+		return minioErr.Code == "NoSuchKey"
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == "NoSuchKey" || apiErr.ErrorCode() == "NotFound"
+	}
+	return bloberror.HasCode(err, bloberror.BlobNotFound)
+}
@@ -0,0 +1,90 @@
+package filestorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// publicReadPolicy returns the bucket policy granting anonymous read access to the objects
+// of a bucket, in the S3 policy language also understood by MinIO.
+func publicReadPolicy(storeBox string) string {
+	return fmt.Sprintf(`{"Version":"2012-10-17","Statement":[{"Sid":"m2cs-public-read","Effect":"Allow",`+
+		`"Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::%s/*"]}]}`, storeBox)
+}
+
+// policyStatement is a statement of a bucket policy. Principal, Action and Resource may be
+// either a string or a list of strings.
+type policyStatement struct {
+	Effect    string          `json:"Effect"`
+	Principal json.RawMessage `json:"Principal"`
+	Action    json.RawMessage `json:"Action"`
+	Condition json.RawMessage `json:"Condition"`
+}
+
+// policyAccess returns the anonymous access granted by a bucket policy. Only unconditional
+// statements allowing every principal are taken into account.
+func policyAccess(policy string) (BoxAccess, error) {
+	var access BoxAccess
+	if policy == "" {
+		return access, nil
+	}
+
+	var document struct {
+		Statement []policyStatement `json:"Statement"`
+	}
+	if err := json.Unmarshal([]byte(policy), &document); err != nil {
+		return access, fmt.Errorf("failed to parse bucket policy: %w", err)
+	}
+
+	for _, statement := range document.Statement {
+		if statement.Effect != "Allow" || len(statement.Condition) > 0 || !allowsAnyone(statement.Principal) {
+			continue
+		}
+		for _, action := range stringOrList(statement.Action) {
+			switch strings.ToLower(action) {
+			case "s3:*":
+				access.PublicRead, access.PublicList = true, true
+			case "s3:getobject":
+				access.PublicRead = true
+			case "s3:listbucket":
+				access.PublicList = true
+			}
+		}
+	}
+
+	return access, nil
+}
+
+// allowsAnyone reports whether a policy principal is "*" or {"AWS": "*"}.
+func allowsAnyone(principal json.RawMessage) bool {
+	for _, p := range stringOrList(principal) {
+		if p == "*" {
+			return true
+		}
+	}
+
+	var byType map[string]json.RawMessage
+	if err := json.Unmarshal(principal, &byType); err != nil {
+		return false
+	}
+	for _, p := range stringOrList(byType["AWS"]) {
+		if p == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// stringOrList decodes a policy value holding either a string or a list of strings.
+func stringOrList(raw json.RawMessage) []string {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package filestorage
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrReadOnlyStorage is returned by the writes, the removals and the changes of the store boxes
+// of a read-only storage, see ReadOnlySetter; nothing is sent to the provider.
+var ErrReadOnlyStorage = errors.New("storage is read-only")
+
+// ReadOnlySetter is implemented by storages able to reject the operations changing their content,
+// e.g. the replicas, which must only be written by the replication of the provider. A read-only
+// storage fails the puts, the uploads, the removals, the creation and removal of store boxes and
+// the changes of tiers, legal holds, lifecycles and access with ErrReadOnlyStorage, while reads
+// keep working. The storages created with ConnectionProperties.ReadOnly start read-only, and
+// GetConnectionProperties reports the current state.
+type ReadOnlySetter interface {
+	SetReadOnly(readOnly bool)
+}
+
+// readOnlyFlag is the state of a storage implementing ReadOnlySetter. Its zero value is writable.
+type readOnlyFlag struct {
+	on atomic.Bool
+}
+
+// writable returns an error wrapping ErrReadOnlyStorage, naming the rejected operation op, when
+// the storage is read-only.
+func (r *readOnlyFlag) writable(op string) error {
+	if r.on.Load() {
+		return fmt.Errorf("%s rejected: %w", op, ErrReadOnlyStorage)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrTransient marks a failure worth retrying, e.g. for storages wrapping other services;
+// see IsRetriable.
+var ErrTransient = errors.New("transient storage failure")
+
+// IsRetriable reports whether err is a transient failure, which may not happen again if the
+// operation is retried: timeouts, throttling (see IsThrottled) and server errors (HTTP 500, 502,
+// 503 and 504) of the providers, and errors wrapping ErrTransient. Canceled operations and failed
+// preconditions are not.
+func IsRetriable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrPreconditionFailed) {
+		return false
+	}
+	if errors.Is(err, ErrTransient) || IsThrottled(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var minioErr minio.ErrorResponse
+	if errors.As(err, &minioErr) {
+		return retriableStatus(minioErr.StatusCode)
+	}
+	var s3Err *awshttp.ResponseError
+	if errors.As(err, &s3Err) {
+		return retriableStatus(s3Err.HTTPStatusCode())
+	}
+	var azErr *azcore.ResponseError
+	if errors.As(err, &azErr) {
+		return retriableStatus(azErr.StatusCode)
+	}
+	return false
+}
+
+// retriableStatus reports whether an HTTP status code is a transient failure.
+func retriableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
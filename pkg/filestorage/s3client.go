@@ -2,13 +2,21 @@ package filestorage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
@@ -19,26 +27,76 @@ import (
 type S3Client struct {
 	client     *s3.Client
 	properties common.ConnectionProperties
+	pipelines  *transform.Cache // Pipelines built from properties, see RotateEncryptKey
+	events     EventQueue
+	readOnly   readOnlyFlag
 }
 
 func (s *S3Client) GetConnectionProperties() common.ConnectionProperties {
-	return s.properties
+	properties := s.properties
+	properties.EncryptKey, properties.EncryptKeyBytes = s.pipelines.Key(), s.pipelines.KeyBytes()
+	properties.ReadOnly = s.readOnly.on.Load()
+	return properties
+}
+
+// SetReadOnly makes the storage read-only, or writable again, see ReadOnlySetter.
+func (s *S3Client) SetReadOnly(readOnly bool) {
+	s.readOnly.on.Store(readOnly)
+}
+
+// RotateEncryptKey replaces the encryption key, see KeyRotator.
+func (s *S3Client) RotateEncryptKey(key string) error {
+	if err := s.pipelines.SetKey(key); err != nil {
+		return fmt.Errorf("failed to rotate the encryption key: %w", err)
+	}
+	return nil
 }
 
 func NewS3Client(client *s3.Client, properties common.ConnectionProperties) (*S3Client, error) {
 	if client == nil {
 		return nil, fmt.Errorf("failed to create S3Client: client is nil")
 	}
+	if err := properties.ValidateBoxAliases(); err != nil {
+		return nil, fmt.Errorf("failed to create S3Client: %w", err)
+	}
 
-	_, err := client.ListBuckets(context.TODO(), nil)
-	if err != nil {
+	if err := probeS3(context.TODO(), client, properties.PhysicalBox(properties.ProbeBox)); err != nil {
 		return nil, fmt.Errorf("failed to connect to AWS S3: %w", err)
 	}
 
-	return &S3Client{
+	s := &S3Client{
 		client:     client,
 		properties: properties,
-	}, nil
+		pipelines:  transform.NewCache(properties),
+	}
+	s.readOnly.on.Store(properties.ReadOnly)
+	return s, nil
+}
+
+// probeS3 checks the connection by listing the buckets or, when probeBox is set, by
+// checking that the bucket exists. Access denied responses on probeBox are accepted, since
+// anonymous and scoped credentials may not be allowed to inspect the bucket.
+func probeS3(ctx context.Context, client *s3.Client, probeBox string) error {
+	if probeBox == "" {
+		_, err := client.ListBuckets(ctx, nil)
+		return err
+	}
+
+	_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(probeBox)})
+	if err != nil {
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) {
+			switch respErr.HTTPStatusCode() {
+			case http.StatusForbidden:
+				return nil
+			case http.StatusNotFound:
+				return fmt.Errorf("store box %s not found", probeBox)
+			}
+		}
+		return err
+	}
+
+	return nil
 }
 
 func (s *S3Client) GetClient() *s3.Client {
@@ -46,6 +104,10 @@ func (s *S3Client) GetClient() *s3.Client {
 }
 
 func (s *S3Client) CreateBucket(ctx context.Context, bucketName string) error {
+	if err := s.readOnly.writable("store box creation"); err != nil {
+		return err
+	}
+	bucketName = s.properties.PhysicalBox(bucketName)
 	_, err := s.client.CreateBucket(ctx, &s3.CreateBucketInput{
 		Bucket: aws.String(bucketName)})
 	if err != nil {
@@ -87,7 +149,7 @@ func (s *S3Client) ListBuckets(ctx context.Context) ([]string, error) {
 			break
 		} else {
 			for _, bucket := range output.Buckets {
-				buckets = append(buckets, fmt.Sprintf("Name: %s, CreatedOn: %s", *bucket.Name, bucket.CreationDate))
+				buckets = append(buckets, fmt.Sprintf("Name: %s, CreatedOn: %s", s.properties.LogicalBox(*bucket.Name), bucket.CreationDate))
 			}
 		}
 	}
@@ -96,6 +158,10 @@ func (s *S3Client) ListBuckets(ctx context.Context) ([]string, error) {
 }
 
 func (s *S3Client) RemoveBucket(ctx context.Context, bucketName string) error {
+	if err := s.readOnly.writable("store box removal"); err != nil {
+		return err
+	}
+	bucketName = s.properties.PhysicalBox(bucketName)
 	_, err := s.client.DeleteBucket(ctx, &s3.DeleteBucketInput{
 		Bucket: aws.String(bucketName)})
 	if err != nil {
@@ -120,16 +186,12 @@ func (s *S3Client) RemoveBucket(ctx context.Context, bucketName string) error {
 }
 
 func (s *S3Client) GetObject(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, error) {
+	storeBox = s.properties.PhysicalBox(storeBox)
 	if _, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(storeBox),
 		Key:    aws.String(fileName),
 	}); err != nil {
-		return nil, fmt.Errorf("failed to head object: %w", err)
-	}
-
-	pipe, err := transform.Factory{}.BuildRPipelineDecryptDecompress(s.properties, s.properties.EncryptKey)
-	if err != nil {
-		return nil, fmt.Errorf("build read pipeline: %w", err)
+		return nil, fmt.Errorf("failed to head object: %w", notFound(err))
 	}
 
 	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
@@ -140,13 +202,19 @@ func (s *S3Client) GetObject(ctx context.Context, storeBox string, fileName stri
 		var noKey *types.NoSuchKey
 		if errors.As(err, &noKey) {
 			log.Printf("Can't get object %s from bucket %s. No such key exists.\n", fileName, storeBox)
-			err = noKey
+			err = notFound(noKey)
 		} else {
 			log.Printf("Couldn't get object %v:%v. Here's why: %v\n", storeBox, fileName, err)
 		}
 		return nil, err
 	}
 
+	pipe, err := readPipeline(s.properties, s.pipelines.Box(storeBox), result.Metadata, aws.ToString(result.ContentEncoding), sizeOf(result.ContentLength))
+	if err != nil {
+		_ = result.Body.Close()
+		return nil, fmt.Errorf("build read pipeline: %w", err)
+	}
+
 	obj, err := pipe.Apply(result.Body)
 	if err != nil {
 		_ = result.Body.Close()
@@ -156,52 +224,356 @@ func (s *S3Client) GetObject(ctx context.Context, storeBox string, fileName stri
 	return obj, err
 }
 
+// GetObjectWithInfo retrieves an object along with its attributes, see InfoGetter.
+// The attributes are taken from the response of the read.
+func (s *S3Client) GetObjectWithInfo(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, ObjectStat, error) {
+	storeBox = s.properties.PhysicalBox(storeBox)
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(storeBox),
+		Key:    aws.String(fileName),
+	})
+	if err != nil {
+		return nil, ObjectStat{}, fmt.Errorf("failed to get object: %w", notFound(err))
+	}
+
+	pipe, err := readPipeline(s.properties, s.pipelines.Box(storeBox), result.Metadata, aws.ToString(result.ContentEncoding), sizeOf(result.ContentLength))
+	if err != nil {
+		_ = result.Body.Close()
+		return nil, ObjectStat{}, fmt.Errorf("build read pipeline: %w", err)
+	}
+
+	obj, err := pipe.Apply(result.Body)
+	if err != nil {
+		_ = result.Body.Close()
+		return nil, ObjectStat{}, fmt.Errorf("apply read pipeline: %w", err)
+	}
+
+	return obj, ObjectStat{
+		ObjectInfo: ObjectInfo{
+			Key:          fileName,
+			Size:         logicalSize(s.properties, aws.ToInt64(result.ContentLength), result.Metadata),
+			LastModified: aws.ToTime(result.LastModified),
+			ETag:         aws.ToString(result.ETag),
+		},
+		ContentType: aws.ToString(result.ContentType),
+	}, nil
+}
+
+// GetObjectRange retrieves length bytes of an object starting at offset.
+// A length <= 0 reads until the end of the object.
+func (s *S3Client) GetObjectRange(ctx context.Context, storeBox string, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	storeBox = s.properties.PhysicalBox(storeBox)
+	if !supportsRange(s.properties) {
+		return nil, ErrRangeNotSupported
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(storeBox),
+		Key:    aws.String(fileName),
+		Range:  aws.String(byteRange),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object range: %w", notFound(err))
+	}
+
+	return result.Body, nil
+}
+
 func (s *S3Client) PutObject(ctx context.Context, storeBox string, fileName string, reader io.Reader) error {
+	return s.PutObjectWithOptions(ctx, storeBox, fileName, reader, PutOptions{})
+}
+
+// PutObjectWithOptions uploads an object storing the given content headers and metadata.
+func (s *S3Client) PutObjectWithOptions(ctx context.Context, storeBox string, fileName string, reader io.Reader, opts PutOptions) error {
+	_, err := s.PutObjectWithResult(ctx, storeBox, fileName, reader, opts)
+	return err
+}
+
+// PutObjectWithResult uploads an object like PutObjectWithOptions, reporting its ETag and version.
+func (s *S3Client) PutObjectWithResult(ctx context.Context, storeBox string, fileName string, reader io.Reader, opts PutOptions) (PutResult, error) {
+	if err := s.readOnly.writable("put"); err != nil {
+		return PutResult{}, err
+	}
+	return putCreatingBox(s.properties, reader, func() (PutResult, error) {
+		return s.putObject(ctx, storeBox, fileName, reader, opts)
+	}, func() error {
+		return s.createBox(ctx, s.properties.PhysicalBox(storeBox))
+	})
+}
+
+// createBox creates the bucket of a put, see ConnectionProperties.AutoCreateBox, in the region of
+// the client, and waits for it to exist. A bucket already owned is not an error.
+func (s *S3Client) createBox(ctx context.Context, bucketName string) error {
+	input := &s3.CreateBucketInput{Bucket: aws.String(bucketName)}
+	// us-east-1 is the default location, which cannot be given as a constraint
+	if region := s.client.Options().Region; region != "" && region != "us-east-1" {
+		input.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+			LocationConstraint: types.BucketLocationConstraint(region),
+		}
+	}
+
+	if _, err := s.client.CreateBucket(ctx, input); err != nil {
+		var owned *types.BucketAlreadyOwnedByYou
+		if !errors.As(err, &owned) {
+			return err
+		}
+	}
+	return s3.NewBucketExistsWaiter(s.client).Wait(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucketName)}, time.Minute)
+}
+
+func (s *S3Client) putObject(ctx context.Context, storeBox string, fileName string, reader io.Reader, opts PutOptions) (PutResult, error) {
+	storeBox = s.properties.PhysicalBox(storeBox)
 	if reader == nil {
-		return fmt.Errorf("reader is nil")
+		return PutResult{}, fmt.Errorf("reader is nil")
+	}
+	if err := validateRetention(opts.Retention); err != nil {
+		return PutResult{}, err
 	}
 
-	pipe, err := transform.Factory{}.BuildWPipelineCompressEncrypt(s.properties, s.properties.EncryptKey)
+	opts, applied, err := checkIdempotencyKey(opts, func() (map[string]string, bool, error) {
+		return s.objectMetadata(ctx, storeBox, fileName)
+	})
 	if err != nil {
-		return fmt.Errorf("build write pipeline: %w", err)
+		return PutResult{}, fmt.Errorf("failed to check the idempotency key: %w", err)
+	}
+	if applied {
+		return PutResult{}, nil
 	}
 
-	obj, closer, err := pipe.Apply(reader)
+	properties, reader, logical, err := skipEmptyTransforms(s.properties.ForObject(fileName, opts.ContentType), reader, payloadSize(reader, opts))
+	if err != nil {
+		return PutResult{}, err
+	}
+	properties, reader, err = skipPoorCompression(properties, reader)
+	if err != nil {
+		return PutResult{}, err
+	}
+	obj, closer, err := writePipeline(properties, s.pipelines.Box(storeBox), reader)
 	if err != nil {
-		return fmt.Errorf("apply write pipeline: %w", err)
+		return PutResult{}, err
 	}
 
 	if closer != nil {
 		defer closer.Close()
 	}
 
-	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(storeBox),
-		Key:    aws.String(fileName),
-		Body:   obj,
-	})
+	opts = withTransformHeaders(opts, s.properties, properties, logical)
+	input := &s3.PutObjectInput{
+		Bucket:   aws.String(storeBox),
+		Key:      aws.String(fileName),
+		Body:     obj,
+		Metadata: opts.Metadata,
+	}
+	if supportsRange(properties) && opts.Size > 0 {
+		input.ContentLength = aws.Int64(opts.Size)
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(opts.ContentEncoding)
+	}
+	if mode, ok := s3RetentionModes[opts.Retention.Mode]; ok {
+		input.ObjectLockMode = types.ObjectLockMode(mode)
+		input.ObjectLockRetainUntilDate = aws.Time(opts.Retention.Until)
+	}
+	if opts.IfMatch != "" {
+		input.IfMatch = aws.String(opts.IfMatch)
+	}
+
+	var sum *payloadChecksum
+	if opts.ChecksumAlgorithm != common.NO_CHECKSUM {
+		if input.Body, sum, err = checksumPayload(obj, opts.ChecksumAlgorithm); err != nil {
+			return PutResult{}, err
+		}
+		input.ChecksumAlgorithm = s3ChecksumAlgorithms[opts.ChecksumAlgorithm]
+	}
+
+	output, err := s.client.PutObject(ctx, input)
 	if err != nil {
+		if opts.Retention.Mode != common.NO_RETENTION {
+			if lockErr := s.objectLockError(ctx, storeBox, err); errors.Is(lockErr, ErrObjectLockUnsupported) {
+				return PutResult{}, fmt.Errorf("failed to put the object into s3 bucket: %w", lockErr)
+			}
+		}
 		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && preconditionError(opts.IfMatch, apiErr.ErrorCode()) {
+			return PutResult{}, fmt.Errorf("failed to put the object into s3 bucket: %w: %w", ErrPreconditionFailed, err)
+		}
+		if errors.As(err, &apiErr) && checksumErrorCodes[apiErr.ErrorCode()] {
+			return PutResult{}, fmt.Errorf("failed to put the object into s3 bucket: %w: %w", ErrChecksumMismatch, err)
+		}
 		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "EntityTooLarge" {
-			return fmt.Errorf("Error while uploading object to %s. The object is too large.\n"+
+			return PutResult{}, fmt.Errorf("Error while uploading object to %s. The object is too large.\n"+
 				"To upload objects larger than 5GB, use the S3 console (160GB max)\n"+
 				"or the multipart upload API (5TB max).", storeBox)
 		} else {
-			return fmt.Errorf("Couldn't upload file %v to %v. Here's why: %v\n",
+			return PutResult{}, fmt.Errorf("Couldn't upload file %v to %v. Here's why: %w\n",
 				fileName, storeBox, err)
 		}
 	} else {
+		if sum != nil {
+			reported := checksumsOf(aws.ToString(output.ChecksumCRC32C), aws.ToString(output.ChecksumSHA1), aws.ToString(output.ChecksumSHA256))
+			if err := sum.verify(reported[opts.ChecksumAlgorithm]); err != nil {
+				return PutResult{}, fmt.Errorf("failed to put the object into s3 bucket: %w", err)
+			}
+		}
 		err = s3.NewObjectExistsWaiter(s.client).Wait(
 			ctx, &s3.HeadObjectInput{Bucket: aws.String(storeBox), Key: aws.String(fileName)}, time.Minute)
 		if err != nil {
-			return fmt.Errorf("Failed attempt to wait for object %s to exist.\n", fileName)
+			return PutResult{}, fmt.Errorf("Failed attempt to wait for object %s to exist.\n", fileName)
 		}
 	}
 
-	return err
+	return PutResult{ETag: aws.ToString(output.ETag), VersionID: aws.ToString(output.VersionId)}, nil
+}
+
+// UploadParallel uploads an object with a multipart upload whose parts are sent concurrently,
+// see ParallelUploader. Objects fitting in a single part, and objects saved compressed or
+// encrypted, whose stored size is only known once written, are uploaded with PutObject.
+func (s *S3Client) UploadParallel(ctx context.Context, storeBox string, fileName string, r io.ReaderAt, size int64, opts ParallelOptions) error {
+	if err := s.readOnly.writable("upload"); err != nil {
+		return err
+	}
+	partSize, err := s3PartLimits.partSize(size, opts.PartSize)
+	if err != nil {
+		return err
+	}
+	if size <= partSize || !supportsRange(s.properties) {
+		return s.PutObject(ctx, storeBox, fileName, io.NewSectionReader(r, 0, size))
+	}
+	storeBox = s.properties.PhysicalBox(storeBox)
+
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(storeBox),
+		Key:               aws.String(fileName),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	parts := make([]types.CompletedPart, (size+partSize-1)/partSize)
+	err = uploadParts(ctx, size, partSize, opts.Concurrency, func(ctx context.Context, number int, offset int64, length int64) error {
+		part, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:            aws.String(storeBox),
+			Key:               aws.String(fileName),
+			UploadId:          created.UploadId,
+			PartNumber:        aws.Int32(int32(number)),
+			Body:              io.NewSectionReader(r, offset, length),
+			ContentLength:     aws.Int64(length),
+			ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+		})
+		if err != nil {
+			return err
+		}
+		parts[number-1] = types.CompletedPart{
+			ETag:          part.ETag,
+			ChecksumCRC32: part.ChecksumCRC32,
+			PartNumber:    aws.Int32(int32(number)),
+		}
+		return nil
+	})
+	if err == nil {
+		_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(storeBox),
+			Key:             aws.String(fileName),
+			UploadId:        created.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if err != nil {
+		// The upload is aborted even if ctx is done, so that its parts are not billed.
+		abortCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		if _, abortErr := s.client.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(storeBox),
+			Key:      aws.String(fileName),
+			UploadId: created.UploadId,
+		}); abortErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to abort multipart upload: %w", abortErr))
+		}
+		return fmt.Errorf("multipart upload of %s failed: %w", fileName, err)
+	}
+
+	return nil
+}
+
+// ListIncompleteUploads lists the incomplete multipart uploads of a bucket.
+func (s *S3Client) ListIncompleteUploads(ctx context.Context, storeBox string) ([]IncompleteUpload, error) {
+	return s.ListIncompleteUploadsWithPrefix(ctx, storeBox, "")
+}
+
+// ListIncompleteUploadsWithPrefix lists the incomplete multipart uploads of a bucket whose key
+// starts with prefix, see PrefixUploadCleaner.
+func (s *S3Client) ListIncompleteUploadsWithPrefix(ctx context.Context, storeBox string, prefix string) ([]IncompleteUpload, error) {
+	storeBox = s.properties.PhysicalBox(storeBox)
+	var uploads []IncompleteUpload
+
+	input := &s3.ListMultipartUploadsInput{Bucket: aws.String(storeBox)}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	for {
+		output, err := s.client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+		}
+
+		for _, upload := range output.Uploads {
+			uploads = append(uploads, IncompleteUpload{
+				Key:       aws.ToString(upload.Key),
+				UploadID:  aws.ToString(upload.UploadId),
+				Initiated: aws.ToTime(upload.Initiated),
+			})
+		}
+
+		if !aws.ToBool(output.IsTruncated) {
+			return uploads, nil
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.UploadIdMarker = output.NextUploadIdMarker
+	}
+}
+
+// AbortIncompleteUploads aborts the multipart uploads of a bucket initiated more than
+// olderThan ago, see UploadCleaner.
+func (s *S3Client) AbortIncompleteUploads(ctx context.Context, storeBox string, olderThan time.Duration) (int, error) {
+	return s.AbortIncompleteUploadsWithPrefix(ctx, storeBox, "", olderThan)
+}
+
+// AbortIncompleteUploadsWithPrefix aborts the multipart uploads of a bucket whose key starts
+// with prefix initiated more than olderThan ago, see PrefixUploadCleaner.
+func (s *S3Client) AbortIncompleteUploadsWithPrefix(ctx context.Context, storeBox string, prefix string, olderThan time.Duration) (int, error) {
+	if err := s.readOnly.writable("upload abort"); err != nil {
+		return 0, err
+	}
+	uploads, err := s.ListIncompleteUploadsWithPrefix(ctx, storeBox, prefix)
+	if err != nil {
+		return 0, err
+	}
+	storeBox = s.properties.PhysicalBox(storeBox)
+
+	return abortUploads(uploads, olderThan, func(upload IncompleteUpload) error {
+		_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(storeBox),
+			Key:      aws.String(upload.Key),
+			UploadId: aws.String(upload.UploadID),
+		})
+		return err
+	})
 }
 
 func (s *S3Client) RemoveObject(ctx context.Context, storeBox string, fileName string) error {
+	if err := s.readOnly.writable("removal"); err != nil {
+		return err
+	}
+	storeBox = s.properties.PhysicalBox(storeBox)
 	input := &s3.DeleteObjectInput{
 		Bucket: aws.String(storeBox),
 		Key:    aws.String(fileName),
@@ -234,14 +606,52 @@ func (s *S3Client) RemoveObject(ctx context.Context, storeBox string, fileName s
 	return err
 }
 
+// ListObjectsInfo lists the objects of a bucket whose key starts with prefix.
+func (s *S3Client) ListObjectsInfo(ctx context.Context, storeBox string, prefix string) ([]ObjectInfo, error) {
+	return collectObjects(s.Objects(ctx, storeBox, ListOptions{Prefix: prefix}))
+}
+
+// Objects iterates the objects of a bucket, one page of ListObjectsV2 at a time, see ObjectIterator.
+func (s *S3Client) Objects(ctx context.Context, storeBox string, opts ListOptions) iter.Seq2[ObjectInfo, error] {
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(s.properties.PhysicalBox(storeBox))}
+	if opts.Prefix != "" {
+		input.Prefix = aws.String(opts.Prefix)
+	}
+	if opts.PageSize > 0 {
+		input.MaxKeys = aws.Int32(int32(min(opts.PageSize, math.MaxInt32)))
+	}
+
+	return func(yield func(ObjectInfo, error) bool) {
+		paginator := s3.NewListObjectsV2Paginator(s.client, input)
+		for paginator.HasMorePages() {
+			output, err := paginator.NextPage(ctx)
+			if err != nil {
+				yield(ObjectInfo{}, fmt.Errorf("failed to list objects: %w", err))
+				return
+			}
+			for _, obj := range output.Contents {
+				info := ObjectInfo{
+					Key:          aws.ToString(obj.Key),
+					Size:         aws.ToInt64(obj.Size),
+					LastModified: aws.ToTime(obj.LastModified),
+					ETag:         aws.ToString(obj.ETag),
+				}
+				if !yield(info, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
 func (s *S3Client) ExistObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
+	storeBox = s.properties.PhysicalBox(storeBox)
 	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(storeBox),
 		Key:    aws.String(fileName),
 	})
 	if err != nil {
-		var noKey *types.NoSuchKey
-		if errors.As(err, &noKey) {
+		if isObjectNotFound(err) {
 			return false, nil
 		}
 		return false, fmt.Errorf("failed to head object: %w", err)
@@ -249,3 +659,462 @@ func (s *S3Client) ExistObject(ctx context.Context, storeBox string, fileName st
 
 	return true, nil
 }
+
+// SetObjectTier moves an object to the storage class of the given tier by copying it
+// onto itself. Archived objects must be restored before their tier can be changed.
+func (s *S3Client) SetObjectTier(ctx context.Context, storeBox string, fileName string, tier common.StorageTier) error {
+	if err := s.readOnly.writable("tier change"); err != nil {
+		return err
+	}
+	storeBox = s.properties.PhysicalBox(storeBox)
+	class, ok := s3StorageClasses[tier]
+	if !ok {
+		return fmt.Errorf("unknown storage tier %v", tier)
+	}
+
+	_, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(storeBox),
+		Key:               aws.String(fileName),
+		CopySource:        aws.String(storeBox + "/" + url.PathEscape(fileName)),
+		MetadataDirective: types.MetadataDirectiveCopy,
+		StorageClass:      class,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to change storage class: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreObject initiates the restore of an archived object, whose temporary copy is
+// kept for the given number of days.
+func (s *S3Client) RestoreObject(ctx context.Context, storeBox string, fileName string, days int) error {
+	storeBox = s.properties.PhysicalBox(storeBox)
+	if days <= 0 {
+		return fmt.Errorf("restore days must be positive, got %d", days)
+	}
+
+	_, err := s.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         aws.String(storeBox),
+		Key:            aws.String(fileName),
+		RestoreRequest: &types.RestoreRequest{Days: aws.Int32(int32(days))},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore object: %w", err)
+	}
+
+	return nil
+}
+
+// StatObject returns the attributes of an object, including its storage class and the
+// state of its restore.
+func (s *S3Client) StatObject(ctx context.Context, storeBox string, fileName string) (ObjectStat, error) {
+	storeBox = s.properties.PhysicalBox(storeBox)
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(storeBox),
+		Key:          aws.String(fileName),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return ObjectStat{}, fmt.Errorf("failed to head object: %w", notFound(err))
+	}
+
+	class := string(head.StorageClass)
+	if class == "" {
+		class = string(types.StorageClassStandard)
+	}
+
+	status := TierStatus{Tier: tierOfStorageClass(class), StorageClass: class}
+	status.Restoring, status.RestoredUntil = parseRestoreHeader(aws.ToString(head.Restore))
+
+	return ObjectStat{
+		ObjectInfo: ObjectInfo{
+			Key:          fileName,
+			Size:         aws.ToInt64(head.ContentLength),
+			LastModified: aws.ToTime(head.LastModified),
+			ETag:         aws.ToString(head.ETag),
+		},
+		ContentType: aws.ToString(head.ContentType),
+		TierStatus:  status,
+		Lock: LockStatus{
+			Retention: Retention{
+				Mode:  retentionModeOf(string(head.ObjectLockMode)),
+				Until: aws.ToTime(head.ObjectLockRetainUntilDate),
+			},
+			LegalHold: head.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn,
+		},
+		Checksums: checksumsOf(aws.ToString(head.ChecksumCRC32C), aws.ToString(head.ChecksumSHA1), aws.ToString(head.ChecksumSHA256)),
+	}, nil
+}
+
+// SetObjectLegalHold places or removes the legal hold of an object.
+// The bucket must have been created with object lock enabled.
+func (s *S3Client) SetObjectLegalHold(ctx context.Context, storeBox string, fileName string, on bool) error {
+	if err := s.readOnly.writable("legal hold change"); err != nil {
+		return err
+	}
+	storeBox = s.properties.PhysicalBox(storeBox)
+	status := types.ObjectLockLegalHoldStatusOff
+	if on {
+		status = types.ObjectLockLegalHoldStatusOn
+	}
+
+	_, err := s.client.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(storeBox),
+		Key:       aws.String(fileName),
+		LegalHold: &types.ObjectLockLegalHold{Status: status},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set the legal hold: %w", s.objectLockError(ctx, storeBox, err))
+	}
+
+	return nil
+}
+
+// objectLockError returns err wrapping ErrObjectLockUnsupported when the bucket does not have
+// object lock enabled, which the object lock failures do not report consistently.
+func (s *S3Client) objectLockError(ctx context.Context, storeBox string, err error) error {
+	config, lookupErr := s.client.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(storeBox),
+	})
+	if lookupErr != nil {
+		var apiErr smithy.APIError
+		if !errors.As(lookupErr, &apiErr) || apiErr.ErrorCode() != objectLockNotFoundCode {
+			return err
+		}
+	}
+	if lookupErr != nil || config.ObjectLockConfiguration == nil ||
+		config.ObjectLockConfiguration.ObjectLockEnabled != types.ObjectLockEnabledEnabled {
+		return fmt.Errorf("%w: bucket %s has no object lock configuration", ErrObjectLockUnsupported, storeBox)
+	}
+	return err
+}
+
+// SetBoxLifecycle replaces the lifecycle configuration of a bucket with the given rules.
+// Transitions target the storage class of the tier, as in SetObjectTier.
+func (s *S3Client) SetBoxLifecycle(ctx context.Context, storeBox string, rules []LifecycleRule) error {
+	if err := s.readOnly.writable("lifecycle change"); err != nil {
+		return err
+	}
+	storeBox = s.properties.PhysicalBox(storeBox)
+	rules, err := validateLifecycleRules(rules)
+	if err != nil {
+		return err
+	}
+
+	if len(rules) == 0 {
+		_, err := s.client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{Bucket: aws.String(storeBox)})
+		if err != nil {
+			return fmt.Errorf("failed to delete bucket lifecycle: %w", err)
+		}
+		return nil
+	}
+
+	s3Rules := make([]types.LifecycleRule, 0, len(rules))
+	for _, rule := range rules {
+		s3Rule := types.LifecycleRule{
+			ID:     aws.String(rule.ID),
+			Status: types.ExpirationStatusEnabled,
+			Filter: &types.LifecycleRuleFilter{Prefix: aws.String(rule.Prefix)},
+		}
+		if rule.ExpireAfterDays > 0 {
+			s3Rule.Expiration = &types.LifecycleExpiration{Days: aws.Int32(int32(rule.ExpireAfterDays))}
+		}
+		if rule.TransitionAfterDays > 0 {
+			s3Rule.Transitions = []types.Transition{{
+				Days:         aws.Int32(int32(rule.TransitionAfterDays)),
+				StorageClass: types.TransitionStorageClass(s3StorageClasses[rule.TargetTier]),
+			}}
+		}
+		s3Rules = append(s3Rules, s3Rule)
+	}
+
+	_, err = s.client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(storeBox),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: s3Rules},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put bucket lifecycle: %w", err)
+	}
+
+	return nil
+}
+
+// GetBoxLifecycle returns the lifecycle rules of a bucket. Rules using filters other than
+// a prefix are returned with their prefix only.
+func (s *S3Client) GetBoxLifecycle(ctx context.Context, storeBox string) ([]LifecycleRule, error) {
+	storeBox = s.properties.PhysicalBox(storeBox)
+	output, err := s.client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(storeBox),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get bucket lifecycle: %w", err)
+	}
+
+	rules := make([]LifecycleRule, 0, len(output.Rules))
+	for _, s3Rule := range output.Rules {
+		rule := LifecycleRule{ID: aws.ToString(s3Rule.ID), Prefix: aws.ToString(s3Rule.Prefix)}
+		if s3Rule.Filter != nil && s3Rule.Filter.Prefix != nil {
+			rule.Prefix = *s3Rule.Filter.Prefix
+		}
+		if s3Rule.Expiration != nil {
+			rule.ExpireAfterDays = int(aws.ToInt32(s3Rule.Expiration.Days))
+		}
+		if len(s3Rule.Transitions) > 0 {
+			rule.TransitionAfterDays = int(aws.ToInt32(s3Rule.Transitions[0].Days))
+			rule.TargetTier = tierOfStorageClass(string(s3Rule.Transitions[0].StorageClass))
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// SetEventQueue sets the queue receiving the event notifications of the watched buckets,
+// e.g. an SQS queue. Without a queue, Watch returns ErrWatchNotSupported.
+func (s *S3Client) SetEventQueue(queue EventQueue) {
+	s.events = queue
+}
+
+// Watch reports the object creations and removals of a bucket, read from the event queue.
+// Messages are deleted from the queue once processed, including the ones of other buckets,
+// so the queue should not be shared with other consumers.
+func (s *S3Client) Watch(ctx context.Context, storeBox string, events []common.EventType) (<-chan ObjectEvent, error) {
+	if s.events == nil {
+		return nil, fmt.Errorf("%w: no event queue set on the S3 client", ErrWatchNotSupported)
+	}
+	queue := s.events
+
+	out := make(chan ObjectEvent)
+	go func() {
+		defer close(out)
+
+		for ctx.Err() == nil {
+			messages, err := queue.Receive(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("[watch] failed to receive S3 events for %s: %v", storeBox, err)
+					sleepContext(ctx, time.Second)
+				}
+				continue
+			}
+
+			for _, msg := range messages {
+				var notification s3EventNotification
+				if err := json.Unmarshal([]byte(msg.Body), &notification); err != nil {
+					log.Printf("[watch] skipping malformed S3 event: %v", err)
+				}
+
+				for _, record := range notification.Records {
+					if record.S3.Bucket.Name != s.properties.PhysicalBox(storeBox) {
+						continue
+					}
+					event, ok := eventFromRecord(s.properties.Label, record.EventName, record.EventTime, storeBox, record.S3.Object.Key)
+					if !ok {
+						continue
+					}
+					if !sendEvent(ctx, out, event, events) {
+						return
+					}
+				}
+
+				if err := queue.Delete(ctx, msg); err != nil && ctx.Err() == nil {
+					log.Printf("[watch] failed to delete S3 event message: %v", err)
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// s3EventNotification is the JSON document of an S3 event notification.
+type s3EventNotification struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		EventTime string `json:"eventTime"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// SetBoxPublicRead grants or revokes anonymous read access to the objects of a bucket.
+// Granting it lifts the public access block on bucket policies and replaces the bucket
+// policy by a generated one; revoking it removes the policy and blocks public access again.
+// Public access blocked at account level still prevents anonymous reads.
+func (s *S3Client) SetBoxPublicRead(ctx context.Context, storeBox string, public bool) error {
+	if err := s.readOnly.writable("access change"); err != nil {
+		return err
+	}
+	storeBox = s.properties.PhysicalBox(storeBox)
+	if public {
+		_, err := s.client.PutPublicAccessBlock(ctx, &s3.PutPublicAccessBlockInput{
+			Bucket: aws.String(storeBox),
+			PublicAccessBlockConfiguration: &types.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(true),
+				IgnorePublicAcls:      aws.Bool(true),
+				BlockPublicPolicy:     aws.Bool(false),
+				RestrictPublicBuckets: aws.Bool(false),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to put public access block: %w", err)
+		}
+
+		_, err = s.client.PutBucketPolicy(ctx, &s3.PutBucketPolicyInput{
+			Bucket: aws.String(storeBox),
+			Policy: aws.String(publicReadPolicy(storeBox)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to put bucket policy: %w", err)
+		}
+
+		return nil
+	}
+
+	_, err := s.client.DeleteBucketPolicy(ctx, &s3.DeleteBucketPolicyInput{Bucket: aws.String(storeBox)})
+	if err != nil {
+		return fmt.Errorf("failed to delete bucket policy: %w", err)
+	}
+
+	_, err = s.client.PutPublicAccessBlock(ctx, &s3.PutPublicAccessBlockInput{
+		Bucket: aws.String(storeBox),
+		PublicAccessBlockConfiguration: &types.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put public access block: %w", err)
+	}
+
+	return nil
+}
+
+// GetBoxAccess returns the anonymous access granted by the policy of a bucket, taking into
+// account the public access block of the bucket.
+func (s *S3Client) GetBoxAccess(ctx context.Context, storeBox string) (BoxAccess, error) {
+	storeBox = s.properties.PhysicalBox(storeBox)
+	output, err := s.client.GetBucketPolicy(ctx, &s3.GetBucketPolicyInput{Bucket: aws.String(storeBox)})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchBucketPolicy" {
+			return BoxAccess{}, nil
+		}
+		return BoxAccess{}, fmt.Errorf("failed to get bucket policy: %w", err)
+	}
+
+	access, err := policyAccess(aws.ToString(output.Policy))
+	if err != nil {
+		return BoxAccess{}, err
+	}
+
+	block, err := s.client.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{Bucket: aws.String(storeBox)})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchPublicAccessBlockConfiguration" {
+			return access, nil
+		}
+		return BoxAccess{}, fmt.Errorf("failed to get public access block: %w", err)
+	}
+	if block.PublicAccessBlockConfiguration != nil && aws.ToBool(block.PublicAccessBlockConfiguration.RestrictPublicBuckets) {
+		return BoxAccess{}, nil
+	}
+
+	return access, nil
+}
+
+// s3StorageClasses maps the storage tiers to S3 storage classes.
+var s3StorageClasses = map[common.StorageTier]types.StorageClass{
+	common.HOT_TIER:     types.StorageClassStandard,
+	common.COOL_TIER:    types.StorageClassStandardIa,
+	common.COLD_TIER:    types.StorageClassGlacierIr,
+	common.ARCHIVE_TIER: types.StorageClassGlacier,
+}
+
+// tierOfStorageClass returns the tier of an S3 storage class. Unknown classes, such as
+// MinIO remote tiers, hold objects that must be restored before being read.
+func tierOfStorageClass(class string) common.StorageTier {
+	switch types.StorageClass(class) {
+	case "", types.StorageClassStandard, types.StorageClassReducedRedundancy, types.StorageClassExpressOnezone:
+		return common.HOT_TIER
+	case types.StorageClassStandardIa, types.StorageClassOnezoneIa, types.StorageClassIntelligentTiering:
+		return common.COOL_TIER
+	case types.StorageClassGlacierIr:
+		return common.COLD_TIER
+	default:
+		return common.ARCHIVE_TIER
+	}
+}
+
+// s3RetentionModes maps the retention modes to the S3 Object Lock modes.
+var s3RetentionModes = map[common.RetentionMode]string{
+	common.GOVERNANCE_RETENTION: string(types.ObjectLockModeGovernance),
+	common.COMPLIANCE_RETENTION: string(types.ObjectLockModeCompliance),
+}
+
+// s3ChecksumAlgorithms maps the checksum algorithms to the S3 additional checksums.
+var s3ChecksumAlgorithms = map[common.ChecksumAlgorithm]types.ChecksumAlgorithm{
+	common.CRC32C_CHECKSUM: types.ChecksumAlgorithmCrc32c,
+	common.SHA1_CHECKSUM:   types.ChecksumAlgorithmSha1,
+	common.SHA256_CHECKSUM: types.ChecksumAlgorithmSha256,
+}
+
+// objectLockNotFoundCode is the error code of the buckets without object lock configuration.
+const objectLockNotFoundCode = "ObjectLockConfigurationNotFoundError"
+
+// retentionModeOf returns the retention mode of an S3 Object Lock mode.
+func retentionModeOf(mode string) common.RetentionMode {
+	for retention, s3Mode := range s3RetentionModes {
+		if strings.EqualFold(mode, s3Mode) {
+			return retention
+		}
+	}
+	return common.NO_RETENTION
+}
+
+// restoreHeaderPattern matches the fields of the x-amz-restore header, e.g.
+// ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT".
+var restoreHeaderPattern = regexp.MustCompile(`(ongoing-request|expiry-date)="([^"]*)"`)
+
+// parseRestoreHeader parses the x-amz-restore header of an object.
+func parseRestoreHeader(header string) (ongoing bool, expiry time.Time) {
+	for _, match := range restoreHeaderPattern.FindAllStringSubmatch(header, -1) {
+		switch match[1] {
+		case "ongoing-request":
+			ongoing = match[2] == "true"
+		case "expiry-date":
+			expiry, _ = time.Parse(http.TimeFormat, match[2])
+		}
+	}
+	return ongoing, expiry
+}
+
+// objectMetadata returns the user metadata of an object, reporting false when it does not exist.
+// HeadObject has no response body, so a missing object is reported by the status code only.
+func (s *S3Client) objectMetadata(ctx context.Context, storeBox string, fileName string) (map[string]string, bool, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(storeBox),
+		Key:    aws.String(fileName),
+	})
+	if err != nil {
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return head.Metadata, true, nil
+}
@@ -0,0 +1,58 @@
+package filestorage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/transform/compression"
+)
+
+// skipPoorCompression returns properties without compression when compressing reader is not
+// worth it, see ConnectionProperties.CompressionThreshold: the head of reader, up to
+// compression.SampleSize bytes, is compressed to estimate the ratio, which is exact for the
+// smaller payloads. The returned reader reads the whole payload: reader itself, rewound, when it
+// can seek, else the head read followed by the rest of reader.
+func skipPoorCompression(properties common.ConnectionProperties, reader io.Reader) (common.ConnectionProperties, io.Reader, error) {
+	if properties.SaveCompress == common.NO_COMPRESSION || properties.CompressionThreshold < 0 {
+		return properties, reader, nil
+	}
+	maxRatio := properties.CompressionThreshold
+	if maxRatio == 0 {
+		maxRatio = compression.DefaultMaxRatio
+	}
+
+	seeker, seekable := reader.(io.Seeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	sample := int64(compression.SampleSize)
+	if size := readerSize(reader); size >= 0 && size < sample {
+		sample = size
+	}
+	head := make([]byte, sample)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return properties, nil, fmt.Errorf("failed to sample the payload: %w", err)
+	}
+	head = head[:n]
+
+	if seekable {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return properties, nil, fmt.Errorf("failed to rewind the payload: %w", err)
+		}
+	} else {
+		reader = io.MultiReader(bytes.NewReader(head), reader)
+	}
+
+	if !compression.Compressible(head, maxRatio) {
+		properties.SaveCompress = common.NO_COMPRESSION
+	}
+	return properties, reader, nil
+}
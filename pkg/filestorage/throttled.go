@@ -0,0 +1,142 @@
+package filestorage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrThrottled marks a failure of a storage throttling the requests, e.g. for storages wrapping
+// other services; see IsThrottled.
+var ErrThrottled = errors.New("storage throttled")
+
+// ThrottledError is a throttling failure carrying the delay the service asked to wait before
+// retrying, e.g. for storages wrapping other services. It wraps ErrThrottled and Err.
+type ThrottledError struct {
+	RetryAfter time.Duration // Delay to wait before retrying, 0 when not given
+	Err        error         // Failure reported by the service, if any
+}
+
+func (e *ThrottledError) Error() string {
+	msg := ErrThrottled.Error()
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(", retry after %v", e.RetryAfter)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ThrottledError) Unwrap() []error {
+	return []error{ErrThrottled, e.Err}
+}
+
+// throttlingCodes are the error codes of the providers throttling the requests.
+var throttlingCodes = []string{"SlowDown", "SlowDownRead", "SlowDownWrite", "ServerBusy", "TooManyRequests", "RequestLimitExceeded"}
+
+// IsThrottled reports whether err is the throttling of the requests by a provider: the HTTP 429
+// answers, the throttling error codes of the providers, e.g. SlowDown for S3 and ServerBusy for
+// Azure, and errors wrapping ErrThrottled. An HTTP 503 is throttling only with one of these codes
+// or a Retry-After header, otherwise it reports an unavailable storage. Throttling is transient,
+// see IsRetriable, and reports an overloaded storage rather than a failed one.
+func IsThrottled(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrThrottled) {
+		return true
+	}
+
+	var minioErr minio.ErrorResponse
+	if errors.As(err, &minioErr) {
+		return minioErr.StatusCode == http.StatusTooManyRequests || throttlingCode(minioErr.Code)
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && throttlingCode(apiErr.ErrorCode()) {
+		return true
+	}
+	var s3Err *awshttp.ResponseError
+	if errors.As(err, &s3Err) {
+		var header http.Header
+		if s3Err.Response != nil && s3Err.Response.Response != nil {
+			header = s3Err.Response.Header
+		}
+		return throttlingStatus(s3Err.HTTPStatusCode(), header)
+	}
+	var azErr *azcore.ResponseError
+	if errors.As(err, &azErr) {
+		var header http.Header
+		if azErr.RawResponse != nil {
+			header = azErr.RawResponse.Header
+		}
+		return throttlingStatus(azErr.StatusCode, header) || throttlingCode(azErr.ErrorCode)
+	}
+	return false
+}
+
+// RetryAfter returns the delay a throttling provider asked to wait before retrying: the one of a
+// ThrottledError or of the Retry-After header of the answer, in seconds or as an HTTP date. It
+// reports false when err does not carry any.
+func RetryAfter(err error) (time.Duration, bool) {
+	var throttledErr *ThrottledError
+	if errors.As(err, &throttledErr) && throttledErr.RetryAfter > 0 {
+		return throttledErr.RetryAfter, true
+	}
+
+	var header http.Header
+	var s3Err *awshttp.ResponseError
+	var azErr *azcore.ResponseError
+	switch {
+	case errors.As(err, &s3Err) && s3Err.Response != nil && s3Err.Response.Response != nil:
+		header = s3Err.Response.Header
+	case errors.As(err, &azErr) && azErr.RawResponse != nil:
+		header = azErr.RawResponse.Header
+	}
+	return parseRetryAfter(header.Get("Retry-After"))
+}
+
+// parseRetryAfter parses the value of a Retry-After header.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// throttlingStatus reports whether an HTTP answer with the given status code and header is the
+// throttling of the requests: a 429, or a 503 asking to retry later.
+func throttlingStatus(code int, header http.Header) bool {
+	switch code {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		return header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// throttlingCode reports whether an error code of a provider is the throttling of the requests.
+func throttlingCode(code string) bool {
+	for _, c := range throttlingCodes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	common "github.com/tizianocitro/m2cs/pkg"
+)
+
+// ErrWatchNotSupported is returned by Watch when the storage cannot push events, e.g. an
+// S3Client without an event queue. Such storages can be watched with PollWatch.
+var ErrWatchNotSupported = errors.New("push notifications not supported")
+
+// DefaultPollInterval is the interval between two listings of PollWatch when none is given.
+const DefaultPollInterval = 10 * time.Second
+
+// ObjectEvent is a change of an object reported by a storage.
+// Label is the label of the storage reporting the change.
+type ObjectEvent struct {
+	Label    string
+	StoreBox string
+	Key      string
+	Type     common.EventType
+	Time     time.Time
+}
+
+// Watcher is implemented by storages able to push the changes of the objects of a store box.
+// An empty events slice watches every event type. The channel is closed when ctx is done.
+type Watcher interface {
+	Watch(ctx context.Context, storeBox string, events []common.EventType) (<-chan ObjectEvent, error)
+}
+
+// QueueMessage is a message received from an EventQueue. Handle identifies the message
+// when it is deleted, e.g. an SQS receipt handle.
+type QueueMessage struct {
+	Handle string
+	Body   string
+}
+
+// EventQueue is a queue receiving the event notifications of a bucket as JSON messages,
+// e.g. an SQS queue configured as the notification destination of an S3 bucket.
+// Receive waits for the next messages, and Delete acknowledges a processed message.
+type EventQueue interface {
+	Receive(ctx context.Context) ([]QueueMessage, error)
+	Delete(ctx context.Context, msg QueueMessage) error
+}
+
+// PollWatch watches a store box by listing it every interval and reporting the differences
+// with the previous listing. Objects existing when the watch starts are not reported, and
+// changes undone between two listings are missed.
+// The channel is closed when ctx is done.
+func PollWatch(ctx context.Context, lister ObjectLister, label, storeBox string, events []common.EventType, interval time.Duration) (<-chan ObjectEvent, error) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	snapshot, err := listSnapshot(ctx, lister, storeBox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list store box %s: %w", storeBox, err)
+	}
+
+	out := make(chan ObjectEvent)
+	go func() {
+		defer close(out)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current, err := listSnapshot(ctx, lister, storeBox)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("[watch] failed to list store box %s on %s: %v", storeBox, label, err)
+				}
+				continue
+			}
+
+			now := time.Now()
+			for key, info := range current {
+				if previous, ok := snapshot[key]; ok && previous.ETag == info.ETag && previous.LastModified.Equal(info.LastModified) {
+					continue
+				}
+				event := ObjectEvent{Label: label, StoreBox: storeBox, Key: key, Type: common.OBJECT_CREATED, Time: info.LastModified}
+				if !sendEvent(ctx, out, event, events) {
+					return
+				}
+			}
+			for key := range snapshot {
+				if _, ok := current[key]; ok {
+					continue
+				}
+				event := ObjectEvent{Label: label, StoreBox: storeBox, Key: key, Type: common.OBJECT_REMOVED, Time: now}
+				if !sendEvent(ctx, out, event, events) {
+					return
+				}
+			}
+
+			snapshot = current
+		}
+	}()
+
+	return out, nil
+}
+
+// listSnapshot returns the objects of a store box keyed by name.
+func listSnapshot(ctx context.Context, lister ObjectLister, storeBox string) (map[string]ObjectInfo, error) {
+	infos, err := lister.ListObjectsInfo(ctx, storeBox, "")
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string]ObjectInfo, len(infos))
+	for _, info := range infos {
+		snapshot[info.Key] = info
+	}
+	return snapshot, nil
+}
+
+// sendEvent delivers the event on out if its type is watched. It returns false when ctx
+// is done before the event is received.
+func sendEvent(ctx context.Context, out chan<- ObjectEvent, event ObjectEvent, events []common.EventType) bool {
+	if !watchesEvent(events, event.Type) {
+		return true
+	}
+
+	select {
+	case out <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// watchesEvent reports whether t is one of the watched events; an empty slice watches all.
+func watchesEvent(events []common.EventType, t common.EventType) bool {
+	if len(events) == 0 {
+		return true
+	}
+	for _, e := range events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// eventFromRecord converts an S3 event notification record, as sent by S3 and MinIO, into
+// an ObjectEvent. It returns false for records other than object creations and removals.
+func eventFromRecord(label, eventName, eventTime, storeBox, key string) (ObjectEvent, bool) {
+	event := ObjectEvent{Label: label, StoreBox: storeBox, Key: key}
+
+	switch {
+	case strings.Contains(eventName, "ObjectCreated:"):
+		event.Type = common.OBJECT_CREATED
+	case strings.Contains(eventName, "ObjectRemoved:"):
+		event.Type = common.OBJECT_REMOVED
+	default:
+		return ObjectEvent{}, false
+	}
+
+	// keys are URL encoded in the records
+	if unescaped, err := url.QueryUnescape(key); err == nil {
+		event.Key = unescaped
+	}
+
+	event.Time = time.Now()
+	if t, err := time.Parse(time.RFC3339, eventTime); err == nil {
+		event.Time = t
+	}
+
+	return event, true
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,181 @@
+package storagetest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tizianocitro/m2cs/internal/clock"
+)
+
+// FakeClock is a clock whose time only moves when advanced, to be passed to m2cs.WithClock so that
+// the tests of the expirations, the retries and the cache validation run without sleeping. Its
+// tickers, After channels and sleeps fire when Advance moves the time past their deadline. It is
+// safe for concurrent use.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond // Signaled when a waiter is added, see BlockUntil
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+var _ clock.Clock = (*FakeClock)(nil)
+
+// fakeWaiter is a ticker, or an After channel when its period is zero.
+type fakeWaiter struct {
+	at     time.Time
+	period time.Duration
+	ch     chan time.Time
+}
+
+// NewFakeClock returns a FakeClock telling now until advanced.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the time of the clock forward by d, firing in order the tickers and After
+// channels whose deadline is reached. Like the ones of the time package, a ticker whose tick was
+// not received drops the following ones.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	target := c.now.Add(d)
+	for {
+		sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+		if len(c.waiters) == 0 || c.waiters[0].at.After(target) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.at
+		select {
+		case w.ch <- c.now:
+		default:
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+	}
+	c.now = target
+}
+
+// BlockUntil waits until at least n tickers, After channels and sleeps wait for the clock, e.g.
+// for the goroutine under test to start waiting before advancing the clock.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// BlockUntilContext is BlockUntil returning ctx.Err() once ctx is done, e.g. for a goroutine
+// advancing the clock while the waiters come and go, until the test stops it.
+func (c *FakeClock) BlockUntilContext(ctx context.Context, n int) error {
+	stop := context.AfterFunc(ctx, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.cond.Broadcast()
+	})
+	defer stop()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.cond.Wait()
+	}
+	return nil
+}
+
+// After returns a channel receiving the time of the clock once advanced by d.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.addLocked(&fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Sleep blocks until the clock is advanced by d.
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// NewTicker returns a ticker ticking every time the clock is advanced by d. It panics when d is
+// not positive, like time.NewTicker.
+func (c *FakeClock) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTicker{clock: c, w: &fakeWaiter{at: c.now.Add(d), period: d, ch: make(chan time.Time, 1)}}
+	c.addLocked(t.w)
+	return t
+}
+
+// addLocked adds a waiter. The caller must hold c.mu.
+func (c *FakeClock) addLocked(w *fakeWaiter) {
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+}
+
+// removeLocked removes a waiter, if waiting. The caller must hold c.mu.
+func (c *FakeClock) removeLocked(w *fakeWaiter) {
+	for i, waiter := range c.waiters {
+		if waiter == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	w     *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.w.ch
+}
+
+// Reset makes the ticker tick every d from the current time of the clock, restarting it if stopped.
+func (t *fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock ticker Reset")
+	}
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	t.clock.removeLocked(t.w)
+	t.w.at, t.w.period = t.clock.now.Add(d), d
+	t.clock.addLocked(t.w)
+}
+
+// Stop stops the ticker, which receives no more ticks.
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.clock.removeLocked(t.w)
+}
@@ -0,0 +1,29 @@
+package storagetest
+
+import (
+	"context"
+	"testing"
+
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// NewMemory returns a main MemoryClient labeled label, holding the given store boxes, to be
+// decorated and passed to a FileClient by a test. A failure to create a store box fails tb.
+func NewMemory(tb testing.TB, label string, boxes ...string) *filestorage.MemoryClient {
+	tb.Helper()
+	return NewMemoryWithProperties(tb, common.ConnectionProperties{Label: label, IsMainInstance: true}, boxes...)
+}
+
+// NewMemoryWithProperties is NewMemory with the given properties, e.g. for a replica or a storage
+// saving the objects compressed.
+func NewMemoryWithProperties(tb testing.TB, props common.ConnectionProperties, boxes ...string) *filestorage.MemoryClient {
+	tb.Helper()
+	memory := filestorage.NewMemoryClient(props)
+	for _, box := range boxes {
+		if err := memory.MakeBucket(context.Background(), box); err != nil {
+			tb.Fatalf("failed to create store box %q: %v", box, err)
+		}
+	}
+	return memory
+}
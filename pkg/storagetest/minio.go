@@ -0,0 +1,172 @@
+package storagetest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// StoredObject is an object held by a MinioServer, with the headers it was written with.
+type StoredObject struct {
+	Data   []byte
+	Header http.Header // Metadata (X-Amz-Meta-*), Content-Type and Content-Encoding headers
+}
+
+// MinioServer is a fake MinIO server holding every store box, to test a MinioClient connected to
+// its URL without a MinIO instance. It serves the writes, heads and reads of the objects, the
+// latter honoring the Range header, and accepts every other request, e.g. the probes of the store
+// boxes, without effect. It is safe for concurrent use.
+type MinioServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]StoredObject // By store box and key
+}
+
+// NewMinioServer starts a MinioServer holding no object, closed when tb finishes.
+func NewMinioServer(tb testing.TB) *MinioServer {
+	s := &MinioServer{objects: make(map[string]StoredObject)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Object returns the object stored at path, made of its store box and key, e.g. "box/key", as
+// written by the client; it is empty when there is none.
+func (s *MinioServer) Object(path string) StoredObject {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[path]
+}
+
+// SetObject stores obj at path, made of its store box and key, e.g. to copy an object as is.
+func (s *MinioServer) SetObject(path string, obj StoredObject) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[path] = obj
+}
+
+func (s *MinioServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if _, name, _ := strings.Cut(path, "/"); name == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := readPayload(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		header := make(http.Header)
+		for name, values := range r.Header {
+			if strings.HasPrefix(name, "X-Amz-Meta-") || name == "Content-Type" || name == "Content-Encoding" {
+				header[name] = values
+			}
+		}
+		s.objects[path] = StoredObject{Data: data, Header: header}
+		w.Header().Set("ETag", `"0123456789abcdef0123456789abcdef"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodHead, http.MethodGet:
+		obj, ok := s.objects[path]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for name, values := range obj.Header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("ETag", `"0123456789abcdef0123456789abcdef"`)
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+
+		data, status := obj.Data, http.StatusOK
+		if spec := r.Header.Get("Range"); spec != "" && r.Method == http.MethodGet {
+			start, end, ok := parseRange(spec, int64(len(obj.Data)))
+			if !ok {
+				writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.Data)))
+			data, status = obj.Data[start:end+1], http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// writeError writes the S3 error answer with the given status and code.
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// parseRange parses a Range header of the form bytes=start-end or bytes=start- into the first and
+// last byte it asks for of an object of the given size. It reports false when the range is not
+// satisfiable or not of these forms.
+func parseRange(spec string, size int64) (int64, int64, bool) {
+	first, last, ok := strings.Cut(strings.TrimPrefix(spec, "bytes="), "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
+
+// readPayload reads the payload of a put, decoding the aws-chunked encoding of the streaming
+// signatures minio-go uses without TLS.
+func readPayload(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var data bytes.Buffer
+	body := bufio.NewReader(r.Body)
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return data.Bytes(), nil
+		}
+		if _, err := io.CopyN(&data, body, n); err != nil {
+			return nil, err
+		}
+		if _, err := body.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
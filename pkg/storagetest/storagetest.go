@@ -0,0 +1,370 @@
+// Package storagetest provides decorators of filestorage.FileStorage for tests: they add latency,
+// inject failures and record the operations of any storage, e.g. of a filestorage.MemoryClient,
+// so that the behavior of a FileClient can be tested without real backends. FakeClock drives the
+// time of a FileClient, see m2cs.WithClock, so that the tests of time-dependent behavior do not sleep.
+//
+// The decorated storages only implement filestorage.FileStorage: the optional interfaces of the
+// wrapped storage, such as filestorage.OptionsPutter, are hidden, so that every write and read
+// goes through the decorators. All the decorators are safe for concurrent use.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tizianocitro/m2cs/internal/clock"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// ErrInjected is the failure injected by Chaos, and by FailNTimes and FailMatching without an error.
+var ErrInjected = errors.New("injected storage failure")
+
+// Operation is an operation of filestorage.FileStorage.
+type Operation int
+
+const (
+	GET_OBJECT Operation = iota
+	PUT_OBJECT
+	REMOVE_OBJECT
+	EXIST_OBJECT
+)
+
+// String returns the name of the method of the operation.
+func (o Operation) String() string {
+	switch o {
+	case GET_OBJECT:
+		return "GetObject"
+	case PUT_OBJECT:
+		return "PutObject"
+	case REMOVE_OBJECT:
+		return "RemoveObject"
+	case EXIST_OBJECT:
+		return "ExistObject"
+	default:
+		return fmt.Sprintf("Operation(%d)", int(o))
+	}
+}
+
+// Call describes an operation received by a decorated storage.
+type Call struct {
+	Op       Operation
+	StoreBox string
+	Key      string
+}
+
+// Decorator wraps a storage, returning the decorated storage.
+type Decorator func(filestorage.FileStorage) filestorage.FileStorage
+
+// Wrap applies the decorators to s, the first one being the outermost: with a recorder r,
+// Wrap(s, r.Decorator(""), Latency(d)) records the operations with their latency, while
+// Wrap(s, Latency(d), r.Decorator("")) records them without.
+func Wrap(s filestorage.FileStorage, decorators ...Decorator) filestorage.FileStorage {
+	for i := len(decorators) - 1; i >= 0; i-- {
+		s = decorators[i](s)
+	}
+	return s
+}
+
+// Latency delays every operation by d before it reaches the storage. The delay is interrupted,
+// failing the operation, when the context of the operation is done.
+func Latency(d time.Duration) Decorator {
+	return LatencyWithClock(clock.Real, d)
+}
+
+// LatencyWithClock is Latency waiting for d to pass on c, e.g. on a FakeClock, so that the
+// operations of a test complete when the clock is advanced instead of after a real delay.
+func LatencyWithClock(c clock.Clock, d time.Duration) Decorator {
+	return around(func(ctx context.Context, call Call, next func() error) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.After(d):
+		}
+		return next()
+	})
+}
+
+// FailNTimes fails the first n operations with err, or ErrInjected when err is nil, without them
+// reaching the storage; the following operations are not affected.
+func FailNTimes(n int, err error) Decorator {
+	err = injected(err)
+	return func(s filestorage.FileStorage) filestorage.FileStorage {
+		var calls atomic.Int64
+		return around(func(ctx context.Context, call Call, next func() error) error {
+			if calls.Add(1) <= int64(n) {
+				return err
+			}
+			return next()
+		})(s)
+	}
+}
+
+// FailMatching fails the operations on the keys matching pattern, with the syntax of path.Match,
+// with err, or ErrInjected when err is nil, without them reaching the storage. A malformed
+// pattern fails every operation with path.ErrBadPattern.
+func FailMatching(pattern string, err error) Decorator {
+	err = injected(err)
+	return around(func(ctx context.Context, call Call, next func() error) error {
+		matched, matchErr := path.Match(pattern, call.Key)
+		if matchErr != nil {
+			return matchErr
+		}
+		if matched {
+			return err
+		}
+		return next()
+	})
+}
+
+// Chaos fails each operation with ErrInjected with the given probability, in [0, 1], without it
+// reaching the storage. The failures are drawn from a source seeded with seed, so that a test
+// running the operations in the same order sees the same failures.
+func Chaos(probability float64, seed int64) Decorator {
+	return func(s filestorage.FileStorage) filestorage.FileStorage {
+		var mu sync.Mutex
+		random := rand.New(rand.NewSource(seed))
+		return around(func(ctx context.Context, call Call, next func() error) error {
+			mu.Lock()
+			fail := random.Float64() < probability
+			mu.Unlock()
+			if fail {
+				return fmt.Errorf("%w: %v on %s/%s", ErrInjected, call.Op, call.StoreBox, call.Key)
+			}
+			return next()
+		})(s)
+	}
+}
+
+// Gate holds the operations of the storages decorated by its Decorator while it is held, e.g. to
+// keep the background replications to a storage in flight while a test checks the backlog. A held
+// operation fails when its context is done.
+type Gate struct {
+	mu      sync.Mutex
+	release chan struct{} // Closed when the gate is released
+}
+
+// NewGate returns a Gate holding the operations until Release.
+func NewGate() *Gate {
+	return &Gate{release: make(chan struct{})}
+}
+
+// Decorator returns a decorator holding the operations of a storage while the gate is held.
+func (g *Gate) Decorator() Decorator {
+	return around(func(ctx context.Context, call Call, next func() error) error {
+		g.mu.Lock()
+		release := g.release
+		g.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-release:
+		}
+		return next()
+	})
+}
+
+// Hold holds the next operations, until Release.
+func (g *Gate) Hold() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.release:
+		g.release = make(chan struct{})
+	default:
+	}
+}
+
+// Release lets the held operations, and the next ones, reach the storage.
+func (g *Gate) Release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.release:
+	default:
+		close(g.release)
+	}
+}
+
+// Outage fails the operations of the storages decorated by its Decorator while it is down, without
+// them reaching the storage, e.g. to take a storage offline in the middle of a test.
+type Outage struct {
+	err  error
+	down atomic.Bool
+}
+
+// NewOutage returns an Outage failing the operations with err, or ErrInjected when err is nil,
+// once Down is called.
+func NewOutage(err error) *Outage {
+	return &Outage{err: injected(err)}
+}
+
+// Decorator returns a decorator failing the operations of a storage while the outage is down.
+func (o *Outage) Decorator() Decorator {
+	return around(func(ctx context.Context, call Call, next func() error) error {
+		if o.down.Load() {
+			return o.err
+		}
+		return next()
+	})
+}
+
+// Down fails the next operations, until Up.
+func (o *Outage) Down() {
+	o.down.Store(true)
+}
+
+// Up lets the next operations reach the storage.
+func (o *Outage) Up() {
+	o.down.Store(false)
+}
+
+// Record is an operation recorded by a Recorder.
+type Record struct {
+	Call
+	Storage  string        // Name of the storage, see Recorder.Decorator
+	Start    time.Time     // Time the operation reached the decorator
+	Duration time.Duration // Time taken by the operation
+	Err      error         // Failure of the operation, if any
+}
+
+// Recorder records the operations of the storages decorated by its Decorator, in the order they
+// complete. The same recorder can decorate several storages, to record the sequence of their
+// operations.
+type Recorder struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Decorator returns a decorator recording the operations of a storage with the given name,
+// or with the label of the storage when name is empty.
+func (r *Recorder) Decorator(name string) Decorator {
+	return func(s filestorage.FileStorage) filestorage.FileStorage {
+		storage := name
+		if storage == "" {
+			storage = s.GetConnectionProperties().Label
+		}
+		return around(func(ctx context.Context, call Call, next func() error) error {
+			start := time.Now()
+			err := next()
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.records = append(r.records, Record{Call: call, Storage: storage, Start: start, Duration: time.Since(start), Err: err})
+			return err
+		})(s)
+	}
+}
+
+// Records returns the recorded operations.
+func (r *Recorder) Records() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record(nil), r.records...)
+}
+
+// Count returns the number of recorded operations op of the named storage, any storage when
+// storage is empty.
+func (r *Recorder) Count(storage string, op Operation) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, record := range r.records {
+		if record.Op == op && (storage == "" || record.Storage == storage) {
+			n++
+		}
+	}
+	return n
+}
+
+// Successes returns the names of the storages of the successful operations op, in order.
+func (r *Recorder) Successes(op Operation) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var storages []string
+	for _, record := range r.records {
+		if record.Op == op && record.Err == nil {
+			storages = append(storages, record.Storage)
+		}
+	}
+	return storages
+}
+
+// Reset removes the recorded operations.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = nil
+}
+
+// injected returns err, or ErrInjected when err is nil.
+func injected(err error) error {
+	if err == nil {
+		return ErrInjected
+	}
+	return err
+}
+
+// around returns a decorator running every operation through run, which calls next to
+// forward the operation to the storage.
+func around(run func(ctx context.Context, call Call, next func() error) error) Decorator {
+	return func(s filestorage.FileStorage) filestorage.FileStorage {
+		return &decorated{inner: s, run: run}
+	}
+}
+
+// decorated is a storage whose operations go through run.
+type decorated struct {
+	inner filestorage.FileStorage
+	run   func(ctx context.Context, call Call, next func() error) error
+}
+
+func (d *decorated) GetObject(ctx context.Context, storeBox string, fileName string) (obj io.ReadCloser, err error) {
+	err = d.run(ctx, Call{Op: GET_OBJECT, StoreBox: storeBox, Key: fileName}, func() (err error) {
+		obj, err = d.inner.GetObject(ctx, storeBox, fileName)
+		return err
+	})
+	if err != nil && obj != nil {
+		_ = obj.Close()
+		obj = nil
+	}
+	return obj, err
+}
+
+func (d *decorated) PutObject(ctx context.Context, storeBox string, fileName string, reader io.Reader) error {
+	return d.run(ctx, Call{Op: PUT_OBJECT, StoreBox: storeBox, Key: fileName}, func() error {
+		return d.inner.PutObject(ctx, storeBox, fileName, reader)
+	})
+}
+
+func (d *decorated) RemoveObject(ctx context.Context, storeBox string, fileName string) error {
+	return d.run(ctx, Call{Op: REMOVE_OBJECT, StoreBox: storeBox, Key: fileName}, func() error {
+		return d.inner.RemoveObject(ctx, storeBox, fileName)
+	})
+}
+
+func (d *decorated) ExistObject(ctx context.Context, storeBox string, fileName string) (exists bool, err error) {
+	err = d.run(ctx, Call{Op: EXIST_OBJECT, StoreBox: storeBox, Key: fileName}, func() (err error) {
+		exists, err = d.inner.ExistObject(ctx, storeBox, fileName)
+		return err
+	})
+	if err != nil {
+		exists = false
+	}
+	return exists, err
+}
+
+func (d *decorated) GetConnectionProperties() common.ConnectionProperties {
+	return d.inner.GetConnectionProperties()
+}
@@ -0,0 +1,140 @@
+package transform
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	common "github.com/tizianocitro/m2cs/pkg"
+)
+
+// Cache holds the pipelines of a backend client, built once from its properties instead of
+// on every read and write. The pipelines are rebuilt when the encryption key is replaced by
+// SetKey. A Cache is safe for concurrent use.
+type Cache struct {
+	props   common.ConnectionProperties
+	current atomic.Pointer[pipelines]
+	boxes   map[string]*Cache // Pipelines of the store boxes with their own key, by physical name
+}
+
+// pipelines are the pipelines built with a key, lazily on first use.
+type pipelines struct {
+	key  string
+	raw  []byte // Raw key, used when key is empty, see ConnectionProperties.EncryptKeyBytes
+	once sync.Once
+
+	write    WritePipeline
+	writeErr error
+	read     ReadPipeline // For the payloads stored as they were transformed
+	readGzip ReadPipeline // For the payloads still reported as gzip encoded, see BuildRPipelineWithEncoding
+	readErr  error
+
+	variants sync.Map // *pipelines of the same key, by variant, see ConnectionProperties.TransformRules
+}
+
+// variant is the compression and the encryption of pipelines other than the ones of the props of
+// the Cache.
+type variant struct {
+	compress common.CompressionAlgorithm
+	encrypt  common.EncryptionAlgorithm
+}
+
+// NewCache returns a Cache of the pipelines of props, encrypting with props.EncryptKey, or
+// props.EncryptKeyBytes, and the store boxes of props.EncryptKeysByBox with their own key. The
+// read pipelines also try props.DecryptKeys, and props.EncryptOnly applies to the keys of the
+// store boxes too.
+func NewCache(props common.ConnectionProperties) *Cache {
+	c := &Cache{props: props}
+	c.current.Store(&pipelines{key: props.EncryptKey, raw: props.EncryptKeyBytes})
+	for box, key := range props.EncryptKeysByBox {
+		if c.boxes == nil {
+			c.boxes = make(map[string]*Cache, len(props.EncryptKeysByBox))
+		}
+		boxProps := props
+		boxProps.EncryptKey, boxProps.EncryptKeyBytes, boxProps.EncryptKeysByBox = key, nil, nil
+		c.boxes[props.PhysicalBox(box)] = NewCache(boxProps)
+	}
+	return c
+}
+
+// Box returns the Cache of the pipelines of the physical store box box: the one of its own key,
+// see ConnectionProperties.EncryptKeysByBox, or c.
+func (c *Cache) Box(box string) *Cache {
+	if boxCache, ok := c.boxes[box]; ok {
+		return boxCache
+	}
+	return c
+}
+
+// Key returns the current encryption key.
+func (c *Cache) Key() string {
+	return c.current.Load().key
+}
+
+// KeyBytes returns the current raw encryption key, nil once replaced by SetKey.
+func (c *Cache) KeyBytes() []byte {
+	return c.current.Load().raw
+}
+
+// SetKey replaces the encryption key, raw ones included, of the pipelines returned afterwards.
+// The pipelines returned before keep the previous key, and the store boxes with their own key,
+// see Box, keep it.
+func (c *Cache) SetKey(key string) error {
+	if key == "" && c.props.SaveEncrypt == common.AES256_ENCRYPTION {
+		return fmt.Errorf("missing encryption key for AES256_ENCRYPTION")
+	}
+	c.current.Store(&pipelines{key: key})
+	return nil
+}
+
+// Write returns the write pipeline, see Factory.BuildWPipelineCompressEncrypt.
+func (c *Cache) Write() (WritePipeline, error) {
+	return c.WriteWith(c.props.SaveCompress, c.props.SaveEncrypt)
+}
+
+// WriteWith returns the write pipeline compressing with compress and encrypting with encrypt,
+// instead of the algorithms of the properties, e.g. for an object selected by a TransformRule.
+func (c *Cache) WriteWith(compress common.CompressionAlgorithm, encrypt common.EncryptionAlgorithm) (WritePipeline, error) {
+	p := c.built(compress, encrypt)
+	return p.write, p.writeErr
+}
+
+// Read returns the read pipeline of a payload with the given Content-Encoding, see
+// Factory.BuildRPipelineWithEncoding.
+func (c *Cache) Read(contentEncoding string) (ReadPipeline, error) {
+	return c.ReadWith(c.props.SaveCompress, c.props.SaveEncrypt, contentEncoding)
+}
+
+// ReadWith returns the read pipeline of a payload with the given Content-Encoding, saved with
+// compress and encrypt instead of the algorithms of the properties, see WriteWith.
+func (c *Cache) ReadWith(compress common.CompressionAlgorithm, encrypt common.EncryptionAlgorithm, contentEncoding string) (ReadPipeline, error) {
+	p := c.built(compress, encrypt)
+	if p.readErr != nil {
+		return ReadPipeline{}, p.readErr
+	}
+	if IsGzipEncoded(contentEncoding) {
+		return p.readGzip, nil
+	}
+	return p.read, nil
+}
+
+// built returns the current pipelines of compress and encrypt, building them on first use.
+func (c *Cache) built(compress common.CompressionAlgorithm, encrypt common.EncryptionAlgorithm) *pipelines {
+	p := c.current.Load()
+	if compress != c.props.SaveCompress || encrypt != c.props.SaveEncrypt {
+		v, _ := p.variants.LoadOrStore(variant{compress, encrypt}, &pipelines{key: p.key, raw: p.raw})
+		p = v.(*pipelines)
+	}
+	p.once.Do(func() {
+		var f Factory
+		props := c.props
+		props.SaveCompress, props.SaveEncrypt = compress, encrypt
+		props.EncryptKeyBytes = p.raw
+		p.write, p.writeErr = f.BuildWPipelineCompressEncrypt(props, p.key)
+		p.read, p.readErr = f.BuildRPipelineWithEncoding(props, p.key, "")
+		if p.readErr == nil {
+			p.readGzip, p.readErr = f.BuildRPipelineWithEncoding(props, p.key, ContentEncodingGzip)
+		}
+	})
+	return p
+}
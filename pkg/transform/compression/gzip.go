@@ -5,37 +5,144 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/internal/bufpool"
+)
+
+// writers and readers recycle the gzip states, whose allocation dominates the transforms of
+// small payloads.
+var (
+	writers = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	readers sync.Pool
 )
 
 type GzipCompress struct{}
 
 func (*GzipCompress) Name() string { return "gzip-compress" }
 
+// Apply compresses r into a pooled buffer, returned to the pool by the returned closer.
 func (*GzipCompress) Apply(r io.Reader) (io.Reader, io.Closer, error) {
-	var buf bytes.Buffer
-	zw := gzip.NewWriter(&buf)
+	buf := bufpool.Default.Get()
+	zw := writers.Get().(*gzip.Writer)
+	zw.Reset(buf)
+	defer writers.Put(zw)
 
 	if _, err := io.Copy(zw, r); err != nil {
 		_ = zw.Close()
+		bufpool.Default.Put(buf)
 		return nil, nil, fmt.Errorf("gzip: copy: %w", err)
 	}
 	if err := zw.Close(); err != nil {
+		bufpool.Default.Put(buf)
 		return nil, nil, fmt.Errorf("gzip: close: %w", err)
 	}
 
-	return bytes.NewReader(buf.Bytes()), io.NopCloser(nil), nil
+	return bytes.NewReader(buf.Bytes()), &pooledBuffer{buf: buf}, nil
 }
 
 type GzipDecompress struct{}
 
 func (GzipDecompress) Name() string { return "gzip-decompress" }
 
+// Apply returns a reader decompressing readerCloser, which is closed along with it. The gzip
+// reader is taken from a pool, and returned to it by the first Close of the returned reader; it is
+// dropped when readerCloser is not gzip data.
 func (GzipDecompress) Apply(readerCloser io.ReadCloser) (io.ReadCloser, error) {
-	gr, err := gzip.NewReader(readerCloser)
+	gr, ok := readers.Get().(*gzip.Reader)
+	var err error
+	if ok {
+		err = gr.Reset(readerCloser)
+	} else {
+		gr, err = gzip.NewReader(readerCloser)
+	}
 	if err != nil {
 		_ = readerCloser.Close()
 		return nil, fmt.Errorf("gzip: %w", err)
 	}
 
-	return gr, nil
+	return &pooledReader{zr: gr, src: readerCloser}, nil
+}
+
+// pooledReader closes its source and returns the gzip reader to the pool on the first Close.
+// It does not expose the gzip reader, which another Apply may use once returned: the reads after
+// Close fail with fs.ErrClosed.
+type pooledReader struct {
+	mu   sync.Mutex
+	zr   *gzip.Reader // nil once returned to the pool
+	src  io.ReadCloser
+	once sync.Once
+}
+
+func (p *pooledReader) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.zr == nil {
+		return 0, fmt.Errorf("gzip: read after close: %w", fs.ErrClosed)
+	}
+	return p.zr.Read(b)
+}
+
+func (p *pooledReader) Close() error {
+	var err error
+	p.once.Do(func() {
+		// the source is closed first, so that a Read blocked on it returns and releases the reader
+		srcErr := p.src.Close()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		err = p.zr.Close()
+		if err == nil {
+			err = srcErr
+		}
+		readers.Put(p.zr)
+		p.zr = nil
+	})
+	return err
+}
+
+// pooledBuffer returns buf to the pool on the first Close.
+type pooledBuffer struct {
+	once sync.Once
+	buf  *bytes.Buffer
+}
+
+func (p *pooledBuffer) Close() error {
+	p.once.Do(func() { bufpool.Default.Put(p.buf) })
+	return nil
+}
+
+// SampleSize is the size of the head of the payloads compressed by Compressible to decide
+// whether to compress them.
+const SampleSize = 64 << 10
+
+// DefaultMaxRatio is the default of the maximum ratio of the compressed to the original size
+// of the payloads stored compressed, see Compressible.
+const DefaultMaxRatio = 0.97
+
+// Compressible reports whether gzip compresses sample to at most maxRatio times its size, e.g.
+// false for random or already compressed data, which gzip inflates. An empty sample is
+// compressible.
+func Compressible(sample []byte, maxRatio float64) bool {
+	if len(sample) == 0 {
+		return true
+	}
+
+	var size countingWriter
+	zw := writers.Get().(*gzip.Writer)
+	zw.Reset(&size)
+	defer writers.Put(zw)
+	_, _ = zw.Write(sample)
+	_ = zw.Close()
+
+	return float64(size) <= maxRatio*float64(len(sample))
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
 }
@@ -8,91 +8,226 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"io/fs"
+	"slices"
+	"sync"
+
+	"github.com/tizianocitro/m2cs/internal/bufpool"
 )
 
 type AESGCMEncrypt struct {
-	Key string
+	Key  string
+	aead cipher.AEAD // Derived from Key by NewAESGCMEncrypt, else on every Apply
 }
 
-func (a *AESGCMEncrypt) Name() string { return "aesgcm-encrypt" }
+// NewAESGCMEncrypt returns an AESGCMEncrypt deriving the cipher from key once, instead of on
+// every Apply. It fails when key is empty.
+func NewAESGCMEncrypt(key string) (*AESGCMEncrypt, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCMEncrypt{Key: key, aead: aead}, nil
+}
 
-func (a *AESGCMEncrypt) Apply(reader io.Reader) (io.Reader, io.Closer, error) {
-	if a.Key == "" {
-		return nil, nil, fmt.Errorf("aesgcm: missing key")
+// NewAESGCMEncryptWithKey returns an AESGCMEncrypt using key as the AES-256 key as is, instead of
+// deriving it from a passphrase. It fails unless key is 32 bytes long.
+func NewAESGCMEncryptWithKey(key []byte) (*AESGCMEncrypt, error) {
+	aead, err := newAEADFromKey(key)
+	if err != nil {
+		return nil, err
 	}
+	return &AESGCMEncrypt{aead: aead}, nil
+}
 
-	// Derive 32-byte AES key from passphrase (SHA-256).
-	key := sha256.Sum256([]byte(a.Key))
+func (a *AESGCMEncrypt) Name() string { return "aesgcm-encrypt" }
 
-	block, err := aes.NewCipher(key[:])
-	if err != nil {
-		return nil, nil, fmt.Errorf("aesgcm: new cipher: %w", err)
+// Apply encrypts reader into a pooled buffer, returned to the pool by the returned closer.
+func (a *AESGCMEncrypt) Apply(reader io.Reader) (io.Reader, io.Closer, error) {
+	aead := a.aead
+	if aead == nil {
+		var err error
+		if aead, err = newAEAD(a.Key); err != nil {
+			return nil, nil, err
+		}
 	}
-	aead, err := cipher.NewGCM(block)
+
+	plain, err := bufpool.Default.ReadAll(reader)
 	if err != nil {
-		return nil, nil, fmt.Errorf("aesgcm: new GCM: %w", err)
+		return nil, nil, fmt.Errorf("aesgcm: read input: %w", err)
 	}
+	defer bufpool.Default.Put(plain)
 
-	nonce := make([]byte, aead.NonceSize())
+	out := bufpool.Default.Get()
+	out.Grow(aead.NonceSize() + plain.Len() + aead.Overhead())
+	nonce := out.AvailableBuffer()[:aead.NonceSize()]
 	if _, err := rand.Read(nonce); err != nil {
+		bufpool.Default.Put(out)
 		return nil, nil, fmt.Errorf("aesgcm: nonce: %w", err)
 	}
+	out.Write(aead.Seal(nonce, nonce, plain.Bytes(), nil))
 
-	plain, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, nil, fmt.Errorf("aesgcm: read input: %w", err)
-	}
+	return bytes.NewReader(out.Bytes()), &pooledBuffer{buf: out}, nil
+}
 
-	ct := aead.Seal(nil, nonce, plain, nil)
+type AESGCMDecrypt struct {
+	Key       string        // passphrase; internally derived to a 32-byte key via SHA-256
+	aead      cipher.AEAD   // Derived from Key by NewAESGCMDecrypt, else on every Apply
+	fallbacks []cipher.AEAD // Tried in turn when aead fails, see WithFallbackKeys
+}
 
-	out := make([]byte, 0, len(nonce)+len(ct))
-	out = append(out, nonce...)
-	out = append(out, ct...)
+// NewAESGCMDecrypt returns an AESGCMDecrypt deriving the cipher from key once, instead of on
+// every Apply. It fails when key is empty.
+func NewAESGCMDecrypt(key string) (*AESGCMDecrypt, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCMDecrypt{Key: key, aead: aead}, nil
+}
 
-	return bytes.NewReader(out), io.NopCloser(bytes.NewReader(nil)), nil
+// NewAESGCMDecryptWithKey returns an AESGCMDecrypt using key as the AES-256 key as is, instead of
+// deriving it from a passphrase. It fails unless key is 32 bytes long.
+func NewAESGCMDecryptWithKey(key []byte) (*AESGCMDecrypt, error) {
+	aead, err := newAEADFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCMDecrypt{aead: aead}, nil
 }
 
-type AESGCMDecrypt struct {
-	Key string // passphrase; internally derived to a 32-byte key via SHA-256
+// WithFallbackKeys returns a copy of t also decrypting with the passphrases keys, tried in turn
+// when the key of t does not authenticate a payload, e.g. the keys of the objects written before
+// a rotation. It fails when a key is empty.
+func (t *AESGCMDecrypt) WithFallbackKeys(keys ...string) (*AESGCMDecrypt, error) {
+	with := *t
+	with.fallbacks = slices.Clone(t.fallbacks)
+	for _, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		with.fallbacks = append(with.fallbacks, aead)
+	}
+	return &with, nil
 }
 
 func (AESGCMDecrypt) Name() string { return "aesgcm-decrypt" }
 
+// Apply decrypts rc in place in a pooled buffer, returned to the pool by the first Close of the
+// returned reader.
 func (t AESGCMDecrypt) Apply(rc io.ReadCloser) (io.ReadCloser, error) {
-	if t.Key == "" {
-		_ = rc.Close()
-		return nil, fmt.Errorf("aesgcm: missing key")
+	aead := t.aead
+	if aead == nil {
+		var err error
+		if aead, err = newAEAD(t.Key); err != nil {
+			_ = rc.Close()
+			return nil, err
+		}
 	}
 
-	cipherBytes, err := io.ReadAll(rc)
+	buf, err := bufpool.Default.ReadAll(rc)
 	_ = rc.Close()
 	if err != nil {
 		return nil, fmt.Errorf("aesgcm: read input: %w", err)
 	}
 
-	key := sha256.Sum256([]byte(t.Key))
-
-	block, err := aes.NewCipher(key[:])
-	if err != nil {
-		return nil, fmt.Errorf("aesgcm: new cipher: %w", err)
-	}
-	aead, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("aesgcm: new GCM: %w", err)
-	}
-
+	cipherBytes := buf.Bytes()
 	if len(cipherBytes) < aead.NonceSize() {
+		bufpool.Default.Put(buf)
 		return nil, fmt.Errorf("aesgcm: invalid ciphertext (too short)")
 	}
 
 	nonce := cipherBytes[:aead.NonceSize()]
 	ciphertext := cipherBytes[aead.NonceSize():]
 
-	plain, err := aead.Open(nil, nonce, ciphertext, nil)
+	// all but the last key are tried on a copy, as a failed Open in place clears the ciphertext
+	var plain []byte
+	for i := 0; i <= len(t.fallbacks); i++ {
+		candidate, dst := aead, []byte(nil)
+		if i > 0 {
+			candidate = t.fallbacks[i-1]
+		}
+		if i == len(t.fallbacks) {
+			dst = ciphertext[:0]
+		}
+		if plain, err = candidate.Open(dst, nonce, ciphertext, nil); err == nil {
+			break
+		}
+	}
 	if err != nil {
+		bufpool.Default.Put(buf)
 		return nil, fmt.Errorf("aesgcm: decryption failed: %w", err)
 	}
 
-	// Wrap plaintext in a new ReadCloser for the next pipeline step / caller.
-	return io.NopCloser(bytes.NewReader(plain)), nil
+	return &pooledReader{r: bytes.NewReader(plain), buf: buf}, nil
+}
+
+// pooledReader reads the plaintext held by buf, returned to the pool on the first Close. It does
+// not expose buf, which another Apply may use once returned: the reads after Close fail with
+// fs.ErrClosed.
+type pooledReader struct {
+	mu  sync.Mutex
+	r   *bytes.Reader // nil once buf is returned to the pool
+	buf *bytes.Buffer
+}
+
+func (p *pooledReader) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.r == nil {
+		return 0, fmt.Errorf("aesgcm: read after close: %w", fs.ErrClosed)
+	}
+	return p.r.Read(b)
+}
+
+func (p *pooledReader) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.r != nil {
+		p.r = nil
+		bufpool.Default.Put(p.buf)
+	}
+	return nil
+}
+
+// newAEAD derives the AES-256-GCM cipher of a passphrase (SHA-256).
+func newAEAD(passphrase string) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("aesgcm: missing key")
+	}
+
+	key := sha256.Sum256([]byte(passphrase))
+	return newAEADFromKey(key[:])
+}
+
+// KeySize is the size of the AES-256 keys, see NewAESGCMEncryptWithKey.
+const KeySize = 32
+
+// newAEADFromKey returns the AES-256-GCM cipher of a raw key.
+func newAEADFromKey(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("aesgcm: key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("aesgcm: new cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("aesgcm: new GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// pooledBuffer returns buf to the pool on the first Close.
+type pooledBuffer struct {
+	once sync.Once
+	buf  *bytes.Buffer
+}
+
+func (p *pooledBuffer) Close() error {
+	p.once.Do(func() { bufpool.Default.Put(p.buf) })
+	return nil
 }
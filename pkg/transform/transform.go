@@ -1,14 +1,19 @@
 package transform
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"strings"
+
 	common "github.com/tizianocitro/m2cs/pkg"
 	"github.com/tizianocitro/m2cs/pkg/transform/compression"
 	"github.com/tizianocitro/m2cs/pkg/transform/encryption"
-	"io"
 )
 
-// WriterTransform applies a write-time transformation to reader
+// WriterTransform applies a write-time transformation to reader.
+// The returned closer releases the resources held by out; it is nil when there is
+// nothing to close. On error, no closer is returned.
 type WriterTransform interface {
 	Name() string
 	Apply(reader io.Reader) (out io.Reader, closer io.Closer, err error)
@@ -25,15 +30,16 @@ type WritePipeline struct{ steps []WriterTransform }
 
 func NewWritePipeline(steps ...WriterTransform) WritePipeline { return WritePipeline{steps: steps} }
 
+// Apply runs the steps in order. The returned closer closes the closers of all steps in
+// reverse order, and is nil when no step has anything to close. If a step fails, the
+// closers of the previous steps are closed in reverse order before returning.
 func (p WritePipeline) Apply(reader io.Reader) (io.Reader, io.Closer, error) {
 	var closers []io.Closer
 	cur := reader
 	for _, s := range p.steps {
 		out, c, err := s.Apply(cur)
 		if err != nil {
-			for i := len(closers) - 1; i >= 0; i-- {
-				_ = closers[i].Close()
-			}
+			_ = multiCloser(closers).Close()
 			return nil, nil, err
 		}
 		if c != nil {
@@ -41,6 +47,9 @@ func (p WritePipeline) Apply(reader io.Reader) (io.Reader, io.Closer, error) {
 		}
 		cur = out
 	}
+	if len(closers) == 0 {
+		return cur, nil, nil
+	}
 	return cur, multiCloser(closers), nil
 }
 
@@ -62,11 +71,15 @@ func (p ReadPipeline) Apply(readerCloser io.ReadCloser) (io.ReadCloser, error) {
 	return cur, nil
 }
 
+// multiCloser closes its closers in reverse order, skipping nil ones, and returns the first error.
 type multiCloser []io.Closer
 
 func (m multiCloser) Close() error {
 	var first error
 	for i := len(m) - 1; i >= 0; i-- {
+		if m[i] == nil {
+			continue
+		}
 		if err := m[i].Close(); err != nil && first == nil {
 			first = err
 		}
@@ -74,10 +87,16 @@ func (m multiCloser) Close() error {
 	return first
 }
 
+// ErrKeyUnavailable is returned when building the pipelines encrypting, or decrypting, without a
+// key for that direction, e.g. on a client with only DecryptKeys, or with EncryptOnly; see
+// common.ConnectionProperties.CanDecrypt.
+var ErrKeyUnavailable = errors.New("encryption key unavailable")
+
 // Factory builds write pipelines from backend properties and runtime key material.
 type Factory struct{}
 
 // BuildWPipelineCompressEncrypt returns a Pipeline that apply compress and encrypt algoritm to reader.
+// It encrypts with the passphrase encryptionKey or, when it is empty, with props.EncryptKeyBytes.
 func (Factory) BuildWPipelineCompressEncrypt(props common.ConnectionProperties, encryptionKey string) (WritePipeline, error) {
 	var steps []WriterTransform
 
@@ -87,6 +106,11 @@ func (Factory) BuildWPipelineCompressEncrypt(props common.ConnectionProperties,
 		// no-op
 	case common.GZIP_COMPRESSION:
 		steps = append(steps, &compression.GzipCompress{})
+	case common.GZIP_CONTENT_ENCODING:
+		if props.SaveEncrypt != common.NO_ENCRYPTION {
+			return WritePipeline{}, fmt.Errorf("GZIP_CONTENT_ENCODING cannot be combined with encryption")
+		}
+		steps = append(steps, &compression.GzipCompress{})
 	default:
 		return WritePipeline{}, fmt.Errorf("unsupported compression algorithm: %v", props.SaveCompress)
 	}
@@ -96,10 +120,22 @@ func (Factory) BuildWPipelineCompressEncrypt(props common.ConnectionProperties,
 	case common.NO_ENCRYPTION:
 		// no-op
 	case common.AES256_ENCRYPTION:
-		if encryptionKey == "" {
-			return WritePipeline{}, fmt.Errorf("missing encryption key for AES256_ENCRYPTION")
+		var (
+			encrypt *encryption.AESGCMEncrypt
+			err     error
+		)
+		switch {
+		case encryptionKey != "":
+			encrypt, err = encryption.NewAESGCMEncrypt(encryptionKey)
+		case len(props.EncryptKeyBytes) > 0:
+			encrypt, err = encryption.NewAESGCMEncryptWithKey(props.EncryptKeyBytes)
+		default:
+			return WritePipeline{}, fmt.Errorf("%w: missing encryption key for AES256_ENCRYPTION", ErrKeyUnavailable)
+		}
+		if err != nil {
+			return WritePipeline{}, err
 		}
-		steps = append(steps, &encryption.AESGCMEncrypt{Key: encryptionKey})
+		steps = append(steps, encrypt)
 	default:
 		return WritePipeline{}, fmt.Errorf("unsupported encryption algorithm: %v", props.SaveEncrypt)
 	}
@@ -107,7 +143,18 @@ func (Factory) BuildWPipelineCompressEncrypt(props common.ConnectionProperties,
 	return NewWritePipeline(steps...), nil
 }
 
-func (Factory) BuildRPipelineDecryptDecompress(props common.ConnectionProperties, decryptionKey string) (ReadPipeline, error) {
+// BuildRPipelineDecryptDecompress returns a Pipeline that apply decrypt and decompress algoritm to reader.
+func (f Factory) BuildRPipelineDecryptDecompress(props common.ConnectionProperties, decryptionKey string) (ReadPipeline, error) {
+	return f.BuildRPipelineWithEncoding(props, decryptionKey, ContentEncodingGzip)
+}
+
+// BuildRPipelineWithEncoding returns a read Pipeline aware of the Content-Encoding header
+// of the response. With GZIP_CONTENT_ENCODING the decompression step is added only when
+// contentEncoding reports that the payload is still gzip encoded, since HTTP clients may
+// have already decompressed it transparently. Like the write pipelines, it decrypts with the
+// passphrase decryptionKey or, when it is empty, with props.EncryptKeyBytes, unless
+// props.EncryptOnly, and then with the passphrases of props.DecryptKeys in turn.
+func (Factory) BuildRPipelineWithEncoding(props common.ConnectionProperties, decryptionKey string, contentEncoding string) (ReadPipeline, error) {
 	var steps []ReaderTransform
 
 	// 1) Decryption
@@ -115,10 +162,31 @@ func (Factory) BuildRPipelineDecryptDecompress(props common.ConnectionProperties
 	case common.NO_ENCRYPTION:
 		// no-op
 	case common.AES256_ENCRYPTION:
-		if decryptionKey == "" {
-			return ReadPipeline{}, fmt.Errorf("missing decryption key for AES256_ENCRYPTION")
+		var (
+			decrypt *encryption.AESGCMDecrypt
+			err     error
+		)
+		keys := props.DecryptKeys
+		switch {
+		case props.EncryptOnly && len(keys) == 0:
+			return ReadPipeline{}, fmt.Errorf("%w: the encryption key of AES256_ENCRYPTION only encrypts", ErrKeyUnavailable)
+		case decryptionKey != "" && !props.EncryptOnly:
+			decrypt, err = encryption.NewAESGCMDecrypt(decryptionKey)
+		case len(props.EncryptKeyBytes) > 0 && !props.EncryptOnly:
+			decrypt, err = encryption.NewAESGCMDecryptWithKey(props.EncryptKeyBytes)
+		case len(keys) > 0:
+			decrypt, err = encryption.NewAESGCMDecrypt(keys[0])
+			keys = keys[1:]
+		default:
+			return ReadPipeline{}, fmt.Errorf("%w: missing decryption key for AES256_ENCRYPTION", ErrKeyUnavailable)
+		}
+		if err == nil && len(keys) > 0 {
+			decrypt, err = decrypt.WithFallbackKeys(keys...)
 		}
-		steps = append(steps, &encryption.AESGCMDecrypt{Key: decryptionKey})
+		if err != nil {
+			return ReadPipeline{}, err
+		}
+		steps = append(steps, decrypt)
 	default:
 		return ReadPipeline{}, fmt.Errorf("unsupported encryption algorithm: %v", props.SaveEncrypt)
 	}
@@ -129,9 +197,38 @@ func (Factory) BuildRPipelineDecryptDecompress(props common.ConnectionProperties
 		// no-op
 	case common.GZIP_COMPRESSION:
 		steps = append(steps, &compression.GzipDecompress{})
+	case common.GZIP_CONTENT_ENCODING:
+		if props.SaveEncrypt != common.NO_ENCRYPTION {
+			return ReadPipeline{}, fmt.Errorf("GZIP_CONTENT_ENCODING cannot be combined with encryption")
+		}
+		if IsGzipEncoded(contentEncoding) {
+			steps = append(steps, &compression.GzipDecompress{})
+		}
 	default:
 		return ReadPipeline{}, fmt.Errorf("unsupported compression algorithm: %v", props.SaveCompress)
 	}
 
 	return NewReadPipeline(steps...), nil
 }
+
+// ContentEncodingGzip is the Content-Encoding value of objects saved with GZIP_CONTENT_ENCODING.
+const ContentEncodingGzip = "gzip"
+
+// ContentEncoding returns the Content-Encoding header to store along with objects
+// written with the given properties, or an empty string if none is needed.
+func ContentEncoding(props common.ConnectionProperties) string {
+	if props.SaveCompress == common.GZIP_CONTENT_ENCODING {
+		return ContentEncodingGzip
+	}
+	return ""
+}
+
+// IsGzipEncoded reports whether a Content-Encoding header value declares gzip encoding.
+func IsGzipEncoded(contentEncoding string) bool {
+	for _, enc := range strings.Split(contentEncoding, ",") {
+		if strings.EqualFold(strings.TrimSpace(enc), ContentEncodingGzip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package aliases_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+func TestAliases_PhysicalAndLogicalBox(t *testing.T) {
+	properties := common.ConnectionProperties{BoxAliases: map[string]string{"data": "m2cs-data", "logs": "m2cs-logs"}}
+
+	assert.Equal(t, "m2cs-data", properties.PhysicalBox("data"))
+	assert.Equal(t, "other", properties.PhysicalBox("other"))
+	assert.Equal(t, "data", properties.LogicalBox("m2cs-data"))
+	assert.Equal(t, "other", properties.LogicalBox("other"))
+	assert.NoError(t, properties.ValidateBoxAliases())
+	assert.NoError(t, common.ConnectionProperties{}.ValidateBoxAliases())
+
+	ambiguous := common.ConnectionProperties{BoxAliases: map[string]string{"data": "shared", "logs": "shared"}}
+	assert.EqualError(t, ambiguous.ValidateBoxAliases(), `invalid store box aliases: both "data" and "logs" map to "shared"`)
+	empty := common.ConnectionProperties{BoxAliases: map[string]string{"data": ""}}
+	assert.ErrorContains(t, empty.ValidateBoxAliases(), "names cannot be empty")
+}
+
+func TestAliases_FileClient(t *testing.T) {
+	azure := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{
+		Label: "azure", IsMainInstance: true, BoxAliases: map[string]string{"data": "m2cs-data"},
+	}, "data")
+	s3 := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{
+		Label: "s3", IsMainInstance: true, BoxAliases: map[string]string{"data": "prod.data.m2cs"},
+	}, "data")
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN,
+		[]filestorage.FileStorage{azure, s3})
+	require.NoError(t, err)
+
+	require.NoError(t, client.PutObject(context.Background(), "data", "key", strings.NewReader("content")))
+
+	// the physical names are not aliased, so that they reach the physical store boxes
+	for storage, physical := range map[*filestorage.MemoryClient]string{azure: "m2cs-data", s3: "prod.data.m2cs"} {
+		exists, err := storage.ExistObject(context.Background(), physical, "key")
+		require.NoError(t, err)
+		assert.True(t, exists, "the object is written to %s", physical)
+
+		boxes, err := storage.ListBuckets(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"data"}, boxes, "the store boxes are listed by logical name")
+	}
+
+	for range 2 {
+		obj, err := client.GetObject(context.Background(), "data", "key")
+		require.NoError(t, err)
+		data, err := io.ReadAll(obj)
+		require.NoError(t, err)
+		require.NoError(t, obj.Close())
+		assert.Equal(t, "content", string(data))
+	}
+
+	require.NoError(t, client.RemoveObject(context.Background(), "data", "key"))
+	for storage, physical := range map[*filestorage.MemoryClient]string{azure: "m2cs-data", s3: "prod.data.m2cs"} {
+		exists, err := storage.ExistObject(context.Background(), physical, "key")
+		require.NoError(t, err)
+		assert.False(t, exists, "the object is removed from %s", physical)
+	}
+}
+
+func TestAliases_NewClients(t *testing.T) {
+	_, err := m2cs.NewMinIOConnection("localhost:9000", m2cs.ConnectionOptions{
+		ConnectionMethod: m2cs.ConnectWithCredentials("user", "password"),
+		BoxAliases:       map[string]string{"data": "shared", "logs": "shared"},
+	}, nil)
+	assert.ErrorContains(t, err, "invalid store box aliases")
+}
@@ -0,0 +1,215 @@
+package audit_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+// errorsHook collects the errors reported by the audit log.
+type errorsHook struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (h *errorsHook) report(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.errs = append(h.errs, err)
+}
+
+func (h *errorsHook) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.errs)
+}
+
+// readRecords reads back the records of the audit box, in the order they were written.
+func readRecords(t *testing.T, storage *filestorage.MemoryClient) []m2cs.AuditRecord {
+	ctx := context.Background()
+	objects, err := storage.ListObjectsInfo(ctx, "audit", "")
+	require.NoError(t, err)
+
+	var records []m2cs.AuditRecord
+	for _, object := range objects {
+		assert.True(t, strings.HasSuffix(object.Key, ".jsonl"), object.Key)
+		obj, err := storage.GetObject(ctx, "audit", object.Key)
+		require.NoError(t, err)
+		scanner := bufio.NewScanner(obj)
+		for scanner.Scan() {
+			var record m2cs.AuditRecord
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			records = append(records, record)
+		}
+		require.NoError(t, scanner.Err())
+		require.NoError(t, obj.Close())
+	}
+	return records
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAudit_Workload(t *testing.T) {
+	a := storagetest.NewMemory(t, "a", "box")
+	outage := storagetest.NewOutage(errors.New("connection refused"))
+	b := storagetest.Wrap(storagetest.NewMemory(t, "b", "box"), outage.Decorator())
+	auditStorage := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "audit"}, "audit")
+	var hook errorsHook
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{a, b}, m2cs.WithAudit(m2cs.AuditOptions{
+			Storage:       auditStorage,
+			StoreBox:      "audit",
+			FlushInterval: time.Hour,
+			OnError:       hook.report,
+		}))
+	require.NoError(t, err)
+
+	alice := m2cs.ContextWithPrincipal(context.Background(), "alice")
+	start := time.Now().UTC()
+	require.NoError(t, client.PutObject(alice, "box", "one", strings.NewReader("first")))
+	require.NoError(t, client.PutObjectWithOptions(context.Background(), "box", "two", strings.NewReader("second"), m2cs.PutOptions{}))
+	require.NoError(t, client.RemoveObject(alice, "box", "one"))
+
+	// failed operations are not audited
+	outage.Down()
+	require.Error(t, client.PutObject(alice, "box", "three", strings.NewReader("third")))
+	outage.Up()
+
+	assert.Empty(t, readRecords(t, auditStorage), "records are batched")
+	require.NoError(t, client.FlushAudit(context.Background()))
+
+	records := readRecords(t, auditStorage)
+	require.Len(t, records, 3)
+	for _, record := range records {
+		assert.False(t, record.Time.Before(start.Truncate(time.Second)))
+		assert.Equal(t, "box", record.StoreBox)
+		assert.Equal(t, []string{"a", "b"}, record.Storages)
+	}
+	assert.Equal(t, "PutObject", records[0].Op)
+	assert.Equal(t, "one", records[0].Key)
+	assert.Equal(t, int64(5), records[0].Size)
+	assert.Equal(t, sha256Hex("first"), records[0].Checksum)
+	assert.Equal(t, "alice", records[0].Principal)
+
+	assert.Equal(t, "two", records[1].Key)
+	assert.Equal(t, sha256Hex("second"), records[1].Checksum)
+	assert.Empty(t, records[1].Principal)
+
+	assert.Equal(t, "RemoveObject", records[2].Op)
+	assert.Equal(t, "one", records[2].Key)
+	assert.Zero(t, records[2].Size)
+	assert.Empty(t, records[2].Checksum)
+	assert.Equal(t, "alice", records[2].Principal)
+
+	// the batches are appended as new objects
+	require.NoError(t, client.PutObject(alice, "box", "four", strings.NewReader("fourth")))
+	require.NoError(t, client.Close())
+	records = readRecords(t, auditStorage)
+	require.Len(t, records, 4)
+	assert.Equal(t, "four", records[3].Key)
+	assert.Zero(t, hook.count())
+}
+
+func TestAudit_BatchSize(t *testing.T) {
+	main := storagetest.NewMemory(t, "main", "box")
+	auditStorage := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "audit"}, "audit")
+	client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{main}, m2cs.WithAudit(m2cs.AuditOptions{
+			Storage:       auditStorage,
+			StoreBox:      "audit",
+			FlushInterval: time.Hour,
+			MaxBatch:      2,
+		}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	for _, key := range []string{"a", "b"} {
+		require.NoError(t, client.PutObject(context.Background(), "box", key, strings.NewReader(key)))
+	}
+	assert.Eventually(t, func() bool { return len(readRecords(t, auditStorage)) == 2 }, time.Second, 10*time.Millisecond)
+}
+
+func TestAudit_Failures(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(map[bool]string{false: "lenient", true: "strict"}[strict], func(t *testing.T) {
+			main := storagetest.NewMemory(t, "main", "box")
+			auditStorage := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "audit"}, "audit")
+			outage := storagetest.NewOutage(errors.New("connection refused"))
+			var hook errorsHook
+			client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+				[]filestorage.FileStorage{main}, m2cs.WithAudit(m2cs.AuditOptions{
+					Storage:       storagetest.Wrap(auditStorage, outage.Decorator()),
+					StoreBox:      "audit",
+					FlushInterval: 20 * time.Millisecond,
+					Strict:        strict,
+					OnError:       hook.report,
+				}))
+			require.NoError(t, err)
+			defer client.Close()
+			ctx := context.Background()
+
+			outage.Down()
+			require.NoError(t, client.PutObject(ctx, "box", "key", strings.NewReader("v1")), "auditing never fails the operation")
+			assert.Eventually(t, func() bool { return hook.count() > 0 }, time.Second, 10*time.Millisecond)
+			hook.mu.Lock()
+			assert.ErrorIs(t, hook.errs[0], m2cs.ErrAuditFailed)
+			assert.ErrorContains(t, hook.errs[0], "connection refused")
+			hook.mu.Unlock()
+
+			err = client.RemoveObject(ctx, "box", "key")
+			if strict {
+				assert.ErrorIs(t, err, m2cs.ErrAuditUnavailable)
+				exists, err := main.ExistObject(ctx, "box", "key")
+				require.NoError(t, err)
+				assert.True(t, exists, "the rejected removal must not be applied")
+				assert.ErrorIs(t, client.PutObject(ctx, "box", "key", strings.NewReader("v2")), m2cs.ErrAuditUnavailable)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// the records are kept and written once the audit storage is back
+			outage.Up()
+			assert.Eventually(t, func() bool { return len(readRecords(t, auditStorage)) > 0 }, time.Second, 10*time.Millisecond)
+			records := readRecords(t, auditStorage)
+			assert.Equal(t, "key", records[0].Key)
+			if strict {
+				assert.Len(t, records, 1)
+				assert.NoError(t, client.RemoveObject(ctx, "box", "key"))
+			} else {
+				assert.Len(t, records, 2)
+			}
+		})
+	}
+}
+
+func TestAudit_Options(t *testing.T) {
+	newClient := func(opts m2cs.AuditOptions) error {
+		_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+			[]filestorage.FileStorage{storagetest.NewMemory(t, "main")}, m2cs.WithAudit(opts))
+		return err
+	}
+	auditStorage := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "audit"})
+
+	assert.ErrorContains(t, newClient(m2cs.AuditOptions{StoreBox: "audit"}), "audit storage is nil")
+	assert.ErrorContains(t, newClient(m2cs.AuditOptions{Storage: auditStorage}), "invalid audit box")
+	assert.ErrorContains(t, newClient(m2cs.AuditOptions{Storage: auditStorage, StoreBox: "audit", MaxBatch: 10, MaxPending: 5}), "lower than MaxBatch")
+	assert.NoError(t, newClient(m2cs.AuditOptions{Storage: auditStorage, StoreBox: "audit"}))
+}
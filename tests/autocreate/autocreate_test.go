@@ -0,0 +1,266 @@
+package autocreate_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// server is a storage holding the store box "box" and the ones it is asked to create. Its
+// paths are /<box>/<object> for MinIO and AWS S3, and /m2cs/<box>/<object> for Azure.
+type server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	boxes     map[string]bool
+	creates   []string // Bodies of the store box creations
+	puts      int      // Writes of objects, the rejected ones included
+	refuse    bool     // Rejects the store box creations
+	forgetful bool     // Accepts the store box creations, and reports them existing, without creating them
+}
+
+func newServer(t *testing.T) *server {
+	s := &server{boxes: map[string]bool{"box": true}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, azure := strings.CutPrefix(r.URL.Path, "/m2cs/")
+	box, object, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	body, _ := io.ReadAll(r.Body)
+
+	if object == "" {
+		switch r.Method {
+		case http.MethodPut:
+			s.creates = append(s.creates, string(body))
+			if s.refuse {
+				s.fail(w, azure, http.StatusForbidden, "AccessDenied", "AuthorizationPermissionMismatch")
+				return
+			}
+			if s.boxes[box] {
+				s.fail(w, azure, http.StatusConflict, "BucketAlreadyOwnedByYou", "ContainerAlreadyExists")
+				return
+			}
+			s.boxes[box] = !s.forgetful
+			s.created(w, azure)
+		default:
+			if !s.boxes[box] && !s.forgetful {
+				s.fail(w, azure, http.StatusNotFound, "NoSuchBucket", "ContainerNotFound")
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}
+		return
+	}
+
+	if !s.boxes[box] {
+		if r.Method == http.MethodPut {
+			s.puts++
+		}
+		s.fail(w, azure, http.StatusNotFound, "NoSuchBucket", "ContainerNotFound")
+		return
+	}
+	if r.Method == http.MethodPut {
+		s.puts++
+	}
+	w.Header().Set("ETag", `"0123456789abcdef0123456789abcdef"`)
+	w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+	s.created(w, azure)
+}
+
+// created answers a write with the status expected by the backend.
+func (s *server) created(w http.ResponseWriter, azure bool) {
+	if azure {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// fail answers with an error of code s3Code for MinIO and AWS S3, or azCode for Azure.
+func (s *server) fail(w http.ResponseWriter, azure bool, status int, s3Code, azCode string) {
+	if azure {
+		w.Header().Set("x-ms-error-code", azCode)
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", s3Code, s3Code)
+}
+
+func (s *server) exists(box string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.boxes[box]
+}
+
+// backend creates a connection to a backend served by s.
+type backend struct {
+	name    string
+	connect func(t *testing.T, s *server, opts m2cs.ConnectionOptions) filestorage.FileStorage
+}
+
+var backends = []backend{
+	{"MinIO", func(t *testing.T, s *server, opts m2cs.ConnectionOptions) filestorage.FileStorage {
+		opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+		client, err := m2cs.NewMinIOConnection(s.URL, opts, nil)
+		require.NoError(t, err)
+		return client
+	}},
+	{"AWS S3", func(t *testing.T, s *server, opts m2cs.ConnectionOptions) filestorage.FileStorage {
+		opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+		client, err := m2cs.NewS3Connection(s.URL, opts, opts.Region)
+		require.NoError(t, err)
+		return client
+	}},
+	{"Azure Blob", func(t *testing.T, s *server, opts m2cs.ConnectionOptions) filestorage.FileStorage {
+		opts.ConnectionMethod = m2cs.ConnectWithConnectionString("DefaultEndpointsProtocol=http;AccountName=m2cs;" +
+			"AccountKey=bTJjcy1hY2NvdW50LWtleQ==;BlobEndpoint=" + s.URL + "/m2cs;")
+		client, err := m2cs.NewAzBlobConnection("", opts)
+		require.NoError(t, err)
+		return client
+	}},
+}
+
+func options(autoCreate bool) m2cs.ConnectionOptions {
+	return m2cs.ConnectionOptions{IsMainInstance: true, ProbeBox: "box", Region: "us-east-1", AutoCreateBox: autoCreate}
+}
+
+func TestAutoCreateBox(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			s := newServer(t)
+			client := b.connect(t, s, options(true))
+
+			err := client.PutObject(context.Background(), "ephemeral", "file.txt", strings.NewReader("content"))
+			require.NoError(t, err)
+			assert.True(t, s.exists("ephemeral"))
+			assert.Len(t, s.creates, 1)
+			assert.Equal(t, 2, s.puts)
+
+			// the store box is not created again
+			err = client.PutObject(context.Background(), "ephemeral", "other.txt", strings.NewReader("content"))
+			require.NoError(t, err)
+			assert.Len(t, s.creates, 1)
+		})
+	}
+}
+
+func TestAutoCreateBox_Disabled(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			s := newServer(t)
+			client := b.connect(t, s, options(false))
+
+			err := client.PutObject(context.Background(), "ephemeral", "file.txt", strings.NewReader("content"))
+			require.Error(t, err)
+			assert.NotErrorIs(t, err, m2cs.ErrBoxCreationFailed)
+			assert.False(t, s.exists("ephemeral"))
+			assert.Empty(t, s.creates)
+			assert.Equal(t, 1, s.puts)
+		})
+	}
+}
+
+func TestAutoCreateBox_CreationFails(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			s := newServer(t)
+			s.refuse = true
+			client := b.connect(t, s, options(true))
+
+			err := client.PutObject(context.Background(), "ephemeral", "file.txt", strings.NewReader("content"))
+			require.ErrorIs(t, err, m2cs.ErrBoxCreationFailed)
+			assert.Equal(t, 1, s.puts)
+		})
+	}
+}
+
+// TestAutoCreateBox_Once checks a store box still missing once created fails the put, without
+// being created again.
+func TestAutoCreateBox_Once(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			s := newServer(t)
+			s.forgetful = true
+			client := b.connect(t, s, options(true))
+
+			err := client.PutObject(context.Background(), "ephemeral", "file.txt", strings.NewReader("content"))
+			require.Error(t, err)
+			assert.NotErrorIs(t, err, m2cs.ErrBoxCreationFailed)
+			assert.Len(t, s.creates, 1)
+			assert.Equal(t, 2, s.puts)
+		})
+	}
+}
+
+// TestAutoCreateBox_Unseekable checks the payloads that cannot be read again are not sent twice:
+// the put fails once the store box is created, and succeeds when retried. The AWS SDK rejects
+// them without TLS.
+func TestAutoCreateBox_Unseekable(t *testing.T) {
+	for _, b := range []backend{backends[0], backends[2]} {
+		t.Run(b.name, func(t *testing.T) {
+			s := newServer(t)
+			client := b.connect(t, s, options(true))
+
+			err := client.PutObject(context.Background(), "ephemeral", "file.txt", io.MultiReader(strings.NewReader("content")))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "store box created, but the payload cannot be sent again")
+			assert.True(t, s.exists("ephemeral"))
+
+			err = client.PutObject(context.Background(), "ephemeral", "file.txt", io.MultiReader(strings.NewReader("content")))
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestAutoCreateBox_Aliases(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			s := newServer(t)
+			opts := options(true)
+			opts.BoxAliases = map[string]string{"data": "m2cs-data"}
+			client := b.connect(t, s, opts)
+
+			require.NoError(t, client.PutObject(context.Background(), "data", "file.txt", bytes.NewReader([]byte("content"))))
+			assert.True(t, s.exists("m2cs-data"))
+			assert.False(t, s.exists("data"))
+		})
+	}
+}
+
+func TestAutoCreateBox_S3Region(t *testing.T) {
+	s := newServer(t)
+	opts := options(true)
+	opts.Region = "eu-west-1"
+	client := backends[1].connect(t, s, opts)
+
+	require.NoError(t, client.PutObject(context.Background(), "ephemeral", "file.txt", strings.NewReader("content")))
+	require.Len(t, s.creates, 1)
+	assert.Contains(t, s.creates[0], "<LocationConstraint>eu-west-1</LocationConstraint>")
+
+	// us-east-1 is the default location, sent without a constraint
+	s = newServer(t)
+	client = backends[1].connect(t, s, options(true))
+	require.NoError(t, client.PutObject(context.Background(), "ephemeral", "file.txt", strings.NewReader("content")))
+	require.Len(t, s.creates, 1)
+	assert.NotContains(t, s.creates[0], "LocationConstraint")
+}
@@ -0,0 +1,146 @@
+package backpressure_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+// delay is the time taken by the operations of the slow storages, on their FakeClock.
+const delay = 20 * time.Millisecond
+
+// advance advances clk by delay whenever an operation waits for it, until the returned function
+// is called.
+func advance(clk *storagetest.FakeClock) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for clk.BlockUntilContext(ctx, 1) == nil {
+			clk.Advance(delay)
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// modes records the mode changes reported by the OnModeChange hook.
+type modes struct {
+	mu      sync.Mutex
+	changes []m2cs.ReplicationMode
+}
+
+func (m *modes) record(mode m2cs.ReplicationMode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.changes = append(m.changes, mode)
+}
+
+func (m *modes) get() []m2cs.ReplicationMode {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]m2cs.ReplicationMode(nil), m.changes...)
+}
+
+func TestBackpressure_FloodSlowReplica(t *testing.T) {
+	for _, block := range []bool{false, true} {
+		t.Run(fmt.Sprintf("block=%v", block), func(t *testing.T) {
+			clk := storagetest.NewFakeClock(time.Now())
+			fast, slow := storagetest.NewMemory(t, "fast", "box"), storagetest.NewMemory(t, "slow", "box")
+			var changes modes
+			client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+				[]filestorage.FileStorage{fast, storagetest.Wrap(slow, storagetest.LatencyWithClock(clk, delay))},
+				m2cs.WithBackpressure(m2cs.BackpressureOptions{
+					HighWater:    5,
+					LowWater:     1,
+					Block:        block,
+					OnModeChange: changes.record,
+				}))
+			require.NoError(t, err)
+			assert.Equal(t, m2cs.ReplicationStatus{Mode: m2cs.ASYNC_REPLICATION}, client.ReplicationStatus())
+
+			// the replications to the slow storage wait for the clock, so the backlog grows by one
+			// write at a time until the writes are throttled
+			const puts = 30
+			for i := range 5 {
+				require.NoError(t, client.PutObject(context.Background(), "box", fmt.Sprintf("key-%d", i), strings.NewReader("data")))
+				assert.Equal(t, int64(i+1), client.ReplicationStatus().Backlog)
+			}
+			assert.Equal(t, m2cs.SYNC_REPLICATION, client.ReplicationStatus().Mode, "the writes must be throttled")
+
+			stop := advance(clk)
+			var maxBacklog int64
+			for i := 5; i < puts; i++ {
+				require.NoError(t, client.PutObject(context.Background(), "box", fmt.Sprintf("key-%d", i), strings.NewReader("data")))
+				maxBacklog = max(maxBacklog, client.ReplicationStatus().Backlog)
+			}
+			assert.LessOrEqual(t, maxBacklog, int64(5), "the backlog must stay bounded")
+			assert.Eventually(t, func() bool {
+				return client.ReplicationStatus() == m2cs.ReplicationStatus{Mode: m2cs.ASYNC_REPLICATION}
+			}, 5*time.Second, time.Millisecond, "the writes must return to background replication")
+			stop()
+
+			got := changes.get()
+			require.GreaterOrEqual(t, len(got), 2)
+			assert.Equal(t, m2cs.SYNC_REPLICATION, got[0])
+			assert.Equal(t, m2cs.ASYNC_REPLICATION, got[len(got)-1])
+
+			// no write is lost
+			for i := range puts {
+				for _, s := range []*filestorage.MemoryClient{fast, slow} {
+					exists, err := s.ExistObject(context.Background(), "box", fmt.Sprintf("key-%d", i))
+					require.NoError(t, err)
+					assert.True(t, exists, "key-%d on %s", i, s.GetConnectionProperties().Label)
+				}
+			}
+		})
+	}
+}
+
+func TestBackpressure_BlockHonorsContext(t *testing.T) {
+	clk := storagetest.NewFakeClock(time.Now())
+	fast := storagetest.NewMemory(t, "fast", "box")
+	slow := storagetest.Wrap(storagetest.NewMemory(t, "slow", "box"), storagetest.LatencyWithClock(clk, delay))
+	client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{fast, slow}, m2cs.WithBackpressure(m2cs.BackpressureOptions{HighWater: 1, Block: true}))
+	require.NoError(t, err)
+
+	require.NoError(t, client.PutObject(context.Background(), "box", "first", strings.NewReader("data")))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = client.PutObject(ctx, "box", "second", strings.NewReader("data"))
+	assert.ErrorIs(t, err, context.Canceled)
+
+	exists, err := fast.ExistObject(context.Background(), "box", "second")
+	require.NoError(t, err)
+	assert.False(t, exists, "the rejected write must not reach the storages")
+
+	clk.BlockUntil(1)
+	clk.Advance(delay)
+	assert.Eventually(t, func() bool { return client.ReplicationStatus().Backlog == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, client.PutObject(context.Background(), "box", "second", strings.NewReader("data")))
+}
+
+func TestBackpressure_Options(t *testing.T) {
+	newClient := func(mode m2cs.ReplicationMode, opts m2cs.BackpressureOptions) error {
+		_, err := m2cs.NewFileClientWithOptions(mode, m2cs.READ_REPLICA_FIRST,
+			[]filestorage.FileStorage{storagetest.NewMemory(t, "a", "box")}, m2cs.WithBackpressure(opts))
+		return err
+	}
+	assert.ErrorContains(t, newClient(m2cs.ASYNC_REPLICATION, m2cs.BackpressureOptions{}), "HighWater must be positive")
+	assert.ErrorContains(t, newClient(m2cs.ASYNC_REPLICATION, m2cs.BackpressureOptions{HighWater: 2, LowWater: 2}), "LowWater must be in [0, 2)")
+	assert.NoError(t, newClient(m2cs.ASYNC_REPLICATION, m2cs.BackpressureOptions{HighWater: 1}))
+	assert.NoError(t, newClient(m2cs.SYNC_REPLICATION, m2cs.BackpressureOptions{HighWater: 1}))
+}
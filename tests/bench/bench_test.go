@@ -0,0 +1,574 @@
+package bench_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/transform"
+	"github.com/tizianocitro/m2cs/pkg/transform/compression"
+)
+
+// cacheHitAllocBudget is the maximum number of allocations of a GetObject served by the cache.
+const cacheHitAllocBudget = 4
+
+const benchBox = "bench"
+
+var payloadSizes = []struct {
+	name string
+	size int
+}{
+	{"1KB", 1 << 10},
+	{"1MB", 1 << 20},
+	{"32MB", 32 << 20},
+}
+
+// newPayload returns size bytes of deterministic, moderately compressible data.
+func newPayload(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+// newMemoryClient returns a MemoryClient holding the benchmark store box.
+func newMemoryClient(tb testing.TB, props common.ConnectionProperties) *filestorage.MemoryClient {
+	client := filestorage.NewMemoryClient(props)
+	if err := client.MakeBucket(context.Background(), benchBox); err != nil {
+		tb.Fatalf("failed to create store box: %v", err)
+	}
+	return client
+}
+
+// newFileClient returns a FileClient over three in-memory main storages.
+func newFileClient(tb testing.TB, mode m2cs.ReplicationMode) *m2cs.FileClient {
+	props := common.ConnectionProperties{IsMainInstance: true}
+	return m2cs.NewFileClient(mode, m2cs.READ_REPLICA_FIRST,
+		newMemoryClient(tb, props), newMemoryClient(tb, props), newMemoryClient(tb, props))
+}
+
+func BenchmarkPutObject(b *testing.B) {
+	ctx := context.Background()
+
+	for _, mode := range []struct {
+		name string
+		mode m2cs.ReplicationMode
+	}{
+		{"sync", m2cs.SYNC_REPLICATION},
+		{"async", m2cs.ASYNC_REPLICATION},
+	} {
+		for _, size := range payloadSizes {
+			b.Run(mode.name+"/"+size.name, func(b *testing.B) {
+				fileClient := newFileClient(b, mode.mode)
+				payload := newPayload(size.size)
+
+				b.ReportAllocs()
+				b.SetBytes(int64(size.size))
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					if err := fileClient.PutObject(ctx, benchBox, "object", bytes.NewReader(payload)); err != nil {
+						b.Fatalf("PutObject failed: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// discardStorage is a main storage consuming the payloads without retaining them, so that
+// the allocations measured are those of the FileClient alone.
+type discardStorage struct{}
+
+func (discardStorage) GetObject(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(nil)), nil
+}
+
+func (discardStorage) PutObject(ctx context.Context, storeBox string, fileName string, reader io.Reader) error {
+	_, err := io.Copy(io.Discard, reader)
+	return err
+}
+
+func (discardStorage) RemoveObject(ctx context.Context, storeBox string, fileName string) error {
+	return nil
+}
+
+func (discardStorage) ExistObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
+	return false, nil
+}
+
+func (discardStorage) GetConnectionProperties() common.ConnectionProperties {
+	return common.ConnectionProperties{IsMainInstance: true}
+}
+
+func BenchmarkPutObject_Fanout(b *testing.B) {
+	ctx := context.Background()
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		discardStorage{}, discardStorage{}, discardStorage{})
+	payload := newPayload(1 << 20)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := fileClient.PutObject(ctx, benchBox, "object", bytes.NewReader(payload)); err != nil {
+			b.Fatalf("PutObject failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkPutObjectWithSize measures the plain uploads of a MemoryClient from a reader not
+// reporting its length, with and without the size given in the put options.
+func BenchmarkPutObjectWithSize(b *testing.B) {
+	ctx := context.Background()
+
+	for _, known := range []bool{false, true} {
+		name := "unknown-size"
+		if known {
+			name = "known-size"
+		}
+		for _, size := range payloadSizes[:2] {
+			b.Run(name+"/"+size.name, func(b *testing.B) {
+				client := newMemoryClient(b, common.ConnectionProperties{IsMainInstance: true})
+				payload := newPayload(size.size)
+				opts := filestorage.PutOptions{}
+				if known {
+					opts.Size = int64(size.size)
+				}
+
+				b.ReportAllocs()
+				b.SetBytes(int64(size.size))
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					// hide the Len and Seek methods of the reader
+					reader := struct{ io.Reader }{bytes.NewReader(payload)}
+					if err := client.PutObjectWithOptions(ctx, benchBox, "object", reader, opts); err != nil {
+						b.Fatalf("PutObjectWithOptions failed: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkGetObject(b *testing.B) {
+	ctx := context.Background()
+
+	for _, cached := range []bool{true, false} {
+		name := "cache-miss"
+		if cached {
+			name = "cache-hit"
+		}
+
+		for _, size := range payloadSizes[:2] {
+			b.Run(name+"/"+size.name, func(b *testing.B) {
+				fileClient := newFileClient(b, m2cs.SYNC_REPLICATION)
+				if err := fileClient.PutObject(ctx, benchBox, "object", bytes.NewReader(newPayload(size.size))); err != nil {
+					b.Fatalf("PutObject failed: %v", err)
+				}
+				if cached {
+					if err := fileClient.ConfigureCache(m2cs.CacheOptions{Enabled: true}); err != nil {
+						b.Fatalf("ConfigureCache failed: %v", err)
+					}
+					defer fileClient.DisableCache()
+
+					// populate the cache
+					obj, err := fileClient.GetObject(ctx, benchBox, "object")
+					if err != nil {
+						b.Fatalf("GetObject failed: %v", err)
+					}
+					_ = obj.Close()
+				}
+
+				b.ReportAllocs()
+				b.SetBytes(int64(size.size))
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					obj, err := fileClient.GetObject(ctx, benchBox, "object")
+					if err != nil {
+						b.Fatalf("GetObject failed: %v", err)
+					}
+					_ = obj.Close()
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkSingleStorage compares the writes and reads of a client wrapping a single storage
+// through the passthrough and through the full path, see m2cs.WithDirectPassthrough.
+func BenchmarkSingleStorage(b *testing.B) {
+	ctx := context.Background()
+
+	for _, passthrough := range []bool{true, false} {
+		path := "full"
+		if passthrough {
+			path = "passthrough"
+		}
+
+		for _, size := range payloadSizes[:2] {
+			payload := newPayload(size.size)
+			fileClient, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+				[]filestorage.FileStorage{newMemoryClient(b, common.ConnectionProperties{IsMainInstance: true})},
+				m2cs.WithDirectPassthrough(passthrough))
+			if err != nil {
+				b.Fatalf("NewFileClientWithOptions failed: %v", err)
+			}
+
+			b.Run("PutObject/"+path+"/"+size.name, func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(size.size))
+				for i := 0; i < b.N; i++ {
+					if err := fileClient.PutObject(ctx, benchBox, "object", bytes.NewReader(payload)); err != nil {
+						b.Fatalf("PutObject failed: %v", err)
+					}
+				}
+			})
+
+			b.Run("GetObject/"+path+"/"+size.name, func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(size.size))
+				for i := 0; i < b.N; i++ {
+					obj, err := fileClient.GetObject(ctx, benchBox, "object")
+					if err != nil {
+						b.Fatalf("GetObject failed: %v", err)
+					}
+					if _, err := io.Copy(io.Discard, obj); err != nil {
+						b.Fatalf("failed to read object: %v", err)
+					}
+					_ = obj.Close()
+				}
+			})
+		}
+	}
+}
+
+// pipelineProperties are the transform configurations covered by the pipeline benchmarks.
+var pipelineProperties = []struct {
+	name  string
+	props common.ConnectionProperties
+}{
+	{"gzip", common.ConnectionProperties{SaveCompress: common.GZIP_COMPRESSION}},
+	{"aes", common.ConnectionProperties{SaveEncrypt: common.AES256_ENCRYPTION, EncryptKey: "m2cs"}},
+	{"gzip+aes", common.ConnectionProperties{SaveCompress: common.GZIP_COMPRESSION, SaveEncrypt: common.AES256_ENCRYPTION, EncryptKey: "m2cs"}},
+}
+
+func BenchmarkWritePipeline(b *testing.B) {
+	for _, p := range pipelineProperties {
+		for _, size := range payloadSizes[:2] {
+			b.Run(p.name+"/"+size.name, func(b *testing.B) {
+				pipe, err := transform.Factory{}.BuildWPipelineCompressEncrypt(p.props, p.props.EncryptKey)
+				if err != nil {
+					b.Fatalf("failed to build write pipeline: %v", err)
+				}
+				payload := newPayload(size.size)
+
+				b.ReportAllocs()
+				b.SetBytes(int64(size.size))
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					out, closer, err := pipe.Apply(bytes.NewReader(payload))
+					if err != nil {
+						b.Fatalf("failed to apply write pipeline: %v", err)
+					}
+					if _, err := io.Copy(io.Discard, out); err != nil {
+						b.Fatalf("failed to read transformed data: %v", err)
+					}
+					if closer != nil {
+						_ = closer.Close()
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkReadPipeline(b *testing.B) {
+	for _, p := range pipelineProperties {
+		for _, size := range payloadSizes[:2] {
+			b.Run(p.name+"/"+size.name, func(b *testing.B) {
+				wpipe, err := transform.Factory{}.BuildWPipelineCompressEncrypt(p.props, p.props.EncryptKey)
+				if err != nil {
+					b.Fatalf("failed to build write pipeline: %v", err)
+				}
+				out, _, err := wpipe.Apply(bytes.NewReader(newPayload(size.size)))
+				if err != nil {
+					b.Fatalf("failed to apply write pipeline: %v", err)
+				}
+				stored, err := io.ReadAll(out)
+				if err != nil {
+					b.Fatalf("failed to read transformed data: %v", err)
+				}
+
+				rpipe, err := transform.Factory{}.BuildRPipelineDecryptDecompress(p.props, p.props.EncryptKey)
+				if err != nil {
+					b.Fatalf("failed to build read pipeline: %v", err)
+				}
+
+				b.ReportAllocs()
+				b.SetBytes(int64(size.size))
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					rc, err := rpipe.Apply(io.NopCloser(bytes.NewReader(stored)))
+					if err != nil {
+						b.Fatalf("failed to apply read pipeline: %v", err)
+					}
+					if _, err := io.Copy(io.Discard, rc); err != nil {
+						b.Fatalf("failed to read data: %v", err)
+					}
+					_ = rc.Close()
+				}
+			})
+		}
+	}
+}
+
+// TestGetObject_CacheHitAllocations guards the allocations of a GetObject served by the
+// cache, which must not depend on the size of the object.
+func TestGetObject_CacheHitAllocations(t *testing.T) {
+	ctx := context.Background()
+
+	for _, size := range payloadSizes[:2] {
+		t.Run(size.name, func(t *testing.T) {
+			fileClient := newFileClient(t, m2cs.SYNC_REPLICATION)
+			if err := fileClient.PutObject(ctx, benchBox, "object", bytes.NewReader(newPayload(size.size))); err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+			if err := fileClient.ConfigureCache(m2cs.CacheOptions{Enabled: true}); err != nil {
+				t.Fatalf("ConfigureCache failed: %v", err)
+			}
+			defer fileClient.DisableCache()
+
+			// populate the cache
+			obj, err := fileClient.GetObject(ctx, benchBox, "object")
+			if err != nil {
+				t.Fatalf("GetObject failed: %v", err)
+			}
+			_ = obj.Close()
+
+			allocs := testing.AllocsPerRun(100, func() {
+				obj, err := fileClient.GetObject(ctx, benchBox, "object")
+				if err != nil {
+					t.Fatalf("GetObject failed: %v", err)
+				}
+				_ = obj.Close()
+			})
+
+			if allocs > cacheHitAllocBudget {
+				t.Fatalf("cache hit GetObject performs %.0f allocations, budget is %d", allocs, cacheHitAllocBudget)
+			}
+			t.Logf("cache hit GetObject performs %.0f allocations", allocs)
+		})
+	}
+}
+
+// TestPutObject_PooledBufferAllocations guards the memory allocated by 1000 PutObject of 1 MB:
+// the payload buffer comes from the pool, so the allocations must not grow with the payload.
+func TestPutObject_PooledBufferAllocations(t *testing.T) {
+	const ops = 1000
+
+	ctx := context.Background()
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		discardStorage{}, discardStorage{}, discardStorage{})
+	payload := newPayload(1 << 20)
+
+	// warm up the pool
+	if err := fileClient.PutObject(ctx, benchBox, "object", bytes.NewReader(payload)); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := 0; i < ops; i++ {
+		if err := fileClient.PutObject(ctx, benchBox, "object", bytes.NewReader(payload)); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+	}
+	runtime.ReadMemStats(&after)
+
+	perOp := (after.TotalAlloc - before.TotalAlloc) / ops
+	if perOp > uint64(len(payload))/8 {
+		t.Fatalf("PutObject of 1 MB allocates %d bytes per operation, budget is %d", perOp, len(payload)/8)
+	}
+	t.Logf("PutObject of 1 MB allocates %d bytes per operation", perOp)
+}
+
+// pipelineSource returns the pipelines of the small-object benchmarks.
+type pipelineSource struct {
+	name  string
+	write func() (transform.WritePipeline, error)
+	read  func() (transform.ReadPipeline, error)
+}
+
+// pipelineSources returns the pipelines of props built on every call, as the clients did
+// before caching them, and the ones cached by a transform.Cache.
+func pipelineSources(props common.ConnectionProperties) []pipelineSource {
+	cache := transform.NewCache(props)
+	return []pipelineSource{
+		{
+			name: "rebuilt",
+			write: func() (transform.WritePipeline, error) {
+				return transform.Factory{}.BuildWPipelineCompressEncrypt(props, props.EncryptKey)
+			},
+			read: func() (transform.ReadPipeline, error) {
+				return transform.Factory{}.BuildRPipelineWithEncoding(props, props.EncryptKey, "")
+			},
+		},
+		{
+			name:  "cached",
+			write: cache.Write,
+			read:  func() (transform.ReadPipeline, error) { return cache.Read("") },
+		},
+	}
+}
+
+// roundTrip writes payload and reads it back through the pipelines of source.
+func roundTrip(tb testing.TB, source pipelineSource, payload []byte) {
+	wpipe, err := source.write()
+	if err != nil {
+		tb.Fatalf("failed to build write pipeline: %v", err)
+	}
+	out, closer, err := wpipe.Apply(bytes.NewReader(payload))
+	if err != nil {
+		tb.Fatalf("failed to apply write pipeline: %v", err)
+	}
+	rpipe, err := source.read()
+	if err != nil {
+		tb.Fatalf("failed to build read pipeline: %v", err)
+	}
+	rc, err := rpipe.Apply(io.NopCloser(out))
+	if err != nil {
+		tb.Fatalf("failed to apply read pipeline: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		tb.Fatalf("failed to read data: %v", err)
+	}
+	_ = rc.Close()
+	if closer != nil {
+		_ = closer.Close()
+	}
+}
+
+// BenchmarkPipeline_SmallObjects compares the round trips of 1 KB objects through pipelines
+// built on every call and through cached ones.
+func BenchmarkPipeline_SmallObjects(b *testing.B) {
+	payload := newPayload(payloadSizes[0].size)
+	for _, p := range pipelineProperties {
+		for _, source := range pipelineSources(p.props) {
+			b.Run(p.name+"/"+source.name, func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(payload)))
+				for i := 0; i < b.N; i++ {
+					roundTrip(b, source, payload)
+				}
+			})
+		}
+	}
+}
+
+// TestPipeline_CachedAllocations guards the allocations saved by the cached pipelines on the
+// round trips of 1 KB objects: deriving the encryption cipher on every call is avoided.
+func TestPipeline_CachedAllocations(t *testing.T) {
+	payload := newPayload(payloadSizes[0].size)
+	for _, p := range pipelineProperties {
+		t.Run(p.name, func(t *testing.T) {
+			var allocs []float64
+			for _, source := range pipelineSources(p.props) {
+				allocs = append(allocs, testing.AllocsPerRun(100, func() { roundTrip(t, source, payload) }))
+			}
+
+			rebuilt, cached := allocs[0], allocs[1]
+			if p.props.SaveEncrypt != common.NO_ENCRYPTION && cached >= rebuilt {
+				t.Fatalf("cached pipelines perform %.0f allocations, rebuilt ones %.0f", cached, rebuilt)
+			}
+			t.Logf("round trip performs %.0f allocations with cached pipelines, %.0f with rebuilt ones", cached, rebuilt)
+		})
+	}
+}
+
+// gzipSources returns the decompressions of the small-object benchmarks: a gzip reader allocated
+// on every read, as GzipDecompress did before pooling them, and the pooled one of GzipDecompress.
+func gzipSources() []struct {
+	name  string
+	apply func(io.ReadCloser) (io.ReadCloser, error)
+} {
+	return []struct {
+		name  string
+		apply func(io.ReadCloser) (io.ReadCloser, error)
+	}{
+		{"allocated", func(rc io.ReadCloser) (io.ReadCloser, error) { return gzip.NewReader(rc) }},
+		{"pooled", compression.GzipDecompress{}.Apply},
+	}
+}
+
+// gzipped returns payload compressed with gzip.
+func gzipped(tb testing.TB, payload []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		tb.Fatalf("failed to compress: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		tb.Fatalf("failed to compress: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// decompress reads stored back through apply.
+func decompress(tb testing.TB, apply func(io.ReadCloser) (io.ReadCloser, error), stored []byte) {
+	rc, err := apply(io.NopCloser(bytes.NewReader(stored)))
+	if err != nil {
+		tb.Fatalf("failed to decompress: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		tb.Fatalf("failed to read data: %v", err)
+	}
+	_ = rc.Close()
+}
+
+// BenchmarkGzipDecompress compares the reads of 1 KB gzip objects with a gzip reader allocated
+// on every read and with the pooled ones.
+func BenchmarkGzipDecompress(b *testing.B) {
+	payload := newPayload(payloadSizes[0].size)
+	stored := gzipped(b, payload)
+	for _, source := range gzipSources() {
+		b.Run(source.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(payload)))
+			for i := 0; i < b.N; i++ {
+				decompress(b, source.apply, stored)
+			}
+		})
+	}
+}
+
+// TestGzipDecompress_PooledAllocations guards the allocations saved by the pooled gzip readers on
+// the reads of 1 KB objects, i.e. the state of the decompressor.
+func TestGzipDecompress_PooledAllocations(t *testing.T) {
+	stored := gzipped(t, newPayload(payloadSizes[0].size))
+
+	var allocs []float64
+	for _, source := range gzipSources() {
+		decompress(t, source.apply, stored) // fills the pool
+		allocs = append(allocs, testing.AllocsPerRun(100, func() { decompress(t, source.apply, stored) }))
+	}
+
+	allocated, pooled := allocs[0], allocs[1]
+	if pooled >= allocated {
+		t.Fatalf("pooled gzip readers perform %.0f allocations, allocated ones %.0f", pooled, allocated)
+	}
+	t.Logf("read performs %.0f allocations with pooled gzip readers, %.0f with allocated ones", pooled, allocated)
+}
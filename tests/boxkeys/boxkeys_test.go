@@ -0,0 +1,135 @@
+package boxkeys_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+const (
+	defaultKey  = "fTq8-Lx2!vRz9#Kp"
+	invoicesKey = "Wm3$hB7@qN1&zY5d"
+	avatarsKey  = "cR6*tJ0^uE4%sG8k"
+)
+
+func options(keys map[string]string) m2cs.ConnectionOptions {
+	return m2cs.ConnectionOptions{
+		ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+		IsMainInstance:   true,
+		SaveEncrypt:      m2cs.AES256_ENCRYPTION,
+		EncryptKey:       defaultKey,
+		EncryptKeysByBox: keys,
+		ProbeBox:         "invoices",
+		Region:           "us-east-1",
+	}
+}
+
+func connect(t *testing.T, s *storagetest.MinioServer, opts m2cs.ConnectionOptions) *filestorage.MinioClient {
+	client, err := m2cs.NewMinIOConnection(s.URL, opts, nil)
+	require.NoError(t, err)
+	return client
+}
+
+func put(t *testing.T, client filestorage.FileStorage, storeBox, fileName, content string) {
+	require.NoError(t, client.PutObject(context.Background(), storeBox, fileName, strings.NewReader(content)))
+}
+
+func get(client filestorage.FileStorage, storeBox, fileName string) (string, error) {
+	reader, err := client.GetObject(context.Background(), storeBox, fileName)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	return string(data), err
+}
+
+func TestEncryptKeysByBox(t *testing.T) {
+	s := storagetest.NewMinioServer(t)
+	keys := map[string]string{"invoices": invoicesKey, "avatars": avatarsKey}
+	client := connect(t, s, options(keys))
+
+	put(t, client, "invoices", "2024.pdf", "invoice")
+	put(t, client, "avatars", "me.png", "avatar")
+	put(t, client, "other", "file.txt", "other")
+	for path, content := range map[string]string{"invoices/2024.pdf": "invoice", "avatars/me.png": "avatar", "other/file.txt": "other"} {
+		assert.NotContains(t, string(s.Object(path).Data), content, "%s is saved encrypted", path)
+	}
+
+	for _, c := range []struct{ storeBox, fileName, content string }{
+		{"invoices", "2024.pdf", "invoice"},
+		{"avatars", "me.png", "avatar"},
+		{"other", "file.txt", "other"},
+	} {
+		got, err := get(client, c.storeBox, c.fileName)
+		require.NoError(t, err)
+		assert.Equal(t, c.content, got)
+	}
+
+	// an invoice moved to the avatars is read with the key of the avatars
+	s.SetObject("avatars/2024.pdf", s.Object("invoices/2024.pdf"))
+	_, err := get(client, "avatars", "2024.pdf")
+	assert.Error(t, err)
+
+	// the store boxes without their own key are encrypted with EncryptKey
+	fallback := connect(t, s, options(nil))
+	got, err := get(fallback, "other", "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "other", got)
+	_, err = get(fallback, "invoices", "2024.pdf")
+	assert.Error(t, err)
+}
+
+func TestEncryptKeysByBox_WrongKey(t *testing.T) {
+	s := storagetest.NewMinioServer(t)
+	writer := connect(t, s, options(map[string]string{"invoices": invoicesKey, "avatars": avatarsKey}))
+	put(t, writer, "invoices", "2024.pdf", "invoice")
+
+	swapped := connect(t, s, options(map[string]string{"invoices": avatarsKey, "avatars": invoicesKey}))
+	_, err := get(swapped, "invoices", "2024.pdf")
+	assert.Error(t, err)
+
+	reader := connect(t, s, options(map[string]string{"invoices": invoicesKey}))
+	got, err := get(reader, "invoices", "2024.pdf")
+	require.NoError(t, err)
+	assert.Equal(t, "invoice", got)
+}
+
+func TestEncryptKeysByBox_Aliases(t *testing.T) {
+	s := storagetest.NewMinioServer(t)
+	opts := options(map[string]string{"invoices": invoicesKey})
+	opts.BoxAliases = map[string]string{"invoices": "prod-invoices"}
+	client := connect(t, s, opts)
+	put(t, client, "invoices", "2024.pdf", "invoice")
+
+	// the keys are by logical name, the objects by physical name
+	direct := connect(t, s, options(map[string]string{"prod-invoices": invoicesKey}))
+	got, err := get(direct, "prod-invoices", "2024.pdf")
+	require.NoError(t, err)
+	assert.Equal(t, "invoice", got)
+}
+
+func TestEncryptKeysByBox_Validation(t *testing.T) {
+	s := storagetest.NewMinioServer(t)
+
+	_, err := m2cs.NewMinIOConnection(s.URL, options(map[string]string{"invoices": ""}), nil)
+	assert.EqualError(t, err, `invalid MinIO connection: invalid EncryptKeysByBox entry "invoices": store box names and keys cannot be empty`)
+
+	_, err = m2cs.NewMinIOConnection(s.URL, options(map[string]string{"invoices": "m2cs"}), nil)
+	assert.ErrorIs(t, err, m2cs.ErrWeakEncryptKey)
+	assert.ErrorContains(t, err, `EncryptKeysByBox["invoices"] has 4 characters, at least 16 are required`)
+
+	opts := options(map[string]string{"invoices": invoicesKey})
+	opts.SaveEncrypt, opts.EncryptKey = m2cs.NO_ENCRYPTION, ""
+	opts.OnWarning = func(warning error) error { return warning }
+	_, err = m2cs.NewMinIOConnection(s.URL, opts, nil)
+	assert.ErrorIs(t, err, m2cs.ErrUnusedEncryptKey)
+}
@@ -0,0 +1,437 @@
+package buffers_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+const threshold = 16
+
+// hooked is a storage calling onPut before its writes and failing them with putErr, and
+// recording the largest read of the objects it returns.
+type hooked struct {
+	*filestorage.MemoryClient
+	onPut  func()
+	putErr error
+
+	mu      sync.Mutex
+	maxRead int
+}
+
+func (h *hooked) PutObject(ctx context.Context, storeBox, fileName string, reader io.Reader) error {
+	if h.onPut != nil {
+		h.onPut()
+	}
+	if h.putErr != nil {
+		return h.putErr
+	}
+	return h.MemoryClient.PutObject(ctx, storeBox, fileName, reader)
+}
+
+func (h *hooked) GetObject(ctx context.Context, storeBox, fileName string) (io.ReadCloser, error) {
+	obj, err := h.MemoryClient.GetObject(ctx, storeBox, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{readerFunc(func(p []byte) (int, error) {
+		n, err := obj.Read(p)
+		h.mu.Lock()
+		h.maxRead = max(h.maxRead, n)
+		h.mu.Unlock()
+		return n, err
+	}), obj}, nil
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (r readerFunc) Read(p []byte) (int, error) { return r(p) }
+
+// failingReader returns content, then fails.
+type failingReader struct{ r io.Reader }
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func newStorages(t *testing.T, labels ...string) []*hooked {
+	var storages []*hooked
+	for _, label := range labels {
+		memory := filestorage.NewMemoryClient(common.ConnectionProperties{Label: label, IsMainInstance: true})
+		require.NoError(t, memory.MakeBucket(context.Background(), "box"))
+		storages = append(storages, &hooked{MemoryClient: memory})
+	}
+	return storages
+}
+
+func newClient(t *testing.T, mode m2cs.ReplicationMode, storages []*hooked, opts ...m2cs.FileClientOption) *m2cs.FileClient {
+	var fs []filestorage.FileStorage
+	for _, s := range storages {
+		fs = append(fs, s)
+	}
+	client, err := m2cs.NewFileClientWithOptions(mode, m2cs.READ_REPLICA_FIRST, fs, opts...)
+	require.NoError(t, err)
+	return client
+}
+
+func spooled(t *testing.T, dir string) int {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	return len(entries)
+}
+
+func read(t *testing.T, s filestorage.FileStorage, key string) string {
+	obj, err := s.GetObject(context.Background(), "box", key)
+	require.NoError(t, err)
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestBuffers_Options(t *testing.T) {
+	for name, opt := range map[string]m2cs.FileClientOption{
+		"threshold": m2cs.WithSpoolThreshold(0),
+		"buffer":    m2cs.WithCopyBufferSize(-1),
+		"missing":   m2cs.WithTempDir(filepath.Join(t.TempDir(), "missing")),
+		"file":      m2cs.WithTempDir("buffers_test.go"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN,
+				[]filestorage.FileStorage{newStorages(t, "a")[0]}, opt)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestBuffers_SpoolPut(t *testing.T) {
+	dir := t.TempDir()
+	storages := newStorages(t, "a", "b")
+	var during []int
+	for _, s := range storages {
+		s.onPut = func() { during = append(during, spooled(t, dir)) }
+	}
+	client := newClient(t, m2cs.SYNC_REPLICATION, storages,
+		m2cs.WithSpoolThreshold(threshold), m2cs.WithTempDir(dir), m2cs.WithMaxStorageConcurrency(1))
+
+	// a payload within the threshold is held in memory
+	require.NoError(t, client.PutObject(context.Background(), "box", "small", strings.NewReader("small")))
+	assert.Equal(t, []int{0, 0}, during)
+
+	during = nil
+	content := strings.Repeat("spooled ", 10)
+	require.NoError(t, client.PutObject(context.Background(), "box", "large", bytes.NewBufferString(content)))
+	assert.Equal(t, []int{1, 1}, during, "every storage is written from the spooled file")
+	assert.Zero(t, spooled(t, dir), "the spooled file is removed")
+
+	// a reader read in place is never spooled
+	during = nil
+	require.NoError(t, client.PutObject(context.Background(), "box", "large", strings.NewReader(content)))
+	assert.Equal(t, []int{0, 0}, during)
+
+	// a reader not reporting its length is buffered up to the threshold before spilling
+	during = nil
+	require.NoError(t, client.PutObject(context.Background(), "box", "stream", io.MultiReader(strings.NewReader(content))))
+	assert.Equal(t, []int{1, 1}, during)
+	assert.Zero(t, spooled(t, dir))
+
+	for _, s := range storages {
+		assert.Equal(t, "small", read(t, s, "small"))
+		assert.Equal(t, content, read(t, s, "large"))
+		assert.Equal(t, content, read(t, s, "stream"))
+	}
+}
+
+func TestBuffers_SpoolPut_ErrorPaths(t *testing.T) {
+	dir := t.TempDir()
+	storages := newStorages(t, "a", "b")
+	client := newClient(t, m2cs.SYNC_REPLICATION, storages, m2cs.WithSpoolThreshold(threshold), m2cs.WithTempDir(dir))
+	content := strings.Repeat("x", 100)
+
+	err := client.PutObjectWithOptions(context.Background(), "box", "key", strings.NewReader(content), m2cs.PutOptions{Size: 200})
+	assert.ErrorIs(t, err, m2cs.ErrUnexpectedPayload)
+	assert.Zero(t, spooled(t, dir))
+
+	err = client.PutObjectWithOptions(context.Background(), "box", "key", io.MultiReader(strings.NewReader(content)), m2cs.PutOptions{MaxSize: 50})
+	assert.ErrorIs(t, err, m2cs.ErrObjectTooLarge)
+	assert.Zero(t, spooled(t, dir))
+
+	err = client.PutObject(context.Background(), "box", "key", &failingReader{r: strings.NewReader(content)})
+	assert.ErrorContains(t, err, "connection reset")
+	assert.Zero(t, spooled(t, dir))
+
+	for _, s := range storages {
+		s.putErr = errors.New("storage down")
+	}
+	err = client.PutObject(context.Background(), "box", "key", strings.NewReader(content))
+	assert.ErrorContains(t, err, "storage down")
+	assert.Zero(t, spooled(t, dir))
+}
+
+func TestBuffers_SpoolPut_Async(t *testing.T) {
+	dir := t.TempDir()
+	storages := newStorages(t, "a", "b", "c")
+	release := make(chan struct{})
+	storages[2].onPut = func() { <-release }
+	client := newClient(t, m2cs.ASYNC_REPLICATION, storages, m2cs.WithSpoolThreshold(threshold), m2cs.WithTempDir(dir))
+
+	content := strings.Repeat("async ", 10)
+	require.NoError(t, client.PutObject(context.Background(), "box", "key", strings.NewReader(content)))
+	assert.Equal(t, 1, spooled(t, dir), "the spooled file is kept for the background writes")
+
+	close(release)
+	assert.Eventually(t, func() bool { return client.ReplicationStatus().Backlog == 0 }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return spooled(t, dir) == 0 }, time.Second, 10*time.Millisecond)
+	for _, s := range storages {
+		assert.Equal(t, content, read(t, s, "key"))
+	}
+}
+
+func TestBuffers_SpoolGet(t *testing.T) {
+	dir := t.TempDir()
+	storages := newStorages(t, "a")
+	client := newClient(t, m2cs.SYNC_REPLICATION, storages, m2cs.WithSpoolThreshold(threshold), m2cs.WithTempDir(dir))
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, MaxSizeMB: 1, MaxItems: 10}))
+
+	content := strings.Repeat("download ", 10)
+	require.NoError(t, storages[0].MemoryClient.PutObject(context.Background(), "box", "large", strings.NewReader(content)))
+	require.NoError(t, storages[0].MemoryClient.PutObject(context.Background(), "box", "small", strings.NewReader("small")))
+
+	obj, err := client.GetObject(context.Background(), "box", "large")
+	require.NoError(t, err)
+	assert.Equal(t, 1, spooled(t, dir))
+
+	seeker, ok := obj.(io.ReadSeeker)
+	require.True(t, ok)
+	_, err = seeker.Seek(int64(len("download ")), io.SeekStart)
+	require.NoError(t, err)
+	var sb strings.Builder
+	_, err = io.Copy(&sb, obj)
+	require.NoError(t, err)
+	assert.Equal(t, content[len("download "):], sb.String())
+
+	require.NoError(t, obj.Close())
+	require.NoError(t, obj.Close())
+	assert.Zero(t, spooled(t, dir), "the spooled object is removed on Close")
+	_, cached := client.CacheContains("box", "large")
+	assert.False(t, cached, "spooled objects are not cached")
+
+	obj, err = client.GetObject(context.Background(), "box", "small")
+	require.NoError(t, err)
+	require.NoError(t, obj.Close())
+	assert.Zero(t, spooled(t, dir))
+	_, cached = client.CacheContains("box", "small")
+	assert.True(t, cached)
+}
+
+func TestBuffers_CopyBufferSize(t *testing.T) {
+	storages := newStorages(t, "a")
+	client := newClient(t, m2cs.SYNC_REPLICATION, storages, m2cs.WithCopyBufferSize(7))
+
+	content := strings.Repeat("copy buffer ", 100)
+	require.NoError(t, client.PutObject(context.Background(), "box", "key", strings.NewReader(content)))
+
+	path := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, client.FGetObject(context.Background(), "box", "key", path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+	assert.Equal(t, 7, storages[0].maxRead, "the object is copied through the configured buffer")
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "parallel"))
+	require.NoError(t, err)
+	defer file.Close()
+	n, err := client.DownloadParallel(context.Background(), "box", "key", file, m2cs.ParallelOptions{PartSize: 100, Concurrency: 3})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+	data, err = os.ReadFile(file.Name())
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+}
+
+// digest is a storage hashing the payloads it is written, instead of holding them.
+type digest struct {
+	*filestorage.MemoryClient
+
+	mu   sync.Mutex
+	sums map[string][sha256.Size]byte
+}
+
+func (d *digest) PutObject(_ context.Context, _, fileName string, reader io.Reader) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sums[fileName] = [sha256.Size]byte(h.Sum(nil))
+	return nil
+}
+
+// writeFile writes size bytes of deterministic data to a file in dir, returning their digest.
+func writeFile(t *testing.T, dir string, size int) (string, [sha256.Size]byte) {
+	file, err := os.Create(filepath.Join(dir, "payload"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	chunk := make([]byte, 1<<20)
+	h := sha256.New()
+	w := io.MultiWriter(file, h)
+	for written := 0; written < size; written += len(chunk) {
+		for i := range chunk {
+			chunk[i] = byte((written + i) % 251)
+		}
+		_, err := w.Write(chunk[:min(len(chunk), size-written)])
+		require.NoError(t, err)
+	}
+	return file.Name(), [sha256.Size]byte(h.Sum(nil))
+}
+
+func TestBuffers_ReaderAtPut_LargeFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a 128 MB file")
+	}
+	const size = 128 << 20
+	path, sum := writeFile(t, t.TempDir(), size)
+
+	var (
+		digests []*digest
+		fs      []filestorage.FileStorage
+	)
+	for _, label := range []string{"a", "b", "c"} {
+		d := &digest{
+			MemoryClient: filestorage.NewMemoryClient(common.ConnectionProperties{Label: label, IsMainInstance: true}),
+			sums:         map[string][sha256.Size]byte{},
+		}
+		digests = append(digests, d)
+		fs = append(fs, d)
+	}
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN, fs)
+	require.NoError(t, err)
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	require.NoError(t, client.PutObject(context.Background(), "box", "key", file))
+	runtime.ReadMemStats(&after)
+
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(size/16), "the file is not buffered")
+	for _, d := range digests {
+		assert.Equal(t, sum, d.sums["key"])
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), offset, "the file is left at its end, as if read")
+}
+
+func TestBuffers_ReaderAtPut(t *testing.T) {
+	content := strings.Repeat("in place ", 10)
+	path := filepath.Join(t.TempDir(), "payload")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	t.Run("consumed file", func(t *testing.T) {
+		dir := t.TempDir()
+		storages := newStorages(t, "a", "b")
+		client := newClient(t, m2cs.SYNC_REPLICATION, storages, m2cs.WithSpoolThreshold(threshold), m2cs.WithTempDir(dir))
+		for _, s := range storages {
+			s.onPut = func() { assert.Zero(t, spooled(t, dir), "the file is read in place") }
+		}
+
+		file, err := os.Open(path)
+		require.NoError(t, err)
+		defer file.Close()
+		_, err = file.Seek(3, io.SeekStart)
+		require.NoError(t, err)
+
+		require.NoError(t, client.PutObject(context.Background(), "box", "key", file))
+		for _, s := range storages {
+			assert.Equal(t, content[3:], read(t, s, "key"), "the file is written from its position")
+		}
+		offset, err := file.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(content)), offset)
+	})
+
+	t.Run("async file", func(t *testing.T) {
+		storages := newStorages(t, "a", "b")
+		release := make(chan struct{})
+		storages[1].onPut = func() { <-release }
+		client := newClient(t, m2cs.ASYNC_REPLICATION, storages)
+
+		file, err := os.Open(path)
+		require.NoError(t, err)
+		require.NoError(t, client.PutObject(context.Background(), "box", "key", file))
+		require.NoError(t, file.Close(), "the background writes do not use the descriptor of the caller")
+
+		close(release)
+		assert.Eventually(t, func() bool { return client.ReplicationStatus().Backlog == 0 }, time.Second, 10*time.Millisecond)
+		for _, s := range storages {
+			assert.Equal(t, content, read(t, s, "key"))
+		}
+	})
+
+	t.Run("async reader", func(t *testing.T) {
+		storages := newStorages(t, "a", "b")
+		release := make(chan struct{})
+		storages[1].onPut = func() { <-release }
+		client := newClient(t, m2cs.ASYNC_REPLICATION, storages)
+
+		data := []byte(content)
+		require.NoError(t, client.PutObject(context.Background(), "box", "key", bytes.NewReader(data)))
+		copy(data, "reused")
+
+		close(release)
+		assert.Eventually(t, func() bool { return client.ReplicationStatus().Backlog == 0 }, time.Second, 10*time.Millisecond)
+		for _, s := range storages {
+			assert.Equal(t, content, read(t, s, "key"), "the reader is buffered for the background writes")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		storages := newStorages(t, "a", "b")
+		client := newClient(t, m2cs.SYNC_REPLICATION, storages)
+		reader := strings.NewReader(content)
+
+		err := client.PutObjectWithOptions(context.Background(), "box", "key", reader, m2cs.PutOptions{Size: 200})
+		assert.ErrorIs(t, err, m2cs.ErrUnexpectedPayload)
+		err = client.PutObjectWithOptions(context.Background(), "box", "key", reader, m2cs.PutOptions{MaxSize: 50})
+		assert.ErrorIs(t, err, m2cs.ErrObjectTooLarge)
+		assert.Equal(t, len(content), reader.Len(), "nothing is read before failing")
+
+		_, err = reader.Seek(0, io.SeekEnd)
+		require.NoError(t, err)
+		err = client.PutObjectWithOptions(context.Background(), "box", "key", reader, m2cs.PutOptions{ExpectNonEmpty: true})
+		assert.ErrorIs(t, err, m2cs.ErrUnexpectedPayload)
+		for _, s := range storages {
+			exists, err := s.ExistObject(context.Background(), "box", "key")
+			require.NoError(t, err)
+			assert.False(t, exists)
+		}
+	})
+}
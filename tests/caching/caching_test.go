@@ -0,0 +1,1197 @@
+package caching_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/internal/caching"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+func newCache(options caching.CacheOptions) *caching.FileCache {
+	options.Enabled = true
+	if options.MaxSizeMB == 0 {
+		options.MaxSizeMB = 1
+	}
+	if options.TTL == 0 {
+		options.TTL = time.Minute
+	}
+	if options.MaxItems == 0 {
+		options.MaxItems = 10
+	}
+	return &caching.FileCache{File: make(map[string]*caching.FileInformation), Options: options}
+}
+
+// newClockedClient returns a client of storage telling the time with clk.
+func newClockedClient(t *testing.T, clk m2cs.Clock, storage filestorage.FileStorage) *m2cs.FileClient {
+	t.Helper()
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{storage}, m2cs.WithClock(clk))
+	require.NoError(t, err)
+	return client
+}
+
+func cached(t *testing.T, cache *caching.FileCache, key string) (string, bool) {
+	t.Helper()
+	rc := cache.GetFile(key)
+	if rc == nil {
+		return "", false
+	}
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(data), true
+}
+
+func TestFileCache_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	clk := storagetest.NewFakeClock(time.Now())
+	cache := newCache(caching.CacheOptions{Clock: clk})
+	for i := 0; i < 5; i++ {
+		cache.Store(fmt.Sprintf("box/key-%d", i), []byte(fmt.Sprintf("data-%d", i)))
+	}
+	cache.Store("box/empty", []byte{})
+	require.NoError(t, cache.SaveSnapshot(path))
+
+	restored := newCache(caching.CacheOptions{Clock: clk})
+	require.NoError(t, restored.LoadSnapshot(path))
+	for i := 0; i < 5; i++ {
+		data, ok := cached(t, restored, fmt.Sprintf("box/key-%d", i))
+		assert.True(t, ok, "key-%d should be restored", i)
+		assert.Equal(t, fmt.Sprintf("data-%d", i), data)
+	}
+	data, ok := cached(t, restored, "box/empty")
+	assert.True(t, ok)
+	assert.Empty(t, data)
+
+	// the restored entries keep their age, so they expire with the TTL of the new cache
+	shortTTL := newCache(caching.CacheOptions{TTL: time.Millisecond, Clock: clk})
+	clk.Advance(5 * time.Millisecond)
+	require.NoError(t, shortTTL.LoadSnapshot(path))
+	assert.Empty(t, shortTTL.File, "entries past the TTL should not be restored")
+
+	// at most MaxItems entries are restored
+	small := newCache(caching.CacheOptions{MaxItems: 2})
+	require.NoError(t, small.LoadSnapshot(path))
+	assert.Len(t, small.File, 2)
+}
+
+func TestFileCache_Snapshot_Filters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	clk := storagetest.NewFakeClock(time.Now())
+	cache := newCache(caching.CacheOptions{TTL: 50 * time.Millisecond, SnapshotMaxItemBytes: 4, Clock: clk})
+	cache.Store("box/expired", []byte("old"))
+	clk.Advance(60 * time.Millisecond)
+	cache.Store("box/small", []byte("tiny"))
+	cache.Store("box/large", []byte("too large"))
+	require.NoError(t, cache.SaveSnapshot(path))
+
+	restored := newCache(caching.CacheOptions{Clock: clk})
+	require.NoError(t, restored.LoadSnapshot(path))
+	_, ok := cached(t, restored, "box/small")
+	assert.True(t, ok)
+	_, ok = cached(t, restored, "box/expired")
+	assert.False(t, ok, "expired entries should not be saved")
+	_, ok = cached(t, restored, "box/large")
+	assert.False(t, ok, "entries over SnapshotMaxItemBytes should not be saved")
+
+	aged := newCache(caching.CacheOptions{SnapshotMaxAge: time.Millisecond, Clock: clk})
+	clk.Advance(5 * time.Millisecond)
+	require.NoError(t, aged.LoadSnapshot(path))
+	assert.Empty(t, aged.File, "entries over SnapshotMaxAge should not be restored")
+}
+
+func TestFileCache_Snapshot_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache.snapshot")
+
+	cache := newCache(caching.CacheOptions{})
+	cache.Store("box/key", []byte(strings.Repeat("data", 64)))
+	require.NoError(t, cache.SaveSnapshot(path))
+	valid, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	flipped := append([]byte(nil), valid...)
+	flipped[bytes.Index(flipped, []byte("datadata"))] ^= 0xff
+
+	for name, content := range map[string][]byte{
+		"empty":     {},
+		"garbage":   []byte("not a snapshot"),
+		"truncated": valid[:len(valid)/2],
+		"flipped":   flipped,
+	} {
+		require.NoError(t, os.WriteFile(path, content, 0o600))
+		restored := newCache(caching.CacheOptions{})
+		restored.Store("box/other", []byte("kept"))
+		err := restored.LoadSnapshot(path)
+		assert.ErrorIs(t, err, caching.ErrCorruptSnapshot, name)
+		assert.Len(t, restored.File, 1, "%s: the cache should be left unchanged", name)
+	}
+
+	// a FileClient starts with a cold cache instead of failing
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+	assert.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, SnapshotPath: path}))
+}
+
+func TestFileClient_CacheSnapshotOnClose(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	require.NoError(t, storage.MakeBucket(ctx, "box"))
+	require.NoError(t, storage.PutObject(ctx, "box", "key", strings.NewReader("content")))
+
+	options := m2cs.CacheOptions{Enabled: true, SnapshotPath: path, SnapshotOnClose: true}
+	assert.Error(t, m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage).
+		ConfigureCache(m2cs.CacheOptions{Enabled: true, SnapshotOnClose: true}), "SnapshotOnClose requires a path")
+
+	// no snapshot yet: a cold start
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+	require.NoError(t, client.ConfigureCache(options))
+	obj, err := client.GetObject(ctx, "box", "key")
+	require.NoError(t, err)
+	obj.Close()
+	require.NoError(t, client.Close())
+
+	// the restarted client serves the object from the restored cache
+	require.NoError(t, storage.RemoveObject(ctx, "box", "key"))
+	restarted := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+	require.NoError(t, restarted.ConfigureCache(options))
+	defer restarted.Close()
+	obj, err = restarted.GetObject(ctx, "box", "key")
+	require.NoError(t, err)
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+}
+
+func TestFileClient_CacheMaxItemSize(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	require.NoError(t, storage.MakeBucket(ctx, "box"))
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, MaxSizeMB: 8, MaxItemSizeMB: 1, MaxItems: 3}))
+	assert.Error(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, MaxItemSizeMB: -1}))
+
+	hot := []string{"hot-1", "hot-2", "hot-3"}
+	for _, key := range hot {
+		require.NoError(t, client.PutObject(ctx, "box", key, strings.NewReader("content of "+key)))
+		obj, err := client.GetObject(ctx, "box", key)
+		require.NoError(t, err)
+		obj.Close()
+	}
+
+	require.NoError(t, client.PutObject(ctx, "box", "huge", bytes.NewReader(make([]byte, 2<<20))))
+	obj, err := client.GetObject(ctx, "box", "huge")
+	require.NoError(t, err)
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	assert.Len(t, data, 2<<20)
+
+	// the hot objects are still served by the cache once removed from the storage
+	for _, key := range hot {
+		require.NoError(t, storage.RemoveObject(ctx, "box", key))
+		obj, err := client.GetObject(ctx, "box", key)
+		if assert.NoError(t, err, "%s should not have been evicted by the huge read", key) {
+			data, err := io.ReadAll(obj)
+			require.NoError(t, err)
+			assert.Equal(t, "content of "+key, string(data))
+		}
+	}
+	require.NoError(t, storage.RemoveObject(ctx, "box", "huge"))
+	_, err = client.GetObject(ctx, "box", "huge")
+	assert.Error(t, err, "the huge object should not have been cached")
+}
+
+func TestFileCache_Admission(t *testing.T) {
+	cache := newCache(caching.CacheOptions{AdmissionMinHits: 2, AdmissionWindow: 1000})
+
+	cache.Store("box/key", []byte("data"))
+	_, ok := cached(t, cache, "box/key")
+	assert.False(t, ok, "a key should not be cached on its first access")
+
+	cache.Store("box/key", []byte("data"))
+	data, ok := cached(t, cache, "box/key")
+	assert.True(t, ok, "a key should be cached on its second access")
+	assert.Equal(t, "data", data)
+
+	// one-off keys do not evict the admitted ones
+	for i := 0; i < 20; i++ {
+		cache.Store(fmt.Sprintf("box/once-%d", i), []byte("once"))
+	}
+	assert.Len(t, cache.File, 1)
+	_, ok = cached(t, cache, "box/key")
+	assert.True(t, ok)
+
+	// preloaded items bypass the filter
+	cache.Preload("box/preloaded", []byte("data"))
+	_, ok = cached(t, cache, "box/preloaded")
+	assert.True(t, ok)
+
+	// the counts fade once the window is over
+	aging := newCache(caching.CacheOptions{AdmissionMinHits: 2, AdmissionWindow: 64})
+	aging.Store("box/rare", []byte("data"))
+	for i := 0; i < 64; i++ {
+		aging.Store("box/noise", []byte("noise"))
+	}
+	aging.Store("box/rare", []byte("data"))
+	_, ok = cached(t, aging, "box/rare")
+	assert.False(t, ok, "accesses of a past window should not count")
+
+	// without the filter every key is cached on its first access
+	always := newCache(caching.CacheOptions{})
+	always.Store("box/key", []byte("data"))
+	_, ok = cached(t, always, "box/key")
+	assert.True(t, ok)
+}
+
+func TestFileClient_CacheEntries(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	require.NoError(t, storage.MakeBucket(ctx, "box"))
+	clk := storagetest.NewFakeClock(time.Now())
+	client := newClockedClient(t, clk, storage)
+	assert.Nil(t, client.CacheEntries(), "no entries without a cache")
+
+	const ttl = 200 * time.Millisecond
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, TTL: ttl, MaxItems: 10}))
+
+	read := func(key string) {
+		obj, err := client.GetObject(ctx, "box", key)
+		require.NoError(t, err)
+		_, err = io.ReadAll(obj)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, client.PutObject(ctx, "box", "a.txt", strings.NewReader("aaaa")))
+	require.NoError(t, client.PutObject(ctx, "box", "b.txt", strings.NewReader("bb")))
+
+	stored := clk.Now()
+	read("a.txt") // miss, stored
+	read("a.txt") // hit
+	read("a.txt") // hit
+	read("b.txt") // miss, stored
+
+	entries := client.CacheEntries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "box/a.txt", entries[0].Key)
+	assert.Equal(t, int64(4), entries[0].SizeBytes)
+	assert.Equal(t, int64(2), entries[0].Hits)
+	assert.Equal(t, "box/b.txt", entries[1].Key)
+	assert.Equal(t, int64(2), entries[1].SizeBytes)
+	assert.Zero(t, entries[1].Hits)
+	for _, entry := range entries {
+		assert.Equal(t, stored, entry.StoredAt, entry.Key)
+		assert.Equal(t, entry.StoredAt.Add(ttl), entry.ExpiresAt)
+	}
+
+	info, ok := client.CacheContains("box", "a.txt")
+	assert.True(t, ok)
+	assert.Equal(t, entries[0], info, "CacheContains should not count a hit")
+	_, ok = client.CacheContains("box", "missing.txt")
+	assert.False(t, ok)
+
+	// a write invalidates the entry; the next read stores it again with no hits
+	require.NoError(t, client.PutObject(ctx, "box", "a.txt", strings.NewReader("aaaaaa")))
+	_, ok = client.CacheContains("box", "a.txt")
+	assert.False(t, ok)
+	read("a.txt")
+	info, ok = client.CacheContains("box", "a.txt")
+	assert.True(t, ok)
+	assert.Equal(t, int64(6), info.SizeBytes)
+	assert.Zero(t, info.Hits)
+
+	// expired entries are not reported
+	clk.Advance(ttl + time.Millisecond)
+	assert.Empty(t, client.CacheEntries())
+	_, ok = client.CacheContains("box", "b.txt")
+	assert.False(t, ok)
+}
+
+func TestFileCache_Entries_Pages(t *testing.T) {
+	cache := newCache(caching.CacheOptions{MaxItems: 1000})
+	for i := 0; i < 600; i++ {
+		cache.Store(fmt.Sprintf("box/key-%04d", i), []byte("data"))
+	}
+
+	entries := cache.Entries()
+	require.Len(t, entries, 600)
+	for i, entry := range entries {
+		assert.Equal(t, fmt.Sprintf("box/key-%04d", i), entry.Key)
+	}
+}
+
+func TestFileClient_CacheState(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	require.NoError(t, storage.MakeBucket(ctx, "box"))
+	require.NoError(t, storage.PutObject(ctx, "box", "key", strings.NewReader("content")))
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+
+	read := func() {
+		obj, err := client.GetObject(ctx, "box", "key")
+		require.NoError(t, err)
+		obj.Close()
+	}
+	isCached := func() bool {
+		_, ok := client.CacheContains("box", "key")
+		return ok
+	}
+
+	// unconfigured: enabling and disabling fail, reads are not cached
+	assert.Equal(t, m2cs.CACHE_UNCONFIGURED, client.CacheState())
+	for op, transition := range map[string]func() error{"EnableCache": client.EnableCache, "DisableCache": client.DisableCache} {
+		err := transition()
+		assert.ErrorIs(t, err, m2cs.ErrCacheNotConfigured, op)
+		var stateErr *m2cs.CacheStateError
+		if assert.ErrorAs(t, err, &stateErr, op) {
+			assert.Equal(t, op, stateErr.Op)
+			assert.Equal(t, m2cs.CACHE_UNCONFIGURED, stateErr.State)
+		}
+		assert.Equal(t, m2cs.CACHE_UNCONFIGURED, client.CacheState(), op)
+	}
+	_, err := client.Prefetch(ctx, "box", []string{"key"}, m2cs.PrefetchOptions{})
+	assert.ErrorIs(t, err, m2cs.ErrCacheNotConfigured)
+	read()
+	assert.False(t, isCached())
+
+	// configured without Enabled: ready but off
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: false}))
+	assert.Equal(t, m2cs.CACHE_DISABLED, client.CacheState())
+	_, err = client.Prefetch(ctx, "box", []string{"key"}, m2cs.PrefetchOptions{})
+	assert.ErrorIs(t, err, m2cs.ErrCacheNotEnabled)
+	assert.NotErrorIs(t, err, m2cs.ErrCacheNotConfigured)
+	read()
+	assert.False(t, isCached())
+	assert.NoError(t, client.DisableCache(), "disabling a disabled cache should do nothing")
+	assert.Equal(t, m2cs.CACHE_DISABLED, client.CacheState())
+
+	// disabled -> enabled
+	assert.NoError(t, client.EnableCache())
+	assert.Equal(t, m2cs.CACHE_ENABLED, client.CacheState())
+	read()
+	assert.True(t, isCached())
+	assert.NoError(t, client.EnableCache(), "enabling an enabled cache should do nothing")
+	assert.Equal(t, m2cs.CACHE_ENABLED, client.CacheState())
+
+	// enabled -> disabled keeps the configuration
+	assert.NoError(t, client.DisableCache())
+	assert.Equal(t, m2cs.CACHE_DISABLED, client.CacheState())
+	assert.True(t, client.Describe().Cache.Configured)
+	assert.NoError(t, client.EnableCache())
+	assert.Equal(t, m2cs.CACHE_ENABLED, client.CacheState())
+
+	// reconfiguring sets the state from the options
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: false}))
+	assert.Equal(t, m2cs.CACHE_DISABLED, client.CacheState())
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true}))
+	assert.Equal(t, m2cs.CACHE_ENABLED, client.CacheState())
+
+	assert.Equal(t, "CACHE_ENABLED", m2cs.CACHE_ENABLED.String())
+	assert.Equal(t, "CacheState(7)", m2cs.CacheState(7).String())
+}
+
+// countingRunner is a caching.ValidationRunner counting its runs.
+type countingRunner struct {
+	runs atomic.Int64
+}
+
+func (r *countingRunner) Apply(*caching.FileCache) error {
+	r.runs.Add(1)
+	return nil
+}
+
+// assertValidationFires advances clk by interval, once the validation routine waits for it, and
+// waits for the runner to run again.
+func assertValidationFires(t *testing.T, clk *storagetest.FakeClock, interval time.Duration, runner *countingRunner, msg string) {
+	t.Helper()
+	runs := runner.runs.Load()
+	clk.BlockUntil(1)
+	clk.Advance(interval)
+	assert.Eventually(t, func() bool { return runner.runs.Load() > runs }, time.Second, 5*time.Millisecond, msg)
+}
+
+func TestFileCache_ValidationIntervalChange(t *testing.T) {
+	runner := &countingRunner{}
+	options := func(interval time.Duration) *caching.ValidationOptions {
+		return &caching.ValidationOptions{Runner: runner, ValidationInterval: interval}
+	}
+
+	clk := storagetest.NewFakeClock(time.Now())
+	cache := newCache(caching.CacheOptions{ValidationOptions: options(20 * time.Millisecond), Clock: clk})
+	require.NoError(t, cache.StartValidationRoutine())
+	defer cache.StopValidationRoutine()
+	assertValidationFires(t, clk, 20*time.Millisecond, runner, "validation should fire")
+
+	cache.SetValidationOptions(options(10 * time.Millisecond))
+	assertValidationFires(t, clk, 10*time.Millisecond, runner, "validation should fire after the first interval change")
+	cache.SetValidationOptions(options(15 * time.Millisecond))
+	assertValidationFires(t, clk, 15*time.Millisecond, runner, "validation should fire after the second interval change")
+
+	// setting the same options again keeps the routine running
+	same := options(15 * time.Millisecond)
+	cache.SetValidationOptions(same)
+	cache.SetValidationOptions(same)
+	assertValidationFires(t, clk, 15*time.Millisecond, runner, "validation should fire after setting the same options")
+
+	// no validation stops the routine, a strategy starts it again
+	cache.SetValidationOptions(&caching.ValidationOptions{Strategy: caching.NO_VALIDATION})
+	runs := runner.runs.Load()
+	clk.Advance(time.Hour)
+	assert.Equal(t, runs, runner.runs.Load(), "validation should not fire without a strategy")
+	cache.SetValidationOptions(options(10 * time.Millisecond))
+	assertValidationFires(t, clk, 10*time.Millisecond, runner, "validation should fire once a strategy is set again")
+}
+
+func TestFileCache_ValidationConcurrentSetStartStop(t *testing.T) {
+	runner := &countingRunner{}
+	clk := storagetest.NewFakeClock(time.Now())
+	cache := newCache(caching.CacheOptions{ValidationOptions: &caching.ValidationOptions{Runner: runner, ValidationInterval: 5 * time.Millisecond}, Clock: clk})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				switch (i + j) % 3 {
+				case 0:
+					_ = cache.StartValidationRoutine()
+				case 1:
+					cache.StopValidationRoutine()
+				default:
+					cache.SetValidationOptions(&caching.ValidationOptions{Runner: runner, ValidationInterval: time.Duration(1+j%4) * time.Millisecond})
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.NoError(t, cache.StartValidationRoutine())
+	defer cache.StopValidationRoutine()
+	assertValidationFires(t, clk, 5*time.Millisecond, runner, "validation should fire after concurrent starts, stops and sets")
+}
+
+// lastStrategy allocates the strategies registered by the tests, as the registry is global.
+var lastStrategy atomic.Int64
+
+func newStrategy() caching.Strategy {
+	return caching.Strategy(1000 + lastStrategy.Add(1))
+}
+
+func TestRegisterValidationStrategy(t *testing.T) {
+	counting := newStrategy()
+
+	runner := &countingRunner{}
+	var built atomic.Int64
+	require.NoError(t, caching.RegisterValidationStrategy(counting, func(v *caching.ValidationOptions) (caching.ValidationRunner, error) {
+		built.Add(1)
+		return runner, nil
+	}))
+
+	assert.Error(t, caching.RegisterValidationStrategy(counting, func(*caching.ValidationOptions) (caching.ValidationRunner, error) { return nil, nil }), "a strategy should not be registered twice")
+	assert.Error(t, caching.RegisterValidationStrategy(caching.SAMPLING_VALIDATION, func(*caching.ValidationOptions) (caching.ValidationRunner, error) { return nil, nil }), "a built-in strategy should not be replaced")
+	assert.Error(t, caching.RegisterValidationStrategy(caching.NO_VALIDATION, func(*caching.ValidationOptions) (caching.ValidationRunner, error) { return nil, nil }))
+	assert.Error(t, caching.RegisterValidationStrategy(newStrategy(), nil))
+
+	clk := storagetest.NewFakeClock(time.Now())
+	cache := newCache(caching.CacheOptions{ValidationOptions: &caching.ValidationOptions{Strategy: counting, ValidationInterval: 5 * time.Millisecond}, Clock: clk})
+	require.NoError(t, cache.StartValidationRoutine())
+	defer cache.StopValidationRoutine()
+	assertValidationFires(t, clk, 5*time.Millisecond, runner, "the routine should invoke the registered runner")
+	assert.Positive(t, built.Load())
+
+	_, err := caching.ValidationStrategyFactory(&caching.ValidationOptions{Strategy: newStrategy()})
+	assert.Error(t, err, "unregistered strategies should not be built")
+
+	// registrations are safe while other strategies are built
+	registered := make([]caching.Strategy, 8)
+	for i := range registered {
+		registered[i] = newStrategy()
+	}
+	var wg sync.WaitGroup
+	for i := range registered {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, caching.RegisterValidationStrategy(registered[i], func(*caching.ValidationOptions) (caching.ValidationRunner, error) { return runner, nil }))
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, err := caching.ValidationStrategyFactory(&caching.ValidationOptions{Strategy: counting})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	for _, strategy := range registered {
+		got, err := caching.ValidationStrategyFactory(&caching.ValidationOptions{Strategy: strategy})
+		assert.NoError(t, err)
+		assert.Same(t, runner, got)
+	}
+}
+
+// staleRunner invalidates the cached items whose content differs from the authoritative one.
+type staleRunner struct {
+	authoritative map[string]string
+	runs          atomic.Int64
+}
+
+func (r *staleRunner) Apply(cache *m2cs.FileCache) error {
+	for _, entry := range cache.Entries() {
+		if want, ok := r.authoritative[entry.Key]; ok && int64(len(want)) != entry.SizeBytes {
+			cache.Invalidate(entry.Key)
+		}
+	}
+	r.runs.Add(1)
+	return nil
+}
+
+func TestFileClient_CustomValidationStrategy(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	require.NoError(t, storage.MakeBucket(ctx, "box"))
+	require.NoError(t, storage.PutObject(ctx, "box", "key", strings.NewReader("old")))
+
+	runner := &staleRunner{authoritative: map[string]string{"box/key": "newer"}}
+	clk := storagetest.NewFakeClock(time.Now())
+	client := newClockedClient(t, clk, storage)
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{
+		Enabled:            true,
+		ValidationStrategy: m2cs.CustomValidationStrategy(runner, 5*time.Millisecond),
+	}))
+	defer client.DisableCache()
+	assert.Equal(t, "CUSTOM_VALIDATION(every 5ms)", client.Describe().Cache.ValidationStrategy)
+
+	obj, err := client.GetObject(ctx, "box", "key")
+	require.NoError(t, err)
+	obj.Close()
+
+	clk.BlockUntil(1)
+	clk.Advance(5 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, cached := client.CacheContains("box", "key")
+		return !cached && runner.runs.Load() > 0
+	}, time.Second, 5*time.Millisecond, "the custom runner should invalidate the stale item")
+}
+
+// slowStorage delays the reads of a storage and counts them.
+type slowStorage struct {
+	filestorage.FileStorage
+	delay time.Duration
+	reads atomic.Int64
+}
+
+func (s *slowStorage) GetObject(ctx context.Context, storeBox, fileName string) (io.ReadCloser, error) {
+	s.reads.Add(1)
+	time.Sleep(s.delay)
+	return s.FileStorage.GetObject(ctx, storeBox, fileName)
+}
+
+func TestFileClient_StaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+
+	memory := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	require.NoError(t, memory.MakeBucket(ctx, "box"))
+	require.NoError(t, memory.PutObject(ctx, "box", "key", strings.NewReader("old")))
+	storage := &slowStorage{FileStorage: memory, delay: 200 * time.Millisecond}
+
+	const ttl = 100 * time.Millisecond
+	clk := storagetest.NewFakeClock(time.Now())
+	client := newClockedClient(t, clk, storage)
+	assert.Error(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, StaleWhileRevalidate: time.Second, MaxStale: time.Millisecond}))
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, TTL: ttl, StaleWhileRevalidate: 5 * time.Second}))
+	defer client.DisableCache()
+
+	read := func() string {
+		obj, err := client.GetObject(ctx, "box", "key")
+		require.NoError(t, err)
+		data, err := io.ReadAll(obj)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	assert.Equal(t, "old", read())
+	require.NoError(t, memory.PutObject(ctx, "box", "key", strings.NewReader("new")))
+	clk.Advance(ttl + time.Millisecond)
+
+	// the first reads after the expiry are served the stale copy at once, and trigger a single refresh
+	reads := storage.reads.Load()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "old", read())
+		}()
+	}
+	wg.Wait()
+	assert.Less(t, time.Since(start), storage.delay, "stale reads should not wait for the backend")
+
+	assert.Eventually(t, func() bool {
+		info, ok := client.CacheContains("box", "key")
+		return ok && info.SizeBytes == 3 && info.StoredAt.Equal(clk.Now()) && read() == "new"
+	}, 2*time.Second, 10*time.Millisecond, "the cache should hold the new data")
+	assert.Equal(t, reads+1, storage.reads.Load(), "concurrent stale reads should be coalesced into one refresh")
+}
+
+func TestFileClient_StaleWhileRevalidate_MaxStale(t *testing.T) {
+	ctx := context.Background()
+
+	memory := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	require.NoError(t, memory.MakeBucket(ctx, "box"))
+	require.NoError(t, memory.PutObject(ctx, "box", "key", strings.NewReader("stale")))
+	storage := &slowStorage{FileStorage: memory}
+
+	const ttl = 100 * time.Millisecond
+	clk := storagetest.NewFakeClock(time.Now())
+	client := newClockedClient(t, clk, storage)
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, TTL: ttl, StaleWhileRevalidate: 200 * time.Millisecond, MaxStale: 600 * time.Millisecond}))
+	defer client.DisableCache()
+
+	obj, err := client.GetObject(ctx, "box", "key")
+	require.NoError(t, err)
+	obj.Close()
+
+	// the backend loses the object: the refreshes fail
+	require.NoError(t, memory.RemoveObject(ctx, "box", "key"))
+	read := func() (string, error) {
+		obj, err := client.GetObject(ctx, "box", "key")
+		if err != nil {
+			return "", err
+		}
+		data, err := io.ReadAll(obj)
+		return string(data), err
+	}
+
+	clk.Advance(ttl + 20*time.Millisecond)
+	data, err := read()
+	require.NoError(t, err)
+	assert.Equal(t, "stale", data, "an expired copy should be served within the grace window")
+
+	// past the grace window, the stale copy is still served once the refresh has failed
+	clk.Advance(280 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		data, err := read()
+		return err == nil && data == "stale"
+	}, time.Second, time.Millisecond, "an expired copy should be served within MaxStale while the refresh fails")
+
+	// past MaxStale, the copy is no longer served
+	clk.Advance(350 * time.Millisecond)
+	_, err = read()
+	assert.Error(t, err)
+}
+
+func TestFileCache_CompressEntries(t *testing.T) {
+	text := bytes.Repeat([]byte(`{"id": 42, "name": "compressible", "tags": ["a", "b", "c"]}`+"\n"), (10<<20)/60)
+
+	cache := newCache(caching.CacheOptions{MaxSizeMB: 64, CompressEntries: true})
+	cache.Store("box/large.json", text)
+	cache.Store("box/small.json", []byte(`{"id": 1}`))
+	random := make([]byte, 256<<10)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	cache.Store("box/random.bin", random)
+
+	logical := int64(len(text) + len(`{"id": 1}`) + len(random))
+	assert.Equal(t, logical, cache.LogicalSizeBytes())
+	assert.Less(t, cache.CurrentSizeBytes(), int64(len(random))+int64(len(text))/10, "the compressible object should be stored compressed")
+
+	data, ok := cached(t, cache, "box/large.json")
+	assert.True(t, ok)
+	assert.True(t, data == string(text), "the compressed object should round-trip")
+	data, ok = cached(t, cache, "box/random.bin")
+	assert.True(t, ok)
+	assert.True(t, data == string(random))
+
+	for _, entry := range cache.Entries() {
+		switch entry.Key {
+		case "box/large.json":
+			assert.Equal(t, int64(len(text)), entry.SizeBytes)
+			assert.Less(t, entry.StoredBytes, entry.SizeBytes/10)
+		case "box/small.json":
+			assert.Equal(t, entry.SizeBytes, entry.StoredBytes, "items below the threshold should not be compressed")
+		case "box/random.bin":
+			assert.Equal(t, entry.SizeBytes, entry.StoredBytes, "incompressible items should be stored as is")
+		}
+	}
+
+	// compressed entries survive a snapshot
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	require.NoError(t, cache.SaveSnapshot(path))
+	restored := newCache(caching.CacheOptions{MaxSizeMB: 64})
+	require.NoError(t, restored.LoadSnapshot(path))
+	data, ok = cached(t, restored, "box/large.json")
+	assert.True(t, ok)
+	assert.True(t, data == string(text))
+	assert.Equal(t, cache.CurrentSizeBytes(), restored.CurrentSizeBytes())
+
+	// the item size limit applies to the logical size
+	limited := newCache(caching.CacheOptions{MaxSizeMB: 64, MaxItemSizeMB: 1, CompressEntries: true})
+	limited.Store("box/large.json", text)
+	_, ok = cached(t, limited, "box/large.json")
+	assert.False(t, ok)
+}
+
+func TestFileClient_CompressEntries(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	require.NoError(t, storage.MakeBucket(ctx, "box"))
+	text := strings.Repeat("the quick brown fox jumps over the lazy dog\n", (10<<20)/44)
+	require.NoError(t, storage.PutObject(ctx, "box", "text.txt", strings.NewReader(text)))
+
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+	assert.Error(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, CompressMinBytes: -1}))
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, CompressEntries: true}))
+	defer client.DisableCache()
+
+	for i := 0; i < 2; i++ {
+		obj, err := client.GetObject(ctx, "box", "text.txt")
+		require.NoError(t, err)
+		data, err := io.ReadAll(obj)
+		require.NoError(t, err)
+		assert.True(t, string(data) == text, "read %d should return the object", i)
+	}
+
+	description := client.Describe().Cache
+	assert.True(t, description.CompressEntries)
+	assert.Equal(t, int64(len(text)), description.LogicalSizeBytes)
+	assert.Less(t, description.CurrentSizeBytes, int64(1<<20), "the cached object should be much smaller than 10 MB")
+}
+
+// spyBackend is a CacheBackend counting its calls by operation and store box.
+type spyBackend struct {
+	mu    sync.Mutex
+	items map[string][]byte
+	calls map[string]int
+	ttls  map[string]time.Duration
+}
+
+func newSpyBackend() *spyBackend {
+	return &spyBackend{items: map[string][]byte{}, calls: map[string]int{}, ttls: map[string]time.Duration{}}
+}
+
+func (s *spyBackend) record(op, key string) {
+	box, _, _ := strings.Cut(key, "/")
+	s.calls[op+" "+box]++
+}
+
+func (s *spyBackend) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("get", key)
+	data, ok := s.items[key]
+	return data, ok, nil
+}
+
+func (s *spyBackend) Set(_ context.Context, key string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("set", key)
+	s.items[key] = data
+	s.ttls[key] = ttl
+	return nil
+}
+
+func (s *spyBackend) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("delete", key)
+	delete(s.items, key)
+	return nil
+}
+
+func (s *spyBackend) Clear(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = map[string][]byte{}
+	return nil
+}
+
+func (s *spyBackend) count(op, box string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[op+" "+box]
+}
+
+func TestFileClient_CacheRules(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+	for _, box := range []string{"assets", "live-data"} {
+		require.NoError(t, storage.MakeBucket(ctx, box))
+		require.NoError(t, storage.PutObject(ctx, box, "key", strings.NewReader(box)))
+	}
+
+	backend := newSpyBackend()
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{
+		Enabled: true,
+		TTL:     time.Minute,
+		Backend: backend,
+		Rules: []m2cs.CacheRule{
+			{BoxPattern: "asset*", Enabled: true, TTL: 24 * time.Hour},
+			{BoxPattern: "*", Enabled: false},
+		},
+	}))
+
+	for i := 0; i < 3; i++ {
+		for _, box := range []string{"assets", "live-data"} {
+			obj, err := client.GetObject(ctx, box, "key")
+			require.NoError(t, err)
+			data, err := io.ReadAll(obj)
+			require.NoError(t, err)
+			assert.Equal(t, box, string(data))
+		}
+	}
+	require.NoError(t, client.PutObject(ctx, "assets", "key", strings.NewReader("v2")))
+	require.NoError(t, client.PutObject(ctx, "live-data", "key", strings.NewReader("v2")))
+
+	assert.Equal(t, 3, backend.count("get", "assets"))
+	assert.Equal(t, 1, backend.count("set", "assets"), "the object should be cached once, then served from the cache")
+	assert.Equal(t, 1, backend.count("delete", "assets"))
+	assert.Equal(t, 24*time.Hour, backend.ttls["assets/key"])
+	for _, op := range []string{"get", "set", "delete"} {
+		assert.Zero(t, backend.count(op, "live-data"), "live-data should bypass the cache on %s", op)
+	}
+
+	report, err := client.Prefetch(ctx, "live-data", []string{"key"}, m2cs.PrefetchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, m2cs.PrefetchBypassed, report.Results[0].Outcome)
+	assert.Zero(t, backend.count("set", "live-data"))
+}
+
+func TestFileClient_CacheRules_InMemory(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+	for _, box := range []string{"short", "long", "small"} {
+		require.NoError(t, storage.MakeBucket(ctx, box))
+		require.NoError(t, storage.PutObject(ctx, box, "key", strings.NewReader("data")))
+	}
+
+	clk := storagetest.NewFakeClock(time.Now())
+	client := newClockedClient(t, clk, storage)
+	defer client.DisableCache()
+
+	invalid := [][]m2cs.CacheRule{
+		{{BoxPattern: "[", Enabled: true}},
+		{{BoxPattern: "", Enabled: true}},
+		{{BoxPattern: "short", TTL: -time.Second}},
+		{{BoxPattern: "*", Enabled: true}, {BoxPattern: "long", Enabled: true}},
+	}
+	for _, rules := range invalid {
+		assert.Error(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, Rules: rules}), "rules %v should be rejected", rules)
+	}
+
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{
+		Enabled: true,
+		TTL:     time.Minute,
+		Rules: []m2cs.CacheRule{
+			{BoxPattern: "short", Enabled: true, TTL: 200 * time.Millisecond},
+			{BoxPattern: "small", Enabled: true, MaxItemSizeMB: 1},
+		},
+	}))
+	require.NoError(t, storage.PutObject(ctx, "small", "large", bytes.NewReader(make([]byte, 2<<20))))
+
+	obj, err := client.GetObject(ctx, "small", "large")
+	require.NoError(t, err)
+	require.NoError(t, obj.Close())
+	for _, box := range []string{"long", "small", "short"} {
+		obj, err := client.GetObject(ctx, box, "key")
+		require.NoError(t, err)
+		require.NoError(t, obj.Close())
+	}
+
+	contains := func(box, key string) bool {
+		_, ok := client.CacheContains(box, key)
+		return ok
+	}
+	assert.True(t, contains("small", "key"))
+	assert.False(t, contains("small", "large"), "objects larger than the rule limit should not be cached")
+
+	entry, ok := client.CacheContains("short", "key")
+	require.True(t, ok)
+	assert.Equal(t, 200*time.Millisecond, entry.ExpiresAt.Sub(entry.StoredAt))
+	clk.Advance(200*time.Millisecond + time.Millisecond)
+	assert.False(t, contains("short", "key"), "the object should expire with the TTL of the rule")
+	assert.True(t, contains("long", "key"), "the object should expire with the cache TTL")
+}
+
+// probeCountingStorage counts the existence checks of a storage.
+type probeCountingStorage struct {
+	filestorage.FileStorage
+	probes atomic.Int64
+}
+
+func (s *probeCountingStorage) ExistObject(ctx context.Context, storeBox, fileName string) (bool, error) {
+	s.probes.Add(1)
+	return s.FileStorage.ExistObject(ctx, storeBox, fileName)
+}
+
+func TestFileClient_ExistsObjectCached(t *testing.T) {
+	ctx := context.Background()
+
+	memory := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	require.NoError(t, memory.MakeBucket(ctx, "box"))
+	require.NoError(t, memory.PutObject(ctx, "box", "read.txt", strings.NewReader("data")))
+	storage := &probeCountingStorage{FileStorage: memory}
+
+	clk := storagetest.NewFakeClock(time.Now())
+	client := newClockedClient(t, clk, storage)
+	assert.Error(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, ExistenceTTL: -time.Second}))
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, ExistenceTTL: 200 * time.Millisecond}))
+	defer client.DisableCache()
+
+	exists := func(key string) bool {
+		t.Helper()
+		exists, err := client.ExistsObject(ctx, "box", key)
+		require.NoError(t, err)
+		return exists
+	}
+
+	require.NoError(t, client.PutObject(ctx, "box", "key.txt", strings.NewReader("data")))
+	assert.True(t, exists("key.txt"))
+	require.NoError(t, client.RemoveObject(ctx, "box", "key.txt"))
+	assert.False(t, exists("key.txt"))
+	assert.Zero(t, storage.probes.Load(), "puts and removals should answer the existence checks")
+
+	obj, err := client.GetObject(ctx, "box", "read.txt")
+	require.NoError(t, err)
+	require.NoError(t, obj.Close())
+	assert.True(t, exists("read.txt"))
+	assert.Zero(t, storage.probes.Load(), "cached objects should exist without checking the storages")
+
+	for i := 0; i < 5; i++ {
+		assert.False(t, exists("missing.txt"))
+	}
+	assert.Equal(t, int64(1), storage.probes.Load(), "a burst of checks should call the storages once")
+
+	clk.Advance(300 * time.Millisecond)
+	assert.False(t, exists("missing.txt"))
+	assert.Equal(t, int64(2), storage.probes.Load(), "expired markers should be checked again")
+
+	require.NoError(t, client.DisableCache())
+	assert.False(t, exists("key.txt"))
+	assert.Equal(t, int64(3), storage.probes.Load(), "a disabled cache should not answer the checks")
+}
+
+// TestFileClient_BytesReadCloser verifies that the objects read from memory report their length
+// and content, on cache misses and hits and from GetObjectWithInfo.
+func TestFileClient_BytesReadCloser(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	require.NoError(t, storage.MakeBucket(ctx, "box"))
+	require.NoError(t, storage.PutObject(ctx, "box", "key", strings.NewReader("content")))
+
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true}))
+	defer client.DisableCache()
+
+	for _, read := range []string{"miss", "hit"} {
+		obj, err := client.GetObject(ctx, "box", "key")
+		require.NoError(t, err, read)
+		b, ok := obj.(*m2cs.BytesReadCloser)
+		require.True(t, ok, "a %s returns a BytesReadCloser", read)
+		assert.Equal(t, len("content"), b.Len(), read)
+		assert.Equal(t, int64(len("content")), b.Size(), read)
+		assert.Equal(t, "content", string(b.Bytes()), read)
+
+		head := make([]byte, 3)
+		_, err = io.ReadFull(b, head)
+		require.NoError(t, err)
+		assert.Equal(t, len("tent"), b.Len(), "Len reports the bytes not yet read")
+		assert.Equal(t, "content", string(b.Bytes()), "Bytes returns the whole object")
+		require.NoError(t, obj.Close())
+	}
+
+	obj, stat, err := client.GetObjectWithInfo(ctx, "box", "key")
+	require.NoError(t, err)
+	defer obj.Close()
+	b, ok := obj.(*m2cs.BytesReadCloser)
+	require.True(t, ok)
+	assert.Equal(t, "content", string(b.Bytes()))
+	assert.Equal(t, int64(b.Len()), stat.Size)
+}
+
+// TestFileClient_CopyOnRead verifies that the callers modifying BytesReadCloser.Bytes do not
+// corrupt the cache with CopyOnRead, on cache misses and hits.
+func TestFileClient_CopyOnRead(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	require.NoError(t, storage.MakeBucket(ctx, "box"))
+	require.NoError(t, storage.PutObject(ctx, "box", "key", strings.NewReader("content")))
+
+	read := func(client *m2cs.FileClient) *m2cs.BytesReadCloser {
+		obj, err := client.GetObject(ctx, "box", "key")
+		require.NoError(t, err)
+		b, ok := obj.(*m2cs.BytesReadCloser)
+		require.True(t, ok)
+		return b
+	}
+
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, CopyOnRead: true}))
+
+	copy(read(client).Bytes(), "MISSED!")
+	_, cached := client.CacheContains("box", "key")
+	require.True(t, cached)
+	copy(read(client).Bytes(), "HITHIT!")
+	assert.Equal(t, "content", string(read(client).Bytes()), "the cache holds its own copy")
+	require.NoError(t, client.DisableCache())
+
+	// without CopyOnRead the slice is shared with the cache, which is why it must not be modified
+	client = m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true}))
+	defer client.DisableCache()
+	first, second := read(client), read(client)
+	assert.Same(t, &first.Bytes()[0], &second.Bytes()[0])
+}
+
+// TestFileCache_CopyOnRead verifies that every hit of a cache with CopyOnRead gets its own copy,
+// compressed entries included.
+func TestFileCache_CopyOnRead(t *testing.T) {
+	objects := map[string][]byte{
+		"box/plain":      []byte("plain"),
+		"box/compressed": bytes.Repeat([]byte("copy on read "), 1000),
+	}
+	cache := newCache(caching.CacheOptions{CopyOnRead: true, CompressEntries: true, CompressMinBytes: 1 << 10})
+	for key, data := range objects {
+		cache.Store(key, bytes.Clone(data))
+	}
+
+	for key, data := range objects {
+		rc, ok := cache.GetFile(key).(*caching.ReadCloser)
+		require.True(t, ok, key)
+		copy(rc.Bytes(), "MODIFIED")
+		content, ok := cached(t, cache, key)
+		require.True(t, ok, key)
+		assert.Equal(t, string(data), content, key)
+	}
+}
+
+// validationStats collects the stats passed to OnValidation.
+type validationStats struct {
+	mu    sync.Mutex
+	stats []caching.ValidationStats
+}
+
+func (v *validationStats) record(stats caching.ValidationStats) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.stats = append(v.stats, stats)
+}
+
+func (v *validationStats) get() []caching.ValidationStats {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]caching.ValidationStats(nil), v.stats...)
+}
+
+func TestFileCache_ValidationStats(t *testing.T) {
+	var collected validationStats
+	const interval = 5 * time.Millisecond
+	clk := storagetest.NewFakeClock(time.Now())
+	cache := newCache(caching.CacheOptions{
+		TTL:               time.Millisecond,
+		ValidationOptions: &caching.ValidationOptions{Strategy: caching.SAMPLING_VALIDATION, SamplingPercent: 100, ValidationInterval: interval},
+		OnValidation:      collected.record,
+		Clock:             clk,
+	})
+	for i := 0; i < 4; i++ {
+		cache.Store(fmt.Sprintf("box/key-%d", i), []byte("data"))
+	}
+
+	start := clk.Now()
+	require.NoError(t, cache.StartValidationRoutine())
+	for runs := 1; runs <= 2; runs++ {
+		clk.BlockUntil(1)
+		clk.Advance(interval)
+		require.Eventually(t, func() bool { return len(collected.get()) >= runs }, time.Second, time.Millisecond)
+	}
+	cache.StopValidationRoutine()
+
+	// the first tick expires every entry, the next ones find the cache empty
+	stats := collected.get()
+	first := stats[0]
+	assert.Equal(t, "SAMPLING_VALIDATION", first.Strategy)
+	assert.Equal(t, 4, first.Scanned)
+	assert.Equal(t, 4, first.SampleSize)
+	assert.Equal(t, 4, first.Expired)
+	assert.NoError(t, first.Err)
+	assert.Equal(t, start.Add(2*interval), first.NextTick)
+	assert.Zero(t, stats[1].Scanned)
+
+	totals := cache.Stats()
+	assert.Equal(t, int64(len(collected.get())), totals.ValidationRuns)
+	assert.Zero(t, totals.ValidationErrors)
+	assert.Equal(t, int64(4), totals.EntriesScanned)
+	assert.Equal(t, int64(4), totals.EntriesExpired)
+	assert.Equal(t, "SAMPLING_VALIDATION", totals.LastValidation.Strategy)
+}
+
+func TestSamplingValidation_SampleSize(t *testing.T) {
+	cache := newCache(caching.CacheOptions{})
+	for i := 0; i < 4; i++ {
+		cache.Store(fmt.Sprintf("box/key-%d", i), []byte("data"))
+	}
+
+	stats, err := (&caching.SamplingValidation{SampleRate: 50}).ApplyWithStats(cache)
+	require.NoError(t, err)
+	assert.Equal(t, caching.ValidationStats{Scanned: 4, SampleSize: 2}, stats)
+}
+
+// failingRunner is a caching.ValidationRunner failing every run.
+type failingRunner struct{}
+
+func (failingRunner) Apply(*caching.FileCache) error {
+	return fmt.Errorf("authoritative hashes unavailable")
+}
+
+func TestFileClient_CacheStats(t *testing.T) {
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	clk := storagetest.NewFakeClock(time.Now())
+	client := newClockedClient(t, clk, storage)
+	assert.Zero(t, client.CacheStats())
+
+	var collected validationStats
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{
+		Enabled:            true,
+		ValidationStrategy: m2cs.CustomValidationStrategy(failingRunner{}, 5*time.Millisecond),
+		OnValidation:       collected.record,
+	}))
+	defer client.DisableCache()
+
+	clk.BlockUntil(1)
+	clk.Advance(5 * time.Millisecond)
+	require.Eventually(t, func() bool { return client.CacheStats().ValidationErrors > 0 }, time.Second, time.Millisecond)
+	stats := client.CacheStats()
+	assert.Equal(t, "CUSTOM_VALIDATION", stats.LastValidation.Strategy)
+	assert.EqualError(t, stats.LastValidation.Err, "authoritative hashes unavailable")
+	assert.Zero(t, stats.EntriesScanned, "runners without stats report no entries")
+	require.Eventually(t, func() bool { return len(collected.get()) > 0 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,102 @@
+package codec_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+type document struct {
+	Name    string
+	Tags    []string
+	Nested  map[string]map[string]int
+	Enabled bool
+}
+
+func newClient(t *testing.T) (*m2cs.FileClient, *filestorage.MemoryClient) {
+	memory := storagetest.NewMemory(t, "memory", "box")
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{memory})
+	require.NoError(t, err)
+	return client, memory
+}
+
+func sample() document {
+	return document{
+		Name:    "doc",
+		Tags:    []string{"a", "b"},
+		Nested:  map[string]map[string]int{"x": {"one": 1, "two": 2}, "y": {}},
+		Enabled: true,
+	}
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	codecs := map[string]struct {
+		put         func(*m2cs.FileClient, any) error
+		get         func(*m2cs.FileClient, any) error
+		contentType string
+	}{
+		"json": {
+			put:         func(c *m2cs.FileClient, v any) error { return c.PutJSON(context.Background(), "box", "key", v) },
+			get:         func(c *m2cs.FileClient, out any) error { return c.GetJSON(context.Background(), "box", "key", out) },
+			contentType: "application/json",
+		},
+		"gob": {
+			put:         func(c *m2cs.FileClient, v any) error { return c.PutGob(context.Background(), "box", "key", v) },
+			get:         func(c *m2cs.FileClient, out any) error { return c.GetGob(context.Background(), "box", "key", out) },
+			contentType: "application/x-gob",
+		},
+	}
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			client, memory := newClient(t)
+			require.NoError(t, codec.put(client, sample()))
+
+			var got document
+			require.NoError(t, codec.get(client, &got))
+			assert.Equal(t, sample(), got)
+
+			stat, err := memory.StatObject(context.Background(), "box", "key")
+			require.NoError(t, err)
+			assert.Equal(t, codec.contentType, stat.ContentType)
+
+			var wrong []int
+			assert.ErrorContains(t, codec.get(client, &wrong), "failed to decode box/key")
+		})
+	}
+}
+
+func TestCodec_Cache(t *testing.T) {
+	client, _ := newClient(t)
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, MaxSizeMB: 1, MaxItems: 10}))
+	require.NoError(t, client.PutJSON(context.Background(), "box", "key", sample()))
+
+	var first document
+	require.NoError(t, client.GetJSON(context.Background(), "box", "key", &first))
+	first.Nested["x"]["one"] = 100
+
+	// the cache holds the encoded bytes, not the decoded value
+	var second document
+	require.NoError(t, client.GetJSON(context.Background(), "box", "key", &second))
+	assert.Equal(t, sample(), second)
+}
+
+func TestCodec_EncodeFailure(t *testing.T) {
+	client, memory := newClient(t)
+
+	err := client.PutJSON(context.Background(), "box", "key", map[string]any{"channel": make(chan int)})
+	assert.ErrorContains(t, err, "failed to put the encoded object")
+
+	exists, err := memory.ExistObject(context.Background(), "box", "key")
+	require.NoError(t, err)
+	assert.False(t, exists, "a value failing to encode is not written")
+
+	var got document
+	assert.ErrorIs(t, client.GetJSON(context.Background(), "box", "key", &got), filestorage.ErrObjectNotFound)
+}
@@ -170,3 +170,50 @@ func TestCreateAzBlobConnection_WithConnString_Success(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, conn)
 }
+
+// TestCreateAzBlobConnection_RedactsSecrets tests that the errors returned by CreateAzBlobConnection
+// never contain the account key, the SAS signature or the full connection string.
+func TestCreateAzBlobConnection_RedactsSecrets(t *testing.T) {
+	const secretKey = "c2VjcmV0LWtleS11c2VkLWluLXJlZGFjdGlvbi10ZXN0cw=="
+	const signature = "c2VjcmV0LXNpZ25hdHVyZQ%3D%3D"
+
+	t.Run("InvalidCredentials", func(t *testing.T) {
+		config := &connection.AuthConfig{}
+		config.SetConnectType("withCredential")
+		config.SetAccessKey(azurite.AccountName)
+		config.SetSecretKey(secretKey)
+
+		conn, err := connfilestorage.CreateAzBlobConnection(blobServiceURL, config)
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "failed to connect to azure blob: ")
+		assert.NotContains(t, err.Error(), secretKey)
+		require.Nil(t, conn)
+	})
+
+	t.Run("InvalidEndpoint", func(t *testing.T) {
+		config := &connection.AuthConfig{}
+		config.SetConnectType("withConnectionString")
+		config.SetConnectionString("BlobEndpoint=http://127.0.0.1:1/devstoreaccount1;" +
+			"SharedAccessSignature=sv=2020-08-04&ss=b&srt=sco&sp=rl&sig=" + signature)
+
+		conn, err := connfilestorage.CreateAzBlobConnection(blobServiceURL, config)
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "failed to connect to azure blob: ")
+		assert.NotContains(t, err.Error(), signature)
+		require.Nil(t, conn)
+	})
+
+	t.Run("InvalidConnectionString", func(t *testing.T) {
+		connString := "AccountName=" + azurite.AccountName + ";AccountKey=" + secretKey
+
+		config := &connection.AuthConfig{}
+		config.SetConnectType("withConnectionString")
+		config.SetConnectionString(connString)
+
+		conn, err := connfilestorage.CreateAzBlobConnection(blobServiceURL, config)
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), secretKey)
+		assert.NotContains(t, err.Error(), connString)
+		require.Nil(t, conn)
+	})
+}
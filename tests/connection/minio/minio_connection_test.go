@@ -251,3 +251,32 @@ func TestCreateMinioConnection_WithCredential_Success(t *testing.T) {
 		t.Fatal("the connection is nil, a valid object was expected")
 	}
 }
+
+// TestCreateMinioConnection_RedactsSecrets verifies that the errors returned by
+// CreateMinioConnection never contain the secret key.
+func TestCreateMinioConnection_RedactsSecrets(t *testing.T) {
+	const secretKey = "minio-secret-key-for-redaction"
+
+	for name, endpoint := range map[string]string{
+		"InvalidCredential": httpEndpoint,
+		"InvalidEndpoint":   "invalidHost",
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := &connection.AuthConfig{}
+			config.SetConnectType("withCredential")
+			config.SetAccessKey("wrongUser")
+			config.SetSecretKey(secretKey)
+
+			conn, err := connfilestorage.CreateMinioConnection(endpoint, config, nil)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if strings.Contains(err.Error(), secretKey) {
+				t.Fatalf("error message exposes the secret key: %s", err.Error())
+			}
+			if conn != nil {
+				t.Fatal("the connection was initialized but it should not have been")
+			}
+		})
+	}
+}
@@ -159,3 +159,29 @@ func TestCreateS3Connection_WithEnv_Success(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, conn)
 }
+
+// TestCreateS3Connection_RedactsSecrets tests that the errors returned by CreateS3Connection
+// never contain the secret key.
+func TestCreateS3Connection_RedactsSecrets(t *testing.T) {
+	const secretKey = "s3-secret-key-for-redaction"
+
+	for name, endpoint := range map[string]string{
+		"InvalidCredentials": s3ServiceUrl,
+		"InvalidEndpoint":    "invalidHost",
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := &connection.AuthConfig{}
+			config.SetConnectType("withCredential")
+			config.SetAccessKey("wrongUser")
+			config.SetSecretKey(secretKey)
+
+			conn, err := connfilestorage.CreateS3Connection(endpoint, config, "")
+			if err == nil {
+				// LocalStack accepts any credentials, so there is no error to inspect.
+				return
+			}
+			assert.NotContains(t, err.Error(), secretKey)
+			require.Nil(t, conn)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package consistency_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+// countingStorage is a MemoryClient counting the reads of objects.
+type countingStorage struct {
+	*filestorage.MemoryClient
+	reads atomic.Int64
+}
+
+func (s *countingStorage) GetObject(ctx context.Context, storeBox, fileName string) (io.ReadCloser, error) {
+	s.reads.Add(1)
+	return s.MemoryClient.GetObject(ctx, storeBox, fileName)
+}
+
+func (s *countingStorage) GetObjectWithInfo(ctx context.Context, storeBox, fileName string) (io.ReadCloser, m2cs.ObjectStat, error) {
+	s.reads.Add(1)
+	return s.MemoryClient.GetObjectWithInfo(ctx, storeBox, fileName)
+}
+
+func newStorage(t *testing.T, label string, main bool) *countingStorage {
+	memory := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: label, IsMainInstance: main}, "box")
+	return &countingStorage{MemoryClient: memory}
+}
+
+func write(t *testing.T, content string, storages ...*countingStorage) {
+	for _, s := range storages {
+		require.NoError(t, s.PutObject(context.Background(), "box", "key", strings.NewReader(content)))
+	}
+}
+
+func read(t *testing.T, client *m2cs.FileClient, consistency m2cs.ReadConsistency) string {
+	obj, err := client.GetObjectWithOptions(context.Background(), "box", "key", m2cs.GetOptions{ReadConsistency: consistency})
+	require.NoError(t, err)
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestReadConsistency_StaleReplica(t *testing.T) {
+	main := newStorage(t, "main", true)
+	replica := newStorage(t, "replica", false)
+	client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{main, replica})
+	require.NoError(t, err)
+
+	write(t, "v1", main, replica)
+	write(t, "v2", main)
+
+	assert.Equal(t, "v1", read(t, client, m2cs.EVENTUAL_CONSISTENCY), "the replica is read first")
+	assert.Equal(t, "v2", read(t, client, m2cs.STRONG_CONSISTENCY))
+
+	main.reads.Store(0)
+	assert.Equal(t, "v2", read(t, client, m2cs.VERIFIED_CONSISTENCY))
+	assert.Equal(t, int64(1), main.reads.Load(), "the stale copy falls back to the main storage")
+
+	// once the replica catches up, verified reads are served by it
+	write(t, "v2", replica)
+	main.reads.Store(0)
+	replica.reads.Store(0)
+	assert.Equal(t, "v2", read(t, client, m2cs.VERIFIED_CONSISTENCY))
+	assert.Zero(t, main.reads.Load())
+	assert.Equal(t, int64(1), replica.reads.Load())
+}
+
+func TestReadConsistency_Cache(t *testing.T) {
+	main := newStorage(t, "main", true)
+	replica := newStorage(t, "replica", false)
+	client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{main, replica})
+	require.NoError(t, err)
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, MaxSizeMB: 1, MaxItems: 10}))
+
+	write(t, "v1", main, replica)
+	assert.Equal(t, "v1", read(t, client, m2cs.EVENTUAL_CONSISTENCY))
+
+	// written by another process: the cache holds v1
+	write(t, "v2", main, replica)
+	assert.Equal(t, "v1", read(t, client, m2cs.EVENTUAL_CONSISTENCY))
+	assert.Equal(t, "v2", read(t, client, m2cs.STRONG_CONSISTENCY))
+}
+
+func TestReadConsistency_Missing(t *testing.T) {
+	main := newStorage(t, "main", true)
+	replica := newStorage(t, "replica", false)
+	client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{main, replica})
+	require.NoError(t, err)
+
+	// an object left on a replica only is not served
+	write(t, "v1", replica)
+	for _, consistency := range []m2cs.ReadConsistency{m2cs.STRONG_CONSISTENCY, m2cs.VERIFIED_CONSISTENCY} {
+		_, err := client.GetObjectWithOptions(context.Background(), "box", "key", m2cs.GetOptions{ReadConsistency: consistency})
+		assert.ErrorIs(t, err, filestorage.ErrObjectNotFound, consistency.String())
+	}
+
+	_, err = client.GetObjectWithOptions(context.Background(), "box", "key", m2cs.GetOptions{ReadConsistency: 42})
+	assert.ErrorContains(t, err, "unknown read consistency ReadConsistency(42)")
+}
@@ -0,0 +1,317 @@
+package encryptkey_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/internal/connection"
+	connfilestorage "github.com/tizianocitro/m2cs/internal/connection/filestorage"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+	"github.com/tizianocitro/m2cs/pkg/transform"
+)
+
+// server is a storage accepting every request, as one holding every store box.
+type server struct {
+	*httptest.Server
+	requests atomic.Int64
+}
+
+func newServer(t *testing.T) *server {
+	s := &server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// constructor creates a connection to a backend served by s.
+type constructor struct {
+	backend string
+	connect func(s *server, opts m2cs.ConnectionOptions) error
+}
+
+var constructors = []constructor{
+	{"MinIO", func(s *server, opts m2cs.ConnectionOptions) error {
+		opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+		_, err := m2cs.NewMinIOConnection(s.URL, opts, nil)
+		return err
+	}},
+	{"Azure Blob", func(s *server, opts m2cs.ConnectionOptions) error {
+		opts.ConnectionMethod = m2cs.ConnectWithConnectionString("DefaultEndpointsProtocol=http;AccountName=m2cs;" +
+			"AccountKey=bTJjcy1hY2NvdW50LWtleQ==;BlobEndpoint=" + s.URL + "/m2cs;")
+		_, err := m2cs.NewAzBlobConnection("", opts)
+		return err
+	}},
+	{"AWS S3", func(s *server, opts m2cs.ConnectionOptions) error {
+		opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+		_, err := m2cs.NewS3Connection(s.URL, opts, "")
+		return err
+	}},
+}
+
+func options(encrypt m2cs.EncryptionAlgorithm, key string) m2cs.ConnectionOptions {
+	return m2cs.ConnectionOptions{SaveEncrypt: encrypt, EncryptKey: key, ProbeBox: "box", Region: "us-east-1", AllowWeakKeys: true}
+}
+
+// captureLog returns the output of the standard logger until the end of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	out := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(out) })
+	return &buf
+}
+
+func TestEncryptKey_Constructors(t *testing.T) {
+	for _, c := range constructors {
+		t.Run(c.backend, func(t *testing.T) {
+			t.Run("missing key", func(t *testing.T) {
+				s := newServer(t)
+				err := c.connect(s, options(m2cs.AES256_ENCRYPTION, ""))
+				assert.EqualError(t, err, "invalid "+c.backend+" connection: EncryptKey, EncryptKeyBytes or DecryptKeys must be set with AES256_ENCRYPTION")
+				assert.Zero(t, s.requests.Load(), "the connection fails before any request")
+			})
+
+			t.Run("encrypted", func(t *testing.T) {
+				logs := captureLog(t)
+				assert.NoError(t, c.connect(newServer(t), options(m2cs.AES256_ENCRYPTION, "m2cs")))
+				assert.Empty(t, logs.String())
+			})
+
+			t.Run("clear", func(t *testing.T) {
+				logs := captureLog(t)
+				assert.NoError(t, c.connect(newServer(t), options(m2cs.NO_ENCRYPTION, "")))
+				assert.Empty(t, logs.String())
+			})
+
+			t.Run("unused key", func(t *testing.T) {
+				logs := captureLog(t)
+				assert.NoError(t, c.connect(newServer(t), options(m2cs.NO_ENCRYPTION, "m2cs")))
+				assert.Contains(t, logs.String(), "warning: "+c.backend+" connection: EncryptKey is set but SaveEncrypt is NO_ENCRYPTION")
+			})
+
+			t.Run("unused key hook", func(t *testing.T) {
+				var warnings []error
+				opts := options(m2cs.NO_ENCRYPTION, "m2cs")
+				opts.OnWarning = func(warning error) error {
+					warnings = append(warnings, warning)
+					return nil
+				}
+				assert.NoError(t, c.connect(newServer(t), opts))
+				require.Len(t, warnings, 1)
+				assert.ErrorIs(t, warnings[0], m2cs.ErrUnusedEncryptKey)
+				assert.ErrorContains(t, warnings[0], c.backend)
+			})
+
+			t.Run("unused key rejected", func(t *testing.T) {
+				s := newServer(t)
+				opts := options(m2cs.NO_ENCRYPTION, "m2cs")
+				opts.OnWarning = func(warning error) error { return warning }
+				err := c.connect(s, opts)
+				assert.ErrorIs(t, err, m2cs.ErrUnusedEncryptKey)
+				assert.Zero(t, s.requests.Load())
+
+				opts.OnWarning = func(warning error) error { t.Fatal("unexpected warning"); return nil }
+				opts.SaveEncrypt = m2cs.AES256_ENCRYPTION
+				assert.NoError(t, c.connect(s, opts), "the hook is not called without a warning")
+			})
+		})
+	}
+}
+
+func TestEncryptKey_Create(t *testing.T) {
+	s := newServer(t)
+	config := m2cs.ConnectWithCredentials("m2csUser", "m2csPassword").WithProperties(common.Properties{
+		SaveEncrypted: common.AES256_ENCRYPTION,
+		ProbeBox:      "box",
+	})
+
+	_, err := connfilestorage.CreateMinioConnection(s.URL, config, nil)
+	assert.EqualError(t, err, "invalid MinIO connection: EncryptKey, EncryptKeyBytes or DecryptKeys must be set with AES256_ENCRYPTION")
+	_, err = connfilestorage.CreateS3Connection(s.URL, config, "us-east-1")
+	assert.EqualError(t, err, "invalid AWS S3 connection: EncryptKey, EncryptKeyBytes or DecryptKeys must be set with AES256_ENCRYPTION")
+	azure := connection.NewAuthConfig()
+	azure.SetConnectType("withConnectionString")
+	azure.SetConnectionString("DefaultEndpointsProtocol=http;AccountName=m2cs;AccountKey=bTJjcy1hY2NvdW50LWtleQ==;BlobEndpoint=" + s.URL + "/m2cs;")
+	_, err = connfilestorage.CreateAzBlobConnection("", azure.WithProperties(config.GetProperties()))
+	assert.EqualError(t, err, "invalid Azure Blob connection: EncryptKey, EncryptKeyBytes or DecryptKeys must be set with AES256_ENCRYPTION")
+	assert.Zero(t, s.requests.Load())
+
+	assert.NoError(t, common.Properties{}.ValidateEncryption())
+	assert.NoError(t, common.Properties{EncryptKey: "m2cs"}.ValidateEncryption(), "an unused key is only a warning of the New*Connection functions")
+}
+
+func TestEncryptKey_Strength(t *testing.T) {
+	const strong = "fTq8-Lx2!vRz9#Kp"
+
+	for _, c := range constructors {
+		t.Run(c.backend, func(t *testing.T) {
+			opts := options(m2cs.AES256_ENCRYPTION, "m2cs")
+			opts.AllowWeakKeys = false
+			err := c.connect(newServer(t), opts)
+			assert.ErrorIs(t, err, m2cs.ErrWeakEncryptKey)
+			assert.EqualError(t, err, "invalid "+c.backend+" connection: weak encryption key: EncryptKey has 4 characters, "+
+				"at least 16 are required; use a longer random passphrase, or a 32-byte EncryptKeyBytes")
+
+			opts.EncryptKey = "aaaabbbbaaaabbbb"
+			err = c.connect(newServer(t), opts)
+			assert.ErrorIs(t, err, m2cs.ErrWeakEncryptKey)
+			assert.ErrorContains(t, err, "EncryptKey has too few distinct characters (about 16 bits of entropy, at least 32 are required)")
+
+			opts.EncryptKey = strong
+			assert.NoError(t, c.connect(newServer(t), opts))
+
+			opts.MinEncryptKeyLength = 24
+			assert.ErrorContains(t, c.connect(newServer(t), opts), "EncryptKey has 16 characters, at least 24 are required")
+			opts.MinEncryptKeyLength = 4
+			opts.EncryptKey = "aaaa"
+			assert.ErrorIs(t, c.connect(newServer(t), opts), m2cs.ErrWeakEncryptKey)
+			opts.EncryptKey = "Kp9!"
+			assert.NoError(t, c.connect(newServer(t), opts))
+
+			opts.MinEncryptKeyLength = 0
+			opts.AllowWeakKeys = true
+			opts.EncryptKey = "m2cs"
+			assert.NoError(t, c.connect(newServer(t), opts))
+		})
+	}
+
+	logs := captureLog(t)
+	opts := options(m2cs.NO_ENCRYPTION, "m2cs")
+	opts.AllowWeakKeys = false
+	assert.NoError(t, constructors[0].connect(newServer(t), opts), "an unused key is not checked")
+	assert.Contains(t, logs.String(), m2cs.ErrUnusedEncryptKey.Error())
+}
+
+func TestEncryptKey_Bytes(t *testing.T) {
+	key := sha256.Sum256([]byte("m2cs"))
+
+	for _, c := range constructors {
+		t.Run(c.backend, func(t *testing.T) {
+			opts := options(m2cs.AES256_ENCRYPTION, "")
+			opts.EncryptKeyBytes = key[:]
+			opts.AllowWeakKeys = false
+			assert.NoError(t, c.connect(newServer(t), opts), "raw keys are not checked for strength")
+
+			opts.EncryptKeyBytes = key[:16]
+			assert.EqualError(t, c.connect(newServer(t), opts), "invalid "+c.backend+" connection: EncryptKeyBytes must be 32 bytes, got 16; "+
+				"generate it with crypto/rand, or derive it with sha256.Sum256")
+
+			opts.EncryptKeyBytes = key[:]
+			opts.EncryptKey = "fTq8-Lx2!vRz9#Kp"
+			assert.ErrorContains(t, c.connect(newServer(t), opts), "EncryptKey and EncryptKeyBytes cannot both be set")
+
+			logs := captureLog(t)
+			opts = options(m2cs.NO_ENCRYPTION, "")
+			opts.EncryptKeyBytes = key[:]
+			assert.NoError(t, c.connect(newServer(t), opts))
+			assert.Contains(t, logs.String(), m2cs.ErrUnusedEncryptKey.Error())
+		})
+	}
+}
+
+func read(t *testing.T, s filestorage.FileStorage, key string) (string, error) {
+	obj, err := s.GetObject(context.Background(), "box", key)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	return string(data), err
+}
+
+func TestEncryptKey_BytesRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	key := make([]byte, common.EncryptKeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	for name, compress := range map[string]common.CompressionAlgorithm{"plain": common.NO_COMPRESSION, "gzip": common.GZIP_COMPRESSION} {
+		t.Run(name, func(t *testing.T) {
+			memory := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{SaveEncrypt: common.AES256_ENCRYPTION, SaveCompress: compress, EncryptKeyBytes: key}, "box")
+			require.NoError(t, memory.PutObject(ctx, "box", "key", strings.NewReader("raw key content")))
+
+			data, err := read(t, memory, "key")
+			require.NoError(t, err)
+			assert.Equal(t, "raw key content", data)
+			assert.Equal(t, key, memory.GetConnectionProperties().EncryptKeyBytes)
+		})
+	}
+
+	_, err = transform.Factory{}.BuildWPipelineCompressEncrypt(common.ConnectionProperties{SaveEncrypt: common.AES256_ENCRYPTION, EncryptKeyBytes: key[:8]}, "")
+	assert.EqualError(t, err, "aesgcm: key must be 32 bytes, got 8")
+}
+
+// encrypt returns content encrypted by the write pipeline of props.
+func encrypt(t *testing.T, props common.ConnectionProperties, content string) []byte {
+	pipe, err := transform.Factory{}.BuildWPipelineCompressEncrypt(props, props.EncryptKey)
+	require.NoError(t, err)
+	out, closer, err := pipe.Apply(strings.NewReader(content))
+	require.NoError(t, err)
+	defer closer.Close()
+	data, err := io.ReadAll(out)
+	require.NoError(t, err)
+	return data
+}
+
+// decrypt returns data decrypted by the read pipeline of props.
+func decrypt(t *testing.T, props common.ConnectionProperties, data []byte) (string, error) {
+	pipe, err := transform.Factory{}.BuildRPipelineDecryptDecompress(props, props.EncryptKey)
+	require.NoError(t, err)
+	rc, err := pipe.Apply(io.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	out, err := io.ReadAll(rc)
+	return string(out), err
+}
+
+func TestEncryptKey_Interop(t *testing.T) {
+	const passphrase = "m2cs-interop-passphrase"
+	derived := sha256.Sum256([]byte(passphrase))
+	withPassphrase := common.ConnectionProperties{SaveEncrypt: common.AES256_ENCRYPTION, SaveCompress: common.GZIP_COMPRESSION, EncryptKey: passphrase}
+	withBytes := common.ConnectionProperties{SaveEncrypt: common.AES256_ENCRYPTION, SaveCompress: common.GZIP_COMPRESSION, EncryptKeyBytes: derived[:]}
+
+	// the raw key derived from the passphrase reads the objects written with it, and the other way around
+	data, err := decrypt(t, withBytes, encrypt(t, withPassphrase, "interop content"))
+	require.NoError(t, err)
+	assert.Equal(t, "interop content", data)
+	data, err = decrypt(t, withPassphrase, encrypt(t, withBytes, "back content"))
+	require.NoError(t, err)
+	assert.Equal(t, "back content", data)
+
+	other := sha256.Sum256([]byte("another passphrase"))
+	withBytes.EncryptKeyBytes = other[:]
+	_, err = decrypt(t, withBytes, encrypt(t, withPassphrase, "interop content"))
+	assert.ErrorContains(t, err, "decryption failed")
+
+	// a client with the raw key rotated to the equivalent passphrase reads the objects it wrote
+	ctx := context.Background()
+	memory := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{SaveEncrypt: common.AES256_ENCRYPTION, EncryptKeyBytes: derived[:]}, "box")
+	require.NoError(t, memory.PutObject(ctx, "box", "key", strings.NewReader("rotated content")))
+	require.NoError(t, memory.RotateEncryptKey(passphrase))
+	props := memory.GetConnectionProperties()
+	assert.Equal(t, passphrase, props.EncryptKey)
+	assert.Nil(t, props.EncryptKeyBytes, "the rotation replaces the raw key")
+	data, err = read(t, memory, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "rotated content", data)
+}
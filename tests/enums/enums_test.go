@@ -0,0 +1,128 @@
+package enums_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/transform"
+)
+
+// enum is the constants of a type, with their names and short names, and its parse function.
+type enum[T fmt.Stringer] struct {
+	values  []T
+	names   []string
+	short   []string
+	parse   func(string) (T, error)
+	unknown T
+}
+
+func (e enum[T]) test(t *testing.T) {
+	for i, v := range e.values {
+		assert.Equal(t, e.names[i], v.String())
+		for _, name := range []string{v.String(), e.short[i]} {
+			parsed, err := e.parse(name)
+			require.NoError(t, err, name)
+			assert.Equal(t, v, parsed, name)
+		}
+
+		// case-insensitively
+		parsed, err := e.parse(flipCase(v.String()))
+		require.NoError(t, err)
+		assert.Equal(t, v, parsed)
+	}
+
+	for _, name := range []string{"", "bogus", e.unknown.String()} {
+		_, err := e.parse(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func flipCase(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z':
+			b[i] = c - 'a' + 'A'
+		case c >= 'A' && c <= 'Z':
+			b[i] = c - 'A' + 'a'
+		}
+	}
+	return string(b)
+}
+
+func TestReplicationMode(t *testing.T) {
+	enum[m2cs.ReplicationMode]{
+		values:  []m2cs.ReplicationMode{m2cs.SYNC_REPLICATION, m2cs.ASYNC_REPLICATION},
+		names:   []string{"SYNC_REPLICATION", "ASYNC_REPLICATION"},
+		short:   []string{"sync", "async"},
+		parse:   m2cs.ParseReplicationMode,
+		unknown: m2cs.ReplicationMode(7),
+	}.test(t)
+
+	assert.Equal(t, "ReplicationMode(7)", m2cs.ReplicationMode(7).String())
+	_, err := m2cs.ParseReplicationMode("eventual")
+	assert.EqualError(t, err, `unknown replication mode "eventual"; use sync or async`)
+}
+
+func TestLoadBalancingStrategy(t *testing.T) {
+	enum[m2cs.LoadBalancingStrategy]{
+		values:  []m2cs.LoadBalancingStrategy{m2cs.READ_REPLICA_FIRST, m2cs.ROUND_ROBIN},
+		names:   []string{"READ_REPLICA_FIRST", "ROUND_ROBIN"},
+		short:   []string{"read-replica-first", "round-robin"},
+		parse:   m2cs.ParseLoadBalancingStrategy,
+		unknown: m2cs.LoadBalancingStrategy(7),
+	}.test(t)
+
+	assert.Equal(t, "LoadBalancingStrategy(7)", m2cs.LoadBalancingStrategy(7).String())
+	_, err := m2cs.NewLoadBalancer(m2cs.LoadBalancingStrategy(7))
+	assert.EqualError(t, err, "unsupported load balancing strategy: LoadBalancingStrategy(7)")
+}
+
+func TestCompressionAlgorithm(t *testing.T) {
+	enum[m2cs.CompressionAlgorithm]{
+		values:  []m2cs.CompressionAlgorithm{m2cs.NO_COMPRESSION, m2cs.GZIP_COMPRESSION, m2cs.GZIP_CONTENT_ENCODING},
+		names:   []string{"NO_COMPRESSION", "GZIP_COMPRESSION", "GZIP_CONTENT_ENCODING"},
+		short:   []string{"none", "gzip", "gzip-content-encoding"},
+		parse:   m2cs.ParseCompressionAlgorithm,
+		unknown: m2cs.CompressionAlgorithm(7),
+	}.test(t)
+
+	assert.Equal(t, "CompressionAlgorithm(7)", m2cs.CompressionAlgorithm(7).String())
+	_, err := transform.Factory{}.BuildWPipelineCompressEncrypt(common.ConnectionProperties{SaveCompress: 7}, "")
+	assert.EqualError(t, err, "unsupported compression algorithm: CompressionAlgorithm(7)")
+}
+
+func TestEncryptionAlgorithm(t *testing.T) {
+	enum[m2cs.EncryptionAlgorithm]{
+		values:  []m2cs.EncryptionAlgorithm{m2cs.NO_ENCRYPTION, m2cs.AES256_ENCRYPTION},
+		names:   []string{"NO_ENCRYPTION", "AES256_ENCRYPTION"},
+		short:   []string{"none", "aes256"},
+		parse:   m2cs.ParseEncryptionAlgorithm,
+		unknown: m2cs.EncryptionAlgorithm(7),
+	}.test(t)
+
+	assert.Equal(t, "EncryptionAlgorithm(7)", m2cs.EncryptionAlgorithm(7).String())
+	_, err := transform.Factory{}.BuildRPipelineWithEncoding(common.ConnectionProperties{SaveEncrypt: 7}, "", "")
+	assert.EqualError(t, err, "unsupported encryption algorithm: EncryptionAlgorithm(7)")
+}
+
+func TestFileClient_UnknownReplicationMode(t *testing.T) {
+	var storages []filestorage.FileStorage
+	for _, label := range []string{"first", "second"} {
+		memory := filestorage.NewMemoryClient(common.ConnectionProperties{Label: label, IsMainInstance: true})
+		require.NoError(t, memory.MakeBucket(context.Background(), "box"))
+		storages = append(storages, memory)
+	}
+	client := m2cs.NewFileClient(m2cs.ReplicationMode(7), m2cs.READ_REPLICA_FIRST, storages...)
+
+	err := client.PutObject(context.Background(), "box", "key", strings.NewReader("data"))
+	assert.EqualError(t, err, "unsupported replication mode: ReplicationMode(7)")
+}
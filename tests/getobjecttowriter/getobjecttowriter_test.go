@@ -0,0 +1,248 @@
+package getobjecttowriter_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+func newClient(t *testing.T, storages ...filestorage.FileStorage) *m2cs.FileClient {
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storages)
+	require.NoError(t, err)
+	return client
+}
+
+// cancelingWriter discards what it is written, and cancels its context once it got after bytes.
+type cancelingWriter struct {
+	written int
+	after   int
+	cancel  context.CancelFunc
+}
+
+func (w *cancelingWriter) Write(p []byte) (int, error) {
+	w.written += len(p)
+	if w.written >= w.after {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
+func TestGetObjectToWriter_LargeObject(t *testing.T) {
+	for _, props := range []common.ConnectionProperties{
+		{Label: "plain", IsMainInstance: true},
+		{Label: "transformed", IsMainInstance: true, SaveCompress: common.GZIP_COMPRESSION, SaveEncrypt: common.AES256_ENCRYPTION, EncryptKey: "m2cs-test-passphrase"},
+	} {
+		t.Run(props.Label, func(t *testing.T) {
+			client := newClient(t, storagetest.NewMemoryWithProperties(t, props, "box"))
+			data := make([]byte, 32<<20)
+			_, err := rand.Read(data)
+			require.NoError(t, err)
+			require.NoError(t, client.PutObject(context.Background(), "box", "large", bytes.NewReader(data)))
+
+			hash := sha256.New()
+			n, err := client.GetObjectToWriter(context.Background(), "box", "large", hash)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), n)
+			want := sha256.Sum256(data)
+			assert.Equal(t, want[:], hash.Sum(nil))
+		})
+	}
+}
+
+func TestGetObjectToWriter_Canceled(t *testing.T) {
+	client := newClient(t, storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "plain", IsMainInstance: true}, "box"))
+	data := bytes.Repeat([]byte("m2cs"), 4<<20)
+	require.NoError(t, client.PutObject(context.Background(), "box", "large", bytes.NewReader(data)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &cancelingWriter{after: 1 << 20, cancel: cancel}
+	n, err := client.GetObjectToWriter(ctx, "box", "large", w)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, n, int64(len(data)))
+	assert.Equal(t, int64(w.written), n)
+}
+
+func TestGetObjectToWriter_Cache(t *testing.T) {
+	client := newClient(t, storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "plain", IsMainInstance: true}, "box"))
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, MaxSizeMB: 8, MaxItemSizeMB: 1, MaxItems: 10}))
+
+	large := bytes.Repeat([]byte("m2cs"), 1<<19) // 2 MB
+	require.NoError(t, client.PutObject(context.Background(), "box", "large", bytes.NewReader(large)))
+	require.NoError(t, client.PutObject(context.Background(), "box", "small", strings.NewReader("small")))
+
+	var buf bytes.Buffer
+	n, err := client.GetObjectToWriter(context.Background(), "box", "large", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(large)), n)
+	assert.Equal(t, large, buf.Bytes())
+	_, cached := client.CacheContains("box", "large")
+	assert.False(t, cached, "objects above the cache item limit should not be cached")
+
+	buf.Reset()
+	_, err = client.GetObjectToWriter(context.Background(), "box", "small", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, "small", buf.String())
+	_, cached = client.CacheContains("box", "small")
+	assert.True(t, cached)
+
+	// the cached copy is served
+	buf.Reset()
+	n, err = client.GetObjectToWriter(context.Background(), "box", "small", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.Equal(t, "small", buf.String())
+}
+
+// corruptedStorage serves content that differs from its ETag.
+type corruptedStorage struct {
+	*filestorage.MemoryClient
+}
+
+func (s corruptedStorage) GetObjectWithInfo(ctx context.Context, storeBox, fileName string) (io.ReadCloser, filestorage.ObjectStat, error) {
+	obj, stat, err := s.MemoryClient.GetObjectWithInfo(ctx, storeBox, fileName)
+	if err != nil {
+		return nil, stat, err
+	}
+	_ = obj.Close()
+	return io.NopCloser(strings.NewReader("corrupted")), stat, nil
+}
+
+func TestGetObjectToWriter_ChecksumMismatch(t *testing.T) {
+	client := newClient(t, corruptedStorage{storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "plain", IsMainInstance: true}, "box")})
+	require.NoError(t, client.PutObject(context.Background(), "box", "key", strings.NewReader("content")))
+
+	var buf bytes.Buffer
+	_, err := client.GetObjectToWriter(context.Background(), "box", "key", &buf)
+	require.ErrorIs(t, err, m2cs.ErrChecksumMismatch)
+}
+
+func TestGetObjectToWriter_Missing(t *testing.T) {
+	client := newClient(t, storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "plain", IsMainInstance: true}, "box"))
+
+	n, err := client.GetObjectToWriter(context.Background(), "box", "missing", io.Discard)
+	require.ErrorIs(t, err, filestorage.ErrObjectNotFound)
+	assert.Zero(t, n)
+}
+
+// interruptedStorage serves the objects through GetObject up to cut bytes, then fails, as a
+// dropped connection; it counts the ranged reads.
+type interruptedStorage struct {
+	*filestorage.MemoryClient
+	cut    int64
+	ranges int
+}
+
+func (s *interruptedStorage) GetObject(ctx context.Context, storeBox, fileName string) (io.ReadCloser, error) {
+	obj, err := s.MemoryClient.GetObject(ctx, storeBox, fileName)
+	if err != nil || s.cut <= 0 {
+		return obj, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(io.LimitReader(obj, s.cut), iotest.ErrReader(errors.New("connection reset"))), obj}, nil
+}
+
+func (s *interruptedStorage) GetObjectRange(ctx context.Context, storeBox, fileName string, offset, length int64) (io.ReadCloser, error) {
+	s.ranges++
+	return s.MemoryClient.GetObjectRange(ctx, storeBox, fileName, offset, length)
+}
+
+// interruptedDownload leaves the part file of an FGetObject of key cut after 1 MiB.
+func interruptedDownload(t *testing.T, client *m2cs.FileClient, storage *interruptedStorage, path string) {
+	storage.cut = 1 << 20
+	require.ErrorContains(t, client.FGetObject(context.Background(), "box", "key", path), "connection reset")
+	storage.cut = 0
+	info, err := os.Stat(path + m2cs.PartFileSuffix)
+	require.NoError(t, err)
+	require.Equal(t, int64(1<<20), info.Size())
+}
+
+func TestFGetObject_Resume(t *testing.T) {
+	ctx := context.Background()
+	data := make([]byte, 4<<20)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	t.Run("unchanged", func(t *testing.T) {
+		storage := &interruptedStorage{MemoryClient: storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{IsMainInstance: true}, "box")}
+		client := newClient(t, storage)
+		require.NoError(t, client.PutObject(ctx, "box", "key", bytes.NewReader(data)))
+
+		path := filepath.Join(t.TempDir(), "key")
+		interruptedDownload(t, client, storage, path)
+		require.NoError(t, client.FGetObject(ctx, "box", "key", path))
+		assert.Equal(t, 1, storage.ranges, "the download is resumed")
+		downloaded, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, downloaded)
+		entries, err := os.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "the part file and its info are removed")
+	})
+
+	t.Run("rewritten", func(t *testing.T) {
+		storage := &interruptedStorage{MemoryClient: storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{IsMainInstance: true}, "box")}
+		client := newClient(t, storage)
+		require.NoError(t, client.PutObject(ctx, "box", "key", bytes.NewReader(data)))
+
+		path := filepath.Join(t.TempDir(), "key")
+		interruptedDownload(t, client, storage, path)
+		// same size, other content: only the ETag tells the versions apart
+		rewritten := bytes.Clone(data)
+		rewritten[0] ^= 0xff
+		require.NoError(t, client.PutObject(ctx, "box", "key", bytes.NewReader(rewritten)))
+
+		require.NoError(t, client.FGetObject(ctx, "box", "key", path))
+		assert.Zero(t, storage.ranges, "the part file of the previous version is discarded")
+		downloaded, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, rewritten, downloaded)
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		storage := &interruptedStorage{MemoryClient: storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{IsMainInstance: true}, "box")}
+		client := newClient(t, storage)
+		require.NoError(t, client.PutObject(ctx, "box", "key", bytes.NewReader(data)))
+
+		path := filepath.Join(t.TempDir(), "key")
+		interruptedDownload(t, client, storage, path)
+		require.NoError(t, storage.RemoveObject(ctx, "box", "key"))
+
+		err := client.FGetObject(ctx, "box", "key", path)
+		assert.ErrorIs(t, err, filestorage.ErrObjectNotFound, "the failures other than a changed object are returned")
+		_, err = os.Stat(path + m2cs.PartFileSuffix)
+		assert.NoError(t, err, "the part file is kept")
+	})
+
+	t.Run("transformed", func(t *testing.T) {
+		storage := &interruptedStorage{MemoryClient: storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{IsMainInstance: true, SaveCompress: common.GZIP_COMPRESSION}, "box")}
+		client := newClient(t, storage)
+		require.NoError(t, client.PutObject(ctx, "box", "key", bytes.NewReader(data)))
+
+		path := filepath.Join(t.TempDir(), "key")
+		interruptedDownload(t, client, storage, path)
+		require.NoError(t, client.FGetObject(ctx, "box", "key", path))
+		assert.Zero(t, storage.ranges, "the storages saving transformed objects cannot resume")
+		downloaded, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, downloaded)
+	})
+}
@@ -0,0 +1,170 @@
+package interceptors_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+var errForbidden = errors.New("forbidden key")
+
+func newClient(t *testing.T, interceptors ...m2cs.Interceptor) (*m2cs.FileClient, *filestorage.MemoryClient) {
+	memory := storagetest.NewMemory(t, "memory", "box")
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{memory}, m2cs.WithInterceptors(interceptors...))
+	require.NoError(t, err)
+	return client, memory
+}
+
+// rejecting returns an interceptor failing the operations on the keys matching pattern.
+func rejecting(pattern string) m2cs.Interceptor {
+	re := regexp.MustCompile(pattern)
+	return func(ctx context.Context, op m2cs.OpInfo, next func(ctx context.Context) error) error {
+		if re.MatchString(op.Key) {
+			return fmt.Errorf("%w: %s", errForbidden, op.Key)
+		}
+		return next(ctx)
+	}
+}
+
+// counter counts the operations by name, along with their outcome.
+type counter struct {
+	mu     sync.Mutex
+	ops    map[string]int
+	failed int
+	sizes  []int64
+}
+
+func (c *counter) intercept(ctx context.Context, op m2cs.OpInfo, next func(ctx context.Context) error) error {
+	err := next(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ops == nil {
+		c.ops = make(map[string]int)
+	}
+	c.ops[op.Name]++
+	if err != nil {
+		c.failed++
+	}
+	if op.Type == m2cs.WRITE_OPERATION {
+		c.sizes = append(c.sizes, op.Size)
+	}
+	return err
+}
+
+func TestInterceptors_RejectKeys(t *testing.T) {
+	client, memory := newClient(t, rejecting(`^secret/`))
+	ctx := context.Background()
+
+	err := client.PutObject(ctx, "box", "secret/key", strings.NewReader("data"))
+	assert.ErrorIs(t, err, errForbidden)
+	exists, err := memory.ExistObject(ctx, "box", "secret/key")
+	require.NoError(t, err)
+	assert.False(t, exists, "a rejected write must not reach the storages")
+
+	_, err = client.GetObject(ctx, "box", "secret/key")
+	assert.ErrorIs(t, err, errForbidden)
+	_, err = client.ExistsObject(ctx, "box", "secret/key")
+	assert.ErrorIs(t, err, errForbidden)
+	assert.ErrorIs(t, client.RemoveObject(ctx, "box", "secret/key"), errForbidden)
+
+	require.NoError(t, client.PutObject(ctx, "box", "public/key", strings.NewReader("data")))
+	obj, err := client.GetObject(ctx, "box", "public/key")
+	require.NoError(t, err)
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	require.NoError(t, obj.Close())
+}
+
+func TestInterceptors_CountOperations(t *testing.T) {
+	var c counter
+	client, _ := newClient(t, c.intercept)
+	ctx := context.Background()
+
+	require.NoError(t, client.PutObject(ctx, "box", "key", strings.NewReader("data")))
+	require.NoError(t, client.PutObjectWithOptions(ctx, "box", "sized", io.LimitReader(strings.NewReader("data"), 4), m2cs.PutOptions{Size: 4}))
+	require.NoError(t, client.PutObjectWithOptions(ctx, "box", "unsized", io.LimitReader(strings.NewReader("data"), 4), m2cs.PutOptions{}))
+	obj, err := client.GetObject(ctx, "box", "key")
+	require.NoError(t, err)
+	require.NoError(t, obj.Close())
+	_, err = client.ExistObject(ctx, "box", "key")
+	require.NoError(t, err)
+	_, err = client.ExistsObject(ctx, "box", "key")
+	require.NoError(t, err)
+	require.NoError(t, client.RemoveObject(ctx, "box", "key"))
+	_, err = client.GetObject(ctx, "box", "key")
+	require.Error(t, err)
+
+	assert.Equal(t, map[string]int{
+		"PutObject":            1,
+		"PutObjectWithOptions": 2,
+		"GetObject":            2,
+		"ExistObject":          1,
+		"ExistsObject":         1,
+		"RemoveObject":         1,
+	}, c.ops)
+	assert.Equal(t, 1, c.failed)
+	assert.Equal(t, []int64{4, 4, -1}, c.sizes)
+}
+
+func TestInterceptors_Order(t *testing.T) {
+	var calls []string
+	record := func(name string) m2cs.Interceptor {
+		return func(ctx context.Context, op m2cs.OpInfo, next func(ctx context.Context) error) error {
+			calls = append(calls, name+" before")
+			err := next(ctx)
+			calls = append(calls, name+" after")
+			return err
+		}
+	}
+	client, _ := newClient(t, record("first"), record("second"))
+
+	require.NoError(t, client.PutObject(context.Background(), "box", "key", strings.NewReader("data")))
+	assert.Equal(t, []string{"first before", "second before", "second after", "first after"}, calls)
+
+	// a short-circuiting interceptor skips the ones registered after it
+	calls = nil
+	client, _ = newClient(t, record("first"), rejecting(".*"), record("third"))
+	assert.ErrorIs(t, client.PutObject(context.Background(), "box", "key", strings.NewReader("data")), errForbidden)
+	assert.Equal(t, []string{"first before", "first after"}, calls)
+}
+
+func TestInterceptors_Context(t *testing.T) {
+	type key struct{}
+	var got any
+	client, _ := newClient(t,
+		func(ctx context.Context, op m2cs.OpInfo, next func(ctx context.Context) error) error {
+			return next(context.WithValue(ctx, key{}, op.Name))
+		},
+		func(ctx context.Context, op m2cs.OpInfo, next func(ctx context.Context) error) error {
+			got = ctx.Value(key{})
+			return next(ctx)
+		})
+
+	_, err := client.ExistObject(context.Background(), "box", "key")
+	require.NoError(t, err)
+	assert.Equal(t, "ExistObject", got)
+
+	// an interceptor skipping the operation without an error fails it
+	client, _ = newClient(t, func(ctx context.Context, op m2cs.OpInfo, next func(ctx context.Context) error) error {
+		return nil
+	})
+	assert.ErrorContains(t, client.PutObject(context.Background(), "box", "key", strings.NewReader("data")), "skipped by an interceptor")
+
+	_, err = m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, nil, m2cs.WithInterceptors(nil))
+	assert.ErrorContains(t, err, "interceptor at index 0 is nil")
+}
@@ -0,0 +1,154 @@
+package journal_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+// hangingStorage is a MemoryClient whose writes hang until release is closed while it hangs,
+// and fail while it is down.
+type hangingStorage struct {
+	*filestorage.MemoryClient
+	release chan struct{}
+	hang    atomic.Bool
+	down    atomic.Bool
+}
+
+func wrap(memory *filestorage.MemoryClient) *hangingStorage {
+	return &hangingStorage{MemoryClient: memory, release: make(chan struct{})}
+}
+
+func (s *hangingStorage) PutObject(ctx context.Context, storeBox, fileName string, reader io.Reader) error {
+	if s.hang.Load() {
+		<-s.release
+		return errors.New("process crashed")
+	}
+	if s.down.Load() {
+		return errors.New("connection refused")
+	}
+	return s.MemoryClient.PutObject(ctx, storeBox, fileName, reader)
+}
+
+func newClient(t *testing.T, dir string, storages ...filestorage.FileStorage) *m2cs.FileClient {
+	client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		storages, m2cs.WithReplicationJournal(dir))
+	require.NoError(t, err)
+	return client
+}
+
+func holds(t *testing.T, memory *filestorage.MemoryClient, key, content string) bool {
+	obj, err := memory.GetObject(context.Background(), "box", key)
+	if errors.Is(err, filestorage.ErrObjectNotFound) {
+		return false
+	}
+	require.NoError(t, err)
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	return string(data) == content
+}
+
+func entries(t *testing.T, dir, suffix string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+suffix))
+	require.NoError(t, err)
+	return matches
+}
+
+func TestJournal_RecoverAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	first, second, third := storagetest.NewMemory(t, "first", "box"), storagetest.NewMemory(t, "second", "box"), storagetest.NewMemory(t, "third", "box")
+
+	// the background replication to second and third never completes, as if the process crashed
+	crashed := []*hangingStorage{wrap(first), wrap(second), wrap(third)}
+	crashed[1].hang.Store(true)
+	crashed[2].hang.Store(true)
+	defer close(crashed[1].release)
+	defer close(crashed[2].release)
+	client := newClient(t, dir, crashed[0], crashed[1], crashed[2])
+
+	require.NoError(t, client.PutObject(context.Background(), "box", "a", strings.NewReader("A")))
+	require.NoError(t, client.PutObject(context.Background(), "box", "b", strings.NewReader("B")))
+	assert.Len(t, entries(t, dir, ".json"), 2, "the pending replications are journaled before PutObject returns")
+	assert.False(t, holds(t, second, "a", "A"))
+
+	// a garbage entry and the leftover of an entry being written are handled
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0-garbage.json"), []byte("{not json"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1-partial.json.tmp-123"), []byte(`{"box":`), 0o644))
+
+	// the restarted process finds third down
+	restarted := []*hangingStorage{wrap(first), wrap(second), wrap(third)}
+	restarted[2].down.Store(true)
+	client = newClient(t, dir, restarted[0], restarted[1], restarted[2])
+
+	report, err := client.RecoverPendingReplications(context.Background())
+	assert.ErrorContains(t, err, "connection refused")
+	assert.Equal(t, m2cs.RecoveryReport{Entries: 2, Failed: 2, Corrupt: 1}, report)
+	assert.True(t, holds(t, second, "a", "A"))
+	assert.True(t, holds(t, second, "b", "B"))
+	assert.False(t, holds(t, third, "a", "A"))
+	assert.Len(t, entries(t, dir, ".json"), 2, "the entries are kept for the failed targets")
+	assert.Len(t, entries(t, dir, ".corrupt"), 1)
+	assert.Empty(t, entries(t, dir, ".tmp-123"))
+
+	// replaying again only needs third, and is idempotent on second
+	restarted[2].down.Store(false)
+	report, err = client.RecoverPendingReplications(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, m2cs.RecoveryReport{Entries: 2, Replicated: 2}, report)
+	for _, memory := range []*filestorage.MemoryClient{first, second, third} {
+		assert.True(t, holds(t, memory, "a", "A"))
+		assert.True(t, holds(t, memory, "b", "B"))
+	}
+	assert.Empty(t, entries(t, dir, ".json"))
+
+	report, err = client.RecoverPendingReplications(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, m2cs.RecoveryReport{}, report)
+}
+
+func TestJournal_CompletedReplications(t *testing.T) {
+	dir := t.TempDir()
+	first, second := storagetest.NewMemory(t, "first", "box"), storagetest.NewMemory(t, "second", "box")
+	storages := []*hangingStorage{wrap(first), wrap(second)}
+	client := newClient(t, dir, storages[0], storages[1])
+
+	require.NoError(t, client.PutObject(context.Background(), "box", "a", strings.NewReader("A")))
+	assert.Eventually(t, func() bool { return holds(t, second, "a", "A") }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return len(entries(t, dir, ".json")) == 0 }, time.Second, 10*time.Millisecond,
+		"the entry is removed once every target is written")
+
+	// a failed background write is kept in the journal
+	storages[1].down.Store(true)
+	require.NoError(t, client.PutObject(context.Background(), "box", "b", strings.NewReader("B")))
+	assert.Eventually(t, func() bool { return client.ReplicationStatus().Backlog == 0 }, time.Second, 10*time.Millisecond)
+	assert.Len(t, entries(t, dir, ".json"), 1)
+
+	storages[1].down.Store(false)
+	report, err := client.RecoverPendingReplications(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, m2cs.RecoveryReport{Entries: 1, Replicated: 1}, report)
+	assert.True(t, holds(t, second, "b", "B"))
+}
+
+func TestJournal_NotConfigured(t *testing.T) {
+	client := m2cs.NewFileClient(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storagetest.NewMemory(t, "a", "box"))
+	_, err := client.RecoverPendingReplications(context.Background())
+	assert.ErrorContains(t, err, "no replication journal configured")
+
+	_, err = m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, nil, m2cs.WithReplicationJournal(""))
+	assert.ErrorContains(t, err, "journal directory is empty")
+}
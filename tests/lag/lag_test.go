@@ -0,0 +1,160 @@
+package lag_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+var errUnavailable = errors.New("storage unavailable")
+
+// lags records the lags reported to the hook.
+type lags struct {
+	mu      sync.Mutex
+	reports map[string][]m2cs.LagInfo
+}
+
+func (l *lags) record(storage string, lag m2cs.LagInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.reports == nil {
+		l.reports = make(map[string][]m2cs.LagInfo)
+	}
+	l.reports[storage] = append(l.reports[storage], lag)
+}
+
+func (l *lags) get(storage string) []m2cs.LagInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]m2cs.LagInfo(nil), l.reports[storage]...)
+}
+
+func put(t *testing.T, client *m2cs.FileClient, key string) {
+	require.NoError(t, client.PutObject(context.Background(), "box", key, strings.NewReader("data")))
+}
+
+func TestReplicationLag_SlowReplica(t *testing.T) {
+	gate := storagetest.NewGate()
+	fast := storagetest.NewMemory(t, "fast", "box")
+	slow := storagetest.Wrap(storagetest.NewMemory(t, "slow", "box"), gate.Decorator())
+	var reported lags
+	client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{fast, slow}, m2cs.WithReplicationLagHook(reported.record))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]m2cs.LagInfo{"fast": {}, "slow": {}}, client.ReplicationLag())
+
+	const puts = 3
+	for i := range puts {
+		put(t, client, fmt.Sprintf("key-%d", i))
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	lag := client.ReplicationLag()
+	assert.Equal(t, puts, lag["slow"].PendingObjects)
+	assert.GreaterOrEqual(t, lag["slow"].OldestPendingAge, 10*time.Millisecond)
+	assert.True(t, lag["slow"].LastReplicated.IsZero())
+	assert.Zero(t, lag["fast"].PendingObjects)
+	assert.False(t, lag["fast"].LastReplicated.IsZero())
+
+	gate.Release()
+	require.Eventually(t, func() bool { return client.ReplicationStatus().Backlog == 0 }, 5*time.Second, time.Millisecond)
+
+	lag = client.ReplicationLag()
+	assert.Zero(t, lag["slow"].PendingObjects)
+	assert.Zero(t, lag["slow"].OldestPendingAge)
+	assert.False(t, lag["slow"].LastReplicated.IsZero())
+
+	// the hook saw the lag rise, then drain
+	var peak int
+	for _, r := range reported.get("slow") {
+		peak = max(peak, r.PendingObjects)
+	}
+	reports := reported.get("slow")
+	assert.Equal(t, puts, peak)
+	assert.Zero(t, reports[len(reports)-1].PendingObjects)
+}
+
+func TestReplicationLag_SameKey(t *testing.T) {
+	gate := storagetest.NewGate()
+	fast := storagetest.NewMemory(t, "fast", "box")
+	slow := storagetest.Wrap(storagetest.NewMemory(t, "slow", "box"), gate.Decorator())
+	client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{fast, slow})
+	require.NoError(t, err)
+
+	// the writes of a key are one pending object until all are confirmed
+	put(t, client, "key")
+	put(t, client, "key")
+	assert.Equal(t, 1, client.ReplicationLag()["slow"].PendingObjects)
+
+	gate.Release()
+	require.Eventually(t, func() bool { return client.ReplicationStatus().Backlog == 0 }, 5*time.Second, time.Millisecond)
+	assert.Zero(t, client.ReplicationLag()["slow"].PendingObjects)
+}
+
+// TestReplicationLag_Recovery checks a failed background replication keeps lagging until it is
+// completed by RecoverPendingReplications.
+func TestReplicationLag_Recovery(t *testing.T) {
+	outage := storagetest.NewOutage(errUnavailable)
+	outage.Down()
+	fast := storagetest.NewMemory(t, "fast", "box")
+	broken := storagetest.Wrap(storagetest.NewMemory(t, "broken", "box"), outage.Decorator())
+	client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{fast, broken}, m2cs.WithReplicationJournal(t.TempDir()))
+	require.NoError(t, err)
+
+	put(t, client, "key")
+	require.Eventually(t, func() bool { return client.ReplicationStatus().Backlog == 0 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, 1, client.ReplicationLag()["broken"].PendingObjects)
+
+	outage.Up()
+	report, err := client.RecoverPendingReplications(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Replicated)
+	lag := client.ReplicationLag()["broken"]
+	assert.Zero(t, lag.PendingObjects)
+	assert.False(t, lag.LastReplicated.IsZero())
+}
+
+func TestReplicationLag_Sync(t *testing.T) {
+	fastOutage, brokenOutage := storagetest.NewOutage(errUnavailable), storagetest.NewOutage(errUnavailable)
+	brokenOutage.Down()
+	fast := storagetest.Wrap(storagetest.NewMemory(t, "fast", "box"), fastOutage.Decorator())
+	broken := storagetest.Wrap(storagetest.NewMemory(t, "broken", "box"), brokenOutage.Decorator())
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{fast, broken})
+	require.NoError(t, err)
+
+	err = client.PutObject(context.Background(), "box", "key", strings.NewReader("data"))
+	require.Error(t, err)
+	assert.Equal(t, 1, client.ReplicationLag()["broken"].PendingObjects)
+	assert.Zero(t, client.ReplicationLag()["fast"].PendingObjects)
+
+	// a write failing everywhere leaves nothing behind
+	fastOutage.Down()
+	err = client.PutObject(context.Background(), "box", "other", strings.NewReader("data"))
+	require.Error(t, err)
+	assert.Equal(t, 1, client.ReplicationLag()["broken"].PendingObjects)
+
+	fastOutage.Up()
+	brokenOutage.Up()
+	put(t, client, "key")
+	assert.Zero(t, client.ReplicationLag()["broken"].PendingObjects)
+}
+
+func TestReplicationLag_NilHook(t *testing.T) {
+	_, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{storagetest.NewMemory(t, "fast", "box")}, m2cs.WithReplicationLagHook(nil))
+	require.EqualError(t, err, "replication lag hook is nil")
+}
@@ -0,0 +1,162 @@
+package limits_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+const limit = 1 << 20
+
+// zeros is an endless reader of zero bytes, counting the bytes read.
+type zeros struct{ read int64 }
+
+func (z *zeros) Read(p []byte) (int, error) {
+	clear(p)
+	z.read += int64(len(p))
+	return len(p), nil
+}
+
+func newClient(t *testing.T, opts ...m2cs.FileClientOption) (*m2cs.FileClient, []*filestorage.MemoryClient) {
+	var memories []*filestorage.MemoryClient
+	var storages []filestorage.FileStorage
+	for _, label := range []string{"a", "b"} {
+		memory := storagetest.NewMemory(t, label, "box")
+		memories = append(memories, memory)
+		storages = append(storages, memory)
+	}
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storages, opts...)
+	require.NoError(t, err)
+	return client, memories
+}
+
+func assertNotWritten(t *testing.T, memories []*filestorage.MemoryClient) {
+	for _, memory := range memories {
+		exists, err := memory.ExistObject(context.Background(), "box", "key")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	}
+}
+
+func TestFileClient_MaxObjectSize_UnboundedReader(t *testing.T) {
+	client, memories := newClient(t, m2cs.WithMaxObjectSize(limit))
+
+	reader := &zeros{}
+	start := time.Now()
+	err := client.PutObject(context.Background(), "box", "key", reader)
+	assert.ErrorIs(t, err, m2cs.ErrObjectTooLarge)
+	assert.ErrorContains(t, err, "no storage was written")
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Less(t, reader.read, int64(2*limit), "the reader must not be read far beyond the limit")
+	assertNotWritten(t, memories)
+
+	// payloads up to the limit are written
+	require.NoError(t, client.PutObject(context.Background(), "box", "key", bytes.NewReader(make([]byte, limit))))
+}
+
+func TestFileClient_MaxObjectSize_PutOptions(t *testing.T) {
+	client, memories := newClient(t, m2cs.WithMaxObjectSize(limit))
+	put := func(size int, opts m2cs.PutOptions) error {
+		return client.PutObjectWithOptions(context.Background(), "box", "key", bytes.NewReader(make([]byte, size)), opts)
+	}
+
+	// the per-call limit overrides the client one, both ways
+	assert.ErrorIs(t, put(1024, m2cs.PutOptions{MaxSize: 512}), m2cs.ErrObjectTooLarge)
+	assertNotWritten(t, memories)
+	assert.NoError(t, put(2*limit, m2cs.PutOptions{MaxSize: 2 * limit}))
+
+	// a declared size beyond the limit fails before reading
+	reader := &zeros{}
+	err := client.PutObjectWithOptions(context.Background(), "box", "other", reader, m2cs.PutOptions{Size: 2 * limit})
+	assert.ErrorIs(t, err, m2cs.ErrObjectTooLarge)
+	assert.Zero(t, reader.read)
+
+	// without limits, the writes are not bounded
+	unlimited, _ := newClient(t)
+	assert.NoError(t, unlimited.PutObject(context.Background(), "box", "key", bytes.NewReader(make([]byte, 2*limit))))
+}
+
+func TestFileClient_MaxObjectSize_KnownSize(t *testing.T) {
+	client, memories := newClient(t, m2cs.WithMaxObjectSize(limit))
+
+	path := filepath.Join(t.TempDir(), "large")
+	require.NoError(t, os.WriteFile(path, make([]byte, limit+1), 0o644))
+	assert.ErrorIs(t, client.FPutObject(context.Background(), "box", "key", path), m2cs.ErrObjectTooLarge)
+
+	payload := strings.NewReader(strings.Repeat("x", limit+1))
+	err := client.UploadParallel(context.Background(), "box", "key", payload, payload.Size(), m2cs.ParallelOptions{})
+	assert.ErrorIs(t, err, m2cs.ErrObjectTooLarge)
+	assertNotWritten(t, memories)
+
+	_, err = m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, nil, m2cs.WithMaxObjectSize(0))
+	assert.ErrorContains(t, err, "max object size must be positive")
+}
+
+func TestFileClient_ConsumedReader(t *testing.T) {
+	for _, mode := range []m2cs.ReplicationMode{m2cs.SYNC_REPLICATION, m2cs.ASYNC_REPLICATION} {
+		t.Run(mode.String(), func(t *testing.T) {
+			var memories []*filestorage.MemoryClient
+			var storages []filestorage.FileStorage
+			for _, label := range []string{"a", "b", "c"} {
+				memory := storagetest.NewMemory(t, label, "box")
+				memories = append(memories, memory)
+				storages = append(storages, memory)
+			}
+			client, err := m2cs.NewFileClientWithOptions(mode, m2cs.READ_REPLICA_FIRST, storages)
+			require.NoError(t, err)
+
+			reader := strings.NewReader("first half|second half")
+			_, err = reader.Seek(int64(len("first half|")), io.SeekStart)
+			require.NoError(t, err)
+			require.NoError(t, client.PutObject(context.Background(), "box", "key", reader))
+			assert.Eventually(t, func() bool { return client.ReplicationStatus().Backlog == 0 }, time.Second, 10*time.Millisecond)
+
+			// every storage holds the same truncated content
+			for _, memory := range memories {
+				obj, err := memory.GetObject(context.Background(), "box", "key")
+				require.NoError(t, err)
+				var buf bytes.Buffer
+				_, err = buf.ReadFrom(obj)
+				require.NoError(t, err)
+				assert.Equal(t, "second half", buf.String())
+			}
+		})
+	}
+}
+
+func TestFileClient_ExpectNonEmpty(t *testing.T) {
+	client, memories := newClient(t)
+
+	reader := strings.NewReader("content")
+	_, err := reader.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	err = client.PutObjectWithOptions(context.Background(), "box", "key", reader, m2cs.PutOptions{ExpectNonEmpty: true})
+	assert.ErrorIs(t, err, m2cs.ErrUnexpectedPayload)
+	assert.ErrorContains(t, err, "no storage was written")
+	assertNotWritten(t, memories)
+
+	// without the flag, the empty payload is written
+	require.NoError(t, client.PutObjectWithOptions(context.Background(), "box", "key", reader, m2cs.PutOptions{}))
+
+	err = client.PutObjectWithOptions(context.Background(), "box", "other", strings.NewReader("short"), m2cs.PutOptions{Size: 10})
+	assert.ErrorIs(t, err, m2cs.ErrUnexpectedPayload)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	err = client.PutObjectFromURL(context.Background(), "box", "url", server.URL, m2cs.URLOptions{Put: m2cs.PutOptions{ExpectNonEmpty: true}})
+	assert.ErrorIs(t, err, m2cs.ErrUnexpectedPayload)
+}
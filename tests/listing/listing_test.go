@@ -0,0 +1,268 @@
+package listing_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+const objects = 1500
+
+func key(i int) string {
+	return fmt.Sprintf("key-%04d", i)
+}
+
+// server serves the listings of a store box "box" holding the objects key-0000 to key-1499, with
+// the S3 ListObjectsV2 and the Azure List Blobs APIs, and counts the pages requested. It accepts
+// the other requests, e.g. the probes of the connections.
+type server struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	pages int
+}
+
+func newServer(t *testing.T) *server {
+	s := &server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *server) pageCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pages
+}
+
+func (s *server) serve(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch {
+	case query.Has("location"):
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><LocationConstraint>us-east-1</LocationConstraint>`)
+	case query.Get("list-type") == "2":
+		s.countPage()
+		start, _ := strconv.Atoi(query.Get("continuation-token"))
+		end := page(start, query.Get("max-keys"), 1000)
+		var sb strings.Builder
+		sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>box</Name>`)
+		fmt.Fprintf(&sb, "<KeyCount>%d</KeyCount><IsTruncated>%t</IsTruncated>", end-start, end < objects)
+		if end < objects {
+			fmt.Fprintf(&sb, "<NextContinuationToken>%d</NextContinuationToken>", end)
+		}
+		for i := start; i < end; i++ {
+			fmt.Fprintf(&sb, `<Contents><Key>%s</Key><LastModified>2024-01-01T00:00:00.000Z</LastModified><ETag>"etag"</ETag><Size>4</Size></Contents>`, key(i))
+		}
+		sb.WriteString("</ListBucketResult>")
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, sb.String())
+	case query.Get("restype") == "container" && query.Get("comp") == "list":
+		s.countPage()
+		start, _ := strconv.Atoi(query.Get("marker"))
+		end := page(start, query.Get("maxresults"), 5000)
+		var sb strings.Builder
+		sb.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="box"><Blobs>`)
+		for i := start; i < end; i++ {
+			fmt.Fprintf(&sb, `<Blob><Name>%s</Name><Properties><Last-Modified>Mon, 01 Jan 2024 00:00:00 GMT</Last-Modified><Etag>0x1</Etag><Content-Length>4</Content-Length><BlobType>BlockBlob</BlobType></Properties></Blob>`, key(i))
+		}
+		sb.WriteString("</Blobs>")
+		if end < objects {
+			fmt.Fprintf(&sb, "<NextMarker>%d</NextMarker>", end)
+		}
+		sb.WriteString("</EnumerationResults>")
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, sb.String())
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *server) countPage() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages++
+}
+
+// page returns the end of the page starting at start, of size the given one or, when empty, size.
+func page(start int, given string, size int) int {
+	if n, err := strconv.Atoi(given); err == nil && n > 0 {
+		size = n
+	}
+	return min(start+size, objects)
+}
+
+var backends = []struct {
+	name    string
+	connect func(t *testing.T, s *server) filestorage.FileStorage
+}{
+	{"MinIO", func(t *testing.T, s *server) filestorage.FileStorage {
+		opts := options()
+		opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+		client, err := m2cs.NewMinIOConnection(s.URL, opts, nil)
+		require.NoError(t, err)
+		return client
+	}},
+	{"AWS S3", func(t *testing.T, s *server) filestorage.FileStorage {
+		opts := options()
+		opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+		client, err := m2cs.NewS3Connection(s.URL, opts, opts.Region)
+		require.NoError(t, err)
+		return client
+	}},
+	{"Azure Blob", func(t *testing.T, s *server) filestorage.FileStorage {
+		opts := options()
+		opts.ConnectionMethod = m2cs.ConnectWithConnectionString("DefaultEndpointsProtocol=http;AccountName=m2cs;" +
+			"AccountKey=bTJjcy1hY2NvdW50LWtleQ==;BlobEndpoint=" + s.URL + "/m2cs;")
+		client, err := m2cs.NewAzBlobConnection("", opts)
+		require.NoError(t, err)
+		return client
+	}},
+}
+
+func options() m2cs.ConnectionOptions {
+	return m2cs.ConnectionOptions{IsMainInstance: true, ProbeBox: "box", Region: "us-east-1"}
+}
+
+// TestObjects_Break checks that breaking out of an iteration stops the paging of the provider.
+func TestObjects_Break(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			s := newServer(t)
+			storage := b.connect(t, s)
+			iterator, ok := storage.(filestorage.ObjectIterator)
+			require.True(t, ok)
+
+			var keys []string
+			for info, err := range iterator.Objects(context.Background(), "box", filestorage.ListOptions{PageSize: 1000}) {
+				require.NoError(t, err)
+				keys = append(keys, info.Key)
+				if len(keys) == 3 {
+					break
+				}
+			}
+			assert.Equal(t, []string{key(0), key(1), key(2)}, keys)
+			assert.Equal(t, 1, s.pageCount(), "a single page should be fetched")
+		})
+	}
+}
+
+func TestObjects_AllPages(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			s := newServer(t)
+			storage := b.connect(t, s)
+
+			infos, err := storage.(filestorage.ObjectLister).ListObjectsInfo(context.Background(), "box", "")
+			require.NoError(t, err)
+			require.Len(t, infos, objects)
+			assert.Equal(t, key(objects-1), infos[objects-1].Key)
+			assert.Equal(t, int64(4), infos[0].Size)
+
+			pages := s.pageCount()
+			n := 0
+			for _, err := range storage.(filestorage.ObjectIterator).Objects(context.Background(), "box", filestorage.ListOptions{PageSize: 500}) {
+				require.NoError(t, err)
+				n++
+			}
+			assert.Equal(t, objects, n)
+			assert.Equal(t, 3, s.pageCount()-pages, "the pages should hold PageSize objects")
+		})
+	}
+}
+
+func TestFileClient_Objects_Break(t *testing.T) {
+	s := newServer(t)
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, backends[1].connect(t, s))
+
+	n := 0
+	for info, err := range client.Objects(context.Background(), "box", m2cs.ListOptions{}) {
+		require.NoError(t, err)
+		assert.Equal(t, key(n), info.Key)
+		if n++; n == 3 {
+			break
+		}
+	}
+	assert.Equal(t, 1, s.pageCount(), "a single page should be fetched")
+}
+
+func TestFileClient_Objects_Namespace(t *testing.T) {
+	ctx := context.Background()
+	main := storagetest.NewMemory(t, "main", "box")
+	replica := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "replica"}, "box")
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{replica, main}, m2cs.WithKeyPrefix("tenant"), m2cs.WithKeyEncoding(m2cs.BASE64_KEY_ENCODING))
+	require.NoError(t, err)
+
+	for _, k := range []string{"photos/a.jpg", "photos/b.jpg", "photosynthesis.txt", "docs/c.txt"} {
+		require.NoError(t, client.PutObject(ctx, "box", k, strings.NewReader("data")))
+	}
+	require.NoError(t, main.PutObject(ctx, "box", "other/photos/d.jpg", strings.NewReader("data")))
+
+	infos, err := client.ListObjects(ctx, "box", m2cs.ListOptions{Prefix: "photos"})
+	require.NoError(t, err)
+	var keys []string
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+	assert.ElementsMatch(t, []string{"photos/a.jpg", "photos/b.jpg", "photosynthesis.txt"}, keys)
+
+	infos, err = client.ListObjects(ctx, "box", m2cs.ListOptions{Prefix: "photos/"})
+	require.NoError(t, err)
+	assert.Len(t, infos, 2)
+
+	all, err := client.ListObjects(ctx, "box", m2cs.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, all, 4, "the keys outside the namespace should be skipped")
+
+	_, err = client.ListObjects(ctx, "missing", m2cs.ListOptions{})
+	assert.ErrorIs(t, err, filestorage.ErrBoxNotFound)
+}
+
+// downStorage fails its listings.
+type downStorage struct {
+	*filestorage.MemoryClient
+}
+
+func (s downStorage) Objects(ctx context.Context, storeBox string, opts filestorage.ListOptions) iter.Seq2[filestorage.ObjectInfo, error] {
+	return func(yield func(filestorage.ObjectInfo, error) bool) {
+		yield(filestorage.ObjectInfo{}, errors.New("region down"))
+	}
+}
+
+func TestMirroredStorage_Objects(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := storagetest.NewMemory(t, "eu", "box"), storagetest.NewMemory(t, "us", "box")
+	for i := range 5 {
+		require.NoError(t, secondary.PutObject(ctx, "box", key(i), strings.NewReader("data")))
+	}
+
+	// the secondary is listed when the primary fails before yielding any object
+	mirror, err := filestorage.NewMirroredStorage(downStorage{primary}, secondary, filestorage.MirrorOptions{})
+	require.NoError(t, err)
+	n := 0
+	for _, err := range mirror.Objects(ctx, "box", filestorage.ListOptions{}) {
+		require.NoError(t, err)
+		n++
+	}
+	assert.Equal(t, 5, n)
+
+	mirror, err = filestorage.NewMirroredStorage(downStorage{primary}, downStorage{secondary}, filestorage.MirrorOptions{})
+	require.NoError(t, err)
+	_, err = mirror.ListObjectsInfo(ctx, "box", "")
+	assert.EqualError(t, err, "primary: region down; secondary: region down")
+}
@@ -0,0 +1,279 @@
+package loadbalancing_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/internal/loadbalancing"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// fakeClient is a loadbalancing.Client recording the operations it receives.
+type fakeClient struct {
+	name string
+	fail bool
+	log  *callLog
+}
+
+type callLog struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (l *callLog) add(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, name)
+}
+
+func (c *fakeClient) GetObject(_ context.Context, _ string, _ string) (io.ReadCloser, error) {
+	c.log.add(c.name)
+	if c.fail {
+		return nil, errors.New(c.name + " failed")
+	}
+	return io.NopCloser(strings.NewReader(c.name)), nil
+}
+
+func (c *fakeClient) ExistObject(_ context.Context, _ string, _ string) (bool, error) {
+	c.log.add(c.name)
+	if c.fail {
+		return false, errors.New(c.name + " failed")
+	}
+	return true, nil
+}
+
+// operations are the operations run through loadbalancing.Execute, each returning
+// the name of the client that served it.
+var operations = map[string]func(ctx context.Context, lb loadbalancing.LoadBalancer) (string, error){
+	"GetObject": func(ctx context.Context, lb loadbalancing.LoadBalancer) (string, error) {
+		return loadbalancing.Execute(ctx, lb, func(c loadbalancing.Client) (string, error) {
+			obj, err := c.GetObject(ctx, "box", "file")
+			if err != nil {
+				return "", err
+			}
+			defer obj.Close()
+			data, err := io.ReadAll(obj)
+			return string(data), err
+		})
+	},
+	"ExistObject": func(ctx context.Context, lb loadbalancing.LoadBalancer) (string, error) {
+		return loadbalancing.Execute(ctx, lb, func(c loadbalancing.Client) (string, error) {
+			if _, err := c.ExistObject(ctx, "box", "file"); err != nil {
+				return "", err
+			}
+			return c.(*fakeClient).name, nil
+		})
+	},
+}
+
+// newGroups builds the client groups described by names; a name prefixed by "!" fails.
+func newGroups(log *callLog, names ...[]string) []loadbalancing.ClientGroup {
+	var groups []loadbalancing.ClientGroup
+	for _, group := range names {
+		var clients []loadbalancing.Client
+		for _, name := range group {
+			fail := strings.HasPrefix(name, "!")
+			clients = append(clients, &fakeClient{name: strings.TrimPrefix(name, "!"), fail: fail, log: log})
+		}
+		groups = append(groups, loadbalancing.ClientGroup{Clients: clients})
+	}
+	return groups
+}
+
+// TestExecute_Ordering tests that every operation run through Execute follows the ordering
+// and the fallback of the classic and round-robin balancers.
+func TestExecute_Ordering(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy loadbalancing.Strategy
+		groups   [][]string
+		runs     int
+		served   []string
+		calls    []string
+	}{
+		{
+			name:     "classic serves from the first group",
+			strategy: loadbalancing.CLASSIC,
+			groups:   [][]string{{"replica1", "replica2"}, {"main1"}},
+			runs:     2,
+			served:   []string{"replica1", "replica1"},
+			calls:    []string{"replica1", "replica1"},
+		},
+		{
+			name:     "classic falls back to the next group",
+			strategy: loadbalancing.CLASSIC,
+			groups:   [][]string{{"!replica1", "!replica2"}, {"main1"}},
+			runs:     1,
+			served:   []string{"main1"},
+			calls:    []string{"replica1", "replica2", "main1"},
+		},
+		{
+			name:     "round-robin rotates the first group",
+			strategy: loadbalancing.ROUND_ROBIN,
+			groups:   [][]string{{"replica1", "replica2", "replica3"}, {"main1"}},
+			runs:     4,
+			served:   []string{"replica1", "replica2", "replica3", "replica1"},
+			calls:    []string{"replica1", "replica2", "replica3", "replica1"},
+		},
+		{
+			name:     "round-robin skips failed clients",
+			strategy: loadbalancing.ROUND_ROBIN,
+			groups:   [][]string{{"replica1", "!replica2"}, {"main1"}},
+			runs:     2,
+			served:   []string{"replica1", "replica1"},
+			calls:    []string{"replica1", "replica2", "replica1"},
+		},
+		{
+			name:     "round-robin rotates the first non-empty group",
+			strategy: loadbalancing.ROUND_ROBIN,
+			groups:   [][]string{{}, {"main1", "main2", "main3"}},
+			runs:     4,
+			served:   []string{"main1", "main2", "main3", "main1"},
+			calls:    []string{"main1", "main2", "main3", "main1"},
+		},
+		{
+			name:     "round-robin falls back to the next group",
+			strategy: loadbalancing.ROUND_ROBIN,
+			groups:   [][]string{{"!replica1"}, {"main1", "main2"}},
+			runs:     1,
+			served:   []string{"main1"},
+			calls:    []string{"replica1", "main1"},
+		},
+	}
+
+	for opName, op := range operations {
+		for _, tt := range tests {
+			t.Run(opName+"/"+tt.name, func(t *testing.T) {
+				log := &callLog{}
+				lb, err := loadbalancing.Factory{}.NewLoadBalancer(tt.strategy, newGroups(log, tt.groups...))
+				require.NoError(t, err)
+
+				var served []string
+				for i := 0; i < tt.runs; i++ {
+					name, err := op(context.Background(), lb)
+					require.NoError(t, err)
+					served = append(served, name)
+				}
+
+				assert.Equal(t, tt.served, served)
+				assert.Equal(t, tt.calls, log.calls)
+			})
+		}
+	}
+}
+
+// TestExecute_Errors tests that Execute reports every failure when no client succeeds,
+// that it fails without clients and that it stops once the context is done.
+func TestExecute_Errors(t *testing.T) {
+	for _, strategy := range []loadbalancing.Strategy{loadbalancing.CLASSIC, loadbalancing.ROUND_ROBIN} {
+		for opName, op := range operations {
+			t.Run(opName, func(t *testing.T) {
+				log := &callLog{}
+				lb, err := loadbalancing.Factory{}.NewLoadBalancer(strategy, newGroups(log, []string{"!replica1"}, []string{"!main1"}))
+				require.NoError(t, err)
+
+				_, err = op(context.Background(), lb)
+				assert.ErrorContains(t, err, "replica1 failed")
+				assert.ErrorContains(t, err, "main1 failed")
+
+				empty, err := loadbalancing.Factory{}.NewLoadBalancer(strategy, nil)
+				require.NoError(t, err)
+				_, err = op(context.Background(), empty)
+				assert.ErrorIs(t, err, loadbalancing.ErrNoClients)
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				log.calls = nil
+				_, err = op(ctx, lb)
+				assert.ErrorIs(t, err, context.Canceled)
+				assert.Empty(t, log.calls, "no client should be called once the context is done")
+			})
+		}
+	}
+}
+
+// TestApply_AllClientsFail tests that Apply keeps reporting the failure of all clients.
+func TestApply_AllClientsFail(t *testing.T) {
+	for _, strategy := range []loadbalancing.Strategy{loadbalancing.CLASSIC, loadbalancing.ROUND_ROBIN} {
+		lb, err := loadbalancing.Factory{}.NewLoadBalancer(strategy, newGroups(&callLog{}, []string{"!replica1"}))
+		require.NoError(t, err)
+
+		_, err = lb.Apply(context.Background(), "box", "file")
+		assert.ErrorContains(t, err, "all clients failed to get the object")
+	}
+}
+
+// TestNewLoadBalancer_StableOrder tests that permuting the storages given to the balancers, and to
+// the FileClient, yields the same order, sorted by label within the replica and main groups.
+func TestNewLoadBalancer_StableOrder(t *testing.T) {
+	storage := func(label string, main bool) filestorage.FileStorage {
+		return filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: main, Label: label})
+	}
+	storages := []filestorage.FileStorage{
+		storage("replica-b", false), storage("main-b", true), storage("replica-a", false),
+		storage("main-c", true), storage("replica-c", false), storage("main-a", true),
+	}
+	want := []string{"replica-a", "replica-b", "replica-c", "main-a", "main-b", "main-c"}
+
+	labels := func(clients []loadbalancing.Client) []string {
+		var labels []string
+		for _, client := range clients {
+			labels = append(labels, client.(filestorage.FileStorage).GetConnectionProperties().Label)
+		}
+		return labels
+	}
+
+	permutations := [][]int{{0, 1, 2, 3, 4, 5}, {5, 4, 3, 2, 1, 0}, {2, 0, 5, 1, 4, 3}, {3, 5, 1, 4, 0, 2}}
+	for _, permutation := range permutations {
+		permuted := make([]filestorage.FileStorage, len(storages))
+		for i, j := range permutation {
+			permuted[i] = storages[j]
+		}
+
+		for _, strategy := range []m2cs.LoadBalancingStrategy{m2cs.READ_REPLICA_FIRST, m2cs.ROUND_ROBIN} {
+			lb, err := m2cs.NewLoadBalancer(strategy, permuted...)
+			require.NoError(t, err)
+			assert.Equal(t, want, labels(lb.Order()), "%v with permutation %v", strategy, permutation)
+		}
+
+		roundRobin := m2cs.NewRoundRobinLoadBalancer(permuted...)
+		assert.Equal(t, want, labels(roundRobin.Order()))
+		assert.Equal(t, []string{"replica-b", "replica-c", "replica-a", "main-a", "main-b", "main-c"}, labels(roundRobin.Order()))
+
+		fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN, permuted...)
+		assert.Equal(t, want, fileClient.Describe().ReadOrder)
+	}
+}
+
+// TestFileClient_RoundRobinMains tests that a ROUND_ROBIN FileClient without replicas rotates its
+// reads fairly across the main storages, in label order, instead of always reading the first one.
+func TestFileClient_RoundRobinMains(t *testing.T) {
+	var storages []filestorage.FileStorage
+	for _, label := range []string{"minio", "az", "s3"} {
+		storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: label})
+		require.NoError(t, storage.MakeBucket(context.Background(), "box"))
+		require.NoError(t, storage.PutObject(context.Background(), "box", "file", strings.NewReader(label)))
+		storages = append(storages, storage)
+	}
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN, storages...)
+
+	var served []string
+	for i := 0; i < 6; i++ {
+		obj, err := fileClient.GetObject(context.Background(), "box", "file")
+		require.NoError(t, err)
+		data, err := io.ReadAll(obj)
+		require.NoError(t, err)
+		require.NoError(t, obj.Close())
+		served = append(served, string(data))
+	}
+
+	assert.Equal(t, []string{"az", "minio", "s3", "az", "minio", "s3"}, served)
+}
@@ -0,0 +1,244 @@
+package mirrored_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/filestorage/filestoragetest"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+var errRegionDown = errors.New("region unavailable")
+
+// down returns s failing the operations on the keys of these tests, none holding a "/", as if
+// its region were unavailable.
+func down(s filestorage.FileStorage) filestorage.FileStorage {
+	return storagetest.Wrap(s, storagetest.FailMatching("*", errRegionDown))
+}
+
+// secondaryErrors records the failures reported to OnSecondaryError.
+type secondaryErrors struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (s *secondaryErrors) record(op, storeBox, fileName string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *secondaryErrors) get() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]error(nil), s.errs...)
+}
+
+func read(t *testing.T, s filestorage.FileStorage, key string) string {
+	obj, err := s.GetObject(context.Background(), "box", key)
+	require.NoError(t, err)
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func newMirror(t *testing.T, primary, secondary filestorage.FileStorage) (*filestorage.MirroredStorage, *secondaryErrors) {
+	var reported secondaryErrors
+	mirror, err := filestorage.NewMirroredStorage(primary, secondary, filestorage.MirrorOptions{Label: "aws", OnSecondaryError: reported.record})
+	require.NoError(t, err)
+	return mirror, &reported
+}
+
+func newRegions(t *testing.T) (primary, secondary *filestorage.MemoryClient) {
+	return storagetest.NewMemory(t, "eu", "box"), storagetest.NewMemory(t, "us", "box")
+}
+
+func TestMirroredStorage_WritesToBoth(t *testing.T) {
+	primary, secondary := newRegions(t)
+	mirror, reported := newMirror(t, primary, secondary)
+	ctx := context.Background()
+
+	require.NoError(t, mirror.PutObject(ctx, "box", "seekable", strings.NewReader("data")))
+	// a payload that cannot be rewound is written to both as well
+	require.NoError(t, mirror.PutObject(ctx, "box", "stream", io.MultiReader(strings.NewReader("stream"))))
+	require.NoError(t, mirror.PutObjectWithOptions(ctx, "box", "typed", strings.NewReader("{}"), filestorage.PutOptions{ContentType: "application/json"}))
+
+	for _, s := range []filestorage.FileStorage{primary, secondary} {
+		assert.Equal(t, "data", read(t, s, "seekable"))
+		assert.Equal(t, "stream", read(t, s, "stream"))
+		stat, err := s.(filestorage.ObjectStater).StatObject(ctx, "box", "typed")
+		require.NoError(t, err)
+		assert.Equal(t, "application/json", stat.ContentType)
+	}
+
+	require.NoError(t, mirror.RemoveObject(ctx, "box", "seekable"))
+	for _, s := range []filestorage.FileStorage{primary, secondary} {
+		exists, err := s.ExistObject(ctx, "box", "seekable")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	}
+	assert.Empty(t, reported.get())
+}
+
+func TestMirroredStorage_ReadFallback(t *testing.T) {
+	primary, secondary := newRegions(t)
+	written, _ := newMirror(t, primary, secondary)
+	ctx := context.Background()
+	require.NoError(t, written.PutObject(ctx, "box", "key", strings.NewReader("data")))
+
+	mirror, _ := newMirror(t, down(primary), secondary)
+	assert.Equal(t, "data", read(t, mirror, "key"))
+	exists, err := mirror.ExistObject(ctx, "box", "key")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	stat, err := mirror.StatObject(ctx, "box", "key")
+	require.NoError(t, err)
+	assert.Equal(t, "key", stat.Key)
+
+	// an object missing from both is reported missing, with the errors of both
+	_, err = mirror.GetObject(ctx, "box", "missing")
+	assert.ErrorIs(t, err, errRegionDown)
+	assert.ErrorIs(t, err, filestorage.ErrObjectNotFound)
+}
+
+func TestMirroredStorage_MissingOnPrimary(t *testing.T) {
+	primary, secondary := newRegions(t)
+	mirror, _ := newMirror(t, primary, secondary)
+	ctx := context.Background()
+
+	// an object left on the secondary, e.g. by a removal that failed on it, is not served
+	require.NoError(t, secondary.PutObject(ctx, "box", "removed", strings.NewReader("stale")))
+	_, err := mirror.GetObject(ctx, "box", "removed")
+	assert.ErrorIs(t, err, filestorage.ErrObjectNotFound)
+}
+
+func TestMirroredStorage_SecondaryFailure(t *testing.T) {
+	primary, secondary := newRegions(t)
+	mirror, reported := newMirror(t, primary, down(secondary))
+	ctx := context.Background()
+
+	require.NoError(t, mirror.PutObject(ctx, "box", "key", strings.NewReader("data")))
+	assert.Equal(t, "data", read(t, primary, "key"))
+	require.NoError(t, mirror.RemoveObject(ctx, "box", "key"))
+
+	errs := reported.get()
+	require.Len(t, errs, 2)
+	for _, err := range errs {
+		assert.ErrorIs(t, err, errRegionDown)
+	}
+}
+
+func TestMirroredStorage_PrimaryFailure(t *testing.T) {
+	primary, secondary := newRegions(t)
+	mirror, reported := newMirror(t, down(primary), secondary)
+	ctx := context.Background()
+
+	err := mirror.PutObject(ctx, "box", "key", strings.NewReader("data"))
+	assert.ErrorIs(t, err, errRegionDown)
+	exists, err := secondary.ExistObject(ctx, "box", "key")
+	require.NoError(t, err)
+	assert.False(t, exists, "the secondary is not written when the primary fails")
+
+	assert.ErrorIs(t, mirror.RemoveObject(ctx, "box", "key"), errRegionDown)
+	assert.Empty(t, reported.get())
+}
+
+func TestMirroredStorage_Properties(t *testing.T) {
+	primary, secondary := newRegions(t)
+	mirror, _ := newMirror(t, primary, secondary)
+	properties := mirror.GetConnectionProperties()
+	assert.Equal(t, "aws", properties.Label)
+	assert.True(t, properties.IsMainInstance)
+
+	unlabelled, err := filestorage.NewMirroredStorage(storagetest.NewMemory(t, "eu"), storagetest.NewMemory(t, "us"), filestorage.MirrorOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "eu", unlabelled.GetConnectionProperties().Label)
+}
+
+func TestNewMirroredStorage_Validation(t *testing.T) {
+	storage := func(properties common.ConnectionProperties) filestorage.FileStorage {
+		return filestorage.NewMemoryClient(properties)
+	}
+	main := common.ConnectionProperties{Label: "eu", IsMainInstance: true}
+	tests := []struct {
+		name      string
+		secondary filestorage.FileStorage
+		err       string
+	}{
+		{"nil", nil, "failed to create mirrored storage: secondary storage is nil"},
+		{"replica", storage(common.ConnectionProperties{Label: "us"}),
+			`failed to create mirrored storage: IsMainInstance differs between "eu" and "us"`},
+		{"compression", storage(common.ConnectionProperties{Label: "us", IsMainInstance: true, SaveCompress: common.GZIP_COMPRESSION}),
+			`failed to create mirrored storage: compression differs between "eu" (NO_COMPRESSION) and "us" (GZIP_COMPRESSION)`},
+		{"encryption", storage(common.ConnectionProperties{Label: "us", IsMainInstance: true, SaveEncrypt: common.AES256_ENCRYPTION, EncryptKey: "key"}),
+			`failed to create mirrored storage: encryption differs between "eu" (NO_ENCRYPTION) and "us" (AES256_ENCRYPTION)`},
+		{"rules", storage(common.ConnectionProperties{Label: "us", IsMainInstance: true, TransformRules: []common.TransformRule{{KeyGlob: "*.log", Compress: common.GZIP_COMPRESSION}}}),
+			`failed to create mirrored storage: transform rules differ between "eu" and "us"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := filestorage.NewMirroredStorage(storage(main), tt.secondary, filestorage.MirrorOptions{})
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+
+	_, err := filestorage.NewMirroredStorage(nil, storage(main), filestorage.MirrorOptions{})
+	assert.EqualError(t, err, "failed to create mirrored storage: primary storage is nil")
+}
+
+func TestMirroredStorage_FileClient(t *testing.T) {
+	primary, secondary := newRegions(t)
+	mirror, _ := newMirror(t, primary, secondary)
+	other := storagetest.NewMemory(t, "azure", "box")
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, mirror, other)
+	ctx := context.Background()
+
+	require.NoError(t, client.PutObject(ctx, "box", "key", strings.NewReader("data")))
+	for _, s := range []filestorage.FileStorage{primary, secondary, other} {
+		assert.Equal(t, "data", read(t, s, "key"))
+	}
+
+	// the client sees a single storage, still serving while a region is down
+	degraded, _ := newMirror(t, down(primary), secondary)
+	client = m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, degraded, down(other))
+	obj, err := client.GetObject(ctx, "box", "key")
+	require.NoError(t, err)
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
+
+// mirroredBoxes is a MirroredStorage creating its store boxes on both storages.
+type mirroredBoxes struct {
+	*filestorage.MirroredStorage
+	primary, secondary *filestorage.MemoryClient
+}
+
+func (m mirroredBoxes) MakeBucket(ctx context.Context, name string) error {
+	if err := m.primary.MakeBucket(ctx, name); err != nil {
+		return err
+	}
+	return m.secondary.MakeBucket(ctx, name)
+}
+
+func TestMirroredStorage_Conformance(t *testing.T) {
+	filestoragetest.RunConformance(t, func(t *testing.T) filestorage.FileStorage {
+		primary := filestorage.NewMemoryClient(common.ConnectionProperties{Label: "eu", IsMainInstance: true})
+		secondary := filestorage.NewMemoryClient(common.ConnectionProperties{Label: "us", IsMainInstance: true})
+		mirror, err := filestorage.NewMirroredStorage(primary, secondary, filestorage.MirrorOptions{})
+		require.NoError(t, err)
+		return mirroredBoxes{MirroredStorage: mirror, primary: primary, secondary: secondary}
+	})
+}
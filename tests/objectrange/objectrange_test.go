@@ -0,0 +1,54 @@
+package objectrange_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+// TestMinioClient_GetObjectRange tests the ranges read from a MinIO storage, a length of 0
+// reading the object to its end, from its first byte as well.
+func TestMinioClient_GetObjectRange(t *testing.T) {
+	s := storagetest.NewMinioServer(t)
+	client, err := m2cs.NewMinIOConnection(s.URL, m2cs.ConnectionOptions{
+		ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+		IsMainInstance:   true,
+		ProbeBox:         "box",
+		Region:           "us-east-1",
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, client.PutObject(context.Background(), "box", "key", strings.NewReader("0123456789")))
+
+	tests := []struct {
+		name           string
+		offset, length int64
+		want           string
+	}{
+		{name: "whole object", offset: 0, length: 0, want: "0123456789"},
+		{name: "to the end", offset: 4, length: 0, want: "456789"},
+		{name: "first bytes", offset: 0, length: 3, want: "012"},
+		{name: "middle", offset: 2, length: 4, want: "2345"},
+		{name: "past the end", offset: 8, length: 10, want: "89"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := client.GetObjectRange(context.Background(), "box", "key", tt.offset, tt.length)
+			require.NoError(t, err)
+			defer reader.Close()
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(data))
+		})
+	}
+
+	_, err = client.GetObjectRange(context.Background(), "box", "missing", 0, 0)
+	assert.ErrorIs(t, err, filestorage.ErrObjectNotFound)
+}
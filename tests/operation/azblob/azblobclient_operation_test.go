@@ -1,15 +1,18 @@
 package azblob
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/azurite"
 	common "github.com/tizianocitro/m2cs/pkg"
 	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/filestorage/filestoragetest"
 	"io"
 	"log"
 	"os"
@@ -197,6 +200,60 @@ func TestAzBlobClient_GetObject_Success(t *testing.T) {
 	assert.Equal(t, "test", string(buf), "expected object content to be 'test'")
 }
 
+// TestAzBlobClient_GetObjectWithInfo_Success verifies that GetObjectWithInfo reports the
+// size of the content read, for a plain blob and for a gzip-stored one.
+func TestAzBlobClient_GetObjectWithInfo_Success(t *testing.T) {
+	content := strings.Repeat("test ", 100)
+
+	for name, properties := range map[string]common.ConnectionProperties{
+		"plain": {},
+		"gzip":  {SaveCompress: common.GZIP_COMPRESSION},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, err := filestorage.NewAzBlobClient(azureBlobClient, properties)
+			require.NoError(t, err)
+
+			key := "info-" + name + ".txt"
+			require.NoError(t, client.PutObjectWithOptions(context.TODO(), "test-container", key, strings.NewReader(content),
+				filestorage.PutOptions{ContentType: "text/plain"}))
+
+			reader, stat, err := client.GetObjectWithInfo(context.TODO(), "test-container", key)
+			require.NoError(t, err)
+			defer reader.Close()
+
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, content, string(data))
+			assert.Equal(t, int64(len(content)), stat.Size)
+			assert.Equal(t, "text/plain", stat.ContentType)
+			assert.NotEmpty(t, stat.ETag)
+			assert.False(t, stat.LastModified.IsZero())
+		})
+	}
+}
+
+// TestAzBlobClient_UploadParallel_Success uploads a 12 MB blob in blocks of 4 MB and verifies
+// that the three blocks are committed with the uploaded content.
+func TestAzBlobClient_UploadParallel_Success(t *testing.T) {
+	content := bytes.Repeat([]byte("m2cs"), 3<<20)
+
+	err := testClient.UploadParallel(context.TODO(), "test-container", "parallel.bin", bytes.NewReader(content), int64(len(content)),
+		filestorage.ParallelOptions{PartSize: 4 << 20, Concurrency: 3})
+	require.NoError(t, err)
+
+	blocks, err := azureBlobClient.ServiceClient().NewContainerClient("test-container").NewBlockBlobClient("parallel.bin").
+		GetBlockList(context.TODO(), blockblob.BlockListTypeCommitted, nil)
+	require.NoError(t, err)
+	assert.Len(t, blocks.CommittedBlocks, 3)
+
+	reader, err := testClient.GetObject(context.TODO(), "test-container", "parallel.bin")
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, data), "downloaded content differs from the uploaded one")
+}
+
 // TestAzBlobClient_RemoveObject_AzureError verifies that the RemoveObject method
 // of the AzBlobClient correctly returns errors from the original azure blob client.
 // This test uses the scenario where the container does not exist.
@@ -218,6 +275,37 @@ func TestAzBlobClient_RemoveObject_Success(t *testing.T) {
 	assert.ErrorContains(t, err, "BlobNotFound")
 }
 
+// TestAzBlobClient_SetObjectTier_Success verifies that SetObjectTier changes the access
+// tier recorded by Azure Blob Storage, and that StatObject reports it.
+func TestAzBlobClient_SetObjectTier_Success(t *testing.T) {
+	err := testClient.PutObject(context.TODO(), "test-container", "test-tier-object", strings.NewReader("test"))
+	require.NoError(t, err, "expected no error when uploading an object")
+
+	err = testClient.SetObjectTier(context.TODO(), "test-container", "test-tier-object", common.COOL_TIER)
+	require.NoError(t, err, "expected no error when setting the tier")
+
+	props, err := azureBlobClient.ServiceClient().NewContainerClient("test-container").
+		NewBlobClient("test-tier-object").GetProperties(context.TODO(), nil)
+	require.NoError(t, err)
+	require.NotNil(t, props.AccessTier)
+	assert.Equal(t, "Cool", *props.AccessTier, "expected the blob to be in the cool tier")
+
+	stat, err := testClient.StatObject(context.TODO(), "test-container", "test-tier-object")
+	require.NoError(t, err)
+	assert.Equal(t, common.COOL_TIER, stat.TierStatus.Tier)
+	assert.Equal(t, "Cool", stat.TierStatus.StorageClass)
+	assert.Equal(t, int64(len("test")), stat.Size)
+}
+
+// TestAzBlobClient_SetObjectTier_AzureError verifies that SetObjectTier returns the errors
+// of the original azure blob client. This test uses the scenario where the blob does not exist.
+func TestAzBlobClient_SetObjectTier_AzureError(t *testing.T) {
+	err := testClient.SetObjectTier(context.TODO(), "test-container", "non-existing-object", common.COOL_TIER)
+
+	require.Error(t, err, "expected error for non-existing blob, got nil")
+	assert.ErrorContains(t, err, "BlobNotFound")
+}
+
 // TestAzBlobClient_DeleteContainer_AzureError verifies that the DeleteContainer method
 // of the AzBlobClient correctly returns errors from the original azure blob client.
 // This test uses the scenario where the container does not exist.
@@ -289,3 +377,10 @@ func runAndPopulateAzuriteContainer(ctx context.Context) {
 		log.Fatalf("failed to create MinIO client: %s", err.Error())
 	}
 }
+
+// TestAzBlobClient_Conformance runs the FileStorage conformance suite against Azure Blob Storage.
+func TestAzBlobClient_Conformance(t *testing.T) {
+	filestoragetest.RunConformance(t, func(t *testing.T) filestorage.FileStorage {
+		return testClient
+	})
+}
@@ -3,16 +3,24 @@ package fileclient
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
@@ -31,6 +39,7 @@ import (
 	"github.com/tizianocitro/m2cs"
 	common "github.com/tizianocitro/m2cs/pkg"
 	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
 )
 
 var (
@@ -258,6 +267,7 @@ func TestFileClient_PutSYNC_NoMainInstance(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.NO_COMPRESSION,
 			IsMainInstance:   false,
 		})
@@ -333,8 +343,8 @@ func TestFileClient_PutAsync_FirstSuccessThenFanOut(t *testing.T) {
 		t.Fatalf("failed to create s3 bucket for async first success then fan-out test: %v", err)
 	}
 
-	slow1 := slowClient{inner: az, delay: 1500 * time.Millisecond}
-	slow2 := slowClient{inner: s3w, delay: 1500 * time.Millisecond}
+	slow1 := storagetest.Wrap(az, storagetest.Latency(1500*time.Millisecond))
+	slow2 := storagetest.Wrap(s3w, storagetest.Latency(1500*time.Millisecond))
 
 	fileClient := m2cs.NewFileClient(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, fast, slow1, slow2)
 	if fileClient == nil {
@@ -404,6 +414,82 @@ func TestFileClient_PutAsync_AllFail(t *testing.T) {
 	assert.ErrorContains(t, err, "[async] PutObject failed on all main storages", "PutObject should fail on all clients because the bucket does not exist")
 }
 
+// TestFileClient_PutAsync_FirstFails tests the PutObject method of the FileClient
+// with ASYNC replication mode when the first main storage fails and the second succeeds,
+// ensuring that the background fan-out targets exactly the other two storages, once each.
+func TestFileClient_PutAsync_FirstFails(t *testing.T) {
+	ctx := context.Background()
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+		},
+		&minio.Options{Secure: false},
+	)
+	if err != nil {
+		t.Fatalf("fail to create minio wrapper: %v", err)
+	}
+
+	azWrap, err := m2cs.NewAzBlobConnection(
+		azuriteEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
+			IsMainInstance:   true,
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to create azurite wrapper: %v", err)
+	}
+
+	s3Wrap, err := m2cs.NewS3Connection(
+		s3Endpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+			IsMainInstance:   true,
+		},
+		"",
+	)
+	if err != nil {
+		t.Fatalf("fail to create s3 wrapper: %v", err)
+	}
+
+	// the bucket is missing on minio only, so that the first main storage fails
+	err = azWrap.CreateContainer(ctx, "boxasyncff")
+	if err != nil {
+		t.Fatalf("failed to create azurite container for async first fails test: %v", err)
+	}
+	err = s3Wrap.CreateBucket(ctx, "boxasyncff")
+	if err != nil {
+		t.Fatalf("failed to create s3 bucket for async first fails test: %v", err)
+	}
+
+	recorder := storagetest.NewRecorder()
+	minioSpy := storagetest.Wrap(minioWrap, recorder.Decorator("minio"))
+	azSpy := storagetest.Wrap(azWrap, recorder.Decorator("azurite"))
+	s3Spy := storagetest.Wrap(s3Wrap, recorder.Decorator("s3"))
+
+	fileClient := m2cs.NewFileClient(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, minioSpy, azSpy, s3Spy)
+
+	err = fileClient.PutObject(ctx, "boxasyncff", "file", strings.NewReader("test first fails"))
+	assert.NoError(t, err, "PutObject should succeed on the second main storage")
+
+	// minio: one failed foreground write plus one background write;
+	// azurite: the successful foreground write only; s3: one background write
+	assert.Eventually(t, func() bool {
+		return recorder.Count("minio", storagetest.PUT_OBJECT) == 2 && recorder.Count("s3", storagetest.PUT_OBJECT) == 1
+	}, 5*time.Second, 50*time.Millisecond, "background writes should reach minio and s3")
+
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, 2, recorder.Count("minio", storagetest.PUT_OBJECT), "minio should receive exactly one background write")
+	assert.Equal(t, 1, recorder.Count("azurite", storagetest.PUT_OBJECT), "azurite should not receive background writes")
+	assert.Equal(t, 1, recorder.Count("s3", storagetest.PUT_OBJECT), "s3 should receive exactly one background write")
+
+	checkResult := checkObjectExistenceInClients(t, ctx, "boxasyncff", "file", "test first fails", azWrap, s3Wrap)
+	assert.Equal(t, ExistsInAllWithCorrectContent, checkResult, "Object should exist in azurite and s3 with correct content")
+}
+
 // TestFileClient_Sync_ZeroLenghtObject tests the PutObject method of the FileClient
 // with SYNC replication mode, ensuring that zero-length objects are handled correctly
 func TestFileClient_PutSync_ZeroLenghtObject(t *testing.T) {
@@ -439,6 +525,83 @@ func TestFileClient_PutSync_ZeroLenghtObject(t *testing.T) {
 
 	err = fileClient.PutObject(ctx, "test-box", "putTest", bytes.NewReader(nil))
 	assert.NoError(t, err, "PutObject should succeed on all clients")
+
+	transforms := map[string]m2cs.ConnectionOptions{
+		"plain":      {SaveEncrypt: m2cs.NO_ENCRYPTION, SaveCompress: m2cs.NO_COMPRESSION},
+		"gzip + aes": {SaveEncrypt: m2cs.AES256_ENCRYPTION, EncryptKey: "m2cs", AllowWeakKeys: true, SaveCompress: m2cs.GZIP_COMPRESSION},
+	}
+	for name, transform := range transforms {
+		t.Run(name, func(t *testing.T) {
+			minioOpts, azOpts, s3Opts := transform, transform, transform
+			minioOpts.ConnectionMethod = m2cs.ConnectWithCredentials(minioUser, minioPassword)
+			azOpts.ConnectionMethod = m2cs.ConnectWithConnectionString(azuriteConnectionString)
+			s3Opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+			minioOpts.IsMainInstance, azOpts.IsMainInstance, s3Opts.IsMainInstance = true, true, true
+
+			minioWrap, err := m2cs.NewMinIOConnection(minioEndpoint, minioOpts, &minio.Options{})
+			if err != nil {
+				t.Fatalf("failed to create minio wrapper: %v", err)
+			}
+			azWrap, err := m2cs.NewAzBlobConnection(azuriteEndpoint, azOpts)
+			if err != nil {
+				t.Fatalf("failed to create azurite wrapper: %v", err)
+			}
+			s3Wrap, err := m2cs.NewS3Connection(s3Endpoint, s3Opts, "")
+			if err != nil {
+				t.Fatalf("failed to create s3 wrapper: %v", err)
+			}
+			storages := []filestorage.FileStorage{minioWrap, azWrap, s3Wrap}
+
+			// directory markers end with "/", which the strict name validation rejects
+			fileClient, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storages,
+				m2cs.WithNameValidation(m2cs.LENIENT_NAME_VALIDATION))
+			if err != nil {
+				t.Fatalf("failed to create the file client: %v", err)
+			}
+
+			// an empty payload of known size, and one whose size is only known once read
+			assert.NoError(t, fileClient.PutObject(ctx, "test-box", "empty", bytes.NewReader(nil)))
+			assert.NoError(t, fileClient.PutObjectWithOptions(ctx, "test-box", "empty-stream", io.MultiReader(), m2cs.PutOptions{}))
+			assert.NoError(t, fileClient.PutObject(ctx, "test-box", "zero/", bytes.NewReader(nil)))
+			assert.NoError(t, fileClient.PutObject(ctx, "test-box", "zero/child", strings.NewReader("child")))
+			assert.NoError(t, fileClient.PutObject(ctx, "test-box", "implied/child", strings.NewReader("child")))
+
+			for _, storage := range storages {
+				for _, key := range []string{"empty", "empty-stream", "zero/"} {
+					obj, err := storage.GetObject(ctx, "test-box", key)
+					if !assert.NoError(t, err, "GetObject(%q) on %T", key, storage) {
+						continue
+					}
+					data, err := io.ReadAll(obj)
+					_ = obj.Close()
+					assert.NoError(t, err)
+					assert.Empty(t, data, "%q should be read empty from %T", key, storage)
+
+					exists, err := storage.ExistObject(ctx, "test-box", key)
+					assert.NoError(t, err)
+					assert.True(t, exists, "%q should exist on %T", key, storage)
+					stat, err := storage.(filestorage.ObjectStater).StatObject(ctx, "test-box", key)
+					assert.NoError(t, err, "StatObject(%q) on %T", key, storage)
+					assert.Zero(t, stat.Size, "%q should be stored empty on %T", key, storage)
+				}
+
+				// an implied directory is not an object, for ExistObject as for StatObject
+				exists, err := storage.ExistObject(ctx, "test-box", "implied/")
+				assert.NoError(t, err)
+				assert.False(t, exists)
+				_, err = storage.(filestorage.ObjectStater).StatObject(ctx, "test-box", "implied/")
+				assert.ErrorIs(t, err, filestorage.ErrObjectNotFound)
+			}
+
+			infos, err := fileClient.ListObjects(ctx, "test-box", m2cs.ListOptions{Prefix: "zero/"})
+			assert.NoError(t, err)
+			var keys []string
+			for _, info := range infos {
+				keys = append(keys, info.Key)
+			}
+			assert.ElementsMatch(t, []string{"zero/", "zero/child"}, keys, "the marker is listed with the keys it prefixes")
+		})
+	}
 }
 
 // TestFileClient_Sync_BigSizeObject tests the PutObject method of the FileClient
@@ -469,6 +632,7 @@ func TestFileClient_PutSync_BigSizeObject(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.NO_COMPRESSION,
 			IsMainInstance:   true,
 		},
@@ -558,6 +722,7 @@ func TestFileClient_GetClassic_ReplicaSuccess(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.NO_COMPRESSION,
 			IsMainInstance:   false,
 		},
@@ -582,6 +747,7 @@ func TestFileClient_GetClassic_ReplicaSuccess(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.GZIP_COMPRESSION,
 			IsMainInstance:   true,
 		}, "")
@@ -589,24 +755,12 @@ func TestFileClient_GetClassic_ReplicaSuccess(t *testing.T) {
 		t.Fatalf("failed to create s3 wrapper: %v", err)
 	}
 
-	successSequence := &[]string{}
+	recorder := storagetest.NewRecorder()
 
 	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
-		&spyClient{
-			inner:      minioWrap,
-			iD:         "minio",
-			successSeq: successSequence,
-		},
-		&spyClient{
-			inner:      azWrap,
-			iD:         "azurite",
-			successSeq: successSequence,
-		},
-		&spyClient{
-			inner:      s3Wrap,
-			iD:         "s3",
-			successSeq: successSequence,
-		})
+		storagetest.Wrap(minioWrap, recorder.Decorator("minio")),
+		storagetest.Wrap(azWrap, recorder.Decorator("azurite")),
+		storagetest.Wrap(s3Wrap, recorder.Decorator("s3")))
 	if fileClient == nil {
 		t.Fatalf("Error in configuraiton test: fileClient is nil")
 	}
@@ -621,10 +775,11 @@ func TestFileClient_GetClassic_ReplicaSuccess(t *testing.T) {
 	assert.NoError(t, err, "Reading from the reader should not produce an error")
 
 	// check that only one client was accessed
-	if successSequence == nil || len(*successSequence) != 1 || (*successSequence)[0] == "s3" {
-		t.Errorf("Expected exactly one non-main client to be accessed, but got: %v", successSequence)
+	successes := recorder.Successes(storagetest.GET_OBJECT)
+	if len(successes) != 1 || successes[0] == "s3" {
+		t.Errorf("Expected exactly one non-main client to be accessed, but got: %v", successes)
 	} else {
-		t.Logf("GetObject succeeded on non-main client: %s", (*successSequence)[0])
+		t.Logf("GetObject succeeded on non-main client: %s", successes[0])
 	}
 }
 
@@ -649,6 +804,7 @@ func TestFileClient_GetClassic_MainSuccess(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.NO_COMPRESSION,
 			IsMainInstance:   false,
 		},
@@ -673,28 +829,17 @@ func TestFileClient_GetClassic_MainSuccess(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.GZIP_COMPRESSION,
 			IsMainInstance:   true,
 		}, "")
 
-	successSequence := &[]string{}
+	recorder := storagetest.NewRecorder()
 
 	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
-		&spyClient{
-			inner:      minioWrap,
-			iD:         "minio",
-			successSeq: successSequence,
-		},
-		&spyClient{
-			inner:      azWrap,
-			iD:         "azurite",
-			successSeq: successSequence,
-		},
-		&spyClient{
-			inner:      s3Wrap,
-			iD:         "s3",
-			successSeq: successSequence,
-		})
+		storagetest.Wrap(minioWrap, recorder.Decorator("minio")),
+		storagetest.Wrap(azWrap, recorder.Decorator("azurite")),
+		storagetest.Wrap(s3Wrap, recorder.Decorator("s3")))
 	if fileClient == nil {
 		t.Fatalf("Error in configuraiton test: fileClient is nil")
 	}
@@ -709,10 +854,11 @@ func TestFileClient_GetClassic_MainSuccess(t *testing.T) {
 	assert.NoError(t, err, "Reading from the reader should not produce an error")
 
 	// check that only one client was accessed and it was the main client (s3)
-	if successSequence == nil || len(*successSequence) != 1 || (*successSequence)[0] != "s3" {
-		t.Errorf("Expected exactly the main client to be accessed, but got: %v", successSequence)
+	successes := recorder.Successes(storagetest.GET_OBJECT)
+	if len(successes) != 1 || successes[0] != "s3" {
+		t.Errorf("Expected exactly the main client to be accessed, but got: %v", successes)
 	} else {
-		t.Logf("GetObject succeeded on main client: %s", (*successSequence)[0])
+		t.Logf("GetObject succeeded on main client: %s", successes[0])
 	}
 }
 
@@ -727,6 +873,7 @@ func TestFileClient_GetClassic_AllClientFail(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.NO_COMPRESSION,
 			IsMainInstance:   false,
 		},
@@ -751,6 +898,7 @@ func TestFileClient_GetClassic_AllClientFail(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.GZIP_COMPRESSION,
 			IsMainInstance:   true,
 		}, "")
@@ -758,24 +906,12 @@ func TestFileClient_GetClassic_AllClientFail(t *testing.T) {
 		t.Fatalf("failed to create s3 wrapper: %v", err)
 	}
 
-	successSequence := &[]string{}
+	recorder := storagetest.NewRecorder()
 
 	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
-		&spyClient{
-			inner:      minioWrap,
-			iD:         "minio",
-			successSeq: successSequence,
-		},
-		&spyClient{
-			inner:      azWrap,
-			iD:         "azurite",
-			successSeq: successSequence,
-		},
-		&spyClient{
-			inner:      s3Wrap,
-			iD:         "s3",
-			successSeq: successSequence,
-		})
+		storagetest.Wrap(minioWrap, recorder.Decorator("minio")),
+		storagetest.Wrap(azWrap, recorder.Decorator("azurite")),
+		storagetest.Wrap(s3Wrap, recorder.Decorator("s3")))
 	if fileClient == nil {
 		t.Fatalf("Error in configuraiton test: fileClient is nil")
 	}
@@ -823,6 +959,7 @@ func TestFileClient_GetRoundRobin_ReplicaSuccess(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.NO_COMPRESSION,
 			IsMainInstance:   false,
 		},
@@ -847,6 +984,7 @@ func TestFileClient_GetRoundRobin_ReplicaSuccess(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.GZIP_COMPRESSION,
 			IsMainInstance:   false,
 		}, "")
@@ -854,24 +992,12 @@ func TestFileClient_GetRoundRobin_ReplicaSuccess(t *testing.T) {
 		t.Fatalf("failed to create s3 wrapper: %v", err)
 	}
 
-	successSequence := &[]string{}
+	recorder := storagetest.NewRecorder()
 
 	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN,
-		&spyClient{
-			inner:      minioWrap,
-			iD:         "minio",
-			successSeq: successSequence,
-		},
-		&spyClient{
-			inner:      azWrap,
-			iD:         "azurite",
-			successSeq: successSequence,
-		},
-		&spyClient{
-			inner:      s3Wrap,
-			iD:         "s3",
-			successSeq: successSequence,
-		})
+		storagetest.Wrap(minioWrap, recorder.Decorator("minio")),
+		storagetest.Wrap(azWrap, recorder.Decorator("azurite")),
+		storagetest.Wrap(s3Wrap, recorder.Decorator("s3")))
 	if fileClient == nil {
 		t.Fatalf("Error in configuraiton test: fileClient is nil")
 	}
@@ -892,8 +1018,9 @@ func TestFileClient_GetRoundRobin_ReplicaSuccess(t *testing.T) {
 	}
 
 	want := []string{"minio", "azurite", "s3", "minio", "azurite", "s3"}
-	if !reflect.DeepEqual(*successSequence, want) {
-		t.Fatalf("RoundRobin sequence mismatch: got=%v want=%v", *successSequence, want)
+	successes := recorder.Successes(storagetest.GET_OBJECT)
+	if !reflect.DeepEqual(successes, want) {
+		t.Fatalf("RoundRobin sequence mismatch: got=%v want=%v", successes, want)
 	}
 }
 
@@ -941,30 +1068,19 @@ func TestFileClient_GetRoundRobin_MainSuccess(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.GZIP_COMPRESSION,
 			IsMainInstance:   true,
 		}, "")
 
-	successSequence := &[]string{}
+	recorder := storagetest.NewRecorder()
 
 	fileClient := m2cs.NewFileClient(
 		m2cs.SYNC_REPLICATION,
 		m2cs.ROUND_ROBIN,
-		&spyClient{
-			inner:      minioWrap,
-			iD:         "minio",
-			successSeq: successSequence,
-		},
-		&spyClient{
-			inner:      azWrap,
-			iD:         "azurite",
-			successSeq: successSequence,
-		},
-		&spyClient{
-			inner:      s3Wrap,
-			iD:         "s3",
-			successSeq: successSequence,
-		},
+		storagetest.Wrap(minioWrap, recorder.Decorator("minio")),
+		storagetest.Wrap(azWrap, recorder.Decorator("azurite")),
+		storagetest.Wrap(s3Wrap, recorder.Decorator("s3")),
 	)
 	if fileClient == nil {
 		t.Fatalf("Error in configuration test: fileClient is nil")
@@ -979,10 +1095,11 @@ func TestFileClient_GetRoundRobin_MainSuccess(t *testing.T) {
 
 	defer reader.Close()
 
-	if len(*successSequence) != 1 || (*successSequence)[0] != "s3" {
-		t.Errorf("expected main client to be accessed after replica failures; got: %v", *successSequence)
+	successes := recorder.Successes(storagetest.GET_OBJECT)
+	if len(successes) != 1 || successes[0] != "s3" {
+		t.Errorf("expected main client to be accessed after replica failures; got: %v", successes)
 	} else {
-		t.Logf("GetObject succeeded on main client: %s", (*successSequence)[0])
+		t.Logf("GetObject succeeded on main client: %s", successes[0])
 	}
 }
 
@@ -997,6 +1114,7 @@ func TestFileClient_GetRoundRobin_AllClientFail(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.NO_COMPRESSION,
 			IsMainInstance:   false,
 		},
@@ -1021,6 +1139,7 @@ func TestFileClient_GetRoundRobin_AllClientFail(t *testing.T) {
 			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
 			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
 			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
 			SaveCompress:     m2cs.GZIP_COMPRESSION,
 			IsMainInstance:   true,
 		}, "")
@@ -1028,24 +1147,12 @@ func TestFileClient_GetRoundRobin_AllClientFail(t *testing.T) {
 		t.Fatalf("failed to create s3 wrapper: %v", err)
 	}
 
-	successSequence := &[]string{}
+	recorder := storagetest.NewRecorder()
 
 	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN,
-		&spyClient{
-			inner:      minioWrap,
-			iD:         "minio",
-			successSeq: successSequence,
-		},
-		&spyClient{
-			inner:      azWrap,
-			iD:         "azurite",
-			successSeq: successSequence,
-		},
-		&spyClient{
-			inner:      s3Wrap,
-			iD:         "s3",
-			successSeq: successSequence,
-		})
+		storagetest.Wrap(minioWrap, recorder.Decorator("minio")),
+		storagetest.Wrap(azWrap, recorder.Decorator("azurite")),
+		storagetest.Wrap(s3Wrap, recorder.Decorator("s3")))
 	if fileClient == nil {
 		t.Fatalf("Error in configuraiton test: fileClient is nil")
 	}
@@ -1056,179 +1163,2362 @@ func TestFileClient_GetRoundRobin_AllClientFail(t *testing.T) {
 }
 
 //==============================================================================
-// Utility functions and structs for setting up test
+// In-memory reader tests
 //==============================================================================
 
-// runAndPopulateAzuriteContainer create an Azurite container
-// starts a connection to it, and populates it with a test bucket and object.
-func runAndPopulateAzuriteContainer(ctx context.Context) {
-	var err error
+// TestFileClient_GetObject_SeekableReader tests that the readers returned by GetObject,
+// both on cache misses and cache hits, implement io.WriterTo and io.Seeker and can be
+// used by http.ServeContent to serve ranges.
+func TestFileClient_GetObject_SeekableReader(t *testing.T) {
+	ctx := context.Background()
 
-	azuriteContainer, err = azurite.Run(
-		ctx,
-		"mcr.microsoft.com/azure-storage/azurite:latest",
-		azurite.WithInMemoryPersistence(64),
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+		},
+		&minio.Options{},
 	)
 	if err != nil {
-		log.Fatalf("failed to start container: %s", err)
-		return
+		t.Fatalf("failed to create minio wrapper: %v", err)
 	}
 
-	azuriteEndpoint = fmt.Sprintf("%s/%s", azuriteContainer.MustServiceURL(ctx, azurite.BlobService), azurite.AccountName)
-	azuriteConnectionString = fmt.Sprintf("DefaultEndpointsProtocol=http;AccountName=%s;AccountKey=%s;BlobEndpoint=%s;", azurite.AccountName, azurite.AccountKey, azuriteEndpoint)
-
-	azureBlobClient, err := azblob.NewClientFromConnectionString(azuriteConnectionString, nil)
+	err = minioWrap.MakeBucket(ctx, "seekable")
 	if err != nil {
-		log.Fatalf("failed to create the Azurite client: %s", err)
+		t.Fatalf("failed to create minio bucket: %v", err)
 	}
 
-	_, err = azureBlobClient.CreateContainer(context.TODO(), "test-box", nil)
-	if err != nil {
-		log.Fatalf("failed to create the azurite container for test: %s\n", err)
-	}
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, minioWrap)
+	err = fileClient.ConfigureCache(m2cs.CacheOptions{Enabled: true})
+	assert.NoError(t, err, "ConfigureCache should succeed")
+	defer fileClient.DisableCache()
 
-	_, err = azureBlobClient.UploadStream(context.TODO(), "test-box", "test-get-object", strings.NewReader("test"), nil)
-	if err != nil {
-		log.Fatalf("failed to upload the test object: %s\n", err)
-	}
+	err = fileClient.PutObject(ctx, "seekable", "file.txt", strings.NewReader("0123456789"))
+	assert.NoError(t, err, "PutObject should succeed")
 
-	resp, err := azureBlobClient.DownloadStream(context.TODO(), "test-box", "test-get-object", nil)
-	if err != nil {
-		log.Fatalf("failed to download the test object: %s\n", err)
-	}
+	for _, path := range []string{"cache miss", "cache hit"} {
+		t.Run(path, func(t *testing.T) {
+			obj, err := fileClient.GetObject(ctx, "seekable", "file.txt")
+			if err != nil {
+				t.Fatalf("GetObject failed: %v", err)
+			}
+			defer obj.Close()
 
-	buf := new(strings.Builder)
-	_, err = io.Copy(buf, resp.Body)
-	if err != nil {
-		log.Fatalf("failed to read the test object: %s\n", err)
-	}
+			assert.Implements(t, (*io.WriterTo)(nil), obj)
+			seeker, ok := obj.(io.ReadSeeker)
+			if !assert.True(t, ok, "the reader should implement io.Seeker") {
+				return
+			}
 
-	if buf.String() != "test" {
-		log.Fatalf("expected object content to be 'test', got '%s'", buf.String())
-	}
+			req := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+			req.Header.Set("Range", "bytes=2-5")
+			rec := httptest.NewRecorder()
+			http.ServeContent(rec, req, "file.txt", time.Time{}, seeker)
 
-	azuriteConnection, err = filestorage.NewAzBlobClient(azureBlobClient, common.ConnectionProperties{
-		IsMainInstance: true,
-		SaveCompress:   common.NO_COMPRESSION,
-		SaveEncrypt:    common.NO_ENCRYPTION,
-	})
-	if err != nil {
-		log.Fatalf("failed to create AzBlobClient: %s", err)
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, "2345", rec.Body.String())
+			assert.Equal(t, "bytes 2-5/10", rec.Header().Get("Content-Range"))
+		})
 	}
 }
 
-// runAndPopulateMinIOContainer creates a MinIO container,
-// starts a connection to it, and populates it with a test bucket and object.
-func runAndPopulateMinIOContainer(ctx context.Context) {
-	req := testcontainers.ContainerRequest{
-		Image: "minio/minio:latest",
-		Env: map[string]string{
-			"MINIO_ROOT_USER":     "m2csUser",
-			"MINIO_ROOT_PASSWORD": "m2csPassword",
-		},
-		Cmd: []string{"server", "/data", "--console-address", ":9001"},
-	}
+//==============================================================================
+// RemoveObject tests
+//==============================================================================
 
-	var err error
+// TestFileClient_Remove_PartialFailure tests the RemoveObject method of the FileClient
+// with one replica and two main storages, one of which fails, ensuring that the failure
+// is reported against the main storages only and can be inspected with errors.As.
+func TestFileClient_Remove_PartialFailure(t *testing.T) {
+	ctx := context.Background()
 
-	minioContainer, err = testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
-		ContainerRequest: req,
-		Started:          true,
-	})
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+			Label:            "minio",
+		},
+		&minio.Options{},
+	)
 	if err != nil {
-		log.Fatalf("Error while starting the MinIO container: %s", err)
+		t.Fatalf("failed to create minio wrapper: %v", err)
 	}
 
-	minioEndpoint, err = minioContainer.Endpoint(ctx, "http")
+	azWrap, err := m2cs.NewAzBlobConnection(azuriteEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
+			IsMainInstance:   true,
+			Label:            "azurite",
+		})
 	if err != nil {
-		log.Fatalf("failed to get minio endpoint: %s", err)
+		t.Fatalf("failed to create azurite wrapper: %v", err)
 	}
 
-	minioClient, err := minio.New(strings.Replace(minioEndpoint, "http://", "", 1), &minio.Options{
-		Creds:  credentials.NewStaticV4(minioUser, minioPassword, ""),
-		Secure: false,
-	})
+	s3Wrap, err := m2cs.NewS3Connection(s3Endpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+			IsMainInstance:   false,
+			Label:            "s3",
+		}, "")
 	if err != nil {
-		log.Fatalf("failed to create MinIO client: %s", err.Error())
+		t.Fatalf("failed to create s3 wrapper: %v", err)
 	}
 
-	err = minioClient.MakeBucket(ctx, "test-box", minio.MakeBucketOptions{})
+	// the container is missing on azurite only, so that removing from it fails
+	err = minioWrap.MakeBucket(ctx, "removepartial")
 	if err != nil {
-		log.Fatalf("failed to create the minio bucket for test: %s\n", err)
+		t.Fatalf("failed to create minio bucket: %v", err)
 	}
-
-	// insert a test object into the bucket
-	_, err = minioClient.PutObject(ctx, "test-box", "object.txt", strings.NewReader("test"), int64(len("test")), minio.PutObjectOptions{})
+	err = minioWrap.PutObject(ctx, "removepartial", "file", strings.NewReader("test"))
 	if err != nil {
-		log.Fatalf("failed to put object into minio bucket: %s\n", err)
+		t.Fatalf("failed to put object into minio: %v", err)
 	}
 
-	obj, err := minioClient.GetObject(ctx, "test-box", "object.txt", minio.GetObjectOptions{})
-	if err != nil {
-		log.Fatalf("failed to get object from minio bucket: %s\n", err)
-	}
-	buf := make([]byte, int64(len("test")))
-	for {
-		_, err := obj.Read(buf)
-		if err != nil {
-			if err != io.EOF {
-				fmt.Println(err)
-			}
-			break
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, s3Wrap, minioWrap, azWrap)
+
+	err = fileClient.RemoveObject(ctx, "removepartial", "file")
+	assert.ErrorContains(t, err, "RemoveObject partially failed on 1/2 storages", "the count should only include main storages")
+
+	var partial *m2cs.PartialFailureError
+	if assert.ErrorAs(t, err, &partial) {
+		assert.Equal(t, 2, partial.Total)
+		if assert.Len(t, partial.Failures, 1) {
+			assert.Equal(t, "azurite", partial.Failures[0].Label)
+			assert.Error(t, partial.Failures[0].Err)
 		}
 	}
-	if string(buf) != "test" {
-		log.Fatalf("expected object content to be 'test', got '%s'", string(buf))
-	}
 
-	minioConnection, err = filestorage.NewMinioClient(minioClient, common.ConnectionProperties{
-		IsMainInstance: true,
-		SaveCompress:   common.NO_COMPRESSION,
-		SaveEncrypt:    common.AES256_ENCRYPTION,
-		EncryptKey:     "m2cs",
-	})
-	if err != nil {
-		log.Fatalf("failed to create MinioClient: %s", err)
+	var storageErr *m2cs.StorageError
+	if assert.ErrorAs(t, err, &storageErr) {
+		assert.Equal(t, "azurite", storageErr.Label)
 	}
 
+	exists, err := minioWrap.ExistObject(ctx, "removepartial", "file")
+	assert.NoError(t, err)
+	assert.False(t, exists, "the object should be removed from minio")
 }
 
-// runAndPopulateS3Container creates a LocalStack container with S3 service,
-// starts a connection to it, and populates it with a test bucket and object.
-func runAndPopulateS3Container(ctx context.Context) {
-	var err error
+//==============================================================================
+// FGetObject / FPutObject tests
+//==============================================================================
 
-	s3Container, err = localstack.Run(ctx, "localstack/localstack:latest")
+// TestFileClient_FPutObject_FGetObject tests that a file uploaded with FPutObject
+// is replicated on all main storages and downloaded back with FGetObject, creating
+// the missing parent directories.
+func TestFileClient_FPutObject_FGetObject(t *testing.T) {
+	ctx := context.Background()
 
-	mappedPort, err := s3Container.MappedPort(ctx, nat.Port("4566/tcp"))
+	src := filepath.Join(t.TempDir(), "upload.txt")
+	err := os.WriteFile(src, []byte("test file put"), 0o644)
 	if err != nil {
-		log.Fatalf("failed to retrieve mapped port: %s", err)
+		t.Fatalf("failed to write source file: %v", err)
 	}
 
-	provider, err := testcontainers.NewDockerProvider()
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
+			EncryptKey:       "m2cs",
+			AllowWeakKeys:    true,
+			IsMainInstance:   true,
+		},
+		&minio.Options{},
+	)
 	if err != nil {
-		log.Fatalf("failed to create docker provider: %s", err)
+		t.Fatalf("failed to create minio wrapper: %v", err)
 	}
-	defer provider.Close()
 
-	host, err := provider.DaemonHost(ctx)
+	azWrap, err := m2cs.NewAzBlobConnection(azuriteEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
+			SaveCompress:     m2cs.GZIP_COMPRESSION,
+			IsMainInstance:   true,
+		})
 	if err != nil {
-		log.Fatalf("failed to retrieve daemon host: %s", err)
+		t.Fatalf("failed to create azurite wrapper: %v", err)
 	}
 
-	awsCfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion("no-region"),
-	)
-	if err != nil {
-		log.Fatalf("failed to load AWS configuration: %s", err)
-	}
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, minioWrap, azWrap)
 
-	s3Endpoint = fmt.Sprintf("http://%s:%s", host, mappedPort.Port())
+	err = fileClient.FPutObject(ctx, "test-box", "fputTest", src)
+	assert.NoError(t, err, "FPutObject should succeed on all clients")
 
-	s3Client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(s3Endpoint)
-		o.UsePathStyle = true
-	})
+	checkResult := checkObjectExistenceInClients(t, ctx, "test-box", "fputTest", "test file put", minioWrap, azWrap)
+	assert.Equal(t, ExistsInAllWithCorrectContent, checkResult, "Object should exist in all clients with correct content")
+
+	dst := filepath.Join(t.TempDir(), "nested", "dir", "download.txt")
+	err = fileClient.FGetObject(ctx, "test-box", "fputTest", dst)
+	assert.NoError(t, err, "FGetObject should succeed")
+
+	data, err := os.ReadFile(dst)
+	assert.NoError(t, err, "downloaded file should exist")
+	assert.Equal(t, "test file put", string(data))
+
+	_, err = os.Stat(dst + m2cs.PartFileSuffix)
+	assert.True(t, os.IsNotExist(err), "part file should be removed after the download")
+}
+
+// TestFileClient_FGetObject_Resume tests that FGetObject resumes a partial download
+// with a ranged read and that the resulting file matches the stored object.
+func TestFileClient_FGetObject_Resume(t *testing.T) {
+	ctx := context.Background()
+
+	size := int64(32 * 1024 * 1024)
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	expectedHash := sha256.Sum256(payload)
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+		},
+		&minio.Options{},
+	)
+	if err != nil {
+		t.Fatalf("failed to create minio wrapper: %v", err)
+	}
+
+	spy := &rangeSpyClient{MinioClient: minioWrap}
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, spy)
+
+	err = fileClient.PutObject(ctx, "test-box", "resumeTest", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	// interrupt the first download after a third of the object, leaving the part file behind
+	dst := filepath.Join(t.TempDir(), "resume.bin")
+	spy.cut = size / 3
+	err = fileClient.FGetObject(ctx, "test-box", "resumeTest", dst)
+	assert.Error(t, err, "interrupted FGetObject should fail")
+	assert.Equal(t, 0, spy.rangeCalls, "first download should not use ranged reads")
+	spy.cut = 0
+
+	info, err := os.Stat(dst + m2cs.PartFileSuffix)
+	if err != nil {
+		t.Fatalf("part file should be left behind: %v", err)
+	}
+	assert.Equal(t, size/3, info.Size())
+
+	err = fileClient.FGetObject(ctx, "test-box", "resumeTest", dst)
+	assert.NoError(t, err, "resumed FGetObject should succeed")
+	assert.Equal(t, 1, spy.rangeCalls, "resumed download should use a ranged read")
+
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("failed to read downloaded file: %v", err)
+	}
+	assert.Equal(t, size, int64(len(data)), "size mismatch after resume")
+	assert.Equal(t, expectedHash, sha256.Sum256(data), "hash mismatch after resume")
+}
+
+//==============================================================================
+// Progress tests
+//==============================================================================
+
+// TestFileClient_Progress tests that PutObjectWithOptions and GetObjectWithOptions
+// report monotonic progress ending with the size of the payload.
+func TestFileClient_Progress(t *testing.T) {
+	ctx := context.Background()
+
+	payload := bytes.Repeat([]byte("m2cs"), 2*1024*1024)
+	size := int64(len(payload))
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+			Label:            "minio-main",
+		},
+		&minio.Options{},
+	)
+	if err != nil {
+		t.Fatalf("failed to create minio wrapper: %v", err)
+	}
+
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, minioWrap)
+
+	var mu sync.Mutex
+	var putCalls []int64
+	var labels []string
+	err = fileClient.PutObjectWithOptions(ctx, "test-box", "progressTest", bytes.NewReader(payload), m2cs.PutOptions{
+		Progress: func(transferred, total int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, size, total, "put total should be the payload size")
+			putCalls = append(putCalls, transferred)
+		},
+		StorageProgress: func(label string, transferred, total int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			labels = append(labels, label)
+		},
+		ProgressBytes: 512 * 1024,
+	})
+	assert.NoError(t, err, "PutObjectWithOptions should succeed")
+
+	assertMonotonicProgress(t, putCalls, size)
+	assert.NotEmpty(t, labels, "storage progress should be reported")
+	for _, label := range labels {
+		assert.Equal(t, "minio-main", label, "storage progress should carry the storage label")
+	}
+
+	var getCalls []int64
+	rc, err := fileClient.GetObjectWithOptions(ctx, "test-box", "progressTest", m2cs.GetOptions{
+		Progress: func(transferred, total int64) {
+			getCalls = append(getCalls, transferred)
+		},
+	})
+	assert.NoError(t, err, "GetObjectWithOptions should succeed")
+	data, err := io.ReadAll(rc)
+	_ = rc.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, payload, data, "downloaded content mismatch")
+
+	assertMonotonicProgress(t, getCalls, size)
+}
+
+//==============================================================================
+// SyncBox tests
+//==============================================================================
+
+// TestFileClient_SyncBox tests that SyncBox copies the objects of the source storage
+// into the other main storages, and that a dry run only plans the copies.
+func TestFileClient_SyncBox(t *testing.T) {
+	ctx := context.Background()
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+			Label:            "minio",
+		},
+		&minio.Options{},
+	)
+	if err != nil {
+		t.Fatalf("failed to create minio wrapper: %v", err)
+	}
+
+	azWrap, err := m2cs.NewAzBlobConnection(azuriteEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
+			SaveCompress:     m2cs.GZIP_COMPRESSION,
+			IsMainInstance:   true,
+			Label:            "azurite",
+		})
+	if err != nil {
+		t.Fatalf("failed to create azurite wrapper: %v", err)
+	}
+
+	s3Wrap, err := m2cs.NewS3Connection(s3Endpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+			IsMainInstance:   true,
+			Label:            "s3",
+		}, "")
+	if err != nil {
+		t.Fatalf("failed to create s3 wrapper: %v", err)
+	}
+
+	err = minioWrap.MakeBucket(ctx, "syncbox")
+	if err != nil {
+		t.Fatalf("failed to create minio bucket: %v", err)
+	}
+	err = azWrap.CreateContainer(ctx, "syncbox")
+	if err != nil {
+		t.Fatalf("failed to create azurite container: %v", err)
+	}
+	err = s3Wrap.CreateBucket(ctx, "syncbox")
+	if err != nil {
+		t.Fatalf("failed to create s3 bucket: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		err = minioWrap.PutObject(ctx, "syncbox", fmt.Sprintf("object-%02d", i), strings.NewReader(fmt.Sprintf("content %d", i)))
+		if err != nil {
+			t.Fatalf("failed to seed minio: %v", err)
+		}
+	}
+
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, minioWrap, azWrap, s3Wrap)
+
+	report, err := fileClient.SyncBox(ctx, "syncbox", "minio", m2cs.SyncOptions{DryRun: true})
+	assert.NoError(t, err, "dry run should succeed")
+	assert.Equal(t, 50, report.Scanned)
+	assert.Len(t, report.Planned, 100, "every object should be planned for both destinations")
+	assert.Equal(t, 0, report.Copied, "dry run should not copy")
+
+	exists, err := azWrap.ExistObject(ctx, "syncbox", "object-00")
+	assert.NoError(t, err)
+	assert.False(t, exists, "dry run should not copy objects")
+
+	report, err = fileClient.SyncBox(ctx, "syncbox", "minio", m2cs.SyncOptions{Concurrency: 8})
+	assert.NoError(t, err, "SyncBox should succeed")
+	assert.Equal(t, 100, report.Copied)
+	assert.Equal(t, 0, report.Failed)
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("object-%02d", i)
+		checkResult := checkObjectExistenceInClients(t, ctx, "syncbox", key, fmt.Sprintf("content %d", i), minioWrap, azWrap, s3Wrap)
+		assert.Equal(t, ExistsInAllWithCorrectContent, checkResult, "object %s should exist in all clients", key)
+	}
+
+	report, err = fileClient.SyncBox(ctx, "syncbox", "minio", m2cs.SyncOptions{})
+	assert.NoError(t, err, "second SyncBox should succeed")
+	assert.Empty(t, report.Planned, "converged storages should need no copies")
+
+	report, err = fileClient.SyncBox(ctx, "syncbox", "minio", m2cs.SyncOptions{
+		DryRun: true,
+		Filter: func(info m2cs.ObjectInfo) bool { return strings.HasSuffix(info.Key, "0") },
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 45, report.Filtered, "filter should skip objects not ending with 0")
+
+	_, err = fileClient.SyncBox(ctx, "syncbox", "unknown", m2cs.SyncOptions{})
+	assert.ErrorContains(t, err, "no storage labeled")
+}
+
+//==============================================================================
+// Soft delete tests
+//==============================================================================
+
+// TestFileClient_SoftDelete tests that RemoveObject moves objects to the trash when
+// soft-delete is enabled, and that they can be restored and purged.
+func TestFileClient_SoftDelete(t *testing.T) {
+	ctx := context.Background()
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+		},
+		&minio.Options{},
+	)
+	if err != nil {
+		t.Fatalf("failed to create minio wrapper: %v", err)
+	}
+
+	azWrap, err := m2cs.NewAzBlobConnection(azuriteEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
+			IsMainInstance:   true,
+		})
+	if err != nil {
+		t.Fatalf("failed to create azurite wrapper: %v", err)
+	}
+
+	for _, box := range []string{"softdelete", "softdelete-trash"} {
+		if err := minioWrap.MakeBucket(ctx, box); err != nil {
+			t.Fatalf("failed to create minio bucket: %v", err)
+		}
+		if err := azWrap.CreateContainer(ctx, box); err != nil {
+			t.Fatalf("failed to create azurite container: %v", err)
+		}
+	}
+
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, minioWrap, azWrap)
+
+	err = fileClient.RestoreObject(ctx, "softdelete", "file.txt")
+	assert.Error(t, err, "RestoreObject should fail when soft-delete is disabled")
+
+	err = fileClient.ConfigureSoftDelete(m2cs.SoftDeleteOptions{Enabled: true, TrashBox: "softdelete-trash"})
+	assert.NoError(t, err, "ConfigureSoftDelete should succeed")
+
+	err = fileClient.PutObject(ctx, "softdelete", "file.txt", strings.NewReader("trashed content"))
+	assert.NoError(t, err, "PutObject should succeed")
+
+	err = fileClient.RemoveObject(ctx, "softdelete", "file.txt")
+	assert.NoError(t, err, "RemoveObject should succeed")
+
+	for _, s := range []filestorage.FileStorage{minioWrap, azWrap} {
+		exists, err := s.ExistObject(ctx, "softdelete", "file.txt")
+		assert.NoError(t, err)
+		assert.False(t, exists, "object should be removed from the store box on %T", s)
+
+		objects, err := s.(filestorage.ObjectLister).ListObjectsInfo(ctx, "softdelete-trash", "softdelete/file.txt/")
+		assert.NoError(t, err)
+		assert.Len(t, objects, 1, "object should be moved to the trash on %T", s)
+	}
+
+	err = fileClient.RestoreObject(ctx, "softdelete", "file.txt")
+	assert.NoError(t, err, "RestoreObject should succeed")
+
+	obj, err := fileClient.GetObject(ctx, "softdelete", "file.txt")
+	assert.NoError(t, err, "GetObject should succeed after restore")
+	data, _ := io.ReadAll(obj)
+	_ = obj.Close()
+	assert.Equal(t, "trashed content", string(data))
+
+	err = fileClient.RemoveObject(ctx, "softdelete", "file.txt")
+	assert.NoError(t, err, "RemoveObject should succeed")
+
+	purged, err := fileClient.PurgeTrash(ctx, time.Hour)
+	assert.NoError(t, err, "PurgeTrash should succeed")
+	assert.Equal(t, 0, purged, "recent entries should be kept")
+
+	purged, err = fileClient.PurgeTrash(ctx, 0)
+	assert.NoError(t, err, "PurgeTrash should succeed")
+	assert.Equal(t, 2, purged, "one entry per main storage should be purged")
+
+	err = fileClient.RestoreObject(ctx, "softdelete", "file.txt")
+	assert.Error(t, err, "RestoreObject should fail once the trash is purged")
+}
+
+//==============================================================================
+// Describe tests
+//==============================================================================
+
+// TestFileClient_Describe tests that Describe reports the configuration of the
+// client without exposing encryption keys.
+func TestFileClient_Describe(t *testing.T) {
+	const secretKey = "describe-secret-key-0123456789ab"
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
+			EncryptKey:       secretKey,
+			Label:            "minio",
+		},
+		&minio.Options{},
+	)
+	if err != nil {
+		t.Fatalf("failed to create minio wrapper: %v", err)
+	}
+
+	azWrap, err := m2cs.NewAzBlobConnection(azuriteEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
+			IsMainInstance:   true,
+			SaveCompress:     m2cs.GZIP_COMPRESSION,
+			Label:            "azurite",
+		})
+	if err != nil {
+		t.Fatalf("failed to create azurite wrapper: %v", err)
+	}
+
+	s3Wrap, err := m2cs.NewS3Connection(s3Endpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+			IsMainInstance:   false,
+			SaveEncrypt:      m2cs.AES256_ENCRYPTION,
+			EncryptKey:       secretKey,
+		}, "")
+	if err != nil {
+		t.Fatalf("failed to create s3 wrapper: %v", err)
+	}
+
+	fileClient := m2cs.NewFileClient(m2cs.ASYNC_REPLICATION, m2cs.ROUND_ROBIN, minioWrap, azWrap, s3Wrap)
+
+	description := fileClient.Describe()
+	assert.Equal(t, m2cs.ASYNC_REPLICATION, description.ReplicationMode)
+	assert.Equal(t, m2cs.ROUND_ROBIN, description.LoadBalancing)
+	assert.False(t, description.Cache.Configured, "cache should not be configured")
+
+	expected := []m2cs.StorageDescription{
+		{Label: "minio", Type: "*filestorage.MinioClient", IsMain: true, Role: m2cs.SECONDARY_MAIN, Compression: m2cs.NO_COMPRESSION, Encryption: m2cs.AES256_ENCRYPTION},
+		{Label: "azurite", Type: "*filestorage.AzBlobClient", IsMain: true, Role: m2cs.SECONDARY_MAIN, Compression: m2cs.GZIP_COMPRESSION, Encryption: m2cs.NO_ENCRYPTION},
+		{Label: "*filestorage.S3Client", Type: "*filestorage.S3Client", IsMain: false, Role: m2cs.REPLICA, Compression: m2cs.NO_COMPRESSION, Encryption: m2cs.AES256_ENCRYPTION},
+	}
+	assert.Equal(t, expected, description.Storages)
+	assert.Equal(t, []string{"*filestorage.S3Client", "azurite", "minio"}, description.ReadOrder, "replicas come first, then the main storages by label")
+
+	err = fileClient.ConfigureCache(m2cs.CacheOptions{Enabled: true, TTL: time.Minute})
+	assert.NoError(t, err)
+	defer fileClient.DisableCache()
+
+	description = fileClient.Describe()
+	assert.True(t, description.Cache.Configured)
+	assert.True(t, description.Cache.Enabled)
+	assert.Equal(t, time.Minute, description.Cache.TTL)
+	assert.Equal(t, int64(1024), description.Cache.MaxSizeMB)
+	assert.Equal(t, int64(1024), description.Cache.MaxItemSizeMB, "the item size should default to MaxSizeMB")
+	assert.Equal(t, 1, description.Cache.AdmissionMinHits)
+
+	assert.NotContains(t, description.String(), secretKey, "String should not expose the encryption key")
+	assert.NotContains(t, fmt.Sprintf("%+v", description), secretKey, "the description should not hold the encryption key")
+	assert.NotContains(t, fmt.Sprintf("%#v", description), secretKey, "the description should not hold the encryption key")
+	assert.Contains(t, description.String(), "AES256_ENCRYPTION")
+}
+
+//==============================================================================
+// Shared load balancer tests
+//==============================================================================
+
+// TestFileClient_SharedRoundRobin tests that concurrent FileClients sharing a round-robin
+// load balancer spread the reads evenly across the replicas, instead of each client
+// starting its own rotation from the first replica.
+func TestFileClient_SharedRoundRobin(t *testing.T) {
+	ctx := context.Background()
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   false,
+		},
+		&minio.Options{})
+	if err != nil {
+		t.Fatalf("failed to create minio wrapper: %v", err)
+	}
+
+	azWrap, err := m2cs.NewAzBlobConnection(azuriteEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
+			IsMainInstance:   false,
+		})
+	if err != nil {
+		t.Fatalf("failed to create azurite wrapper: %v", err)
+	}
+
+	s3Wrap, err := m2cs.NewS3Connection(s3Endpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+			IsMainInstance:   false,
+		}, "")
+	if err != nil {
+		t.Fatalf("failed to create s3 wrapper: %v", err)
+	}
+
+	err = minioWrap.MakeBucket(ctx, "sharedrr")
+	if err != nil {
+		t.Fatalf("failed to create minio bucket: %v", err)
+	}
+	err = azWrap.CreateContainer(ctx, "sharedrr")
+	if err != nil {
+		t.Fatalf("failed to create azurite container: %v", err)
+	}
+	err = s3Wrap.CreateBucket(ctx, "sharedrr")
+	if err != nil {
+		t.Fatalf("failed to create s3 bucket: %v", err)
+	}
+	for _, s := range []filestorage.FileStorage{minioWrap, azWrap, s3Wrap} {
+		if err := s.PutObject(ctx, "sharedrr", "object", strings.NewReader("test")); err != nil {
+			t.Fatalf("failed to put object on %T: %v", s, err)
+		}
+	}
+
+	recorder := storagetest.NewRecorder()
+	storages := []filestorage.FileStorage{
+		storagetest.Wrap(minioWrap, recorder.Decorator("minio")),
+		storagetest.Wrap(azWrap, recorder.Decorator("azurite")),
+		storagetest.Wrap(s3Wrap, recorder.Decorator("s3")),
+	}
+
+	lb := m2cs.NewRoundRobinLoadBalancer(storages...)
+
+	const clients = 6
+	const readsPerClient = 5
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// every request builds its own client, as short-lived handlers do
+			for j := 0; j < readsPerClient; j++ {
+				fileClient, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN, storages,
+					m2cs.WithSharedLoadBalancer(lb))
+				if !assert.NoError(t, err, "NewFileClientWithOptions should succeed") {
+					return
+				}
+
+				reader, err := fileClient.GetObject(ctx, "sharedrr", "object")
+				if assert.NoError(t, err, "GetObject should succeed") {
+					_ = reader.Close()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	successes := recorder.Successes(storagetest.GET_OBJECT)
+	for _, replica := range []string{"minio", "azurite", "s3"} {
+		served := 0
+		for _, storage := range successes {
+			if storage == replica {
+				served++
+			}
+		}
+		assert.Equal(t, clients*readsPerClient/len(storages), served,
+			"replica %s should serve an even share of the reads", replica)
+	}
+
+	_, err = m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN, storages, m2cs.WithSharedLoadBalancer(nil))
+	assert.Error(t, err, "a nil load balancer should be rejected")
+}
+
+//==============================================================================
+// PutObjectFromURL tests
+//==============================================================================
+
+// TestFileClient_PutObjectFromURL tests that an object downloaded from an HTTP source lands
+// on every main storage, and that failed statuses, oversized bodies, checksum mismatches and
+// redirect loops are rejected without writing anything.
+func TestFileClient_PutObjectFromURL(t *testing.T) {
+	ctx := context.Background()
+	content := []byte("content served over http")
+	sum := md5.Sum(content)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/object", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		_, _ = w.Write(content)
+	})
+	mux.HandleFunc("/etag", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		_, _ = w.Write(content)
+	})
+	mux.HandleFunc("/corrupted", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		_, _ = w.Write([]byte("tampered content"))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/object", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var mains []*filestorage.MemoryClient
+	var storages []filestorage.FileStorage
+	for i := 0; i < 3; i++ {
+		client := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+		if err := client.MakeBucket(ctx, "fromurl"); err != nil {
+			t.Fatalf("failed to create memory bucket: %v", err)
+		}
+		mains = append(mains, client)
+		storages = append(storages, client)
+	}
+
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storages...)
+
+	assertStored := func(t *testing.T, fileName string) {
+		for i, client := range mains {
+			reader, err := client.GetObject(ctx, "fromurl", fileName)
+			if !assert.NoError(t, err, "object should be stored on main %d", i) {
+				continue
+			}
+			data, err := io.ReadAll(reader)
+			_ = reader.Close()
+			assert.NoError(t, err)
+			assert.Equal(t, content, data, "content on main %d should match the source", i)
+		}
+	}
+
+	assertMissing := func(t *testing.T, fileName string) {
+		for i, client := range mains {
+			exists, err := client.ExistObject(ctx, "fromurl", fileName)
+			assert.NoError(t, err)
+			assert.False(t, exists, "object should not be stored on main %d", i)
+		}
+	}
+
+	t.Run("Content-MD5", func(t *testing.T) {
+		err := fileClient.PutObjectFromURL(ctx, "fromurl", "object", server.URL+"/object", m2cs.URLOptions{})
+		assert.NoError(t, err)
+		assertStored(t, "object")
+	})
+
+	t.Run("ETag", func(t *testing.T) {
+		err := fileClient.PutObjectFromURL(ctx, "fromurl", "etag", server.URL+"/etag", m2cs.URLOptions{HTTPClient: server.Client()})
+		assert.NoError(t, err)
+		assertStored(t, "etag")
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		err := fileClient.PutObjectFromURL(ctx, "fromurl", "redirect", server.URL+"/redirect", m2cs.URLOptions{MaxRedirects: 1})
+		assert.NoError(t, err)
+		assertStored(t, "redirect")
+
+		err = fileClient.PutObjectFromURL(ctx, "fromurl", "noredirect", server.URL+"/redirect", m2cs.URLOptions{MaxRedirects: -1})
+		assert.Error(t, err, "redirects should not be followed when disabled")
+		assertMissing(t, "noredirect")
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		err := fileClient.PutObjectFromURL(ctx, "fromurl", "corrupted", server.URL+"/corrupted", m2cs.URLOptions{})
+		assert.ErrorIs(t, err, m2cs.ErrChecksumMismatch)
+		assertMissing(t, "corrupted")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		err := fileClient.PutObjectFromURL(ctx, "fromurl", "missing", server.URL+"/missing", m2cs.URLOptions{})
+		assert.ErrorContains(t, err, "404")
+		assertMissing(t, "missing")
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		err := fileClient.PutObjectFromURL(ctx, "fromurl", "large", server.URL+"/object", m2cs.URLOptions{MaxSize: 4})
+		assert.ErrorIs(t, err, m2cs.ErrObjectTooLarge)
+		assertMissing(t, "large")
+	})
+
+	t.Run("redirect loop", func(t *testing.T) {
+		err := fileClient.PutObjectFromURL(ctx, "fromurl", "loop", server.URL+"/loop", m2cs.URLOptions{MaxRedirects: 3})
+		assert.ErrorContains(t, err, "stopped after 3 redirects")
+		assertMissing(t, "loop")
+	})
+}
+
+//==============================================================================
+// Storage tier tests
+//==============================================================================
+
+// TestFileClient_SetObjectTier tests that SetObjectTier moves the object on the main storages
+// supporting tiers, and reports MinIO, whose bucket has no ILM rule, as a partial failure.
+func TestFileClient_SetObjectTier(t *testing.T) {
+	ctx := context.Background()
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+			Label:            "minio",
+		},
+		&minio.Options{},
+	)
+	if err != nil {
+		t.Fatalf("failed to create minio wrapper: %v", err)
+	}
+
+	azWrap, err := m2cs.NewAzBlobConnection(azuriteEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
+			IsMainInstance:   true,
+			Label:            "azurite",
+		})
+	if err != nil {
+		t.Fatalf("failed to create azurite wrapper: %v", err)
+	}
+
+	s3Wrap, err := m2cs.NewS3Connection(s3Endpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+			IsMainInstance:   true,
+			Label:            "s3",
+		}, "")
+	if err != nil {
+		t.Fatalf("failed to create s3 wrapper: %v", err)
+	}
+
+	err = minioWrap.MakeBucket(ctx, "tiering")
+	if err != nil {
+		t.Fatalf("failed to create minio bucket: %v", err)
+	}
+	err = azWrap.CreateContainer(ctx, "tiering")
+	if err != nil {
+		t.Fatalf("failed to create azurite container: %v", err)
+	}
+	err = s3Wrap.CreateBucket(ctx, "tiering")
+	if err != nil {
+		t.Fatalf("failed to create s3 bucket: %v", err)
+	}
+
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, minioWrap, azWrap, s3Wrap)
+
+	err = fileClient.PutObject(ctx, "tiering", "file", strings.NewReader("test tier"))
+	if err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	err = fileClient.SetObjectTier(ctx, "tiering", "file", m2cs.COOL_TIER)
+	assert.ErrorIs(t, err, filestorage.ErrTierNotSupported)
+
+	var partial *m2cs.PartialFailureError
+	if assert.ErrorAs(t, err, &partial) {
+		assert.Equal(t, 3, partial.Total)
+		if assert.Len(t, partial.Failures, 1) {
+			assert.Equal(t, "minio", partial.Failures[0].Label)
+		}
+	}
+
+	for _, tm := range []filestorage.TierManager{azWrap, s3Wrap} {
+		stat, err := tm.StatObject(ctx, "tiering", "file")
+		if assert.NoError(t, err) {
+			assert.Equal(t, m2cs.COOL_TIER, stat.TierStatus.Tier, "%T should record the cool tier", tm)
+		}
+	}
+}
+
+// TestFileClient_ObjectLock tests that a write with a retention locks the object on MinIO, whose
+// bucket has object lock enabled, and that the storages without object lock are reported.
+func TestFileClient_ObjectLock(t *testing.T) {
+	ctx := context.Background()
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+			Label:            "minio",
+		},
+		&minio.Options{},
+	)
+	if err != nil {
+		t.Fatalf("failed to create minio wrapper: %v", err)
+	}
+	err = minioWrap.GetClient().MakeBucket(ctx, "worm", minio.MakeBucketOptions{ObjectLocking: true})
+	if err != nil {
+		t.Fatalf("failed to create minio bucket: %v", err)
+	}
+
+	memory := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	if err := memory.MakeBucket(ctx, "worm"); err != nil {
+		t.Fatalf("failed to create memory bucket: %v", err)
+	}
+
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, minioWrap, memory)
+
+	until := time.Now().Add(time.Hour)
+	err = fileClient.PutObjectWithOptions(ctx, "worm", "record", strings.NewReader("audit record"), m2cs.PutOptions{
+		Retention: m2cs.Retention{Mode: m2cs.GOVERNANCE_RETENTION, Until: until},
+	})
+	assert.ErrorIs(t, err, filestorage.ErrObjectLockUnsupported)
+	assert.ErrorContains(t, err, "PutObject partially failed on 1/2 storages")
+
+	stat, err := minioWrap.StatObject(ctx, "worm", "record")
+	if assert.NoError(t, err) {
+		assert.Equal(t, m2cs.GOVERNANCE_RETENTION, stat.Lock.Retention.Mode)
+	}
+
+	err = fileClient.SetObjectLegalHold(ctx, "worm", "record", true)
+	var partial *m2cs.PartialFailureError
+	if assert.ErrorAs(t, err, &partial) {
+		assert.Equal(t, 2, partial.Total)
+		if assert.Len(t, partial.Failures, 1) {
+			assert.Equal(t, "memory", partial.Failures[0].Label)
+			assert.ErrorIs(t, partial.Failures[0], filestorage.ErrObjectLockUnsupported)
+		}
+	}
+
+	stat, err = minioWrap.StatObject(ctx, "worm", "record")
+	if assert.NoError(t, err) {
+		assert.True(t, stat.Lock.LegalHold)
+	}
+}
+
+//==============================================================================
+// Lifecycle tests
+//==============================================================================
+
+// TestFileClient_BoxLifecycle tests that SetBoxLifecycle pushes the rules to MinIO and S3,
+// which read them back, and reports Azure, whose policies are account-scoped, as a partial failure.
+func TestFileClient_BoxLifecycle(t *testing.T) {
+	ctx := context.Background()
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+			Label:            "minio",
+		},
+		&minio.Options{},
+	)
+	if err != nil {
+		t.Fatalf("failed to create minio wrapper: %v", err)
+	}
+
+	azWrap, err := m2cs.NewAzBlobConnection(azuriteEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
+			IsMainInstance:   true,
+			Label:            "azurite",
+		})
+	if err != nil {
+		t.Fatalf("failed to create azurite wrapper: %v", err)
+	}
+
+	s3Wrap, err := m2cs.NewS3Connection(s3Endpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+			IsMainInstance:   true,
+			Label:            "s3",
+		}, "")
+	if err != nil {
+		t.Fatalf("failed to create s3 wrapper: %v", err)
+	}
+
+	err = minioWrap.MakeBucket(ctx, "lifecycle")
+	if err != nil {
+		t.Fatalf("failed to create minio bucket: %v", err)
+	}
+	err = azWrap.CreateContainer(ctx, "lifecycle")
+	if err != nil {
+		t.Fatalf("failed to create azurite container: %v", err)
+	}
+	err = s3Wrap.CreateBucket(ctx, "lifecycle")
+	if err != nil {
+		t.Fatalf("failed to create s3 bucket: %v", err)
+	}
+
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, minioWrap, azWrap, s3Wrap)
+
+	rules := []m2cs.LifecycleRule{{ID: "expire-tmp", Prefix: "tmp/", ExpireAfterDays: 7}}
+
+	err = fileClient.SetBoxLifecycle(ctx, "lifecycle", rules)
+	assert.ErrorIs(t, err, filestorage.ErrLifecycleNotSupported)
+
+	var partial *m2cs.PartialFailureError
+	if assert.ErrorAs(t, err, &partial) {
+		assert.Equal(t, 3, partial.Total)
+		if assert.Len(t, partial.Failures, 1) {
+			assert.Equal(t, "azurite", partial.Failures[0].Label)
+		}
+	}
+
+	lifecycles, err := fileClient.GetBoxLifecycle(ctx, "lifecycle")
+	assert.ErrorIs(t, err, filestorage.ErrLifecycleNotSupported)
+	assert.Equal(t, map[string][]m2cs.LifecycleRule{"minio": rules, "s3": rules}, lifecycles)
+}
+
+//==============================================================================
+// Watch tests
+//==============================================================================
+
+// TestFileClient_Watch tests that a change replicated to several storages is delivered once
+// within the deduplication window, labeled with the first storage reporting it.
+func TestFileClient_Watch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var storages []filestorage.FileStorage
+	for _, label := range []string{"first", "second", "third"} {
+		client := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: label})
+		if err := client.MakeBucket(ctx, "watch"); err != nil {
+			t.Fatalf("failed to create memory bucket: %v", err)
+		}
+		storages = append(storages, client)
+	}
+
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storages...)
+
+	events, err := fileClient.WatchWithOptions(ctx, "watch", nil, m2cs.WatchOptions{
+		PollInterval: 10 * time.Millisecond,
+		DedupWindow:  time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("failed to watch: %v", err)
+	}
+
+	// each change is awaited, since the listing diff misses the changes undone between two listings
+	err = fileClient.PutObject(ctx, "watch", "object", strings.NewReader("test"))
+	assert.NoError(t, err)
+	received := collectEvents(events, 200*time.Millisecond)
+	if assert.Len(t, received, 1, "the creation should be delivered once") {
+		assert.Equal(t, m2cs.OBJECT_CREATED, received[0].Type)
+		assert.Equal(t, "object", received[0].Key)
+		assert.Contains(t, []string{"first", "second", "third"}, received[0].Label)
+	}
+
+	err = fileClient.RemoveObject(ctx, "watch", "object")
+	assert.NoError(t, err)
+	received = collectEvents(events, 200*time.Millisecond)
+	if assert.Len(t, received, 1, "the removal should be delivered once") {
+		assert.Equal(t, m2cs.OBJECT_REMOVED, received[0].Type)
+		assert.Equal(t, "object", received[0].Key)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+//==============================================================================
+// Public access tests
+//==============================================================================
+
+// TestFileClient_SetBoxPublicRead tests that the anonymous access is applied to all main
+// storages, and that GetBoxAccess reports storages granting different access.
+func TestFileClient_SetBoxPublicRead(t *testing.T) {
+	ctx := context.Background()
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+			Label:            "minio",
+		},
+		&minio.Options{},
+	)
+	if err != nil {
+		t.Fatalf("failed to create minio wrapper: %v", err)
+	}
+
+	azWrap, err := m2cs.NewAzBlobConnection(azuriteEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
+			IsMainInstance:   true,
+			Label:            "azurite",
+		})
+	if err != nil {
+		t.Fatalf("failed to create azurite wrapper: %v", err)
+	}
+
+	s3Wrap, err := m2cs.NewS3Connection(s3Endpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+			IsMainInstance:   false,
+			Label:            "s3",
+		}, "")
+	if err != nil {
+		t.Fatalf("failed to create s3 wrapper: %v", err)
+	}
+
+	err = minioWrap.MakeBucket(ctx, "publicread")
+	if err != nil {
+		t.Fatalf("failed to create minio bucket: %v", err)
+	}
+	err = azWrap.CreateContainer(ctx, "publicread")
+	if err != nil {
+		t.Fatalf("failed to create azurite container: %v", err)
+	}
+
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, s3Wrap, minioWrap, azWrap)
+
+	err = fileClient.SetBoxPublicRead(ctx, "publicread", true)
+	assert.NoError(t, err)
+
+	accesses, err := fileClient.GetBoxAccess(ctx, "publicread")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]m2cs.BoxAccess{
+		"minio":   {PublicRead: true},
+		"azurite": {PublicRead: true},
+	}, accesses, "only the main storages should be inspected")
+
+	// revoke the access on minio only
+	err = minioWrap.SetBoxPublicRead(ctx, "publicread", false)
+	assert.NoError(t, err)
+
+	accesses, err = fileClient.GetBoxAccess(ctx, "publicread")
+	assert.ErrorIs(t, err, m2cs.ErrBoxAccessDiverged)
+	assert.Equal(t, m2cs.BoxAccess{}, accesses["minio"])
+	assert.Equal(t, m2cs.BoxAccess{PublicRead: true}, accesses["azurite"])
+
+	err = fileClient.SetBoxPublicRead(ctx, "publicread", false)
+	assert.NoError(t, err)
+}
+
+//==============================================================================
+// Buffer pool tests
+//==============================================================================
+
+// TestFileClient_PutObject_BufferReuse writes distinct payloads concurrently, in both replication
+// modes, and checks that every storage holds the payload of its own write: a pooled buffer recycled
+// while a storage is still reading it would mix the payloads. Run with -race to catch the reuse.
+func TestFileClient_PutObject_BufferReuse(t *testing.T) {
+	ctx := context.Background()
+
+	for _, mode := range []m2cs.ReplicationMode{m2cs.SYNC_REPLICATION, m2cs.ASYNC_REPLICATION} {
+		t.Run(mode.String(), func(t *testing.T) {
+			var storages []filestorage.FileStorage
+			var clients []*filestorage.MemoryClient
+			for i := 0; i < 3; i++ {
+				client := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+				if err := client.MakeBucket(ctx, "pool"); err != nil {
+					t.Fatalf("failed to create memory bucket: %v", err)
+				}
+				storages = append(storages, client)
+				clients = append(clients, client)
+			}
+
+			fileClient := m2cs.NewFileClient(mode, m2cs.READ_REPLICA_FIRST, storages...)
+
+			const writes = 50
+			var wg sync.WaitGroup
+			wg.Add(writes)
+			for i := 0; i < writes; i++ {
+				go func(i int) {
+					defer wg.Done()
+					payload := bytes.Repeat([]byte{byte(i)}, 64<<10+i)
+					err := fileClient.PutObject(ctx, "pool", fmt.Sprintf("object-%d", i), bytes.NewReader(payload))
+					assert.NoError(t, err)
+				}(i)
+			}
+			wg.Wait()
+
+			// in ASYNC_REPLICATION mode the background writes may still be running
+			assert.Eventually(t, func() bool {
+				for _, client := range clients {
+					objects, err := client.ListObjectsInfo(ctx, "pool", "")
+					if err != nil || len(objects) != writes {
+						return false
+					}
+				}
+				return true
+			}, 5*time.Second, 10*time.Millisecond)
+
+			for i := 0; i < writes; i++ {
+				want := bytes.Repeat([]byte{byte(i)}, 64<<10+i)
+				for j, client := range clients {
+					obj, err := client.GetObject(ctx, "pool", fmt.Sprintf("object-%d", i))
+					if !assert.NoError(t, err) {
+						continue
+					}
+					got, err := io.ReadAll(obj)
+					_ = obj.Close()
+					assert.NoError(t, err)
+					assert.True(t, bytes.Equal(want, got), "storage %d holds a corrupted object-%d", j, i)
+				}
+			}
+		})
+	}
+}
+
+//==============================================================================
+// Write concurrency tests
+//==============================================================================
+
+// TestFileClient_WithMaxConcurrentWrites launches 10 concurrent puts against a slow storage and checks
+// that no more than 2 backend puts are ever in flight.
+func TestFileClient_WithMaxConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+
+	for _, mode := range []m2cs.ReplicationMode{m2cs.SYNC_REPLICATION, m2cs.ASYNC_REPLICATION} {
+		t.Run(mode.String(), func(t *testing.T) {
+			spy := &concurrencySpyStorage{delay: 20 * time.Millisecond}
+			fileClient, err := m2cs.NewFileClientWithOptions(mode, m2cs.READ_REPLICA_FIRST,
+				[]filestorage.FileStorage{spy}, m2cs.WithMaxConcurrentWrites(2))
+			assert.NoError(t, err)
+
+			var wg sync.WaitGroup
+			wg.Add(10)
+			for i := 0; i < 10; i++ {
+				go func(i int) {
+					defer wg.Done()
+					err := fileClient.PutObject(ctx, "box", fmt.Sprintf("object-%d", i), strings.NewReader("test"))
+					assert.NoError(t, err)
+				}(i)
+			}
+			wg.Wait()
+
+			assert.Equal(t, int64(10), spy.puts.Load())
+			assert.LessOrEqual(t, spy.maxInFlight.Load(), int64(2))
+			// the slots of ASYNC_REPLICATION are released by a background goroutine
+			assert.Eventually(t, func() bool { return fileClient.InFlightWrites() == 0 }, time.Second, time.Millisecond)
+		})
+	}
+
+	_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, nil, m2cs.WithMaxConcurrentWrites(0))
+	assert.Error(t, err)
+}
+
+// TestFileClient_WithMaxConcurrentWrites_ContextCancelled verifies that a caller waiting for a write
+// slot gives up when its context is done, without taking the slot.
+func TestFileClient_WithMaxConcurrentWrites_ContextCancelled(t *testing.T) {
+	spy := &concurrencySpyStorage{delay: 200 * time.Millisecond}
+	fileClient, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{spy}, m2cs.WithMaxConcurrentWrites(1))
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- fileClient.PutObject(context.Background(), "box", "slow", strings.NewReader("test"))
+	}()
+	assert.Eventually(t, func() bool { return fileClient.InFlightWrites() == 1 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = fileClient.PutObject(ctx, "box", "waiting", strings.NewReader("test"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int64(1), fileClient.InFlightWrites())
+
+	assert.NoError(t, <-done)
+	assert.Equal(t, int64(1), spy.puts.Load(), "the cancelled put should not reach the storage")
+}
+
+// TestFileClient_WithMaxStorageConcurrency removes and puts an object on 20 slow storages and checks that
+// no more than 4 storages are ever called at once, in both replication modes.
+func TestFileClient_WithMaxStorageConcurrency(t *testing.T) {
+	ctx := context.Background()
+
+	for _, mode := range []m2cs.ReplicationMode{m2cs.SYNC_REPLICATION, m2cs.ASYNC_REPLICATION} {
+		t.Run(mode.String(), func(t *testing.T) {
+			counters := &storageCallCounters{}
+			fileClient, err := m2cs.NewFileClientWithOptions(mode, m2cs.READ_REPLICA_FIRST,
+				blockingStorages(20, 10*time.Millisecond, counters), m2cs.WithMaxStorageConcurrency(4))
+			assert.NoError(t, err)
+
+			assert.NoError(t, fileClient.RemoveObject(ctx, "box", "object"))
+			assert.Equal(t, int64(20), counters.calls.Load())
+			assert.LessOrEqual(t, counters.maxInFlight.Load(), int64(4))
+
+			assert.NoError(t, fileClient.PutObject(ctx, "box", "object", strings.NewReader("test")))
+			assert.Eventually(t, func() bool { return fileClient.InFlightWrites() == 0 }, 2*time.Second, time.Millisecond)
+			assert.Equal(t, int64(40), counters.calls.Load())
+			assert.LessOrEqual(t, counters.maxInFlight.Load(), int64(4))
+		})
+	}
+
+	_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, nil, m2cs.WithMaxStorageConcurrency(0))
+	assert.Error(t, err)
+}
+
+// TestFileClient_RemoveObject_ContextCancelled cancels a RemoveObject on 20 storages whose calls ignore the
+// context, and checks that it returns once the calls in flight do, without calling the other storages.
+func TestFileClient_RemoveObject_ContextCancelled(t *testing.T) {
+	const delay = 200 * time.Millisecond
+
+	counters := &storageCallCounters{}
+	fileClient, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		blockingStorages(20, delay, counters), m2cs.WithMaxStorageConcurrency(4))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = fileClient.RemoveObject(ctx, "box", "object")
+	elapsed := time.Since(start)
+
+	assert.Less(t, elapsed, 2*delay, "RemoveObject should only wait for the calls in flight, not for 5 rounds of them")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	var partial *m2cs.PartialFailureError
+	if assert.ErrorAs(t, err, &partial) {
+		assert.Len(t, partial.Failures, 16, "the storages not called should fail")
+	}
+	assert.Equal(t, int64(4), counters.calls.Load())
+}
+
+//==============================================================================
+// Shadow read tests
+//==============================================================================
+
+// TestFileClient_WithShadowReads stores a corrupted copy of an object on the shadow storage and checks
+// that a mismatch is reported while GetObject still returns the correct data.
+func TestFileClient_WithShadowReads(t *testing.T) {
+	ctx := context.Background()
+
+	primary := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "primary"})
+	shadow := filestorage.NewMemoryClient(common.ConnectionProperties{Label: "shadow"})
+	for _, client := range []*filestorage.MemoryClient{primary, shadow} {
+		if err := client.MakeBucket(ctx, "shadow"); err != nil {
+			t.Fatalf("failed to create memory bucket: %v", err)
+		}
+	}
+	assert.NoError(t, primary.PutObject(ctx, "shadow", "good", strings.NewReader("same content")))
+	assert.NoError(t, shadow.PutObject(ctx, "shadow", "good", strings.NewReader("same content")))
+	assert.NoError(t, primary.PutObject(ctx, "shadow", "corrupted", strings.NewReader("original content")))
+	assert.NoError(t, shadow.PutObject(ctx, "shadow", "corrupted", strings.NewReader("corrupted content")))
+
+	results := make(chan m2cs.ShadowResult, 10)
+	fileClient, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{primary}, m2cs.WithShadowReads(m2cs.ShadowOptions{
+			Storage:    shadow,
+			Percentage: 100,
+			OnResult:   func(result m2cs.ShadowResult) { results <- result },
+		}))
+	assert.NoError(t, err)
+
+	for name, want := range map[string]string{"good": "same content", "corrupted": "original content"} {
+		obj, err := fileClient.GetObject(ctx, "shadow", name)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		data, err := io.ReadAll(obj)
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(data), "the shadow copy should never be served")
+
+		select {
+		case result := <-results:
+			assert.Equal(t, name, result.FileName)
+			assert.Equal(t, "shadow", result.Label)
+			assert.NoError(t, result.Err)
+			assert.Equal(t, name == "good", result.Match)
+		case <-time.After(time.Second):
+			t.Fatalf("no shadow result for %s", name)
+		}
+	}
+
+	// a missing shadow copy is reported as an error, not as a mismatch
+	assert.NoError(t, primary.PutObject(ctx, "shadow", "missing", strings.NewReader("content")))
+	_, err = fileClient.GetObject(ctx, "shadow", "missing")
+	assert.NoError(t, err)
+	select {
+	case result := <-results:
+		assert.Error(t, result.Err)
+		assert.False(t, result.Match)
+	case <-time.After(time.Second):
+		t.Fatal("no shadow result for the missing copy")
+	}
+}
+
+// TestFileClient_WithShadowReads_Bandwidth verifies that a throttled shadow read does not delay GetObject,
+// and that the shadow copy is read no faster than the configured bandwidth.
+func TestFileClient_WithShadowReads_Bandwidth(t *testing.T) {
+	ctx := context.Background()
+
+	payload := bytes.Repeat([]byte("m2cs"), 8<<10) // 32 KB
+	primary := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+	shadow := filestorage.NewMemoryClient(common.ConnectionProperties{})
+	for _, client := range []*filestorage.MemoryClient{primary, shadow} {
+		if err := client.MakeBucket(ctx, "shadow"); err != nil {
+			t.Fatalf("failed to create memory bucket: %v", err)
+		}
+		assert.NoError(t, client.PutObject(ctx, "shadow", "object", bytes.NewReader(payload)))
+	}
+
+	results := make(chan m2cs.ShadowResult, 1)
+	fileClient, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{primary}, m2cs.WithShadowReads(m2cs.ShadowOptions{
+			Storage:           shadow,
+			Percentage:        100,
+			MaxBytesPerSecond: 64 << 10,
+			OnResult:          func(result m2cs.ShadowResult) { results <- result },
+		}))
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = fileClient.GetObject(ctx, "shadow", "object")
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "GetObject should not wait for the shadow read")
+
+	select {
+	case result := <-results:
+		assert.True(t, result.Match)
+		assert.GreaterOrEqual(t, result.Duration, 400*time.Millisecond, "32 KB at 64 KB/s should take about 500ms")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no shadow result")
+	}
+}
+
+//==============================================================================
+// GetObjectWithInfo tests
+//==============================================================================
+
+// TestFileClient_GetObjectWithInfo verifies that the logical size is reported by a storage
+// saving gzip-compressed objects, also when the payload is wrapped to report the progress.
+func TestFileClient_GetObjectWithInfo(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, SaveCompress: common.GZIP_COMPRESSION})
+	if err := storage.MakeBucket(ctx, "info"); err != nil {
+		t.Fatalf("failed to create memory bucket: %v", err)
+	}
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+
+	content := strings.Repeat("compressible content ", 50)
+	err := fileClient.PutObjectWithOptions(ctx, "info", "object.txt", strings.NewReader(content), m2cs.PutOptions{
+		Progress: func(transferred, total int64) {},
+	})
+	assert.NoError(t, err)
+
+	reader, stat, err := fileClient.GetObjectWithInfo(ctx, "info", "object.txt")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(data))
+	assert.Equal(t, int64(len(content)), stat.Size)
+	assert.Equal(t, "object.txt", stat.Key)
+
+	_, _, err = fileClient.GetObjectWithInfo(ctx, "info", "missing.txt")
+	assert.ErrorIs(t, err, filestorage.ErrObjectNotFound)
+}
+
+//==============================================================================
+// DownloadParallel tests
+//==============================================================================
+
+// TestFileClient_DownloadParallel downloads a 64 MB object replicated on two storages with 4 workers
+// into a file, and compares its hash with the one of the uploaded content.
+func TestFileClient_DownloadParallel(t *testing.T) {
+	ctx := context.Background()
+
+	content := make([]byte, 64<<20)
+	for i := range content {
+		content[i] = byte(i * 7 % 251)
+	}
+
+	var storages []filestorage.FileStorage
+	for _, label := range []string{"replica-a", "replica-b"} {
+		storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: label})
+		if err := storage.MakeBucket(ctx, "parallel"); err != nil {
+			t.Fatalf("failed to create memory bucket: %v", err)
+		}
+		storages = append(storages, storage)
+	}
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN, storages...)
+	assert.NoError(t, fileClient.PutObject(ctx, "parallel", "large.bin", bytes.NewReader(content)))
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "large.bin"))
+	if err != nil {
+		t.Fatalf("failed to create local file: %v", err)
+	}
+	defer file.Close()
+
+	n, err := fileClient.DownloadParallel(ctx, "parallel", "large.bin", file, m2cs.ParallelOptions{PartSize: 4 << 20, Concurrency: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+
+	downloaded, err := os.ReadFile(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, sha256.Sum256(content), sha256.Sum256(downloaded))
+}
+
+// TestFileClient_DownloadParallel_SequentialFallback verifies that an object saved compressed,
+// whose ranges cannot be served, is streamed sequentially into the writer.
+func TestFileClient_DownloadParallel_SequentialFallback(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, SaveCompress: common.GZIP_COMPRESSION})
+	if err := storage.MakeBucket(ctx, "parallel"); err != nil {
+		t.Fatalf("failed to create memory bucket: %v", err)
+	}
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+
+	content := strings.Repeat("compressed content ", 1000)
+	assert.NoError(t, fileClient.PutObject(ctx, "parallel", "object.txt", strings.NewReader(content)))
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "object.txt"))
+	if err != nil {
+		t.Fatalf("failed to create local file: %v", err)
+	}
+	defer file.Close()
+
+	n, err := fileClient.DownloadParallel(ctx, "parallel", "object.txt", file, m2cs.ParallelOptions{PartSize: 1024})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+
+	downloaded, err := os.ReadFile(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(downloaded))
+
+	_, err = fileClient.DownloadParallel(ctx, "parallel", "missing.txt", file, m2cs.ParallelOptions{})
+	assert.ErrorIs(t, err, filestorage.ErrObjectNotFound)
+}
+
+// TestFileClient_DownloadParallel_ChecksumMismatch stores different contents of the same size on two
+// replicas and checks that a download mixing their ranges is rejected.
+func TestFileClient_DownloadParallel_ChecksumMismatch(t *testing.T) {
+	ctx := context.Background()
+
+	var storages []filestorage.FileStorage
+	for _, content := range []string{"aaaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbbb"} {
+		storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+		if err := storage.MakeBucket(ctx, "parallel"); err != nil {
+			t.Fatalf("failed to create memory bucket: %v", err)
+		}
+		assert.NoError(t, storage.PutObject(ctx, "parallel", "object.txt", strings.NewReader(content)))
+		storages = append(storages, storage)
+	}
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN, storages...)
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "object.txt"))
+	if err != nil {
+		t.Fatalf("failed to create local file: %v", err)
+	}
+	defer file.Close()
+
+	_, err = fileClient.DownloadParallel(ctx, "parallel", "object.txt", file, m2cs.ParallelOptions{PartSize: 4, Concurrency: 2})
+	assert.ErrorIs(t, err, m2cs.ErrChecksumMismatch)
+}
+
+//==============================================================================
+// UploadParallel tests
+//==============================================================================
+
+// TestFileClient_UploadParallel verifies that with SYNC_REPLICATION the object is written on every main
+// storage, in parts on the storages supporting it and sequentially on the others.
+func TestFileClient_UploadParallel(t *testing.T) {
+	ctx := context.Background()
+
+	plain := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+	spy := &parallelSpyStorage{MemoryClient: filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})}
+	for _, client := range []*filestorage.MemoryClient{plain, spy.MemoryClient} {
+		if err := client.MakeBucket(ctx, "parallel"); err != nil {
+			t.Fatalf("failed to create memory bucket: %v", err)
+		}
+	}
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, plain, spy)
+
+	content := strings.Repeat("parallel upload ", 1000)
+	opts := m2cs.ParallelOptions{PartSize: 1024, Concurrency: 2}
+	assert.NoError(t, fileClient.UploadParallel(ctx, "parallel", "object.txt", strings.NewReader(content), int64(len(content)), opts))
+
+	assert.Equal(t, []m2cs.ParallelOptions{opts}, spy.calls())
+	for _, client := range []*filestorage.MemoryClient{plain, spy.MemoryClient} {
+		reader, err := client.GetObject(ctx, "parallel", "object.txt")
+		if !assert.NoError(t, err) {
+			continue
+		}
+		data, _ := io.ReadAll(reader)
+		assert.Equal(t, content, string(data))
+	}
+}
+
+// TestFileClient_UploadParallel_Async verifies that with ASYNC_REPLICATION the call returns once the
+// first main storage is written, while the upload to the others completes in the background.
+func TestFileClient_UploadParallel_Async(t *testing.T) {
+	ctx := context.Background()
+
+	first := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+	spy := &parallelSpyStorage{
+		MemoryClient: filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true}),
+		release:      make(chan struct{}),
+	}
+	for _, client := range []*filestorage.MemoryClient{first, spy.MemoryClient} {
+		if err := client.MakeBucket(ctx, "parallel"); err != nil {
+			t.Fatalf("failed to create memory bucket: %v", err)
+		}
+	}
+	fileClient := m2cs.NewFileClient(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, first, spy)
+
+	content := "asynchronous parallel upload"
+	assert.NoError(t, fileClient.UploadParallel(ctx, "parallel", "object.txt", strings.NewReader(content), int64(len(content)), m2cs.ParallelOptions{}))
+
+	exists, err := first.ExistObject(ctx, "parallel", "object.txt")
+	assert.NoError(t, err)
+	assert.True(t, exists, "expected the first storage to be written when the call returns")
+	exists, err = spy.ExistObject(ctx, "parallel", "object.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists, "expected the background upload to be pending")
+
+	close(spy.release)
+	assert.Eventually(t, func() bool {
+		exists, err := spy.ExistObject(ctx, "parallel", "object.txt")
+		return err == nil && exists
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+//==============================================================================
+// Incomplete uploads tests
+//==============================================================================
+
+// TestFileClient_CleanupIncompleteUploads creates an incomplete multipart upload on MinIO and checks
+// that it is aborted, that S3 reports no upload and that Azure is skipped.
+func TestFileClient_CleanupIncompleteUploads(t *testing.T) {
+	ctx := context.Background()
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+			Label:            "minio",
+		},
+		&minio.Options{},
+	)
+	if err != nil {
+		t.Fatalf("failed to create minio wrapper: %v", err)
+	}
+
+	azWrap, err := m2cs.NewAzBlobConnection(azuriteEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
+			IsMainInstance:   true,
+			Label:            "azurite",
+		})
+	if err != nil {
+		t.Fatalf("failed to create azurite wrapper: %v", err)
+	}
+
+	s3Wrap, err := m2cs.NewS3Connection(s3Endpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+			IsMainInstance:   true,
+			Label:            "s3",
+		}, "")
+	if err != nil {
+		t.Fatalf("failed to create s3 wrapper: %v", err)
+	}
+
+	rawMinio, err := minio.New(strings.TrimPrefix(minioEndpoint, "http://"), &minio.Options{
+		Creds: credentials.NewStaticV4(minioUser, minioPassword, ""),
+	})
+	if err != nil {
+		t.Fatalf("failed to create minio client: %v", err)
+	}
+	core := minio.Core{Client: rawMinio}
+	_, err = core.NewMultipartUpload(ctx, "test-box", "abandoned.bin", minio.PutObjectOptions{})
+	assert.NoError(t, err)
+
+	fileClient := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, minioWrap, azWrap, s3Wrap)
+
+	counts, err := fileClient.CleanupIncompleteUploads(ctx, "test-box", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"minio": 1, "s3": 0}, counts)
+
+	uploads, err := minioWrap.ListIncompleteUploads(ctx, "test-box")
+	assert.NoError(t, err)
+	assert.Empty(t, uploads)
+}
+
+//==============================================================================
+// Key prefix tests
+//==============================================================================
+
+// TestFileClient_WithKeyPrefix creates two clients with different key prefixes over the same storages
+// and checks that neither can read, find, remove or sync the objects of the other.
+func TestFileClient_WithKeyPrefix(t *testing.T) {
+	ctx := context.Background()
+
+	var storages []filestorage.FileStorage
+	var memories []*filestorage.MemoryClient
+	for _, label := range []string{"first", "second"} {
+		client := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: label})
+		if err := client.MakeBucket(ctx, "shared"); err != nil {
+			t.Fatalf("failed to create memory bucket: %v", err)
+		}
+		storages = append(storages, client)
+		memories = append(memories, client)
+	}
+
+	tenantA, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storages, m2cs.WithKeyPrefix("tenant-a"))
+	assert.NoError(t, err)
+	tenantB, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storages, m2cs.WithKeyPrefix("tenant-b/"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, tenantA.PutObject(ctx, "shared", "doc.txt", strings.NewReader("content of a")))
+	assert.NoError(t, tenantB.PutObject(ctx, "shared", "doc.txt", strings.NewReader("content of b")))
+	assert.NoError(t, tenantA.PutObject(ctx, "shared", "only-a.txt", strings.NewReader("private")))
+
+	for _, memory := range memories {
+		exists, err := memory.ExistObject(ctx, "shared", "tenant-a/doc.txt")
+		assert.NoError(t, err)
+		assert.True(t, exists, "the key should be stored with the prefix")
+	}
+
+	for client, want := range map[*m2cs.FileClient]string{tenantA: "content of a", tenantB: "content of b"} {
+		obj, err := client.GetObject(ctx, "shared", "doc.txt")
+		if assert.NoError(t, err) {
+			data, err := io.ReadAll(obj)
+			assert.NoError(t, err)
+			assert.Equal(t, want, string(data))
+		}
+	}
+
+	exists, err := tenantB.ExistObject(ctx, "shared", "only-a.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists, "objects of another namespace should not be visible")
+	_, err = tenantB.GetObject(ctx, "shared", "only-a.txt")
+	assert.Error(t, err)
+	assert.Error(t, tenantB.RemoveObject(ctx, "shared", "only-a.txt"))
+
+	// relative segments cannot reach the other namespace
+	for _, key := range []string{"../tenant-a/only-a.txt", "x/../../tenant-a/only-a.txt", `..\tenant-a\only-a.txt`, "/only-a.txt", "./only-a.txt", ""} {
+		_, err := tenantB.GetObject(ctx, "shared", key)
+		assert.ErrorIs(t, err, m2cs.ErrInvalidKey, "key %q", key)
+		assert.ErrorIs(t, tenantB.PutObject(ctx, "shared", key, strings.NewReader("x")), m2cs.ErrInvalidKey, "key %q", key)
+	}
+
+	// SyncBox only copies the objects of the namespace, reporting their keys without the prefix
+	assert.NoError(t, memories[1].RemoveObject(ctx, "shared", "tenant-a/only-a.txt"))
+	assert.NoError(t, memories[1].RemoveObject(ctx, "shared", "tenant-b/doc.txt"))
+	report, err := tenantA.SyncBox(ctx, "shared", "first", m2cs.SyncOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Scanned)
+	assert.Equal(t, []m2cs.SyncAction{{Key: "only-a.txt", Target: "second", Reason: "missing"}}, report.Planned)
+	exists, err = memories[1].ExistObject(ctx, "shared", "tenant-b/doc.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists, "SyncBox should not copy the objects of another namespace")
+
+	assert.NoError(t, tenantA.RemoveObject(ctx, "shared", "doc.txt"))
+	obj, err := tenantB.GetObject(ctx, "shared", "doc.txt")
+	if assert.NoError(t, err, "removing an object should not affect the other namespace") {
+		_ = obj.Close()
+	}
+}
+
+// TestFileClient_WithBoxPrefix verifies that the store boxes are prefixed and that invalid prefixes are rejected.
+func TestFileClient_WithBoxPrefix(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+	if err := storage.MakeBucket(ctx, "acme-photos"); err != nil {
+		t.Fatalf("failed to create memory bucket: %v", err)
+	}
+
+	fileClient, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{storage}, m2cs.WithBoxPrefix("acme-"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, fileClient.PutObject(ctx, "photos", "cat.png", strings.NewReader("meow")))
+	exists, err := storage.ExistObject(ctx, "acme-photos", "cat.png")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = fileClient.ExistObject(ctx, "photos", "cat.png")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	for _, opt := range []m2cs.FileClientOption{m2cs.WithBoxPrefix(""), m2cs.WithBoxPrefix("a/b"), m2cs.WithKeyPrefix(""), m2cs.WithKeyPrefix("/abs"), m2cs.WithKeyPrefix("a/../b")} {
+		_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, []filestorage.FileStorage{storage}, opt)
+		assert.Error(t, err)
+	}
+}
+
+//==============================================================================
+// Name validation tests
+//==============================================================================
+
+// TestValidateBoxName checks tricky store box names against the strict and the lenient rules.
+func TestValidateBoxName(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		lenient bool
+	}{
+		{"test-bucket", true, true},
+		{"a1b", true, true},
+		{strings.Repeat("a", 63), true, true},
+		{"Test_Bucket", false, true},
+		{"test_bucket", false, true},
+		{"ab", false, true},
+		{strings.Repeat("a", 64), false, true},
+		{"bücket", false, true},
+		{"my.bucket", false, true},
+		{"bucket.", false, true},
+		{"-bucket", false, true},
+		{"bucket-", false, true},
+		{"my--bucket", false, true},
+		{"xn--bucket", false, true},
+		{"sthree-bucket", false, true},
+		{"bucket-s3alias", false, true},
+		{"my bucket", false, true},
+		{"my/bucket", false, false},
+		{"", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for validation, valid := range map[m2cs.NameValidation]bool{m2cs.STRICT_NAME_VALIDATION: tt.strict, m2cs.LENIENT_NAME_VALIDATION: tt.lenient} {
+				err := m2cs.ValidateBoxName(tt.name, validation)
+				if valid {
+					assert.NoError(t, err, "%v", validation)
+				} else {
+					assert.ErrorIs(t, err, m2cs.ErrInvalidBoxName, "%v", validation)
+				}
+			}
+		})
+	}
+}
+
+// TestValidateKey checks tricky keys against the strict and the lenient rules.
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		strict  bool
+		lenient bool
+	}{
+		{"simple", "object.txt", true, true},
+		{"nested", "a/b/c.txt", true, true},
+		{"unicode", "фото/猫.png", true, true},
+		{"spaces", "my file.txt", true, true},
+		{"max length", strings.Repeat("k", 1024), true, true},
+		{"too long", strings.Repeat("k", 1025), false, false},
+		{"too long in bytes", strings.Repeat("é", 513), false, false},
+		{"empty", "", false, false},
+		{"invalid utf-8", "bad\xffkey", false, false},
+		{"control character", "line\nbreak", false, true},
+		{"trailing dot", "file.", false, true},
+		{"trailing slash", "folder/", false, true},
+		{"too many segments", strings.Repeat("a/", 254) + "a", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for validation, valid := range map[m2cs.NameValidation]bool{m2cs.STRICT_NAME_VALIDATION: tt.strict, m2cs.LENIENT_NAME_VALIDATION: tt.lenient} {
+				err := m2cs.ValidateKey(tt.key, validation)
+				if valid {
+					assert.NoError(t, err, "%v", validation)
+				} else {
+					assert.ErrorIs(t, err, m2cs.ErrInvalidKey, "%v", validation)
+				}
+			}
+		})
+	}
+}
+
+// TestFileClient_NameValidation verifies that invalid names are rejected before reaching the storages,
+// and that the lenient validation lets through the names accepted by a single provider.
+func TestFileClient_NameValidation(t *testing.T) {
+	ctx := context.Background()
+
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+	if err := storage.MakeBucket(ctx, "Test_Bucket"); err != nil {
+		t.Fatalf("failed to create memory bucket: %v", err)
+	}
+
+	strict := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+	err := strict.PutObject(ctx, "Test_Bucket", "object", strings.NewReader("content"))
+	assert.ErrorIs(t, err, m2cs.ErrInvalidBoxName)
+	assert.Contains(t, err.Error(), "uppercase")
+	_, err = strict.GetObject(ctx, "Test_Bucket", "object")
+	assert.ErrorIs(t, err, m2cs.ErrInvalidBoxName)
+	assert.ErrorIs(t, strict.RemoveObject(ctx, "test-bucket", "folder/"), m2cs.ErrInvalidKey)
+
+	exists, err := storage.ExistObject(ctx, "Test_Bucket", "object")
+	assert.NoError(t, err)
+	assert.False(t, exists, "no request should reach the storage")
+
+	lenient, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{storage}, m2cs.WithNameValidation(m2cs.LENIENT_NAME_VALIDATION))
+	assert.NoError(t, err)
+	assert.NoError(t, lenient.PutObject(ctx, "Test_Bucket", "object", strings.NewReader("content")))
+	exists, err = lenient.ExistObject(ctx, "Test_Bucket", "object")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.ErrorIs(t, lenient.PutObject(ctx, "Test_Bucket", "", strings.NewReader("content")), m2cs.ErrInvalidKey)
+}
+
+//==============================================================================
+// Key encoding tests
+//==============================================================================
+
+// TestFileClient_WithKeyEncoding round-trips keys with characters handled differently by the providers
+// through both encodings, and checks the stored keys and the keys reported by SyncBox.
+func TestFileClient_WithKeyEncoding(t *testing.T) {
+	ctx := context.Background()
+
+	keys := []string{"my file.txt", "100%.txt", `dir\file.txt`, "a%20b", "docs/фото 1.png"}
+	tests := []struct {
+		encoding m2cs.KeyEncoding
+		stored   map[string]string
+	}{
+		{m2cs.URL_KEY_ENCODING, map[string]string{`dir\file.txt`: "dir%5Cfile.txt", "a%20b": "a%2520b"}},
+		{m2cs.BASE64_KEY_ENCODING, map[string]string{"docs/фото 1.png": "ZG9jcw/0YTQvtGC0L4gMS5wbmc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.encoding.String(), func(t *testing.T) {
+			var storages []filestorage.FileStorage
+			var memories []*filestorage.MemoryClient
+			for _, label := range []string{"first", "second"} {
+				client := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: label})
+				if err := client.MakeBucket(ctx, "encoded"); err != nil {
+					t.Fatalf("failed to create memory bucket: %v", err)
+				}
+				storages = append(storages, client)
+				memories = append(memories, client)
+			}
+
+			fileClient, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+				storages[:1], m2cs.WithKeyEncoding(tt.encoding))
+			assert.NoError(t, err)
+
+			for _, key := range keys {
+				if !assert.NoError(t, fileClient.PutObject(ctx, "encoded", key, strings.NewReader("content of "+key)), "key %q", key) {
+					continue
+				}
+				obj, err := fileClient.GetObject(ctx, "encoded", key)
+				if assert.NoError(t, err, "key %q", key) {
+					data, err := io.ReadAll(obj)
+					assert.NoError(t, err)
+					assert.Equal(t, "content of "+key, string(data))
+				}
+			}
+			for key, stored := range tt.stored {
+				exists, err := memories[0].ExistObject(ctx, "encoded", stored)
+				assert.NoError(t, err)
+				assert.True(t, exists, "%q should be stored as %q", key, stored)
+			}
+
+			// a key written without the encoding is not the encoding of any name, and is ignored
+			assert.NoError(t, memories[0].PutObject(ctx, "encoded", "raw key", strings.NewReader("literal")))
+
+			syncClient, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+				storages, m2cs.WithKeyEncoding(tt.encoding))
+			assert.NoError(t, err)
+			report, err := syncClient.SyncBox(ctx, "encoded", "first", m2cs.SyncOptions{DryRun: true})
+			assert.NoError(t, err)
+			var planned []string
+			for _, action := range report.Planned {
+				planned = append(planned, action.Key)
+			}
+			assert.ElementsMatch(t, keys, planned, "SyncBox should report the decoded keys")
+		})
+	}
+}
+
+// TestFileClient_WithKeyEncoding_AllStorages round-trips keys with spaces, '%', '\' and 1024-byte
+// names on MinIO, Azure Blob and AWS S3.
+func TestFileClient_WithKeyEncoding_AllStorages(t *testing.T) {
+	ctx := context.Background()
+
+	minioWrap, err := m2cs.NewMinIOConnection(
+		minioEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials(minioUser, minioPassword),
+			IsMainInstance:   true,
+		},
+		&minio.Options{},
+	)
+	if err != nil {
+		t.Fatalf("failed to create minio wrapper: %v", err)
+	}
+
+	azWrap, err := m2cs.NewAzBlobConnection(azuriteEndpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithConnectionString(azuriteConnectionString),
+			IsMainInstance:   true,
+		})
+	if err != nil {
+		t.Fatalf("failed to create azurite wrapper: %v", err)
+	}
+
+	s3Wrap, err := m2cs.NewS3Connection(s3Endpoint,
+		m2cs.ConnectionOptions{
+			ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+			IsMainInstance:   true,
+		}, "")
+	if err != nil {
+		t.Fatalf("failed to create s3 wrapper: %v", err)
+	}
+
+	if err := minioWrap.MakeBucket(ctx, "keyencoding"); err != nil {
+		t.Fatalf("failed to create minio bucket: %v", err)
+	}
+	if err := azWrap.CreateContainer(ctx, "keyencoding"); err != nil {
+		t.Fatalf("failed to create azurite container: %v", err)
+	}
+	if err := s3Wrap.CreateBucket(ctx, "keyencoding"); err != nil {
+		t.Fatalf("failed to create s3 bucket: %v", err)
+	}
+
+	keys := map[m2cs.KeyEncoding][]string{
+		m2cs.URL_KEY_ENCODING:    {"my file.txt", "50% off.txt", `windows\path\file.txt`, "trailing.", strings.Repeat("k", 1024)},
+		m2cs.BASE64_KEY_ENCODING: {"my file.txt", "50% off.txt", `windows\path\file.txt`, "trailing.", strings.Repeat("k", 768)},
+	}
+
+	for encoding, encodingKeys := range keys {
+		fileClient, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+			[]filestorage.FileStorage{minioWrap, azWrap, s3Wrap}, m2cs.WithKeyEncoding(encoding))
+		assert.NoError(t, err)
+
+		for _, key := range encodingKeys {
+			if key == "trailing." && encoding == m2cs.URL_KEY_ENCODING {
+				// the dot is left as is, and rejected as Azure Blob does not preserve it
+				assert.ErrorIs(t, fileClient.PutObject(ctx, "keyencoding", key, strings.NewReader(key)), m2cs.ErrInvalidKey)
+				continue
+			}
+
+			err := fileClient.PutObject(ctx, "keyencoding", key, strings.NewReader("content of "+key))
+			if !assert.NoError(t, err, "%v: PutObject of a %d-byte key should succeed", encoding, len(key)) {
+				continue
+			}
+
+			for _, storage := range []filestorage.FileStorage{minioWrap, azWrap, s3Wrap} {
+				single, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+					[]filestorage.FileStorage{storage}, m2cs.WithKeyEncoding(encoding))
+				assert.NoError(t, err)
+
+				obj, err := single.GetObject(ctx, "keyencoding", key)
+				if !assert.NoError(t, err, "%v: %T should serve a %d-byte key", encoding, storage, len(key)) {
+					continue
+				}
+				data, err := io.ReadAll(obj)
+				assert.NoError(t, err)
+				assert.Equal(t, "content of "+key, string(data))
+			}
+		}
+	}
+}
+
+//==============================================================================
+// Prefetch tests
+//==============================================================================
+
+func TestFileClient_Prefetch(t *testing.T) {
+	ctx := context.Background()
+
+	var storages []filestorage.FileStorage
+	recorder := storagetest.NewRecorder()
+	for _, label := range []string{"first", "second"} {
+		memory := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: label})
+		if err := memory.MakeBucket(ctx, "warm"); err != nil {
+			t.Fatalf("failed to create memory bucket: %v", err)
+		}
+		storages = append(storages, storagetest.Wrap(memory, recorder.Decorator("")))
+	}
+
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN, storages)
+	assert.NoError(t, err)
+
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("hot-%02d.txt", i)
+		assert.NoError(t, client.PutObject(ctx, "warm", key, strings.NewReader("content of "+key)))
+		keys = append(keys, key)
+	}
+
+	_, err = client.Prefetch(ctx, "warm", keys, m2cs.PrefetchOptions{})
+	assert.Error(t, err, "prefetching without a cache should fail")
+
+	assert.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, MaxItems: 20}))
+
+	report, err := client.Prefetch(ctx, "warm", keys, m2cs.PrefetchOptions{Concurrency: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 20, report.Cached)
+	assert.Zero(t, report.Skipped)
+	assert.Zero(t, report.Failed)
+	for i, result := range report.Results {
+		assert.Equal(t, keys[i], result.Key)
+		assert.Equal(t, m2cs.PrefetchCached, result.Outcome)
+		assert.Equal(t, int64(len("content of "+keys[i])), result.Size)
+	}
+
+	attempts := func() int {
+		return recorder.Count("", storagetest.GET_OBJECT)
+	}
+	before := attempts()
+	for _, key := range keys {
+		obj, err := client.GetObject(ctx, "warm", key)
+		if assert.NoError(t, err) {
+			data, err := io.ReadAll(obj)
+			assert.NoError(t, err)
+			assert.Equal(t, "content of "+key, string(data))
+		}
+	}
+	assert.Equal(t, before, attempts(), "prefetched objects should be served by the cache")
+
+	// missing objects are reported without stopping the prefetch
+	report, err = client.Prefetch(ctx, "warm", []string{"missing.txt", keys[0]}, m2cs.PrefetchOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, 1, report.Cached)
+	assert.Equal(t, m2cs.PrefetchFailed, report.Results[0].Outcome)
+	assert.Error(t, report.Results[0].Err)
+}
+
+func TestFileClient_Prefetch_Limits(t *testing.T) {
+	ctx := context.Background()
+
+	memory := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true, Label: "memory"})
+	if err := memory.MakeBucket(ctx, "warm"); err != nil {
+		t.Fatalf("failed to create memory bucket: %v", err)
+	}
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, []filestorage.FileStorage{memory})
+	assert.NoError(t, err)
+	assert.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true, MaxSizeMB: 1, MaxItems: 3}))
+
+	assert.NoError(t, client.PutObject(ctx, "warm", "large.bin", bytes.NewReader(make([]byte, 1<<20+1))))
+	var keys []string
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("small-%d.txt", i)
+		assert.NoError(t, client.PutObject(ctx, "warm", key, strings.NewReader("small")))
+		keys = append(keys, key)
+	}
+
+	// objects larger than an item are skipped, and the cache holds at most MaxItems objects
+	report, err := client.Prefetch(ctx, "warm", append([]string{"large.bin"}, keys...), m2cs.PrefetchOptions{Concurrency: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, m2cs.PrefetchTooLarge, report.Results[0].Outcome)
+	assert.Equal(t, 3, report.Cached)
+	assert.Equal(t, 3, report.Skipped)
+	assert.Equal(t, m2cs.PrefetchCacheFull, report.Results[4].Outcome)
+	assert.Equal(t, m2cs.PrefetchCacheFull, report.Results[5].Outcome)
+
+	// a cancelled prefetch does not attempt the keys
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	report, err = client.Prefetch(cancelled, "warm", keys, m2cs.PrefetchOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, report.Cached)
+	for _, result := range report.Results {
+		assert.Equal(t, m2cs.PrefetchNotAttempted, result.Outcome)
+		assert.ErrorIs(t, result.Err, context.Canceled)
+	}
+}
+
+//==============================================================================
+// Utility functions and structs for setting up test
+//==============================================================================
+
+// runAndPopulateAzuriteContainer create an Azurite container
+// starts a connection to it, and populates it with a test bucket and object.
+func runAndPopulateAzuriteContainer(ctx context.Context) {
+	var err error
+
+	azuriteContainer, err = azurite.Run(
+		ctx,
+		"mcr.microsoft.com/azure-storage/azurite:latest",
+		azurite.WithInMemoryPersistence(64),
+	)
+	if err != nil {
+		log.Fatalf("failed to start container: %s", err)
+		return
+	}
+
+	azuriteEndpoint = fmt.Sprintf("%s/%s", azuriteContainer.MustServiceURL(ctx, azurite.BlobService), azurite.AccountName)
+	azuriteConnectionString = fmt.Sprintf("DefaultEndpointsProtocol=http;AccountName=%s;AccountKey=%s;BlobEndpoint=%s;", azurite.AccountName, azurite.AccountKey, azuriteEndpoint)
+
+	azureBlobClient, err := azblob.NewClientFromConnectionString(azuriteConnectionString, nil)
+	if err != nil {
+		log.Fatalf("failed to create the Azurite client: %s", err)
+	}
+
+	_, err = azureBlobClient.CreateContainer(context.TODO(), "test-box", nil)
+	if err != nil {
+		log.Fatalf("failed to create the azurite container for test: %s\n", err)
+	}
+
+	_, err = azureBlobClient.UploadStream(context.TODO(), "test-box", "test-get-object", strings.NewReader("test"), nil)
+	if err != nil {
+		log.Fatalf("failed to upload the test object: %s\n", err)
+	}
+
+	resp, err := azureBlobClient.DownloadStream(context.TODO(), "test-box", "test-get-object", nil)
+	if err != nil {
+		log.Fatalf("failed to download the test object: %s\n", err)
+	}
+
+	buf := new(strings.Builder)
+	_, err = io.Copy(buf, resp.Body)
+	if err != nil {
+		log.Fatalf("failed to read the test object: %s\n", err)
+	}
+
+	if buf.String() != "test" {
+		log.Fatalf("expected object content to be 'test', got '%s'", buf.String())
+	}
+
+	azuriteConnection, err = filestorage.NewAzBlobClient(azureBlobClient, common.ConnectionProperties{
+		IsMainInstance: true,
+		SaveCompress:   common.NO_COMPRESSION,
+		SaveEncrypt:    common.NO_ENCRYPTION,
+	})
+	if err != nil {
+		log.Fatalf("failed to create AzBlobClient: %s", err)
+	}
+}
+
+// runAndPopulateMinIOContainer creates a MinIO container,
+// starts a connection to it, and populates it with a test bucket and object.
+func runAndPopulateMinIOContainer(ctx context.Context) {
+	req := testcontainers.ContainerRequest{
+		Image: "minio/minio:latest",
+		Env: map[string]string{
+			"MINIO_ROOT_USER":     "m2csUser",
+			"MINIO_ROOT_PASSWORD": "m2csPassword",
+		},
+		Cmd: []string{"server", "/data", "--console-address", ":9001"},
+	}
+
+	var err error
+
+	minioContainer, err = testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		log.Fatalf("Error while starting the MinIO container: %s", err)
+	}
+
+	minioEndpoint, err = minioContainer.Endpoint(ctx, "http")
+	if err != nil {
+		log.Fatalf("failed to get minio endpoint: %s", err)
+	}
+
+	minioClient, err := minio.New(strings.Replace(minioEndpoint, "http://", "", 1), &minio.Options{
+		Creds:  credentials.NewStaticV4(minioUser, minioPassword, ""),
+		Secure: false,
+	})
+	if err != nil {
+		log.Fatalf("failed to create MinIO client: %s", err.Error())
+	}
+
+	err = minioClient.MakeBucket(ctx, "test-box", minio.MakeBucketOptions{})
+	if err != nil {
+		log.Fatalf("failed to create the minio bucket for test: %s\n", err)
+	}
+
+	// insert a test object into the bucket
+	_, err = minioClient.PutObject(ctx, "test-box", "object.txt", strings.NewReader("test"), int64(len("test")), minio.PutObjectOptions{})
+	if err != nil {
+		log.Fatalf("failed to put object into minio bucket: %s\n", err)
+	}
+
+	obj, err := minioClient.GetObject(ctx, "test-box", "object.txt", minio.GetObjectOptions{})
+	if err != nil {
+		log.Fatalf("failed to get object from minio bucket: %s\n", err)
+	}
+	buf := make([]byte, int64(len("test")))
+	for {
+		_, err := obj.Read(buf)
+		if err != nil {
+			if err != io.EOF {
+				fmt.Println(err)
+			}
+			break
+		}
+	}
+	if string(buf) != "test" {
+		log.Fatalf("expected object content to be 'test', got '%s'", string(buf))
+	}
+
+	minioConnection, err = filestorage.NewMinioClient(minioClient, common.ConnectionProperties{
+		IsMainInstance: true,
+		SaveCompress:   common.NO_COMPRESSION,
+		SaveEncrypt:    common.AES256_ENCRYPTION,
+		EncryptKey:     "m2cs",
+	})
+	if err != nil {
+		log.Fatalf("failed to create MinioClient: %s", err)
+	}
+
+}
+
+// runAndPopulateS3Container creates a LocalStack container with S3 service,
+// starts a connection to it, and populates it with a test bucket and object.
+func runAndPopulateS3Container(ctx context.Context) {
+	var err error
+
+	s3Container, err = localstack.Run(ctx, "localstack/localstack:latest")
+
+	mappedPort, err := s3Container.MappedPort(ctx, nat.Port("4566/tcp"))
+	if err != nil {
+		log.Fatalf("failed to retrieve mapped port: %s", err)
+	}
+
+	provider, err := testcontainers.NewDockerProvider()
+	if err != nil {
+		log.Fatalf("failed to create docker provider: %s", err)
+	}
+	defer provider.Close()
+
+	host, err := provider.DaemonHost(ctx)
+	if err != nil {
+		log.Fatalf("failed to retrieve daemon host: %s", err)
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion("no-region"),
+	)
+	if err != nil {
+		log.Fatalf("failed to load AWS configuration: %s", err)
+	}
+
+	s3Endpoint = fmt.Sprintf("http://%s:%s", host, mappedPort.Port())
+
+	s3Client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(s3Endpoint)
+		o.UsePathStyle = true
+	})
 	if s3Client == nil {
 		log.Fatalf("failed to create S3 client: client is nil")
 	}
@@ -1369,76 +3659,185 @@ const (
 	UnknownError
 )
 
-// slowClient decorates a filestorage.FileStorage adding a delay on Operation.
-// It is used to simulate slow clients in tests.
-type slowClient struct {
-	inner filestorage.FileStorage
-	delay time.Duration
+// rangeSpyClient decorates a filestorage.MinioClient counting the ranged reads. With cut set, its
+// reads fail after cut bytes, as a dropped connection.
+type rangeSpyClient struct {
+	*filestorage.MinioClient
+	cut int64
+
+	mu         sync.Mutex
+	rangeCalls int
 }
 
-func (s slowClient) GetConnectionProperties() common.ConnectionProperties {
-	return s.inner.GetConnectionProperties()
+func (r *rangeSpyClient) GetObject(ctx context.Context, storeBox, fileName string) (io.ReadCloser, error) {
+	obj, err := r.MinioClient.GetObject(ctx, storeBox, fileName)
+	if err != nil || r.cut <= 0 {
+		return obj, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(io.LimitReader(obj, r.cut), iotest.ErrReader(errors.New("connection reset"))), obj}, nil
 }
 
-func (s slowClient) PutObject(ctx context.Context, storeBox, fileName string, r io.Reader) error {
-	time.Sleep(s.delay)
-	return s.inner.PutObject(ctx, storeBox, fileName, r)
+func (r *rangeSpyClient) GetObjectRange(ctx context.Context, storeBox, fileName string, offset, length int64) (io.ReadCloser, error) {
+	r.mu.Lock()
+	r.rangeCalls++
+	r.mu.Unlock()
+
+	return r.MinioClient.GetObjectRange(ctx, storeBox, fileName, offset, length)
 }
 
-func (s slowClient) GetObject(ctx context.Context, storeBox, fileName string) (io.ReadCloser, error) {
-	time.Sleep(s.delay)
-	return s.inner.GetObject(ctx, storeBox, fileName)
+// assertMonotonicProgress checks that progress calls never go backwards and that
+// the last one equals the expected size.
+func assertMonotonicProgress(t *testing.T, calls []int64, size int64) {
+	t.Helper()
+
+	if len(calls) == 0 {
+		t.Fatalf("no progress reported")
+	}
+	for i := 1; i < len(calls); i++ {
+		assert.GreaterOrEqual(t, calls[i], calls[i-1], "progress should be monotonic")
+	}
+	assert.Equal(t, size, calls[len(calls)-1], "final progress should equal the payload size")
 }
 
-func (s slowClient) RemoveObject(ctx context.Context, storeBox, fileName string) error {
-	time.Sleep(s.delay)
-	return s.inner.RemoveObject(ctx, storeBox, fileName)
+// collectEvents returns the events received within the given duration.
+func collectEvents(events <-chan m2cs.ObjectEvent, duration time.Duration) []m2cs.ObjectEvent {
+	var received []m2cs.ObjectEvent
+	timeout := time.After(duration)
+	for {
+		select {
+		case event := <-events:
+			received = append(received, event)
+		case <-timeout:
+			return received
+		}
+	}
+}
+
+// concurrencySpyStorage is a slow main storage recording the highest number of puts running at once.
+type concurrencySpyStorage struct {
+	delay       time.Duration
+	puts        atomic.Int64
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+}
+
+func (s *concurrencySpyStorage) GetObject(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
 }
 
-func (s slowClient) ExistObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
+func (s *concurrencySpyStorage) PutObject(ctx context.Context, storeBox string, fileName string, reader io.Reader) error {
+	s.puts.Add(1)
+	current := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		max := s.maxInFlight.Load()
+		if current <= max || s.maxInFlight.CompareAndSwap(max, current) {
+			break
+		}
+	}
+
+	_, err := io.Copy(io.Discard, reader)
 	time.Sleep(s.delay)
-	return s.inner.ExistObject(ctx, storeBox, fileName)
+	return err
 }
 
-// spyClient decorates a filestorage.FileStorage
-type spyClient struct {
-	inner filestorage.FileStorage
-	iD    string
+func (s *concurrencySpyStorage) RemoveObject(ctx context.Context, storeBox string, fileName string) error {
+	return nil
+}
 
-	mu         sync.Mutex
-	attempts   int
-	successes  int
-	successSeq *[]string
+func (s *concurrencySpyStorage) ExistObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
+	return false, nil
 }
 
-func (s *spyClient) GetConnectionProperties() common.ConnectionProperties {
-	return s.inner.GetConnectionProperties()
+func (s *concurrencySpyStorage) GetConnectionProperties() common.ConnectionProperties {
+	return common.ConnectionProperties{IsMainInstance: true, Label: "spy"}
 }
 
-func (s *spyClient) GetObject(ctx context.Context, box, key string) (io.ReadCloser, error) {
+// parallelSpyStorage is a MemoryClient supporting parallel uploads, which records their options and
+// waits for release, when set, before writing the object sequentially.
+type parallelSpyStorage struct {
+	*filestorage.MemoryClient
+	release chan struct{}
+
+	mu      sync.Mutex
+	options []m2cs.ParallelOptions
+}
+
+func (s *parallelSpyStorage) UploadParallel(ctx context.Context, storeBox string, fileName string, r io.ReaderAt, size int64, opts filestorage.ParallelOptions) error {
 	s.mu.Lock()
-	s.attempts++
+	s.options = append(s.options, opts)
 	s.mu.Unlock()
 
-	rc, err := s.inner.GetObject(ctx, box, key)
-	if err == nil {
-		s.mu.Lock()
-		s.successes++
-		if s.successSeq != nil {
-			*s.successSeq = append(*s.successSeq, s.iD)
+	if s.release != nil {
+		<-s.release
+	}
+	return s.PutObject(ctx, storeBox, fileName, io.NewSectionReader(r, 0, size))
+}
+
+func (s *parallelSpyStorage) calls() []m2cs.ParallelOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]m2cs.ParallelOptions(nil), s.options...)
+}
+
+// storageCallCounters records the calls made to a set of blockingStorage.
+type storageCallCounters struct {
+	calls       atomic.Int64
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+}
+
+// blockingStorage is a main storage whose puts and removals take delay whatever their context,
+// as an SDK call waiting for its own timeout does, and which records them in shared counters.
+type blockingStorage struct {
+	label    string
+	delay    time.Duration
+	counters *storageCallCounters
+}
+
+// blockingStorages returns n blockingStorage sharing the given counters.
+func blockingStorages(n int, delay time.Duration, counters *storageCallCounters) []filestorage.FileStorage {
+	storages := make([]filestorage.FileStorage, n)
+	for i := range storages {
+		storages[i] = &blockingStorage{label: fmt.Sprintf("blocking-%d", i), delay: delay, counters: counters}
+	}
+	return storages
+}
+
+func (s *blockingStorage) call() {
+	s.counters.calls.Add(1)
+	current := s.counters.inFlight.Add(1)
+	defer s.counters.inFlight.Add(-1)
+	for {
+		max := s.counters.maxInFlight.Load()
+		if current <= max || s.counters.maxInFlight.CompareAndSwap(max, current) {
+			break
 		}
-		s.mu.Unlock()
 	}
-	return rc, err
+	time.Sleep(s.delay)
+}
+
+func (s *blockingStorage) GetObject(ctx context.Context, storeBox string, fileName string) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *blockingStorage) PutObject(ctx context.Context, storeBox string, fileName string, reader io.Reader) error {
+	s.call()
+	return nil
 }
 
-func (s *spyClient) PutObject(ctx context.Context, box, key string, r io.Reader) error {
-	return s.inner.PutObject(ctx, box, key, r)
+func (s *blockingStorage) RemoveObject(ctx context.Context, storeBox string, fileName string) error {
+	s.call()
+	return nil
 }
-func (s *spyClient) RemoveObject(ctx context.Context, box, key string) error {
-	return s.inner.RemoveObject(ctx, box, key)
+
+func (s *blockingStorage) ExistObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
+	return false, nil
 }
 
-func (s *spyClient) ExistObject(ctx context.Context, storeBox string, fileName string) (bool, error) {
-	return s.inner.ExistObject(ctx, storeBox, fileName)
+func (s *blockingStorage) GetConnectionProperties() common.ConnectionProperties {
+	return common.ConnectionProperties{IsMainInstance: true, Label: s.label}
 }
@@ -0,0 +1,485 @@
+package memory_operation_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/filestorage/filestoragetest"
+)
+
+// newTestClient returns a MemoryClient with the given properties holding the test-bucket.
+func newTestClient(t *testing.T, properties common.ConnectionProperties) *filestorage.MemoryClient {
+	client := filestorage.NewMemoryClient(properties)
+	require.NoError(t, client.MakeBucket(context.TODO(), "test-bucket"))
+	return client
+}
+
+// TestMemoryClient_Buckets verifies that store boxes can be created, listed and removed,
+// and that duplicated or missing boxes are reported.
+func TestMemoryClient_Buckets(t *testing.T) {
+	client := newTestClient(t, common.ConnectionProperties{})
+
+	err := client.MakeBucket(context.TODO(), "test-bucket")
+	assert.Error(t, err, "expected error for existing bucket")
+
+	require.NoError(t, client.MakeBucket(context.TODO(), "test-bucket-2"))
+
+	buckets, err := client.ListBuckets(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test-bucket", "test-bucket-2"}, buckets)
+
+	require.NoError(t, client.RemoveBucket(context.TODO(), "test-bucket-2"))
+	err = client.RemoveBucket(context.TODO(), "test-bucket-2")
+	assert.ErrorIs(t, err, filestorage.ErrBoxNotFound)
+}
+
+// TestMemoryClient_Objects verifies put, get, existence and removal of objects with every
+// combination of transforms.
+func TestMemoryClient_Objects(t *testing.T) {
+	properties := map[string]common.ConnectionProperties{
+		"plain":      {},
+		"gzip":       {SaveCompress: common.GZIP_COMPRESSION},
+		"encoding":   {SaveCompress: common.GZIP_CONTENT_ENCODING},
+		"aes":        {SaveEncrypt: common.AES256_ENCRYPTION, EncryptKey: "m2cs"},
+		"gzip + aes": {SaveCompress: common.GZIP_COMPRESSION, SaveEncrypt: common.AES256_ENCRYPTION, EncryptKey: "m2cs"},
+	}
+
+	for name, props := range properties {
+		t.Run(name, func(t *testing.T) {
+			client := newTestClient(t, props)
+
+			err := client.PutObject(context.TODO(), "test-bucket", "object.txt", strings.NewReader("test content"))
+			require.NoError(t, err)
+
+			reader, err := client.GetObject(context.TODO(), "test-bucket", "object.txt")
+			require.NoError(t, err)
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, "test content", string(data))
+
+			exists, err := client.ExistObject(context.TODO(), "test-bucket", "object.txt")
+			require.NoError(t, err)
+			assert.True(t, exists)
+
+			require.NoError(t, client.RemoveObject(context.TODO(), "test-bucket", "object.txt"))
+
+			exists, err = client.ExistObject(context.TODO(), "test-bucket", "object.txt")
+			require.NoError(t, err)
+			assert.False(t, exists)
+		})
+	}
+}
+
+// TestMemoryClient_Conformance runs the FileStorage conformance suite with and without transforms.
+func TestMemoryClient_Conformance(t *testing.T) {
+	properties := map[string]common.ConnectionProperties{
+		"plain":      {},
+		"gzip + aes": {SaveCompress: common.GZIP_COMPRESSION, SaveEncrypt: common.AES256_ENCRYPTION, EncryptKey: "m2cs"},
+	}
+
+	for name, props := range properties {
+		t.Run(name, func(t *testing.T) {
+			filestoragetest.RunConformance(t, func(t *testing.T) filestorage.FileStorage {
+				return filestorage.NewMemoryClient(props)
+			})
+		})
+	}
+}
+
+// TestMemoryClient_Errors verifies that missing store boxes and objects are reported.
+func TestMemoryClient_Errors(t *testing.T) {
+	client := newTestClient(t, common.ConnectionProperties{})
+
+	err := client.PutObject(context.TODO(), "non-existent-bucket", "object.txt", strings.NewReader("test"))
+	assert.ErrorIs(t, err, filestorage.ErrBoxNotFound)
+
+	_, err = client.GetObject(context.TODO(), "test-bucket", "non-existent.txt")
+	assert.ErrorIs(t, err, filestorage.ErrObjectNotFound)
+
+	err = client.RemoveObject(context.TODO(), "test-bucket", "non-existent.txt")
+	assert.ErrorIs(t, err, filestorage.ErrObjectNotFound)
+
+	_, err = client.ExistObject(context.TODO(), "non-existent-bucket", "object.txt")
+	assert.ErrorIs(t, err, filestorage.ErrBoxNotFound)
+}
+
+// TestMemoryClient_GetObjectRange verifies ranged reads, which are refused when
+// transforms are configured.
+func TestMemoryClient_GetObjectRange(t *testing.T) {
+	client := newTestClient(t, common.ConnectionProperties{})
+	require.NoError(t, client.PutObject(context.TODO(), "test-bucket", "object.txt", strings.NewReader("0123456789")))
+
+	reader, err := client.GetObjectRange(context.TODO(), "test-bucket", "object.txt", 2, 4)
+	require.NoError(t, err)
+	data, _ := io.ReadAll(reader)
+	assert.Equal(t, "2345", string(data))
+
+	reader, err = client.GetObjectRange(context.TODO(), "test-bucket", "object.txt", 7, 0)
+	require.NoError(t, err)
+	data, _ = io.ReadAll(reader)
+	assert.Equal(t, "789", string(data))
+
+	gzipClient := newTestClient(t, common.ConnectionProperties{SaveCompress: common.GZIP_COMPRESSION})
+	_, err = gzipClient.GetObjectRange(context.TODO(), "test-bucket", "object.txt", 0, 1)
+	assert.ErrorIs(t, err, filestorage.ErrRangeNotSupported)
+}
+
+// TestMemoryClient_GetObjectWithInfo verifies that the logical size is reported for plain
+// and gzip-stored objects, and is unknown for transformed objects written without it.
+// The metadata passed to the put is copied, not modified.
+func TestMemoryClient_GetObjectWithInfo(t *testing.T) {
+	content := strings.Repeat("m2cs ", 100)
+
+	for name, properties := range map[string]common.ConnectionProperties{
+		"plain": {},
+		"gzip":  {SaveCompress: common.GZIP_COMPRESSION},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := newTestClient(t, properties)
+			metadata := map[string]string{"owner": "m2cs"}
+			require.NoError(t, client.PutObjectWithOptions(context.TODO(), "test-bucket", "object.txt", strings.NewReader(content),
+				filestorage.PutOptions{ContentType: "text/plain", Metadata: metadata}))
+			assert.Equal(t, map[string]string{"owner": "m2cs"}, metadata, "the metadata of the caller must not be modified")
+
+			reader, stat, err := client.GetObjectWithInfo(context.TODO(), "test-bucket", "object.txt")
+			require.NoError(t, err)
+			data, _ := io.ReadAll(reader)
+			assert.Equal(t, content, string(data))
+			assert.Equal(t, int64(len(content)), stat.Size)
+			assert.Equal(t, "object.txt", stat.Key)
+			assert.Equal(t, "text/plain", stat.ContentType)
+			assert.NotEmpty(t, stat.ETag)
+		})
+	}
+
+	gzipClient := newTestClient(t, common.ConnectionProperties{SaveCompress: common.GZIP_COMPRESSION})
+	require.NoError(t, gzipClient.PutObject(context.TODO(), "test-bucket", "object.txt", io.MultiReader(strings.NewReader(content))))
+	_, stat, err := gzipClient.GetObjectWithInfo(context.TODO(), "test-bucket", "object.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), stat.Size)
+
+	_, _, err = gzipClient.GetObjectWithInfo(context.TODO(), "test-bucket", "missing.txt")
+	assert.ErrorIs(t, err, filestorage.ErrObjectNotFound)
+}
+
+// TestMemoryClient_EmptyObject verifies that empty objects are stored empty whatever the
+// transforms, so that their size shows it, and read back empty.
+func TestMemoryClient_EmptyObject(t *testing.T) {
+	for name, properties := range map[string]common.ConnectionProperties{
+		"gzip":       {SaveCompress: common.GZIP_COMPRESSION},
+		"aes":        {SaveEncrypt: common.AES256_ENCRYPTION, EncryptKey: "m2cs"},
+		"gzip + aes": {SaveCompress: common.GZIP_COMPRESSION, SaveEncrypt: common.AES256_ENCRYPTION, EncryptKey: "m2cs"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := newTestClient(t, properties)
+			payloads := map[string]io.Reader{
+				"sized":  strings.NewReader(""),
+				"stream": io.MultiReader(),
+				"dir/":   bytes.NewReader(nil),
+			}
+			for key, payload := range payloads {
+				require.NoError(t, client.PutObject(context.TODO(), "test-bucket", key, payload))
+
+				stat, err := client.StatObject(context.TODO(), "test-bucket", key)
+				require.NoError(t, err)
+				assert.Zero(t, stat.Size, "%q should be stored empty", key)
+
+				reader, stat, err := client.GetObjectWithInfo(context.TODO(), "test-bucket", key)
+				require.NoError(t, err)
+				data, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				assert.Empty(t, data)
+				assert.Zero(t, stat.Size, "%q should have a logical size of 0", key)
+			}
+
+			// a payload of unknown size is still transformed once found not empty
+			content := strings.Repeat("m2cs ", 100)
+			require.NoError(t, client.PutObject(context.TODO(), "test-bucket", "object", io.MultiReader(strings.NewReader(content))))
+			reader, err := client.GetObject(context.TODO(), "test-bucket", "object")
+			require.NoError(t, err)
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, content, string(data))
+			stat, err := client.StatObject(context.TODO(), "test-bucket", "object")
+			require.NoError(t, err)
+			assert.NotEqual(t, int64(len(content)), stat.Size, "the object should be stored transformed")
+		})
+	}
+}
+
+// TestMemoryClient_ListObjectsInfo verifies that objects are listed by prefix, sorted by key.
+func TestMemoryClient_ListObjectsInfo(t *testing.T) {
+	client := newTestClient(t, common.ConnectionProperties{})
+	for _, key := range []string{"b/2.txt", "a/1.txt", "b/1.txt"} {
+		require.NoError(t, client.PutObject(context.TODO(), "test-bucket", key, strings.NewReader(key)))
+	}
+
+	infos, err := client.ListObjectsInfo(context.TODO(), "test-bucket", "b/")
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "b/1.txt", infos[0].Key)
+	assert.Equal(t, "b/2.txt", infos[1].Key)
+	assert.Equal(t, int64(len("b/1.txt")), infos[0].Size)
+	assert.NotEmpty(t, infos[0].ETag)
+}
+
+// TestMemoryClient_PollWatch verifies that PollWatch reports the objects created, overwritten
+// and removed between two listings, but not the objects existing when the watch starts.
+func TestMemoryClient_PollWatch(t *testing.T) {
+	client := newTestClient(t, common.ConnectionProperties{Label: "memory"})
+	require.NoError(t, client.PutObject(context.TODO(), "test-bucket", "existing.txt", strings.NewReader("existing")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := filestorage.PollWatch(ctx, client, "memory", "test-bucket", nil, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	require.NoError(t, client.PutObject(context.TODO(), "test-bucket", "new.txt", strings.NewReader("new")))
+	event := receiveEvent(t, events)
+	assert.Equal(t, filestorage.ObjectEvent{Label: "memory", StoreBox: "test-bucket", Key: "new.txt", Type: common.OBJECT_CREATED, Time: event.Time}, event)
+
+	require.NoError(t, client.PutObject(context.TODO(), "test-bucket", "existing.txt", strings.NewReader("overwritten")))
+	event = receiveEvent(t, events)
+	assert.Equal(t, "existing.txt", event.Key)
+	assert.Equal(t, common.OBJECT_CREATED, event.Type, "an overwrite should be reported as a creation")
+
+	require.NoError(t, client.RemoveObject(context.TODO(), "test-bucket", "new.txt"))
+	event = receiveEvent(t, events)
+	assert.Equal(t, "new.txt", event.Key)
+	assert.Equal(t, common.OBJECT_REMOVED, event.Type)
+
+	cancel()
+	for range events {
+	}
+}
+
+// TestMemoryClient_PollWatch_Filter verifies that only the watched event types are reported.
+func TestMemoryClient_PollWatch_Filter(t *testing.T) {
+	client := newTestClient(t, common.ConnectionProperties{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := filestorage.PollWatch(ctx, client, "memory", "test-bucket", []common.EventType{common.OBJECT_REMOVED}, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	require.NoError(t, client.PutObject(context.TODO(), "test-bucket", "object.txt", strings.NewReader("content")))
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, client.RemoveObject(context.TODO(), "test-bucket", "object.txt"))
+
+	event := receiveEvent(t, events)
+	assert.Equal(t, common.OBJECT_REMOVED, event.Type, "creations should not be reported")
+
+	_, err = filestorage.PollWatch(ctx, client, "memory", "missing-bucket", nil, 0)
+	assert.ErrorIs(t, err, filestorage.ErrBoxNotFound)
+}
+
+// receiveEvent waits for the next event on events.
+func receiveEvent(t *testing.T, events <-chan filestorage.ObjectEvent) filestorage.ObjectEvent {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "expected an event, the channel was closed")
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an event")
+		return filestorage.ObjectEvent{}
+	}
+}
+
+// countingReader counts the bytes read from the wrapped reader.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+// TestMemoryClient_IdempotencyKey verifies that a write with the idempotency key of the stored
+// object is skipped without reading the payload, and that a different key overwrites it.
+func TestMemoryClient_IdempotencyKey(t *testing.T) {
+	client := newTestClient(t, common.ConnectionProperties{SaveCompress: common.GZIP_COMPRESSION})
+	put := func(content, key string) int {
+		r := &countingReader{Reader: strings.NewReader(content)}
+		err := client.PutObjectWithOptions(context.TODO(), "test-bucket", "object.txt", r, filestorage.PutOptions{
+			IdempotencyKey: key,
+			Metadata:       map[string]string{"owner": "m2cs"},
+		})
+		require.NoError(t, err)
+		return r.n
+	}
+	content := func() string {
+		reader, err := client.GetObject(context.TODO(), "test-bucket", "object.txt")
+		require.NoError(t, err)
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	assert.Equal(t, len("first"), put("first", "key-1"))
+	assert.Equal(t, 0, put("retry", "key-1"), "the payload of a duplicate write must not be read")
+	assert.Equal(t, "first", content())
+
+	assert.Equal(t, len("second"), put("second", "key-2"))
+	assert.Equal(t, "second", content())
+
+	// writes without a key are always applied
+	require.NoError(t, client.PutObject(context.TODO(), "test-bucket", "object.txt", strings.NewReader("third")))
+	assert.Equal(t, len("fourth"), put("fourth", "key-2"))
+	assert.Equal(t, "fourth", content())
+}
+
+// TestMemoryClient_RetentionUnsupported verifies that writes with a retention are refused,
+// as the memory client cannot lock objects.
+func TestMemoryClient_RetentionUnsupported(t *testing.T) {
+	client := newTestClient(t, common.ConnectionProperties{})
+
+	err := client.PutObjectWithOptions(context.TODO(), "test-bucket", "object.txt", strings.NewReader("data"), filestorage.PutOptions{
+		Retention: filestorage.Retention{Mode: common.GOVERNANCE_RETENTION, Until: time.Now().Add(time.Hour)},
+	})
+	assert.ErrorIs(t, err, filestorage.ErrObjectLockUnsupported)
+
+	exists, err := client.ExistObject(context.TODO(), "test-bucket", "object.txt")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+// TestMemoryClient_PutObjectSize verifies that objects are written the same with and without a size,
+// from seekable and non-seekable readers, with and without transforms.
+func TestMemoryClient_PutObjectSize(t *testing.T) {
+	const content = "hello m2cs"
+	properties := map[string]common.ConnectionProperties{
+		"plain":      {},
+		"compressed": {SaveCompress: common.GZIP_COMPRESSION},
+	}
+	readers := map[string]func() io.Reader{
+		"seekable":     func() io.Reader { return strings.NewReader(content) },
+		"non-seekable": func() io.Reader { return &countingReader{Reader: strings.NewReader(content)} },
+	}
+
+	for name, props := range properties {
+		for kind, newReader := range readers {
+			for _, size := range []int64{0, int64(len(content))} {
+				t.Run(fmt.Sprintf("%s/%s/size=%d", name, kind, size), func(t *testing.T) {
+					client := newTestClient(t, props)
+					err := client.PutObjectWithOptions(context.TODO(), "test-bucket", "object.txt", newReader(), filestorage.PutOptions{Size: size})
+					require.NoError(t, err)
+
+					reader, err := client.GetObject(context.TODO(), "test-bucket", "object.txt")
+					require.NoError(t, err)
+					defer reader.Close()
+					data, err := io.ReadAll(reader)
+					require.NoError(t, err)
+					assert.Equal(t, content, string(data))
+				})
+			}
+		}
+	}
+
+	client := newTestClient(t, common.ConnectionProperties{})
+	err := client.PutObjectWithOptions(context.TODO(), "test-bucket", "object.txt", strings.NewReader(content), filestorage.PutOptions{Size: 4})
+	assert.ErrorContains(t, err, "expected 4")
+	exists, err := client.ExistObject(context.TODO(), "test-bucket", "object.txt")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+// TestMemoryClient_Checksum verifies that the additional checksum of the stored bytes is reported by StatObject.
+func TestMemoryClient_Checksum(t *testing.T) {
+	client := newTestClient(t, common.ConnectionProperties{})
+	content := []byte("checksummed content")
+
+	err := client.PutObjectWithOptions(context.TODO(), "test-bucket", "object.txt", bytes.NewReader(content), filestorage.PutOptions{
+		ChecksumAlgorithm: common.SHA256_CHECKSUM,
+	})
+	require.NoError(t, err)
+
+	sum := sha256.Sum256(content)
+	stat, err := client.StatObject(context.TODO(), "test-bucket", "object.txt")
+	require.NoError(t, err)
+	assert.Equal(t, map[common.ChecksumAlgorithm]string{common.SHA256_CHECKSUM: base64.StdEncoding.EncodeToString(sum[:])}, stat.Checksums)
+
+	err = client.PutObjectWithOptions(context.TODO(), "test-bucket", "object.txt", bytes.NewReader(content), filestorage.PutOptions{
+		ChecksumAlgorithm: common.ChecksumAlgorithm(42),
+	})
+	assert.ErrorContains(t, err, "unknown checksum algorithm")
+}
+
+// TestMemoryClient_RotateEncryptKey verifies that the cached pipelines are rebuilt with the key
+// set by RotateEncryptKey, also while reads and writes are in flight.
+func TestMemoryClient_RotateEncryptKey(t *testing.T) {
+	ctx := context.TODO()
+	client := newTestClient(t, common.ConnectionProperties{
+		SaveCompress: common.GZIP_COMPRESSION, SaveEncrypt: common.AES256_ENCRYPTION, EncryptKey: "old-key",
+	})
+	var _ filestorage.KeyRotator = client
+
+	read := func(key string) (string, error) {
+		obj, err := client.GetObject(ctx, "test-bucket", key)
+		if err != nil {
+			return "", err
+		}
+		defer obj.Close()
+		data, err := io.ReadAll(obj)
+		return string(data), err
+	}
+
+	// the pipelines are built and cached by the first write and read
+	require.NoError(t, client.PutObject(ctx, "test-bucket", "old.txt", strings.NewReader("old content")))
+	content, err := read("old.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "old content", content)
+
+	assert.Error(t, client.RotateEncryptKey(""))
+	require.NoError(t, client.RotateEncryptKey("new-key"))
+	assert.Equal(t, "new-key", client.GetConnectionProperties().EncryptKey)
+
+	_, err = read("old.txt")
+	assert.ErrorContains(t, err, "decryption failed", "the objects written with the previous key are not readable")
+
+	require.NoError(t, client.PutObject(ctx, "test-bucket", "new.txt", strings.NewReader("new content")))
+	content, err = read("new.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "new content", content)
+
+	// reads and writes racing with rotations use either key, never a mix of the two
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 50 {
+			assert.NoError(t, client.RotateEncryptKey(fmt.Sprintf("key-%d", i%2)))
+		}
+	}()
+	for range 50 {
+		if err := client.PutObject(ctx, "test-bucket", "racy.txt", strings.NewReader("racy content")); err != nil {
+			t.Fatalf("PutObject during rotations failed: %v", err)
+		}
+		if content, err := read("racy.txt"); err == nil {
+			assert.Equal(t, "racy content", content)
+		}
+	}
+	<-done
+
+	require.NoError(t, client.PutObject(ctx, "test-bucket", "racy.txt", strings.NewReader("racy content")))
+	content, err = read("racy.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "racy content", content)
+}
@@ -0,0 +1,83 @@
+package minio_operation_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+
+	"github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// startMirrorRegion starts a MinIO container of its own, standing for a region of a mirrored
+// storage, with the store box "mirror-bucket", and returns it with its client.
+func startMirrorRegion(t *testing.T, label string) (testcontainers.Container, *filestorage.MinioClient) {
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image: "minio/minio:latest",
+			Env: map[string]string{
+				"MINIO_ROOT_USER":     minioUser,
+				"MINIO_ROOT_PASSWORD": minioPassword,
+			},
+			Cmd: []string{"server", "/data"},
+		},
+		Started: true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = testcontainers.TerminateContainer(container) })
+
+	endpoint, err := container.Endpoint(ctx, "http")
+	require.NoError(t, err)
+	client, err := minio.New(strings.Replace(endpoint, "http://", "", 1), &minio.Options{
+		Creds:  credentials.NewStaticV4(minioUser, minioPassword, ""),
+		Secure: false,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.MakeBucket(ctx, "mirror-bucket", minio.MakeBucketOptions{}))
+
+	storage, err := filestorage.NewMinioClient(client, common.ConnectionProperties{Label: label, IsMainInstance: true})
+	require.NoError(t, err)
+	return container, storage
+}
+
+// TestMinioClient_Mirrored checks a MirroredStorage of two MinIO servers writes to both and keeps
+// serving the reads from the secondary once the primary is down.
+func TestMinioClient_Mirrored(t *testing.T) {
+	ctx := context.Background()
+	primaryContainer, primary := startMirrorRegion(t, "eu")
+	_, secondary := startMirrorRegion(t, "us")
+	mirror, err := filestorage.NewMirroredStorage(primary, secondary, filestorage.MirrorOptions{Label: "minio"})
+	require.NoError(t, err)
+
+	require.NoError(t, mirror.PutObject(ctx, "mirror-bucket", "object.txt", strings.NewReader("mirrored")))
+	for _, s := range []filestorage.FileStorage{primary, secondary} {
+		obj, err := s.GetObject(ctx, "mirror-bucket", "object.txt")
+		require.NoError(t, err)
+		data, err := io.ReadAll(obj)
+		obj.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "mirrored", string(data))
+	}
+
+	require.NoError(t, primaryContainer.Stop(ctx, nil))
+	obj, err := mirror.GetObject(ctx, "mirror-bucket", "object.txt")
+	require.NoError(t, err)
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	assert.Equal(t, "mirrored", string(data))
+
+	_, err = mirror.GetObject(ctx, "mirror-bucket", "missing.txt")
+	assert.ErrorIs(t, err, filestorage.ErrObjectNotFound)
+
+	err = mirror.PutObject(ctx, "mirror-bucket", "other.txt", strings.NewReader("data"))
+	assert.Error(t, err, "the primary must be written")
+}
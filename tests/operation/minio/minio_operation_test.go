@@ -1,13 +1,19 @@
 package minio_operation_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -17,6 +23,7 @@ import (
 
 	"github.com/tizianocitro/m2cs/pkg"
 	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/filestorage/filestoragetest"
 )
 
 var (
@@ -178,6 +185,38 @@ func TestMinioClient_GetObject_Success(t *testing.T) {
 	assert.Contains(t, string(buf), "test", "expected object content to be 'test'")
 }
 
+// TestMinioClient_GetObjectWithInfo_Success verifies that GetObjectWithInfo reports the
+// size of the content read, for a plain object and for a gzip-stored one.
+func TestMinioClient_GetObjectWithInfo_Success(t *testing.T) {
+	content := strings.Repeat("test ", 100)
+
+	for name, properties := range map[string]common.ConnectionProperties{
+		"plain": {},
+		"gzip":  {SaveCompress: common.GZIP_COMPRESSION},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, err := filestorage.NewMinioClient(minioClient, properties)
+			require.NoError(t, err)
+
+			key := "info-" + name + ".txt"
+			require.NoError(t, client.PutObjectWithOptions(context.TODO(), "test-bucket", key, strings.NewReader(content),
+				filestorage.PutOptions{ContentType: "text/plain"}))
+
+			reader, stat, err := client.GetObjectWithInfo(context.TODO(), "test-bucket", key)
+			require.NoError(t, err)
+			defer reader.Close()
+
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, content, string(data))
+			assert.Equal(t, int64(len(content)), stat.Size)
+			assert.Equal(t, "text/plain", stat.ContentType)
+			assert.NotEmpty(t, stat.ETag)
+			assert.False(t, stat.LastModified.IsZero())
+		})
+	}
+}
+
 // TestMinioClient_PutObject_MinioError verifies that the PutObject method
 // of the MinioClient wrapper correctly returns errors from the original MinIO client.
 // This test uses the scenario where an attempt is made to insert an object into a non-existent bucket,
@@ -211,6 +250,60 @@ func TestMinioClient_PutObject_Success(t *testing.T) {
 	assert.Contains(t, string(buf), "put-test", "expected object content to be 'put-test'")
 }
 
+// TestMinioClient_UploadParallel_Success uploads a 12 MB object in parts of 5 MB and verifies
+// that it is stored as a multipart object with the uploaded content, and that no incomplete
+// upload is left behind.
+func TestMinioClient_UploadParallel_Success(t *testing.T) {
+	content := bytes.Repeat([]byte("m2cs"), 3<<20)
+
+	err := testClient.UploadParallel(context.TODO(), "test-bucket", "parallel.bin", bytes.NewReader(content), int64(len(content)),
+		filestorage.ParallelOptions{PartSize: 5 << 20, Concurrency: 3})
+	require.NoError(t, err)
+
+	info, err := minioClient.StatObject(context.TODO(), "test-bucket", "parallel.bin", minio.StatObjectOptions{})
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(info.ETag, "-3"), "expected the ETag of a 3-part upload, got %s", info.ETag)
+
+	reader, err := testClient.GetObject(context.TODO(), "test-bucket", "parallel.bin")
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, data), "downloaded content differs from the uploaded one")
+
+	for upload := range minioClient.ListIncompleteUploads(context.TODO(), "test-bucket", "parallel.bin", true) {
+		t.Errorf("unexpected incomplete upload %s", upload.UploadID)
+	}
+}
+
+// TestMinioClient_AbortIncompleteUploads creates an incomplete multipart upload with the MinIO SDK
+// and verifies that it is listed, kept while younger than olderThan, and then aborted.
+func TestMinioClient_AbortIncompleteUploads(t *testing.T) {
+	core := minio.Core{Client: minioClient}
+	uploadID, err := core.NewMultipartUpload(context.TODO(), "test-bucket", "abandoned.bin", minio.PutObjectOptions{})
+	require.NoError(t, err)
+	_, err = core.PutObjectPart(context.TODO(), "test-bucket", "abandoned.bin", uploadID, 1, strings.NewReader("part"), int64(len("part")), minio.PutObjectPartOptions{})
+	require.NoError(t, err)
+
+	uploads, err := testClient.ListIncompleteUploads(context.TODO(), "test-bucket")
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+	assert.Equal(t, "abandoned.bin", uploads[0].Key)
+	assert.Equal(t, uploadID, uploads[0].UploadID)
+
+	aborted, err := testClient.AbortIncompleteUploads(context.TODO(), "test-bucket", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, aborted, "expected a recent upload to be kept")
+
+	aborted, err = testClient.AbortIncompleteUploads(context.TODO(), "test-bucket", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, aborted)
+
+	uploads, err = testClient.ListIncompleteUploads(context.TODO(), "test-bucket")
+	require.NoError(t, err)
+	assert.Empty(t, uploads)
+}
+
 // TestMinioClient_PutObject_MinioError verifies that the PutObject method
 // of the MinioClient wrapper correctly returns errors from the original MinIO client.
 // This test uses the scenario where an attempt is made to insert an object into a non-existent bucket,
@@ -232,6 +325,227 @@ func TestMinioClient_RemoveObject_Success(t *testing.T) {
 	require.ErrorContains(t, err, "The specified key does not exist.", "expected error for non-existent object, got nil")
 }
 
+// TestMinioClient_BoxLifecycle_Success verifies that the lifecycle rules set on a bucket
+// read back equal, with the IDs of unnamed rules filled in, and that an empty slice removes them.
+func TestMinioClient_BoxLifecycle_Success(t *testing.T) {
+	rules := []filestorage.LifecycleRule{
+		{ID: "expire-logs", Prefix: "logs/", ExpireAfterDays: 30},
+		{Prefix: "tmp/", ExpireAfterDays: 1},
+	}
+
+	err := testClient.SetBoxLifecycle(context.TODO(), "test-bucket", rules)
+	require.NoError(t, err, "expected no error when setting the lifecycle, got error")
+
+	got, err := testClient.GetBoxLifecycle(context.TODO(), "test-bucket")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []filestorage.LifecycleRule{
+		{ID: "expire-logs", Prefix: "logs/", ExpireAfterDays: 30},
+		{ID: "m2cs-rule-1", Prefix: "tmp/", ExpireAfterDays: 1},
+	}, got)
+
+	err = testClient.SetObjectTier(context.TODO(), "test-bucket", "object.txt", common.ARCHIVE_TIER)
+	assert.ErrorIs(t, err, filestorage.ErrTierNotSupported, "expiration rules should not enable tiering")
+
+	err = testClient.SetBoxLifecycle(context.TODO(), "test-bucket", nil)
+	require.NoError(t, err, "expected no error when removing the lifecycle, got error")
+
+	got, err = testClient.GetBoxLifecycle(context.TODO(), "test-bucket")
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+// TestMinioClient_BoxLifecycle_InvalidRule verifies that rules without any action are
+// rejected before contacting MinIO.
+func TestMinioClient_BoxLifecycle_InvalidRule(t *testing.T) {
+	err := testClient.SetBoxLifecycle(context.TODO(), "test-bucket", []filestorage.LifecycleRule{{Prefix: "logs/"}})
+
+	require.Error(t, err, "expected error for a rule without actions, got nil")
+	assert.ErrorContains(t, err, "no expiration nor transition")
+}
+
+// TestMinioClient_Watch_Success verifies that the creation and the removal of an object
+// are reported by the bucket notifications of MinIO.
+func TestMinioClient_Watch_Success(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	events, err := testClient.Watch(ctx, "test-bucket", nil)
+	require.NoError(t, err)
+
+	// the listener connects in the background, so the put is retried until it is reported
+	var created filestorage.ObjectEvent
+	for received := false; !received; {
+		err := testClient.PutObject(ctx, "test-bucket", "watched.txt", strings.NewReader("watched"))
+		require.NoError(t, err)
+
+		select {
+		case created = <-events:
+			received = true
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			t.Fatal("expected a creation event")
+		}
+	}
+
+	assert.Equal(t, common.OBJECT_CREATED, created.Type)
+	assert.Equal(t, "watched.txt", created.Key)
+	assert.Equal(t, "test-bucket", created.StoreBox)
+
+	err = testClient.RemoveObject(ctx, "test-bucket", "watched.txt")
+	require.NoError(t, err)
+
+	for {
+		select {
+		case event := <-events:
+			if event.Type != common.OBJECT_REMOVED {
+				continue // creations of the retried puts
+			}
+			assert.Equal(t, "watched.txt", event.Key)
+			return
+		case <-ctx.Done():
+			t.Fatal("expected a removal event")
+		}
+	}
+}
+
+// TestMinioClient_SetBoxPublicRead_Success verifies that the objects of a public bucket can be
+// fetched anonymously with a plain HTTP client, and that revoking the access denies them again.
+func TestMinioClient_SetBoxPublicRead_Success(t *testing.T) {
+	err := testClient.MakeBucket(context.TODO(), "public-bucket")
+	require.NoError(t, err)
+	err = testClient.PutObject(context.TODO(), "public-bucket", "asset.txt", strings.NewReader("public asset"))
+	require.NoError(t, err)
+
+	objectURL := minioEndpoint + "/public-bucket/asset.txt"
+
+	resp, err := http.Get(objectURL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "expected anonymous access to be denied by default")
+
+	err = testClient.SetBoxPublicRead(context.TODO(), "public-bucket", true)
+	require.NoError(t, err, "expected no error when making the bucket public, got error")
+
+	access, err := testClient.GetBoxAccess(context.TODO(), "public-bucket")
+	require.NoError(t, err)
+	assert.Equal(t, filestorage.BoxAccess{PublicRead: true}, access)
+
+	resp, err = http.Get(objectURL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "public asset", string(body))
+
+	err = testClient.SetBoxPublicRead(context.TODO(), "public-bucket", false)
+	require.NoError(t, err, "expected no error when making the bucket private, got error")
+
+	access, err = testClient.GetBoxAccess(context.TODO(), "public-bucket")
+	require.NoError(t, err)
+	assert.Equal(t, filestorage.BoxAccess{}, access)
+
+	resp, err = http.Get(objectURL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "expected anonymous access to be denied again")
+}
+
+// TestMinioClient_ObjectLock_Success verifies that an object written with a retention in a bucket
+// with object lock reports it on stat, that deleting its version is refused, and that the legal
+// hold can be placed and removed.
+func TestMinioClient_ObjectLock_Success(t *testing.T) {
+	err := minioClient.MakeBucket(context.TODO(), "locked-bucket", minio.MakeBucketOptions{ObjectLocking: true})
+	require.NoError(t, err)
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	err = testClient.PutObjectWithOptions(context.TODO(), "locked-bucket", "record.txt", strings.NewReader("audit record"), filestorage.PutOptions{
+		Retention: filestorage.Retention{Mode: common.GOVERNANCE_RETENTION, Until: until},
+	})
+	require.NoError(t, err, "expected no error when writing with a retention, got error")
+
+	stat, err := testClient.StatObject(context.TODO(), "locked-bucket", "record.txt")
+	require.NoError(t, err)
+	assert.Equal(t, common.GOVERNANCE_RETENTION, stat.Lock.Retention.Mode)
+	assert.True(t, until.Equal(stat.Lock.Retention.Until), "expected retention until %v, got %v", until, stat.Lock.Retention.Until)
+	assert.False(t, stat.Lock.LegalHold)
+
+	info, err := minioClient.StatObject(context.TODO(), "locked-bucket", "record.txt", minio.StatObjectOptions{})
+	require.NoError(t, err)
+	err = minioClient.RemoveObject(context.TODO(), "locked-bucket", "record.txt", minio.RemoveObjectOptions{VersionID: info.VersionID})
+	require.Error(t, err, "expected the deletion of a retained version to be refused")
+
+	require.NoError(t, testClient.SetObjectLegalHold(context.TODO(), "locked-bucket", "record.txt", true))
+	stat, err = testClient.StatObject(context.TODO(), "locked-bucket", "record.txt")
+	require.NoError(t, err)
+	assert.True(t, stat.Lock.LegalHold)
+
+	require.NoError(t, testClient.SetObjectLegalHold(context.TODO(), "locked-bucket", "record.txt", false))
+	stat, err = testClient.StatObject(context.TODO(), "locked-bucket", "record.txt")
+	require.NoError(t, err)
+	assert.False(t, stat.Lock.LegalHold)
+}
+
+// TestMinioClient_Checksum verifies that the additional checksums are sent as trailers and
+// stored with the object, and that they require a client with trailing headers.
+func TestMinioClient_Checksum(t *testing.T) {
+	trailing, err := minio.New(strings.TrimPrefix(minioEndpoint, "http://"), &minio.Options{
+		Creds:           credentials.NewStaticV4(minioUser, minioPassword, ""),
+		TrailingHeaders: true,
+	})
+	require.NoError(t, err)
+	client, err := filestorage.NewMinioClient(trailing, common.ConnectionProperties{})
+	require.NoError(t, err)
+
+	content := []byte("checksummed content")
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	crc.Write(content)
+	sha := sha256.Sum256(content)
+	expected := map[common.ChecksumAlgorithm]string{
+		common.CRC32C_CHECKSUM: base64.StdEncoding.EncodeToString(crc.Sum(nil)),
+		common.SHA256_CHECKSUM: base64.StdEncoding.EncodeToString(sha[:]),
+	}
+
+	for algorithm, checksum := range expected {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			err := client.PutObjectWithOptions(context.TODO(), "test-bucket", "checksummed.txt", bytes.NewReader(content), filestorage.PutOptions{
+				ChecksumAlgorithm: algorithm,
+			})
+			require.NoError(t, err)
+
+			stat, err := client.StatObject(context.TODO(), "test-bucket", "checksummed.txt")
+			require.NoError(t, err)
+			assert.Equal(t, checksum, stat.Checksums[algorithm])
+		})
+	}
+
+	err = testClient.PutObjectWithOptions(context.TODO(), "test-bucket", "checksummed.txt", bytes.NewReader(content), filestorage.PutOptions{
+		ChecksumAlgorithm: common.CRC32C_CHECKSUM,
+	})
+	assert.ErrorContains(t, err, "TrailingHeaders")
+}
+
+// TestMinioClient_ObjectLock_Unsupported verifies that object lock operations on a bucket
+// without object lock fail with ErrObjectLockUnsupported, and that nothing is written.
+func TestMinioClient_ObjectLock_Unsupported(t *testing.T) {
+	err := testClient.PutObjectWithOptions(context.TODO(), "test-bucket", "unlocked.txt", strings.NewReader("data"), filestorage.PutOptions{
+		Retention: filestorage.Retention{Mode: common.COMPLIANCE_RETENTION, Until: time.Now().Add(time.Hour)},
+	})
+	assert.ErrorIs(t, err, filestorage.ErrObjectLockUnsupported)
+
+	exists, err := testClient.ExistObject(context.TODO(), "test-bucket", "unlocked.txt")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	err = testClient.SetObjectLegalHold(context.TODO(), "test-bucket", "object.txt", true)
+	assert.ErrorIs(t, err, filestorage.ErrObjectLockUnsupported)
+
+	err = testClient.PutObjectWithOptions(context.TODO(), "test-bucket", "unlocked.txt", strings.NewReader("data"), filestorage.PutOptions{
+		Retention: filestorage.Retention{Mode: common.COMPLIANCE_RETENTION},
+	})
+	assert.ErrorContains(t, err, "has no expiry")
+}
+
 // runAndPopulateMinIOContainer starts the MinIO container and populates it with a test bucket.
 // The bucket created in this function is used to test methods where an actual connection is made,
 // to see if the connections can find the bucket.
@@ -301,3 +615,10 @@ func runAndPopulateMinIOContainer(ctx context.Context) {
 		log.Fatalf("failed to create MinIO client: %s", err.Error())
 	}
 }
+
+// TestMinioClient_Conformance runs the FileStorage conformance suite against MinIO.
+func TestMinioClient_Conformance(t *testing.T) {
+	filestoragetest.RunConformance(t, func(t *testing.T) filestorage.FileStorage {
+		return testClient
+	})
+}
@@ -0,0 +1,177 @@
+package redis_operation_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/cache/redis"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+var (
+	redisContainer testcontainers.Container
+	redisAddr      string
+)
+
+// TestMain sets up the Redis container as a test dependency. Once the tests are run,
+// the container is terminated to ensure proper cleanup.
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+
+	runRedisContainer(ctx)
+	defer func() {
+		if err := testcontainers.TerminateContainer(redisContainer); err != nil {
+			log.Printf("failed to terminate Redis container: %s", err)
+		}
+	}()
+
+	code := m.Run()
+	os.Exit(code)
+}
+
+// TestRedisClient_Operations verifies that objects can be set, read, deleted and cleared,
+// that they expire with their TTL and that Clear leaves the keys outside the prefix untouched.
+func TestRedisClient_Operations(t *testing.T) {
+	ctx := context.Background()
+	client := redis.New(&redis.UniversalOptions{Addrs: []string{redisAddr}}, redis.Options{KeyPrefix: "operations:"})
+	defer client.Close()
+	other := redis.New(&redis.UniversalOptions{Addrs: []string{redisAddr}}, redis.Options{KeyPrefix: "other:"})
+	defer other.Close()
+
+	require.NoError(t, client.Ping(ctx))
+
+	_, found, err := client.Get(ctx, "test-bucket/missing.txt")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, client.Set(ctx, "test-bucket/a.txt", []byte("a\r\nb"), 0))
+	data, found, err := client.Get(ctx, "test-bucket/a.txt")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "a\r\nb", string(data))
+
+	require.NoError(t, client.Set(ctx, "test-bucket/empty.txt", []byte{}, 0))
+	data, found, err = client.Get(ctx, "test-bucket/empty.txt")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Empty(t, data)
+
+	require.NoError(t, client.Delete(ctx, "test-bucket/a.txt"))
+	_, found, err = client.Get(ctx, "test-bucket/a.txt")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, client.Set(ctx, "test-bucket/ttl.txt", []byte("ttl"), 100*time.Millisecond))
+	assert.Eventually(t, func() bool {
+		_, found, err := client.Get(ctx, "test-bucket/ttl.txt")
+		return err == nil && !found
+	}, 5*time.Second, 50*time.Millisecond, "expected the object to expire")
+
+	for _, key := range []string{"x", "y", "z"} {
+		require.NoError(t, client.Set(ctx, key, []byte(key), 0))
+	}
+	require.NoError(t, other.Set(ctx, "x", []byte("other"), 0))
+	require.NoError(t, client.Clear(ctx))
+
+	for _, key := range []string{"x", "y", "z"} {
+		_, found, err := client.Get(ctx, key)
+		require.NoError(t, err)
+		assert.False(t, found, "expected %s to be cleared", key)
+	}
+	data, found, err = other.Get(ctx, "x")
+	require.NoError(t, err)
+	assert.True(t, found, "expected the keys of another prefix to be kept")
+	assert.Equal(t, "other", string(data))
+}
+
+// TestRedisClient_WrongPassword verifies that a failed authentication is reported.
+func TestRedisClient_WrongPassword(t *testing.T) {
+	client := redis.New(&redis.UniversalOptions{Addrs: []string{redisAddr}, Password: "wrong"}, redis.Options{})
+	defer client.Close()
+
+	assert.Error(t, client.Ping(context.Background()))
+}
+
+// TestFileClient_SharedCache verifies that two FileClients sharing a Redis cache serve the objects
+// cached by each other, and that a put on one of them invalidates the copy read by the other.
+func TestFileClient_SharedCache(t *testing.T) {
+	ctx := context.Background()
+	storage := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+	require.NoError(t, storage.MakeBucket(ctx, "test-bucket"))
+
+	backend := redis.New(&redis.UniversalOptions{Addrs: []string{redisAddr}}, redis.Options{KeyPrefix: "shared:"})
+	defer backend.Close()
+
+	newClient := func() *m2cs.FileClient {
+		client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storage)
+		require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{
+			Enabled: true,
+			TTL:     time.Minute,
+			Backend: backend,
+		}))
+		return client
+	}
+	writer, reader := newClient(), newClient()
+
+	require.NoError(t, writer.PutObject(ctx, "test-bucket", "shared.txt", strings.NewReader("v1")))
+	assert.Equal(t, "v1", readObject(t, reader, "shared.txt"))
+
+	// the copy cached by the reader is served to the writer, even once removed from the storage
+	require.NoError(t, storage.RemoveObject(ctx, "test-bucket", "shared.txt"))
+	assert.Equal(t, "v1", readObject(t, writer, "shared.txt"))
+
+	// the put invalidates the copy for both clients
+	require.NoError(t, writer.PutObject(ctx, "test-bucket", "shared.txt", strings.NewReader("v2")))
+	assert.Equal(t, "v2", readObject(t, reader, "shared.txt"))
+	assert.Equal(t, "v2", readObject(t, writer, "shared.txt"))
+
+	writer.ClearCache()
+	_, found, err := backend.Get(ctx, "test-bucket/shared.txt")
+	require.NoError(t, err)
+	assert.False(t, found, "expected ClearCache to clear the backend")
+}
+
+// readObject reads an object of test-bucket through client.
+func readObject(t *testing.T, client *m2cs.FileClient, fileName string) string {
+	obj, err := client.GetObject(context.Background(), "test-bucket", fileName)
+	require.NoError(t, err)
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func runRedisContainer(ctx context.Context) {
+	req := testcontainers.ContainerRequest{
+		Image:        "redis:7-alpine",
+		ExposedPorts: []string{"6379/tcp"},
+		WaitingFor:   wait.ForListeningPort("6379/tcp"),
+	}
+
+	var err error
+	redisContainer, err = testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		log.Fatalf("Error while starting the Redis container: %s", err)
+	}
+
+	redisAddr, err = redisContainer.PortEndpoint(ctx, "6379/tcp", "")
+	if err != nil {
+		log.Fatalf("failed to get Redis endpoint: %s", err)
+	}
+}
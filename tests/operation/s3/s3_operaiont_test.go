@@ -1,13 +1,23 @@
 package s3
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -25,6 +35,7 @@ import (
 	_ "github.com/testcontainers/testcontainers-go/wait"
 	common "github.com/tizianocitro/m2cs/pkg"
 	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/filestorage/filestoragetest"
 )
 
 var (
@@ -209,6 +220,38 @@ func TestS3Client_GetObject_Success(t *testing.T) {
 	assert.Contains(t, string(buf), "test", "expected object content to be 'test'")
 }
 
+// TestS3Client_GetObjectWithInfo_Success verifies that GetObjectWithInfo reports the
+// size of the content read, for a plain object and for a gzip-stored one.
+func TestS3Client_GetObjectWithInfo_Success(t *testing.T) {
+	content := strings.Repeat("test ", 100)
+
+	for name, properties := range map[string]common.ConnectionProperties{
+		"plain": {},
+		"gzip":  {SaveCompress: common.GZIP_COMPRESSION},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, err := filestorage.NewS3Client(s3Client, properties)
+			require.NoError(t, err)
+
+			key := "info-" + name + ".txt"
+			require.NoError(t, client.PutObjectWithOptions(context.TODO(), "test-bucket", key, strings.NewReader(content),
+				filestorage.PutOptions{ContentType: "text/plain"}))
+
+			reader, stat, err := client.GetObjectWithInfo(context.TODO(), "test-bucket", key)
+			require.NoError(t, err)
+			defer reader.Close()
+
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, content, string(data))
+			assert.Equal(t, int64(len(content)), stat.Size)
+			assert.Equal(t, "text/plain", stat.ContentType)
+			assert.NotEmpty(t, stat.ETag)
+			assert.False(t, stat.LastModified.IsZero())
+		})
+	}
+}
+
 // TestS3Client_PutObject_S3Error verifies that the PutObject method
 // of the S3Client wrapper correctly returns errors from the original S3 client.
 // This test uses the scenario where the bucket name provided does not exist in S3.
@@ -248,6 +291,282 @@ func TestS3Client_PutObject_Success(t *testing.T) {
 	assert.Contains(t, string(buf), "test2", "expected object content to be 'test2'")
 }
 
+// TestS3Client_PutObject_GzipContentEncoding verifies that objects saved with
+// GZIP_CONTENT_ENCODING carry the Content-Encoding header when fetched with the
+// AWS SDK, while the S3Client wrapper returns the plaintext content.
+func TestS3Client_PutObject_GzipContentEncoding(t *testing.T) {
+	client, err := filestorage.NewS3Client(s3Client, common.ConnectionProperties{
+		IsMainInstance: true,
+		SaveCompress:   common.GZIP_CONTENT_ENCODING,
+	})
+	require.NoError(t, err)
+
+	// long enough to be worth compressing
+	content := strings.Repeat("test content encoding ", 16)
+	err = client.PutObject(context.TODO(), "test-bucket", "encoded.txt", strings.NewReader(content))
+	require.NoError(t, err, "expected no error when putting object, got error")
+
+	// Verify the raw object with the AWS SDK
+	result, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("encoded.txt"),
+	})
+	require.NoError(t, err, "expected no error when getting object, got error")
+	defer result.Body.Close()
+
+	assert.Equal(t, "gzip", aws.ToString(result.ContentEncoding), "expected Content-Encoding header to be gzip")
+
+	gz, err := gzip.NewReader(result.Body)
+	require.NoError(t, err, "expected raw object to be gzip encoded")
+	raw, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(raw))
+
+	// Verify the object through m2cs
+	reader, err := client.GetObject(context.TODO(), "test-bucket", "encoded.txt")
+	require.NoError(t, err, "expected no error when getting object, got error")
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data), "expected plaintext content without double decompression")
+}
+
+// TestS3Client_PutObject_Checksum verifies that the additional checksums are computed over the
+// stored bytes, compressed ones included, and stored with the object by S3.
+func TestS3Client_PutObject_Checksum(t *testing.T) {
+	client, err := filestorage.NewS3Client(s3Client, common.ConnectionProperties{
+		IsMainInstance: true,
+		SaveCompress:   common.GZIP_COMPRESSION,
+	})
+	require.NoError(t, err)
+
+	for _, algorithm := range []common.ChecksumAlgorithm{common.CRC32C_CHECKSUM, common.SHA1_CHECKSUM, common.SHA256_CHECKSUM} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			err := client.PutObjectWithOptions(context.TODO(), "test-bucket", "checksummed.txt", strings.NewReader("checksummed content"), filestorage.PutOptions{
+				ChecksumAlgorithm: algorithm,
+			})
+			require.NoError(t, err)
+
+			// the checksum covers the compressed bytes, as stored
+			result, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
+				Bucket: aws.String("test-bucket"),
+				Key:    aws.String("checksummed.txt"),
+			})
+			require.NoError(t, err)
+			defer result.Body.Close()
+			stored, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+
+			var h hash.Hash
+			switch algorithm {
+			case common.CRC32C_CHECKSUM:
+				h = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+			case common.SHA1_CHECKSUM:
+				h = sha1.New()
+			default:
+				h = sha256.New()
+			}
+			h.Write(stored)
+
+			stat, err := client.StatObject(context.TODO(), "test-bucket", "checksummed.txt")
+			require.NoError(t, err)
+			assert.Equal(t, base64.StdEncoding.EncodeToString(h.Sum(nil)), stat.Checksums[algorithm])
+		})
+	}
+}
+
+// TestS3Client_SetObjectTier_Success verifies that SetObjectTier changes the storage class
+// recorded by S3, keeping the content of the object, and that StatObject reports it.
+func TestS3Client_SetObjectTier_Success(t *testing.T) {
+	err := testClient.PutObject(context.TODO(), "test-bucket", "tier.txt", strings.NewReader("test tier"))
+	require.NoError(t, err, "expected no error when putting object, got error")
+
+	err = testClient.SetObjectTier(context.TODO(), "test-bucket", "tier.txt", common.COOL_TIER)
+	require.NoError(t, err, "expected no error when setting the tier, got error")
+
+	head, err := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("tier.txt"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, types.StorageClassStandardIa, head.StorageClass, "expected the object to be in STANDARD_IA")
+
+	stat, err := testClient.StatObject(context.TODO(), "test-bucket", "tier.txt")
+	require.NoError(t, err)
+	assert.Equal(t, common.COOL_TIER, stat.TierStatus.Tier)
+	assert.Equal(t, "STANDARD_IA", stat.TierStatus.StorageClass)
+
+	reader, err := testClient.GetObject(context.TODO(), "test-bucket", "tier.txt")
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "test tier", string(data), "expected the content to survive the tier change")
+}
+
+// TestS3Client_RestoreObject_InvalidDays verifies that RestoreObject rejects a
+// non-positive restore period before contacting S3.
+func TestS3Client_RestoreObject_InvalidDays(t *testing.T) {
+	err := testClient.RestoreObject(context.TODO(), "test-bucket", "tier.txt", 0)
+
+	require.Error(t, err, "expected error for non-positive days, got nil")
+	assert.ErrorContains(t, err, "restore days must be positive")
+}
+
+// TestS3Client_BoxLifecycle_Success verifies that the lifecycle rules set on a bucket
+// read back equal, transitions included, and that an empty slice removes them.
+func TestS3Client_BoxLifecycle_Success(t *testing.T) {
+	rules := []filestorage.LifecycleRule{
+		{ID: "archive-reports", Prefix: "reports/", TransitionAfterDays: 30, TargetTier: common.ARCHIVE_TIER, ExpireAfterDays: 365},
+		{ID: "cool-media", Prefix: "media/", TransitionAfterDays: 60, TargetTier: common.COOL_TIER},
+	}
+
+	err := testClient.SetBoxLifecycle(context.TODO(), "test-bucket", rules)
+	require.NoError(t, err, "expected no error when setting the lifecycle, got error")
+
+	got, err := testClient.GetBoxLifecycle(context.TODO(), "test-bucket")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, rules, got)
+
+	err = testClient.SetBoxLifecycle(context.TODO(), "test-bucket", nil)
+	require.NoError(t, err, "expected no error when removing the lifecycle, got error")
+
+	got, err = testClient.GetBoxLifecycle(context.TODO(), "test-bucket")
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+// TestS3Client_Watch_EventQueue verifies that Watch reports the events of the watched bucket
+// received from the event queue, and deletes the processed messages.
+func TestS3Client_Watch_EventQueue(t *testing.T) {
+	client, err := filestorage.NewS3Client(s3Client, common.ConnectionProperties{Label: "s3"})
+	require.NoError(t, err)
+
+	_, err = client.Watch(context.TODO(), "test-bucket", nil)
+	assert.ErrorIs(t, err, filestorage.ErrWatchNotSupported, "expected an error without event queue")
+
+	queue := &fakeEventQueue{messages: make(chan filestorage.QueueMessage, 3)}
+	queue.messages <- filestorage.QueueMessage{Handle: "1", Body: `{"Records":[{"eventName":"ObjectCreated:Put","eventTime":"2024-05-01T10:00:00.000Z","s3":{"bucket":{"name":"other-bucket"},"object":{"key":"other.txt"}}}]}`}
+	queue.messages <- filestorage.QueueMessage{Handle: "2", Body: `{"Records":[{"eventName":"ObjectCreated:Put","eventTime":"2024-05-01T10:00:01.000Z","s3":{"bucket":{"name":"test-bucket"},"object":{"key":"reports/may+2024.csv"}}}]}`}
+	queue.messages <- filestorage.QueueMessage{Handle: "3", Body: `{"Records":[{"eventName":"ObjectRemoved:Delete","eventTime":"2024-05-01T10:00:02.000Z","s3":{"bucket":{"name":"test-bucket"},"object":{"key":"old.csv"}}}]}`}
+	client.SetEventQueue(queue)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := client.Watch(ctx, "test-bucket", nil)
+	require.NoError(t, err)
+
+	created := <-events
+	assert.Equal(t, filestorage.ObjectEvent{
+		Label:    "s3",
+		StoreBox: "test-bucket",
+		Key:      "reports/may 2024.csv",
+		Type:     common.OBJECT_CREATED,
+		Time:     time.Date(2024, 5, 1, 10, 0, 1, 0, time.UTC),
+	}, created)
+
+	removed := <-events
+	assert.Equal(t, "old.csv", removed.Key)
+	assert.Equal(t, common.OBJECT_REMOVED, removed.Type)
+
+	cancel()
+	for range events {
+	}
+	assert.ElementsMatch(t, []string{"1", "2", "3"}, queue.deletedHandles())
+}
+
+// TestS3Client_UploadParallel_Success uploads a 12 MB object in parts of 5 MB and verifies
+// that it is stored as a multipart object with the uploaded content.
+func TestS3Client_UploadParallel_Success(t *testing.T) {
+	content := bytes.Repeat([]byte("m2cs"), 3<<20)
+
+	err := testClient.UploadParallel(context.TODO(), "test-bucket", "parallel.bin", bytes.NewReader(content), int64(len(content)),
+		filestorage.ParallelOptions{PartSize: 5 << 20, Concurrency: 3})
+	require.NoError(t, err)
+
+	head, err := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("parallel.bin"),
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(strings.Trim(aws.ToString(head.ETag), `"`), "-3"), "expected the ETag of a 3-part upload, got %s", aws.ToString(head.ETag))
+
+	reader, err := testClient.GetObject(context.TODO(), "test-bucket", "parallel.bin")
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, data), "downloaded content differs from the uploaded one")
+}
+
+// TestS3Client_UploadParallel_AbortOnFailure makes the upload of the second part fail and
+// verifies that the multipart upload is aborted, leaving no incomplete upload in the bucket.
+func TestS3Client_UploadParallel_AbortOnFailure(t *testing.T) {
+	httpClient := &faultyPartClient{failPart: "2"}
+	client, err := filestorage.NewS3Client(s3.New(s3Client.Options(), func(o *s3.Options) {
+		o.HTTPClient = httpClient
+	}), common.ConnectionProperties{})
+	require.NoError(t, err)
+
+	content := bytes.Repeat([]byte("m2cs"), 3<<20)
+	err = client.UploadParallel(context.TODO(), "test-bucket", "aborted.bin", bytes.NewReader(content), int64(len(content)),
+		filestorage.ParallelOptions{PartSize: 5 << 20, Concurrency: 1})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "induced failure")
+	assert.Equal(t, int32(1), httpClient.aborts.Load(), "expected AbortMultipartUpload to be called once")
+
+	uploads, err := s3Client.ListMultipartUploads(context.TODO(), &s3.ListMultipartUploadsInput{
+		Bucket: aws.String("test-bucket"),
+		Prefix: aws.String("aborted.bin"),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, uploads.Uploads, "expected no incomplete multipart upload")
+
+	_, err = s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("aborted.bin"),
+	})
+	assert.Error(t, err, "expected the object not to exist")
+}
+
+// TestS3Client_AbortIncompleteUploads creates an incomplete multipart upload with the AWS SDK
+// and verifies that it is listed, kept while younger than olderThan, and then aborted.
+func TestS3Client_AbortIncompleteUploads(t *testing.T) {
+	created, err := s3Client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("abandoned.bin"),
+	})
+	require.NoError(t, err)
+	_, err = s3Client.UploadPart(context.TODO(), &s3.UploadPartInput{
+		Bucket:     aws.String("test-bucket"),
+		Key:        aws.String("abandoned.bin"),
+		UploadId:   created.UploadId,
+		PartNumber: aws.Int32(1),
+		Body:       strings.NewReader("part"),
+	})
+	require.NoError(t, err)
+
+	uploads, err := testClient.ListIncompleteUploads(context.TODO(), "test-bucket")
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+	assert.Equal(t, "abandoned.bin", uploads[0].Key)
+	assert.Equal(t, aws.ToString(created.UploadId), uploads[0].UploadID)
+
+	aborted, err := testClient.AbortIncompleteUploads(context.TODO(), "test-bucket", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, aborted, "expected a recent upload to be kept")
+
+	aborted, err = testClient.AbortIncompleteUploads(context.TODO(), "test-bucket", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, aborted)
+
+	uploads, err = testClient.ListIncompleteUploads(context.TODO(), "test-bucket")
+	require.NoError(t, err)
+	assert.Empty(t, uploads)
+}
+
 // TestS3Client_RemoveObject_S3Error verifies that the RemoveObject method
 // of the S3Client wrapper correctly returns errors from the original S3 client.
 // This test uses the scenario where the bucket name provided does not exist in S3.
@@ -392,3 +711,62 @@ func runAndPopulateS3Container(ctx context.Context) {
 		log.Fatalf("failed to create MinIO client: %s", err.Error())
 	}
 }
+
+// fakeEventQueue is an EventQueue serving the messages of a channel.
+type fakeEventQueue struct {
+	messages chan filestorage.QueueMessage
+	mu       sync.Mutex
+	deleted  []string
+}
+
+func (q *fakeEventQueue) Receive(ctx context.Context) ([]filestorage.QueueMessage, error) {
+	select {
+	case msg := <-q.messages:
+		return []filestorage.QueueMessage{msg}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *fakeEventQueue) Delete(ctx context.Context, msg filestorage.QueueMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deleted = append(q.deleted, msg.Handle)
+	return nil
+}
+
+func (q *fakeEventQueue) deletedHandles() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]string(nil), q.deleted...)
+}
+
+// faultyPartClient is an HTTP client rejecting the upload of the part numbered failPart
+// and counting the AbortMultipartUpload requests; other requests are sent as they are.
+type faultyPartClient struct {
+	failPart string
+	aborts   atomic.Int32
+}
+
+func (c *faultyPartClient) Do(req *http.Request) (*http.Response, error) {
+	query := req.URL.Query()
+	if req.Method == http.MethodPut && query.Get("partNumber") == c.failPart {
+		return &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header:     http.Header{"Content-Type": []string{"application/xml"}},
+			Body:       io.NopCloser(strings.NewReader(`<Error><Code>AccessDenied</Code><Message>induced failure</Message></Error>`)),
+			Request:    req,
+		}, nil
+	}
+	if req.Method == http.MethodDelete && query.Has("uploadId") {
+		c.aborts.Add(1)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// TestS3Client_Conformance runs the FileStorage conformance suite against S3.
+func TestS3Client_Conformance(t *testing.T) {
+	filestoragetest.RunConformance(t, func(t *testing.T) filestorage.FileStorage {
+		return testClient
+	})
+}
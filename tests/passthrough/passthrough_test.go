@@ -0,0 +1,192 @@
+package passthrough_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+// spyStorage records the readers written to it and returns the readers it serves.
+type spyStorage struct {
+	*filestorage.MemoryClient
+	written io.Reader
+	served  io.ReadCloser
+}
+
+func (s *spyStorage) PutObject(ctx context.Context, storeBox, fileName string, reader io.Reader) error {
+	s.written = reader
+	return s.MemoryClient.PutObject(ctx, storeBox, fileName, reader)
+}
+
+func (s *spyStorage) GetObject(ctx context.Context, storeBox, fileName string) (io.ReadCloser, error) {
+	obj, err := s.MemoryClient.GetObject(ctx, storeBox, fileName)
+	s.served = obj
+	return obj, err
+}
+
+func newSpy(t *testing.T, label string, main bool) *spyStorage {
+	memory := filestorage.NewMemoryClient(common.ConnectionProperties{Label: label, IsMainInstance: main})
+	require.NoError(t, memory.MakeBucket(context.Background(), "box"))
+	return &spyStorage{MemoryClient: memory}
+}
+
+func newClient(t *testing.T, mode m2cs.ReplicationMode, storages []filestorage.FileStorage, opts ...m2cs.FileClientOption) *m2cs.FileClient {
+	client, err := m2cs.NewFileClientWithOptions(mode, m2cs.READ_REPLICA_FIRST, storages, opts...)
+	require.NoError(t, err)
+	return client
+}
+
+// direct reports whether the client passes the caller's payload to spy as is and returns the
+// reader of spy as is.
+func direct(t *testing.T, client *m2cs.FileClient, spy *spyStorage) bool {
+	t.Helper()
+
+	payload := strings.NewReader("content")
+	require.NoError(t, client.PutObject(context.Background(), "box", "key", payload))
+	obj, err := client.GetObject(context.Background(), "box", "key")
+	require.NoError(t, err)
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	put, get := spy.written == io.Reader(payload), spy.served == obj
+	assert.Equal(t, put, get, "the writes and reads take the same path")
+	return put
+}
+
+func TestPassthrough_Direct(t *testing.T) {
+	for _, mode := range []m2cs.ReplicationMode{m2cs.SYNC_REPLICATION, m2cs.ASYNC_REPLICATION} {
+		spy := newSpy(t, "a", true)
+		client := newClient(t, mode, []filestorage.FileStorage{spy}, m2cs.WithKeyPrefix("tenant"))
+		assert.True(t, direct(t, client, spy), mode.String())
+
+		exists, err := spy.ExistObject(context.Background(), "box", "tenant/key")
+		require.NoError(t, err)
+		assert.True(t, exists, "the key is scoped")
+
+		require.NoError(t, client.RemoveObject(context.Background(), "box", "key"))
+		exists, err = spy.ExistObject(context.Background(), "box", "tenant/key")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	}
+
+	spy := newSpy(t, "a", true)
+	client := newClient(t, m2cs.SYNC_REPLICATION, []filestorage.FileStorage{spy}, m2cs.WithDirectPassthrough(false))
+	assert.False(t, direct(t, client, spy), "WithDirectPassthrough(false) forces the full path")
+	obj, err := client.GetObject(context.Background(), "box", "key")
+	require.NoError(t, err)
+	assert.IsType(t, &m2cs.BytesReadCloser{}, obj)
+}
+
+func TestPassthrough_FullPath(t *testing.T) {
+	tests := map[string]struct {
+		replica bool
+		opts    []m2cs.FileClientOption
+		setup   func(t *testing.T, client *m2cs.FileClient)
+	}{
+		"replica":  {replica: true},
+		"retries":  {opts: []m2cs.FileClientOption{m2cs.WithRetryPolicy(m2cs.RetryPolicy{MaxAttempts: 2})}},
+		"max size": {opts: []m2cs.FileClientOption{m2cs.WithMaxObjectSize(1 << 20)}},
+		"cache": {setup: func(t *testing.T, client *m2cs.FileClient) {
+			require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true}))
+			t.Cleanup(func() { _ = client.DisableCache() })
+		}},
+		"quota": {setup: func(t *testing.T, client *m2cs.FileClient) {
+			require.NoError(t, client.ConfigureQuota("box", 1<<20, 10))
+		}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			spy := newSpy(t, "a", true)
+			storages := []filestorage.FileStorage{spy}
+			if test.replica {
+				storages = append(storages, newSpy(t, "b", false))
+			}
+			client := newClient(t, m2cs.SYNC_REPLICATION, storages, test.opts...)
+			if test.setup != nil {
+				test.setup(t, client)
+			}
+			assert.False(t, direct(t, client, spy))
+		})
+	}
+
+	primary := filestorage.NewMemoryClient(common.ConnectionProperties{Label: "a", IsMainInstance: true, Role: common.PRIMARY})
+	require.NoError(t, primary.MakeBucket(context.Background(), "box"))
+	spy := &spyStorage{MemoryClient: primary}
+	assert.False(t, direct(t, newClient(t, m2cs.SYNC_REPLICATION, []filestorage.FileStorage{spy}), spy), "a PRIMARY takes the full path")
+
+	// a single read-only storage is not written, as on the full path
+	client := newClient(t, m2cs.SYNC_REPLICATION, []filestorage.FileStorage{newSpy(t, "a", false)})
+	assert.ErrorContains(t, client.PutObject(context.Background(), "box", "key", strings.NewReader("content")), "no main instance")
+}
+
+// TestPassthrough_Errors verifies that the operations fail alike on both paths.
+func TestPassthrough_Errors(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := map[string]struct {
+		ctx context.Context
+		box string
+		key string
+	}{
+		"missing box":       {ctx: context.Background(), box: "missing", key: "key"},
+		"missing object":    {ctx: context.Background(), box: "box", key: "missing"},
+		"invalid key":       {ctx: context.Background(), box: "box", key: "/key"},
+		"invalid box":       {ctx: context.Background(), box: "B", key: "key"},
+		"canceled":          {ctx: canceled, box: "box", key: "key"},
+		"deadline exceeded": {ctx: expired(t), box: "box", key: "key"},
+	}
+
+	for _, mode := range []m2cs.ReplicationMode{m2cs.SYNC_REPLICATION, m2cs.ASYNC_REPLICATION} {
+		for name, test := range tests {
+			t.Run(mode.String()+"/"+name, func(t *testing.T) {
+				var results [2][3]error
+				for i, enabled := range []bool{true, false} {
+					client := newClient(t, mode, []filestorage.FileStorage{newSpy(t, "a", true)}, m2cs.WithDirectPassthrough(enabled))
+					put := client.PutObject(test.ctx, test.box, "written", strings.NewReader("content"))
+					_, get := client.GetObject(test.ctx, test.box, test.key)
+					remove := client.RemoveObject(test.ctx, test.box, test.key)
+					results[i] = [3]error{put, get, remove}
+				}
+
+				for op, name := range []string{"PutObject", "GetObject", "RemoveObject"} {
+					direct, full := results[0][op], results[1][op]
+					if full == nil {
+						assert.NoError(t, direct, name)
+						continue
+					}
+					if assert.Error(t, direct, name) {
+						assert.Equal(t, full.Error(), direct.Error(), name)
+					}
+					for _, target := range []error{filestorage.ErrObjectNotFound, filestorage.ErrBoxNotFound, m2cs.ErrInvalidKey, m2cs.ErrInvalidBoxName, context.Canceled, context.DeadlineExceeded} {
+						assert.Equal(t, errors.Is(full, target), errors.Is(direct, target), "%s: errors.Is(%v)", name, target)
+					}
+				}
+			})
+		}
+	}
+
+	for _, enabled := range []bool{true, false} {
+		client := newClient(t, m2cs.SYNC_REPLICATION, []filestorage.FileStorage{newSpy(t, "a", true)}, m2cs.WithDirectPassthrough(enabled))
+		assert.EqualError(t, client.PutObject(context.Background(), "box", "key", nil), "reader is nil")
+	}
+}
+
+func expired(t *testing.T) context.Context {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	t.Cleanup(cancel)
+	return ctx
+}
@@ -0,0 +1,175 @@
+package permissions_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+// restrictedStorage is a MemoryClient whose credentials are not allowed to write or remove.
+type restrictedStorage struct {
+	*filestorage.MemoryClient
+}
+
+func (s restrictedStorage) PutObject(context.Context, string, string, io.Reader) error {
+	return fmt.Errorf("put refused: %w", filestorage.ErrAccessDenied)
+}
+
+func (s restrictedStorage) RemoveObject(context.Context, string, string) error {
+	return fmt.Errorf("remove refused: %w", filestorage.ErrAccessDenied)
+}
+
+// silentStorage is a MemoryClient accepting the removals without removing the objects, like S3
+// answering a removal refused by the policy.
+type silentStorage struct {
+	*filestorage.MemoryClient
+}
+
+func (s silentStorage) RemoveObject(context.Context, string, string) error {
+	return nil
+}
+
+// basicStorage only implements filestorage.FileStorage.
+type basicStorage struct {
+	filestorage.FileStorage
+}
+
+// outcomes returns the outcome of every operation checked on p.
+func outcomes(p m2cs.StoragePermissions) map[string]string {
+	outcomes := make(map[string]string)
+	for _, c := range p.Checks {
+		outcomes[c.Operation] = c.Outcome
+	}
+	return outcomes
+}
+
+func TestCheckPermissions(t *testing.T) {
+	granted, restricted := storagetest.NewMemory(t, "granted", "box"), storagetest.NewMemory(t, "restricted", "box")
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{granted, restrictedStorage{restricted}})
+	require.NoError(t, err)
+
+	report, err := client.CheckPermissions(context.Background(), "box")
+	require.NoError(t, err)
+	assert.False(t, report.Granted())
+	assert.Equal(t, "box", report.StoreBox)
+	assert.True(t, strings.HasPrefix(report.Key, "m2cs-permission-check-"))
+	require.Len(t, report.Storages, 2)
+
+	assert.Equal(t, "granted", report.Storages[0].Label)
+	assert.Equal(t, map[string]string{
+		m2cs.PermissionPut: m2cs.PermissionGranted, m2cs.PermissionGet: m2cs.PermissionGranted,
+		m2cs.PermissionStat: m2cs.PermissionGranted, m2cs.PermissionList: m2cs.PermissionGranted,
+		m2cs.PermissionDelete: m2cs.PermissionGranted,
+	}, outcomes(report.Storages[0]))
+	assert.False(t, report.Storages[0].Leftover)
+
+	// the object is missing, so the reads are granted
+	assert.Equal(t, map[string]string{
+		m2cs.PermissionPut: m2cs.PermissionDenied, m2cs.PermissionGet: m2cs.PermissionGranted,
+		m2cs.PermissionStat: m2cs.PermissionGranted, m2cs.PermissionList: m2cs.PermissionGranted,
+		m2cs.PermissionDelete: m2cs.PermissionDenied,
+	}, outcomes(report.Storages[1]))
+	assert.False(t, report.Storages[1].Granted(m2cs.PermissionPut))
+	assert.True(t, report.Storages[1].Granted(m2cs.PermissionGet))
+	assert.ErrorIs(t, report.Storages[1].Checks[0].Err, filestorage.ErrAccessDenied)
+
+	// the temporary object is removed
+	objects, err := granted.ListObjectsInfo(context.Background(), "box", "")
+	require.NoError(t, err)
+	assert.Empty(t, objects)
+}
+
+func TestCheckPermissions_AllGranted(t *testing.T) {
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{storagetest.NewMemory(t, "first", "box"), storagetest.NewMemory(t, "second", "box")}, m2cs.WithKeyPrefix("tenant"))
+	require.NoError(t, err)
+
+	report, err := client.CheckPermissions(context.Background(), "box")
+	require.NoError(t, err)
+	assert.True(t, report.Granted())
+	assert.True(t, strings.HasPrefix(report.Key, "tenant/m2cs-permission-check-"))
+}
+
+func TestCheckPermissions_Leftover(t *testing.T) {
+	silent := storagetest.NewMemory(t, "silent", "box")
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{silentStorage{silent}})
+	require.NoError(t, err)
+
+	report, err := client.CheckPermissions(context.Background(), "box")
+	require.NoError(t, err)
+	permissions := report.Storages[0]
+	assert.Equal(t, m2cs.PermissionDenied, outcomes(permissions)[m2cs.PermissionDelete])
+	assert.True(t, permissions.Leftover)
+	assert.False(t, report.Granted())
+}
+
+func TestCheckPermissions_Unsupported(t *testing.T) {
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{basicStorage{storagetest.NewMemory(t, "basic", "box")}})
+	require.NoError(t, err)
+
+	report, err := client.CheckPermissions(context.Background(), "box")
+	require.NoError(t, err)
+	assert.Equal(t, m2cs.PermissionUnsupported, outcomes(report.Storages[0])[m2cs.PermissionStat])
+	assert.Equal(t, m2cs.PermissionUnsupported, outcomes(report.Storages[0])[m2cs.PermissionList])
+	assert.True(t, report.Granted())
+}
+
+func TestCheckPermissions_Canceled(t *testing.T) {
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{storagetest.NewMemory(t, "first", "box")})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err := client.CheckPermissions(ctx, "box")
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, report.Storages[0].Checks)
+}
+
+// TestCheckPermissions_MinIO checks the access denied answers of MinIO are reported as denied,
+// against a server accepting the checks of the store box only.
+func TestCheckPermissions_MinIO(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/box" && r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, "<Error><Code>AccessDenied</Code><Message>Access Denied.</Message></Error>")
+	}))
+	defer server.Close()
+
+	opts := m2cs.ConnectionOptions{
+		ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+		IsMainInstance:   true,
+		ProbeBox:         "box",
+		Region:           "us-east-1",
+	}
+	minio, err := m2cs.NewMinIOConnection(server.URL, opts, nil)
+	require.NoError(t, err)
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, []filestorage.FileStorage{minio})
+	require.NoError(t, err)
+
+	report, err := client.CheckPermissions(context.Background(), "box")
+	require.NoError(t, err)
+	for _, c := range report.Storages[0].Checks {
+		assert.Equal(t, m2cs.PermissionDenied, c.Outcome, c.Operation)
+	}
+	assert.Len(t, report.Storages[0].Checks, 5)
+}
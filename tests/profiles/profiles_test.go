@@ -0,0 +1,102 @@
+package profiles_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+)
+
+// newServer returns a server accepting every request, as a storage holding every store box.
+func newServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func options(method m2cs.ConnectionOptions) m2cs.ConnectionOptions {
+	method.ProbeBox = "box"
+	method.Region = "us-east-1"
+	return method
+}
+
+// TestProfiles_SharedConnectionMethod is a regression test: the properties of a connection used
+// to be stored in its connectionFunc, and carried over to the next connection reusing it.
+func TestProfiles_SharedConnectionMethod(t *testing.T) {
+	main, replica := newServer(t), newServer(t)
+	method := m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+
+	mainConn, err := m2cs.NewMinIOConnection(main.URL, options(m2cs.ConnectionOptions{
+		ConnectionMethod: method,
+		IsMainInstance:   true,
+		Label:            "main",
+		SaveEncrypt:      m2cs.AES256_ENCRYPTION,
+		EncryptKey:       "main-key",
+		AllowWeakKeys:    true,
+	}), nil)
+	require.NoError(t, err)
+	replicaConn, err := m2cs.NewMinIOConnection(replica.URL, options(m2cs.ConnectionOptions{
+		ConnectionMethod: method,
+		IsMainInstance:   false,
+		Label:            "replica",
+	}), nil)
+	require.NoError(t, err)
+
+	mainProps := mainConn.GetConnectionProperties()
+	assert.True(t, mainProps.IsMainInstance)
+	assert.Equal(t, "main", mainProps.Label)
+	assert.Equal(t, m2cs.AES256_ENCRYPTION, mainProps.SaveEncrypt)
+	assert.Equal(t, "main-key", mainProps.EncryptKey)
+
+	replicaProps := replicaConn.GetConnectionProperties()
+	assert.False(t, replicaProps.IsMainInstance)
+	assert.Equal(t, "replica", replicaProps.Label)
+	assert.Equal(t, m2cs.NO_ENCRYPTION, replicaProps.SaveEncrypt)
+	assert.Empty(t, replicaProps.EncryptKey, "the key of the main connection is not carried over")
+
+	assert.Zero(t, method.GetProperties(), "the connectionFunc of the caller is not modified")
+}
+
+func TestProfiles_CredentialProfile(t *testing.T) {
+	server := newServer(t)
+	profile := m2cs.NewCredentialProfile("minio-prod", m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"))
+	assert.Equal(t, "minio-prod", profile.GetProfile())
+	assert.Contains(t, profile.String(), `profile: "minio-prod"`)
+	assert.Nil(t, m2cs.NewCredentialProfile("none", nil))
+
+	// the profile is shared by connections created concurrently, to different backends
+	var wg sync.WaitGroup
+	for i, label := range []string{"a", "b", "c", "d"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts := options(m2cs.ConnectionOptions{ConnectionMethod: profile, IsMainInstance: i%2 == 0, Label: label})
+			if i < 2 {
+				conn, err := m2cs.NewMinIOConnection(server.URL, opts, nil)
+				require.NoError(t, err)
+				assert.Equal(t, label, conn.GetConnectionProperties().Label)
+				assert.Equal(t, i%2 == 0, conn.GetConnectionProperties().IsMainInstance)
+				return
+			}
+			conn, err := m2cs.NewS3Connection(server.URL, opts, "")
+			require.NoError(t, err)
+			assert.Equal(t, label, conn.GetConnectionProperties().Label)
+			assert.Equal(t, i%2 == 0, conn.GetConnectionProperties().IsMainInstance)
+		}()
+	}
+	wg.Wait()
+	assert.Zero(t, profile.GetProperties())
+
+	// the errors name the profile
+	_, err := m2cs.NewMinIOConnection(server.URL, m2cs.ConnectionOptions{
+		ConnectionMethod: m2cs.NewCredentialProfile("public", m2cs.ConnectWithAnonymousCredentials()),
+	}, nil)
+	assert.EqualError(t, err, "credential profile public: ProbeBox must be set with anonymous credentials, which cannot list the buckets")
+}
@@ -0,0 +1,133 @@
+package quota_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+func newClient(t *testing.T, opts ...m2cs.FileClientOption) (*m2cs.FileClient, *filestorage.MemoryClient) {
+	memory := storagetest.NewMemory(t, "memory", "box")
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{memory}, opts...)
+	require.NoError(t, err)
+	return client, memory
+}
+
+func put(client *m2cs.FileClient, key string, size int) error {
+	return client.PutObject(context.Background(), "box", key, strings.NewReader(strings.Repeat("x", size)))
+}
+
+func TestQuota_Bytes(t *testing.T) {
+	client, memory := newClient(t)
+	require.NoError(t, client.ConfigureQuota("box", 100, 0))
+
+	for i := range 4 {
+		require.NoError(t, put(client, fmt.Sprintf("key-%d", i), 25))
+	}
+	usage, ok := client.Usage("box")
+	require.True(t, ok)
+	assert.Equal(t, m2cs.BoxUsage{Bytes: 100, Objects: 4, MaxBytes: 100}, usage)
+
+	err := put(client, "extra", 1)
+	assert.ErrorIs(t, err, m2cs.ErrQuotaExceeded)
+	exists, err := memory.ExistObject(context.Background(), "box", "extra")
+	require.NoError(t, err)
+	assert.False(t, exists, "a rejected write must not reach the storages")
+
+	// overwriting an object only counts the difference
+	assert.ErrorIs(t, put(client, "key-0", 26), m2cs.ErrQuotaExceeded)
+	require.NoError(t, put(client, "key-0", 10))
+	require.NoError(t, put(client, "key-0", 25))
+
+	require.NoError(t, client.RemoveObject(context.Background(), "box", "key-1"))
+	usage, _ = client.Usage("box")
+	assert.Equal(t, int64(75), usage.Bytes)
+	assert.Equal(t, int64(3), usage.Objects)
+
+	require.NoError(t, put(client, "extra", 25))
+	assert.ErrorIs(t, put(client, "more", 1), m2cs.ErrQuotaExceeded)
+}
+
+func TestQuota_Objects(t *testing.T) {
+	client, _ := newClient(t)
+	require.NoError(t, client.ConfigureQuota("box", 0, 2))
+
+	require.NoError(t, put(client, "a", 1000))
+	require.NoError(t, put(client, "b", 1000))
+	assert.ErrorIs(t, put(client, "c", 1), m2cs.ErrQuotaExceeded)
+	require.NoError(t, put(client, "a", 2000), "overwrites do not add objects")
+
+	require.NoError(t, client.RemoveObject(context.Background(), "box", "a"))
+	require.NoError(t, put(client, "c", 1))
+
+	usage, _ := client.Usage("box")
+	assert.Equal(t, m2cs.BoxUsage{Bytes: 1001, Objects: 2, MaxObjects: 2}, usage)
+}
+
+func TestQuota_Concurrent(t *testing.T) {
+	client, _ := newClient(t)
+	require.NoError(t, client.ConfigureQuota("box", 0, 10))
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted int
+	)
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := put(client, fmt.Sprintf("key-%d", i), 10); err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			} else {
+				assert.ErrorIs(t, err, m2cs.ErrQuotaExceeded)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10, accepted)
+	usage, _ := client.Usage("box")
+	assert.Equal(t, int64(10), usage.Objects)
+	assert.Equal(t, int64(100), usage.Bytes)
+}
+
+func TestQuota_InitializeUsageFromListing(t *testing.T) {
+	client, memory := newClient(t, m2cs.WithKeyPrefix("tenant"))
+	ctx := context.Background()
+
+	// objects written by other processes, inside and outside the namespace
+	require.NoError(t, memory.PutObject(ctx, "box", "tenant/a", strings.NewReader("12345")))
+	require.NoError(t, memory.PutObject(ctx, "box", "tenant/b", strings.NewReader("123")))
+	require.NoError(t, memory.PutObject(ctx, "box", "other/c", strings.NewReader("1234567")))
+
+	_, ok := client.Usage("box")
+	assert.False(t, ok)
+	assert.ErrorContains(t, client.InitializeUsageFromListing(ctx, "box"), "has no quota")
+
+	require.NoError(t, client.ConfigureQuota("box", 10, 0))
+	usage, _ := client.Usage("box")
+	assert.Zero(t, usage.Bytes, "the usage is only seeded by InitializeUsageFromListing")
+
+	require.NoError(t, client.InitializeUsageFromListing(ctx, "box"))
+	usage, _ = client.Usage("box")
+	assert.Equal(t, m2cs.BoxUsage{Bytes: 8, Objects: 2, MaxBytes: 10}, usage)
+
+	assert.ErrorIs(t, put(client, "c", 3), m2cs.ErrQuotaExceeded)
+	require.NoError(t, client.RemoveObject(ctx, "box", "a"))
+	require.NoError(t, put(client, "c", 3))
+
+	assert.ErrorContains(t, client.ConfigureQuota("box", -1, 0), "must not be negative")
+}
@@ -0,0 +1,196 @@
+package readonly_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+// server records the methods of the requests it receives, and accepts them all.
+type server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	methods []string
+}
+
+func newServer(t *testing.T) *server {
+	s := &server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *server) serve(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.Copy(io.Discard, r.Body)
+	s.mu.Lock()
+	s.methods = append(s.methods, r.Method)
+	s.mu.Unlock()
+
+	if r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/m2cs/") {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// writes returns the number of requests received that may change the content of the storage.
+func (s *server) writes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, method := range s.methods {
+		if method != http.MethodGet && method != http.MethodHead {
+			n++
+		}
+	}
+	return n
+}
+
+var backends = []struct {
+	name    string
+	connect func(t *testing.T, s *server) filestorage.FileStorage
+	makeBox func(ctx context.Context, s filestorage.FileStorage) error
+}{
+	{"MinIO", func(t *testing.T, s *server) filestorage.FileStorage {
+		opts := options()
+		opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+		client, err := m2cs.NewMinIOConnection(s.URL, opts, nil)
+		require.NoError(t, err)
+		return client
+	}, func(ctx context.Context, s filestorage.FileStorage) error {
+		return s.(*filestorage.MinioClient).MakeBucket(ctx, "other")
+	}},
+	{"AWS S3", func(t *testing.T, s *server) filestorage.FileStorage {
+		opts := options()
+		opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+		client, err := m2cs.NewS3Connection(s.URL, opts, opts.Region)
+		require.NoError(t, err)
+		return client
+	}, func(ctx context.Context, s filestorage.FileStorage) error {
+		return s.(*filestorage.S3Client).CreateBucket(ctx, "other")
+	}},
+	{"Azure Blob", func(t *testing.T, s *server) filestorage.FileStorage {
+		opts := options()
+		opts.ConnectionMethod = m2cs.ConnectWithConnectionString("DefaultEndpointsProtocol=http;AccountName=m2cs;" +
+			"AccountKey=bTJjcy1hY2NvdW50LWtleQ==;BlobEndpoint=" + s.URL + "/m2cs;")
+		client, err := m2cs.NewAzBlobConnection("", opts)
+		require.NoError(t, err)
+		return client
+	}, func(ctx context.Context, s filestorage.FileStorage) error {
+		return s.(*filestorage.AzBlobClient).CreateContainer(ctx, "other")
+	}},
+}
+
+func options() m2cs.ConnectionOptions {
+	return m2cs.ConnectionOptions{ProbeBox: "box", Region: "us-east-1", ReadOnly: true}
+}
+
+// TestReadOnlyConnection checks the writes of read-only connections are rejected without
+// reaching the provider.
+func TestReadOnlyConnection(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			s := newServer(t)
+			client := b.connect(t, s)
+			ctx := context.Background()
+			assert.True(t, client.GetConnectionProperties().ReadOnly)
+
+			err := client.PutObject(ctx, "box", "file.txt", strings.NewReader("content"))
+			assert.ErrorIs(t, err, m2cs.ErrReadOnlyStorage)
+			assert.ErrorIs(t, client.RemoveObject(ctx, "box", "file.txt"), m2cs.ErrReadOnlyStorage)
+			assert.ErrorIs(t, b.makeBox(ctx, client), m2cs.ErrReadOnlyStorage)
+			assert.Zero(t, s.writes())
+
+			// made writable again
+			client.(filestorage.ReadOnlySetter).SetReadOnly(false)
+			assert.False(t, client.GetConnectionProperties().ReadOnly)
+			require.NoError(t, client.PutObject(ctx, "box", "file.txt", strings.NewReader("content")))
+			assert.NotZero(t, s.writes())
+		})
+	}
+}
+
+func TestReadOnlyMemoryClient(t *testing.T) {
+	memory := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "replica"}, "box")
+	ctx := context.Background()
+	require.NoError(t, memory.PutObject(ctx, "box", "key", strings.NewReader("data")))
+	memory.SetReadOnly(true)
+
+	err := memory.PutObject(ctx, "box", "key", strings.NewReader("other"))
+	assert.EqualError(t, err, "put rejected: storage is read-only")
+	assert.ErrorIs(t, memory.RemoveObject(ctx, "box", "key"), filestorage.ErrReadOnlyStorage)
+	assert.ErrorIs(t, memory.MakeBucket(ctx, "other"), filestorage.ErrReadOnlyStorage)
+	assert.ErrorIs(t, memory.RemoveBucket(ctx, "box"), filestorage.ErrReadOnlyStorage)
+
+	obj, err := memory.GetObject(ctx, "box", "key")
+	require.NoError(t, err)
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
+
+func TestEnforceReplicaReadOnly(t *testing.T) {
+	main := storagetest.NewMemory(t, "main", "box")
+	replica := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "replica"}, "box")
+	ctx := context.Background()
+	require.NoError(t, replica.PutObject(ctx, "box", "key", strings.NewReader("data")))
+	for _, s := range []*filestorage.MemoryClient{main, replica} {
+		require.NoError(t, s.MakeBucket(ctx, m2cs.DefaultDiagnosticsBox))
+	}
+
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{main, replica}, m2cs.WithEnforceReplicaReadOnly())
+	require.NoError(t, err)
+	assert.True(t, replica.GetConnectionProperties().ReadOnly)
+	assert.False(t, main.GetConnectionProperties().ReadOnly)
+
+	// the application code cannot write the replica directly
+	err = replica.PutObject(ctx, "box", "key", strings.NewReader("corrupted"))
+	assert.ErrorIs(t, err, m2cs.ErrReadOnlyStorage)
+
+	// the client keeps writing the main storages and reading from the replica
+	require.NoError(t, client.PutObject(ctx, "box", "other", strings.NewReader("written")))
+	obj, err := client.GetObject(ctx, "box", "key")
+	require.NoError(t, err)
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	// the diagnostics report the writes of the replica denied, without failing on them
+	require.NoError(t, client.SelfTest(ctx))
+	report, err := client.CheckPermissions(ctx, "box")
+	require.NoError(t, err)
+	assert.True(t, report.Storages[0].Granted(m2cs.PermissionPut))
+	assert.Equal(t, m2cs.PermissionDenied, report.Storages[1].Checks[0].Outcome)
+	assert.True(t, report.Storages[1].Granted(m2cs.PermissionGet))
+}
+
+func TestEnforceReplicaReadOnly_Unsupported(t *testing.T) {
+	main := storagetest.NewMemory(t, "main", "box")
+	replica := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "replica"}, "box")
+	decorated := storagetest.Wrap(storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "decorated"}, "box"), storagetest.Latency(0))
+
+	_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{main, replica, decorated}, m2cs.WithEnforceReplicaReadOnly())
+	assert.True(t, errors.Is(err, m2cs.ErrInvalidConfiguration))
+	assert.EqualError(t, err, "invalid FileClient configuration: replica decorated cannot be made read-only")
+
+	// no replica is made read-only by a client failing to be created
+	assert.False(t, replica.GetConnectionProperties().ReadOnly)
+}
@@ -0,0 +1,254 @@
+package replication_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	"github.com/tizianocitro/m2cs/internal/replication"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+// recorder records the writes of the tasks, in order.
+type recorder struct {
+	mu     sync.Mutex
+	writes []string
+}
+
+func (r *recorder) record(write string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writes = append(r.writes, write)
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.writes...)
+}
+
+func task(key, version string, r *recorder, release <-chan struct{}) *replication.Task {
+	return &replication.Task{
+		StoreBox: "box",
+		Key:      key,
+		Source:   "source",
+		Targets:  []string{"target"},
+		Write: func(ctx context.Context, i int) error {
+			if release != nil {
+				<-release
+			}
+			r.record(key + "@" + version)
+			return nil
+		},
+	}
+}
+
+func TestEngine_OrderingPerKey(t *testing.T) {
+	var r recorder
+	engine := replication.New(replication.Options{})
+
+	release := make(chan struct{})
+	require.NoError(t, engine.Enqueue(context.Background(), task("a", "1", &r, release)))
+	require.NoError(t, engine.Enqueue(context.Background(), task("a", "2", &r, nil)))
+	require.NoError(t, engine.Enqueue(context.Background(), task("a", "3", &r, nil)))
+	require.NoError(t, engine.Enqueue(context.Background(), task("b", "1", &r, nil)))
+
+	// the tasks of another key do not wait for the ones of a
+	assert.Eventually(t, func() bool {
+		return withoutAge(engine.Stats()) == replication.Stats{Pending: 3, Running: 1}
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"b@1"}, r.get())
+
+	close(release)
+	require.NoError(t, engine.Drain(context.Background()))
+	assert.Equal(t, []string{"b@1", "a@1", "a@2", "a@3"}, r.get())
+}
+
+func withoutAge(stats replication.Stats) replication.Stats {
+	stats.OldestAge = 0
+	return stats
+}
+
+func TestEngine_RetryExhaustion(t *testing.T) {
+	queue := replication.NewMemoryQueue()
+	engine := replication.New(replication.Options{
+		Queue: queue,
+		Retry: func(ctx context.Context, write func() error) error {
+			var err error
+			for range 3 {
+				if err = write(); err == nil {
+					return nil
+				}
+			}
+			return err
+		},
+	})
+
+	var results []error
+	done := make(chan struct{})
+	require.NoError(t, engine.Enqueue(context.Background(), &replication.Task{
+		StoreBox: "box",
+		Key:      "key",
+		Source:   "source",
+		Targets:  []string{"up", "down"},
+		Write: func(ctx context.Context, i int) error {
+			if i == 1 {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+		Done: func(r []error) {
+			results = r
+			close(done)
+		},
+	}))
+	<-done
+	require.NoError(t, engine.Drain(context.Background()))
+
+	require.Len(t, results, 2)
+	assert.NoError(t, results[0])
+	assert.EqualError(t, results[1], "connection refused")
+
+	// the task is kept with the target left to write
+	pending, corrupt, err := queue.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, corrupt)
+	require.Len(t, pending, 1)
+	assert.Equal(t, []string{"down"}, pending[0].Targets)
+	assert.Equal(t, 4, pending[0].Attempts, "one attempt on up, three on down")
+
+	// replaying it with the Copy of the engine completes it
+	var copied atomic.Int32
+	engine = replication.New(replication.Options{Queue: queue, Copy: func(ctx context.Context, t *replication.Task, i int) error {
+		copied.Add(1)
+		return nil
+	}})
+	require.NoError(t, engine.Enqueue(context.Background(), pending[0]))
+	require.NoError(t, engine.Drain(context.Background()))
+	assert.Equal(t, int32(1), copied.Load())
+	pending, _, err = queue.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestEngine_Drain(t *testing.T) {
+	clk := storagetest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	engine := replication.New(replication.Options{Workers: 2, Clock: clk})
+	require.NoError(t, engine.Drain(context.Background()), "an idle engine is drained")
+
+	var (
+		r       recorder
+		running atomic.Int32
+		peak    atomic.Int32
+	)
+	release := make(chan struct{})
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		tk := task(key, "1", &r, release)
+		write := tk.Write
+		tk.Write = func(ctx context.Context, i int) error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			return write(ctx, i)
+		}
+		require.NoError(t, engine.Enqueue(context.Background(), tk))
+	}
+	clk.Advance(time.Minute)
+	assert.Equal(t, replication.Stats{Pending: 5, Running: 2, OldestAge: time.Minute}, engine.Stats())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, engine.Drain(ctx), context.DeadlineExceeded)
+
+	close(release)
+	require.NoError(t, engine.Drain(context.Background()))
+	assert.Len(t, r.get(), 5, "every task is done once drained")
+	assert.LessOrEqual(t, peak.Load(), int32(2))
+	assert.Equal(t, replication.Stats{}, engine.Stats())
+}
+
+func TestDiskQueue(t *testing.T) {
+	dir := t.TempDir()
+	queue, err := replication.NewDiskQueue(dir)
+	require.NoError(t, err)
+
+	tk := &replication.Task{StoreBox: "box", Key: "key", Source: "first", Targets: []string{"second", "third"}, Attempts: 2, Created: time.Now().UTC()}
+	require.NoError(t, queue.Add(tk))
+	assert.Equal(t, dir, filepath.Dir(tk.ID))
+	require.NoError(t, queue.Update(tk, []string{"third"}))
+	assert.Equal(t, []string{"second", "third"}, tk.Targets, "the task is not modified")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0-garbage.json"), []byte("{not json"), 0o644))
+	pending, corrupt, err := queue.Pending()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, []string{"third"}, pending[0].Targets)
+	assert.Equal(t, 2, pending[0].Attempts)
+	assert.Equal(t, tk.ID, pending[0].ID)
+	require.Len(t, corrupt, 1)
+	assert.NotErrorIs(t, corrupt[0], replication.ErrSetAside)
+
+	require.NoError(t, queue.Update(pending[0], nil))
+	pending, corrupt, err = queue.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	assert.Empty(t, corrupt, "the corrupt entry is set aside")
+}
+
+func content(t *testing.T, s *filestorage.MemoryClient, key string) string {
+	obj, err := s.GetObject(context.Background(), "box", key)
+	if errors.Is(err, filestorage.ErrObjectNotFound) {
+		return ""
+	}
+	require.NoError(t, err)
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	return string(data)
+}
+
+// TestFileClient_AsyncReplication checks that the ASYNC_REPLICATION writes return once a main
+// storage is written and reach the other ones in background, in the order of the writes.
+func TestFileClient_AsyncReplication(t *testing.T) {
+	ctx := context.Background()
+	first, second := storagetest.NewMemory(t, "first", "box"), storagetest.NewMemory(t, "second", "box")
+	gate := storagetest.NewGate()
+	storages := []filestorage.FileStorage{first, storagetest.Wrap(second, gate.Decorator())}
+	client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		storages, m2cs.WithReplicationWorkers(1))
+	require.NoError(t, err)
+
+	for _, version := range []string{"v1", "v2", "v3"} {
+		require.NoError(t, client.PutObject(ctx, "box", "key", strings.NewReader(version)))
+	}
+	require.NoError(t, client.PutObject(ctx, "box", "other", strings.NewReader("other")))
+	assert.Equal(t, "v3", content(t, first, "key"), "the writes return once the first storage is written")
+	assert.Equal(t, "", content(t, second, "key"))
+
+	status := client.ReplicationStatus()
+	assert.Equal(t, int64(4), status.Backlog)
+	assert.Equal(t, int64(3), status.Queued, "one worker runs the first replication, the other ones wait")
+
+	gate.Release()
+	assert.Eventually(t, func() bool { return client.ReplicationStatus().Backlog == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, "v3", content(t, second, "key"), "the last write wins on every storage")
+	assert.Equal(t, "other", content(t, second, "other"))
+	assert.Equal(t, m2cs.ReplicationStatus{Mode: m2cs.ASYNC_REPLICATION}, client.ReplicationStatus())
+
+	_, err = m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		storages, m2cs.WithReplicationWorkers(0))
+	assert.EqualError(t, err, "replication workers must be positive, got 0")
+}
@@ -0,0 +1,101 @@
+package replicationfilter_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+func exists(t *testing.T, s filestorage.FileStorage, key string) bool {
+	ok, err := s.ExistObject(context.Background(), "box", key)
+	require.NoError(t, err)
+	return ok
+}
+
+func notTmp(_, key string, _ m2cs.PutOptions) bool {
+	return !strings.HasPrefix(key, "tmp/")
+}
+
+func TestReplicationFilter(t *testing.T) {
+	for _, mode := range []m2cs.ReplicationMode{m2cs.SYNC_REPLICATION, m2cs.ASYNC_REPLICATION} {
+		t.Run(mode.String(), func(t *testing.T) {
+			ctx := context.Background()
+			primary := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "primary", IsMainInstance: true, Role: common.PRIMARY}, "box")
+			secondaries := []*filestorage.MemoryClient{storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "eu", IsMainInstance: true, Role: common.SECONDARY_MAIN}, "box"), storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "us", IsMainInstance: true, Role: common.SECONDARY_MAIN}, "box")}
+			client, err := m2cs.NewFileClientWithOptions(mode, m2cs.READ_REPLICA_FIRST,
+				[]filestorage.FileStorage{secondaries[0], primary, secondaries[1]}, m2cs.WithReplicationFilter(notTmp))
+			require.NoError(t, err)
+
+			report, err := client.PutObjectWithReport(ctx, "box", "tmp/upload.part", strings.NewReader("temp"), m2cs.PutOptions{})
+			require.NoError(t, err)
+			assert.True(t, report.Local)
+			report, err = client.PutObjectWithReport(ctx, "box", "photos/a.jpg", strings.NewReader("photo"), m2cs.PutOptions{})
+			require.NoError(t, err)
+			assert.False(t, report.Local)
+
+			assert.True(t, exists(t, primary, "tmp/upload.part"))
+			assert.True(t, exists(t, primary, "photos/a.jpg"))
+			for _, s := range secondaries {
+				assert.Eventually(t, func() bool { return exists(t, s, "photos/a.jpg") }, time.Second, 10*time.Millisecond)
+			}
+			for _, s := range secondaries {
+				assert.False(t, exists(t, s, "tmp/upload.part"), "%s should not hold the temporary file", s.GetConnectionProperties().Label)
+			}
+		})
+	}
+}
+
+// TestReplicationFilter_NoPrimary checks that, without a PRIMARY storage, the writes kept local land
+// on a single main storage.
+func TestReplicationFilter_NoPrimary(t *testing.T) {
+	storages := []filestorage.FileStorage{storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "eu", IsMainInstance: true, Role: common.NO_ROLE}, "box"), storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "us", IsMainInstance: true, Role: common.NO_ROLE}, "box")}
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storages, m2cs.WithReplicationFilter(notTmp))
+	require.NoError(t, err)
+
+	require.NoError(t, client.PutObject(context.Background(), "box", "tmp/upload.part", strings.NewReader("temp")))
+	n := 0
+	for _, s := range storages {
+		if exists(t, s, "tmp/upload.part") {
+			n++
+		}
+	}
+	assert.Equal(t, 1, n)
+}
+
+// TestReplicationFilter_Arguments checks that the filter receives the names given by the caller and
+// the put options.
+func TestReplicationFilter_Arguments(t *testing.T) {
+	ctx := context.Background()
+	storage := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "main", IsMainInstance: true, Role: common.NO_ROLE}, "box")
+	require.NoError(t, storage.MakeBucket(ctx, "tenant-box"))
+
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	filter := func(storeBox, key string, opts m2cs.PutOptions) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, storeBox+"|"+key+"|"+opts.ContentType)
+		return true
+	}
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, []filestorage.FileStorage{storage},
+		m2cs.WithBoxPrefix("tenant-"), m2cs.WithKeyPrefix("a"), m2cs.WithReplicationFilter(filter))
+	require.NoError(t, err)
+
+	require.NoError(t, client.PutObjectWithOptions(ctx, "box", "photos/a.jpg", strings.NewReader("photo"), m2cs.PutOptions{ContentType: "image/jpeg"}))
+	assert.Equal(t, []string{"box|photos/a.jpg|image/jpeg"}, calls)
+
+	_, err = m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, []filestorage.FileStorage{storage}, m2cs.WithReplicationFilter(nil))
+	assert.EqualError(t, err, "replication filter is nil")
+}
@@ -0,0 +1,194 @@
+package requestid_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+)
+
+type requestIDKey struct{}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// server records the X-Request-Id header of the requests it receives, and accepts them all. Its paths are /<box>/<object>
+// for MinIO and AWS S3, and /m2cs/<box>/<object> for Azure.
+type server struct {
+	*httptest.Server
+
+	mu  sync.Mutex
+	ids []string
+}
+
+func newServer(t *testing.T) *server {
+	s := &server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *server) serve(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.Copy(io.Discard, r.Body)
+	s.mu.Lock()
+	s.ids = append(s.ids, r.Header.Get("X-Request-Id"))
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", `"0123456789abcdef0123456789abcdef"`)
+	w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+	if r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/m2cs/") {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// requestIDs returns the request IDs of the requests received since the last call.
+func (s *server) requestIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := s.ids
+	s.ids = nil
+	return ids
+}
+
+// only reports whether ids holds id alone, at least once.
+func only(ids []string, id string) bool {
+	for _, got := range ids {
+		if got != id {
+			return false
+		}
+	}
+	return len(ids) > 0
+}
+
+var backends = []struct {
+	name    string
+	connect func(t *testing.T, s *server) filestorage.FileStorage
+}{
+	{"MinIO", func(t *testing.T, s *server) filestorage.FileStorage {
+		opts := options()
+		opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+		client, err := m2cs.NewMinIOConnection(s.URL, opts, nil)
+		require.NoError(t, err)
+		return client
+	}},
+	{"AWS S3", func(t *testing.T, s *server) filestorage.FileStorage {
+		opts := options()
+		opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+		client, err := m2cs.NewS3Connection(s.URL, opts, opts.Region)
+		require.NoError(t, err)
+		return client
+	}},
+	{"Azure Blob", func(t *testing.T, s *server) filestorage.FileStorage {
+		opts := options()
+		opts.ConnectionMethod = m2cs.ConnectWithConnectionString("DefaultEndpointsProtocol=http;AccountName=m2cs;" +
+			"AccountKey=bTJjcy1hY2NvdW50LWtleQ==;BlobEndpoint=" + s.URL + "/m2cs;")
+		client, err := m2cs.NewAzBlobConnection("", opts)
+		require.NoError(t, err)
+		return client
+	}},
+}
+
+func options() m2cs.ConnectionOptions {
+	return m2cs.ConnectionOptions{IsMainInstance: true, ProbeBox: "box", Region: "us-east-1"}
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			s := newServer(t)
+			client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+				[]filestorage.FileStorage{b.connect(t, s)}, m2cs.WithRequestIDHeader("X-Request-Id", requestID))
+			require.NoError(t, err)
+
+			s.requestIDs()
+
+			ctx := context.WithValue(context.Background(), requestIDKey{}, "req-1")
+			require.NoError(t, client.PutObject(ctx, "box", "file.txt", strings.NewReader("content")))
+			ids := s.requestIDs()
+			assert.True(t, only(ids, "req-1"), "request IDs: %q", ids)
+
+			// without a request ID, no header is attached
+			require.NoError(t, client.PutObject(context.Background(), "box", "file.txt", strings.NewReader("content")))
+			ids = s.requestIDs()
+			assert.True(t, only(ids, ""), "request IDs: %q", ids)
+		})
+	}
+}
+
+func TestRequestIDHeader_Disabled(t *testing.T) {
+	s := newServer(t)
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{backends[0].connect(t, s)})
+	require.NoError(t, err)
+	s.requestIDs()
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-1")
+	require.NoError(t, client.PutObject(ctx, "box", "file.txt", strings.NewReader("content")))
+	ids := s.requestIDs()
+	assert.True(t, only(ids, ""), "request IDs: %q", ids)
+}
+
+// TestRequestIDHeader_Interceptor checks the request ID set by an interceptor is attached.
+func TestRequestIDHeader_Interceptor(t *testing.T) {
+	s := newServer(t)
+	setID := func(ctx context.Context, op m2cs.OpInfo, next func(ctx context.Context) error) error {
+		return next(context.WithValue(ctx, requestIDKey{}, "from-interceptor"))
+	}
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{backends[0].connect(t, s)},
+		m2cs.WithRequestIDHeader("X-Request-Id", requestID), m2cs.WithInterceptors(setID))
+	require.NoError(t, err)
+	s.requestIDs()
+
+	require.NoError(t, client.PutObject(context.Background(), "box", "file.txt", strings.NewReader("content")))
+	ids := s.requestIDs()
+	assert.True(t, only(ids, "from-interceptor"), "request IDs: %q", ids)
+}
+
+// TestRequestIDHeader_Async checks the background replications carry the request ID of the write.
+func TestRequestIDHeader_Async(t *testing.T) {
+	first, second := newServer(t), newServer(t)
+	client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{backends[0].connect(t, first), backends[0].connect(t, second)},
+		m2cs.WithRequestIDHeader("X-Request-Id", requestID))
+	require.NoError(t, err)
+	first.requestIDs()
+	second.requestIDs()
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), requestIDKey{}, "req-1"))
+	require.NoError(t, client.PutObject(ctx, "box", "file.txt", strings.NewReader("content")))
+	cancel()
+	require.Eventually(t, func() bool { return client.ReplicationStatus().Backlog == 0 }, 5*time.Second, time.Millisecond)
+
+	for _, s := range []*server{first, second} {
+		ids := s.requestIDs()
+		assert.True(t, only(ids, "req-1"), "request IDs: %q", ids)
+	}
+}
+
+func TestRequestIDHeader_Invalid(t *testing.T) {
+	for _, name := range []string{"", "X Request", "X-Request:Id"} {
+		_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+			[]filestorage.FileStorage{filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})}, m2cs.WithRequestIDHeader(name, requestID))
+		assert.EqualError(t, err, `invalid request ID header name "`+name+`"`)
+	}
+
+	_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})}, m2cs.WithRequestIDHeader("X-Request-Id", nil))
+	assert.EqualError(t, err, "request ID extractor is nil")
+}
@@ -0,0 +1,618 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+// flakyStorage fails the first calls of every operation with a transient error, and counts the calls.
+type flakyStorage struct {
+	filestorage.FileStorage
+	err error
+
+	mu       sync.Mutex
+	failures int            // Calls left to fail, per operation
+	calls    map[string]int // Calls by operation
+	left     map[string]int
+}
+
+func newFlakyStorage(t *testing.T, main bool, failures int) *flakyStorage {
+	memory := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: main})
+	require.NoError(t, memory.MakeBucket(context.Background(), "box"))
+	require.NoError(t, memory.PutObject(context.Background(), "box", "key", strings.NewReader("data")))
+	return &flakyStorage{
+		FileStorage: memory,
+		err:         fmt.Errorf("503 Service Unavailable: %w", filestorage.ErrTransient),
+		failures:    failures,
+		calls:       map[string]int{},
+		left:        map[string]int{},
+	}
+}
+
+// call counts a call of op and returns the error it fails with, if any.
+func (s *flakyStorage) call(op string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.left[op]; !ok {
+		s.left[op] = s.failures
+	}
+	s.calls[op]++
+	if s.left[op] > 0 {
+		s.left[op]--
+		return s.err
+	}
+	return nil
+}
+
+func (s *flakyStorage) count(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[op]
+}
+
+func (s *flakyStorage) GetObject(ctx context.Context, storeBox, fileName string) (io.ReadCloser, error) {
+	if err := s.call("get"); err != nil {
+		return nil, err
+	}
+	return s.FileStorage.GetObject(ctx, storeBox, fileName)
+}
+
+func (s *flakyStorage) PutObject(ctx context.Context, storeBox, fileName string, reader io.Reader) error {
+	if err := s.call("put"); err != nil {
+		// the payload is consumed by the failed attempt, as by a failed upload
+		_, _ = io.Copy(io.Discard, reader)
+		return err
+	}
+	return s.FileStorage.PutObject(ctx, storeBox, fileName, reader)
+}
+
+func (s *flakyStorage) RemoveObject(ctx context.Context, storeBox, fileName string) error {
+	if err := s.call("remove"); err != nil {
+		return err
+	}
+	return s.FileStorage.RemoveObject(ctx, storeBox, fileName)
+}
+
+func (s *flakyStorage) ExistObject(ctx context.Context, storeBox, fileName string) (bool, error) {
+	if err := s.call("exist"); err != nil {
+		return false, err
+	}
+	return s.FileStorage.ExistObject(ctx, storeBox, fileName)
+}
+
+func newClient(t *testing.T, opts []m2cs.FileClientOption, storages ...filestorage.FileStorage) *m2cs.FileClient {
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storages, opts...)
+	require.NoError(t, err)
+	return client
+}
+
+func read(t *testing.T, client *m2cs.FileClient) (string, error) {
+	obj, err := client.GetObject(context.Background(), "box", "key")
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	return string(data), nil
+}
+
+func TestRetryPolicy_Reads(t *testing.T) {
+	policy := m2cs.RetryPolicy{MaxAttempts: 3, BaseDelay: 20 * time.Millisecond, MaxDelay: 40 * time.Millisecond}
+
+	storage := newFlakyStorage(t, true, 2)
+	client := newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy)}, storage)
+
+	start := time.Now()
+	data, err := read(t, client)
+	elapsed := time.Since(start)
+	require.NoError(t, err)
+	assert.Equal(t, "data", data)
+	assert.Equal(t, 3, storage.count("get"))
+	// the delays are drawn in [10ms, 20ms] and [20ms, 40ms]
+	assert.GreaterOrEqual(t, elapsed, 30*time.Millisecond)
+	assert.Less(t, elapsed, 500*time.Millisecond)
+
+	// the attempts are exhausted on the replica before falling back to the main storage
+	replica := newFlakyStorage(t, false, 10)
+	main := newFlakyStorage(t, true, 0)
+	client = newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy)}, replica, main)
+	data, err = read(t, client)
+	require.NoError(t, err)
+	assert.Equal(t, "data", data)
+	assert.Equal(t, 3, replica.count("get"))
+	assert.Equal(t, 1, main.count("get"))
+
+	// without fallback, the last failure is returned
+	storage = newFlakyStorage(t, true, 10)
+	client = newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy)}, storage)
+	_, err = read(t, client)
+	assert.ErrorIs(t, err, filestorage.ErrTransient)
+	assert.ErrorContains(t, err, "after 3 attempts")
+	assert.Equal(t, 3, storage.count("get"))
+}
+
+func TestRetryPolicy_NotRetried(t *testing.T) {
+	policy := m2cs.RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond}
+
+	// failures which are not transient are not retried
+	storage := newFlakyStorage(t, true, 10)
+	storage.err = errors.New("access denied")
+	client := newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy)}, storage)
+	_, err := read(t, client)
+	assert.Error(t, err)
+	assert.Equal(t, 1, storage.count("get"))
+
+	// RetryOn decides which failures are retried
+	retryAll := policy
+	retryAll.RetryOn = func(error) bool { return true }
+	storage = newFlakyStorage(t, true, 2)
+	storage.err = errors.New("access denied")
+	client = newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(retryAll)}, storage)
+	_, err = read(t, client)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, storage.count("get"))
+
+	// the policy applies to the given operation types only
+	storage = newFlakyStorage(t, true, 2)
+	client = newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy, m2cs.WRITE_OPERATION)}, storage)
+	_, err = read(t, client)
+	assert.Error(t, err)
+	assert.Equal(t, 1, storage.count("get"))
+	require.NoError(t, client.PutObject(context.Background(), "box", "key", strings.NewReader("new")))
+	assert.Equal(t, 3, storage.count("put"))
+}
+
+func TestRetryPolicy_Replication(t *testing.T) {
+	ctx := context.Background()
+	policy := m2cs.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	// a storage recovering within its attempts is not reported as failed
+	healthy := newFlakyStorage(t, true, 0)
+	flaky := newFlakyStorage(t, true, 2)
+	client := newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy)}, healthy, flaky)
+	require.NoError(t, client.PutObject(ctx, "box", "new", strings.NewReader("payload")))
+	assert.Equal(t, 1, healthy.count("put"))
+	assert.Equal(t, 3, flaky.count("put"))
+	for _, s := range []*flakyStorage{healthy, flaky} {
+		obj, err := s.FileStorage.GetObject(ctx, "box", "new")
+		require.NoError(t, err)
+		data, err := io.ReadAll(obj)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(data), "every storage should hold the whole payload")
+	}
+
+	// a storage failing past its attempts is declared failed
+	broken := newFlakyStorage(t, true, 10)
+	client = newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy)}, healthy, broken)
+	err := client.PutObject(ctx, "box", "other", strings.NewReader("payload"))
+	assert.ErrorContains(t, err, "partially failed on 1/2 storages")
+	assert.ErrorIs(t, err, filestorage.ErrTransient)
+	assert.Equal(t, 3, broken.count("put"))
+
+	// removals and existence checks are retried per storage
+	flaky = newFlakyStorage(t, true, 1)
+	client = newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy)}, healthy, flaky)
+	require.NoError(t, client.RemoveObject(ctx, "box", "key"))
+	assert.Equal(t, 2, flaky.count("remove"))
+	exists, err := client.ExistsObject(ctx, "box", "key")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, 2, flaky.count("exist"))
+}
+
+func TestRetryPolicy_Canceled(t *testing.T) {
+	policy := m2cs.RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: time.Second}
+	storage := newFlakyStorage(t, true, 10)
+	client := newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy)}, storage)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GetObject(ctx, "box", "key")
+	assert.ErrorIs(t, err, filestorage.ErrTransient)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "the retries should stop with the context")
+	assert.Equal(t, 1, storage.count("get"))
+}
+
+func TestRetryPolicy_Clock(t *testing.T) {
+	policy := m2cs.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+	clk := storagetest.NewFakeClock(time.Now())
+	storage := newFlakyStorage(t, true, 2)
+	client := newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy), m2cs.WithClock(clk)}, storage)
+
+	done := make(chan error, 1)
+	go func() {
+		obj, err := client.GetObject(context.Background(), "box", "key")
+		if err == nil {
+			obj.Close()
+		}
+		done <- err
+	}()
+
+	// the delays are drawn in [30s, 1m] and [1m, 2m], and waited on the clock of the client
+	clk.BlockUntil(1)
+	assert.Equal(t, 1, storage.count("get"))
+	clk.Advance(time.Minute)
+	clk.BlockUntil(1)
+	assert.Equal(t, 2, storage.count("get"))
+	clk.Advance(2 * time.Minute)
+	require.NoError(t, <-done)
+	assert.Equal(t, 3, storage.count("get"))
+
+	_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{storage}, m2cs.WithClock(nil))
+	assert.Error(t, err)
+}
+
+func TestRetryPolicy_Options(t *testing.T) {
+	storage := newFlakyStorage(t, true, 0)
+	invalid := []m2cs.RetryPolicy{
+		{MaxAttempts: -1},
+		{BaseDelay: -time.Second},
+		{BaseDelay: time.Second, MaxDelay: time.Millisecond},
+	}
+	for _, policy := range invalid {
+		_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+			[]filestorage.FileStorage{storage}, m2cs.WithRetryPolicy(policy))
+		assert.Error(t, err, "policy %+v should be rejected", policy)
+	}
+	_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{storage}, m2cs.WithRetryPolicy(m2cs.RetryPolicy{}, m2cs.OperationType(42)))
+	assert.Error(t, err)
+}
+
+func TestIsRetriable(t *testing.T) {
+	retriable := []error{
+		fmt.Errorf("wrapped: %w", filestorage.ErrTransient),
+		context.DeadlineExceeded,
+		minio.ErrorResponse{StatusCode: http.StatusServiceUnavailable},
+		fmt.Errorf("wrapped: %w", &azcore.ResponseError{StatusCode: http.StatusTooManyRequests}),
+	}
+	for _, err := range retriable {
+		assert.True(t, filestorage.IsRetriable(err), "%v should be retriable", err)
+	}
+
+	permanent := []error{
+		nil,
+		context.Canceled,
+		errors.New("access denied"),
+		minio.ErrorResponse{StatusCode: http.StatusNotFound},
+		&azcore.ResponseError{StatusCode: http.StatusForbidden},
+	}
+	for _, err := range permanent {
+		assert.False(t, filestorage.IsRetriable(err), "%v should not be retriable", err)
+	}
+}
+
+// recordingClock records the delays waited on it, and returns at once.
+type recordingClock struct {
+	*storagetest.FakeClock
+
+	mu    sync.Mutex
+	waits []time.Duration
+}
+
+func (c *recordingClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	c.waits = append(c.waits, d)
+	c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	ch <- c.Now()
+	return ch
+}
+
+func (c *recordingClock) get() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.waits...)
+}
+
+// throttled returns the answer of Azure throttling the requests, asking to wait retryAfter.
+func throttled(retryAfter string) error {
+	header := http.Header{}
+	if retryAfter != "" {
+		header.Set("Retry-After", retryAfter)
+	}
+	return &azcore.ResponseError{
+		ErrorCode:   "TooManyRequests",
+		StatusCode:  http.StatusTooManyRequests,
+		RawResponse: &http.Response{StatusCode: http.StatusTooManyRequests, Header: header},
+	}
+}
+
+func TestRetryPolicy_Throttling(t *testing.T) {
+	policy := m2cs.RetryPolicy{MaxAttempts: 4, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	// a throttled replica is retried with growing delays, at least the one it asks for, and
+	// the read does not fall back to the main storage
+	clk := &recordingClock{FakeClock: storagetest.NewFakeClock(time.Now())}
+	replica := newFlakyStorage(t, false, 3)
+	replica.err = throttled("1")
+	main := newFlakyStorage(t, true, 0)
+	client := newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy), m2cs.WithClock(clk)}, replica, main)
+	data, err := read(t, client)
+	require.NoError(t, err)
+	assert.Equal(t, "data", data)
+	assert.Equal(t, 4, replica.count("get"))
+	assert.Equal(t, 0, main.count("get"), "a throttled storage is not declared failed")
+
+	waits := clk.get()
+	require.Len(t, waits, 3)
+	for i, wait := range waits {
+		assert.GreaterOrEqual(t, wait, time.Second, "Retry-After should be honored")
+		if i > 0 {
+			assert.GreaterOrEqual(t, wait, waits[i-1], "the delays should grow")
+		}
+	}
+	assert.GreaterOrEqual(t, waits[2], 2*time.Second)
+
+	// the delay asked for is bounded by MaxDelay
+	clk = &recordingClock{FakeClock: storagetest.NewFakeClock(time.Now())}
+	storage := newFlakyStorage(t, true, 1)
+	storage.err = throttled("120")
+	client = newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy), m2cs.WithClock(clk)}, storage)
+	_, err = read(t, client)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{10 * time.Second}, clk.get())
+
+	// once the attempts are exhausted, the failure is reported as throttling
+	storage = newFlakyStorage(t, true, 10)
+	storage.err = minio.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable}
+	client = newClient(t, []m2cs.FileClientOption{m2cs.WithRetryPolicy(policy), m2cs.WithClock(clk)}, storage)
+	_, err = read(t, client)
+	assert.ErrorIs(t, err, m2cs.ErrThrottled)
+	assert.ErrorContains(t, err, "after 4 attempts")
+	assert.Equal(t, 4, storage.count("get"))
+}
+
+func TestIsThrottled(t *testing.T) {
+	s3Err := &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Header:     http.Header{"Retry-After": []string{"3"}},
+		}},
+		Err: errors.New("SlowDown"),
+	}}
+	throttling := []error{
+		fmt.Errorf("wrapped: %w", filestorage.ErrThrottled),
+		&filestorage.ThrottledError{RetryAfter: time.Second},
+		minio.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable},
+		s3Err,
+		&azcore.ResponseError{ErrorCode: "ServerBusy", StatusCode: http.StatusServiceUnavailable},
+		&azcore.ResponseError{
+			StatusCode:  http.StatusServiceUnavailable,
+			RawResponse: &http.Response{Header: http.Header{"Retry-After": []string{"1"}}},
+		},
+		throttled(""),
+	}
+	for _, err := range throttling {
+		assert.True(t, filestorage.IsThrottled(err), "%v should be throttling", err)
+		assert.True(t, filestorage.IsRetriable(err), "%v should be retriable", err)
+	}
+
+	other := []error{
+		nil,
+		fmt.Errorf("wrapped: %w", filestorage.ErrTransient),
+		context.DeadlineExceeded,
+		minio.ErrorResponse{StatusCode: http.StatusInternalServerError},
+		&azcore.ResponseError{StatusCode: http.StatusForbidden},
+		// a 503 not asking to slow down is an outage rather than back-pressure
+		minio.ErrorResponse{Code: "ServiceUnavailable", StatusCode: http.StatusServiceUnavailable},
+		&azcore.ResponseError{StatusCode: http.StatusServiceUnavailable},
+		&awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}},
+			Err:      errors.New("service unavailable"),
+		}},
+	}
+	for _, err := range other {
+		assert.False(t, filestorage.IsThrottled(err), "%v should not be throttling", err)
+	}
+
+	after, ok := filestorage.RetryAfter(fmt.Errorf("wrapped: %w", s3Err))
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, after)
+	after, ok = filestorage.RetryAfter(throttled(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)))
+	assert.True(t, ok)
+	assert.InDelta(t, time.Minute, after, float64(2*time.Second))
+	after, ok = filestorage.RetryAfter(&filestorage.ThrottledError{RetryAfter: time.Second})
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, after)
+	_, ok = filestorage.RetryAfter(throttled(""))
+	assert.False(t, ok)
+}
+
+// TestFileClient_ThrottledWrites tests that the writes to a throttled storage are narrowed rather
+// than failing the storage, then widened back as the writes succeed.
+func TestFileClient_ThrottledWrites(t *testing.T) {
+	clk := storagetest.NewFakeClock(time.Now())
+	throttled := storagetest.Wrap(
+		storagetest.NewMemory(t, "throttled", "box"),
+		storagetest.LatencyWithClock(clk, time.Second),
+		storagetest.FailNTimes(4, &filestorage.ThrottledError{}),
+	)
+	client := newClient(t, nil, storagetest.NewMemory(t, "fast", "box"), throttled)
+
+	put := func(keys ...string) <-chan error {
+		errs := make(chan error, len(keys))
+		for _, key := range keys {
+			go func() {
+				errs <- client.PutObject(context.Background(), "box", key, strings.NewReader("data"))
+			}()
+		}
+		return errs
+	}
+	wait := func(errs <-chan error, n int) []error {
+		var got []error
+		for range n {
+			got = append(got, <-errs)
+		}
+		return got
+	}
+
+	// 4 writes throttled together narrow the storage down to a single write in flight
+	errs := put("a", "b", "c", "d")
+	clk.BlockUntil(4)
+	clk.Advance(time.Second)
+	for _, err := range wait(errs, 4) {
+		assert.ErrorIs(t, err, m2cs.ErrThrottled)
+	}
+	assert.Equal(t, map[string]int{"throttled": 1}, client.WriteConcurrencyLimits())
+
+	// a second write waits for the first one, whose success widens the limit
+	errs = put("e", "f")
+	clk.BlockUntil(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, clk.BlockUntilContext(ctx, 2), context.DeadlineExceeded, "the writes should be narrowed")
+	clk.Advance(time.Second)
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	for _, err := range wait(errs, 2) {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, map[string]int{"throttled": 3}, client.WriteConcurrencyLimits())
+
+	// the limit is lifted once back to the concurrency the storage was throttled at
+	errs = put("g")
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	require.NoError(t, <-errs)
+	assert.Empty(t, client.WriteConcurrencyLimits())
+}
+
+// uploadSpy counts the writes reaching a MemoryClient, skipped ones excluded,
+// and records the size given to the last one.
+type uploadSpy struct {
+	*filestorage.MemoryClient
+
+	mu      sync.Mutex
+	uploads int
+	size    int64
+}
+
+func (s *uploadSpy) PutObjectWithOptions(ctx context.Context, storeBox, fileName string, reader io.Reader, opts filestorage.PutOptions) error {
+	read := false
+	err := s.MemoryClient.PutObjectWithOptions(ctx, storeBox, fileName, readFunc(func(p []byte) (int, error) {
+		read = true
+		return reader.Read(p)
+	}), opts)
+	if read {
+		s.mu.Lock()
+		s.uploads++
+		s.size = opts.Size
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *uploadSpy) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uploads
+}
+
+type readFunc func(p []byte) (int, error)
+
+func (f readFunc) Read(p []byte) (int, error) { return f(p) }
+
+func TestFileClient_IdempotencyKey(t *testing.T) {
+	var spies []*uploadSpy
+	var storages []filestorage.FileStorage
+	for i := 0; i < 2; i++ {
+		memory := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+		require.NoError(t, memory.MakeBucket(context.Background(), "box"))
+		spy := &uploadSpy{MemoryClient: memory}
+		spies = append(spies, spy)
+		storages = append(storages, spy)
+	}
+	client := newClient(t, nil, storages...)
+
+	put := func(content, key string) {
+		err := client.PutObjectWithOptions(context.Background(), "box", "key", strings.NewReader(content), m2cs.PutOptions{IdempotencyKey: key})
+		require.NoError(t, err)
+	}
+
+	// a retried write with the same key is not uploaded again
+	put("first", "write-1")
+	put("first", "write-1")
+	for _, spy := range spies {
+		assert.Equal(t, 1, spy.count())
+	}
+
+	// a write with a different key overwrites the object
+	put("second", "write-2")
+	for _, spy := range spies {
+		assert.Equal(t, 2, spy.count())
+	}
+	data, err := read(t, client)
+	require.NoError(t, err)
+	assert.Equal(t, "second", data)
+
+	// storages without put options cannot honor the key
+	plain := newFlakyStorage(t, true, 0)
+	client = newClient(t, nil, plain)
+	err = client.PutObjectWithOptions(context.Background(), "box", "key", strings.NewReader("data"), m2cs.PutOptions{IdempotencyKey: "write-1"})
+	assert.ErrorContains(t, err, "idempotency keys are not supported")
+}
+
+func TestFileClient_PutObjectSize(t *testing.T) {
+	memory := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+	require.NoError(t, memory.MakeBucket(context.Background(), "box"))
+	spy := &uploadSpy{MemoryClient: memory}
+	plain := newFlakyStorage(t, true, 0)
+	client := newClient(t, nil, spy, plain)
+
+	// the size of a reader not reporting its length reaches the storages accepting it
+	body := strings.NewReader("payload")
+	err := client.PutObjectWithOptions(context.Background(), "box", "key", readFunc(body.Read), m2cs.PutOptions{Size: 7})
+	require.NoError(t, err)
+	assert.Equal(t, 1, spy.count())
+	assert.Equal(t, int64(7), spy.size)
+	data, err := read(t, client)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", data)
+
+	// a wrong size fails before any storage is written
+	err = client.PutObjectWithOptions(context.Background(), "box", "key", strings.NewReader("other"), m2cs.PutOptions{Size: 7})
+	assert.ErrorContains(t, err, "read 5 bytes, PutOptions.Size is 7")
+	assert.Equal(t, 1, spy.count())
+}
+
+func TestFileClient_ChecksumAlgorithm(t *testing.T) {
+	memory := filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+	require.NoError(t, memory.MakeBucket(context.Background(), "box"))
+	client := newClient(t, nil, memory)
+
+	err := client.PutObjectWithOptions(context.Background(), "box", "key", strings.NewReader("data"), m2cs.PutOptions{ChecksumAlgorithm: m2cs.CRC32C_CHECKSUM})
+	require.NoError(t, err)
+	stat, err := memory.StatObject(context.Background(), "box", "key")
+	require.NoError(t, err)
+	assert.NotEmpty(t, stat.Checksums[m2cs.CRC32C_CHECKSUM])
+
+	// storages without put options cannot send the checksum
+	client = newClient(t, nil, newFlakyStorage(t, true, 0))
+	err = client.PutObjectWithOptions(context.Background(), "box", "key", strings.NewReader("data"), m2cs.PutOptions{ChecksumAlgorithm: m2cs.CRC32C_CHECKSUM})
+	assert.ErrorContains(t, err, "checksums are not supported")
+}
@@ -0,0 +1,178 @@
+package roles_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+func newStorage(t *testing.T, label string, role common.StorageRole) *filestorage.MemoryClient {
+	return storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{
+		Label:          label,
+		IsMainInstance: role != common.REPLICA,
+		Role:           role,
+	}, "box")
+}
+
+// holds reports whether s holds the object written by put with the given content.
+func holds(t *testing.T, s filestorage.FileStorage, content string) bool {
+	obj, err := s.GetObject(context.Background(), "box", "key")
+	if errors.Is(err, filestorage.ErrObjectNotFound) {
+		return false
+	}
+	require.NoError(t, err)
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	return string(data) == content
+}
+
+func put(client *m2cs.FileClient, content string) error {
+	return client.PutObject(context.Background(), "box", "key", strings.NewReader(content))
+}
+
+func TestFileClient_PrimaryDown(t *testing.T) {
+	for _, mode := range []m2cs.ReplicationMode{m2cs.SYNC_REPLICATION, m2cs.ASYNC_REPLICATION} {
+		t.Run(mode.String(), func(t *testing.T) {
+			primary := newStorage(t, "primary", m2cs.PRIMARY)
+			secondary := newStorage(t, "secondary", m2cs.SECONDARY_MAIN)
+			outage := storagetest.NewOutage(errors.New("connection refused"))
+			client, err := m2cs.NewFileClientWithOptions(mode, m2cs.READ_REPLICA_FIRST,
+				[]filestorage.FileStorage{secondary, storagetest.Wrap(primary, outage.Decorator())})
+			require.NoError(t, err)
+
+			label, ok := client.Primary()
+			assert.True(t, ok)
+			assert.Equal(t, "primary", label)
+
+			// the write is rejected without reaching the secondary
+			outage.Down()
+			err = put(client, "v1")
+			assert.ErrorIs(t, err, m2cs.ErrPrimaryUnavailable)
+			assert.ErrorContains(t, err, "connection refused")
+			assert.False(t, holds(t, secondary, "v1"), "the secondary must not get ahead of the primary")
+
+			outage.Up()
+			require.NoError(t, put(client, "v2"))
+			assert.True(t, holds(t, primary, "v2"))
+			assert.Eventually(t, func() bool { return holds(t, secondary, "v2") }, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestFileClient_PromoteToPrimary(t *testing.T) {
+	primary := newStorage(t, "primary", m2cs.PRIMARY)
+	secondary := newStorage(t, "secondary", m2cs.SECONDARY_MAIN)
+	replica := newStorage(t, "replica", m2cs.REPLICA)
+	outage := storagetest.NewOutage(errors.New("connection refused"))
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{storagetest.Wrap(primary, outage.Decorator()), secondary, replica})
+	require.NoError(t, err)
+
+	outage.Down()
+	require.ErrorIs(t, put(client, "v1"), m2cs.ErrPrimaryUnavailable)
+
+	require.NoError(t, client.PromoteToPrimary("secondary"))
+	label, _ := client.Primary()
+	assert.Equal(t, "secondary", label)
+
+	roles := map[string]m2cs.StorageRole{}
+	for _, s := range client.GetStorages() {
+		roles[s.Label] = s.Role
+	}
+	assert.Equal(t, map[string]m2cs.StorageRole{"primary": m2cs.SECONDARY_MAIN, "secondary": m2cs.PRIMARY, "replica": m2cs.REPLICA}, roles)
+
+	// the demoted primary is still required by default
+	err = put(client, "v2")
+	assert.NotErrorIs(t, err, m2cs.ErrPrimaryUnavailable)
+	assert.ErrorContains(t, err, "PutObject partially failed on 1/2 storages")
+	assert.True(t, holds(t, secondary, "v2"))
+	assert.False(t, holds(t, replica, "v2"), "replicas are not written")
+
+	outage.Up()
+	require.NoError(t, put(client, "v3"))
+	assert.True(t, holds(t, primary, "v3"))
+	assert.True(t, holds(t, secondary, "v3"))
+
+	assert.ErrorContains(t, client.PromoteToPrimary("replica"), "not a main storage")
+	assert.ErrorContains(t, client.PromoteToPrimary("missing"), "not found")
+}
+
+func TestFileClient_BestEffortSecondaries(t *testing.T) {
+	primary := newStorage(t, "primary", m2cs.PRIMARY)
+	secondary := newStorage(t, "secondary", m2cs.SECONDARY_MAIN)
+	outage := storagetest.NewOutage(errors.New("connection refused"))
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{primary, storagetest.Wrap(secondary, outage.Decorator())}, m2cs.WithBestEffortSecondaries())
+	require.NoError(t, err)
+
+	outage.Down()
+	require.NoError(t, put(client, "v1"), "the failures of the secondaries are only logged")
+	assert.True(t, holds(t, primary, "v1"))
+	assert.False(t, holds(t, secondary, "v1"))
+}
+
+func TestFileClient_RoleValidation(t *testing.T) {
+	newClient := func(storages ...filestorage.FileStorage) error {
+		_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storages)
+		return err
+	}
+
+	err := newClient(newStorage(t, "a", m2cs.PRIMARY), newStorage(t, "b", m2cs.PRIMARY))
+	assert.ErrorContains(t, err, "at most one is allowed")
+
+	notMain := filestorage.NewMemoryClient(common.ConnectionProperties{Label: "a", Role: common.PRIMARY})
+	assert.ErrorContains(t, newClient(notMain), "is not a main instance")
+
+	mainReplica := filestorage.NewMemoryClient(common.ConnectionProperties{Label: "a", Role: common.REPLICA, IsMainInstance: true})
+	assert.ErrorContains(t, newClient(mainReplica), "is a main instance")
+
+	// without roles, the writes are not led by any storage
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true}))
+	_, ok := client.Primary()
+	assert.False(t, ok)
+}
+
+func TestFileClient_StorageValidation(t *testing.T) {
+	newClient := func(storages []filestorage.FileStorage, opts ...m2cs.FileClientOption) error {
+		_, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, storages, opts...)
+		return err
+	}
+	a := newStorage(t, "a", m2cs.NO_ROLE)
+
+	var missing *filestorage.MemoryClient
+	for _, storage := range []filestorage.FileStorage{nil, missing} {
+		err := newClient([]filestorage.FileStorage{a, storage})
+		assert.ErrorIs(t, err, m2cs.ErrInvalidConfiguration)
+		assert.ErrorContains(t, err, "storage at index 1 is nil")
+	}
+
+	err := newClient([]filestorage.FileStorage{a, newStorage(t, "b", m2cs.NO_ROLE), a})
+	assert.ErrorIs(t, err, m2cs.ErrInvalidConfiguration)
+	assert.ErrorContains(t, err, "storages at index 0 and 2 are the same instance")
+
+	err = newClient([]filestorage.FileStorage{a, newStorage(t, "a", m2cs.REPLICA)})
+	assert.ErrorIs(t, err, m2cs.ErrInvalidConfiguration)
+	assert.ErrorContains(t, err, `storages at index 0 and 1 have the same label "a"`)
+
+	// storages without a label are only compared by instance
+	unlabeled := func() filestorage.FileStorage {
+		return filestorage.NewMemoryClient(common.ConnectionProperties{IsMainInstance: true})
+	}
+	assert.NoError(t, newClient([]filestorage.FileStorage{unlabeled(), unlabeled()}))
+
+	assert.NoError(t, newClient([]filestorage.FileStorage{a, a}, m2cs.WithAllowDuplicates()))
+	assert.ErrorContains(t, newClient([]filestorage.FileStorage{a, nil}, m2cs.WithAllowDuplicates()), "is nil")
+}
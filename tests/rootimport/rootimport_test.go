@@ -0,0 +1,94 @@
+// Package rootimport_test checks that an application can use the library importing the root
+// package only: it must not import any other package of the module.
+package rootimport_test
+
+import (
+	"context"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+)
+
+func newStorage(t *testing.T, label string, main bool) *m2cs.MemoryClient {
+	storage := m2cs.NewMemoryClient(m2cs.ConnectionProperties{Label: label, IsMainInstance: main, SaveCompress: m2cs.GZIP_COMPRESSION})
+	require.NoError(t, storage.MakeBucket(context.Background(), "box"))
+	return storage
+}
+
+func TestRootImport(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newStorage(t, "eu", true), newStorage(t, "us", true)
+	mirror, err := m2cs.NewMirroredStorage(primary, secondary, m2cs.MirrorOptions{Label: "mirror"})
+	require.NoError(t, err)
+	replica := newStorage(t, "replica", false)
+
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]m2cs.FileStorage{mirror, replica}, m2cs.WithRetryPolicy(m2cs.RetryPolicy{MaxAttempts: 2}))
+	require.NoError(t, err)
+	require.NoError(t, client.ConfigureCache(m2cs.CacheOptions{Enabled: true}))
+
+	require.NoError(t, client.PutObject(ctx, "box", "key", strings.NewReader("data")))
+	for _, s := range []m2cs.FileStorage{primary, secondary} {
+		exists, err := s.ExistObject(ctx, "box", "key")
+		require.NoError(t, err)
+		assert.True(t, exists, "%s should hold the object", s.GetConnectionProperties().Label)
+	}
+
+	_, err = client.GetObject(ctx, "box", "missing")
+	assert.ErrorIs(t, err, m2cs.ErrObjectNotFound)
+	_, err = primary.GetObject(ctx, "other", "key")
+	assert.ErrorIs(t, err, m2cs.ErrBoxNotFound)
+}
+
+// TestRootImport_Imports keeps this package importing the root package only, among the packages
+// of the module, so that the test above stays a check of the re-exported surface.
+func TestRootImport_Imports(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	for _, file := range files {
+		parsed, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly)
+		require.NoError(t, err)
+		for _, spec := range parsed.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			require.NoError(t, err)
+			if strings.HasPrefix(path, "github.com/tizianocitro/m2cs") {
+				assert.Equal(t, "github.com/tizianocitro/m2cs", path, "%s imports a subpackage", file)
+			}
+		}
+	}
+}
+
+// Example_rootImport writes and reads an object with a client of an in-memory storage, using
+// the root package only.
+func Example_rootImport() {
+	ctx := context.Background()
+	storage := m2cs.NewMemoryClient(m2cs.ConnectionProperties{Label: "memory", IsMainInstance: true})
+	if err := storage.MakeBucket(ctx, "box"); err != nil {
+		panic(err)
+	}
+
+	var s m2cs.FileStorage = storage
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST, s)
+	if err := client.PutObject(ctx, "box", "hello.txt", strings.NewReader("hello")); err != nil {
+		panic(err)
+	}
+	obj, err := client.GetObject(ctx, "box", "hello.txt")
+	if err != nil {
+		panic(err)
+	}
+	defer obj.Close()
+	data, _ := io.ReadAll(obj)
+	fmt.Println(string(data))
+	// Output: hello
+}
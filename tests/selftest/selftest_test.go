@@ -0,0 +1,112 @@
+package selftest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+func newStorage(t *testing.T, label, box string) *filestorage.MemoryClient {
+	return storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{
+		Label:          label,
+		IsMainInstance: true,
+		SaveCompress:   common.GZIP_COMPRESSION,
+		SaveEncrypt:    common.AES256_ENCRYPTION,
+		EncryptKey:     "m2cs-" + label + "-passphrase",
+	}, box)
+}
+
+func steps(result m2cs.SelfTestResult) []string {
+	var names []string
+	for _, step := range result.Steps {
+		names = append(names, step.Name)
+	}
+	return names
+}
+
+func TestSelfTest(t *testing.T) {
+	first, second := newStorage(t, "first", m2cs.DefaultDiagnosticsBox), newStorage(t, "second", m2cs.DefaultDiagnosticsBox)
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{first, second})
+	require.NoError(t, err)
+
+	report, err := client.SelfTestWithReport(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, m2cs.DefaultDiagnosticsBox, report.StoreBox)
+	require.Len(t, report.Storages, 2)
+	for _, result := range report.Storages {
+		assert.NoError(t, result.Err)
+		assert.Equal(t, []string{m2cs.SelfTestPut, m2cs.SelfTestGet, m2cs.SelfTestRemove, m2cs.SelfTestCanary}, steps(result))
+		assert.Positive(t, result.Duration)
+	}
+	assert.Positive(t, report.Duration)
+
+	// only the canary is left behind
+	objects, err := first.ListObjectsInfo(context.Background(), m2cs.DefaultDiagnosticsBox, "")
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "m2cs-selftest-canary", objects[0].Key)
+
+	require.NoError(t, client.SelfTest(context.Background()))
+}
+
+// TestSelfTest_WrongKey checks a storage deployed with another EncryptKey fails on the canary
+// written by the previous runs, and is named in the error, while the others pass.
+func TestSelfTest_WrongKey(t *testing.T) {
+	first, second := newStorage(t, "first", m2cs.DefaultDiagnosticsBox), newStorage(t, "second", m2cs.DefaultDiagnosticsBox)
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{first, second})
+	require.NoError(t, err)
+	require.NoError(t, client.SelfTest(context.Background()))
+
+	require.NoError(t, second.RotateEncryptKey("m2cs-wrong-passphrase"))
+	report, err := client.SelfTestWithReport(context.Background())
+	require.Error(t, err)
+
+	var partial *m2cs.PartialFailureError
+	require.ErrorAs(t, err, &partial)
+	require.Len(t, partial.Failures, 1)
+	assert.Equal(t, "second", partial.Failures[0].Label)
+	assert.Contains(t, err.Error(), "SelfTest failed on storage second: canary:")
+
+	assert.NoError(t, report.Storages[0].Err)
+	require.Error(t, report.Storages[1].Err)
+	last := report.Storages[1].Steps[len(report.Storages[1].Steps)-1]
+	assert.Equal(t, m2cs.SelfTestCanary, last.Name)
+	assert.Error(t, last.Err)
+}
+
+func TestSelfTest_MissingBox(t *testing.T) {
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{newStorage(t, "first", "other"), newStorage(t, "second", "other")})
+	require.NoError(t, err)
+
+	report, err := client.SelfTestWithReport(context.Background())
+	require.ErrorContains(t, err, "SelfTest failed on all storages")
+	assert.True(t, errors.Is(err, filestorage.ErrBoxNotFound))
+	for _, result := range report.Storages {
+		assert.Equal(t, []string{m2cs.SelfTestPut}, steps(result))
+	}
+}
+
+func TestSelfTest_DiagnosticsBox(t *testing.T) {
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{newStorage(t, "first", "diagnostics")}, m2cs.WithDiagnosticsBox("diagnostics"))
+	require.NoError(t, err)
+
+	report, err := client.SelfTestWithReport(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "diagnostics", report.StoreBox)
+
+	_, err = m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{newStorage(t, "first", "diagnostics")}, m2cs.WithDiagnosticsBox(""))
+	assert.EqualError(t, err, "diagnostics box is empty")
+}
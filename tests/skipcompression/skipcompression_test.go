@@ -0,0 +1,116 @@
+package skipcompression_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+	"github.com/tizianocitro/m2cs/pkg/transform/compression"
+)
+
+func random(t *testing.T, size int) []byte {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	return data
+}
+
+// put writes data as fileName and returns its stored size.
+func put(t *testing.T, client *filestorage.MemoryClient, fileName string, reader io.Reader) int64 {
+	require.NoError(t, client.PutObject(context.Background(), "box", fileName, reader))
+	stat, err := client.StatObject(context.Background(), "box", fileName)
+	require.NoError(t, err)
+	return stat.Size
+}
+
+func get(t *testing.T, client *filestorage.MemoryClient, fileName string) []byte {
+	reader, err := client.GetObject(context.Background(), "box", fileName)
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return data
+}
+
+func TestSkipCompression_Incompressible(t *testing.T) {
+	for _, compress := range []common.CompressionAlgorithm{common.GZIP_COMPRESSION, common.GZIP_CONTENT_ENCODING} {
+		t.Run(compress.String(), func(t *testing.T) {
+			client := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{SaveCompress: compress}, "box")
+
+			for name, size := range map[string]int{"small": 1000, "large": 3 * compression.SampleSize} {
+				data := random(t, size)
+
+				// buffered, then streamed
+				assert.Equal(t, int64(size), put(t, client, name, bytes.NewReader(data)), "stored raw")
+				assert.Equal(t, data, get(t, client, name))
+				assert.Equal(t, int64(size), put(t, client, name+"-stream", io.MultiReader(bytes.NewReader(data))), "stored raw")
+				assert.Equal(t, data, get(t, client, name+"-stream"))
+
+				_, stat, err := client.GetObjectWithInfo(context.Background(), "box", name)
+				require.NoError(t, err)
+				assert.Equal(t, int64(size), stat.Size)
+			}
+		})
+	}
+}
+
+func TestSkipCompression_Compressible(t *testing.T) {
+	client := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{SaveCompress: common.GZIP_COMPRESSION}, "box")
+	text := strings.Repeat("compressible text ", 10000)
+
+	assert.Less(t, put(t, client, "text", strings.NewReader(text)), int64(len(text))/10)
+	assert.Equal(t, text, string(get(t, client, "text")))
+	assert.Less(t, put(t, client, "stream", io.MultiReader(strings.NewReader(text))), int64(len(text))/10)
+	assert.Equal(t, text, string(get(t, client, "stream")))
+}
+
+func TestSkipCompression_Encrypted(t *testing.T) {
+	client := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{
+		SaveCompress: common.GZIP_COMPRESSION,
+		SaveEncrypt:  common.AES256_ENCRYPTION,
+		EncryptKey:   "fTq8-Lx2!vRz9#Kp",
+	}, "box")
+	data := random(t, 10000)
+
+	stored := put(t, client, "random", bytes.NewReader(data))
+	assert.Greater(t, stored, int64(len(data)), "still encrypted")
+	assert.Equal(t, data, get(t, client, "random"))
+}
+
+func TestSkipCompression_Threshold(t *testing.T) {
+	data := random(t, 10000)
+
+	// a negative threshold compresses everything
+	client := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{SaveCompress: common.GZIP_COMPRESSION, CompressionThreshold: -1}, "box")
+	assert.Greater(t, put(t, client, "random", bytes.NewReader(data)), int64(len(data)))
+	assert.Equal(t, data, get(t, client, "random"))
+
+	// half random, half zeros compresses to about 50%
+	half := append(random(t, 5000), make([]byte, 5000)...)
+	client = storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{SaveCompress: common.GZIP_COMPRESSION, CompressionThreshold: 0.4}, "box")
+	assert.Equal(t, int64(len(half)), put(t, client, "half", bytes.NewReader(half)))
+	client = storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{SaveCompress: common.GZIP_COMPRESSION, CompressionThreshold: 0.6}, "box")
+	assert.Less(t, put(t, client, "half", bytes.NewReader(half)), int64(len(half)))
+	assert.Equal(t, half, get(t, client, "half"))
+
+	assert.True(t, compression.Compressible(nil, compression.DefaultMaxRatio))
+	assert.False(t, compression.Compressible(data, compression.DefaultMaxRatio))
+	assert.Equal(t, 0.97, m2cs.DefaultCompressionThreshold)
+
+	err := m2cs.ConnectionOptions{
+		ConnectionMethod:     m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"),
+		CompressionThreshold: math.NaN(),
+	}.Validate(m2cs.MINIO_BACKEND)
+	assert.EqualError(t, err, "invalid MinIO connection: CompressionThreshold must be a finite number, got NaN")
+}
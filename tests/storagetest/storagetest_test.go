@@ -0,0 +1,303 @@
+package storagetest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+func put(s filestorage.FileStorage, key string) error {
+	return s.PutObject(context.Background(), "box", key, strings.NewReader(key))
+}
+
+func TestStoragetest_Latency(t *testing.T) {
+	s := storagetest.Wrap(storagetest.NewMemory(t, "memory", "box"), storagetest.Latency(50*time.Millisecond))
+	assert.Equal(t, "memory", s.GetConnectionProperties().Label)
+
+	start := time.Now()
+	require.NoError(t, put(s, "key"))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := s.ExistObject(ctx, "box", "key")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestStoragetest_LatencyWithClock(t *testing.T) {
+	clk := storagetest.NewFakeClock(time.Now())
+	memory := storagetest.NewMemory(t, "memory", "box")
+	s := storagetest.Wrap(memory, storagetest.LatencyWithClock(clk, time.Hour))
+
+	done := make(chan error, 1)
+	go func() { done <- put(s, "key") }()
+	clk.BlockUntil(1)
+	exists, err := memory.ExistObject(context.Background(), "box", "key")
+	require.NoError(t, err)
+	assert.False(t, exists, "the write should wait for the clock")
+
+	clk.Advance(time.Hour)
+	require.NoError(t, <-done)
+	exists, err = memory.ExistObject(context.Background(), "box", "key")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.ExistObject(ctx, "box", "key")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, clk.BlockUntilContext(ctx, 2), context.Canceled)
+}
+
+func TestStoragetest_Gate(t *testing.T) {
+	gate := storagetest.NewGate()
+	memory := storagetest.NewMemory(t, "memory", "box")
+	s := storagetest.Wrap(memory, gate.Decorator())
+
+	done := make(chan error, 1)
+	go func() { done <- put(s, "key") }()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := s.ExistObject(ctx, "box", "key")
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "the operations should be held")
+
+	gate.Release()
+	gate.Release()
+	require.NoError(t, <-done)
+	exists, err := s.ExistObject(context.Background(), "box", "key")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	gate.Hold()
+	go func() { done <- put(s, "other") }()
+	gate.Release()
+	require.NoError(t, <-done)
+}
+
+func TestStoragetest_Outage(t *testing.T) {
+	unavailable := errors.New("storage unavailable")
+	outage := storagetest.NewOutage(unavailable)
+	memory := storagetest.NewMemory(t, "memory", "box")
+	s := storagetest.Wrap(memory, outage.Decorator())
+
+	require.NoError(t, put(s, "key"))
+	outage.Down()
+	assert.ErrorIs(t, put(s, "other"), unavailable)
+	_, err := s.GetObject(context.Background(), "box", "key")
+	assert.ErrorIs(t, err, unavailable)
+	exists, err := memory.ExistObject(context.Background(), "box", "other")
+	require.NoError(t, err)
+	assert.False(t, exists, "the failed operations should not reach the storage")
+
+	outage.Up()
+	require.NoError(t, put(s, "other"))
+
+	injected := storagetest.NewOutage(nil)
+	injected.Down()
+	assert.ErrorIs(t, put(storagetest.Wrap(memory, injected.Decorator()), "key"), storagetest.ErrInjected)
+}
+
+func TestStoragetest_NewMemory(t *testing.T) {
+	memory := storagetest.NewMemory(t, "memory", "first", "second")
+	properties := memory.GetConnectionProperties()
+	assert.Equal(t, "memory", properties.Label)
+	assert.True(t, properties.IsMainInstance)
+	for _, box := range []string{"first", "second"} {
+		require.NoError(t, memory.PutObject(context.Background(), box, "key", strings.NewReader("data")))
+	}
+
+	replica := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "replica"})
+	assert.False(t, replica.GetConnectionProperties().IsMainInstance)
+	assert.Error(t, replica.PutObject(context.Background(), "box", "key", strings.NewReader("data")), "no store box is created")
+}
+
+func TestStoragetest_FailNTimes(t *testing.T) {
+	refused := errors.New("connection refused")
+	memory := storagetest.NewMemory(t, "memory", "box")
+	s := storagetest.Wrap(memory, storagetest.FailNTimes(2, refused))
+
+	assert.ErrorIs(t, put(s, "key"), refused)
+	_, err := s.GetObject(context.Background(), "box", "key")
+	assert.ErrorIs(t, err, refused)
+	require.NoError(t, put(s, "key"))
+	obj, err := s.GetObject(context.Background(), "box", "key")
+	require.NoError(t, err)
+	require.NoError(t, obj.Close())
+
+	// a nil error injects ErrInjected
+	s = storagetest.Wrap(memory, storagetest.FailNTimes(1, nil))
+	assert.ErrorIs(t, put(s, "key"), storagetest.ErrInjected)
+}
+
+func TestStoragetest_FailMatching(t *testing.T) {
+	memory := storagetest.NewMemory(t, "memory", "box")
+	s := storagetest.Wrap(memory, storagetest.FailMatching("tmp/*", nil))
+
+	assert.ErrorIs(t, put(s, "tmp/a"), storagetest.ErrInjected)
+	require.NoError(t, put(s, "data/a"))
+	exists, err := memory.ExistObject(context.Background(), "box", "tmp/a")
+	require.NoError(t, err)
+	assert.False(t, exists, "the failed operation does not reach the storage")
+
+	s = storagetest.Wrap(memory, storagetest.FailMatching("[", nil))
+	assert.ErrorIs(t, put(s, "data/a"), path.ErrBadPattern)
+}
+
+func TestStoragetest_Chaos(t *testing.T) {
+	outcomes := func(seed int64) []bool {
+		s := storagetest.Wrap(storagetest.NewMemory(t, "memory", "box"), storagetest.Chaos(0.5, seed))
+		var failed []bool
+		for i := range 100 {
+			err := put(s, fmt.Sprintf("key-%d", i))
+			if err != nil {
+				assert.ErrorIs(t, err, storagetest.ErrInjected)
+			}
+			failed = append(failed, err != nil)
+		}
+		return failed
+	}
+
+	first := outcomes(42)
+	assert.Equal(t, first, outcomes(42), "the same seed injects the same failures")
+	assert.Contains(t, first, true)
+	assert.Contains(t, first, false)
+
+	never := storagetest.Wrap(storagetest.NewMemory(t, "memory", "box"), storagetest.Chaos(0, 1))
+	always := storagetest.Wrap(storagetest.NewMemory(t, "memory", "box"), storagetest.Chaos(1, 1))
+	for range 10 {
+		assert.NoError(t, put(never, "key"))
+		assert.ErrorIs(t, put(always, "key"), storagetest.ErrInjected)
+	}
+}
+
+func TestStoragetest_Recorder(t *testing.T) {
+	recorder := storagetest.NewRecorder()
+	first := storagetest.Wrap(storagetest.NewMemory(t, "first", "box"), recorder.Decorator(""), storagetest.Latency(10*time.Millisecond))
+	second := storagetest.Wrap(storagetest.NewMemory(t, "second", "box"), recorder.Decorator("renamed"), storagetest.FailMatching("missing", nil))
+
+	require.NoError(t, put(first, "a"))
+	require.NoError(t, put(second, "a"))
+	_, err := second.GetObject(context.Background(), "box", "missing")
+	require.Error(t, err)
+	exists, err := first.ExistObject(context.Background(), "box", "a")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	require.NoError(t, first.RemoveObject(context.Background(), "box", "a"))
+
+	records := recorder.Records()
+	require.Len(t, records, 5)
+	var ops []string
+	for _, record := range records {
+		ops = append(ops, record.Storage+" "+record.Op.String()+" "+record.Key)
+	}
+	assert.Equal(t, []string{
+		"first PutObject a",
+		"renamed PutObject a",
+		"renamed GetObject missing",
+		"first ExistObject a",
+		"first RemoveObject a",
+	}, ops)
+	assert.GreaterOrEqual(t, records[0].Duration, 10*time.Millisecond, "the latency is recorded")
+	assert.ErrorIs(t, records[2].Err, storagetest.ErrInjected)
+	for i := 1; i < len(records); i++ {
+		assert.False(t, records[i].Start.Before(records[i-1].Start))
+	}
+
+	assert.Equal(t, 2, recorder.Count("", storagetest.PUT_OBJECT))
+	assert.Equal(t, 1, recorder.Count("renamed", storagetest.GET_OBJECT))
+	assert.Empty(t, recorder.Successes(storagetest.GET_OBJECT))
+	assert.Equal(t, "Operation(42)", storagetest.Operation(42).String())
+
+	recorder.Reset()
+	assert.Empty(t, recorder.Records())
+}
+
+func TestStoragetest_Concurrent(t *testing.T) {
+	recorder := storagetest.NewRecorder()
+	s := storagetest.Wrap(storagetest.NewMemory(t, "memory", "box"), recorder.Decorator(""), storagetest.Chaos(0.3, 7), storagetest.FailNTimes(5, nil))
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 25 {
+				_ = put(s, fmt.Sprintf("key-%d-%d", i, j))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 200, recorder.Count("memory", storagetest.PUT_OBJECT))
+}
+
+func TestStoragetest_FileClient(t *testing.T) {
+	recorder := storagetest.NewRecorder()
+	first := storagetest.Wrap(storagetest.NewMemory(t, "first", "box"), recorder.Decorator(""), storagetest.FailNTimes(1, filestorage.ErrTransient))
+	second := storagetest.Wrap(storagetest.NewMemory(t, "second", "box"), recorder.Decorator(""))
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN,
+		[]filestorage.FileStorage{first, second}, m2cs.WithRetryPolicy(m2cs.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+	require.NoError(t, err)
+
+	require.NoError(t, client.PutObject(context.Background(), "box", "key", strings.NewReader("data")))
+	assert.Equal(t, 2, recorder.Count("first", storagetest.PUT_OBJECT), "the injected failure is retried")
+	assert.Equal(t, 1, recorder.Count("second", storagetest.PUT_OBJECT))
+
+	obj, err := client.GetObject(context.Background(), "box", "key")
+	require.NoError(t, err)
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	assert.Len(t, recorder.Successes(storagetest.GET_OBJECT), 1)
+}
+
+func TestStoragetest_FakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := storagetest.NewFakeClock(start)
+	assert.Equal(t, start, clk.Now())
+
+	after := clk.After(time.Second)
+	ticker := clk.NewTicker(300 * time.Millisecond)
+	clk.Advance(500 * time.Millisecond)
+	assert.Equal(t, start.Add(500*time.Millisecond), clk.Now())
+	assert.Len(t, after, 0, "After should not fire before its deadline")
+	assert.Equal(t, start.Add(300*time.Millisecond), <-ticker.C())
+
+	// the ticks not received are dropped, like the ones of time.Ticker
+	clk.Advance(time.Second)
+	assert.Equal(t, start.Add(time.Second), <-after)
+	assert.Equal(t, start.Add(600*time.Millisecond), <-ticker.C())
+	assert.Len(t, ticker.C(), 0)
+
+	ticker.Reset(time.Minute)
+	clk.Advance(59 * time.Second)
+	assert.Len(t, ticker.C(), 0, "Reset should restart the period")
+	clk.Advance(time.Second)
+	assert.Len(t, ticker.C(), 1)
+	<-ticker.C()
+	ticker.Stop()
+	clk.Advance(time.Hour)
+	assert.Len(t, ticker.C(), 0, "a stopped ticker should not tick")
+
+	slept := make(chan struct{})
+	go func() {
+		clk.Sleep(time.Second)
+		close(slept)
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	<-slept
+}
@@ -0,0 +1,286 @@
+package transform_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tizianocitro/m2cs/pkg/transform"
+	"github.com/tizianocitro/m2cs/pkg/transform/compression"
+	"github.com/tizianocitro/m2cs/pkg/transform/encryption"
+)
+
+// recordingTransform upper-cases its input and returns a closer recording its name
+// in closed. When fail is set, Apply fails without returning a closer.
+type recordingTransform struct {
+	name    string
+	fail    bool
+	noClose bool
+	closed  *[]string
+}
+
+func (r *recordingTransform) Name() string { return r.name }
+
+func (r *recordingTransform) Apply(reader io.Reader) (io.Reader, io.Closer, error) {
+	if r.fail {
+		return nil, nil, errors.New(r.name + " failed")
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := bytes.NewReader(append(bytes.ToUpper(data), []byte("+"+r.name)...))
+	if r.noClose {
+		return out, nil, nil
+	}
+	return out, closerFunc(func() error {
+		*r.closed = append(*r.closed, r.name)
+		return nil
+	}), nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// TestWritePipeline_Closers tests the closers returned by write pipelines made of
+// 0, 1 and 2 transforms, including transforms with nothing to close.
+func TestWritePipeline_Closers(t *testing.T) {
+	tests := []struct {
+		name       string
+		steps      func(closed *[]string) []transform.WriterTransform
+		output     string
+		nilCloser  bool
+		closeOrder []string
+	}{
+		{
+			name:      "no transforms",
+			steps:     func(closed *[]string) []transform.WriterTransform { return nil },
+			output:    "data",
+			nilCloser: true,
+		},
+		{
+			name: "one transform",
+			steps: func(closed *[]string) []transform.WriterTransform {
+				return []transform.WriterTransform{&recordingTransform{name: "a", closed: closed}}
+			},
+			output:     "DATA+a",
+			closeOrder: []string{"a"},
+		},
+		{
+			name: "one transform without closer",
+			steps: func(closed *[]string) []transform.WriterTransform {
+				return []transform.WriterTransform{&recordingTransform{name: "a", noClose: true, closed: closed}}
+			},
+			output:    "DATA+a",
+			nilCloser: true,
+		},
+		{
+			name: "two transforms",
+			steps: func(closed *[]string) []transform.WriterTransform {
+				return []transform.WriterTransform{
+					&recordingTransform{name: "a", closed: closed},
+					&recordingTransform{name: "b", closed: closed},
+				}
+			},
+			output:     "DATA+A+b",
+			closeOrder: []string{"b", "a"},
+		},
+		{
+			name: "two transforms, one without closer",
+			steps: func(closed *[]string) []transform.WriterTransform {
+				return []transform.WriterTransform{
+					&recordingTransform{name: "a", noClose: true, closed: closed},
+					&recordingTransform{name: "b", closed: closed},
+				}
+			},
+			output:     "DATA+A+b",
+			closeOrder: []string{"b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var closed []string
+			out, closer, err := transform.NewWritePipeline(tt.steps(&closed)...).Apply(strings.NewReader("data"))
+			require.NoError(t, err)
+
+			data, err := io.ReadAll(out)
+			require.NoError(t, err)
+			assert.Equal(t, tt.output, string(data))
+
+			if tt.nilCloser {
+				assert.Nil(t, closer, "no closer should be returned when there is nothing to close")
+				return
+			}
+			require.NotNil(t, closer)
+			assert.NoError(t, closer.Close())
+			assert.Equal(t, tt.closeOrder, closed)
+		})
+	}
+}
+
+// TestWritePipeline_ErrorMidPipeline tests that a failing step closes the closers
+// of the previous steps in reverse order, without panicking.
+func TestWritePipeline_ErrorMidPipeline(t *testing.T) {
+	var closed []string
+	pipe := transform.NewWritePipeline(
+		&recordingTransform{name: "a", closed: &closed},
+		&recordingTransform{name: "b", noClose: true, closed: &closed},
+		&recordingTransform{name: "c", closed: &closed},
+		&recordingTransform{name: "d", fail: true, closed: &closed},
+		&recordingTransform{name: "e", closed: &closed},
+	)
+
+	assert.NotPanics(t, func() {
+		out, closer, err := pipe.Apply(strings.NewReader("data"))
+		assert.EqualError(t, err, "d failed")
+		assert.Nil(t, out)
+		assert.Nil(t, closer)
+	})
+	assert.Equal(t, []string{"c", "a"}, closed)
+}
+
+// TestWritePipeline_BuiltinTransforms tests that the built-in compression and encryption
+// transforms return a closer releasing their pooled buffers and can be read back by the
+// read pipeline.
+func TestWritePipeline_BuiltinTransforms(t *testing.T) {
+	const key = "m2cs"
+
+	out, closer, err := transform.NewWritePipeline(
+		&compression.GzipCompress{},
+		&encryption.AESGCMEncrypt{Key: key},
+	).Apply(strings.NewReader("payload"))
+	require.NoError(t, err)
+	require.NotNil(t, closer)
+	defer closer.Close()
+
+	rc, err := transform.NewReadPipeline(
+		&encryption.AESGCMDecrypt{Key: key},
+		&compression.GzipDecompress{},
+	).Apply(io.NopCloser(out))
+	require.NoError(t, err)
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(data))
+}
+
+// TestAESGCMDecrypt_ReadAfterClose tests that the reader of AESGCMDecrypt fails after Close,
+// instead of reading the pooled buffer that another Apply may be using.
+func TestAESGCMDecrypt_ReadAfterClose(t *testing.T) {
+	const key = "m2cs"
+
+	out, closer, err := (&encryption.AESGCMEncrypt{Key: key}).Apply(strings.NewReader("payload"))
+	require.NoError(t, err)
+	defer closer.Close()
+
+	rc, err := encryption.AESGCMDecrypt{Key: key}.Apply(io.NopCloser(out))
+	require.NoError(t, err)
+
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(rc, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pay", string(buf))
+
+	require.NoError(t, rc.Close())
+	require.NoError(t, rc.Close())
+	_, err = rc.Read(buf)
+	assert.ErrorIs(t, err, fs.ErrClosed)
+}
+
+// TestGzipDecompress_ConcurrentReads checks that the pooled gzip readers are never shared:
+// concurrent reads, some closed early, twice or on invalid data, each read back their own
+// payload, and a read after Close fails instead of using a reader returned to the pool.
+func TestGzipDecompress_ConcurrentReads(t *testing.T) {
+	const (
+		readers = 16
+		reads   = 200
+	)
+
+	payloads := make([][]byte, readers)
+	stored := make([][]byte, readers)
+	for i := range payloads {
+		payloads[i] = bytes.Repeat([]byte(fmt.Sprintf("reader %d ", i)), 100+i*50)
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(payloads[i])
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		stored[i] = buf.Bytes()
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for i := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range reads {
+				if err := decompressOnce(stored[i], payloads[i], n); err != nil {
+					errs <- fmt.Errorf("reader %d, read %d: %w", i, n, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// decompressOnce reads stored back with GzipDecompress, varying with n how it is read and closed.
+func decompressOnce(stored, payload []byte, n int) error {
+	if n%7 == 0 {
+		// invalid data fails without handing out a reader
+		if _, err := (compression.GzipDecompress{}).Apply(io.NopCloser(strings.NewReader("not gzip"))); err == nil {
+			return errors.New("invalid data decompressed")
+		}
+	}
+
+	rc, err := compression.GzipDecompress{}.Apply(io.NopCloser(bytes.NewReader(stored)))
+	if err != nil {
+		return err
+	}
+	if n%5 == 0 {
+		// closed early, while the reader is not drained
+		buf := make([]byte, 16)
+		if _, err := io.ReadFull(rc, buf); err != nil {
+			return err
+		}
+		if !bytes.Equal(buf, payload[:16]) {
+			return fmt.Errorf("read %q, want %q", buf, payload[:16])
+		}
+		return rc.Close()
+	}
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, payload) {
+		return fmt.Errorf("read %d bytes not matching the %d written", len(data), len(payload))
+	}
+	if err := rc.Close(); err != nil {
+		return err
+	}
+	if err := rc.Close(); err != nil {
+		return fmt.Errorf("second Close: %w", err)
+	}
+	if _, err := rc.Read(make([]byte, 1)); !errors.Is(err, fs.ErrClosed) {
+		return fmt.Errorf("Read after Close: got %v, want fs.ErrClosed", err)
+	}
+	return nil
+}
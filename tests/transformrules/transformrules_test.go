@@ -0,0 +1,240 @@
+package transformrules_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+// rules skip the compression of the JPEG images, and compress everything else.
+var rules = []m2cs.TransformRule{
+	{KeyGlob: "*.jpg", Compress: m2cs.NO_COMPRESSION},
+	{KeyGlob: "*.json", Compress: m2cs.GZIP_COMPRESSION},
+}
+
+func connect(t *testing.T, s *storagetest.MinioServer, opts m2cs.ConnectionOptions) *filestorage.MinioClient {
+	opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+	opts.IsMainInstance, opts.ProbeBox, opts.Region = true, "box", "us-east-1"
+	client, err := m2cs.NewMinIOConnection(s.URL, opts, nil)
+	require.NoError(t, err)
+	return client
+}
+
+func get(t *testing.T, client filestorage.FileStorage, fileName string) []byte {
+	reader, err := client.GetObject(context.Background(), "box", fileName)
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return data
+}
+
+func isGzip(data []byte) bool {
+	_, err := gzip.NewReader(bytes.NewReader(data))
+	return err == nil
+}
+
+func TestTransformRules(t *testing.T) {
+	s := storagetest.NewMinioServer(t)
+	client := connect(t, s, m2cs.ConnectionOptions{SaveCompress: m2cs.GZIP_COMPRESSION, TransformRules: rules})
+
+	jpg := make([]byte, 4096)
+	_, _ = rand.Read(jpg)
+	json := []byte(strings.Repeat(`{"key": "value"}`, 256))
+	require.NoError(t, client.PutObject(context.Background(), "box", "photos/cat.jpg", bytes.NewReader(jpg)))
+	require.NoError(t, client.PutObject(context.Background(), "box", "data.json", bytes.NewReader(json)))
+	notes := strings.Repeat("notes ", 64)
+	require.NoError(t, client.PutObject(context.Background(), "box", "notes.txt", strings.NewReader(notes)))
+
+	stored := s.Object("box/photos/cat.jpg")
+	assert.Equal(t, jpg, stored.Data, "*.jpg is saved as is")
+	assert.Equal(t, "NO_COMPRESSION,NO_ENCRYPTION", stored.Header.Get("X-Amz-Meta-M2cstransform"))
+	stored = s.Object("box/data.json")
+	assert.True(t, isGzip(stored.Data), "*.json is compressed")
+	assert.Less(t, len(stored.Data), len(json))
+	assert.Equal(t, "GZIP_COMPRESSION,NO_ENCRYPTION", stored.Header.Get("X-Amz-Meta-M2cstransform"))
+	assert.True(t, isGzip(s.Object("box/notes.txt").Data), "the other objects are compressed by default")
+
+	assert.Equal(t, jpg, get(t, client, "photos/cat.jpg"))
+	assert.Equal(t, json, get(t, client, "data.json"))
+	assert.Equal(t, notes, string(get(t, client, "notes.txt")))
+
+	// the objects are read with the transforms of their metadata, whatever the rules of the reader
+	plain := connect(t, s, m2cs.ConnectionOptions{})
+	assert.Equal(t, jpg, get(t, plain, "photos/cat.jpg"))
+	assert.Equal(t, json, get(t, plain, "data.json"))
+	compressed := connect(t, s, m2cs.ConnectionOptions{SaveCompress: m2cs.GZIP_COMPRESSION})
+	assert.Equal(t, jpg, get(t, compressed, "photos/cat.jpg"))
+
+	_, stat, err := client.GetObjectWithInfo(context.Background(), "box", "photos/cat.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(jpg)), stat.Size)
+}
+
+func TestTransformRules_Encryption(t *testing.T) {
+	s := storagetest.NewMinioServer(t)
+	opts := m2cs.ConnectionOptions{
+		EncryptKey: "fTq8-Lx2!vRz9#Kp",
+		TransformRules: []m2cs.TransformRule{
+			{ContentType: "application/pdf", Compress: m2cs.GZIP_COMPRESSION, Encrypt: m2cs.AES256_ENCRYPTION},
+		},
+	}
+	client := connect(t, s, opts)
+
+	put := func(fileName, contentType string) {
+		err := client.PutObjectWithOptions(context.Background(), "box", fileName, strings.NewReader("secret"),
+			filestorage.PutOptions{ContentType: contentType})
+		require.NoError(t, err)
+	}
+	put("invoice", "application/PDF; version=1.7")
+	put("readme", "text/plain")
+
+	assert.NotContains(t, string(s.Object("box/invoice").Data), "secret")
+	assert.Equal(t, "secret", string(s.Object("box/readme").Data))
+	assert.Equal(t, "secret", string(get(t, client, "invoice")))
+	assert.Equal(t, "secret", string(get(t, client, "readme")))
+
+	// the objects encrypted by a rule need the key
+	_, err := connect(t, s, m2cs.ConnectionOptions{}).GetObject(context.Background(), "box", "invoice")
+	assert.ErrorContains(t, err, "missing decryption key")
+}
+
+func TestEncryption_KeyDirections(t *testing.T) {
+	const key = "fTq8-Lx2!vRz9#Kp"
+	ctx := context.Background()
+	s := storagetest.NewMinioServer(t)
+	writer := connect(t, s, m2cs.ConnectionOptions{SaveEncrypt: m2cs.AES256_ENCRYPTION, SaveCompress: m2cs.GZIP_COMPRESSION,
+		EncryptKey: key, EncryptOnly: true})
+	reader := connect(t, s, m2cs.ConnectionOptions{SaveEncrypt: m2cs.AES256_ENCRYPTION, SaveCompress: m2cs.GZIP_COMPRESSION,
+		DecryptKeys: []string{"Hw3!qZ8#mN2$kR7v", key}})
+
+	require.NoError(t, writer.PutObject(ctx, "box", "report", strings.NewReader("secret")))
+	assert.NotContains(t, string(s.Object("box/report").Data), "secret")
+
+	// the read-only client reads the object with the second of its keys
+	assert.Equal(t, "secret", string(get(t, reader, "report")))
+	assert.True(t, reader.GetConnectionProperties().CanDecrypt())
+
+	// each client fails the direction it has no key for
+	_, err := writer.GetObject(ctx, "box", "report")
+	assert.ErrorIs(t, err, m2cs.ErrKeyUnavailable)
+	assert.False(t, writer.GetConnectionProperties().CanDecrypt())
+	err = reader.PutObject(ctx, "box", "report", strings.NewReader("forged"))
+	assert.ErrorIs(t, err, m2cs.ErrKeyUnavailable)
+	assert.Equal(t, "secret", string(get(t, reader, "report")))
+
+	// a FileClient reads from the storages able to decrypt first, whatever their labels
+	full := filestorage.NewMemoryClient(common.ConnectionProperties{Label: "b", IsMainInstance: true,
+		SaveEncrypt: common.AES256_ENCRYPTION, EncryptKey: key})
+	writeOnly := filestorage.NewMemoryClient(common.ConnectionProperties{Label: "a", IsMainInstance: true,
+		SaveEncrypt: common.AES256_ENCRYPTION, EncryptKey: key, EncryptOnly: true})
+	for _, memory := range []*filestorage.MemoryClient{full, writeOnly} {
+		require.NoError(t, memory.MakeBucket(ctx, "box"))
+	}
+	client := m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN, writeOnly, full)
+	assert.Equal(t, []string{"b", "a"}, client.Describe().ReadOrder)
+	require.NoError(t, client.PutObject(ctx, "box", "report", strings.NewReader("secret")))
+	for range 2 {
+		obj, err := client.GetObject(ctx, "box", "report")
+		require.NoError(t, err)
+		data, err := io.ReadAll(obj)
+		require.NoError(t, err)
+		assert.Equal(t, "secret", string(data))
+	}
+	_, err = m2cs.NewFileClient(m2cs.SYNC_REPLICATION, m2cs.ROUND_ROBIN, writeOnly).GetObject(ctx, "box", "report")
+	assert.ErrorIs(t, err, m2cs.ErrKeyUnavailable)
+}
+
+func TestEncryption_KeyDirectionsValidation(t *testing.T) {
+	s := storagetest.NewMinioServer(t)
+	validate := func(opts m2cs.ConnectionOptions) error {
+		opts.ConnectionMethod = m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")
+		opts.SaveEncrypt, opts.ProbeBox, opts.Region = m2cs.AES256_ENCRYPTION, "box", "us-east-1"
+		_, err := m2cs.NewMinIOConnection(s.URL, opts, nil)
+		return err
+	}
+
+	assert.NoError(t, validate(m2cs.ConnectionOptions{DecryptKeys: []string{"fTq8-Lx2!vRz9#Kp"}}))
+	assert.EqualError(t, validate(m2cs.ConnectionOptions{DecryptKeys: []string{"fTq8-Lx2!vRz9#Kp", ""}}),
+		"invalid MinIO connection: DecryptKeys cannot hold empty keys")
+	assert.ErrorIs(t, validate(m2cs.ConnectionOptions{DecryptKeys: []string{"m2cs"}}), m2cs.ErrWeakEncryptKey)
+	assert.EqualError(t, validate(m2cs.ConnectionOptions{EncryptOnly: true, DecryptKeys: []string{"fTq8-Lx2!vRz9#Kp"}}),
+		"invalid MinIO connection: EncryptOnly requires EncryptKey or EncryptKeyBytes")
+}
+
+func TestTransformRule_Matches(t *testing.T) {
+	for _, c := range []struct {
+		rule       common.TransformRule
+		key, ctype string
+		matches    bool
+	}{
+		{common.TransformRule{KeyGlob: "*.jpg"}, "cat.jpg", "", true},
+		{common.TransformRule{KeyGlob: "*.jpg"}, "photos/2024/cat.jpg", "", true},
+		{common.TransformRule{KeyGlob: "*.jpg"}, "cat.jpeg", "", false},
+		{common.TransformRule{KeyGlob: "photos/*.jpg"}, "photos/cat.jpg", "", true},
+		{common.TransformRule{KeyGlob: "photos/*.jpg"}, "videos/cat.jpg", "", false},
+		{common.TransformRule{ContentType: "image/*"}, "cat", "image/png", true},
+		{common.TransformRule{ContentType: "image/*"}, "cat", "IMAGE/PNG; q=1", true},
+		{common.TransformRule{ContentType: "image/*"}, "cat", "video/mp4", false},
+		{common.TransformRule{KeyGlob: "*.mp4", ContentType: "video/*"}, "cat.mp4", "image/png", false},
+		{common.TransformRule{}, "anything", "", true},
+	} {
+		assert.Equal(t, c.matches, c.rule.Matches(c.key, c.ctype), "%+v %q %q", c.rule, c.key, c.ctype)
+	}
+
+	// the first rule matching wins
+	props := common.ConnectionProperties{SaveCompress: common.GZIP_COMPRESSION, TransformRules: []common.TransformRule{
+		{KeyGlob: "*.mp4", Compress: common.NO_COMPRESSION},
+		{KeyGlob: "*", Compress: common.GZIP_CONTENT_ENCODING},
+	}}
+	assert.Equal(t, common.NO_COMPRESSION, props.ForObject("cat.mp4", "").SaveCompress)
+	assert.Equal(t, common.GZIP_CONTENT_ENCODING, props.ForObject("cat.txt", "").SaveCompress)
+	props.TransformRules = props.TransformRules[:1]
+	assert.Equal(t, common.GZIP_COMPRESSION, props.ForObject("cat.txt", "").SaveCompress)
+}
+
+func TestTransformRules_Ranges(t *testing.T) {
+	memory := filestorage.NewMemoryClient(common.ConnectionProperties{
+		TransformRules: []common.TransformRule{{KeyGlob: "*.json", Compress: common.GZIP_COMPRESSION}},
+	})
+	require.NoError(t, memory.MakeBucket(context.Background(), "box"))
+	require.NoError(t, memory.PutObject(context.Background(), "box", "data.json", strings.NewReader(`{"key": "value"}`)))
+
+	_, err := memory.GetObjectRange(context.Background(), "box", "data.json", 0, 4)
+	assert.ErrorIs(t, err, filestorage.ErrRangeNotSupported)
+	reader, err := memory.GetObject(context.Background(), "box", "data.json")
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, `{"key": "value"}`, string(data))
+}
+
+func TestTransformRules_Validation(t *testing.T) {
+	s := storagetest.NewMinioServer(t)
+	validate := func(rules ...m2cs.TransformRule) error {
+		opts := m2cs.ConnectionOptions{ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword"), TransformRules: rules}
+		_, err := m2cs.NewMinIOConnection(s.URL, opts, nil)
+		return err
+	}
+
+	assert.EqualError(t, validate(m2cs.TransformRule{KeyGlob: "[*.jpg"}),
+		`invalid MinIO connection: invalid transform rule 0: KeyGlob "[*.jpg": syntax error in pattern`)
+	assert.EqualError(t, validate(m2cs.TransformRule{}, m2cs.TransformRule{Compress: 7}),
+		"invalid MinIO connection: invalid transform rule 1: unsupported compression algorithm: CompressionAlgorithm(7)")
+	assert.EqualError(t, validate(m2cs.TransformRule{Encrypt: m2cs.AES256_ENCRYPTION}),
+		"invalid MinIO connection: invalid transform rule 0: EncryptKey, EncryptKeyBytes or DecryptKeys must be set with AES256_ENCRYPTION")
+	assert.EqualError(t, validate(m2cs.TransformRule{Compress: m2cs.GZIP_CONTENT_ENCODING, Encrypt: m2cs.AES256_ENCRYPTION}),
+		"invalid MinIO connection: invalid transform rule 0: GZIP_CONTENT_ENCODING cannot be combined with encryption")
+}
@@ -0,0 +1,192 @@
+package validate_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+)
+
+const strongKey = "kX9#pQ2vL7@wR4zT"
+
+var backends = []struct {
+	backend m2cs.BackendType
+	name    string
+	method  func() m2cs.ConnectionOptions
+}{
+	{m2cs.MINIO_BACKEND, "MinIO", func() m2cs.ConnectionOptions {
+		return m2cs.ConnectionOptions{ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")}
+	}},
+	{m2cs.S3_BACKEND, "AWS S3", func() m2cs.ConnectionOptions {
+		return m2cs.ConnectionOptions{ConnectionMethod: m2cs.ConnectWithCredentials("m2csUser", "m2csPassword")}
+	}},
+	{m2cs.AZBLOB_BACKEND, "Azure Blob", func() m2cs.ConnectionOptions {
+		return m2cs.ConnectionOptions{ConnectionMethod: m2cs.ConnectWithConnectionString("UseDevelopmentStorage=true")}
+	}},
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(o *m2cs.ConnectionOptions)
+		errs   []string // Messages of the joined errors, with %s the name of the backend
+	}{
+		{name: "valid", modify: func(o *m2cs.ConnectionOptions) {}},
+		{name: "valid encrypted", modify: func(o *m2cs.ConnectionOptions) {
+			o.SaveEncrypt, o.EncryptKey, o.SaveCompress = m2cs.AES256_ENCRYPTION, strongKey, m2cs.GZIP_COMPRESSION
+		}},
+		{name: "nil method", modify: func(o *m2cs.ConnectionOptions) { o.ConnectionMethod = nil },
+			errs: []string{"connectionMethod cannot be nil"}},
+		{name: "unknown compression", modify: func(o *m2cs.ConnectionOptions) { o.SaveCompress = m2cs.CompressionAlgorithm(7) },
+			errs: []string{"invalid %s connection: unsupported compression algorithm: CompressionAlgorithm(7)"}},
+		{name: "unknown encryption", modify: func(o *m2cs.ConnectionOptions) { o.SaveEncrypt = m2cs.EncryptionAlgorithm(5) },
+			errs: []string{"invalid %s connection: unsupported encryption algorithm: EncryptionAlgorithm(5)"}},
+		{name: "unknown role", modify: func(o *m2cs.ConnectionOptions) { o.Role = m2cs.StorageRole(9) },
+			errs: []string{"invalid %s connection: unknown storage role: StorageRole(9)"}},
+		{name: "content encoding encrypted", modify: func(o *m2cs.ConnectionOptions) {
+			o.SaveCompress, o.SaveEncrypt, o.EncryptKey = m2cs.GZIP_CONTENT_ENCODING, m2cs.AES256_ENCRYPTION, strongKey
+		}, errs: []string{"invalid %s connection: GZIP_CONTENT_ENCODING cannot be combined with encryption"}},
+		{name: "negative key length", modify: func(o *m2cs.ConnectionOptions) { o.MinEncryptKeyLength = -1 },
+			errs: []string{"invalid %s connection: MinEncryptKeyLength cannot be negative, got -1"}},
+		{name: "empty alias", modify: func(o *m2cs.ConnectionOptions) { o.BoxAliases = map[string]string{"logical": ""} },
+			errs: []string{`invalid %s connection: invalid store box alias "logical" -> "": names cannot be empty`}},
+		{name: "every issue", modify: func(o *m2cs.ConnectionOptions) {
+			o.ConnectionMethod = nil
+			o.SaveCompress = m2cs.CompressionAlgorithm(7)
+			o.SaveEncrypt = m2cs.AES256_ENCRYPTION
+			o.Role = m2cs.StorageRole(9)
+		}, errs: []string{
+			"connectionMethod cannot be nil",
+			"invalid %s connection: unsupported compression algorithm: CompressionAlgorithm(7)",
+			"invalid %s connection: EncryptKey, EncryptKeyBytes or DecryptKeys must be set with AES256_ENCRYPTION",
+			"invalid %s connection: unknown storage role: StorageRole(9)",
+		}},
+	}
+
+	for _, b := range backends {
+		for _, tt := range tests {
+			t.Run(b.name+"/"+tt.name, func(t *testing.T) {
+				opts := b.method()
+				tt.modify(&opts)
+
+				err := opts.Validate(b.backend)
+				if len(tt.errs) == 0 {
+					assert.NoError(t, err)
+					return
+				}
+				require.Error(t, err)
+				joined, ok := err.(interface{ Unwrap() []error })
+				require.True(t, ok, "Validate must join its errors")
+				var messages []string
+				for _, e := range joined.Unwrap() {
+					messages = append(messages, e.Error())
+				}
+				var expected []string
+				for _, e := range tt.errs {
+					expected = append(expected, strings.ReplaceAll(e, "%s", b.name))
+				}
+				assert.Equal(t, expected, messages)
+			})
+		}
+	}
+}
+
+func TestValidate_WeakKey(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			opts := b.method()
+			opts.SaveEncrypt, opts.EncryptKey = m2cs.AES256_ENCRYPTION, "m2cs"
+
+			err := opts.Validate(b.backend)
+			assert.ErrorIs(t, err, m2cs.ErrWeakEncryptKey)
+
+			opts.AllowWeakKeys = true
+			assert.NoError(t, opts.Validate(b.backend))
+		})
+	}
+}
+
+func TestValidate_Methods(t *testing.T) {
+	anonymous := m2cs.ConnectionOptions{ConnectionMethod: m2cs.ConnectWithAnonymousCredentials()}
+	assert.EqualError(t, anonymous.Validate(m2cs.MINIO_BACKEND),
+		"ProbeBox must be set with anonymous credentials, which cannot list the buckets")
+	assert.EqualError(t, anonymous.Validate(m2cs.AZBLOB_BACKEND),
+		"anonymous credentials are not supported for Azure Blob; use ConnectWithSASToken instead")
+	anonymous.ProbeBox = "box"
+	assert.NoError(t, anonymous.Validate(m2cs.S3_BACKEND))
+
+	connectionString := m2cs.ConnectionOptions{ConnectionMethod: m2cs.ConnectWithConnectionString("UseDevelopmentStorage=true")}
+	assert.EqualError(t, connectionString.Validate(m2cs.S3_BACKEND),
+		"invalid connection method for AWS S3; use: ConnectWithCredentials, ConnectWithEnvCredentials, "+
+			"ConnectWithDefaultCredentials or ConnectWithAnonymousCredentials")
+
+	sas := m2cs.ConnectionOptions{ConnectionMethod: m2cs.ConnectWithSASToken("https://m2cs.blob.core.windows.net", "sv=2022-11-02&sig=c2ln")}
+	assert.EqualError(t, sas.Validate(m2cs.AZBLOB_BACKEND),
+		"ProbeBox must be set with a SAS token, which may not allow listing the containers")
+
+	assert.EqualError(t, connectionString.Validate(m2cs.BackendType(4)), "unknown backend BackendType(4)")
+}
+
+// TestValidate_Constructors checks the constructors fail with every issue of Validate.
+func TestValidate_Constructors(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	methods := map[m2cs.BackendType]func() m2cs.ConnectionOptions{
+		m2cs.AZBLOB_BACKEND: func() m2cs.ConnectionOptions {
+			return m2cs.ConnectionOptions{ConnectionMethod: m2cs.ConnectWithConnectionString("DefaultEndpointsProtocol=http;" +
+				"AccountName=m2cs;AccountKey=bTJjcy1hY2NvdW50LWtleQ==;BlobEndpoint=" + srv.URL + "/m2cs;")}
+		},
+	}
+	connects := map[m2cs.BackendType]func(o m2cs.ConnectionOptions) error{
+		m2cs.MINIO_BACKEND: func(o m2cs.ConnectionOptions) error {
+			_, err := m2cs.NewMinIOConnection(srv.URL, o, nil)
+			return err
+		},
+		m2cs.S3_BACKEND: func(o m2cs.ConnectionOptions) error {
+			_, err := m2cs.NewS3Connection(srv.URL, o, "")
+			return err
+		},
+		m2cs.AZBLOB_BACKEND: func(o m2cs.ConnectionOptions) error {
+			_, err := m2cs.NewAzBlobConnection("", o)
+			return err
+		},
+	}
+
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			method := b.method
+			if m, ok := methods[b.backend]; ok {
+				method = m
+			}
+			opts := method()
+			opts.SaveCompress, opts.Role, opts.ProbeBox, opts.Region = m2cs.CompressionAlgorithm(7), m2cs.StorageRole(9), "box", "us-east-1"
+
+			err := connects[b.backend](opts)
+			require.Error(t, err)
+			assert.Equal(t, opts.Validate(b.backend).Error(), err.Error())
+			assert.Contains(t, err.Error(), "CompressionAlgorithm(7)")
+			assert.Contains(t, err.Error(), "StorageRole(9)")
+
+			opts.ConnectionMethod = m2cs.NewCredentialProfile("broken", opts.ConnectionMethod)
+			err = connects[b.backend](opts)
+			require.Error(t, err)
+			assert.True(t, strings.HasPrefix(err.Error(), "credential profile broken: "), err.Error())
+
+			// once valid, the connections are created
+			opts.SaveCompress, opts.Role = m2cs.NO_COMPRESSION, m2cs.NO_ROLE
+			assert.NoError(t, connects[b.backend](opts))
+		})
+	}
+	assert.NotZero(t, requests.Load())
+}
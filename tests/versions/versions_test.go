@@ -0,0 +1,124 @@
+package versions_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tizianocitro/m2cs"
+	common "github.com/tizianocitro/m2cs/pkg"
+	"github.com/tizianocitro/m2cs/pkg/filestorage"
+	"github.com/tizianocitro/m2cs/pkg/storagetest"
+)
+
+func content(t *testing.T, memory *filestorage.MemoryClient) string {
+	obj, err := memory.GetObject(context.Background(), "box", "key")
+	require.NoError(t, err)
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func put(client *m2cs.FileClient, data string, ifMatch m2cs.Version) (m2cs.PutReport, error) {
+	return client.PutObjectWithReport(context.Background(), "box", "key", strings.NewReader(data), m2cs.PutOptions{IfMatch: ifMatch})
+}
+
+func TestVersions_Report(t *testing.T) {
+	first, second := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "first", IsMainInstance: true, Role: common.NO_ROLE}, "box"), storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "second", IsMainInstance: true, Role: common.NO_ROLE}, "box")
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{first, second})
+	require.NoError(t, err)
+
+	report, err := put(client, "v1", "")
+	require.NoError(t, err)
+	require.Len(t, report.Storages, 2)
+	assert.ElementsMatch(t, []string{"first", "second"}, []string{report.Storages[0].Label, report.Storages[1].Label})
+
+	etags, err := report.Version.ETags()
+	require.NoError(t, err)
+	for i, write := range report.Storages {
+		stat, err := []*filestorage.MemoryClient{first, second}[i].StatObject(context.Background(), "box", "key")
+		require.NoError(t, err)
+		assert.Equal(t, stat.ETag, write.ETag)
+		assert.Equal(t, write.ETag, etags[write.Label])
+	}
+
+	_, err = m2cs.Version("not a version").ETags()
+	assert.ErrorContains(t, err, "invalid version")
+	_, err = put(client, "v2", "not a version")
+	assert.ErrorContains(t, err, "invalid version")
+}
+
+func TestVersions_ConditionalOverwrite(t *testing.T) {
+	first, second := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "first", IsMainInstance: true, Role: common.NO_ROLE}, "box"), storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "second", IsMainInstance: true, Role: common.NO_ROLE}, "box")
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{first, second})
+	require.NoError(t, err)
+
+	v1, err := put(client, "v1", "")
+	require.NoError(t, err)
+	v2, err := put(client, "v2", v1.Version)
+	require.NoError(t, err, "the version round-trips into a conditional overwrite")
+	assert.NotEqual(t, v1.Version, v2.Version)
+
+	// the first version is stale
+	_, err = put(client, "v3", v1.Version)
+	assert.ErrorIs(t, err, m2cs.ErrPreconditionFailed)
+
+	// an out-of-band change fails the condition of the storage it was applied to, while the
+	// other storages are still written
+	require.NoError(t, second.PutObject(context.Background(), "box", "key", strings.NewReader("changed")))
+	_, err = put(client, "v3", v2.Version)
+	assert.ErrorIs(t, err, m2cs.ErrPreconditionFailed)
+	assert.Equal(t, "changed", content(t, second))
+	assert.Equal(t, "v3", content(t, first))
+
+	// a removed object fails the condition
+	require.NoError(t, client.RemoveObject(context.Background(), "box", "key"))
+	_, err = put(client, "v4", v2.Version)
+	assert.ErrorIs(t, err, m2cs.ErrPreconditionFailed)
+}
+
+func TestVersions_Primary(t *testing.T) {
+	primary, secondary := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "primary", IsMainInstance: true, Role: common.PRIMARY}, "box"), storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "secondary", IsMainInstance: true, Role: common.NO_ROLE}, "box")
+	client, err := m2cs.NewFileClientWithOptions(m2cs.SYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{primary, secondary})
+	require.NoError(t, err)
+
+	v1, err := put(client, "v1", "")
+	require.NoError(t, err)
+
+	// the secondaries do not check the condition
+	require.NoError(t, secondary.PutObject(context.Background(), "box", "key", strings.NewReader("changed")))
+	v2, err := put(client, "v2", v1.Version)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", content(t, secondary))
+
+	// a change on the primary rejects the write before the secondaries are written
+	require.NoError(t, primary.PutObject(context.Background(), "box", "key", strings.NewReader("changed")))
+	_, err = put(client, "v3", v2.Version)
+	assert.ErrorIs(t, err, m2cs.ErrPreconditionFailed)
+	assert.NotErrorIs(t, err, m2cs.ErrPrimaryUnavailable)
+	assert.Equal(t, "v2", content(t, secondary))
+}
+
+func TestVersions_Async(t *testing.T) {
+	first, second := storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "first", IsMainInstance: true, Role: common.NO_ROLE}, "box"), storagetest.NewMemoryWithProperties(t, common.ConnectionProperties{Label: "second", IsMainInstance: true, Role: common.NO_ROLE}, "box")
+	client, err := m2cs.NewFileClientWithOptions(m2cs.ASYNC_REPLICATION, m2cs.READ_REPLICA_FIRST,
+		[]filestorage.FileStorage{first, second})
+	require.NoError(t, err)
+
+	v1, err := put(client, "v1", "")
+	require.NoError(t, err)
+	require.Len(t, v1.Storages, 1, "only the storage written before returning is reported")
+	assert.Equal(t, "first", v1.Storages[0].Label)
+
+	require.NoError(t, first.PutObject(context.Background(), "box", "key", strings.NewReader("changed")))
+	_, err = put(client, "v2", v1.Version)
+	assert.ErrorIs(t, err, m2cs.ErrPreconditionFailed, "the write does not fall back to the next storage")
+}